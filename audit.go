@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// auditRecord is one line of the audit log
+type auditRecord struct {
+	Time      time.Time  `json:"time"`
+	Interface string     `json:"interface"`
+	VRID      uint8      `json:"vrid"`
+	OldState  string     `json:"old_state"`
+	NewState  string     `json:"new_state"`
+	Cause     string     `json:"cause"`
+	Detail    string     `json:"detail,omitempty"`
+	Priority  uint8      `json:"priority"`
+	Peer      *auditPeer `json:"peer,omitempty"`
+}
+
+// auditPeer is the last advertisement heard from another router
+type auditPeer struct {
+	IP       net.IP    `json:"ip"`
+	Priority uint8     `json:"priority"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// auditRequest is a line of the audit log for an admin request that changes
+// the daemon's behavior, whether or not it was allowed
+type auditRequest struct {
+	Time      time.Time `json:"time"`
+	Command   string    `json:"command"`
+	Interface string    `json:"interface,omitempty"`
+	VRID      uint8     `json:"vrid,omitempty"`
+	// Params are the settings the request changes, e.g. "priority=150"
+	Params    []string `json:"params,omitempty"`
+	Transport string   `json:"transport"`
+	UID       *uint32  `json:"uid,omitempty"`
+	GID       *uint32  `json:"gid,omitempty"`
+	PID       int32    `json:"pid,omitempty"`
+	Addr      string   `json:"addr,omitempty"`
+	Cert      string   `json:"cert,omitempty"`
+	Token     string   `json:"token,omitempty"`
+	// Result is "ok", "denied" or "failed"
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// auditLog appends a JSON line for every state transition and every admin
+// request that changes the daemon's behavior to a file kept apart from the
+// daemon's log, for post-incident review. Each record is synced to disk
+// before the transition completes or the request is answered.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &auditLog{f: f}, nil
+}
+
+// record appends t for the instance on iface and vrid. Failures are logged:
+// an unwritable audit log does not stop the router.
+func (a *auditLog) record(iface string, vrid uint8, t vrrp.Transition) {
+	rec := auditRecord{
+		Time:      t.Time,
+		Interface: iface,
+		VRID:      vrid,
+		OldState:  t.Old.String(),
+		NewState:  t.New.String(),
+		Cause:     string(t.Cause),
+		Detail:    t.Detail,
+		Priority:  t.Priority,
+	}
+	if t.Peer.SourceIP != nil {
+		rec.Peer = &auditPeer{IP: t.Peer.SourceIP, Priority: t.Peer.Priority, LastSeen: t.Peer.LastSeen}
+	}
+	a.write(&rec)
+}
+
+// recordRequest appends an admin request from c and its outcome
+func (a *auditLog) recordRequest(c *control.Caller, req *control.Request, result string, err error) {
+	rec := auditRequest{
+		Time:      time.Now(),
+		Command:   req.Command,
+		Interface: req.Interface,
+		VRID:      req.VRID,
+		Params:    requestParams(req),
+		Transport: c.Transport,
+		Addr:      c.Addr,
+		Cert:      c.Cert,
+		Token:     c.Token,
+		Result:    result,
+	}
+	if c.Cred != nil {
+		rec.UID, rec.GID, rec.PID = &c.Cred.UID, &c.Cred.GID, c.Cred.PID
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	a.write(&rec)
+}
+
+func (a *auditLog) write(rec any) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		slog.Error("Failed to encode audit record", "err", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(data, '\n')); err != nil {
+		slog.Error("Failed to write audit log", "err", err)
+		return
+	}
+	if err := a.f.Sync(); err != nil {
+		slog.Error("Failed to sync audit log", "err", err)
+	}
+}
+
+// logRequest records an admin request that changes the daemon's behavior
+// in the daemon's log and the audit log, if any
+func (d *daemon) logRequest(c *control.Caller, req *control.Request, err error) {
+	result := "ok"
+	switch {
+	case control.IsDenied(err):
+		result = "denied"
+		slog.Warn("Admin request denied", "command", req.Command, "caller", c.String(), "err", err)
+	case err != nil:
+		result = "failed"
+		slog.Info("Admin request failed", "command", req.Command, "params", requestParams(req),
+			"caller", c.String(), "err", err)
+	default:
+		slog.Info("Admin request", "command", req.Command, "params", requestParams(req), "caller", c.String())
+	}
+	if d.audit != nil {
+		d.audit.recordRequest(c, req, result, err)
+	}
+}
+
+// requestParams lists the settings req changes, for the audit log and the
+// daemon's log
+func requestParams(req *control.Request) []string {
+	var params []string
+	if req.Priority != 0 {
+		params = append(params, fmt.Sprintf("priority=%d", req.Priority))
+	}
+	if req.AdvertInterval != 0 {
+		params = append(params, fmt.Sprintf("advert_interval=%d", req.AdvertInterval))
+	}
+	if req.Preempt != nil {
+		params = append(params, fmt.Sprintf("preempt=%t", *req.Preempt))
+	}
+	if req.Tracker != "" {
+		params = append(params, fmt.Sprintf("tracker=%s enabled=%t", req.Tracker, req.TrackerEnabled))
+	}
+	if req.HoldSeconds != 0 {
+		params = append(params, fmt.Sprintf("hold_seconds=%d", req.HoldSeconds))
+	}
+	if req.Peer != "" {
+		params = append(params, "peer="+req.Peer)
+	}
+	if req.Reset {
+		params = append(params, "reset=true")
+	}
+	return params
+}
+
+func (a *auditLog) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var (
+	benchCmd       = app.Command("bench", "Measure how many instances and how short an interval this machine sustains")
+	benchInstances = benchCmd.Flag("instances", "Instances to size for: timers run at once").Short('n').
+			Default("100").Int()
+	benchInterval = benchCmd.Flag("interval", "Advertisement interval to size for").Default("1s").Duration()
+	benchVIPs     = benchCmd.Flag("vips", "Virtual IPs per advertisement").Default("1").Int()
+	benchDuration = benchCmd.Flag("duration", "How long each packet benchmark runs").Default("1s").Duration()
+	benchTimers   = benchCmd.Flag("timer-duration", "How long the timers run").Default("5s").Duration()
+	benchOutput   = benchCmd.Flag("output", "Output format").Short('o').Default("text").Enum("text", "json")
+)
+
+// benchOp is a packet benchmark in the JSON output of bench
+type benchOp struct {
+	Ops       int     `json:"ops"`
+	NsPerOp   int64   `json:"ns_per_op"`
+	PerSecond float64 `json:"per_second"`
+}
+
+// benchReport is the JSON output of bench
+type benchReport struct {
+	VIPs      int              `json:"vips"`
+	Marshal   benchOp          `json:"marshal"`
+	Unmarshal benchOp          `json:"unmarshal"`
+	Checksum  benchOp          `json:"checksum"`
+	Timers    benchTimerResult `json:"timers"`
+	Capacity  benchCapacity    `json:"capacity"`
+}
+
+type benchTimerResult struct {
+	Instances   int    `json:"instances"`
+	Interval    string `json:"interval"`
+	Expirations int    `json:"expirations"`
+	Missed      int    `json:"missed"`
+	P50Ns       int64  `json:"lateness_p50_ns"`
+	P99Ns       int64  `json:"lateness_p99_ns"`
+	MaxNs       int64  `json:"lateness_max_ns"`
+}
+
+type benchCapacity struct {
+	// AdvertsPerSecond is sent and received at the interval, CPUFraction the
+	// share of one core marshaling and parsing them takes
+	AdvertsPerSecond float64 `json:"adverts_per_second"`
+	CPUFraction      float64 `json:"cpu_fraction"`
+	// InOrder is whether the p99 lateness is below the skew time between
+	// priorities one apart, OnTime whether the maximum is below the interval
+	InOrder bool `json:"in_order"`
+	OnTime  bool `json:"on_time"`
+}
+
+func newBenchOp(r vrrp.BenchResult) benchOp {
+	return benchOp{Ops: r.Ops, NsPerOp: r.PerOp().Nanoseconds(), PerSecond: r.PerSecond()}
+}
+
+// runBench measures the packet code and the timer scheduler of the daemon
+// on this machine and tells whether it keeps up with --instances at
+// --interval. It leaves out the network and the system calls.
+func runBench() {
+	if *benchInstances < 1 {
+		app.Fatalf("--instances must be at least 1")
+	}
+	if *benchInterval <= 0 || *benchDuration <= 0 {
+		app.Fatalf("--interval and --duration must be positive")
+	}
+	if *benchTimers < 2**benchInterval {
+		app.Fatalf("--timer-duration must cover at least two intervals")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	text := *benchOutput == "text"
+	if text {
+		fmt.Printf("Packets: VRRPv2 with %d VIP(s), %s each\n", *benchVIPs, *benchDuration)
+	}
+	var ops [3]vrrp.BenchResult
+	for i, b := range []struct {
+		name string
+		fn   func(time.Duration, int) (vrrp.BenchResult, error)
+	}{
+		{"marshal", vrrp.BenchMarshal},
+		{"unmarshal", vrrp.BenchUnmarshal},
+		{"checksum", vrrp.BenchChecksum},
+	} {
+		r, err := b.fn(*benchDuration, *benchVIPs)
+		if err != nil {
+			exitWithError(withExitCode(exitUsage, err))
+		}
+		ops[i] = r
+		if text {
+			fmt.Printf("  %-10s %12s/s %10s/op\n", b.name, formatRate(r.PerSecond()), r.PerOp())
+		}
+	}
+
+	if text {
+		fmt.Printf("\nTimers: %d instances every %s for %s\n", *benchInstances, *benchInterval, *benchTimers)
+	}
+	tr, err := vrrp.BenchTimers(ctx, *benchInstances, *benchInterval, *benchTimers)
+	if err != nil {
+		exitWithError(withExitCode(exitUsage, err))
+	}
+	if ctx.Err() != nil {
+		exitWithError(ctx.Err())
+	}
+
+	// Each instance sends or receives one advertisement per interval
+	rate := float64(*benchInstances) / benchInterval.Seconds()
+	capacity := benchCapacity{
+		AdvertsPerSecond: rate,
+		CPUFraction:      rate * (ops[0].PerOp() + ops[1].PerOp()).Seconds(),
+		// Backups one priority apart expire their master down timers
+		// interval/256 apart
+		InOrder: tr.P99 < *benchInterval/256,
+		OnTime:  tr.Max < *benchInterval,
+	}
+
+	if !text {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(benchReport{
+			VIPs:      *benchVIPs,
+			Marshal:   newBenchOp(ops[0]),
+			Unmarshal: newBenchOp(ops[1]),
+			Checksum:  newBenchOp(ops[2]),
+			Timers: benchTimerResult{
+				Instances:   tr.Timers,
+				Interval:    tr.Period.String(),
+				Expirations: tr.Expirations,
+				Missed:      tr.Missed,
+				P50Ns:       tr.P50.Nanoseconds(),
+				P99Ns:       tr.P99.Nanoseconds(),
+				MaxNs:       tr.Max.Nanoseconds(),
+			},
+			Capacity: capacity,
+		})
+		return
+	}
+
+	fmt.Printf("  %-10s %d, %d missed\n", "expired", tr.Expirations, tr.Missed)
+	fmt.Printf("  %-10s p50 %s, p99 %s, max %s\n", "lateness", tr.P50, tr.P99, tr.Max)
+
+	fmt.Printf("\nCapacity: %s advertisements/s sent and received take %.3f%% of a core in the packet code\n",
+		formatRate(rate), capacity.CPUFraction*100)
+	skew := *benchInterval / 256
+	if capacity.InOrder {
+		fmt.Printf("  ok: p99 lateness is below interval/256 (%s): backups one priority apart take over in order\n",
+			skew)
+	} else {
+		fmt.Printf("  warning: p99 lateness is above interval/256 (%s): backups close in priority may take over "+
+			"out of order; run fewer instances or a longer interval\n", skew)
+	}
+	if capacity.OnTime {
+		fmt.Printf("  ok: no expiration was an interval late\n")
+	} else {
+		fmt.Printf("  warning: an expiration was an interval or more late (%d missed): backups may take over "+
+			"from a live master\n", tr.Missed)
+	}
+}
+
+// formatRate prints a rate with a metric suffix
+func formatRate(r float64) string {
+	switch {
+	case r >= 1e6:
+		return fmt.Sprintf("%.2fM", r/1e6)
+	case r >= 1e3:
+		return fmt.Sprintf("%.1fk", r/1e3)
+	}
+	return fmt.Sprintf("%.0f", r)
+}
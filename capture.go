@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/pcap"
+)
+
+// packetCapture writes the VRRP messages every instance sends and receives to
+// a pcap file, for offline analysis with tcpdump, Wireshark or vrrp replay
+type packetCapture struct {
+	f      *os.File
+	w      *pcap.Writer
+	failed atomic.Bool
+}
+
+// openPacketCapture creates the capture file, replacing an existing one
+func openPacketCapture(path string) (*packetCapture, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	w, err := pcap.NewWriter(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &packetCapture{f: f, w: w}, nil
+}
+
+// write appends a message. The first failure is logged: an unwritable
+// capture does not stop the router.
+func (c *packetCapture) write(t time.Time, header *ipv4.Header, payload []byte) {
+	if err := c.w.WriteIPv4(t, header, payload); err != nil && !c.failed.Swap(true) {
+		slog.Error("Failed to write packet capture", "err", err)
+	}
+}
+
+func (c *packetCapture) close() error {
+	return c.f.Close()
+}
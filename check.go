@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/config"
+)
+
+var (
+	checkCmd    = app.Command("check", "Validate a configuration file")
+	checkConfig = checkCmd.Flag("config", "Configuration file to validate").Short('c').Required().String()
+	checkNoHost = checkCmd.Flag("skip-interfaces",
+		"Do not check that interfaces exist on this host (for CI)").Bool()
+)
+
+func checkConfigFile() {
+	f, err := config.Load(*checkConfig)
+	if err != nil {
+		exitWithError(withExitCode(exitConfig, err))
+	}
+
+	errs := f.Validate()
+	if !*checkNoHost {
+		errs = append(errs, checkInterfaces(f)...)
+	}
+
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", *checkConfig, err)
+		}
+		exitWithError(withExitCode(exitConfig, fmt.Errorf("%d problem(s) found", len(errs))))
+	}
+
+	fmt.Printf("%s: OK (%d instances)\n", *checkConfig, len(f.Instances))
+}
+
+// checkInterfaces verifies that every interface exists and has the IPv4
+// address advertisements are sent from
+func checkInterfaces(f *config.File) []error {
+	var errs []error
+	for i := range f.Instances {
+		in := &f.Instances[i]
+		if in.Interface == "" {
+			continue
+		}
+
+		iface, err := net.InterfaceByName(in.Interface)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): interface %s not found on this host",
+				in.Where(i), in.Key(), in.Interface))
+			continue
+		}
+
+		if !hasIPv4(iface) {
+			errs = append(errs, fmt.Errorf("%s (%s): interface %s has no IPv4 address",
+				in.Where(i), in.Key(), in.Interface))
+		}
+	}
+	return errs
+}
+
+func hasIPv4(iface *net.Interface) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return true
+		}
+	}
+	return false
+}
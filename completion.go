@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	completionCmd   = app.Command("completion", "Print a shell completion script")
+	completionShell = completionCmd.Arg("shell", "Shell to generate the script for").
+			Required().Enum("bash", "zsh", "fish")
+)
+
+// fishCompletionTemplate asks the binary for candidates through kingpin's
+// --completion-bash flag, like the bash and zsh scripts do
+const fishCompletionTemplate = `function __{{.App.Name}}_complete
+    set -l tokens (commandline -opc)
+    {{.App.Name}} --completion-bash $tokens[2..-1] (commandline -ct)
+end
+complete -c {{.App.Name}} -f -a '(__{{.App.Name}}_complete)'
+complete -c {{.App.Name}} -l config -l from -r -F
+`
+
+func printCompletion() {
+	tmpl := kingpin.BashCompletionTemplate
+	switch *completionShell {
+	case "zsh":
+		tmpl = kingpin.ZshCompletionTemplate
+	case "fish":
+		tmpl = fishCompletionTemplate
+	}
+
+	ctx, err := app.ParseContext(nil)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	app.UsageWriter(os.Stdout)
+	if err := app.UsageForContextWithTemplate(ctx, 2, tmpl); err != nil {
+		exitWithError(fmt.Errorf("failed to generate completion script: %w", err))
+	}
+}
+
+// interfaceNames completes --interface flags with the host's interfaces
+func interfaceNames() []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(ifaces))
+	for _, iface := range ifaces {
+		names = append(names, iface.Name)
+	}
+	return names
+}
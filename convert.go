@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/config"
+)
+
+var (
+	convertCmd    = app.Command("convert", "Convert a keepalived.conf into a native configuration file")
+	convertFrom   = convertCmd.Flag("from", "keepalived.conf to convert").Required().ExistingFile()
+	convertOutput = convertCmd.Flag("output", "Write the configuration to this file instead of stdout").
+			Short('o').String()
+)
+
+func convertConfig() {
+	f, warnings, err := config.LoadKeepalived(*convertFrom)
+	if err != nil {
+		exitWithError(withExitCode(exitConfig, err))
+	}
+
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		exitWithError(err)
+	}
+	data = append(data, '\n')
+
+	if *convertOutput == "" {
+		_, _ = os.Stdout.Write(data)
+		return
+	}
+
+	if err := os.WriteFile(*convertOutput, data, 0o644); err != nil {
+		exitWithError(err)
+	}
+}
@@ -0,0 +1,763 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/config"
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+	"github.com/tokuhirom/vrrp-simple/pkg/metrics"
+	"github.com/tokuhirom/vrrp-simple/pkg/nomad"
+	"github.com/tokuhirom/vrrp-simple/pkg/route53"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// failoverWait bounds how long a failover or handoff request waits for a
+// backup to take over; it stays below the control client's timeout
+const failoverWait = 3 * time.Second
+
+// instance is one virtual router managed by the daemon
+type instance struct {
+	cfg    config.Instance
+	router *vrrp.VirtualRouter
+	lock   *instanceLock
+
+	// route53 points cfg.Route53 at this host on becoming MASTER; nil
+	// without one or in a dry run. A reload may replace it.
+	route53 atomic.Pointer[route53.Updater]
+
+	onStateChange []func(old, new vrrp.State)
+}
+
+// daemon owns the running instances and serves control requests for them
+type daemon struct {
+	mu         sync.Mutex
+	ctx        context.Context
+	configPath string
+	lockDir    string
+	dryRun     bool
+	manager    *vrrp.Manager
+	// metrics is nil in low-footprint mode without --metrics-listen or
+	// --metrics-textfile-dir
+	metrics *metrics.Prometheus
+	// statsd is nil without --statsd-addr
+	statsd *metrics.StatsD
+	// zabbix is nil without --zabbix-server
+	zabbix *metrics.Zabbix
+	// sink receives the events of every instance: metrics, statsd, zabbix
+	// or several of them
+	sink      vrrp.Metrics
+	instances []*instance
+	ctrl      *control.Server
+
+	// lowFootprint shortens the instances' queues (--low-footprint)
+	lowFootprint bool
+
+	// audit records every transition if --audit-log is set; it is set
+	// before the instances start
+	audit *auditLog
+	// capture writes every advertisement if --pcap is set; likewise set
+	// before the instances start
+	capture *packetCapture
+	// nomad publishes the instances this host is MASTER of if --nomad-addr
+	// is set; likewise set before the instances start
+	nomad *nomad.Publisher
+	// textfile writes metrics for node_exporter if --metrics-textfile-dir
+	// is set; likewise set before the instances start
+	textfile *textfileExport
+
+	// handover is set while taking over from a previous daemon
+	handover *handover
+}
+
+// newDaemon creates the instances of cfgs. h is the handover from the
+// daemon being replaced by an upgrade, or nil.
+func newDaemon(configPath string, cfgs []config.Instance, dryRun, lowFootprint bool, h *handover) (*daemon, error) {
+	d := &daemon{
+		configPath:   configPath,
+		dryRun:       dryRun,
+		lowFootprint: lowFootprint,
+		manager:      vrrp.NewManager(nil),
+		ctrl:         control.NewServer(*socketPath),
+		handover:     h,
+	}
+	if !lowFootprint || *runMetricsListen != "" || *runMetricsTextfileDir != "" {
+		d.metrics = metrics.NewPrometheus()
+	}
+	if *runStatsDAddr != "" {
+		scfg := &metrics.StatsDConfig{
+			Address:  *runStatsDAddr,
+			Prefix:   *runStatsDPrefix,
+			Tagged:   *runStatsDFormat == "dogstatsd",
+			Interval: *runStatsDInterval,
+		}
+		for _, tag := range strings.Split(*runStatsDTags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				scfg.Tags = append(scfg.Tags, tag)
+			}
+		}
+		statsd, err := metrics.NewStatsD(scfg)
+		if err != nil {
+			return nil, err
+		}
+		d.statsd = statsd
+	}
+	if *runZabbixServer != "" {
+		zabbix, err := metrics.NewZabbix(&metrics.ZabbixConfig{
+			Server:   *runZabbixServer,
+			Host:     *runZabbixHost,
+			Prefix:   *runZabbixPrefix,
+			Interval: *runZabbixInterval,
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.zabbix = zabbix
+	}
+	var sinks metrics.Multi
+	if d.metrics != nil {
+		sinks = append(sinks, d.metrics)
+	}
+	if d.statsd != nil {
+		sinks = append(sinks, d.statsd)
+	}
+	if d.zabbix != nil {
+		sinks = append(sinks, d.zabbix)
+	}
+	switch len(sinks) {
+	case 0:
+	case 1:
+		d.sink = sinks[0]
+	default:
+		d.sink = sinks
+	}
+	if h != nil {
+		h.inherit(d.manager)
+	}
+
+	for i := range cfgs {
+		inst, err := d.newInstance(&cfgs[i])
+		if err != nil {
+			return nil, err
+		}
+		d.instances = append(d.instances, inst)
+	}
+
+	d.registerHandlers()
+	d.ctrl.SetAuditor(d.logRequest)
+	return d, nil
+}
+
+// vrrpConfig is the router configuration for an instance
+func (d *daemon) vrrpConfig(cfg *config.Instance) *vrrp.Config {
+	vcfg := cfg.VRRPConfig()
+	vcfg.DryRun = d.dryRun
+	if d.sink != nil {
+		vcfg.Metrics = d.sink
+	}
+	if d.lowFootprint {
+		vcfg.QueueLength = lowFootprintQueueLength
+	}
+	vcfg.KernelFilter = *runKernelFilter
+	vcfg.MaxAdvertRate = *runMaxAdvertRate
+	if vcfg.MaxAdvertRate == 0 {
+		vcfg.MaxAdvertRate = -1
+	}
+	vcfg.AuthReplayWindow = *runAuthReplayWindow
+	if vcfg.AuthReplayWindow == 0 {
+		vcfg.AuthReplayWindow = -1
+	}
+	vcfg.Membership = vrrp.Membership{Check: *runMulticastCheck, Refresh: *runMulticastRefresh}
+	if vcfg.Membership.Check == 0 {
+		vcfg.Membership.Check = -1
+	}
+	vcfg.Capture = d
+	if cfg.SyncGroup != "" {
+		vcfg.SyncGroup = d.manager.SyncGroup(cfg.SyncGroup)
+	}
+	if hi := d.handover.instance(cfg.Interface, cfg.VRID); hi != nil {
+		vcfg.ResumeMaster = hi.Master
+	}
+	return vcfg
+}
+
+// CapturePacket writes a message sent or received by an instance to the
+// --pcap file, if there is one
+func (d *daemon) CapturePacket(t time.Time, header *ipv4.Header, payload []byte) {
+	if d.capture != nil {
+		d.capture.write(t, header, payload)
+	}
+}
+
+func (d *daemon) newInstance(cfg *config.Instance) (*instance, error) {
+	router, err := d.manager.Add(d.vrrpConfig(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("instance %s: %w", cfg.Key(), err)
+	}
+
+	inst := &instance{cfg: *cfg, router: router}
+	if err := d.setRoute53(inst, cfg.Route53); err != nil {
+		_ = d.manager.Remove(cfg.Interface, cfg.VRID)
+		return nil, fmt.Errorf("instance %s: %w", cfg.Key(), err)
+	}
+	router.SetStateChangeCallback(func(old, new vrrp.State) {
+		d.ctrl.Publish(control.StateEvent{
+			Interface: inst.cfg.Interface,
+			VRID:      inst.cfg.VRID,
+			OldState:  old.String(),
+			NewState:  new.String(),
+			Time:      time.Now(),
+		})
+		if d.nomad != nil {
+			d.nomad.SetState(inst.cfg.Interface, inst.cfg.VRID, new == vrrp.Master)
+		}
+		if d.textfile != nil {
+			d.textfile.changed()
+		}
+		if u := inst.route53.Load(); u != nil {
+			u.SetMaster(new == vrrp.Master)
+		}
+		for _, fn := range inst.onStateChange {
+			fn(old, new)
+		}
+	})
+	router.SetTransitionCallback(func(t vrrp.Transition) {
+		if d.audit != nil {
+			d.audit.record(inst.cfg.Interface, inst.cfg.VRID, t)
+		}
+	})
+	// Only a MASTER detects a split brain
+	router.SetSplitBrainCallback(func(sb vrrp.SplitBrain) {
+		detail := fmt.Sprintf("%s at priority %d is also MASTER", sb.Peer, sb.PeerPriority)
+		if sb.Kind == vrrp.SplitBrainARP {
+			detail = fmt.Sprintf("%s answered for %s", sb.PeerMAC, sb.Peer)
+		}
+		d.ctrl.Publish(control.StateEvent{
+			Interface: inst.cfg.Interface,
+			VRID:      inst.cfg.VRID,
+			OldState:  vrrp.Master.String(),
+			NewState:  vrrp.Master.String(),
+			Time:      sb.Time,
+			Event:     control.EventSplitBrain,
+			Detail:    detail,
+		})
+	})
+	router.SetMasterLostCallback(func() {
+		d.ctrl.Publish(control.StateEvent{
+			Interface: inst.cfg.Interface,
+			VRID:      inst.cfg.VRID,
+			OldState:  vrrp.Backup.String(),
+			NewState:  vrrp.Backup.String(),
+			Time:      time.Now(),
+			Event:     control.EventMasterLost,
+			Detail:    "no master, and the instance is backup-only",
+		})
+	})
+
+	return inst, nil
+}
+
+// setRoute53 replaces the Route 53 updater of inst with one for record, or
+// none if record is nil, and starts it at once if inst is MASTER
+func (d *daemon) setRoute53(inst *instance, record *route53.Record) error {
+	var u *route53.Updater
+	if record != nil && !d.dryRun {
+		var err error
+		if u, err = route53.NewUpdater(&route53.Config{Record: *record}); err != nil {
+			return err
+		}
+	}
+	if old := inst.route53.Swap(u); old != nil {
+		old.Close()
+	}
+	if u != nil && inst.router.GetState() == vrrp.Master {
+		u.SetMaster(true)
+	}
+	return nil
+}
+
+// start locks and starts every instance, and those added later by a reload,
+// until ctx is canceled. The locks in lockDir keep another daemon on this host
+// from running the same instances; all of them are taken before any router
+// starts.
+func (d *daemon) start(ctx context.Context, lockDir string) error {
+	d.ctx = ctx
+	d.lockDir = lockDir
+
+	for _, inst := range d.instances {
+		lock, err := d.lock(inst)
+		if err != nil {
+			return err
+		}
+		inst.lock = lock
+	}
+
+	return d.manager.Start(ctx)
+}
+
+// lock takes the instance lock, or the one inherited from a previous daemon
+func (d *daemon) lock(inst *instance) (*instanceLock, error) {
+	if hi := d.handover.instance(inst.cfg.Interface, inst.cfg.VRID); hi != nil && hi.Lock != 0 {
+		if f := d.handover.take(hi.Lock); f != nil {
+			return inheritLock(f), nil
+		}
+	}
+	return lockInstance(d.lockDir, inst.cfg.Interface, inst.cfg.VRID)
+}
+
+// stop stops every router, a MASTER handing over with a priority 0
+// advertisement, and releases the instance locks. Routers still tearing down
+// after timeout are left to finish on their own.
+func (d *daemon) stop(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := d.manager.Stop(ctx); err != nil {
+		slog.Error("Failed to stop instances", "err", err)
+	}
+	for _, inst := range d.instances {
+		inst.unlock()
+		_ = d.setRoute53(inst, nil)
+	}
+}
+
+func (inst *instance) unlock() {
+	if inst.lock != nil {
+		inst.lock.release()
+		inst.lock = nil
+	}
+}
+
+// add locks and starts a new instance while the daemon is running. d.mu must
+// be held.
+func (d *daemon) add(cfg *config.Instance) error {
+	lock, err := lockInstance(d.lockDir, cfg.Interface, cfg.VRID)
+	if err != nil {
+		return err
+	}
+
+	inst, err := d.newInstance(cfg)
+	if err != nil {
+		lock.release()
+		return err
+	}
+	inst.lock = lock
+
+	if err := inst.router.Start(d.ctx); err != nil {
+		_ = d.manager.Remove(cfg.Interface, cfg.VRID)
+		lock.release()
+		return err
+	}
+
+	d.instances = append(d.instances, inst)
+	return nil
+}
+
+// remove stops an instance and forgets it. d.mu must be held.
+func (d *daemon) remove(inst *instance) {
+	if err := d.manager.Remove(inst.cfg.Interface, inst.cfg.VRID); err != nil {
+		slog.Error("Failed to stop instance", "instance", inst.cfg.Key(), "err", err)
+	}
+	if d.metrics != nil {
+		d.metrics.Forget(inst.cfg.Interface, inst.cfg.VRID)
+	}
+	if d.statsd != nil {
+		d.statsd.Forget(inst.cfg.Interface, inst.cfg.VRID)
+	}
+	if d.zabbix != nil {
+		d.zabbix.Forget(inst.cfg.Interface, inst.cfg.VRID)
+	}
+	inst.unlock()
+	_ = d.setRoute53(inst, nil)
+	for i, candidate := range d.instances {
+		if candidate == inst {
+			d.instances = append(d.instances[:i], d.instances[i+1:]...)
+			break
+		}
+	}
+}
+
+// matching returns the instances selected by req's filters
+func (d *daemon) matching(req *control.Request) []*instance {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []*instance
+	for _, inst := range d.instances {
+		if req.Matches(inst.cfg.Interface, inst.cfg.VRID) {
+			out = append(out, inst)
+		}
+	}
+	return out
+}
+
+func (d *daemon) registerHandlers() {
+	d.ctrl.Handle(control.CommandStatus, func(req *control.Request) (*control.Response, error) {
+		resp := &control.Response{}
+		for _, inst := range d.matching(req) {
+			st := inst.router.Status()
+			is := control.NewInstanceStatus(&st)
+			d.mu.Lock()
+			if p := inst.cfg.Pair; p != nil {
+				is.Pair = &control.PairStatus{Name: p.Name, Master: p.Master, Backup: p.Backup, PartnerVRID: p.PartnerVRID}
+			}
+			d.mu.Unlock()
+			resp.Instances = append(resp.Instances, is)
+		}
+		return resp, nil
+	})
+
+	d.ctrl.Handle(control.CommandStats, func(req *control.Request) (*control.Response, error) {
+		resp := &control.Response{}
+		for _, inst := range d.matching(req) {
+			var s vrrp.Stats
+			if req.Reset {
+				s = inst.router.ResetStats()
+			} else {
+				s = inst.router.GetStats()
+			}
+			resp.Stats = append(resp.Stats, control.NewInstanceStats(&s))
+		}
+		return resp, nil
+	})
+
+	d.ctrl.Handle(control.CommandSetPriority, func(req *control.Request) (*control.Response, error) {
+		insts := d.matching(req)
+		if len(insts) == 0 {
+			return nil, fmt.Errorf("no matching instance")
+		}
+
+		var msgs []string
+		for _, inst := range insts {
+			old := inst.router.GetPriority()
+			if err := inst.router.SetPriority(req.Priority); err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, fmt.Sprintf("%s: priority changed from %d to %d", inst.cfg.Key(), old, req.Priority))
+		}
+		return &control.Response{Message: strings.Join(msgs, "\n")}, nil
+	})
+
+	d.ctrl.Handle(control.CommandSet, func(req *control.Request) (*control.Response, error) {
+		if req.Priority == 0 && req.AdvertInterval == 0 && req.Preempt == nil && req.Tracker == "" {
+			return nil, fmt.Errorf("nothing to set")
+		}
+
+		insts := d.matching(req)
+		if len(insts) == 0 {
+			return nil, fmt.Errorf("no matching instance")
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		var changes []string
+		for _, inst := range insts {
+			cfg := inst.cfg
+			if req.Priority != 0 {
+				cfg.Priority = req.Priority
+			}
+			if req.AdvertInterval != 0 {
+				cfg.AdvertInterval = req.AdvertInterval
+			}
+			if req.Preempt != nil {
+				cfg.Preempt = req.Preempt
+			}
+
+			applied, err := d.apply(inst, &cfg)
+			changes = append(changes, applied...)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", inst.cfg.Key(), err)
+			}
+
+			if req.Tracker != "" {
+				if err := inst.router.SetTrackerEnabled(req.Tracker, req.TrackerEnabled); err != nil {
+					return nil, fmt.Errorf("%s: %w", inst.cfg.Key(), err)
+				}
+				state := "disabled"
+				if req.TrackerEnabled {
+					state = "enabled"
+				}
+				changes = append(changes, fmt.Sprintf("%s: tracker %s %s", inst.cfg.Key(), req.Tracker, state))
+			}
+		}
+
+		if len(changes) == 0 {
+			changes = append(changes, "no changes")
+		}
+		return &control.Response{Message: strings.Join(changes, "\n")}, nil
+	})
+
+	d.ctrl.Handle(control.CommandFailover, func(req *control.Request) (*control.Response, error) {
+		hold := control.DefaultFailoverHold
+		if req.HoldSeconds > 0 {
+			hold = time.Duration(req.HoldSeconds) * time.Second
+		}
+
+		var masters []*instance
+		for _, inst := range d.matching(req) {
+			if inst.router.GetState() == vrrp.Master {
+				masters = append(masters, inst)
+			}
+		}
+		if len(masters) == 0 {
+			return nil, fmt.Errorf("no matching instance is MASTER")
+		}
+
+		// The instances step down together and their backups are waited
+		// for until one deadline, so that the wait stays below the client's
+		// timeout
+		since := time.Now()
+		for _, inst := range masters {
+			if err := inst.router.Failover(hold); err != nil {
+				return nil, err
+			}
+		}
+		deadline := since.Add(failoverWait)
+		var msgs []string
+		for _, inst := range masters {
+			msgs = append(msgs, fmt.Sprintf("%s: %s, not preempting for %s",
+				inst.cfg.Key(), waitForTakeover(inst.router, since, deadline), hold))
+		}
+		return &control.Response{Message: strings.Join(msgs, "\n")}, nil
+	})
+
+	d.ctrl.Handle(control.CommandHandoff, func(req *control.Request) (*control.Response, error) {
+		hold := control.DefaultFailoverHold
+		if req.HoldSeconds > 0 {
+			hold = time.Duration(req.HoldSeconds) * time.Second
+		}
+		var to net.IP
+		if req.Peer != "" {
+			if to = net.ParseIP(req.Peer); to == nil {
+				return nil, fmt.Errorf("invalid peer address %q", req.Peer)
+			}
+		}
+
+		var masters []*instance
+		for _, inst := range d.matching(req) {
+			if inst.router.GetState() == vrrp.Master {
+				masters = append(masters, inst)
+			}
+		}
+		if len(masters) == 0 {
+			return nil, fmt.Errorf("no matching instance is MASTER")
+		}
+
+		// The instances hand off together so that the wait stays below the
+		// client's timeout
+		ctx, cancel := context.WithTimeout(context.Background(), failoverWait)
+		defer cancel()
+		msgs := make([]string, len(masters))
+		errs := make([]error, len(masters))
+		var wg sync.WaitGroup
+		for i, inst := range masters {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				master, err := inst.router.Handoff(ctx, to, hold)
+				if err != nil {
+					errs[i] = fmt.Errorf("%s: %w", inst.cfg.Key(), err)
+					return
+				}
+				msgs[i] = fmt.Sprintf("%s: handed off to %s, not preempting for %s", inst.cfg.Key(), master, hold)
+			}()
+		}
+		wg.Wait()
+		msgs = slices.DeleteFunc(msgs, func(m string) bool { return m == "" })
+		if err := errors.Join(errs...); err != nil && len(msgs) > 0 {
+			// Report the instances that handed off too
+			return nil, fmt.Errorf("%s\n%w", strings.Join(msgs, "\n"), err)
+		} else if err != nil {
+			return nil, err
+		}
+		return &control.Response{Message: strings.Join(msgs, "\n")}, nil
+	})
+
+	d.ctrl.Handle(control.CommandReload, func(*control.Request) (*control.Response, error) {
+		changes, err := d.reload()
+		if err != nil {
+			// Report what was applied before the failure too
+			return nil, fmt.Errorf("%s\n%w", strings.Join(changes, "\n"), err)
+		}
+		return &control.Response{Message: strings.Join(changes, "\n")}, nil
+	})
+}
+
+// reload re-reads the configuration file and applies changed parameters to the
+// running instances. Mastership is kept: priorities and intervals are updated in
+// place and a master reprograms only the VIPs that were added or removed.
+// Instances removed from the file are stopped, handing over to a backup, before
+// new ones are started, so a VIP can move between instances in one reload.
+// Other instances are not disturbed.
+func (d *daemon) reload() ([]string, error) {
+	if d.configPath == "" {
+		return nil, fmt.Errorf("nothing to reload: daemon was started without --config")
+	}
+
+	f, err := config.Load(d.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := errors.Join(f.Validate()...); err != nil {
+		return nil, fmt.Errorf("invalid configuration, nothing applied:\n%w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var changes []string
+	seen := make(map[string]bool)
+	for i := range f.Instances {
+		seen[f.Instances[i].Key()] = true
+	}
+
+	for _, inst := range append([]*instance(nil), d.instances...) {
+		if !seen[inst.cfg.Key()] {
+			d.remove(inst)
+			changes = append(changes, fmt.Sprintf("%s: removed, instance stopped", inst.cfg.Key()))
+		}
+	}
+
+	var errs []error
+	for i := range f.Instances {
+		cfg := &f.Instances[i]
+
+		inst := d.find(cfg.Key())
+		if inst == nil {
+			if err := d.add(cfg); err != nil {
+				errs = append(errs, fmt.Errorf("%s: failed to start new instance: %w", cfg.Key(), err))
+				continue
+			}
+			changes = append(changes, fmt.Sprintf("%s: added, instance started", cfg.Key()))
+			continue
+		}
+
+		applied, err := d.apply(inst, cfg)
+		changes = append(changes, applied...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cfg.Key(), err))
+		}
+	}
+
+	if len(changes) == 0 {
+		changes = append(changes, "no changes")
+	}
+
+	for _, c := range changes {
+		slog.Info("Reload", "change", c)
+	}
+
+	return changes, errors.Join(errs...)
+}
+
+func (d *daemon) find(key string) *instance {
+	for _, inst := range d.instances {
+		if inst.cfg.Key() == key {
+			return inst
+		}
+	}
+	return nil
+}
+
+// apply updates the running router to match cfg, returning what changed
+func (d *daemon) apply(inst *instance, cfg *config.Instance) ([]string, error) {
+	if cfg.SyncGroup != inst.cfg.SyncGroup {
+		return nil, fmt.Errorf("sync_group cannot be changed by a reload; remove the instance and add it again")
+	}
+
+	applied, err := inst.router.UpdateConfig(d.vrrpConfig(cfg))
+
+	changes := make([]string, len(applied))
+	for i, c := range applied {
+		changes[i] = fmt.Sprintf("%s: %s", cfg.Key(), c)
+		switch c.Field {
+		case vrrp.FieldPriority:
+			inst.cfg.Priority = cfg.Priority
+		case vrrp.FieldAdvertInterval:
+			inst.cfg.AdvertInterval = cfg.AdvertInterval
+		case vrrp.FieldPreempt:
+			inst.cfg.Preempt = cfg.Preempt
+		case vrrp.FieldPreemptWindows:
+			inst.cfg.PreemptWindows = cfg.PreemptWindows
+		case vrrp.FieldVirtualIPs:
+			inst.cfg.VirtualIPs = cfg.VirtualIPs
+		case vrrp.FieldExcludedIPs:
+			inst.cfg.ExcludedIPs = cfg.ExcludedIPs
+		case vrrp.FieldOnLinkCheck:
+			inst.cfg.OnLinkCheck = cfg.OnLinkCheck
+		case vrrp.FieldIntervalCheck:
+			inst.cfg.IntervalCheck = cfg.IntervalCheck
+		case vrrp.FieldVersionPolicy:
+			inst.cfg.VersionPolicy = cfg.VersionPolicy
+		case vrrp.FieldAddressCheck:
+			inst.cfg.AddressCheck = cfg.AddressCheck
+		case vrrp.FieldGARP:
+			inst.cfg.GARP = cfg.GARP
+		case vrrp.FieldAllowedPeers:
+			inst.cfg.AllowedPeers = cfg.AllowedPeers
+		case vrrp.FieldAuthKeys:
+			inst.cfg.AuthKeys = cfg.AuthKeys
+		case vrrp.FieldTrackers:
+			inst.cfg.Trackers = cfg.Trackers
+		case vrrp.FieldBackupOnly:
+			inst.cfg.BackupOnly = cfg.BackupOnly
+		}
+	}
+	// A pair can be renamed, or its nodes swapped, without the router
+	// noticing
+	inst.cfg.Pair = cfg.Pair
+
+	if !sameRoute53(cfg.Route53, inst.cfg.Route53) {
+		if rerr := d.setRoute53(inst, cfg.Route53); rerr != nil {
+			return changes, errors.Join(err, rerr)
+		}
+		changes = append(changes, fmt.Sprintf("%s: route53 %s -> %s",
+			cfg.Key(), formatRoute53(inst.cfg.Route53), formatRoute53(cfg.Route53)))
+		inst.cfg.Route53 = cfg.Route53
+	}
+	return changes, err
+}
+
+func sameRoute53(a, b *route53.Record) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// formatRoute53 describes a record without its credentials
+func formatRoute53(r *route53.Record) string {
+	if r == nil {
+		return "none"
+	}
+	return "[" + r.String() + "]"
+}
+
+// waitForTakeover waits until deadline for a backup to advertise after a
+// step-down at since and describes the outcome. It looks at least once, even
+// past the deadline.
+func waitForTakeover(router *vrrp.VirtualRouter, since, deadline time.Time) string {
+	for {
+		st := router.Status()
+		if st.Peer.LastSeen.After(since) && st.Peer.Priority > 0 {
+			return fmt.Sprintf("stepped down, %s took over", st.Peer.SourceIP)
+		}
+		if !time.Now().Before(deadline) {
+			return "stepped down, no backup has taken over yet"
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/config"
+)
+
+func TestReloadAppliesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vrrp.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"instances": [{"interface": "eth0", "vrid": 10, "priority": 100, "advert_interval": 1,
+		"virtual_ips": ["192.168.1.100"]}]}`)
+	f, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	d, err := newDaemon(path, f.Instances, true, false, nil)
+	if err != nil {
+		t.Fatalf("newDaemon: %v", err)
+	}
+
+	write(`{"instances": [{"interface": "eth0", "vrid": 10, "priority": 150, "advert_interval": 3,
+		"virtual_ips": ["192.168.1.100", "192.168.1.101"]}]}`)
+	changes, err := d.reload()
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	want := []string{
+		"eth0/10: priority 100 -> 150",
+		"eth0/10: advert interval 1s -> 3s",
+		"eth0/10: virtual IPs [192.168.1.100] -> [192.168.1.100, 192.168.1.101]",
+	}
+	if !slices.Equal(changes, want) {
+		t.Errorf("reload changed %q, want %q", changes, want)
+	}
+
+	inst := d.find("eth0/10")
+	st := inst.router.Status()
+	var vips []string
+	for _, ip := range st.VirtualIPs {
+		vips = append(vips, ip.String())
+	}
+	if st.Priority != 150 || inst.router.GetAdvertInterval() != 3 ||
+		!slices.Equal(vips, []string{"192.168.1.100", "192.168.1.101"}) {
+		t.Errorf("router has priority %d, interval %ds and VIPs %v after the reload",
+			st.Priority, inst.router.GetAdvertInterval(), vips)
+	}
+	if inst.cfg.Priority != 150 || inst.cfg.AdvertInterval != 3 || len(inst.cfg.VirtualIPs) != 2 {
+		t.Errorf("instance configuration not updated: %+v", inst.cfg)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+// startDebugServer serves net/http/pprof and expvar on addr, which must be a
+// loopback address. The handlers go on a dedicated mux so they are never
+// reachable through the admin APIs.
+func startDebugServer(addr string, d *daemon) (*http.Server, error) {
+	if err := checkLoopback("debug", addr); err != nil {
+		return nil, withExitCode(exitUsage, err)
+	}
+
+	publishDebugVars(d)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Debug server failed", "err", err)
+		}
+	}()
+	return srv, nil
+}
+
+// checkLoopback rejects listen addresses of the what server reachable from
+// other hosts, such as the profiling endpoints, which have no authentication
+func checkLoopback(what, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s listen address %q: %w", what, addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s listen address %q is not a loopback address", what, addr)
+}
+
+// debugInstance is the expvar form of one instance
+type debugInstance struct {
+	State           string `json:"state"`
+	Priority        uint8  `json:"priority"`
+	AdvertsSent     uint64 `json:"adverts_sent"`
+	AdvertsReceived uint64 `json:"adverts_received"`
+	PacketsDropped  uint64 `json:"packets_dropped"`
+	SendQueue       int    `json:"send_queue"`
+	RecvQueue       int    `json:"recv_queue"`
+	EventQueue      int    `json:"event_queue"`
+}
+
+// debugVarsOnce publishes the variables once: expvar panics on a second
+// Publish of a name, and the server restarts after a failed upgrade
+var debugVarsOnce sync.Once
+
+func publishDebugVars(d *daemon) {
+	debugVarsOnce.Do(func() { publishDebugVarsOnce(d) })
+}
+
+func publishDebugVarsOnce(d *daemon) {
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("instances", expvar.Func(func() any {
+		out := make(map[string]debugInstance)
+		for _, inst := range d.matching(&control.Request{}) {
+			st := inst.router.Status()
+			q := inst.router.QueueLengths()
+			out[inst.cfg.Key()] = debugInstance{
+				State:           st.State.String(),
+				Priority:        st.Priority,
+				AdvertsSent:     st.AdvertsSent,
+				AdvertsReceived: st.AdvertsReceived,
+				PacketsDropped:  st.PacketsDropped,
+				SendQueue:       q.Send,
+				RecvQueue:       q.Recv,
+				EventQueue:      q.Event,
+			}
+		}
+		return out
+	}))
+}
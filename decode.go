@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/pcap"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var (
+	decodeCmd = app.Command("decode", "Decode VRRP packets given as hex, a binary file or a pcap, with RFC notes")
+	decodeHex = decodeCmd.Flag("hex",
+		"Packet in hex, a VRRP message or an IPv4 packet (separators and 0x are ignored)").Short('x').Strings()
+	decodeFiles = decodeCmd.Arg("file",
+		"Pcap, binary packet or hex dump, one packet per line; standard input without --hex or files").
+		ExistingFiles()
+	decodeOutput = decodeCmd.Flag("output", "Output format").Short('o').Default("text").Enum("text", "json")
+)
+
+// decodedPacket is one packet as printed by vrrp decode
+type decodedPacket struct {
+	// Source is where the packet comes from: "hex", a file, or a file and
+	// the number of its packet or line
+	Source string     `json:"source"`
+	Time   *time.Time `json:"time,omitempty"`
+	IP     *struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		TTL         int    `json:"ttl"`
+		Protocol    int    `json:"protocol"`
+	} `json:"ip,omitempty"`
+	Version     uint8    `json:"version"`
+	Type        uint8    `json:"type"`
+	VRID        uint8    `json:"vrid"`
+	Priority    uint8    `json:"priority"`
+	CountIPs    uint8    `json:"count_ip_addrs"`
+	AuthType    uint8    `json:"auth_type"`
+	AdvInterval uint16   `json:"advert_interval"`
+	Interval    string   `json:"interval"`
+	Checksum    string   `json:"checksum"`
+	VirtualIPs  []string `json:"virtual_ips"`
+	AuthData    string   `json:"auth_data,omitempty"`
+	// ChecksumStatus is "ok", "bad" or "unverified"
+	ChecksumStatus string       `json:"checksum_status"`
+	Notes          []decodeNote `json:"notes"`
+	Error          string       `json:"error,omitempty"`
+}
+
+type decodeNote struct {
+	Level string `json:"level"`
+	Text  string `json:"text"`
+}
+
+// decodeInput is a packet to decode, with or without its IPv4 header
+type decodeInput struct {
+	source string
+	time   time.Time
+	data   []byte
+}
+
+// decodePackets prints every packet given, decoded field by field, for
+// comparing the advertisements of other implementations with ours
+func decodePackets() {
+	var inputs []decodeInput
+	for i, h := range *decodeHex {
+		data, err := parseHex(h)
+		if err != nil {
+			app.Fatalf("--hex: %v", err)
+		}
+		source := "hex"
+		if len(*decodeHex) > 1 {
+			source = fmt.Sprintf("hex %d", i+1)
+		}
+		inputs = append(inputs, decodeInput{source: source, data: data})
+	}
+	for _, path := range *decodeFiles {
+		f, err := os.Open(path)
+		if err != nil {
+			exitWithError(err)
+		}
+		in, err := readDecodeInputs(path, f)
+		_ = f.Close()
+		if err != nil {
+			exitWithError(fmt.Errorf("%s: %w", path, err))
+		}
+		inputs = append(inputs, in...)
+	}
+	if len(*decodeHex) == 0 && len(*decodeFiles) == 0 {
+		in, err := readDecodeInputs("stdin", os.Stdin)
+		if err != nil {
+			exitWithError(err)
+		}
+		inputs = in
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for i, in := range inputs {
+		d := decodePacket(in)
+		if *decodeOutput == "json" {
+			_ = enc.Encode(d)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printDecoded(&d)
+	}
+}
+
+// readDecodeInputs tells a pcap, a hex dump and a binary packet apart
+func readDecodeInputs(name string, r io.Reader) ([]decodeInput, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no packet given")
+	}
+
+	if rd, err := pcap.NewReader(bytes.NewReader(data)); err == nil {
+		var inputs []decodeInput
+		for n := 1; ; n++ {
+			p, err := rd.Next()
+			if err == io.EOF {
+				return inputs, nil
+			}
+			if err != nil {
+				return inputs, err
+			}
+			inputs = append(inputs, decodeInput{source: fmt.Sprintf("%s #%d", name, n), time: p.Time, data: p.Data})
+		}
+	}
+
+	if !isHexDump(data) {
+		return []decodeInput{{source: name, data: data}}, nil
+	}
+	var inputs []decodeInput
+	for n, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		b, err := parseHex(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		inputs = append(inputs, decodeInput{source: fmt.Sprintf("%s:%d", name, n+1), data: b})
+	}
+	return inputs, nil
+}
+
+// isHexDump reports whether data is text parseHex may read
+func isHexDump(data []byte) bool {
+	for _, c := range data {
+		if !strings.ContainsRune("0123456789abcdefABCDEFxX:-. \t\r\n", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHex reads bytes written in hex, ignoring separators and 0x prefixes
+func parseHex(s string) ([]byte, error) {
+	s = strings.NewReplacer("0x", "", "0X", "", ":", "", "-", "", ".", "", " ", "", "\t", "", "\r", "").Replace(s)
+	if s == "" {
+		return nil, errors.New("no bytes")
+	}
+	return hex.DecodeString(s)
+}
+
+// decodePacket decodes in, which starts with an IPv4 header if its first
+// nibble is 4: that of a VRRP message is its version, 2 or 3
+func decodePacket(in decodeInput) decodedPacket {
+	d := decodedPacket{Source: in.source, VirtualIPs: []string{}, Notes: []decodeNote{}, ChecksumStatus: "unverified"}
+	if !in.time.IsZero() {
+		d.Time = &in.time
+	}
+
+	payload := in.data
+	var header *ipv4.Header
+	if len(payload) > 0 && payload[0]>>4 == ipv4.Version {
+		p := &pcap.Packet{Data: in.data}
+		h, body, err := p.IPv4()
+		if err != nil {
+			d.Error = err.Error()
+			return d
+		}
+		header, payload = h, body
+		d.IP = &struct {
+			Source      string `json:"source"`
+			Destination string `json:"destination"`
+			TTL         int    `json:"ttl"`
+			Protocol    int    `json:"protocol"`
+		}{h.Src.String(), h.Dst.String(), h.TTL, h.Protocol}
+	}
+
+	pkt := &vrrp.Packet{}
+	if err := pkt.Unmarshal(payload); err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	d.Version = pkt.Version
+	d.Type = pkt.Type
+	d.VRID = pkt.VRID
+	d.Priority = pkt.Priority
+	d.CountIPs = pkt.CountIPAddrs
+	d.AuthType = pkt.AuthType
+	d.AdvInterval = pkt.AdvInterval
+	d.Interval = pkt.Interval().String()
+	d.Checksum = fmt.Sprintf("%#04x", pkt.Checksum)
+	for _, ip := range pkt.IPAddresses {
+		d.VirtualIPs = append(d.VirtualIPs, ip.String())
+	}
+	if pkt.AuthData != nil {
+		d.AuthData = hex.EncodeToString(pkt.AuthData)
+	}
+
+	if header != nil {
+		d.ChecksumStatus = "bad"
+		if pkt.VerifyChecksumIPv4(payload, header.Src, header.Dst) {
+			d.ChecksumStatus = "ok"
+		}
+	} else if valid, ok := pkt.VerifyChecksum(payload); ok {
+		d.ChecksumStatus = "bad"
+		if valid {
+			d.ChecksumStatus = "ok"
+		}
+	}
+
+	for _, n := range vrrp.CheckCompliance(header, payload, pkt) {
+		d.Notes = append(d.Notes, decodeNote{Level: string(n.Level), Text: n.Text})
+	}
+	return d
+}
+
+func printDecoded(d *decodedPacket) {
+	title := d.Source
+	if d.Time != nil {
+		title += " at " + d.Time.Format("2006-01-02 15:04:05.000000")
+	}
+	fmt.Println(title)
+	if d.IP != nil {
+		fmt.Printf("  IPv4       %s > %s ttl %d protocol %d\n", d.IP.Source, d.IP.Destination, d.IP.TTL, d.IP.Protocol)
+	}
+	if d.Error != "" {
+		fmt.Printf("  malformed: %s\n", d.Error)
+		return
+	}
+
+	typ := "advertisement"
+	if d.Type != vrrp.TypeAdvertisement {
+		typ = "unknown"
+	}
+	fmt.Printf("  Version    %d\n", d.Version)
+	fmt.Printf("  Type       %d (%s)\n", d.Type, typ)
+	fmt.Printf("  VRID       %d\n", d.VRID)
+	fmt.Printf("  Priority   %d\n", d.Priority)
+	fmt.Printf("  Addresses  %d: %s\n", d.CountIPs, vipList(d.VirtualIPs))
+	// VRRPv3 intervals are in centiseconds
+	unit := "s"
+	if d.Version == vrrp.VRRPv3 {
+		unit = "cs"
+	}
+	fmt.Printf("  Interval   %d%s (%s)\n", d.AdvInterval, unit, d.Interval)
+	if d.Version == vrrp.VRRPv2 {
+		fmt.Printf("  Auth       type %d data %s\n", d.AuthType, orDash(d.AuthData))
+	}
+	fmt.Printf("  Checksum   %s %s\n", d.Checksum, d.ChecksumStatus)
+	for _, n := range d.Notes {
+		fmt.Printf("  %s: %s\n", n.Level, n.Text)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// advertHex is a VRRPv2 advertisement of VRID 10 at priority 100 for
+// 192.168.1.100, with a valid checksum
+const advertHex = "210a64010001b8e6c0a801640000000000000000"
+
+func TestIsHexDump(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want bool
+	}{
+		{advertHex + "\n", true},
+		{"0x21 0x0a\r\n21:0a:64\n21-0a.64\t01\n", true},
+		{"21 0g", false},
+		{"\x21\x0a\x64\x01", false},
+	} {
+		if got := isHexDump([]byte(tt.data)); got != tt.want {
+			t.Errorf("isHexDump(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    []byte
+		wantErr bool
+	}{
+		{"210a64", []byte{0x21, 0x0a, 0x64}, false},
+		{"0x21 0X0a:64-01.00\t01\r", []byte{0x21, 0x0a, 0x64, 0x01, 0x00, 0x01}, false},
+		{"210", nil, true},
+		{"21zz", nil, true},
+		{" : ", nil, true},
+	} {
+		got, err := parseHex(tt.in)
+		if (err != nil) != tt.wantErr || err == nil && !bytes.Equal(got, tt.want) {
+			t.Errorf("parseHex(%q) = %x, %v, want %x and an error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReadDecodeInputs(t *testing.T) {
+	raw, _ := parseHex(advertHex)
+
+	in, err := readDecodeInputs("dump", strings.NewReader(advertHex+"\n\n"+strings.ToUpper(advertHex)+"\n"))
+	if err != nil {
+		t.Fatalf("hex dump: %v", err)
+	}
+	if len(in) != 2 || in[0].source != "dump:1" || in[1].source != "dump:3" ||
+		!bytes.Equal(in[0].data, raw) || !bytes.Equal(in[1].data, raw) {
+		t.Errorf("hex dump read as %+v", in)
+	}
+
+	in, err = readDecodeInputs("bin", bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("binary: %v", err)
+	}
+	if len(in) != 1 || in[0].source != "bin" || !bytes.Equal(in[0].data, raw) {
+		t.Errorf("binary packet read as %+v", in)
+	}
+
+	for data, want := range map[string]string{
+		advertHex + "\n210a6\n": "line 2: encoding/hex: odd length hex string",
+		"":                      "no packet given",
+	} {
+		if _, err := readDecodeInputs("dump", strings.NewReader(data)); err == nil || err.Error() != want {
+			t.Errorf("readDecodeInputs(%q) = %v, want %q", data, err, want)
+		}
+	}
+}
+
+func TestDecodePacket(t *testing.T) {
+	raw, _ := parseHex(advertHex)
+
+	d := decodePacket(decodeInput{source: "hex", data: raw})
+	if d.Error != "" {
+		t.Fatalf("decodePacket: %s", d.Error)
+	}
+	if d.Version != 2 || d.VRID != 10 || d.Priority != 100 || d.Interval != "1s" ||
+		!slices.Equal(d.VirtualIPs, []string{"192.168.1.100"}) || d.ChecksumStatus != "ok" || d.IP != nil {
+		t.Errorf("decoded as %+v", d)
+	}
+
+	d = decodePacket(decodeInput{source: "hex", data: raw[:10]})
+	if d.Error == "" {
+		t.Errorf("truncated packet decoded as %+v", d)
+	}
+}
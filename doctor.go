@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/config"
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+	"github.com/tokuhirom/vrrp-simple/pkg/doctor"
+)
+
+var (
+	doctorCmd    = app.Command("doctor", "Check the host for the common causes of VRRP not working")
+	doctorConfig = doctorCmd.Flag("config", "Configuration file whose instances to check").Short('c').
+			ExistingFile()
+	doctorInterface = doctorCmd.Flag("interface", "Network interface to check").Short('i').
+			HintAction(interfaceNames).String()
+	doctorVIPs   = doctorCmd.Flag("vips", "Virtual IP addresses to check (comma-separated)").Short('v').String()
+	doctorOutput = doctorCmd.Flag("output", "Output format").Short('o').Default("text").Enum("text", "json")
+)
+
+// firewallTimeout bounds listing the firewall rules
+const firewallTimeout = 5 * time.Second
+
+// doctorInstance is what doctor checks of an instance
+type doctorInstance struct {
+	iface string
+	vips  []string
+}
+
+// doctorFinding is a doctor.Finding in the JSON output
+type doctorFinding struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+// runDoctor checks the host for what keeps VRRP from working: the daemon's
+// capabilities, other VRRP daemons, firewall rules, and for the instances
+// of --config, of --interface and --vips, or else of the running daemon,
+// their interfaces and virtual IPs. It fails if any check does.
+func runDoctor() {
+	// The running daemon tells which addresses it holds as MASTER
+	var running []control.InstanceStatus
+	client := control.NewClient(*socketPath)
+	if resp, err := client.Do(&control.Request{Command: control.CommandStatus}); err == nil {
+		running = resp.Instances
+	}
+
+	var instances []doctorInstance
+	switch {
+	case *doctorConfig != "":
+		f, err := config.Load(*doctorConfig)
+		if err != nil {
+			exitWithError(withExitCode(exitConfig, err))
+		}
+		for _, in := range f.Instances {
+			instances = append(instances, doctorInstance{iface: in.Interface, vips: in.VirtualIPs})
+		}
+	case *doctorInterface != "":
+		var vips []string
+		if *doctorVIPs != "" {
+			vips = strings.Split(*doctorVIPs, ",")
+		}
+		instances = append(instances, doctorInstance{iface: *doctorInterface, vips: vips})
+	case *doctorVIPs != "":
+		app.Fatalf("--vips requires --interface")
+	default:
+		for _, is := range running {
+			instances = append(instances, doctorInstance{iface: is.Interface, vips: is.VirtualIPs})
+		}
+	}
+
+	findings := []doctor.Finding{capabilityFinding(), doctor.OtherDaemons(os.DirFS("/proc"), os.Getpid())}
+	findings = append(findings, firewallFindings()...)
+	var ifaces []string
+	for _, in := range instances {
+		if !slices.Contains(ifaces, in.iface) {
+			ifaces = append(ifaces, in.iface)
+			findings = append(findings, interfaceFindings(in.iface)...)
+		}
+	}
+	for _, in := range instances {
+		for _, vip := range in.vips {
+			findings = append(findings, vipFinding(vip, running))
+		}
+	}
+	if len(instances) == 0 {
+		findings = append(findings, doctor.Finding{
+			Check:   "instances",
+			Status:  doctor.StatusSkip,
+			Message: "no daemon running: pass --config or --interface and --vips to check interfaces and VIPs",
+		})
+	}
+
+	failed := 0
+	for _, f := range findings {
+		if f.Status == doctor.StatusFail {
+			failed++
+		}
+	}
+	printFindings(findings)
+	if failed > 0 {
+		exitWithError(fmt.Errorf("%d check(s) failed", failed))
+	}
+}
+
+func printFindings(findings []doctor.Finding) {
+	if *doctorOutput == "json" {
+		out := []doctorFinding{}
+		for _, f := range findings {
+			out = append(out, doctorFinding{Check: f.Check, Status: string(f.Status), Message: f.Message, Fix: f.Fix})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(out)
+		return
+	}
+
+	width := 0
+	for _, f := range findings {
+		width = max(width, len(f.Check))
+	}
+	for _, f := range findings {
+		fmt.Printf("%-4s  %-*s  %s\n", strings.ToUpper(string(f.Status)), width, f.Check, f.Message)
+		if f.Fix != "" {
+			fmt.Printf("%-4s  %-*s  fix: %s\n", "", width, "", f.Fix)
+		}
+	}
+}
+
+// capabilityFinding checks the capabilities of this process, which are those
+// vrrp run would have if started the same way
+func capabilityFinding() doctor.Finding {
+	f := doctor.Finding{Check: "capabilities", Status: doctor.StatusOK, Message: "CAP_NET_RAW and CAP_NET_ADMIN"}
+	if err := checkCapabilities(); err != nil {
+		f.Status, f.Message = doctor.StatusFail, err.Error()
+		f.Fix = "run as root, or with AmbientCapabilities=CAP_NET_RAW CAP_NET_ADMIN under systemd " +
+			"(vrrp install-service writes such a unit)"
+	}
+	return f
+}
+
+// firewallFindings scans the rules of iptables and nftables, where installed
+func firewallFindings() []doctor.Finding {
+	var findings []doctor.Finding
+	for _, fw := range []struct {
+		check string
+		cmd   []string
+		scan  func(string) doctor.Finding
+	}{
+		{"iptables", []string{"iptables-save"}, doctor.ScanIptables},
+		{"nftables", []string{"nft", "list", "ruleset"}, doctor.ScanNftables},
+	} {
+		path, err := exec.LookPath(fw.cmd[0])
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), firewallTimeout)
+		out, err := exec.CommandContext(ctx, path, fw.cmd[1:]...).Output()
+		cancel()
+		if err != nil {
+			findings = append(findings, doctor.Finding{
+				Check:   fw.check,
+				Status:  doctor.StatusSkip,
+				Message: fmt.Sprintf("%s failed: %v", strings.Join(fw.cmd, " "), err),
+				Fix:     "run as root to read the rules",
+			})
+			continue
+		}
+		findings = append(findings, fw.scan(string(out)))
+	}
+	return findings
+}
+
+// interfaceFindings checks that iface can carry advertisements, and its
+// reverse path filter
+func interfaceFindings(iface string) []doctor.Finding {
+	f := doctor.Finding{Check: "interface " + iface, Status: doctor.StatusFail}
+	ifi, err := net.InterfaceByName(iface)
+	switch {
+	case err != nil:
+		f.Message, f.Fix = "not found", "check the name with ip link"
+		return []doctor.Finding{f}
+	case ifi.Flags&net.FlagUp == 0:
+		f.Message, f.Fix = "down", "ip link set "+iface+" up"
+	case ifi.Flags&net.FlagMulticast == 0:
+		f.Message = "multicast is off, so advertisements to 224.0.0.18 are neither sent nor received"
+		f.Fix = "ip link set " + iface + " multicast on"
+	case !hasIPv4(ifi):
+		f.Message = "no IPv4 address to send advertisements from"
+		f.Fix = "ip addr add ADDRESS/PREFIX dev " + iface
+	default:
+		f.Status, f.Message = doctor.StatusOK, "up, multicast, with an IPv4 address"
+	}
+	findings := []doctor.Finding{f}
+
+	all, errAll := readRPFilter("all")
+	value, err := readRPFilter(iface)
+	if errAll != nil || err != nil {
+		return append(findings, doctor.Finding{
+			Check:   "rp_filter " + iface,
+			Status:  doctor.StatusSkip,
+			Message: fmt.Sprintf("cannot read: %v", errors.Join(errAll, err)),
+		})
+	}
+	return append(findings, doctor.RPFilter(iface, all, value))
+}
+
+// readRPFilter reads net.ipv4.conf.IFACE.rp_filter
+func readRPFilter(iface string) (int, error) {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/conf/" + iface + "/rp_filter")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// vipFinding checks that vip is not on an interface of this host already,
+// unless the running daemon holds it as MASTER: a static or leftover copy
+// answers ARP while another router is MASTER
+func vipFinding(vip string, running []control.InstanceStatus) doctor.Finding {
+	f := doctor.Finding{Check: "VIP " + vip, Status: doctor.StatusOK, Message: "not on this host"}
+	ip := net.ParseIP(vip)
+	if ip == nil {
+		f.Status, f.Message = doctor.StatusFail, "not an IP address"
+		return f
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		f.Status, f.Message = doctor.StatusSkip, err.Error()
+		return f
+	}
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || !ipnet.IP.Equal(ip) {
+				continue
+			}
+			for _, is := range running {
+				if is.State == "MASTER" && slices.Contains(is.VirtualIPs, vip) {
+					f.Message = fmt.Sprintf("on %s, held by the running daemon as MASTER of VRID %d", ifi.Name, is.VRID)
+					return f
+				}
+			}
+			f.Status = doctor.StatusFail
+			f.Message = fmt.Sprintf("already on %s, not held by a running MASTER: configured statically or left "+
+				"by a daemon that did not stop cleanly", ifi.Name)
+			f.Fix = fmt.Sprintf("ip addr del %s dev %s", ipnet, ifi.Name)
+			return f
+		}
+	}
+	return f
+}
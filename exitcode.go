@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"syscall"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Exit codes. Supervisors can restart on exitFailure but should not retry the
+// others until an operator has fixed the cause.
+const (
+	exitOK         = 0
+	exitFailure    = 1 // runtime failure, retrying may help
+	exitUsage      = 2 // invalid command line
+	exitConfig     = 3 // invalid configuration
+	exitPermission = 4 // missing privileges (root or CAP_NET_RAW/CAP_NET_ADMIN)
+	exitInterface  = 5 // interface missing or without an IPv4 address
+	exitConflict   = 6 // instance, pidfile or listen address already in use
+)
+
+var exitClasses = map[int]string{
+	exitFailure:    "runtime",
+	exitUsage:      "usage",
+	exitConfig:     "config",
+	exitPermission: "permission",
+	exitInterface:  "interface",
+	exitConflict:   "conflict",
+}
+
+// errAlreadyRunning reports that another process holds an instance lock or
+// the pidfile
+var errAlreadyRunning = errors.New("already running")
+
+// exitError attaches an exit code to an error whose class cannot be told
+// from its chain, such as a configuration file that failed to parse
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// exitCodeFor classifies err
+func exitCodeFor(err error) int {
+	var ee *exitError
+	switch {
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.Is(err, vrrp.ErrInvalidConfig):
+		return exitConfig
+	case errors.Is(err, vrrp.ErrInterfaceNotFound), errors.Is(err, vrrp.ErrNoIPv4Address):
+		return exitInterface
+	case errors.Is(err, os.ErrPermission):
+		return exitPermission
+	case errors.Is(err, errAlreadyRunning), errors.Is(err, syscall.EADDRINUSE):
+		return exitConflict
+	default:
+		return exitFailure
+	}
+}
+
+// fatal logs msg and err at error level with the error's class and exit
+// code, then exits with that code. The record is also written to stderr when
+// logs go to a file, so it is always the last line a supervisor sees.
+func fatal(msg string, err error, args ...any) {
+	code := exitCodeFor(err)
+	args = append(args, "err", err, "class", exitClasses[code], "exit_code", code)
+
+	slog.Error(msg, args...)
+	if *logFile != "" {
+		stderrLogger().Error(msg, args...)
+	}
+	os.Exit(code)
+}
+
+// exitWithError ends a client command with err's exit code
+func exitWithError(err error) {
+	code := exitCodeFor(err)
+	stderrLogger().Error(err.Error(), "class", exitClasses[code], "exit_code", code)
+	os.Exit(code)
+}
+
+// usageTerminate makes kingpin's own errors exit with exitUsage
+func usageTerminate(code int) {
+	if code != exitOK {
+		code = exitUsage
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"invalid config", fmt.Errorf("eth0/10: %w: priority 0", vrrp.ErrInvalidConfig), exitConfig},
+		{"interface not found", fmt.Errorf("eth9: %w", vrrp.ErrInterfaceNotFound), exitInterface},
+		{"no IPv4 address", vrrp.ErrNoIPv4Address, exitInterface},
+		{"permission", &fs.PathError{Op: "open", Path: "/run/vrrp.pid", Err: syscall.EACCES}, exitPermission},
+		{"raw socket permission", os.NewSyscallError("socket", syscall.EPERM), exitPermission},
+		{"already running", fmt.Errorf("lock eth0/10: %w", errAlreadyRunning), exitConflict},
+		{"address in use", &net.OpError{Op: "listen", Net: "tcp", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)},
+			exitConflict},
+		{"explicit code", withExitCode(exitConfig, errors.New("parse config.json: unexpected EOF")), exitConfig},
+		{"explicit code over the chain", withExitCode(exitUsage, vrrp.ErrInterfaceNotFound), exitUsage},
+		{"plain error", errors.New("connection refused"), exitFailure},
+	} {
+		if got := exitCodeFor(tt.err); got != tt.want {
+			t.Errorf("%s: exitCodeFor(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var (
+	failoverCmd       = app.Command("failover", "Make the local MASTER hand over to a backup")
+	failoverInterface = failoverCmd.Flag("interface", "Network interface").Short('i').HintAction(interfaceNames).String()
+	failoverVRID      = failoverCmd.Flag("vrid", "Virtual Router ID").Short('r').Uint8()
+	failoverHold      = failoverCmd.Flag("hold", "How long to stay BACKUP before preempting again").
+				Default(control.DefaultFailoverHold.String()).Duration()
+)
+
+func failover() {
+	resp, err := control.NewClient(*socketPath).Do(&control.Request{
+		Command:     control.CommandFailover,
+		Interface:   *failoverInterface,
+		VRID:        *failoverVRID,
+		HoldSeconds: int(failoverHold.Seconds()),
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Println(resp.Message)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"runtime/debug"
+)
+
+// The --low-footprint profile, for routers with 64-128MB of RAM
+const (
+	// lowFootprintGCPercent collects garbage when the heap has grown by a
+	// quarter instead of doubled
+	lowFootprintGCPercent = 25
+	// lowFootprintMemoryLimit is a soft limit on the memory of the Go
+	// runtime, above which the garbage collector works harder
+	lowFootprintMemoryLimit = 16 << 20
+	// lowFootprintQueueLength is the capacity of each instance's send and
+	// receive queues, enough for a master and a preempting backup
+	lowFootprintQueueLength = 4
+)
+
+// applyLowFootprint tunes the Go runtime for --low-footprint. GOGC and
+// GOMEMLIMIT in the environment take precedence.
+func applyLowFootprint() {
+	attrs := []any{"queue_length", lowFootprintQueueLength}
+	if os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(lowFootprintGCPercent)
+		attrs = append(attrs, "gc_percent", lowFootprintGCPercent)
+	}
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(lowFootprintMemoryLimit)
+		attrs = append(attrs, "memory_limit", lowFootprintMemoryLimit)
+	}
+	slog.Info("Low-footprint mode", attrs...)
+}
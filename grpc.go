@@ -0,0 +1,30 @@
+//go:build !small
+// +build !small
+
+package main
+
+import (
+	"crypto/tls"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+// grpcBuilt reports the gRPC admin API in vrrp version
+const grpcBuilt = true
+
+// startGRPCServer serves the gRPC admin API for ctrl on addr, over TLS with
+// tlsConfig if it is not nil, and returns the function that stops it
+func startGRPCServer(addr string, ctrl *control.Server, tlsConfig *tls.Config) (func(), error) {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := control.NewGRPCServer(ctrl, opts...)
+	if err := s.Start(addr); err != nil {
+		return nil, err
+	}
+	return s.Close, nil
+}
@@ -0,0 +1,20 @@
+//go:build small
+// +build small
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+// grpcBuilt reports the gRPC admin API in vrrp version
+const grpcBuilt = false
+
+// startGRPCServer fails in a binary built with -tags small, which leaves out
+// the gRPC library
+func startGRPCServer(string, *control.Server, *tls.Config) (func(), error) {
+	return nil, withExitCode(exitUsage, errors.New("not built into this binary (built with -tags small)"))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var (
+	handoffCmd = app.Command("handoff",
+		"Make the local MASTER hand over to a peer and wait until it has taken over")
+	handoffInterface = handoffCmd.Flag("interface", "Network interface").Short('i').HintAction(interfaceNames).String()
+	handoffVRID      = handoffCmd.Flag("vrid", "Virtual Router ID").Short('r').Uint8()
+	handoffTo        = handoffCmd.Flag("to", "Address of the router to hand over to (default: any)").String()
+	handoffHold      = handoffCmd.Flag("hold", "How long to stay BACKUP before preempting again").
+				Default(control.DefaultFailoverHold.String()).Duration()
+)
+
+func handoff() {
+	resp, err := control.NewClient(*socketPath).Do(&control.Request{
+		Command:     control.CommandHandoff,
+		Interface:   *handoffInterface,
+		VRID:        *handoffVRID,
+		Peer:        *handoffTo,
+		HoldSeconds: int(handoffHold.Seconds()),
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Println(resp.Message)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var (
+	listCmd    = app.Command("list", "List the instances the running daemon manages")
+	listOutput = listCmd.Flag("output", "Output format").Short('o').Default(outputTable).Enum(outputTable, outputJSON)
+)
+
+// listEntry is one instance in the JSON output of list: the fields of the
+// table, without the rest of the status
+type listEntry struct {
+	Interface         string   `json:"interface"`
+	VRID              uint8    `json:"vrid"`
+	State             string   `json:"state"`
+	Priority          uint8    `json:"priority"`
+	EffectivePriority uint8    `json:"effective_priority"`
+	VirtualIPs        []string `json:"virtual_ips"`
+	Uptime            string   `json:"uptime"`
+}
+
+var listColumns = []column[listEntry]{
+	{header: "INTERFACE", value: func(e listEntry) string { return e.Interface }},
+	{header: "VRID", value: func(e listEntry) string { return strconv.Itoa(int(e.VRID)) }},
+	{header: "STATE", value: func(e listEntry) string { return e.State }},
+	{header: "PRIORITY", value: func(e listEntry) string { return strconv.Itoa(int(e.Priority)) }},
+	{header: "EFFECTIVE", value: func(e listEntry) string { return strconv.Itoa(int(e.EffectivePriority)) }},
+	{header: "VIPS", value: func(e listEntry) string { return strings.Join(e.VirtualIPs, ",") }},
+	{header: "UPTIME", value: func(e listEntry) string { return orDash(e.Uptime) }},
+}
+
+// listInstances prints every instance with its priority before and after
+// the trackers, for a quick inventory; status has the details
+func listInstances() {
+	resp, err := control.NewClient(*socketPath).Do(&control.Request{Command: control.CommandStatus})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	entries := []listEntry{}
+	for _, is := range resp.Instances {
+		entries = append(entries, listEntry{
+			Interface:         is.Interface,
+			VRID:              is.VRID,
+			State:             is.State,
+			Priority:          is.ConfiguredPriority,
+			EffectivePriority: is.Priority,
+			VirtualIPs:        is.VirtualIPs,
+			Uptime:            is.Uptime,
+		})
+	}
+	if len(entries) == 0 && *listOutput != outputJSON {
+		fmt.Println("No VRRP instances")
+		return
+	}
+	if err := printRows(os.Stdout, *listOutput, entries, listColumns); err != nil {
+		exitWithError(err)
+	}
+}
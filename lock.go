@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// instanceLock is an flock held for as long as this process runs an instance,
+// so a second daemon cannot run the same interface/VRID pair on this host
+type instanceLock struct {
+	f *os.File
+}
+
+func lockInstance(dir, iface string, vrid uint8) (*instanceLock, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.lock", iface, vrid))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s/%d is %w in another vrrp process%s (lock %s)",
+				iface, vrid, errAlreadyRunning, describeHolder(path), path)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	return inheritLock(f), nil
+}
+
+// inheritLock wraps a lock file that is already locked, by this process or by
+// a predecessor that handed the descriptor over
+func inheritLock(f *os.File) *instanceLock {
+	// Record our PID for the error message of the next process that tries
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &instanceLock{f: f}
+}
+
+func describeHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if pid := strings.TrimSpace(string(data)); pid != "" {
+		return " (pid " + pid + ")"
+	}
+	return ""
+}
+
+// release drops the lock. The file is left in place: removing it would let
+// a concurrent locker end up holding a lock on an unlinked file.
+func (l *instanceLock) release() {
+	_ = l.f.Close()
+}
+
+// writePidfile writes the PID to path, refusing to replace the pidfile of a
+// process that is still alive other than predecessor, the PID of a daemon
+// handing over to this one (0 if none)
+func writePidfile(path string, predecessor int) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() &&
+			pid != predecessor && syscall.Kill(pid, 0) == nil {
+			return fmt.Errorf("pidfile %s belongs to running process %d: %w", path, pid, errAlreadyRunning)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write pidfile: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write pidfile: %w", err)
+	}
+	return nil
+}
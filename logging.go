@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+	"github.com/tokuhirom/vrrp-simple/pkg/logfile"
+)
+
+var (
+	logLevel = app.Flag("log-level", "Minimum log level").
+			Envar("VRRP_LOG_LEVEL").Default("info").Enum("debug", "info", "warn", "error")
+	logFormat = app.Flag("log-format", "Log output format").Envar("VRRP_LOG_FORMAT").Default("text").Enum("text", "json")
+
+	logFile    = app.Flag("log-file", "Write logs to this file instead of stderr").Envar("VRRP_LOG_FILE").String()
+	logMaxSize = app.Flag("log-max-size", "Rotate the log file at this size (0 disables)").
+			Envar("VRRP_LOG_MAX_SIZE").Default("100MB").Bytes()
+	logRotateEvery = app.Flag("log-rotate-interval", "Rotate the log file after this long (0 disables)").
+			Envar("VRRP_LOG_ROTATE_INTERVAL").Default("0").Duration()
+	logMaxBackups = app.Flag("log-max-backups", "Number of rotated log files to keep (0 keeps all)").
+			Envar("VRRP_LOG_MAX_BACKUPS").Default("5").Int()
+	logMaxAge = app.Flag("log-max-age", "Remove rotated log files older than this (0 disables)").
+			Envar("VRRP_LOG_MAX_AGE").Default("0").Duration()
+)
+
+// setupLogging installs the slog handler selected by --log-level and
+// --log-format as the default logger. Messages from the standard log package
+// are routed through it as well.
+func setupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		app.Fatalf("invalid log level %q", *logLevel)
+	}
+
+	var out io.Writer = os.Stderr
+	if *logFile != "" {
+		w, err := logfile.Open(logfile.Config{
+			Path:        *logFile,
+			MaxSize:     int64(*logMaxSize),
+			RotateEvery: *logRotateEvery,
+			MaxBackups:  *logMaxBackups,
+			MaxAge:      *logMaxAge,
+		})
+		if err != nil {
+			app.Fatalf("%v", err)
+		}
+		out = w
+	}
+
+	slog.SetDefault(slog.New(newLogHandler(out, &slog.HandlerOptions{Level: level})))
+}
+
+func newLogHandler(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	if *logFormat == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// stderrLogger writes untimestamped records in the --log-format to stderr,
+// for the final error line of a command
+func stderrLogger() *slog.Logger {
+	return slog.New(newLogHandler(os.Stderr, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+// historyHandler passes records on to next and keeps them in a
+// control.LogBuffer, for vrrp logs to read over the control socket
+type historyHandler struct {
+	next slog.Handler
+	buf  *control.LogBuffer
+	// attrs are those of WithAttrs, rendered; prefix is that of WithGroup
+	attrs  []control.LogAttr
+	prefix string
+}
+
+// keepLogHistory makes the default logger also keep its records in buf
+func keepLogHistory(buf *control.LogBuffer) {
+	slog.SetDefault(slog.New(&historyHandler{next: slog.Default().Handler(), buf: buf}))
+}
+
+func (h *historyHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *historyHandler) Handle(ctx context.Context, r slog.Record) error {
+	rec := control.LogRecord{Time: r.Time, Level: r.Level.String(), Message: r.Message, Attrs: slices.Clone(h.attrs)}
+	r.Attrs(func(a slog.Attr) bool {
+		rec.Attrs = appendLogAttr(rec.Attrs, h.prefix, a)
+		return true
+	})
+	h.buf.Add(rec)
+	return h.next.Handle(ctx, r)
+}
+
+func (h *historyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h.next.WithAttrs(attrs)
+	h2.attrs = slices.Clone(h.attrs)
+	for _, a := range attrs {
+		h2.attrs = appendLogAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *historyHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.next = h.next.WithGroup(name)
+	h2.prefix += name + "."
+	return &h2
+}
+
+// appendLogAttr renders a, flattening groups into prefixed keys as the text
+// handler does
+func appendLogAttr(attrs []control.LogAttr, prefix string, a slog.Attr) []control.LogAttr {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			attrs = appendLogAttr(attrs, prefix, ga)
+		}
+		return attrs
+	}
+	if a.Key == "" {
+		return attrs
+	}
+	return append(attrs, control.LogAttr{Key: prefix + a.Key, Value: v.String()})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var (
+	logsCmd       = app.Command("logs", "Print the recent log of the running daemon")
+	logsFollow    = logsCmd.Flag("follow", "Go on printing records as the daemon logs them").Short('f').Bool()
+	logsLines     = logsCmd.Flag("lines", "Number of recent records to print").Short('n').Default("50").Int()
+	logsInterface = logsCmd.Flag("interface", "Only records of this network interface").Short('i').
+			HintAction(interfaceNames).String()
+	logsVRID   = logsCmd.Flag("vrid", "Only records of this Virtual Router ID").Short('r').Uint8()
+	logsOutput = logsCmd.Flag("output", "Output format").Short('o').Default("text").Enum("text", "json")
+)
+
+// showLogs prints the records the daemon kept, then with --follow the new
+// ones until interrupted or the daemon stops
+func showLogs() {
+	if *logsLines < 0 {
+		app.Fatalf("--lines must not be negative")
+	}
+	req := &control.Request{
+		Command:   control.CommandLogs,
+		Interface: *logsInterface,
+		VRID:      *logsVRID,
+		Lines:     *logsLines,
+		Follow:    *logsFollow,
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	err := control.NewClient(*socketPath).Stream(req, func(resp *control.Response) error {
+		for i := range resp.Logs {
+			if *logsOutput == "json" {
+				if err := enc.Encode(&resp.Logs[i]); err != nil {
+					return err
+				}
+				continue
+			}
+			printLogRecord(&resp.Logs[i])
+		}
+		return nil
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// printLogRecord prints rec as the daemon's text log does
+func printLogRecord(rec *control.LogRecord) {
+	var b strings.Builder
+	b.WriteString("time=" + rec.Time.Format(time.RFC3339Nano))
+	b.WriteString(" level=" + rec.Level)
+	b.WriteString(" msg=" + logValue(rec.Message))
+	for _, a := range rec.Attrs {
+		b.WriteString(" " + a.Key + "=" + logValue(a.Value))
+	}
+	fmt.Println(b.String())
+}
+
+// logValue quotes s where the text handler of log/slog would
+func logValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var (
+	monitorCmd       = app.Command("monitor", "Print VRRP advertisements seen on an interface")
+	monitorInterface = monitorCmd.Flag("interface", "Network interface to listen on").Short('i').
+				HintAction(interfaceNames).Required().String()
+	monitorVRID   = monitorCmd.Flag("vrid", "Only show this Virtual Router ID").Short('r').Uint8()
+	monitorOutput = monitorCmd.Flag("output", "Output format").Short('o').Default("text").Enum("text", "json")
+)
+
+// advert is one received advertisement as printed by vrrp monitor and vrrp
+// replay
+type advert struct {
+	Time        time.Time `json:"time"`
+	Interface   string    `json:"interface"`
+	Source      string    `json:"source"`
+	TTL         int       `json:"ttl"`
+	Version     uint8     `json:"version"`
+	VRID        uint8     `json:"vrid"`
+	Priority    uint8     `json:"priority"`
+	AdvInterval uint16    `json:"advert_interval"`
+	AuthType    uint8     `json:"auth_type"`
+	VirtualIPs  []string  `json:"virtual_ips"`
+	Checksum    string    `json:"checksum"` // "ok", "bad" or "unverified"
+	Error       string    `json:"error,omitempty"`
+	// Drop is why a router would discard the advertisement (vrrp replay)
+	Drop string `json:"drop,omitempty"`
+}
+
+func monitorAdverts() {
+	network, err := vrrp.NewNetwork(*monitorInterface)
+	if err != nil {
+		exitWithError(err)
+	}
+	defer func() { _ = network.Close() }()
+	if *monitorVRID != 0 {
+		// The VRID is still checked below if the kernel won't filter
+		if err := network.SetVRIDFilter([]uint8{*monitorVRID}); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	enc := json.NewEncoder(os.Stdout)
+	err = network.ReceiveRaw(ctx, func(header *ipv4.Header, payload []byte) {
+		a := decodeAdvert(time.Now(), *monitorInterface, header, payload)
+		if *monitorVRID != 0 && a.VRID != *monitorVRID {
+			return
+		}
+
+		if *monitorOutput == "json" {
+			_ = enc.Encode(a)
+			return
+		}
+		printAdvert(&a)
+	})
+	if err != nil && err != context.Canceled {
+		exitWithError(err)
+	}
+}
+
+func decodeAdvert(t time.Time, iface string, header *ipv4.Header, payload []byte) advert {
+	a := advert{
+		Time:       t,
+		Interface:  iface,
+		Source:     header.Src.String(),
+		TTL:        header.TTL,
+		Checksum:   "unverified",
+		VirtualIPs: []string{},
+	}
+
+	pkt := &vrrp.Packet{}
+	if err := pkt.Unmarshal(payload); err != nil {
+		a.Error = err.Error()
+		return a
+	}
+
+	a.Version = pkt.Version
+	a.VRID = pkt.VRID
+	a.Priority = pkt.Priority
+	a.AdvInterval = pkt.AdvInterval
+	a.AuthType = pkt.AuthType
+	for _, ip := range pkt.IPAddresses {
+		a.VirtualIPs = append(a.VirtualIPs, ip.String())
+	}
+
+	if valid, ok := pkt.VerifyChecksum(payload); ok {
+		a.Checksum = "bad"
+		if valid {
+			a.Checksum = "ok"
+		}
+	}
+
+	return a
+}
+
+// vipList formats the addresses of an advertisement, which some stacks send
+// without any
+func vipList(vips []string) string {
+	if len(vips) == 0 {
+		return "none"
+	}
+	return strings.Join(vips, ",")
+}
+
+func printAdvert(a *advert) {
+	ts := a.Time.Format("15:04:05.000")
+	if a.Error != "" {
+		fmt.Printf("%s %s > malformed: %s\n", ts, a.Source, a.Error)
+		return
+	}
+
+	// VRRPv3 intervals are in centiseconds
+	unit := "s"
+	if a.Version == vrrp.VRRPv3 {
+		unit = "cs"
+	}
+	line := fmt.Sprintf("%s %s > VRRPv%d vrid %d prio %d int %d%s ttl %d auth %d checksum %s vips %s",
+		ts, a.Source, a.Version, a.VRID, a.Priority, a.AdvInterval, unit, a.TTL, a.AuthType, a.Checksum,
+		vipList(a.VirtualIPs))
+	if a.Drop != "" {
+		line += " [dropped: " + a.Drop + "]"
+	}
+	fmt.Println(line)
+}
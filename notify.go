@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+)
+
+// sdNotify sends a state update to systemd when running under Type=notify.
+// It does nothing when NOTIFY_SOCKET is not set.
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+
+	// A leading '@' denotes an abstract socket
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Failed to notify systemd", "err", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Failed to notify systemd", "err", err)
+	}
+}
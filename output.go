@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats accepted by --output
+const (
+	outputTable = "table"
+	outputWide  = "wide"
+	outputJSON  = "json"
+)
+
+var outputFormats = []string{outputTable, outputWide, outputJSON}
+
+// column is one field of a tabular listing
+type column[T any] struct {
+	header string
+	wide   bool // only shown with --output wide
+	value  func(T) string
+}
+
+// columnsNamed picks the columns with the given headers out of columns, in
+// the order given, to be shown in every format. It panics on a header
+// columns does not have, which the tests of the listings catch.
+func columnsNamed[T any](columns []column[T], headers ...string) []column[T] {
+	out := make([]column[T], 0, len(headers))
+	for _, h := range headers {
+		i := slices.IndexFunc(columns, func(c column[T]) bool { return c.header == h })
+		if i < 0 {
+			panic("no column " + h)
+		}
+		c := columns[i]
+		c.wide = false
+		out = append(out, c)
+	}
+	return out
+}
+
+// printRows writes rows in the requested format. JSON output encodes rows as-is
+// so the structure matches the control socket wire format.
+func printRows[T any](w io.Writer, format string, rows []T, columns []column[T]) error {
+	if format == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var cols []column[T]
+	for _, c := range columns {
+		if c.wide && format != outputWide {
+			continue
+		}
+		cols = append(cols, c)
+	}
+
+	for i, c := range cols {
+		if i > 0 {
+			fmt.Fprint(tw, "\t")
+		}
+		fmt.Fprint(tw, c.header)
+	}
+	fmt.Fprintln(tw)
+
+	for _, row := range rows {
+		for i, c := range cols {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, c.value(row))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func formatAgo(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Truncate(time.Second).String() + " ago"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// DefaultActiveActivePriority is the priority of a node on the VRID of an
+// active-active pair it is meant to be MASTER of
+const DefaultActiveActivePriority = 150
+
+// hostname names this node when an active-active pair leaves out node
+var hostname = os.Hostname
+
+// ActiveActive splits virtual IPs between two nodes that back each other
+// up. Parse expands it into two instances on VRID and VRID+1: the virtual
+// IPs are dealt between them in turn, the first node has Priority on VRID
+// and BackupPriority on VRID+1, and the second node the other way round.
+type ActiveActive struct {
+	// Name identifies the pair in errors and the status
+	Name       string   `json:"name"`
+	Interface  string   `json:"interface"`
+	VRID       uint8    `json:"vrid"`
+	VirtualIPs []string `json:"virtual_ips"`
+
+	// Nodes are the two nodes of the pair, Node the one this file is for;
+	// the host name if empty
+	Nodes []string `json:"nodes"`
+	Node  string   `json:"node,omitempty"`
+
+	Priority       uint8 `json:"priority,omitempty"`
+	BackupPriority uint8 `json:"backup_priority,omitempty"`
+	AdvertInterval int   `json:"advert_interval,omitempty"`
+}
+
+// Pair is what an instance expanded from an ActiveActive knows of it
+type Pair struct {
+	// Index is the pair's position in File.ActiveActive
+	Index int
+	Name  string
+	// Master is the node meant to be MASTER of the instance's VRID, Backup
+	// the other, MASTER of PartnerVRID
+	Master      string
+	Backup      string
+	PartnerVRID uint8
+}
+
+func (aa *ActiveActive) applyDefaults() {
+	if aa.Priority == 0 {
+		aa.Priority = DefaultActiveActivePriority
+	}
+	if aa.BackupPriority == 0 {
+		aa.BackupPriority = DefaultPriority
+	}
+	if aa.AdvertInterval == 0 {
+		aa.AdvertInterval = DefaultAdvertInterval
+	}
+	if aa.Node == "" {
+		aa.Node, _ = hostname()
+	}
+}
+
+// Key identifies the pair by its interface and first VRID
+func (aa *ActiveActive) Key() string {
+	return fmt.Sprintf("%s/%d", aa.Interface, aa.VRID)
+}
+
+// instances expands the pair at index into the instances of this node,
+// leaving out those without virtual IPs or beyond VRID 255, which validate
+// reports
+func (aa *ActiveActive) instances(index int) []Instance {
+	var first, second string
+	if len(aa.Nodes) == 2 {
+		first, second = aa.Nodes[0], aa.Nodes[1]
+	}
+
+	out := make([]Instance, 0, 2)
+	for i := 0; i < 2 && i < len(aa.VirtualIPs) && int(aa.VRID)+i <= 255; i++ {
+		master, backup := first, second
+		if i == 1 {
+			master, backup = second, first
+		}
+		priority := aa.BackupPriority
+		if aa.Node == master {
+			priority = aa.Priority
+		}
+
+		var vips []string
+		for j := i; j < len(aa.VirtualIPs); j += 2 {
+			vips = append(vips, aa.VirtualIPs[j])
+		}
+		partner := int(aa.VRID) + 1 - i
+		in := Instance{
+			Interface:      aa.Interface,
+			VRID:           aa.VRID + uint8(i),
+			Priority:       priority,
+			VirtualIPs:     vips,
+			AdvertInterval: aa.AdvertInterval,
+		}
+		in.Pair = &Pair{Index: index, Name: aa.Name, Master: master, Backup: backup, PartnerVRID: uint8(partner)}
+		in.applyDefaults()
+		out = append(out, in)
+	}
+	return out
+}
+
+// validate reports the mistakes of the pair at index itself; those of the
+// instances it expands into are found with the others
+func (aa *ActiveActive) validate(index int) []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("active_active[%d] (%s): %s", index, aa.Key(), fmt.Sprintf(format, args...)))
+	}
+
+	if aa.Name == "" {
+		fail("name is required")
+	}
+	if aa.VRID == 255 {
+		fail("vrid must be between 1 and 254: the pair uses vrid and vrid+1")
+	}
+	if len(aa.Nodes) != 2 || aa.Nodes[0] == aa.Nodes[1] || aa.Nodes[0] == "" || aa.Nodes[1] == "" {
+		fail("nodes must name two different nodes, got %q", aa.Nodes)
+	} else if aa.Node != aa.Nodes[0] && aa.Node != aa.Nodes[1] {
+		fail("node %q is not one of nodes %q", aa.Node, aa.Nodes)
+	}
+	if aa.Priority <= aa.BackupPriority {
+		fail("priority %d must be above backup_priority %d", aa.Priority, aa.BackupPriority)
+	}
+	if len(aa.VirtualIPs) < 2 {
+		fail("at least two virtual IPs are required to split them")
+	}
+	return errs
+}
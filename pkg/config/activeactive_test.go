@@ -0,0 +1,108 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseActiveActive(t *testing.T) {
+	data := `{
+		"instances": [{"interface": "eth1", "vrid": 1, "virtual_ips": ["10.0.0.100"]}],
+		"active_active": [
+			{"name": "web", "interface": "eth0", "vrid": 10, "nodes": ["lb1", "lb2"], "node": "%s",
+			 "virtual_ips": ["192.168.1.100", "192.168.1.101", "192.168.1.102"]}
+		]
+	}`
+
+	for _, tt := range []struct {
+		node       string
+		priorities [2]uint8
+	}{
+		{"lb1", [2]uint8{DefaultActiveActivePriority, DefaultPriority}},
+		{"lb2", [2]uint8{DefaultPriority, DefaultActiveActivePriority}},
+	} {
+		f, err := Parse([]byte(strings.Replace(data, "%s", tt.node, 1)))
+		if err != nil {
+			t.Fatalf("Parse for %s: %v", tt.node, err)
+		}
+		if errs := f.Validate(); len(errs) != 0 {
+			t.Errorf("Validate for %s: %v", tt.node, errs)
+		}
+		if len(f.Instances) != 3 {
+			t.Fatalf("%s: %d instances, want 3", tt.node, len(f.Instances))
+		}
+
+		first, second := f.Instances[1], f.Instances[2]
+		if first.Key() != "eth0/10" || second.Key() != "eth0/11" {
+			t.Errorf("%s: keys %s and %s, want eth0/10 and eth0/11", tt.node, first.Key(), second.Key())
+		}
+		if first.Priority != tt.priorities[0] || second.Priority != tt.priorities[1] {
+			t.Errorf("%s: priorities %d and %d, want %v", tt.node, first.Priority, second.Priority, tt.priorities)
+		}
+		if !slices.Equal(first.VirtualIPs, []string{"192.168.1.100", "192.168.1.102"}) ||
+			!slices.Equal(second.VirtualIPs, []string{"192.168.1.101"}) {
+			t.Errorf("%s: virtual IPs split as %v and %v", tt.node, first.VirtualIPs, second.VirtualIPs)
+		}
+		if !first.PreemptEnabled() || first.AdvertInterval != DefaultAdvertInterval {
+			t.Errorf("%s: defaults not applied: %+v", tt.node, first)
+		}
+		want := Pair{Index: 0, Name: "web", Master: "lb2", Backup: "lb1", PartnerVRID: 10}
+		if second.Pair == nil || *second.Pair != want {
+			t.Errorf("%s: pair of the second instance = %+v, want %+v", tt.node, second.Pair, want)
+		}
+		if f.Instances[0].Pair != nil || f.Instances[0].Where(0) != "instances[0]" || second.Where(2) != "active_active[0]" {
+			t.Errorf("%s: instances named %s, %s", tt.node, f.Instances[0].Where(0), second.Where(2))
+		}
+	}
+}
+
+func TestParseActiveActiveHostname(t *testing.T) {
+	orig := hostname
+	t.Cleanup(func() { hostname = orig })
+	hostname = func() (string, error) { return "lb2", nil }
+
+	f, err := Parse([]byte(`{"active_active": [{"name": "web", "interface": "eth0", "vrid": 10,
+		"nodes": ["lb1", "lb2"], "virtual_ips": ["192.168.1.100", "192.168.1.101"]}]}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(f.Instances) != 2 || f.Instances[0].Priority != DefaultPriority ||
+		f.Instances[1].Priority != DefaultActiveActivePriority {
+		t.Errorf("instances of lb2 = %+v", f.Instances)
+	}
+}
+
+func TestValidateActiveActive(t *testing.T) {
+	f, err := Parse([]byte(`{
+		"instances": [{"interface": "eth0", "vrid": 11, "virtual_ips": ["192.168.1.100"]}],
+		"active_active": [
+			{"name": "web", "interface": "eth0", "vrid": 10, "nodes": ["lb1", "lb2"], "node": "lb3",
+			 "virtual_ips": ["192.168.1.100", "192.168.1.101"]},
+			{"name": "web", "interface": "eth1", "vrid": 255, "nodes": ["lb1", "lb1"], "node": "lb1",
+			 "priority": 100, "virtual_ips": ["10.0.0.100"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	errs := f.Validate()
+	for _, want := range []string{
+		`active_active[0] (eth0/10): node "lb3" is not one of nodes ["lb1" "lb2"]`,
+		"active_active[0] (eth0/11): interface and vrid already used by instances[0]",
+		"active_active[0] (eth0/10): virtual IP 192.168.1.100 already used by instances[0]",
+		"active_active[1] (eth1/255): vrid must be between 1 and 254: the pair uses vrid and vrid+1",
+		`active_active[1] (eth1/255): nodes must name two different nodes, got ["lb1" "lb1"]`,
+		"active_active[1] (eth1/255): priority 100 must be above backup_priority 100",
+		"active_active[1] (eth1/255): at least two virtual IPs are required to split them",
+		`active_active[1] (eth1/255): name "web" already used by active_active[0]`,
+	} {
+		if !slices.ContainsFunc(errs, func(err error) bool { return err.Error() == want }) {
+			t.Errorf("Missing error %q in %v", want, errs)
+		}
+	}
+	if len(errs) != 8 {
+		t.Errorf("Expected 8 errors, got %d: %v", len(errs), errs)
+	}
+}
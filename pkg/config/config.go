@@ -0,0 +1,210 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/route53"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Defaults applied to fields left out of the configuration file
+const (
+	DefaultPriority       = 100
+	DefaultAdvertInterval = 1
+)
+
+// File is the daemon configuration file. It is optional: a single instance can
+// still be configured entirely with command-line flags.
+type File struct {
+	Instances []Instance `json:"instances,omitempty"`
+
+	// ActiveActive are pairs of instances splitting virtual IPs between two
+	// nodes. Parse appends the instances of each to Instances.
+	ActiveActive []ActiveActive `json:"active_active,omitempty"`
+}
+
+// Instance configures one virtual router
+type Instance struct {
+	Interface      string   `json:"interface"`
+	VRID           uint8    `json:"vrid"`
+	Priority       uint8    `json:"priority,omitempty"`
+	VirtualIPs     []string `json:"virtual_ips"`
+	AdvertInterval int      `json:"advert_interval,omitempty"`
+	Preempt        *bool    `json:"preempt,omitempty"`
+
+	// PreemptWindows restrict preemption to weekly periods in the syntax of
+	// vrrp.ParsePreemptWindow, e.g. "Mon-Fri 02:00-04:00"
+	PreemptWindows []string `json:"preempt_windows,omitempty"`
+
+	// ExcludedIPs are held with the virtual IPs while master but not
+	// advertised
+	ExcludedIPs []string `json:"excluded_ips,omitempty"`
+
+	// SyncGroup names a group of instances that fail over together
+	SyncGroup string `json:"sync_group,omitempty"`
+
+	// Observe follows the election without taking part: the instance
+	// never advertises or programs the virtual IPs
+	Observe bool `json:"observe,omitempty"`
+
+	// BackupOnly keeps the instance from ever becoming MASTER; it raises
+	// an alarm instead when the master is lost
+	BackupOnly bool `json:"backup_only,omitempty"`
+
+	// AddressBackend is how the virtual IPs are programmed: netlink
+	// (default), exec or noop
+	AddressBackend string `json:"address_backend,omitempty"`
+
+	// DetectVIPConflicts makes a MASTER probe its virtual IPs with ARP and
+	// report a split brain when another host answers for one
+	DetectVIPConflicts bool `json:"detect_vip_conflicts,omitempty"`
+
+	// OnLinkCheck is what to do with advertisements from sources outside
+	// the interface's subnets: off (default), count or enforce
+	OnLinkCheck string `json:"on_link_check,omitempty"`
+
+	// IntervalCheck is what to do with advertisements of another interval:
+	// count (default) or enforce, which drops the VRRPv2 ones
+	IntervalCheck string `json:"interval_check,omitempty"`
+
+	// VersionPolicy is what to do with VRRPv3 advertisements: prefer
+	// (default), strict, or translate to also send VRRPv3
+	VersionPolicy string `json:"version_policy,omitempty"`
+
+	// AddressCheck is what to do with advertisements of other virtual IPs:
+	// warn (default), drop, or adopt to report the master's list
+	AddressCheck string `json:"address_check,omitempty"`
+
+	// GARP is the forms of gratuitous ARP sent for each virtual IP on
+	// becoming master: both (default), request, reply or off
+	GARP string `json:"garp,omitempty"`
+
+	// AllowedPeers are the addresses and CIDR prefixes advertisements are
+	// accepted from; empty accepts any
+	AllowedPeers []string `json:"allowed_peers,omitempty"`
+
+	// AuthKeys turn on authenticated advertisements, "ID:KEY" each, the
+	// first signing; only routers running this daemon understand them
+	AuthKeys []string `json:"auth_keys,omitempty"`
+
+	// Trackers lower the priority while a health check fails, in the
+	// syntax of vrrp.ParseTracker, e.g. "dns:query=example.com,server=127.0.0.1"
+	Trackers []string `json:"trackers,omitempty"`
+
+	// Route53 points a Route 53 record at this host each time the instance
+	// becomes master
+	Route53 *route53.Record `json:"route53,omitempty"`
+
+	// Chaos injects faults into received advertisements, for testing, in
+	// the syntax of vrrp.ParseChaos, e.g. "drop=0.2,jitter=50ms"
+	Chaos string `json:"chaos,omitempty"`
+
+	// Pair is set on the instances expanded from an ActiveActive
+	Pair *Pair `json:"-"`
+}
+
+// Load reads and parses the configuration file at path, applying defaults
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Parse decodes a configuration file, rejecting unknown fields so typos are
+// not silently ignored
+func Parse(data []byte) (*File, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var f File
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	for i := range f.Instances {
+		f.Instances[i].applyDefaults()
+	}
+	for i := range f.ActiveActive {
+		f.ActiveActive[i].applyDefaults()
+		f.Instances = append(f.Instances, f.ActiveActive[i].instances(i)...)
+	}
+
+	if len(f.Instances) == 0 {
+		return nil, fmt.Errorf("invalid config: no instances defined")
+	}
+
+	return &f, nil
+}
+
+func (in *Instance) applyDefaults() {
+	if in.Priority == 0 {
+		in.Priority = DefaultPriority
+	}
+	if in.AdvertInterval == 0 {
+		in.AdvertInterval = DefaultAdvertInterval
+	}
+	if in.Preempt == nil {
+		preempt := true
+		in.Preempt = &preempt
+	}
+}
+
+// Key identifies the instance on this host
+func (in *Instance) Key() string {
+	return fmt.Sprintf("%s/%d", in.Interface, in.VRID)
+}
+
+// Where names the instance at index i of File.Instances as the file has
+// it: instances[i], or the active_active pair it was expanded from
+func (in *Instance) Where(i int) string {
+	if in.Pair != nil {
+		return fmt.Sprintf("active_active[%d]", in.Pair.Index)
+	}
+	return fmt.Sprintf("instances[%d]", i)
+}
+
+// PreemptEnabled reports the effective preemption setting
+func (in *Instance) PreemptEnabled() bool {
+	return in.Preempt == nil || *in.Preempt
+}
+
+// VRRPConfig converts the instance to a library configuration. Chaos is
+// left out if it does not parse, which Validate reports.
+func (in *Instance) VRRPConfig() *vrrp.Config {
+	chaos, _ := vrrp.ParseChaos(in.Chaos)
+	return &vrrp.Config{
+		VRID:        in.VRID,
+		Priority:    in.Priority,
+		Interface:   in.Interface,
+		VirtualIPs:  in.VirtualIPs,
+		ExcludedIPs: in.ExcludedIPs,
+		AdvInterval: in.AdvertInterval,
+		Preempt:     in.PreemptEnabled(),
+		Version:     vrrp.VRRPv2,
+
+		PreemptWindows:     in.PreemptWindows,
+		Observe:            in.Observe,
+		BackupOnly:         in.BackupOnly,
+		AddressBackend:     vrrp.AddressBackend(in.AddressBackend),
+		DetectVIPConflicts: in.DetectVIPConflicts,
+		OnLinkCheck:        vrrp.OnLinkCheck(in.OnLinkCheck),
+		IntervalCheck:      vrrp.IntervalCheck(in.IntervalCheck),
+		VersionPolicy:      vrrp.VersionPolicy(in.VersionPolicy),
+		AddressCheck:       vrrp.AddressCheck(in.AddressCheck),
+		GARP:               vrrp.GARPMode(in.GARP),
+		AllowedPeers:       in.AllowedPeers,
+		AuthKeys:           in.AuthKeys,
+		Trackers:           in.Trackers,
+		Chaos:              chaos,
+	}
+}
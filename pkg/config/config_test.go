@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDefaults(t *testing.T) {
+	f, err := Parse([]byte(`{
+		"instances": [
+			{"interface": "eth0", "vrid": 10, "virtual_ips": ["192.168.1.100"]},
+			{"interface": "eth1", "vrid": 20, "priority": 200, "virtual_ips": ["10.0.0.100"],
+			 "advert_interval": 3, "preempt": false}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if len(f.Instances) != 2 {
+		t.Fatalf("Expected 2 instances, got %d", len(f.Instances))
+	}
+
+	first := f.Instances[0]
+	if first.Priority != DefaultPriority || first.AdvertInterval != DefaultAdvertInterval || !first.PreemptEnabled() {
+		t.Errorf("Defaults not applied: %+v", first)
+	}
+
+	second := f.Instances[1]
+	if second.Priority != 200 || second.AdvertInterval != 3 || second.PreemptEnabled() {
+		t.Errorf("Explicit values not kept: %+v", second)
+	}
+
+	if got := second.Key(); got != "eth1/20" {
+		t.Errorf("Unexpected key %q", got)
+	}
+
+	cfg := second.VRRPConfig()
+	if cfg.VRID != 20 || cfg.Interface != "eth1" || cfg.Preempt {
+		t.Errorf("Unexpected library config: %+v", cfg)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"Unknown field": `{"instances": [{"interface": "eth0", "vrid": 1, "prio": 5}]}`,
+		"No instances":  `{"instances": []}`,
+		"Not JSON":      `instances: []`,
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(data)); err == nil {
+				t.Error("Expected parse error")
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vrrp.json")
+	data := `{"instances": [{"interface": "eth0", "vrid": 10, "virtual_ips": ["192.168.1.100"]}]}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if f.Instances[0].VRID != 10 {
+		t.Errorf("Unexpected VRID %d", f.Instances[0].VRID)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	f, err := Parse([]byte(`{
+		"instances": [
+			{"interface": "eth0", "vrid": 10, "virtual_ips": ["192.168.1.100"]},
+			{"interface": "eth0", "vrid": 10, "virtual_ips": ["192.168.1.101"]},
+			{"interface": "eth1", "vrid": 20, "virtual_ips": ["192.168.1.100", "bogus", "fe80::1"],
+			 "advert_interval": 300},
+			{"interface": "eth2", "vrid": 30, "virtual_ips": ["192.168.3.100"], "address_backend": "ifconfig",
+			 "excluded_ips": ["192.168.3.100", "192.168.1.100"],
+			 "chaos": "drop=2", "allowed_peers": ["192.168.3.0/33"],
+			 "on_link_check": "strict", "interval_check": "strict", "version_policy": "v3",
+			 "address_check": "adopted", "garp": "announce", "auth_keys": ["1:short"],
+			 "trackers": ["dns:query=example.com"], "preempt_windows": ["Mon-Fry 02:00-04:00"],
+			 "observe": true, "backup_only": true, "sync_group": "web",
+			 "route53": {"hosted_zone_id": "Z0123456789ABC", "name": "app.example.com", "value": "2001:db8::10"}}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	errs := f.Validate()
+
+	for _, want := range []string{
+		"instances[1] (eth0/10): interface and vrid already used by instances[0]",
+		"instances[2] (eth1/20): virtual IP 192.168.1.100 already used by instances[0]",
+		`instances[2] (eth1/20): invalid virtual IP "bogus"`,
+		"instances[2] (eth1/20): virtual IP fe80::1 is not IPv4",
+		"instances[2] (eth1/20): advert_interval 300 must be between 1 and 255 seconds",
+		`instances[3] (eth2/30): an observer cannot be in sync_group "web"`,
+		`instances[3] (eth2/30): a backup-only instance cannot be in sync_group "web"`,
+		"instances[3] (eth2/30): excluded IP 192.168.3.100 is also a virtual IP",
+		"instances[3] (eth2/30): excluded IP 192.168.1.100 already used by instances[0]",
+		`instances[3] (eth2/30): address_backend "ifconfig" must be one of netlink, exec, noop`,
+		"instances[3] (eth2/30): invalid configuration: chaos drop 2 must be between 0 and 1",
+		`instances[3] (eth2/30): on_link_check "strict" must be one of off, count, enforce`,
+		`instances[3] (eth2/30): interval_check "strict" must be one of count, enforce`,
+		`instances[3] (eth2/30): version_policy "v3" must be one of prefer, strict, translate`,
+		`instances[3] (eth2/30): address_check "adopted" must be one of warn, drop, adopt`,
+		`instances[3] (eth2/30): garp "announce" must be one of both, request, reply, off`,
+		`instances[3] (eth2/30): invalid configuration: invalid allowed peer "192.168.3.0/33": ` +
+			`netip.ParsePrefix("192.168.3.0/33"): prefix length out of range`,
+		`instances[3] (eth2/30): invalid configuration: invalid preemption window "Mon-Fry 02:00-04:00": ` +
+			`unknown day "Mon-Fry"`,
+		"instances[3] (eth2/30): invalid configuration: authentication key 1 is shorter than 16 bytes",
+		`instances[3] (eth2/30): invalid configuration: tracker "dns:query=example.com": ` +
+			"query and server are required",
+		"instances[3] (eth2/30): route53: value 2001:db8::10 is not an IPv4 address for an A record",
+	} {
+		found := false
+		for _, err := range errs {
+			if err.Error() == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Missing error %q in %v", want, errs)
+		}
+	}
+
+	if len(errs) != 21 {
+		t.Errorf("Expected 21 errors, got %d: %v", len(errs), errs)
+	}
+
+	valid := &File{Instances: f.Instances[:1]}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Errorf("Expected valid config, got %v", errs)
+	}
+}
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// kaNode is one statement of a keepalived.conf: a keyword, its arguments and,
+// for blocks, the nested statements
+type kaNode struct {
+	line     int
+	keyword  string
+	args     []string
+	children []*kaNode
+}
+
+// LoadKeepalived reads a keepalived.conf and converts it with ParseKeepalived
+func LoadKeepalived(path string) (*File, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read keepalived config: %w", err)
+	}
+
+	f, warnings, err := ParseKeepalived(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, warnings, nil
+}
+
+// ParseKeepalived converts the vrrp_instance and vrrp_sync_group blocks of a
+// keepalived.conf into a native configuration. Settings without an equivalent here (authentication,
+// unicast peers, vrrp_script tracking, notify scripts, ...) are dropped and
+// reported in the returned warnings.
+func ParseKeepalived(data []byte) (*File, []string, error) {
+	nodes, err := parseKeepalivedNodes(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := &File{}
+	var warnings []string
+	byName := make(map[string]int)
+	var syncGroups []*kaNode
+
+	for _, n := range nodes {
+		switch n.keyword {
+		case "vrrp_instance":
+			in, w, err := convertKeepalivedInstance(n)
+			if err != nil {
+				return nil, nil, err
+			}
+			warnings = append(warnings, w...)
+			byName[strings.Join(n.args, " ")] = len(f.Instances)
+			f.Instances = append(f.Instances, in)
+
+		case "vrrp_sync_group":
+			// Members may be defined further down
+			syncGroups = append(syncGroups, n)
+
+		case "vrrp_script":
+			warnings = append(warnings, fmt.Sprintf(
+				"line %d: vrrp_script %s dropped: health check scripts are not supported",
+				n.line, strings.Join(n.args, " ")))
+
+		case "global_defs":
+			// Router ID, notification email etc. have no meaning here
+
+		default:
+			warnings = append(warnings, fmt.Sprintf("line %d: %s ignored", n.line, n.keyword))
+		}
+	}
+
+	if len(f.Instances) == 0 {
+		return nil, nil, fmt.Errorf("no vrrp_instance blocks found")
+	}
+
+	for _, n := range syncGroups {
+		warnings = append(warnings, convertKeepalivedSyncGroup(n, f, byName)...)
+	}
+
+	return f, warnings, nil
+}
+
+// convertKeepalivedSyncGroup sets the sync group of the instances listed in
+// the group block
+func convertKeepalivedSyncGroup(n *kaNode, f *File, byName map[string]int) []string {
+	var warnings []string
+	name := strings.Join(n.args, " ")
+	warn := func(line int, format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf("line %d: vrrp_sync_group %s: ", line, name)+fmt.Sprintf(format, args...))
+	}
+
+	for _, c := range n.children {
+		if c.keyword != "group" {
+			warn(c.line, "%s ignored", c.keyword)
+			continue
+		}
+
+		// Members are listed one per line or several on a line
+		for _, member := range c.children {
+			for _, inst := range append([]string{member.keyword}, member.args...) {
+				i, ok := byName[inst]
+				if !ok {
+					warn(member.line, "unknown vrrp_instance %s ignored", inst)
+					continue
+				}
+				f.Instances[i].SyncGroup = name
+			}
+		}
+	}
+	return warnings
+}
+
+func convertKeepalivedInstance(n *kaNode) (Instance, []string, error) {
+	var in Instance
+	var warnings []string
+
+	name := strings.Join(n.args, " ")
+	warn := func(line int, format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf("line %d: vrrp_instance %s: ", line, name)+fmt.Sprintf(format, args...))
+	}
+
+	for _, c := range n.children {
+		switch c.keyword {
+		case "interface":
+			v, err := c.arg()
+			if err != nil {
+				return in, nil, err
+			}
+			in.Interface = v
+
+		case "virtual_router_id":
+			v, err := c.uintArg(1, 255)
+			if err != nil {
+				return in, nil, err
+			}
+			in.VRID = uint8(v)
+
+		case "priority":
+			v, err := c.uintArg(1, 255)
+			if err != nil {
+				return in, nil, err
+			}
+			in.Priority = uint8(v)
+
+		case "advert_int":
+			v, err := c.arg()
+			if err != nil {
+				return in, nil, err
+			}
+			secs, err := strconv.ParseFloat(v, 64)
+			if err != nil || secs <= 0 {
+				return in, nil, fmt.Errorf("line %d: invalid advert_int %q", c.line, v)
+			}
+			in.AdvertInterval = int(math.Ceil(secs))
+			if float64(in.AdvertInterval) != secs {
+				warn(c.line, "advert_int %s rounded up to %d seconds", v, in.AdvertInterval)
+			}
+
+		case "nopreempt":
+			preempt := false
+			in.Preempt = &preempt
+
+		case "virtual_ipaddress":
+			for _, addr := range c.children {
+				ip, w := keepalivedVIP(addr)
+				if w != "" {
+					warn(addr.line, "%s", w)
+				}
+				in.VirtualIPs = append(in.VirtualIPs, ip)
+			}
+
+		case "virtual_ipaddress_excluded":
+			for _, addr := range c.children {
+				ip, w := keepalivedVIP(addr)
+				if w != "" {
+					warn(addr.line, "%s", w)
+				}
+				in.ExcludedIPs = append(in.ExcludedIPs, ip)
+			}
+
+		case "state":
+			// The initial state is only a hint; election is decided by priority
+
+		case "track_script":
+			warn(c.line, "track_script dropped: health check scripts are not supported")
+
+		default:
+			warn(c.line, "%s ignored", c.keyword)
+		}
+	}
+
+	switch {
+	case in.Interface == "":
+		return in, nil, fmt.Errorf("line %d: vrrp_instance %s: interface is required", n.line, name)
+	case in.VRID == 0:
+		return in, nil, fmt.Errorf("line %d: vrrp_instance %s: virtual_router_id is required", n.line, name)
+	case len(in.VirtualIPs) == 0:
+		return in, nil, fmt.Errorf("line %d: vrrp_instance %s: virtual_ipaddress is required", n.line, name)
+	}
+
+	return in, warnings, nil
+}
+
+// keepalivedVIP extracts the address from a virtual_ipaddress entry such as
+// "192.168.1.100/24 dev eth0 label eth0:1"
+func keepalivedVIP(n *kaNode) (string, string) {
+	addr := n.keyword
+	var dropped []string
+
+	if ip, _, err := net.ParseCIDR(addr); err == nil {
+		dropped = append(dropped, "prefix length")
+		addr = ip.String()
+	}
+	if len(n.args) > 0 {
+		dropped = append(dropped, fmt.Sprintf("options %q", strings.Join(n.args, " ")))
+	}
+
+	if len(dropped) == 0 {
+		return addr, ""
+	}
+	return addr, fmt.Sprintf("%s of %s dropped", strings.Join(dropped, " and "), n.keyword)
+}
+
+func (n *kaNode) arg() (string, error) {
+	if len(n.args) != 1 {
+		return "", fmt.Errorf("line %d: %s expects one value", n.line, n.keyword)
+	}
+	return n.args[0], nil
+}
+
+func (n *kaNode) uintArg(lo, hi uint64) (uint64, error) {
+	s, err := n.arg()
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("line %d: invalid %s %q: must be between %d and %d", n.line, n.keyword, s, lo, hi)
+	}
+	return v, nil
+}
+
+// parseKeepalivedNodes splits the file into statements. Comments start with
+// '#' or '!', blocks are delimited by braces and quoted strings are kept whole.
+func parseKeepalivedNodes(data []byte) ([]*kaNode, error) {
+	root := &kaNode{}
+	stack := []*kaNode{root}
+	var cur *kaNode
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		line := i + 1
+		for _, tok := range tokenize(raw) {
+			parent := stack[len(stack)-1]
+			switch tok {
+			case "{":
+				if cur == nil {
+					// "{" on a line of its own opens a block for the previous statement
+					if len(parent.children) == 0 {
+						return nil, fmt.Errorf("line %d: unexpected '{'", line)
+					}
+					cur = parent.children[len(parent.children)-1]
+				}
+				stack = append(stack, cur)
+				cur = nil
+
+			case "}":
+				if len(stack) == 1 {
+					return nil, fmt.Errorf("line %d: unexpected '}'", line)
+				}
+				stack = stack[:len(stack)-1]
+				cur = nil
+
+			default:
+				if cur == nil {
+					cur = &kaNode{line: line, keyword: tok}
+					parent.children = append(parent.children, cur)
+				} else {
+					cur.args = append(cur.args, tok)
+				}
+			}
+		}
+		cur = nil
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("unterminated block %s", stack[len(stack)-1].keyword)
+	}
+
+	return root.children, nil
+}
+
+func tokenize(line string) []string {
+	var toks []string
+	var b strings.Builder
+	inQuote := false
+
+	flush := func() {
+		if b.Len() > 0 {
+			toks = append(toks, b.String())
+			b.Reset()
+		}
+	}
+
+	for _, r := range line {
+		switch {
+		case inQuote:
+			if r == '"' {
+				inQuote = false
+				toks = append(toks, b.String())
+				b.Reset()
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '"':
+			flush()
+			inQuote = true
+		case r == '#' || r == '!':
+			flush()
+			return toks
+		case r == '{' || r == '}':
+			flush()
+			toks = append(toks, string(r))
+		case r == ' ' || r == '\t' || r == '\r':
+			flush()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	flush()
+
+	return toks
+}
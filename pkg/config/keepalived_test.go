@@ -0,0 +1,128 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleKeepalived = `
+! Configuration File for keepalived
+global_defs {
+   router_id LVS_DEVEL
+}
+
+vrrp_sync_group VG_1 {
+    group {
+        VI_1
+        VI_2 VI_3
+    }
+}
+
+vrrp_script chk_haproxy {
+    script "killall -0 haproxy"   # cheaper than pidof
+    interval 2
+}
+
+vrrp_instance VI_1 {
+    state MASTER
+    interface eth0
+    virtual_router_id 51
+    priority 150
+    advert_int 1
+    authentication {
+        auth_type PASS
+        auth_pass 1111
+    }
+    virtual_ipaddress {
+        192.168.200.16
+        192.168.200.17/24 dev eth0 label eth0:1
+    }
+    track_script {
+        chk_haproxy
+    }
+}
+
+vrrp_instance VI_2
+{
+    interface eth1
+    virtual_router_id 52
+    nopreempt
+    advert_int 0.5
+    virtual_ipaddress {
+        10.0.0.100
+    }
+    virtual_ipaddress_excluded {
+        10.0.1.100
+        10.0.1.101/24
+    }
+}
+`
+
+func TestParseKeepalived(t *testing.T) {
+	f, warnings, err := ParseKeepalived([]byte(sampleKeepalived))
+	if err != nil {
+		t.Fatalf("Failed to parse keepalived config: %v", err)
+	}
+
+	if len(f.Instances) != 2 {
+		t.Fatalf("Expected 2 instances, got %d", len(f.Instances))
+	}
+
+	first := f.Instances[0]
+	if first.Interface != "eth0" || first.VRID != 51 || first.Priority != 150 || first.AdvertInterval != 1 {
+		t.Errorf("Unexpected first instance: %+v", first)
+	}
+	if got := strings.Join(first.VirtualIPs, ","); got != "192.168.200.16,192.168.200.17" {
+		t.Errorf("Unexpected virtual IPs: %s", got)
+	}
+	if !first.PreemptEnabled() {
+		t.Error("Preemption should stay enabled without nopreempt")
+	}
+
+	second := f.Instances[1]
+	if second.Interface != "eth1" || second.VRID != 52 || second.PreemptEnabled() {
+		t.Errorf("Unexpected second instance: %+v", second)
+	}
+	if got := strings.Join(second.ExcludedIPs, ","); got != "10.0.1.100,10.0.1.101" {
+		t.Errorf("Unexpected excluded IPs: %s", got)
+	}
+	if second.AdvertInterval != 1 {
+		t.Errorf("Fractional advert_int should round up to 1, got %d", second.AdvertInterval)
+	}
+	if first.SyncGroup != "VG_1" || second.SyncGroup != "VG_1" {
+		t.Errorf("Both instances should be in sync group VG_1, got %q and %q", first.SyncGroup, second.SyncGroup)
+	}
+
+	for _, want := range []string{"vrrp_script chk_haproxy", "authentication ignored", "track_script", "rounded up",
+		"prefix length and options", "prefix length of 10.0.1.101/24", "unknown vrrp_instance VI_3"} {
+		found := false
+		for _, w := range warnings {
+			if strings.Contains(w, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected a warning containing %q, got %v", want, warnings)
+		}
+	}
+}
+
+func TestParseKeepalivedErrors(t *testing.T) {
+	tests := map[string]string{
+		"No instances":   "global_defs {\n}\n",
+		"Unterminated":   "vrrp_instance VI_1 {\n interface eth0\n",
+		"Stray brace":    "}\n",
+		"Missing vrid":   "vrrp_instance VI_1 {\n interface eth0\n virtual_ipaddress {\n 10.0.0.1\n }\n}\n",
+		"Bad priority":   "vrrp_instance VI_1 {\n priority 300\n}\n",
+		"Missing vips":   "vrrp_instance VI_1 {\n interface eth0\n virtual_router_id 1\n}\n",
+		"Bad advert_int": "vrrp_instance VI_1 {\n advert_int fast\n}\n",
+	}
+
+	for name, conf := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := ParseKeepalived([]byte(conf)); err == nil {
+				t.Error("Expected parse error")
+			}
+		})
+	}
+}
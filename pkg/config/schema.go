@@ -0,0 +1,273 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/route53"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// SchemaDialect is the JSON Schema version Schema follows
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is a JSON Schema, with the keywords Schema uses
+type JSONSchema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+
+	Items    *JSONSchema `json:"items,omitempty"`
+	MinItems *int        `json:"minItems,omitempty"`
+	MaxItems *int        `json:"maxItems,omitempty"`
+
+	Enum    []string `json:"enum,omitempty"`
+	Format  string   `json:"format,omitempty"`
+	Minimum *int     `json:"minimum,omitempty"`
+	Maximum *int     `json:"maximum,omitempty"`
+	Default any      `json:"default,omitempty"`
+}
+
+// schemaHint adds to the schema of a field what its Go type does not say
+type schemaHint struct {
+	description      string
+	enum             []string
+	format           string
+	minimum, maximum *int
+	minItems         *int
+	maxItems         *int
+	def              any
+}
+
+func intp(n int) *int { return &n }
+
+// names lists the values of a string enumeration
+func names[T ~string](values []T) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = string(v)
+	}
+	return out
+}
+
+// schemaHints are by the path of the field's JSON names, "instances" being
+// the path of the items of the instances array too. Every field has one, so
+// that the schema documents the whole file; a test checks it.
+var schemaHints = map[string]schemaHint{
+	"instances": {description: "The virtual routers to run, besides those of active_active"},
+	"instances.interface": {
+		description: "Network interface the instance advertises on and adds the virtual IPs to",
+	},
+	"instances.vrid": {description: "Virtual Router ID, shared by the routers of the group", minimum: intp(1)},
+	"instances.priority": {
+		description: "Priority in the election; 255 is the owner of the virtual IPs",
+		minimum:     intp(1),
+		def:         DefaultPriority,
+	},
+	"instances.virtual_ips": {
+		description: "IPv4 addresses held by the master and advertised",
+		format:      "ipv4",
+		minItems:    intp(1),
+		maxItems:    intp(vrrp.MaxVirtualIPs),
+	},
+	"instances.advert_interval": {
+		description: "Advertisement interval in seconds",
+		minimum:     intp(1),
+		maximum:     intp(255),
+		def:         DefaultAdvertInterval,
+	},
+	"instances.preempt": {description: "Whether a higher priority router takes over from the master", def: true},
+	"instances.preempt_windows": {
+		description: "Weekly periods preemption is restricted to, on the host's clock, e.g. " +
+			"\"Mon-Fri 02:00-04:00\"; empty preempts at any time",
+	},
+	"instances.excluded_ips": {
+		description: "IPv4 addresses held with the virtual IPs while master but not advertised",
+		format:      "ipv4",
+	},
+	"instances.sync_group": {description: "Name of a group of instances that fail over together"},
+	"instances.backup_only": {
+		description: "Never become master, whatever the priority; report the master being lost instead",
+	},
+	"instances.observe": {
+		description: "Follow the election without taking part: never advertise or program the virtual IPs",
+	},
+	"instances.address_backend": {
+		description: "How the virtual IPs are programmed",
+		enum:        names(vrrp.AddressBackends),
+		def:         string(vrrp.AddressNetlink),
+	},
+	"instances.detect_vip_conflicts": {
+		description: "Probe the virtual IPs with ARP while master and report a split brain when another " +
+			"host answers",
+	},
+	"instances.on_link_check": {
+		description: "What to do with advertisements from sources outside the interface's subnets",
+		enum:        names(vrrp.OnLinkChecks),
+		def:         string(vrrp.OnLinkOff),
+	},
+	"instances.interval_check": {
+		description: "What to do with advertisements of another interval; enforce drops the VRRPv2 ones",
+		enum:        names(vrrp.IntervalChecks),
+		def:         string(vrrp.IntervalCount),
+	},
+	"instances.version_policy": {
+		description: "What to do with VRRPv3 advertisements; translate also sends VRRPv3",
+		enum:        names(vrrp.VersionPolicies),
+		def:         string(vrrp.VersionPrefer),
+	},
+	"instances.address_check": {
+		description: "What to do with advertisements of other virtual IPs; adopt reports the master's list",
+		enum:        names(vrrp.AddressChecks),
+		def:         string(vrrp.AddressWarn),
+	},
+	"instances.garp": {
+		description: "Forms of gratuitous ARP sent for each virtual IP on becoming master",
+		enum:        names(vrrp.GARPModes),
+		def:         string(vrrp.GARPBoth),
+	},
+	"instances.allowed_peers": {
+		description: "Addresses and CIDR prefixes advertisements are accepted from; empty accepts any",
+	},
+	"instances.auth_keys": {
+		description: "Keys of authenticated advertisements, \"ID:KEY\" each, the first signing",
+	},
+	"instances.trackers": {
+		description: "Health checks lowering the priority while they fail, e.g. " +
+			"\"dns:query=example.com,server=127.0.0.1\"",
+	},
+	"instances.route53": {
+		description: "Route 53 record pointed at this host each time the instance becomes master",
+	},
+	"instances.route53.hosted_zone_id": {description: "Hosted zone holding the record, e.g. Z0123456789ABC"},
+	"instances.route53.name":           {description: "Domain name of the record, e.g. app.example.com"},
+	"instances.route53.type": {
+		description: "Record type",
+		enum:        []string{"A", "AAAA"},
+		def:         "A",
+	},
+	"instances.route53.value": {description: "Address of this host as the record should hold it"},
+	"instances.route53.ttl": {
+		description: "TTL of the record in seconds",
+		minimum:     intp(0),
+		def:         route53.DefaultTTL,
+	},
+	"instances.route53.access_key_id": {
+		description: "AWS access key; AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are used if empty",
+	},
+	"instances.route53.secret_access_key": {description: "AWS secret key of access_key_id"},
+	"instances.chaos": {
+		description: "Faults injected into received advertisements, for testing, e.g. \"drop=0.2,jitter=50ms\"",
+	},
+	"active_active": {
+		description: "Pairs of instances on consecutive VRIDs splitting virtual IPs between two nodes, " +
+			"each the master of one and the backup of the other",
+	},
+	"active_active.name":      {description: "Name of the pair, shown by vrrp status"},
+	"active_active.interface": {description: "Network interface of both instances"},
+	"active_active.vrid": {
+		description: "VRID of the first instance, mastered by the first node; the second uses vrid+1",
+		minimum:     intp(1),
+		maximum:     intp(254),
+	},
+	"active_active.virtual_ips": {
+		description: "IPv4 addresses dealt in turn to the first and second instance",
+		format:      "ipv4",
+		minItems:    intp(2),
+	},
+	"active_active.nodes": {
+		description: "The two nodes, the first the master of vrid and the second of vrid+1",
+		minItems:    intp(2),
+		maxItems:    intp(2),
+	},
+	"active_active.node": {description: "The node this file is for; the host name if empty"},
+	"active_active.priority": {
+		description: "Priority of a node on the instance it is the master of",
+		minimum:     intp(1),
+		def:         DefaultActiveActivePriority,
+	},
+	"active_active.backup_priority": {
+		description: "Priority of a node on the instance it backs up, below priority",
+		minimum:     intp(1),
+		def:         DefaultPriority,
+	},
+	"active_active.advert_interval": {
+		description: "Advertisement interval in seconds of both instances",
+		minimum:     intp(1),
+		maximum:     intp(255),
+		def:         DefaultAdvertInterval,
+	},
+}
+
+// Schema returns the JSON Schema of the configuration file, generated from
+// File so that it follows the fields Parse accepts: unknown properties are
+// refused like there, and fields without omitempty are required.
+func Schema() *JSONSchema {
+	s := schemaOf(reflect.TypeFor[File](), "")
+	s.Schema = SchemaDialect
+	s.Title = "vrrp-simple configuration"
+	s.Description = "Configuration file of vrrp run --config"
+	return s
+}
+
+func schemaOf(t reflect.Type, path string) *JSONSchema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	s := &JSONSchema{}
+	hint := schemaHints[path]
+	switch t.Kind() {
+	case reflect.Struct:
+		s.Type = "object"
+		s.Properties = make(map[string]*JSONSchema)
+		s.AdditionalProperties = new(bool)
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" || name == "" {
+				continue
+			}
+			s.Properties[name] = schemaOf(field.Type, strings.TrimPrefix(path+"."+name, "."))
+			if !strings.Contains(opts, "omitempty") {
+				s.Required = append(s.Required, name)
+			}
+		}
+	case reflect.Slice:
+		s.Type = "array"
+		s.Items = schemaOf(t.Elem(), path)
+		// The hint of the path is for the array; only its format is for the
+		// items
+		s.Items.Description, s.Items.Format = "", hint.format
+		s.Items.MinItems, s.Items.MaxItems, s.Items.Default = nil, nil, nil
+		s.MinItems, s.MaxItems = hint.minItems, hint.maxItems
+		s.Description = hint.description
+		return s
+	case reflect.String:
+		s.Type = "string"
+		s.Enum = hint.enum
+		s.Format = hint.format
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Uint8:
+		s.Type = "integer"
+		s.Minimum, s.Maximum = intp(0), intp(255)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		s.Type = "integer"
+	}
+
+	s.Description = hint.description
+	if hint.minimum != nil {
+		s.Minimum = hint.minimum
+	}
+	if hint.maximum != nil {
+		s.Maximum = hint.maximum
+	}
+	s.Default = hint.def
+	return s
+}
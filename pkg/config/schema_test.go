@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// walkSchema calls fn with every property of s and its path
+func walkSchema(s *JSONSchema, path string, fn func(path string, s *JSONSchema)) {
+	if s.Items != nil {
+		walkSchema(s.Items, path, fn)
+	}
+	for name, p := range s.Properties {
+		child := name
+		if path != "" {
+			child = path + "." + name
+		}
+		fn(child, p)
+		walkSchema(p, child, fn)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	s := Schema()
+	if s.Schema != SchemaDialect || s.Type != "object" || s.AdditionalProperties == nil || *s.AdditionalProperties {
+		t.Errorf("root = %+v", s)
+	}
+
+	instance := s.Properties["instances"].Items
+	for _, name := range []string{"interface", "vrid", "virtual_ips"} {
+		if !slices.Contains(instance.Required, name) {
+			t.Errorf("%s not required in %v", name, instance.Required)
+		}
+	}
+	if slices.Contains(instance.Required, "priority") {
+		t.Error("priority, which has a default, is required")
+	}
+	if p := instance.Properties["priority"]; p.Type != "integer" || *p.Minimum != 1 || *p.Maximum != 255 ||
+		p.Default != DefaultPriority {
+		t.Errorf("priority = %+v", p)
+	}
+	if p := instance.Properties["virtual_ips"]; p.Type != "array" || p.Items.Format != "ipv4" || *p.MinItems != 1 {
+		t.Errorf("virtual_ips = %+v", p)
+	}
+	if p := instance.Properties["garp"]; !slices.Equal(p.Enum, []string{"both", "request", "reply", "off"}) {
+		t.Errorf("garp enum = %v", p.Enum)
+	}
+	if p := instance.Properties["route53"]; p.Type != "object" || !slices.Contains(p.Required, "hosted_zone_id") {
+		t.Errorf("route53 = %+v", p)
+	}
+
+	// Every field is documented, and has a hint that is used
+	seen := make(map[string]bool)
+	walkSchema(s, "", func(path string, p *JSONSchema) {
+		seen[path] = true
+		if p.Description == "" {
+			t.Errorf("%s has no description", path)
+		}
+	})
+	for path := range schemaHints {
+		if !seen[path] {
+			t.Errorf("hint for %s, which is no field", path)
+		}
+	}
+
+	if _, err := json.Marshal(s); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Validate checks the configuration for mistakes that would make the daemon
+// fail or misbehave: VRID and advertisement interval ranges, VIP syntax and
+// instances sharing an interface/VRID pair or a VIP, and active_active pairs
+// that do not name this node. All problems are returned, not just the first.
+func (f *File) Validate() []error {
+	var errs []error
+	keys := make(map[string]int)
+	vips := make(map[string]int)
+
+	names := make(map[string]int)
+	for i := range f.ActiveActive {
+		aa := &f.ActiveActive[i]
+		errs = append(errs, aa.validate(i)...)
+		if prev, ok := names[aa.Name]; ok && aa.Name != "" {
+			errs = append(errs, fmt.Errorf("active_active[%d] (%s): name %q already used by active_active[%d]",
+				i, aa.Key(), aa.Name, prev))
+		} else {
+			names[aa.Name] = i
+		}
+	}
+
+	for i := range f.Instances {
+		in := &f.Instances[i]
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("%s (%s): %s", in.Where(i), in.Key(), fmt.Sprintf(format, args...)))
+		}
+
+		if in.Interface == "" {
+			fail("interface is required")
+		}
+		if in.VRID == 0 {
+			fail("vrid must be between 1 and 255")
+		}
+		if in.Priority == 0 {
+			fail("priority must be between 1 and 255")
+		}
+		if in.AdvertInterval < 1 || in.AdvertInterval > 255 {
+			fail("advert_interval %d must be between 1 and 255 seconds", in.AdvertInterval)
+		}
+		if in.Observe && in.SyncGroup != "" {
+			fail("an observer cannot be in sync_group %q", in.SyncGroup)
+		}
+		if in.BackupOnly && in.SyncGroup != "" {
+			fail("a backup-only instance cannot be in sync_group %q", in.SyncGroup)
+		}
+
+		if !validAddressBackend(in.AddressBackend) {
+			fail("address_backend %q must be one of %s", in.AddressBackend, addressBackendNames())
+		}
+		if _, err := vrrp.ParseChaos(in.Chaos); err != nil {
+			fail("%v", err)
+		}
+		if !validOnLinkCheck(in.OnLinkCheck) {
+			fail("on_link_check %q must be one of %s", in.OnLinkCheck, onLinkCheckNames())
+		}
+		if !validIntervalCheck(in.IntervalCheck) {
+			fail("interval_check %q must be one of %s", in.IntervalCheck, intervalCheckNames())
+		}
+		if !validVersionPolicy(in.VersionPolicy) {
+			fail("version_policy %q must be one of %s", in.VersionPolicy, versionPolicyNames())
+		}
+		if !validAddressCheck(in.AddressCheck) {
+			fail("address_check %q must be one of %s", in.AddressCheck, addressCheckNames())
+		}
+		if !validGARPMode(in.GARP) {
+			fail("garp %q must be one of %s", in.GARP, garpModeNames())
+		}
+		if _, err := vrrp.ParsePreemptWindows(in.PreemptWindows); err != nil {
+			fail("%v", err)
+		}
+		if _, err := vrrp.ParseAllowedPeers(in.AllowedPeers); err != nil {
+			fail("%v", err)
+		}
+		if _, err := vrrp.ParseAuthKeys(in.AuthKeys); err != nil {
+			fail("%v", err)
+		}
+		if _, err := vrrp.ParseTrackers(in.Trackers); err != nil {
+			fail("%v", err)
+		}
+		if in.Route53 != nil {
+			if err := in.Route53.Validate(); err != nil {
+				fail("%v", err)
+			}
+		}
+
+		if prev, ok := keys[in.Key()]; ok {
+			fail("interface and vrid already used by %s", f.Instances[prev].Where(prev))
+		} else {
+			keys[in.Key()] = i
+		}
+
+		if len(in.VirtualIPs) == 0 {
+			fail("at least one virtual IP is required")
+		}
+		if len(in.VirtualIPs) > vrrp.MaxVirtualIPs {
+			fail("%d virtual IPs do not fit an advertisement, which carries %d; list the rest in excluded_ips",
+				len(in.VirtualIPs), vrrp.MaxVirtualIPs)
+		}
+		checkIP := func(kind, vip string) net.IP {
+			ip := net.ParseIP(vip)
+			switch {
+			case ip == nil:
+				fail("invalid %s %q", kind, vip)
+				return nil
+			case ip.To4() == nil:
+				fail("%s %s is not IPv4", kind, vip)
+				return nil
+			}
+
+			if prev, ok := vips[ip.String()]; ok && prev != i {
+				fail("%s %s already used by %s", kind, vip, f.Instances[prev].Where(prev))
+			} else {
+				vips[ip.String()] = i
+			}
+			return ip
+		}
+		for _, vip := range in.VirtualIPs {
+			checkIP("virtual IP", vip)
+		}
+		for _, addr := range in.ExcludedIPs {
+			ip := checkIP("excluded IP", addr)
+			if ip == nil {
+				continue
+			}
+			if slices.ContainsFunc(in.VirtualIPs, func(vip string) bool { return ip.Equal(net.ParseIP(vip)) }) {
+				fail("excluded IP %s is also a virtual IP", addr)
+			}
+		}
+	}
+
+	return errs
+}
+
+func validAddressBackend(name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, b := range vrrp.AddressBackends {
+		if string(b) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func addressBackendNames() string {
+	names := make([]string, len(vrrp.AddressBackends))
+	for i, b := range vrrp.AddressBackends {
+		names[i] = string(b)
+	}
+	return strings.Join(names, ", ")
+}
+
+func validOnLinkCheck(name string) bool {
+	return name == "" || slices.Contains(vrrp.OnLinkChecks, vrrp.OnLinkCheck(name))
+}
+
+func onLinkCheckNames() string {
+	names := make([]string, len(vrrp.OnLinkChecks))
+	for i, c := range vrrp.OnLinkChecks {
+		names[i] = string(c)
+	}
+	return strings.Join(names, ", ")
+}
+
+func validIntervalCheck(name string) bool {
+	return name == "" || slices.Contains(vrrp.IntervalChecks, vrrp.IntervalCheck(name))
+}
+
+func intervalCheckNames() string {
+	names := make([]string, len(vrrp.IntervalChecks))
+	for i, c := range vrrp.IntervalChecks {
+		names[i] = string(c)
+	}
+	return strings.Join(names, ", ")
+}
+
+func validVersionPolicy(name string) bool {
+	return name == "" || slices.Contains(vrrp.VersionPolicies, vrrp.VersionPolicy(name))
+}
+
+func versionPolicyNames() string {
+	names := make([]string, len(vrrp.VersionPolicies))
+	for i, p := range vrrp.VersionPolicies {
+		names[i] = string(p)
+	}
+	return strings.Join(names, ", ")
+}
+
+func validAddressCheck(name string) bool {
+	return name == "" || slices.Contains(vrrp.AddressChecks, vrrp.AddressCheck(name))
+}
+
+func addressCheckNames() string {
+	names := make([]string, len(vrrp.AddressChecks))
+	for i, c := range vrrp.AddressChecks {
+		names[i] = string(c)
+	}
+	return strings.Join(names, ", ")
+}
+
+func validGARPMode(name string) bool {
+	return name == "" || slices.Contains(vrrp.GARPModes, vrrp.GARPMode(name))
+}
+
+func garpModeNames() string {
+	names := make([]string, len(vrrp.GARPModes))
+	for i, m := range vrrp.GARPModes {
+		names[i] = string(m)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,44 @@
+package control
+
+// The messages of the gRPC and REST admin APIs. They are plain structs
+// encoded as JSON by both, so the REST API stays available in a binary built
+// without gRPC (-tags small).
+
+// InstanceSelector picks instances by interface and VRID; zero values match everything
+type InstanceSelector struct {
+	Interface string `json:"interface,omitempty"`
+	VRID      uint8  `json:"vrid,omitempty"`
+}
+
+type ListInstancesRequest struct {
+	InstanceSelector
+}
+
+type ListInstancesResponse struct {
+	Instances []InstanceStatus `json:"instances"`
+}
+
+type GetStatusRequest struct {
+	InstanceSelector
+}
+
+type WatchRequest struct {
+	InstanceSelector
+}
+
+type SetPriorityRequest struct {
+	InstanceSelector
+	Priority uint8 `json:"priority"`
+}
+
+type FailoverRequest struct {
+	InstanceSelector
+	HoldSeconds int `json:"hold_seconds,omitempty"`
+}
+
+type ReloadRequest struct{}
+
+// ActionResponse is returned by RPCs that change daemon behavior
+type ActionResponse struct {
+	Message string `json:"message,omitempty"`
+}
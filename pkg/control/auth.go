@@ -0,0 +1,229 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Transports a request can arrive on, in Caller.Transport
+const (
+	TransportSocket = "socket"
+	TransportGRPC   = "grpc"
+	TransportHTTP   = "http"
+)
+
+// MinTokenLen is the shortest API token ParseTokens accepts
+const MinTokenLen = 16
+
+// Errors of requests a Policy refuses, mapped to status codes by the gRPC and
+// HTTP APIs
+var (
+	errUnauthenticated = errors.New("unauthenticated")
+	errPermission      = errors.New("permission denied")
+)
+
+// IsDenied reports whether err is the refusal of a request by the Policy
+func IsDenied(err error) bool {
+	return errors.Is(err, errUnauthenticated) || errors.Is(err, errPermission)
+}
+
+// PeerCred is the credentials of the process at the other end of a control
+// socket connection
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// Caller identifies who sent a request, for the Policy and the audit log
+type Caller struct {
+	// Transport is TransportSocket, TransportGRPC or TransportHTTP
+	Transport string
+	// Cred is set for a control socket connection, unless the kernel did
+	// not tell
+	Cred *PeerCred
+	// Addr is the remote address of an API client
+	Addr string
+	// Cert is the name in the client certificate an API client connected
+	// with over mutual TLS, if any
+	Cert string
+	// Token is the name of the API token the client presented, if any
+	Token string
+
+	// invalid is why the client's credentials were refused, so any request
+	// it sends fails
+	invalid error
+}
+
+func (c *Caller) String() string {
+	var b strings.Builder
+	b.WriteString(c.Transport)
+	if c.Cred != nil {
+		fmt.Fprintf(&b, " uid=%d gid=%d pid=%d", c.Cred.UID, c.Cred.GID, c.Cred.PID)
+	}
+	if c.Addr != "" {
+		b.WriteString(" addr=" + c.Addr)
+	}
+	if c.Cert != "" {
+		b.WriteString(" cert=" + c.Cert)
+	}
+	if c.Token != "" {
+		b.WriteString(" token=" + c.Token)
+	}
+	return b.String()
+}
+
+// Policy says who may send the requests that change the daemon's behavior
+// (see Request.Mutating). Reading status and statistics is always allowed.
+type Policy struct {
+	// UIDs and GIDs are the users and groups, besides root and the daemon's
+	// own user, allowed to change the daemon through the control socket; a
+	// user qualifies by its supplementary groups too. With neither set,
+	// any peer the socket's permissions let in is allowed.
+	UIDs []uint32
+	GIDs []uint32
+
+	// Tokens maps the bearer tokens the gRPC and REST APIs accept to the
+	// names the audit log shows instead. With none, any API client is
+	// allowed. A client with a verified TLS client certificate needs no
+	// token.
+	Tokens map[string]string
+}
+
+// apiToken is a token of a Policy, kept as a digest so comparing it takes
+// the same time whatever a client sends
+type apiToken struct {
+	name string
+	sum  [sha256.Size]byte
+}
+
+// ParseTokens parses an API token file: one "NAME:TOKEN" per line, blank
+// lines and lines starting with # ignored
+func ParseTokens(data []byte) (map[string]string, error) {
+	tokens := make(map[string]string)
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, token, ok := strings.Cut(line, ":")
+		switch {
+		case !ok || name == "":
+			return nil, fmt.Errorf("line %d: must be NAME:TOKEN", n)
+		case len(token) < MinTokenLen:
+			return nil, fmt.Errorf("line %d: token %s is shorter than %d characters", n, name, MinTokenLen)
+		case names[name]:
+			return nil, fmt.Errorf("line %d: duplicate token name %s", n, name)
+		case tokens[token] != "":
+			return nil, fmt.Errorf("line %d: token %s is the same as token %s", n, name, tokens[token])
+		}
+		names[name] = true
+		tokens[token] = name
+	}
+	return tokens, scanner.Err()
+}
+
+// SetPolicy restricts the requests that change the daemon's behavior to the
+// callers p allows. It must be called before Start.
+func (s *Server) SetPolicy(p Policy) {
+	s.policy = p
+	s.tokens = nil
+	for token, name := range p.Tokens {
+		s.tokens = append(s.tokens, apiToken{name: name, sum: sha256.Sum256([]byte(token))})
+	}
+}
+
+// SetAuditor registers fn to be called with the outcome of every request
+// that changes the daemon's behavior, including those the Policy refuses.
+// It must be called before Start.
+func (s *Server) SetAuditor(fn func(c *Caller, req *Request, err error)) {
+	s.auditor = fn
+}
+
+// apiCaller identifies an API client by its address, its TLS connection and
+// the token it presented, if any. A token that is not valid fails every
+// request, to be audited if it would change the daemon.
+func (s *Server) apiCaller(transport, addr string, cs *tls.ConnectionState, token string) *Caller {
+	c := &Caller{Transport: transport, Addr: addr, Cert: certName(cs)}
+	if token == "" {
+		return c
+	}
+	sum := sha256.Sum256([]byte(token))
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(sum[:], t.sum[:]) == 1 {
+			c.Token = t.name
+		}
+	}
+	if c.Token == "" {
+		c.invalid = fmt.Errorf("%w: invalid API token", errUnauthenticated)
+	}
+	return c
+}
+
+// authorize reports whether c may send a request that changes the daemon's
+// behavior
+func (s *Server) authorize(c *Caller) error {
+	if c.Transport != TransportSocket {
+		if len(s.tokens) == 0 || c.Token != "" || c.Cert != "" {
+			return nil
+		}
+		return fmt.Errorf("%w: an API token is required", errUnauthenticated)
+	}
+
+	if len(s.policy.UIDs) == 0 && len(s.policy.GIDs) == 0 {
+		return nil
+	}
+	if c.Cred == nil {
+		return fmt.Errorf("%w: the caller's credentials are unknown", errPermission)
+	}
+	uid := c.Cred.UID
+	if uid == 0 || uid == uint32(os.Geteuid()) || slices.Contains(s.policy.UIDs, uid) ||
+		slices.Contains(s.policy.GIDs, c.Cred.GID) {
+		return nil
+	}
+	if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+		groups, _ := u.GroupIds()
+		for _, g := range groups {
+			gid, err := strconv.ParseUint(g, 10, 32)
+			if err == nil && slices.Contains(s.policy.GIDs, uint32(gid)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: uid %d may not change the daemon", errPermission, uid)
+}
+
+// peerCred returns the credentials of the process connected to conn
+func peerCred(conn net.Conn) *PeerCred {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *unix.Ucred
+	_ = raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return nil
+	}
+	return &PeerCred{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}
+}
@@ -0,0 +1,178 @@
+package control
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testToken = "0123456789abcdef0123"
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := ParseTokens([]byte("# operators\nops:" + testToken + "\n\n  ci:fedcba9876543210  \n"))
+	if err != nil {
+		t.Fatalf("ParseTokens: %v", err)
+	}
+	if len(tokens) != 2 || tokens[testToken] != "ops" || tokens["fedcba9876543210"] != "ci" {
+		t.Errorf("ParseTokens = %v", tokens)
+	}
+
+	for _, bad := range []string{
+		testToken,
+		":" + testToken,
+		"ops:short",
+		"ops:" + testToken + "\nops:fedcba9876543210",
+		"ops:" + testToken + "\nci:" + testToken,
+	} {
+		if _, err := ParseTokens([]byte(bad)); err == nil {
+			t.Errorf("ParseTokens(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRequestMutating(t *testing.T) {
+	for req, want := range map[Request]bool{
+		{Command: CommandStatus}:                   false,
+		{Command: CommandStats}:                    false,
+		{Command: CommandStats, Reset: true}:       true,
+		{Command: CommandSetPriority}:              true,
+		{Command: CommandSet, Priority: 10}:        true,
+		{Command: CommandFailover}:                 true,
+		{Command: CommandReload}:                   true,
+		{Command: CommandStatus, VRID: 10}:         false,
+		{Command: CommandFailover, VRID: 10}:       true,
+		{Command: CommandStats, Interface: "eth0"}: false,
+	} {
+		if got := req.Mutating(); got != want {
+			t.Errorf("%+v Mutating = %v, want %v", req, got, want)
+		}
+	}
+}
+
+func TestSocketPolicy(t *testing.T) {
+	srv := NewServer("")
+	srv.SetPolicy(Policy{UIDs: []uint32{1001}, GIDs: []uint32{2001}})
+	srv.Handle(CommandStatus, func(*Request) (*Response, error) { return &Response{}, nil })
+	srv.Handle(CommandReload, func(*Request) (*Response, error) { return &Response{Message: "reloaded"}, nil })
+
+	var audited []error
+	srv.SetAuditor(func(_ *Caller, req *Request, err error) {
+		if req.Command != CommandReload {
+			t.Errorf("audited %s", req.Command)
+		}
+		audited = append(audited, err)
+	})
+
+	socket := func(uid, gid uint32) *Caller {
+		return &Caller{Transport: TransportSocket, Cred: &PeerCred{PID: 1, UID: uid, GID: gid}}
+	}
+	for _, tc := range []struct {
+		name   string
+		caller *Caller
+		ok     bool
+	}{
+		{"root", socket(0, 0), true},
+		{"the daemon's user", socket(uint32(os.Geteuid()), 0), true},
+		{"a listed user", socket(1001, 100), true},
+		{"a listed group", socket(1500, 2001), true},
+		{"another user", socket(1500, 100), false},
+		{"unknown credentials", &Caller{Transport: TransportSocket}, false},
+	} {
+		resp := srv.dispatch(tc.caller, &Request{Command: CommandReload})
+		if ok := resp.Error == ""; ok != tc.ok {
+			t.Errorf("reload from %s: %+v, want allowed %v", tc.name, resp, tc.ok)
+		}
+		// Reading the status is always allowed
+		if resp := srv.dispatch(tc.caller, &Request{Command: CommandStatus}); resp.Error != "" {
+			t.Errorf("status from %s: %s", tc.name, resp.Error)
+		}
+	}
+
+	if len(audited) != 6 {
+		t.Fatalf("audited %d requests, want 6", len(audited))
+	}
+	for i, err := range audited {
+		if denied := IsDenied(err); denied != (i >= 4) {
+			t.Errorf("audited request %d with %v", i, err)
+		}
+	}
+}
+
+func TestSocketPeerCred(t *testing.T) {
+	srv, client := startTestServer(t)
+	srv.SetPolicy(Policy{UIDs: []uint32{1001}})
+	srv.Handle(CommandFailover, func(*Request) (*Response, error) { return &Response{}, nil })
+
+	var caller *Caller
+	srv.SetAuditor(func(c *Caller, _ *Request, _ error) { caller = c })
+
+	// This process runs as the daemon's user, which is always allowed
+	if _, err := client.Do(&Request{Command: CommandFailover}); err != nil {
+		t.Fatalf("failover: %v", err)
+	}
+	if caller == nil || caller.Cred == nil || caller.Cred.UID != uint32(os.Geteuid()) ||
+		caller.Cred.PID != int32(os.Getpid()) {
+		t.Errorf("caller = %+v, want this process", caller)
+	}
+}
+
+func TestHTTPTokens(t *testing.T) {
+	ctrl := NewServer("")
+	ctrl.SetPolicy(Policy{Tokens: map[string]string{testToken: "ops"}})
+	ctrl.Handle(CommandStatus, func(*Request) (*Response, error) { return &Response{}, nil })
+	ctrl.Handle(CommandReload, func(*Request) (*Response, error) { return &Response{}, nil })
+
+	var callers []*Caller
+	var errs []error
+	ctrl.SetAuditor(func(c *Caller, _ *Request, err error) {
+		callers = append(callers, c)
+		errs = append(errs, err)
+	})
+
+	ts := httptest.NewServer(NewHTTPServer(ctrl).Handler())
+	t.Cleanup(ts.Close)
+
+	do := func(method, path, token string) int {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(""))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, tc := range []struct {
+		method, path, token string
+		code                int
+	}{
+		{"GET", "/v1/instances", "", http.StatusOK},
+		{"GET", "/v1/instances", "wrong-token-0000000", http.StatusUnauthorized},
+		{"POST", "/v1/reload", "", http.StatusUnauthorized},
+		{"POST", "/v1/reload", "wrong-token-0000000", http.StatusUnauthorized},
+		{"POST", "/v1/reload", testToken, http.StatusOK},
+	} {
+		if code := do(tc.method, tc.path, tc.token); code != tc.code {
+			t.Errorf("%s %s with token %q = %d, want %d", tc.method, tc.path, tc.token, code, tc.code)
+		}
+	}
+
+	// The reloads are audited, refused or not
+	if len(callers) != 3 {
+		t.Fatalf("audited %d requests, want 3", len(callers))
+	}
+	for i, err := range errs[:2] {
+		if !errors.Is(err, errUnauthenticated) || callers[i].Token != "" {
+			t.Errorf("request %d without a valid token audited as %+v, %v", i, callers[i], err)
+		}
+	}
+	if errs[2] != nil || callers[2].Token != "ops" || callers[2].Transport != TransportHTTP || callers[2].Addr == "" {
+		t.Errorf("request with a token audited as %+v, %v", callers[2], errs[2])
+	}
+}
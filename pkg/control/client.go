@@ -0,0 +1,108 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Client sends requests to a running daemon's control socket
+type Client struct {
+	path    string
+	timeout time.Duration
+}
+
+// NewClient creates a client for the control socket at path
+func NewClient(path string) *Client {
+	return &Client{
+		path:    path,
+		timeout: 5 * time.Second,
+	}
+}
+
+// Do sends req and waits for the response. Errors reported by the daemon are
+// returned as a non-nil error.
+func (c *Client) Do(req *Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", c.path, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to control socket %s (is the daemon running?): %w", c.path, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// Stream sends req, a CommandLogs request, and passes each response to fn
+// until the daemon closes the connection or fn fails. Only the first
+// response is waited for with a timeout: with Follow, the next ones come
+// whenever the daemon logs.
+func (c *Client) Stream(req *Request, fn func(*Response) error) error {
+	conn, err := net.DialTimeout("unix", c.path, c.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to control socket %s (is the daemon running?): %w", c.path, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	for first := true; ; first = false {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if first {
+				return fmt.Errorf("failed to read response: %w", err)
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		if first {
+			if err := conn.SetDeadline(time.Time{}); err != nil {
+				return err
+			}
+		}
+		if err := fn(&resp); err != nil {
+			return err
+		}
+	}
+}
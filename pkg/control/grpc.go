@@ -0,0 +1,343 @@
+//go:build !small
+// +build !small
+
+package control
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// The admin service is described by hand instead of generated protobuf code:
+// messages are the Go structs below carried with a JSON codec (content-subtype
+// "json"). Any gRPC client can call it by using that codec.
+
+// GRPCServiceName is the fully qualified gRPC service name
+const GRPCServiceName = "vrrp.admin.v1.Admin"
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// GRPCServer exposes the control commands registered on a Server over gRPC
+type GRPCServer struct {
+	ctrl *Server
+	srv  *grpc.Server
+}
+
+// NewGRPCServer creates a gRPC admin server backed by ctrl's handlers
+func NewGRPCServer(ctrl *Server, opts ...grpc.ServerOption) *GRPCServer {
+	g := &GRPCServer{
+		ctrl: ctrl,
+		srv:  grpc.NewServer(opts...),
+	}
+	g.srv.RegisterService(&adminServiceDesc, g)
+	return g
+}
+
+// Start listens on addr and serves in the background
+func (g *GRPCServer) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	go func() { _ = g.Serve(ln) }()
+	return nil
+}
+
+// Serve accepts connections on ln until Close is called
+func (g *GRPCServer) Serve(ln net.Listener) error {
+	return g.srv.Serve(ln)
+}
+
+// Close stops the server, ending open Watch streams
+func (g *GRPCServer) Close() {
+	g.srv.Stop()
+}
+
+// caller identifies the client of a call by its address, its TLS client
+// certificate and the token in its "authorization" metadata, "Bearer TOKEN"
+// (see Policy)
+func (g *GRPCServer) caller(ctx context.Context) *Caller {
+	var addr, token string
+	var cs *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			cs = &info.State
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			token, _ = strings.CutPrefix(v[0], "Bearer ")
+		}
+	}
+	return g.ctrl.apiCaller(TransportGRPC, addr, cs, token)
+}
+
+func (g *GRPCServer) do(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := g.ctrl.call(g.caller(ctx), req)
+	return resp, grpcError(err)
+}
+
+func grpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errUnsupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, errNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, errPermission):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+}
+
+func (g *GRPCServer) listInstances(ctx context.Context, req *ListInstancesRequest) (*ListInstancesResponse, error) {
+	resp, err := g.do(ctx, &Request{Command: CommandStatus, Interface: req.Interface, VRID: req.VRID})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ListInstancesResponse{Instances: resp.Instances}
+	if out.Instances == nil {
+		out.Instances = []InstanceStatus{}
+	}
+	return out, nil
+}
+
+func (g *GRPCServer) getStatus(ctx context.Context, req *GetStatusRequest) (*InstanceStatus, error) {
+	is, err := g.ctrl.lookup(g.caller(ctx), req.Interface, req.VRID)
+	return is, grpcError(err)
+}
+
+func (g *GRPCServer) setPriority(ctx context.Context, req *SetPriorityRequest) (*ActionResponse, error) {
+	resp, err := g.do(ctx, &Request{
+		Command:   CommandSetPriority,
+		Interface: req.Interface,
+		VRID:      req.VRID,
+		Priority:  req.Priority,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ActionResponse{Message: resp.Message}, nil
+}
+
+func (g *GRPCServer) failover(ctx context.Context, req *FailoverRequest) (*ActionResponse, error) {
+	resp, err := g.do(ctx, &Request{
+		Command:     CommandFailover,
+		Interface:   req.Interface,
+		VRID:        req.VRID,
+		HoldSeconds: req.HoldSeconds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ActionResponse{Message: resp.Message}, nil
+}
+
+func (g *GRPCServer) reload(ctx context.Context, _ *ReloadRequest) (*ActionResponse, error) {
+	resp, err := g.do(ctx, &Request{Command: CommandReload})
+	if err != nil {
+		return nil, err
+	}
+	return &ActionResponse{Message: resp.Message}, nil
+}
+
+func (g *GRPCServer) watch(req *WatchRequest, stream grpc.ServerStream) error {
+	if err := g.caller(stream.Context()).invalid; err != nil {
+		return grpcError(err)
+	}
+	events, cancel := g.ctrl.Subscribe()
+	defer cancel()
+
+	filter := Request{Interface: req.Interface, VRID: req.VRID}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-events:
+			if !filter.Matches(ev.Interface, ev.VRID) {
+				continue
+			}
+			if err := stream.SendMsg(&ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func unaryHandler[Req, Resp any](method string,
+	fn func(g *GRPCServer, ctx context.Context, req *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv any, ctx context.Context, dec func(any) error,
+			interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			g := srv.(*GRPCServer)
+			if interceptor == nil {
+				return fn(g, ctx, req)
+			}
+
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + GRPCServiceName + "/" + method}
+			return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return fn(g, ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: GRPCServiceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("ListInstances", (*GRPCServer).listInstances),
+		unaryHandler("GetStatus", (*GRPCServer).getStatus),
+		unaryHandler("SetPriority", (*GRPCServer).setPriority),
+		unaryHandler("Failover", (*GRPCServer).failover),
+		unaryHandler("Reload", (*GRPCServer).reload),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				req := new(WatchRequest)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(*GRPCServer).watch(req, stream)
+			},
+		},
+	},
+}
+
+// GRPCClient is a typed client for the gRPC admin service
+type GRPCClient struct {
+	conn *grpc.ClientConn
+}
+
+// NewGRPCClient connects to the admin service at target. Without options the
+// connection is unencrypted.
+func NewGRPCClient(target string, opts ...grpc.DialOption) (*GRPCClient, error) {
+	if len(opts) == 0 {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	opts = append(opts, grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name())))
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCClient{conn: conn}, nil
+}
+
+// WithToken makes a GRPCClient present an API token (see Policy). Pass it
+// together with the transport credentials; the token is sent over an
+// unencrypted connection too.
+func WithToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenCredentials(token))
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (tokenCredentials) RequireTransportSecurity() bool { return false }
+
+// Close closes the underlying connection
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *GRPCClient) invoke(ctx context.Context, method string, req, resp any) error {
+	return c.conn.Invoke(ctx, "/"+GRPCServiceName+"/"+method, req, resp)
+}
+
+func (c *GRPCClient) ListInstances(ctx context.Context, req *ListInstancesRequest) (*ListInstancesResponse, error) {
+	resp := new(ListInstancesResponse)
+	return resp, c.invoke(ctx, "ListInstances", req, resp)
+}
+
+func (c *GRPCClient) GetStatus(ctx context.Context, req *GetStatusRequest) (*InstanceStatus, error) {
+	resp := new(InstanceStatus)
+	return resp, c.invoke(ctx, "GetStatus", req, resp)
+}
+
+func (c *GRPCClient) SetPriority(ctx context.Context, req *SetPriorityRequest) (*ActionResponse, error) {
+	resp := new(ActionResponse)
+	return resp, c.invoke(ctx, "SetPriority", req, resp)
+}
+
+func (c *GRPCClient) Failover(ctx context.Context, req *FailoverRequest) (*ActionResponse, error) {
+	resp := new(ActionResponse)
+	return resp, c.invoke(ctx, "Failover", req, resp)
+}
+
+func (c *GRPCClient) Reload(ctx context.Context, req *ReloadRequest) (*ActionResponse, error) {
+	resp := new(ActionResponse)
+	return resp, c.invoke(ctx, "Reload", req, resp)
+}
+
+// Watch streams state events to fn until ctx is canceled or fn returns an error
+func (c *GRPCClient) Watch(ctx context.Context, req *WatchRequest, fn func(*StateEvent) error) error {
+	desc := &adminServiceDesc.Streams[0]
+	stream, err := c.conn.NewStream(ctx, desc, "/"+GRPCServiceName+"/"+desc.StreamName)
+	if err != nil {
+		return err
+	}
+
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		ev := new(StateEvent)
+		if err := stream.RecvMsg(ev); err != nil {
+			if errors.Is(ctx.Err(), context.Canceled) || status.Code(err) == codes.Canceled {
+				return nil
+			}
+			return err
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,171 @@
+//go:build !small
+// +build !small
+
+package control
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startTestGRPC(t *testing.T, ctrl *Server, opts ...grpc.DialOption) *GRPCClient {
+	t.Helper()
+
+	ln := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(ctrl)
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(srv.Close)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	client, err := NewGRPCClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatalf("Failed to create gRPC client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestGRPCListAndStatus(t *testing.T) {
+	ctrl := NewServer("")
+	ctrl.Handle(CommandStatus, func(req *Request) (*Response, error) {
+		resp := &Response{}
+		for _, is := range []InstanceStatus{
+			{Interface: "eth0", VRID: 10, State: "MASTER"},
+			{Interface: "eth1", VRID: 10, State: "BACKUP"},
+			{Interface: "eth1", VRID: 20, State: "BACKUP"},
+		} {
+			if req.Matches(is.Interface, is.VRID) {
+				resp.Instances = append(resp.Instances, is)
+			}
+		}
+		return resp, nil
+	})
+
+	client := startTestGRPC(t, ctrl)
+	ctx := context.Background()
+
+	list, err := client.ListInstances(ctx, &ListInstancesRequest{})
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if len(list.Instances) != 3 {
+		t.Errorf("Expected 3 instances, got %d", len(list.Instances))
+	}
+
+	st, err := client.GetStatus(ctx, &GetStatusRequest{InstanceSelector{VRID: 20}})
+	if err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	if st.Interface != "eth1" || st.State != "BACKUP" {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+
+	_, err = client.GetStatus(ctx, &GetStatusRequest{InstanceSelector{VRID: 10}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Ambiguous VRID should be InvalidArgument, got %v", err)
+	}
+	_, err = client.GetStatus(ctx, &GetStatusRequest{InstanceSelector{VRID: 99}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Unknown VRID should be NotFound, got %v", err)
+	}
+}
+
+func TestGRPCActions(t *testing.T) {
+	ctrl := NewServer("")
+
+	var got Request
+	ctrl.Handle(CommandSetPriority, func(req *Request) (*Response, error) {
+		got = *req
+		return &Response{Message: "ok"}, nil
+	})
+
+	client := startTestGRPC(t, ctrl)
+	ctx := context.Background()
+
+	resp, err := client.SetPriority(ctx, &SetPriorityRequest{InstanceSelector{VRID: 10}, 150})
+	if err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+	if resp.Message != "ok" || got.VRID != 10 || got.Priority != 150 {
+		t.Errorf("Unexpected SetPriority result: resp=%+v req=%+v", resp, got)
+	}
+
+	if _, err := client.Reload(ctx, &ReloadRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("Unregistered command should be Unimplemented, got %v", err)
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	ctrl := NewServer("")
+	client := startTestGRPC(t, ctrl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := make(chan StateEvent, 1)
+	go func() {
+		_ = client.Watch(ctx, &WatchRequest{InstanceSelector{VRID: 10}}, func(ev *StateEvent) error {
+			received <- *ev
+			return nil
+		})
+	}()
+
+	// Publish until the stream has subscribed; events for other VRIDs must be filtered
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-received:
+			if ev.VRID != 10 || ev.NewState != "MASTER" {
+				t.Errorf("Unexpected event: %+v", ev)
+			}
+			return
+		case <-ticker.C:
+			ctrl.Publish(StateEvent{Interface: "eth0", VRID: 20, NewState: "BACKUP"})
+			ctrl.Publish(StateEvent{Interface: "eth0", VRID: 10, NewState: "MASTER"})
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for watch event")
+		}
+	}
+}
+
+func TestGRPCTokens(t *testing.T) {
+	ctrl := NewServer("")
+	ctrl.SetPolicy(Policy{Tokens: map[string]string{testToken: "ops"}})
+	ctrl.Handle(CommandReload, func(*Request) (*Response, error) { return &Response{Message: "ok"}, nil })
+
+	var caller *Caller
+	ctrl.SetAuditor(func(c *Caller, _ *Request, _ error) { caller = c })
+	ctx := context.Background()
+
+	for token, want := range map[string]codes.Code{
+		"":                    codes.Unauthenticated,
+		"wrong-token-0000000": codes.Unauthenticated,
+		testToken:             codes.OK,
+	} {
+		var opts []grpc.DialOption
+		if token != "" {
+			opts = append(opts, WithToken(token))
+		}
+		client := startTestGRPC(t, ctrl, opts...)
+		if _, err := client.Reload(ctx, &ReloadRequest{}); status.Code(err) != want {
+			t.Errorf("Reload with token %q = %v, want %v", token, err, want)
+		}
+	}
+	if caller == nil || caller.Transport != TransportGRPC {
+		t.Errorf("Reload audited as %+v", caller)
+	}
+}
@@ -0,0 +1,228 @@
+package control
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPServer exposes the control commands registered on a Server as a REST API:
+//
+//	GET  /v1/instances[?interface=&vrid=]
+//	GET  /v1/instances/{vrid}[?interface=]
+//	POST /v1/instances/{vrid}/priority   {"priority": N}
+//	POST /v1/instances/{vrid}/failover  [{"hold_seconds": N}]
+//	POST /v1/reload
+//
+// Clients authenticate with "Authorization: Bearer TOKEN" or a TLS client
+// certificate (see Policy and SetTLSConfig).
+type HTTPServer struct {
+	ctrl *Server
+	srv  *http.Server
+}
+
+// NewHTTPServer creates a REST admin server backed by ctrl's handlers
+func NewHTTPServer(ctrl *Server) *HTTPServer {
+	h := &HTTPServer{ctrl: ctrl}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/instances", h.authenticated(h.listInstances))
+	mux.HandleFunc("GET /v1/instances/{vrid}", h.authenticated(h.getInstance))
+	mux.HandleFunc("POST /v1/instances/{vrid}/priority", h.authenticated(h.setPriority))
+	mux.HandleFunc("POST /v1/instances/{vrid}/failover", h.authenticated(h.failover))
+	mux.HandleFunc("POST /v1/reload", h.authenticated(h.reload))
+
+	h.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return h
+}
+
+// Handler returns the HTTP handler serving the API
+func (h *HTTPServer) Handler() http.Handler {
+	return h.srv.Handler
+}
+
+// SetTLSConfig makes Start serve HTTPS with cfg, such as ServerTLSConfig
+// returns. It must be called before Start.
+func (h *HTTPServer) SetTLSConfig(cfg *tls.Config) {
+	h.srv.TLSConfig = cfg
+}
+
+// Start listens on addr and serves in the background
+func (h *HTTPServer) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	if h.srv.TLSConfig != nil {
+		ln = tls.NewListener(ln, h.srv.TLSConfig)
+	}
+
+	go func() {
+		if err := h.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			h.ctrl.logger.Error("HTTP admin API failed", "err", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops the server
+func (h *HTTPServer) Close() error {
+	return h.srv.Close()
+}
+
+// authenticated identifies the caller of a request, by the token in its
+// "Authorization: Bearer" header, before fn serves it
+func (h *HTTPServer) authenticated(fn func(w http.ResponseWriter, r *http.Request, c *Caller)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		fn(w, r, h.ctrl.apiCaller(TransportHTTP, r.RemoteAddr, r.TLS, token))
+	}
+}
+
+func (h *HTTPServer) listInstances(w http.ResponseWriter, r *http.Request, c *Caller) {
+	vrid, err := parseVRID(r.URL.Query().Get("vrid"), true)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	resp, err := h.ctrl.call(c, &Request{
+		Command:   CommandStatus,
+		Interface: r.URL.Query().Get("interface"),
+		VRID:      vrid,
+	})
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	out := ListInstancesResponse{Instances: resp.Instances}
+	if out.Instances == nil {
+		out.Instances = []InstanceStatus{}
+	}
+	h.writeJSON(w, http.StatusOK, out)
+}
+
+func (h *HTTPServer) getInstance(w http.ResponseWriter, r *http.Request, c *Caller) {
+	vrid, err := parseVRID(r.PathValue("vrid"), false)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	is, err := h.ctrl.lookup(c, r.URL.Query().Get("interface"), vrid)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, is)
+}
+
+func (h *HTTPServer) setPriority(w http.ResponseWriter, r *http.Request, c *Caller) {
+	vrid, err := parseVRID(r.PathValue("vrid"), false)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	var body struct {
+		Priority uint8 `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.writeError(w, fmt.Errorf("invalid body: %v: %w", err, errInvalid))
+		return
+	}
+
+	h.action(w, c, &Request{
+		Command:   CommandSetPriority,
+		Interface: r.URL.Query().Get("interface"),
+		VRID:      vrid,
+		Priority:  body.Priority,
+	})
+}
+
+func (h *HTTPServer) failover(w http.ResponseWriter, r *http.Request, c *Caller) {
+	vrid, err := parseVRID(r.PathValue("vrid"), false)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	// The body is optional
+	var body struct {
+		HoldSeconds int `json:"hold_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		h.writeError(w, fmt.Errorf("invalid body: %v: %w", err, errInvalid))
+		return
+	}
+
+	h.action(w, c, &Request{
+		Command:     CommandFailover,
+		Interface:   r.URL.Query().Get("interface"),
+		VRID:        vrid,
+		HoldSeconds: body.HoldSeconds,
+	})
+}
+
+func (h *HTTPServer) reload(w http.ResponseWriter, _ *http.Request, c *Caller) {
+	h.action(w, c, &Request{Command: CommandReload})
+}
+
+func (h *HTTPServer) action(w http.ResponseWriter, c *Caller, req *Request) {
+	resp, err := h.ctrl.call(c, req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, ActionResponse{Message: resp.Message})
+}
+
+func parseVRID(s string, optional bool) (uint8, error) {
+	if s == "" && optional {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("invalid VRID %q: %w", s, errInvalid)
+	}
+	return uint8(v), nil
+}
+
+func (h *HTTPServer) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.ctrl.logger.Warn("Failed to write HTTP response", "err", err)
+	}
+}
+
+func (h *HTTPServer) writeError(w http.ResponseWriter, err error) {
+	code := http.StatusConflict
+	switch {
+	case errors.Is(err, errUnsupported):
+		code = http.StatusNotImplemented
+	case errors.Is(err, errNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, errInvalid):
+		code = http.StatusBadRequest
+	case errors.Is(err, errUnauthenticated):
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case errors.Is(err, errPermission):
+		code = http.StatusForbidden
+	}
+
+	h.writeJSON(w, code, Response{Error: err.Error()})
+}
@@ -0,0 +1,124 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestHTTP(t *testing.T) (*httptest.Server, *Request) {
+	t.Helper()
+
+	ctrl := NewServer("")
+	ctrl.Handle(CommandStatus, func(req *Request) (*Response, error) {
+		resp := &Response{}
+		for _, is := range []InstanceStatus{
+			{Interface: "eth0", VRID: 10, State: "MASTER"},
+			{Interface: "eth0", VRID: 20, State: "BACKUP"},
+		} {
+			if req.Matches(is.Interface, is.VRID) {
+				resp.Instances = append(resp.Instances, is)
+			}
+		}
+		return resp, nil
+	})
+
+	last := &Request{}
+	ctrl.Handle(CommandSetPriority, func(req *Request) (*Response, error) {
+		*last = *req
+		return &Response{Message: "priority changed"}, nil
+	})
+	ctrl.Handle(CommandFailover, func(req *Request) (*Response, error) {
+		*last = *req
+		return &Response{Message: "stepped down"}, nil
+	})
+
+	ts := httptest.NewServer(NewHTTPServer(ctrl).Handler())
+	t.Cleanup(ts.Close)
+
+	return ts, last
+}
+
+func TestHTTPInstances(t *testing.T) {
+	ts, _ := newTestHTTP(t)
+
+	resp, err := http.Get(ts.URL + "/v1/instances")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var list ListInstancesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(list.Instances) != 2 {
+		t.Errorf("Expected 2 instances, got %d", len(list.Instances))
+	}
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/v1/instances/20", http.StatusOK},
+		{"/v1/instances/99", http.StatusNotFound},
+		{"/v1/instances/abc", http.StatusBadRequest},
+		{"/v1/instances?vrid=300", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		r, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", tt.path, err)
+		}
+		r.Body.Close()
+		if r.StatusCode != tt.code {
+			t.Errorf("GET %s: expected %d, got %d", tt.path, tt.code, r.StatusCode)
+		}
+	}
+}
+
+func TestHTTPActions(t *testing.T) {
+	ts, last := newTestHTTP(t)
+
+	resp, err := http.Post(ts.URL+"/v1/instances/10/priority", "application/json",
+		strings.NewReader(`{"priority": 180}`))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+	if last.VRID != 10 || last.Priority != 180 {
+		t.Errorf("Unexpected request passed to handler: %+v", last)
+	}
+
+	for body, hold := range map[string]int{`{"hold_seconds": 30}`: 30, "": 0} {
+		resp, err = http.Post(ts.URL+"/v1/instances/10/failover", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Failover with body %q: expected 200, got %d", body, resp.StatusCode)
+		}
+		if last.Command != CommandFailover || last.HoldSeconds != hold {
+			t.Errorf("Unexpected request passed to handler: %+v", last)
+		}
+	}
+
+	resp, err = http.Post(ts.URL+"/v1/reload", "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Unregistered command should return 501, got %d", resp.StatusCode)
+	}
+}
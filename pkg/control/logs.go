@@ -0,0 +1,133 @@
+package control
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultLogHistory is how many records the daemon keeps for CommandLogs
+const DefaultLogHistory = 1000
+
+// LogRecord is one record of the daemon's log
+type LogRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	Attrs   []LogAttr `json:"attrs,omitempty"`
+}
+
+// LogAttr is an attribute of a LogRecord, with its value as the text log
+// renders it. Attributes of a group have its name as key prefix, e.g.
+// "peer.ip".
+type LogAttr struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Attr returns the value of the attribute key, and whether the record has it
+func (r *LogRecord) Attr(key string) (string, bool) {
+	for _, a := range r.Attrs {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// MatchesLog reports whether a log record passes the request filters. A
+// record about no instance, without iface and vrid attributes, only passes
+// without filters.
+func (r *Request) MatchesLog(rec *LogRecord) bool {
+	if r.Interface != "" {
+		if iface, _ := rec.Attr("iface"); iface != r.Interface {
+			return false
+		}
+	}
+	if r.VRID != 0 {
+		if vrid, _ := rec.Attr("vrid"); vrid != strconv.Itoa(int(r.VRID)) {
+			return false
+		}
+	}
+	return true
+}
+
+// LogBuffer keeps the latest records of the daemon's log and passes new ones
+// to followers. It is safe for concurrent use.
+type LogBuffer struct {
+	mu      sync.Mutex
+	records []LogRecord
+	// next is where the next record goes once records is full
+	next        int
+	subscribers map[chan LogRecord]struct{}
+}
+
+// NewLogBuffer creates a buffer keeping size records
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{
+		records:     make([]LogRecord, 0, max(size, 1)),
+		subscribers: make(map[chan LogRecord]struct{}),
+	}
+}
+
+// Add keeps rec, replacing the oldest record if the buffer is full, and
+// passes it to the followers. Slow followers miss records rather than
+// blocking the caller, which is logging.
+func (b *LogBuffer) Add(rec LogRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.records) < cap(b.records) {
+		b.records = append(b.records, rec)
+	} else {
+		b.records[b.next] = rec
+		b.next = (b.next + 1) % len(b.records)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- rec:
+		default:
+		}
+	}
+}
+
+// Recent returns up to the last n records that match, oldest first
+func (b *LogBuffer) Recent(n int, match func(*LogRecord) bool) []LogRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.recent(n, match)
+}
+
+// recent is Recent with b.mu held
+func (b *LogBuffer) recent(n int, match func(*LogRecord) bool) []LogRecord {
+	var out []LogRecord
+	for i := len(b.records) - 1; i >= 0 && len(out) < n; i-- {
+		rec := &b.records[(b.next+i)%len(b.records)]
+		if match(rec) {
+			out = append(out, *rec)
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// Follow returns up to the last n records that match, like Recent, and a
+// channel receiving every record added after them, until the returned
+// function is called
+func (b *LogBuffer) Follow(n int, match func(*LogRecord) bool) ([]LogRecord, <-chan LogRecord, func()) {
+	ch := make(chan LogRecord, 64)
+
+	b.mu.Lock()
+	recent := b.recent(n, match)
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return recent, ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
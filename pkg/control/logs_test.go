@@ -0,0 +1,111 @@
+package control
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testLogRecord(msg string, vrid int) LogRecord {
+	rec := LogRecord{Time: time.Now(), Level: "INFO", Message: msg}
+	if vrid != 0 {
+		rec.Attrs = []LogAttr{{Key: "iface", Value: "eth0"}, {Key: "vrid", Value: strconv.Itoa(vrid)}}
+	}
+	return rec
+}
+
+func messages(recs []LogRecord) []string {
+	var out []string
+	for _, rec := range recs {
+		out = append(out, rec.Message)
+	}
+	return out
+}
+
+func TestLogBuffer(t *testing.T) {
+	b := NewLogBuffer(3)
+	all := func(*LogRecord) bool { return true }
+	if got := b.Recent(10, all); len(got) != 0 {
+		t.Errorf("empty buffer has %v", messages(got))
+	}
+
+	for i := 1; i <= 5; i++ {
+		b.Add(testLogRecord(strconv.Itoa(i), i%2))
+	}
+	if got := messages(b.Recent(10, all)); len(got) != 3 || got[0] != "3" || got[2] != "5" {
+		t.Errorf("Recent(10) = %v, want the last 3 oldest first", got)
+	}
+	if got := messages(b.Recent(2, all)); len(got) != 2 || got[0] != "4" || got[1] != "5" {
+		t.Errorf("Recent(2) = %v, want [4 5]", got)
+	}
+
+	req := &Request{VRID: 1}
+	if got := messages(b.Recent(10, req.MatchesLog)); len(got) != 2 || got[0] != "3" || got[1] != "5" {
+		t.Errorf("Recent of VRID 1 = %v, want [3 5]", got)
+	}
+	req = &Request{Interface: "eth1"}
+	if got := b.Recent(10, req.MatchesLog); len(got) != 0 {
+		t.Errorf("Recent of eth1 = %v, want none", messages(got))
+	}
+}
+
+func TestServerLogs(t *testing.T) {
+	srv, client := startTestServer(t)
+
+	req := &Request{Command: CommandLogs, Lines: 10}
+	if _, err := client.Do(req); err == nil {
+		t.Error("logs without a buffer succeeded")
+	}
+
+	logs := NewLogBuffer(DefaultLogHistory)
+	srv.SetLogs(logs)
+	logs.Add(testLogRecord("started", 0))
+	logs.Add(testLogRecord("State changed", 10))
+	logs.Add(testLogRecord("State changed", 20))
+
+	resp, err := client.Do(&Request{Command: CommandLogs, Lines: 10, VRID: 20})
+	if err != nil {
+		t.Fatalf("logs: %v", err)
+	}
+	if len(resp.Logs) != 1 || resp.Logs[0].Message != "State changed" {
+		t.Errorf("logs of VRID 20 = %+v", resp.Logs)
+	}
+	if vrid, _ := resp.Logs[0].Attr("vrid"); vrid != "20" {
+		t.Errorf("vrid = %q, want 20", vrid)
+	}
+
+	// Following, the recent records come first, then the new ones as they
+	// are logged, until the server closes
+	got := make(chan []LogRecord, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Stream(&Request{Command: CommandLogs, Lines: 1, VRID: 10, Follow: true}, func(resp *Response) error {
+			got <- resp.Logs
+			return nil
+		})
+	}()
+	if recs := <-got; len(recs) != 1 || recs[0].Message != "State changed" {
+		t.Fatalf("first response = %+v, want the recent record", recs)
+	}
+
+	logs.Add(testLogRecord("other router", 20))
+	logs.Add(testLogRecord("Took over as MASTER", 10))
+	select {
+	case recs := <-got:
+		if len(recs) != 1 || recs[0].Message != "Took over as MASTER" {
+			t.Errorf("followed = %+v, want only the record of VRID 10", recs)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("new record not streamed")
+	}
+
+	_ = srv.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Stream: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream not ended by Close")
+	}
+}
@@ -0,0 +1,395 @@
+package control
+
+import (
+	"net"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// DefaultSocketPath is where the daemon listens for control requests
+const DefaultSocketPath = "/run/vrrp-simple.sock"
+
+// DefaultFailoverHold is used when a failover request does not set a hold time
+const DefaultFailoverHold = time.Minute
+
+// Commands understood by the control socket
+const (
+	CommandStatus      = "status"
+	CommandSetPriority = "set-priority"
+	CommandSet         = "set"
+	CommandFailover    = "failover"
+	CommandHandoff     = "handoff"
+	CommandReload      = "reload"
+	CommandStats       = "stats"
+	CommandLogs        = "logs"
+)
+
+// Request is a single control command sent by a client. Interface and VRID
+// filter the instances the command applies to; zero values match everything.
+type Request struct {
+	Command   string `json:"command"`
+	Interface string `json:"interface,omitempty"`
+	VRID      uint8  `json:"vrid,omitempty"`
+	Priority  uint8  `json:"priority,omitempty"`
+
+	// AdvertInterval and Preempt are changed by CommandSet when set, together
+	// with Priority
+	AdvertInterval int   `json:"advert_interval,omitempty"`
+	Preempt        *bool `json:"preempt,omitempty"`
+
+	// Tracker is the tracker CommandSet disables, or enables again, as
+	// TrackerEnabled says
+	Tracker        string `json:"tracker,omitempty"`
+	TrackerEnabled bool   `json:"tracker_enabled,omitempty"`
+
+	// HoldSeconds is how long a failed-over or handed-off master waits
+	// before preempting again; zero means DefaultFailoverHold
+	HoldSeconds int `json:"hold_seconds,omitempty"`
+
+	// Peer is the address of the router CommandHandoff hands over to; empty
+	// means any
+	Peer string `json:"peer,omitempty"`
+
+	// Reset makes CommandStats zero the counters after reading them
+	Reset bool `json:"reset,omitempty"`
+
+	// Lines is how many recent log records CommandLogs returns. Follow keeps
+	// the connection open for the records logged afterwards, one response
+	// per line, until the client closes it.
+	Lines  int  `json:"lines,omitempty"`
+	Follow bool `json:"follow,omitempty"`
+}
+
+// Mutating reports whether r changes the daemon's behavior, which a Policy
+// restricts and the auditor records: everything but reading the status and
+// statistics without resetting them, and the logs
+func (r *Request) Mutating() bool {
+	switch r.Command {
+	case CommandStatus, CommandLogs:
+		return false
+	case CommandStats:
+		return r.Reset
+	}
+	return true
+}
+
+// Matches reports whether an instance passes the request filters
+func (r *Request) Matches(iface string, vrid uint8) bool {
+	if r.Interface != "" && r.Interface != iface {
+		return false
+	}
+	if r.VRID != 0 && r.VRID != vrid {
+		return false
+	}
+	return true
+}
+
+// Response is the reply to a Request
+type Response struct {
+	Error     string           `json:"error,omitempty"`
+	Message   string           `json:"message,omitempty"`
+	Instances []InstanceStatus `json:"instances,omitempty"`
+	Stats     []InstanceStats  `json:"stats,omitempty"`
+	Logs      []LogRecord      `json:"logs,omitempty"`
+}
+
+// StateEvent reports a state transition of one instance. Other events set
+// Event, with OldState and NewState both the state the instance is in.
+type StateEvent struct {
+	Interface string    `json:"interface"`
+	VRID      uint8     `json:"vrid"`
+	OldState  string    `json:"old_state"`
+	NewState  string    `json:"new_state"`
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// EventSplitBrain is published when a MASTER finds another router acting as
+// MASTER too; Detail names it
+const EventSplitBrain = "split_brain"
+
+// EventMasterLost is published when a backup-only instance finds no master
+// where it would have taken over
+const EventMasterLost = "master_lost"
+
+// InstanceStatus is the wire form of vrrp.Status
+type InstanceStatus struct {
+	Interface      string      `json:"interface"`
+	VRID           uint8       `json:"vrid"`
+	State          string      `json:"state"`
+	Priority       uint8       `json:"priority"`
+	VirtualIPs     []string    `json:"virtual_ips"`
+	ExcludedIPs    []string    `json:"excluded_ips,omitempty"`
+	StartedAt      time.Time   `json:"started_at"`
+	Uptime         string      `json:"uptime"`
+	LastTransition time.Time   `json:"last_transition"`
+	MasterIP       string      `json:"master_ip,omitempty"`
+	Master         *PeerStatus `json:"master,omitempty"`
+	Peer           *PeerStatus `json:"peer,omitempty"`
+	// Peers is every router heard advertising for the VRID
+	Peers           []KnownPeerStatus `json:"peers,omitempty"`
+	AdvertsSent     uint64            `json:"adverts_sent"`
+	AdvertsReceived uint64            `json:"adverts_received"`
+	PacketsDropped  uint64            `json:"packets_dropped"`
+	SyncGroup       string            `json:"sync_group,omitempty"`
+	// Observe is set for an instance that follows the election without
+	// taking part
+	Observe bool `json:"observe,omitempty"`
+	// BackupOnly is set for an instance kept from becoming MASTER
+	BackupOnly bool `json:"backup_only,omitempty"`
+	// Pair is set for an instance of an active_active pair
+	Pair *PairStatus `json:"pair,omitempty"`
+	// Trackers are the instance's health checks; Priority is lowered while
+	// one fails
+	Trackers []TrackerStatus `json:"trackers,omitempty"`
+	// ConfiguredPriority is the priority before the trackers lower it
+	ConfiguredPriority uint8 `json:"configured_priority"`
+}
+
+// PairStatus describes the active_active pair of an instance
+type PairStatus struct {
+	Name string `json:"name"`
+	// Master is the node meant to be MASTER of the instance's VRID, Backup
+	// the other, meant to be MASTER of PartnerVRID
+	Master      string `json:"master"`
+	Backup      string `json:"backup"`
+	PartnerVRID uint8  `json:"partner_vrid"`
+}
+
+// TrackerStatus is the wire form of vrrp.TrackerStatus
+type TrackerStatus struct {
+	Name    string    `json:"name"`
+	Healthy bool      `json:"healthy"`
+	Error   string    `json:"error,omitempty"`
+	Since   time.Time `json:"since"`
+	// Disabled is set for a tracker turned off with vrrp set, which does
+	// not lower the priority while it fails
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// PeerStatus describes the last advertisement heard from a router
+type PeerStatus struct {
+	SourceIP string `json:"source_ip"`
+	Priority uint8  `json:"priority"`
+	// AdvertInterval is empty if unknown (VRRPv3)
+	AdvertInterval string    `json:"advert_interval,omitempty"`
+	LastSeen       time.Time `json:"last_seen"`
+	// SinceLastAdvert is the time from LastSeen to the snapshot
+	SinceLastAdvert string `json:"since_last_advert,omitempty"`
+	// VirtualIPs are the virtual IPs the router advertises, set only when
+	// they differ from the instance's and its address check is adopt
+	VirtualIPs []string `json:"virtual_ips,omitempty"`
+}
+
+// KnownPeerStatus is the wire form of vrrp.KnownPeer
+type KnownPeerStatus struct {
+	PeerStatus
+	Version   uint8     `json:"version"`
+	FirstSeen time.Time `json:"first_seen"`
+	Adverts   uint64    `json:"adverts"`
+}
+
+// newPeerStatus converts p, returning nil if no advertisement was heard
+func newPeerStatus(p vrrp.PeerInfo) *PeerStatus {
+	if p.SourceIP == nil {
+		return nil
+	}
+	ps := &PeerStatus{
+		SourceIP: p.SourceIP.String(),
+		Priority: p.Priority,
+		LastSeen: p.LastSeen,
+	}
+	if p.AdvInterval > 0 {
+		ps.AdvertInterval = p.AdvInterval.String()
+	}
+	if !p.LastSeen.IsZero() {
+		ps.SinceLastAdvert = time.Since(p.LastSeen).Truncate(time.Millisecond).String()
+	}
+	for _, ip := range p.VirtualIPs {
+		ps.VirtualIPs = append(ps.VirtualIPs, ip.String())
+	}
+	return ps
+}
+
+// NewInstanceStatus converts a router status snapshot to its wire form
+func NewInstanceStatus(st *vrrp.Status) InstanceStatus {
+	is := InstanceStatus{
+		Interface:       st.Interface,
+		VRID:            st.VRID,
+		State:           st.State.String(),
+		Priority:        st.Priority,
+		VirtualIPs:      ipStrings(st.VirtualIPs),
+		ExcludedIPs:     ipStrings(st.ExcludedIPs),
+		StartedAt:       st.StartedAt,
+		LastTransition:  st.LastTransition,
+		AdvertsSent:     st.AdvertsSent,
+		AdvertsReceived: st.AdvertsReceived,
+		PacketsDropped:  st.PacketsDropped,
+		SyncGroup:       st.SyncGroup,
+		Observe:         st.Observe,
+		BackupOnly:      st.BackupOnly,
+
+		ConfiguredPriority: st.ConfiguredPriority,
+	}
+
+	if st.MasterIP != nil {
+		is.MasterIP = st.MasterIP.String()
+	}
+
+	if !st.StartedAt.IsZero() {
+		is.Uptime = time.Since(st.StartedAt).Truncate(time.Second).String()
+	}
+
+	is.Master = newPeerStatus(st.Master)
+	is.Peer = newPeerStatus(st.Peer)
+	for _, p := range st.Peers {
+		is.Peers = append(is.Peers, KnownPeerStatus{
+			PeerStatus: *newPeerStatus(vrrp.PeerInfo{
+				SourceIP:    p.SourceIP,
+				Priority:    p.Priority,
+				AdvInterval: p.AdvInterval,
+				LastSeen:    p.LastSeen,
+			}),
+			Version:   p.Version,
+			FirstSeen: p.FirstSeen,
+			Adverts:   p.Adverts,
+		})
+	}
+	for _, t := range st.Trackers {
+		is.Trackers = append(is.Trackers, TrackerStatus{
+			Name: t.Name, Healthy: t.Healthy, Error: t.Err, Since: t.Since, Disabled: t.Disabled,
+		})
+	}
+
+	return is
+}
+
+// InstanceStats is the wire form of vrrp.Stats for one instance
+type InstanceStats struct {
+	Interface            string                     `json:"interface"`
+	VRID                 uint8                      `json:"vrid"`
+	State                string                     `json:"state"`
+	StateSince           time.Time                  `json:"state_since"`
+	StateUptime          string                     `json:"state_uptime"`
+	MasterReason         string                     `json:"master_reason,omitempty"`
+	CountersSince        time.Time                  `json:"counters_since"`
+	Transitions          uint64                     `json:"transitions"`
+	AdvertsSent          uint64                     `json:"adverts_sent"`
+	AdvertsReceived      uint64                     `json:"adverts_received"`
+	BecomeMaster         uint64                     `json:"become_master"`
+	ChecksumErrors       uint64                     `json:"checksum_errors"`
+	TTLErrors            uint64                     `json:"ttl_errors"`
+	VRIDMismatches       uint64                     `json:"vrid_mismatches"`
+	VersionErrors        uint64                     `json:"version_errors"`
+	InvalidTypeReceived  uint64                     `json:"invalid_type_received"`
+	PacketLengthErrors   uint64                     `json:"packet_length_errors"`
+	AdvIntervalErrors    uint64                     `json:"advert_interval_errors"`
+	AddressListErrors    uint64                     `json:"address_list_errors"`
+	PriorityZeroReceived uint64                     `json:"priority_zero_received"`
+	PriorityZeroSent     uint64                     `json:"priority_zero_sent"`
+	PacketsDropped       uint64                     `json:"packets_dropped"`
+	Drops                map[vrrp.DropReason]uint64 `json:"drops"`
+	LastProtocolError    string                     `json:"last_protocol_error,omitempty"`
+	LastAdvert           *PeerStatus                `json:"last_advert,omitempty"`
+	VIPErrors            uint64                     `json:"vip_errors"`
+	SplitBrains          uint64                     `json:"split_brains"`
+	OffLinkAdverts       uint64                     `json:"off_link_adverts"`
+	ForeignVersion       uint64                     `json:"foreign_version_adverts"`
+	MembershipLost       uint64                     `json:"membership_lost"`
+	Jitter               map[string]LatencyStats    `json:"jitter,omitempty"`
+	FailoverLatency      LatencyStats               `json:"failover_latency"`
+}
+
+// LatencyStats is the wire form of a vrrp.Histogram
+type LatencyStats struct {
+	Count   uint64        `json:"count"`
+	Mean    string        `json:"mean"`
+	P50     string        `json:"p50"`
+	P99     string        `json:"p99"`
+	Buckets []BucketCount `json:"buckets,omitempty"`
+}
+
+// BucketCount is the number of observations up to LE and above the bucket
+// before; LE is "+Inf" for the last bucket
+type BucketCount struct {
+	LE    string `json:"le"`
+	Count uint64 `json:"count"`
+}
+
+// NewLatencyStats converts a histogram to its wire form
+func NewLatencyStats(h vrrp.Histogram) LatencyStats {
+	ls := LatencyStats{
+		Count: h.Count,
+		Mean:  h.Mean().String(),
+		P50:   h.Quantile(0.5).String(),
+		P99:   h.Quantile(0.99).String(),
+	}
+	for i, n := range h.Counts {
+		le := "+Inf"
+		if i < len(vrrp.LatencyBuckets) {
+			le = vrrp.LatencyBuckets[i].String()
+		}
+		ls.Buckets = append(ls.Buckets, BucketCount{LE: le, Count: n})
+	}
+	return ls
+}
+
+// NewInstanceStats converts the statistics of one instance to their wire form
+func NewInstanceStats(s *vrrp.Stats) InstanceStats {
+	is := InstanceStats{
+		Interface:            s.Interface,
+		VRID:                 s.VRID,
+		State:                s.State.String(),
+		StateSince:           s.StateSince,
+		MasterReason:         string(s.MasterReason),
+		CountersSince:        s.CountersSince,
+		Transitions:          s.Transitions,
+		AdvertsSent:          s.AdvertsSent,
+		AdvertsReceived:      s.AdvertsReceived,
+		BecomeMaster:         s.BecomeMaster,
+		ChecksumErrors:       s.ChecksumErrors,
+		TTLErrors:            s.TTLErrors,
+		VRIDMismatches:       s.VRIDMismatches,
+		VersionErrors:        s.VersionErrors,
+		InvalidTypeReceived:  s.InvalidTypeReceived,
+		PacketLengthErrors:   s.PacketLengthErrors,
+		AdvIntervalErrors:    s.AdvIntervalErrors,
+		AddressListErrors:    s.AddressListErrors,
+		PriorityZeroReceived: s.PriorityZeroReceived,
+		PriorityZeroSent:     s.PriorityZeroSent,
+		PacketsDropped:       s.PacketsDropped,
+		Drops:                s.Drops,
+		LastProtocolError:    string(s.LastProtocolError),
+		VIPErrors:            s.VIPErrors,
+		SplitBrains:          s.SplitBrains,
+		OffLinkAdverts:       s.OffLinkAdverts,
+		ForeignVersion:       s.ForeignVersionAdverts,
+		MembershipLost:       s.MembershipLost,
+		FailoverLatency:      NewLatencyStats(s.FailoverLatency),
+	}
+
+	if len(s.Jitter) > 0 {
+		is.Jitter = make(map[string]LatencyStats, len(s.Jitter))
+		for peer, h := range s.Jitter {
+			is.Jitter[peer] = NewLatencyStats(h)
+		}
+	}
+
+	if !s.StateSince.IsZero() {
+		is.StateUptime = s.StateUptime.Truncate(time.Second).String()
+	}
+
+	is.LastAdvert = newPeerStatus(s.LastAdvert)
+
+	return is
+}
+
+func ipStrings(ips []net.IP) []string {
+	out := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		out = append(out, ip.String())
+	}
+	return out
+}
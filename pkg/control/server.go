@@ -0,0 +1,315 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+)
+
+// HandlerFunc serves a single control request
+type HandlerFunc func(req *Request) (*Response, error)
+
+// Server serves control requests on a Unix domain socket. Each connection
+// carries one JSON request line and receives one JSON response line, except
+// for a CommandLogs request that follows the log.
+type Server struct {
+	path     string
+	listener net.Listener
+	logger   *slog.Logger
+	// logs serves CommandLogs if set (see SetLogs)
+	logs *LogBuffer
+	// done is closed by Close to end the log streams
+	done chan struct{}
+
+	mu          sync.RWMutex
+	handlers    map[string]HandlerFunc
+	subscribers map[chan StateEvent]struct{}
+
+	// policy, tokens and auditor are set before Start (see SetPolicy)
+	policy  Policy
+	tokens  []apiToken
+	auditor func(c *Caller, req *Request, err error)
+
+	wg sync.WaitGroup
+}
+
+// NewServer creates a control server listening on path
+func NewServer(path string) *Server {
+	return &Server{
+		path:        path,
+		logger:      slog.Default(),
+		handlers:    make(map[string]HandlerFunc),
+		subscribers: make(map[chan StateEvent]struct{}),
+	}
+}
+
+// SetLogger replaces the logger, slog.Default() unless set. The REST admin
+// server logs through it too. It must be called before Start.
+func (s *Server) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// SetLogs makes the server answer CommandLogs from b. It must be called
+// before Start.
+func (s *Server) SetLogs(b *LogBuffer) {
+	s.logs = b
+}
+
+// Handle registers fn for the given command
+func (s *Server) Handle(command string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = fn
+}
+
+// Publish delivers ev to every subscriber. Slow subscribers miss events rather
+// than blocking the caller, which is usually a state change callback.
+func (s *Server) Publish(ev StateEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving published state events and a function
+// that ends the subscription
+func (s *Server) Subscribe() (<-chan StateEvent, func()) {
+	ch := make(chan StateEvent, 16)
+
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}
+}
+
+// Errors returned by call and lookup, mapped to status codes by the gRPC and HTTP APIs
+var (
+	errUnsupported = errors.New("not supported by this daemon")
+	errNotFound    = errors.New("no matching instance")
+	errInvalid     = errors.New("invalid request")
+)
+
+// call dispatches req from c like a control socket request, returning
+// handler failures as errors
+func (s *Server) call(c *Caller, req *Request) (*Response, error) {
+	s.mu.RLock()
+	_, ok := s.handlers[req.Command]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", req.Command, errUnsupported)
+	}
+
+	return s.serve(c, req)
+}
+
+// lookup returns the status of exactly one instance for c
+func (s *Server) lookup(c *Caller, iface string, vrid uint8) (*InstanceStatus, error) {
+	if vrid == 0 {
+		return nil, fmt.Errorf("vrid is required: %w", errInvalid)
+	}
+
+	resp, err := s.call(c, &Request{Command: CommandStatus, Interface: iface, VRID: vrid})
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(resp.Instances) {
+	case 0:
+		return nil, fmt.Errorf("VRID %d: %w", vrid, errNotFound)
+	case 1:
+		return &resp.Instances[0], nil
+	default:
+		return nil, fmt.Errorf("VRID %d exists on several interfaces, set interface: %w", vrid, errInvalid)
+	}
+}
+
+// Start binds the socket and begins accepting connections
+func (s *Server) Start() error {
+	// A socket left behind by a crashed daemon would make Listen fail
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale control socket %s: %w", s.path, err)
+	}
+
+	ln, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket %s: %w", s.path, err)
+	}
+
+	if err := os.Chmod(s.path, 0o660); err != nil {
+		_ = ln.Close()
+		return fmt.Errorf("failed to set control socket permissions: %w", err)
+	}
+
+	s.listener = ln
+	s.done = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return nil
+}
+
+// Close stops accepting connections and removes the socket file
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+
+	err := s.listener.Close()
+	select {
+	case <-s.done:
+		// Closed before
+	default:
+		close(s.done)
+	}
+	s.wg.Wait()
+	_ = os.Remove(s.path)
+
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Error("Control socket accept failed", "err", err)
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	var req Request
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+
+	var resp *Response
+	if err := json.Unmarshal(line, &req); err != nil {
+		resp = &Response{Error: fmt.Sprintf("invalid request: %v", err)}
+	} else if req.Command == CommandLogs {
+		s.streamLogs(conn, &req)
+		return
+	} else {
+		resp = s.dispatch(&Caller{Transport: TransportSocket, Cred: peerCred(conn)}, &req)
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		s.logger.Warn("Failed to write control response", "err", err)
+	}
+}
+
+// streamLogs answers a CommandLogs request with the recent records in one
+// response, then with Follow with a response per record logged afterwards,
+// until the client hangs up or the server closes
+func (s *Server) streamLogs(conn net.Conn, req *Request) {
+	enc := json.NewEncoder(conn)
+	if s.logs == nil {
+		_ = enc.Encode(&Response{Error: fmt.Sprintf("%s: %v", req.Command, errUnsupported)})
+		return
+	}
+	if !req.Follow {
+		_ = enc.Encode(&Response{Logs: s.logs.Recent(req.Lines, req.MatchesLog)})
+		return
+	}
+
+	recent, records, cancel := s.logs.Follow(req.Lines, req.MatchesLog)
+	defer cancel()
+	if err := enc.Encode(&Response{Logs: recent}); err != nil {
+		return
+	}
+
+	// The client sends nothing more: a read only returns when it hangs up
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-s.done:
+			return
+		case rec := <-records:
+			if !req.MatchesLog(&rec) {
+				continue
+			}
+			if err := enc.Encode(&Response{Logs: []LogRecord{rec}}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) dispatch(c *Caller, req *Request) *Response {
+	resp, err := s.serve(c, req)
+	if err != nil {
+		return &Response{Error: err.Error()}
+	}
+	return resp
+}
+
+// serve runs the handler of req for c. A request that changes the daemon's
+// behavior must pass the Policy first and is passed to the auditor with its
+// outcome.
+func (s *Server) serve(c *Caller, req *Request) (*Response, error) {
+	s.mu.RLock()
+	fn, ok := s.handlers[req.Command]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown command: %q", req.Command)
+	}
+
+	mutating := req.Mutating()
+	err := c.invalid
+	if err == nil && mutating {
+		err = s.authorize(c)
+	}
+	var resp *Response
+	if err == nil {
+		resp, err = fn(req)
+	}
+	if mutating && s.auditor != nil {
+		s.auditor(c, req, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		resp = &Response{}
+	}
+
+	return resp, nil
+}
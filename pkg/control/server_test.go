@@ -0,0 +1,87 @@
+package control
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func startTestServer(t *testing.T) (*Server, *Client) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "vrrp.sock")
+	srv := NewServer(path)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start control server: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return srv, NewClient(path)
+}
+
+func TestServerStatus(t *testing.T) {
+	srv, client := startTestServer(t)
+
+	instances := []InstanceStatus{
+		{Interface: "eth0", VRID: 10, State: "MASTER"},
+		{Interface: "eth0", VRID: 20, State: "BACKUP"},
+		{Interface: "eth1", VRID: 10, State: "BACKUP"},
+	}
+
+	srv.Handle(CommandStatus, func(req *Request) (*Response, error) {
+		resp := &Response{}
+		for _, is := range instances {
+			if req.Matches(is.Interface, is.VRID) {
+				resp.Instances = append(resp.Instances, is)
+			}
+		}
+		return resp, nil
+	})
+
+	tests := []struct {
+		name     string
+		req      Request
+		expected int
+	}{
+		{name: "No filter", req: Request{Command: CommandStatus}, expected: 3},
+		{name: "Interface filter", req: Request{Command: CommandStatus, Interface: "eth0"}, expected: 2},
+		{name: "VRID filter", req: Request{Command: CommandStatus, VRID: 10}, expected: 2},
+		{name: "Both filters", req: Request{Command: CommandStatus, Interface: "eth1", VRID: 10}, expected: 1},
+		{name: "No match", req: Request{Command: CommandStatus, VRID: 99}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Do(&tt.req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if len(resp.Instances) != tt.expected {
+				t.Errorf("Expected %d instances, got %d", tt.expected, len(resp.Instances))
+			}
+		})
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	srv, client := startTestServer(t)
+
+	srv.Handle("fail", func(*Request) (*Response, error) {
+		return nil, errors.New("something broke")
+	})
+
+	if _, err := client.Do(&Request{Command: "fail"}); err == nil || err.Error() != "something broke" {
+		t.Errorf("Expected handler error, got %v", err)
+	}
+
+	if _, err := client.Do(&Request{Command: "nope"}); err == nil {
+		t.Error("Expected error for unknown command")
+	}
+}
+
+func TestClientNoDaemon(t *testing.T) {
+	client := NewClient(filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := client.Do(&Request{Command: CommandStatus}); err == nil {
+		t.Error("Expected error when no daemon is listening")
+	}
+}
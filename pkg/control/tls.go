@@ -0,0 +1,81 @@
+package control
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// TLSFiles are the files of an admin API served with mutual TLS
+type TLSFiles struct {
+	// Cert and Key are the server's certificate chain and private key, PEM
+	Cert string
+	Key  string
+	// ClientCA holds the PEM certificates that client certificates must
+	// chain to
+	ClientCA string
+	// ClientNames are the common names or DNS names a client certificate
+	// must have one of; empty accepts any certificate ClientCA signed
+	ClientNames []string
+}
+
+// ServerTLSConfig loads f into a TLS configuration that requires a client
+// certificate signed by f.ClientCA with one of f.ClientNames
+func ServerTLSConfig(f *TLSFiles) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	pem, err := os.ReadFile(f.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in client CA %s", f.ClientCA)
+	}
+
+	names := slices.Clone(f.ClientNames)
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(names) == 0 || allowedName(cs.PeerCertificates[0], names) {
+				return nil
+			}
+			return errors.New("client certificate name is not allowed")
+		},
+	}, nil
+}
+
+// allowedName reports whether cert is for one of names
+func allowedName(cert *x509.Certificate, names []string) bool {
+	if slices.Contains(names, cert.Subject.CommonName) {
+		return true
+	}
+	for _, n := range cert.DNSNames {
+		if slices.Contains(names, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// certName is the name of the verified client certificate of a TLS
+// connection, its common name or else its first DNS name, or "" if there is
+// none
+func certName(cs *tls.ConnectionState) string {
+	if cs == nil || len(cs.VerifiedChains) == 0 {
+		return ""
+	}
+	leaf := cs.VerifiedChains[0][0]
+	if leaf.Subject.CommonName != "" || len(leaf.DNSNames) == 0 {
+		return leaf.Subject.CommonName
+	}
+	return leaf.DNSNames[0]
+}
@@ -0,0 +1,187 @@
+package control
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for cn, a server's for 127.0.0.1 or else a
+// client's, as PEM certificate and key
+func (ca *testCA) issue(t *testing.T, cn string, server bool) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// client returns a TLS client certificate for cn
+func (ca *testCA) client(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(ca.issue(t, cn, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeTestTLSFiles writes a server certificate and ca as the client CA
+func writeTestTLSFiles(t *testing.T, ca *testCA, names ...string) *TLSFiles {
+	t.Helper()
+	dir := t.TempDir()
+	f := &TLSFiles{
+		Cert:        filepath.Join(dir, "server.pem"),
+		Key:         filepath.Join(dir, "server-key.pem"),
+		ClientCA:    filepath.Join(dir, "ca.pem"),
+		ClientNames: names,
+	}
+	certPEM, keyPEM := ca.issue(t, "vrrp", true)
+	for path, data := range map[string][]byte{f.Cert: certPEM, f.Key: keyPEM, f.ClientCA: ca.pem} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	f := writeTestTLSFiles(t, newTestCA(t))
+	cfg, err := ServerTLSConfig(f)
+	if err != nil {
+		t.Fatalf("ServerTLSConfig: %v", err)
+	}
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("ClientAuth = %v, want client certificates required", cfg.ClientAuth)
+	}
+
+	missing := *f
+	missing.Key = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := ServerTLSConfig(&missing); err == nil {
+		t.Error("ServerTLSConfig with a missing key succeeded")
+	}
+	noCA := *f
+	noCA.ClientCA = f.Key
+	if _, err := ServerTLSConfig(&noCA); err == nil {
+		t.Error("ServerTLSConfig with a client CA of no certificates succeeded")
+	}
+}
+
+func TestHTTPClientCerts(t *testing.T) {
+	ca := newTestCA(t)
+	cfg, err := ServerTLSConfig(writeTestTLSFiles(t, ca, "fleet"))
+	if err != nil {
+		t.Fatalf("ServerTLSConfig: %v", err)
+	}
+
+	ctrl := NewServer("")
+	ctrl.SetPolicy(Policy{Tokens: map[string]string{testToken: "ops"}})
+	ctrl.Handle(CommandReload, func(*Request) (*Response, error) { return &Response{}, nil })
+	var callers []*Caller
+	ctrl.SetAuditor(func(c *Caller, _ *Request, _ error) { callers = append(callers, c) })
+
+	ts := httptest.NewUnstartedServer(NewHTTPServer(ctrl).Handler())
+	ts.TLS = cfg
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	reload := func(certs ...tls.Certificate) (int, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		resp, err := client.Post(ts.URL+"/v1/reload", "application/json", nil)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	// A client certificate with an allowed name needs no token
+	if code, err := reload(ca.client(t, "fleet")); err != nil || code != http.StatusOK {
+		t.Errorf("reload as fleet = %d, %v, want 200", code, err)
+	}
+	if len(callers) != 1 || callers[0].Cert != "fleet" || callers[0].Token != "" {
+		t.Errorf("reload as fleet audited as %+v", callers)
+	}
+
+	// Other clients do not get past the handshake
+	for name, certs := range map[string][]tls.Certificate{
+		"no certificate":           nil,
+		"a name not allowed":       {ca.client(t, "intruder")},
+		"another CA's certificate": {newTestCA(t).client(t, "fleet")},
+	} {
+		if _, err := reload(certs...); err == nil {
+			t.Errorf("reload with %s succeeded", name)
+		}
+	}
+	if len(callers) != 1 {
+		t.Errorf("audited %d requests, want only the one that reached the API", len(callers))
+	}
+}
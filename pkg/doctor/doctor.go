@@ -0,0 +1,108 @@
+// Package doctor holds the checks of vrrp doctor that read the host's
+// configuration: reverse path filtering, firewall rules and other VRRP
+// daemons. Each returns Findings with a suggested fix; gathering the input,
+// which mostly needs root, is left to the caller.
+package doctor
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	// StatusSkip is a check that could not run, e.g. without root
+	StatusSkip Status = "skip"
+)
+
+// Finding is the result of one check
+type Finding struct {
+	Check   string
+	Status  Status
+	Message string
+	// Fix suggests how to resolve a warning or failure, a command where
+	// there is one
+	Fix string
+}
+
+// RPFilter checks the reverse path filter of iface, given the values of
+// net.ipv4.conf.all.rp_filter and net.ipv4.conf.IFACE.rp_filter. The kernel
+// applies the larger of the two.
+func RPFilter(iface string, all, value int) Finding {
+	f := Finding{Check: "rp_filter " + iface}
+	switch max(all, value) {
+	case 0:
+		f.Status, f.Message = StatusOK, "off"
+	case 2:
+		f.Status, f.Message = StatusOK, "loose"
+	default:
+		f.Status = StatusWarn
+		f.Message = "strict: advertisements from a source the host does not route through " + iface +
+			" are dropped before the daemon sees them"
+		f.Fix = fmt.Sprintf("sysctl -w net.ipv4.conf.%s.rp_filter=2", iface)
+		if all == 1 {
+			f.Fix += " net.ipv4.conf.all.rp_filter=2"
+		}
+	}
+	return f
+}
+
+// conflictingDaemons are the process names of other VRRP implementations
+var conflictingDaemons = []string{"keepalived", "vrrpd"}
+
+// OtherDaemons looks through fsys, /proc, for processes of other VRRP
+// daemons, which fight over the same VRIDs and addresses. self is the PID
+// to leave out.
+func OtherDaemons(fsys fs.FS, self int) Finding {
+	f := Finding{Check: "other VRRP daemons", Status: StatusOK, Message: "none running"}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		f.Status, f.Message = StatusSkip, err.Error()
+		return f
+	}
+
+	found := make(map[string][]int)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		comm, err := fs.ReadFile(fsys, e.Name()+"/comm")
+		if err != nil {
+			// Gone since listed
+			continue
+		}
+		name := strings.TrimSpace(string(comm))
+		if slices.Contains(conflictingDaemons, name) {
+			found[name] = append(found[name], pid)
+		}
+	}
+	if len(found) == 0 {
+		return f
+	}
+
+	var names, pids []string
+	for _, name := range conflictingDaemons {
+		if len(found[name]) == 0 {
+			continue
+		}
+		slices.Sort(found[name])
+		names = append(names, name)
+		for _, pid := range found[name] {
+			pids = append(pids, strconv.Itoa(pid))
+		}
+	}
+	f.Status = StatusWarn
+	f.Message = fmt.Sprintf("%s running (PID %s): it must not use the same VRIDs or addresses",
+		strings.Join(names, " and "), strings.Join(pids, ", "))
+	f.Fix = "systemctl disable --now " + strings.Join(names, " ") + ", or give it other VRIDs"
+	return f
+}
@@ -0,0 +1,50 @@
+package doctor
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRPFilter(t *testing.T) {
+	for _, tt := range []struct {
+		all, value int
+		want       Status
+	}{
+		{0, 0, StatusOK},
+		{0, 2, StatusOK},
+		{2, 1, StatusOK},
+		{0, 1, StatusWarn},
+		{1, 0, StatusWarn},
+		{1, 2, StatusOK},
+	} {
+		f := RPFilter("eth0", tt.all, tt.value)
+		if f.Status != tt.want {
+			t.Errorf("all=%d eth0=%d: %s, want %s", tt.all, tt.value, f.Status, tt.want)
+		}
+		if f.Status == StatusWarn && !strings.Contains(f.Fix, "net.ipv4.conf.eth0.rp_filter=2") {
+			t.Errorf("all=%d eth0=%d: fix %q", tt.all, tt.value, f.Fix)
+		}
+	}
+}
+
+func TestOtherDaemons(t *testing.T) {
+	proc := fstest.MapFS{
+		"1/comm":    {Data: []byte("systemd\n")},
+		"812/comm":  {Data: []byte("keepalived\n")},
+		"813/comm":  {Data: []byte("keepalived\n")},
+		"900/comm":  {Data: []byte("vrrp\n")},
+		"self/comm": {Data: []byte("vrrp\n")},
+		"uptime":    {Data: []byte("1 1\n")},
+	}
+	f := OtherDaemons(proc, 900)
+	if f.Status != StatusWarn || !strings.Contains(f.Message, "keepalived running (PID 812, 813)") {
+		t.Errorf("with keepalived: %+v", f)
+	}
+
+	delete(proc, "812/comm")
+	delete(proc, "813/comm")
+	if f := OtherDaemons(proc, 900); f.Status != StatusOK {
+		t.Errorf("without: %+v", f)
+	}
+}
@@ -0,0 +1,182 @@
+package doctor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fixes suggested for the firewall checks
+const (
+	iptablesFix = "iptables -I INPUT -p vrrp -d 224.0.0.18 -j ACCEPT"
+	nftablesFix = "nft insert rule inet filter input ip protocol vrrp ip daddr 224.0.0.18 accept"
+)
+
+// fwRule is a firewall rule reduced to what the checks need
+type fwRule struct {
+	chain string
+	// text is the rule as listed, for messages
+	text string
+	// vrrp is set for a rule matching protocol 112, multicast for one
+	// matching the VRRP group or multicast in general
+	vrrp      bool
+	multicast bool
+	verdict   string // "accept", "drop", "reject" or "" for others
+}
+
+// judgeFirewall finds the rules that drop advertisements: a drop of
+// protocol 112, or of multicast not preceded by an accept of VRRP in its
+// chain. dropChains are the chains of incoming packets whose policy is to
+// drop, fine only if some rule accepts VRRP.
+func judgeFirewall(check, fix string, rules []fwRule, dropChains []string) Finding {
+	f := Finding{Check: check, Fix: fix}
+	var problems []string
+	accepted := make(map[string]bool)
+	anyAccept := false
+	for _, r := range rules {
+		switch {
+		case r.verdict == "accept" && r.vrrp:
+			accepted[r.chain] = true
+			anyAccept = true
+		case (r.verdict == "drop" || r.verdict == "reject") && r.vrrp:
+			problems = append(problems, fmt.Sprintf("%q in %s drops VRRP", r.text, r.chain))
+		case (r.verdict == "drop" || r.verdict == "reject") && r.multicast && !accepted[r.chain]:
+			problems = append(problems, fmt.Sprintf("%q in %s drops multicast, VRRP included", r.text, r.chain))
+		}
+	}
+	if len(problems) > 0 {
+		f.Status, f.Message = StatusFail, strings.Join(problems, "; ")
+		return f
+	}
+	if len(dropChains) > 0 && !anyAccept {
+		f.Status = StatusWarn
+		f.Message = fmt.Sprintf("%s drops by default and no rule accepts VRRP: advertisements are lost unless a "+
+			"broader rule accepts them", strings.Join(dropChains, ", "))
+		return f
+	}
+	f.Status, f.Message, f.Fix = StatusOK, "no rule drops VRRP", ""
+	return f
+}
+
+// ScanIptables checks the rules listed by iptables-save
+func ScanIptables(out string) Finding {
+	var rules []fwRule
+	var dropChains []string
+	table := ""
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "*"):
+			table = line[1:]
+		case strings.HasPrefix(line, ":"):
+			// :INPUT DROP [0:0]
+			fields := strings.Fields(line[1:])
+			if len(fields) >= 2 && table == "filter" && fields[0] == "INPUT" && fields[1] == "DROP" {
+				dropChains = append(dropChains, "the INPUT policy")
+			}
+		case strings.HasPrefix(line, "-A "):
+			r := parseIptablesRule(table, line)
+			// -A INPUT -j DROP ends the chain like a policy
+			if r.chain == "filter/INPUT" && r.verdict != "accept" && r.verdict != "" &&
+				len(strings.Fields(line)) == 4 {
+				dropChains = append(dropChains, fmt.Sprintf("%q", line))
+			}
+			rules = append(rules, r)
+		}
+	}
+	return judgeFirewall("iptables", iptablesFix, rules, dropChains)
+}
+
+func parseIptablesRule(table, line string) fwRule {
+	fields := strings.Fields(line)
+	r := fwRule{chain: table + "/" + fields[1], text: line}
+	negate := false
+	for i := 2; i < len(fields); i++ {
+		next := ""
+		if i+1 < len(fields) {
+			next = fields[i+1]
+		}
+		switch fields[i] {
+		case "!":
+			negate = true
+			continue
+		case "-p", "--protocol":
+			r.vrrp = !negate && (next == "vrrp" || next == "112")
+		case "-d", "--destination":
+			r.multicast = r.multicast || !negate && (strings.HasPrefix(next, "224.0.0.18") || next == "224.0.0.0/4")
+		case "--pkt-type":
+			r.multicast = r.multicast || !negate && next == "multicast"
+		case "--dst-type":
+			r.multicast = r.multicast || !negate && next == "MULTICAST"
+		case "-j", "--jump":
+			switch next {
+			case "ACCEPT":
+				r.verdict = "accept"
+			case "DROP":
+				r.verdict = "drop"
+			case "REJECT":
+				r.verdict = "reject"
+			}
+		}
+		negate = false
+	}
+	return r
+}
+
+// ScanNftables checks the ruleset listed by nft list ruleset
+func ScanNftables(out string) Finding {
+	var rules []fwRule
+	var dropChains []string
+	table, chain := "", ""
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0 || line == "}":
+		case fields[0] == "table" && len(fields) >= 3:
+			table, chain = fields[1]+" "+fields[2], ""
+		case fields[0] == "chain" && len(fields) >= 2:
+			chain = fields[1]
+		case fields[0] == "set" || fields[0] == "map" || fields[0] == "flowtable":
+			// Their elements are not rules
+			chain = ""
+		case fields[0] == "type":
+			// type filter hook input priority filter; policy drop;
+			if strings.Contains(line, "hook input") && strings.Contains(line, "policy drop") {
+				dropChains = append(dropChains, fmt.Sprintf("chain %s in table %s", chain, table))
+			}
+		case chain != "":
+			rules = append(rules, parseNftRule(table+"/"+chain, line))
+		}
+	}
+	return judgeFirewall("nftables", nftablesFix, rules, dropChains)
+}
+
+func parseNftRule(chain, line string) fwRule {
+	r := fwRule{chain: chain, text: line}
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		switch field {
+		case "accept", "drop", "reject":
+			r.verdict = field
+		}
+		if i+1 >= len(fields) {
+			continue
+		}
+		// A negated match has != before its value
+		if fields[i+1] == "!=" {
+			continue
+		}
+		value := fields[i+1]
+		switch {
+		case field == "protocol" || field == "l4proto":
+			r.vrrp = r.vrrp || value == "vrrp" || value == "112"
+		case field == "daddr":
+			r.multicast = r.multicast || strings.HasPrefix(value, "224.0.0.18") || value == "224.0.0.0/4"
+		case field == "pkttype":
+			r.multicast = r.multicast || value == "multicast"
+		case field == "type" && i > 0 && fields[i-1] == "daddr":
+			r.multicast = r.multicast || value == "multicast"
+		}
+	}
+	return r
+}
@@ -0,0 +1,67 @@
+package doctor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanIptables(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		rules string
+		want  Status
+		in    string
+	}{
+		{"empty", "*filter\n:INPUT ACCEPT [0:0]\nCOMMIT\n", StatusOK, ""},
+		{"VRRP dropped", "*filter\n:INPUT ACCEPT [0:0]\n-A INPUT -p vrrp -j DROP\nCOMMIT\n", StatusFail, "drops VRRP"},
+		{"multicast dropped", "*raw\n:PREROUTING ACCEPT [0:0]\n-A PREROUTING -m pkttype --pkt-type multicast -j DROP\n",
+			StatusFail, "raw/PREROUTING drops multicast"},
+		{"multicast dropped after VRRP accepted",
+			"*filter\n-A INPUT -p vrrp -j ACCEPT\n-A INPUT -d 224.0.0.0/4 -j DROP\n", StatusOK, ""},
+		{"other protocols dropped", "*filter\n-A INPUT ! -p vrrp -d 224.0.0.18/32 -j ACCEPT\n" +
+			"-A INPUT -p tcp --dport 23 -j REJECT\n", StatusOK, ""},
+		{"drop policy", "*filter\n:INPUT DROP [0:0]\n-A INPUT -p tcp --dport 22 -j ACCEPT\n", StatusWarn,
+			"INPUT policy"},
+		{"final drop", "*filter\n:INPUT ACCEPT [0:0]\n-A INPUT -p tcp --dport 22 -j ACCEPT\n-A INPUT -j DROP\n",
+			StatusWarn, "-A INPUT -j DROP"},
+		{"drop policy with VRRP accepted", "*filter\n:INPUT DROP [0:0]\n-A INPUT -p 112 -j ACCEPT\n", StatusOK, ""},
+	} {
+		f := ScanIptables(tt.rules)
+		if f.Status != tt.want || !strings.Contains(f.Message, tt.in) {
+			t.Errorf("%s: %s %q, want %s with %q", tt.name, f.Status, f.Message, tt.want, tt.in)
+		}
+		if f.Status != StatusOK && f.Fix == "" {
+			t.Errorf("%s: no fix", tt.name)
+		}
+	}
+}
+
+func TestScanNftables(t *testing.T) {
+	ruleset := func(policy string, rules ...string) string {
+		return "table inet filter {\n\tset allowed {\n\t\ttype ipv4_addr\n\t\telements = { 10.0.0.1 }\n\t}\n" +
+			"\tchain input {\n\t\ttype filter hook input priority filter; policy " + policy + ";\n\t\t" +
+			strings.Join(rules, "\n\t\t") + "\n\t}\n}\n"
+	}
+	for _, tt := range []struct {
+		name    string
+		ruleset string
+		want    Status
+		in      string
+	}{
+		{"accepting", ruleset("accept", "ct state established,related accept"), StatusOK, ""},
+		{"VRRP dropped", ruleset("accept", "ip protocol vrrp counter packets 0 bytes 0 drop"), StatusFail,
+			"drops VRRP"},
+		{"multicast dropped", ruleset("accept", "fib daddr type multicast drop"), StatusFail, "drops multicast"},
+		{"multicast dropped after VRRP accepted",
+			ruleset("accept", "meta l4proto 112 accept", "meta pkttype multicast drop"), StatusOK, ""},
+		{"negated", ruleset("accept", "ip protocol != vrrp ip daddr 224.0.0.18 drop"), StatusFail,
+			"drops multicast"},
+		{"drop policy", ruleset("drop", "tcp dport 22 accept"), StatusWarn, "chain input in table inet filter"},
+		{"drop policy with VRRP accepted", ruleset("drop", "ip protocol vrrp accept"), StatusOK, ""},
+	} {
+		f := ScanNftables(tt.ruleset)
+		if f.Status != tt.want || !strings.Contains(f.Message, tt.in) {
+			t.Errorf("%s: %s %q, want %s with %q", tt.name, f.Status, f.Message, tt.want, tt.in)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package ipvs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/moby/ipvs"
+)
+
+// RealServer is a backend that receives traffic for the virtual service
+type RealServer struct {
+	Address net.IP
+	Port    uint16
+	Weight  int
+}
+
+func (rs RealServer) String() string {
+	return net.JoinHostPort(rs.Address.String(), strconv.Itoa(int(rs.Port)))
+}
+
+// Config describes the virtual service programmed for every VIP
+type Config struct {
+	Port          uint16
+	Protocol      string // "tcp" or "udp"
+	Scheduler     string // IPVS scheduler name, e.g. "rr", "wrr", "lc"
+	Forwarding    string // "nat", "dr" or "tunnel"
+	RealServers   []RealServer
+	CheckInterval time.Duration
+	CheckTimeout  time.Duration
+
+	// Logger receives the controller's log records. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// handle is the subset of *ipvs.Handle used by the controller
+type handle interface {
+	NewService(s *ipvs.Service) error
+	DelService(s *ipvs.Service) error
+	IsServicePresent(s *ipvs.Service) bool
+	NewDestination(s *ipvs.Service, d *ipvs.Destination) error
+	UpdateDestination(s *ipvs.Service, d *ipvs.Destination) error
+	Close()
+}
+
+// Controller programs IPVS virtual servers for the VIPs while the router is MASTER
+// and removes them otherwise. Real servers failing their TCP check are kept in the
+// service with weight 0 so existing connections drain.
+type Controller struct {
+	mu      sync.Mutex
+	cfg     Config
+	vips    []net.IP
+	handle  handle
+	active  bool
+	healthy []bool
+	logger  *slog.Logger
+
+	check func(ctx context.Context, rs RealServer, timeout time.Duration) error
+}
+
+// NewController validates the configuration and opens an IPVS netlink handle
+func NewController(cfg *Config, vips []net.IP) (*Controller, error) {
+	h, err := ipvs.New("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IPVS handle: %w", err)
+	}
+
+	c, err := newController(cfg, vips, h)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newController(cfg *Config, vips []net.IP, h handle) (*Controller, error) {
+	if cfg.Port == 0 {
+		return nil, fmt.Errorf("virtual service port is required")
+	}
+	if _, err := protocolNumber(cfg.Protocol); err != nil {
+		return nil, err
+	}
+	if _, err := forwardingFlags(cfg.Forwarding); err != nil {
+		return nil, err
+	}
+	if len(cfg.RealServers) == 0 {
+		return nil, fmt.Errorf("at least one real server is required")
+	}
+
+	c := &Controller{
+		cfg:     *cfg,
+		vips:    vips,
+		handle:  h,
+		healthy: make([]bool, len(cfg.RealServers)),
+		check:   tcpCheck,
+		logger:  cfg.Logger,
+	}
+
+	if c.cfg.Scheduler == "" {
+		c.cfg.Scheduler = "rr"
+	}
+	if c.logger == nil {
+		c.logger = slog.Default()
+	}
+	if c.cfg.CheckTimeout == 0 {
+		c.cfg.CheckTimeout = 2 * time.Second
+	}
+
+	// Real servers start healthy so the service is usable before the first check
+	for i := range c.healthy {
+		c.healthy[i] = true
+	}
+
+	return c, nil
+}
+
+// SetActive programs the virtual services when active is true and removes them otherwise
+func (c *Controller) SetActive(active bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active == active {
+		return nil
+	}
+	c.active = active
+
+	if active {
+		return c.program()
+	}
+	return c.unprogram()
+}
+
+// Run periodically checks real servers until ctx is canceled
+func (c *Controller) Run(ctx context.Context) {
+	if c.cfg.CheckInterval <= 0 || c.cfg.Protocol != "tcp" {
+		return
+	}
+
+	ticker := time.NewTicker(c.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		c.checkRealServers(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close removes any programmed services and releases the netlink handle
+func (c *Controller) Close() error {
+	err := c.SetActive(false)
+	c.handle.Close()
+	return err
+}
+
+func (c *Controller) checkRealServers(ctx context.Context) {
+	for i, rs := range c.cfg.RealServers {
+		healthy := c.check(ctx, rs, c.cfg.CheckTimeout) == nil
+
+		c.mu.Lock()
+		if c.healthy[i] != healthy {
+			c.healthy[i] = healthy
+			if healthy {
+				c.logger.Info("IPVS real server is up", "real_server", rs)
+			} else {
+				c.logger.Warn("IPVS real server is down", "real_server", rs)
+			}
+			if c.active {
+				if err := c.updateWeight(i); err != nil {
+					c.logger.Error("Failed to update IPVS real server", "real_server", rs, "err", err)
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *Controller) program() error {
+	for _, vip := range c.vips {
+		svc := c.service(vip)
+		if !c.handle.IsServicePresent(svc) {
+			if err := c.handle.NewService(svc); err != nil {
+				return fmt.Errorf("failed to add IPVS service %s: %w", c.serviceName(vip), err)
+			}
+		}
+
+		for i := range c.cfg.RealServers {
+			if err := c.handle.NewDestination(svc, c.destination(i)); err != nil {
+				return fmt.Errorf("failed to add IPVS real server %s to %s: %w",
+					c.cfg.RealServers[i], c.serviceName(vip), err)
+			}
+		}
+
+		c.logger.Info("Added IPVS service", "service", c.serviceName(vip), "real_servers", len(c.cfg.RealServers))
+	}
+
+	return nil
+}
+
+func (c *Controller) unprogram() error {
+	var firstErr error
+	for _, vip := range c.vips {
+		svc := c.service(vip)
+		if !c.handle.IsServicePresent(svc) {
+			continue
+		}
+		if err := c.handle.DelService(svc); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to delete IPVS service %s: %w", c.serviceName(vip), err)
+			}
+			continue
+		}
+		c.logger.Info("Removed IPVS service", "service", c.serviceName(vip))
+	}
+
+	return firstErr
+}
+
+func (c *Controller) updateWeight(i int) error {
+	for _, vip := range c.vips {
+		if err := c.handle.UpdateDestination(c.service(vip), c.destination(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) service(vip net.IP) *ipvs.Service {
+	proto, _ := protocolNumber(c.cfg.Protocol)
+	return &ipvs.Service{
+		Address:       vip,
+		Protocol:      proto,
+		Port:          c.cfg.Port,
+		SchedName:     c.cfg.Scheduler,
+		Netmask:       0xffffffff,
+		AddressFamily: syscall.AF_INET,
+	}
+}
+
+func (c *Controller) destination(i int) *ipvs.Destination {
+	rs := c.cfg.RealServers[i]
+	flags, _ := forwardingFlags(c.cfg.Forwarding)
+
+	weight := rs.Weight
+	if !c.healthy[i] {
+		weight = 0
+	}
+
+	return &ipvs.Destination{
+		Address:         rs.Address,
+		Port:            rs.Port,
+		Weight:          weight,
+		ConnectionFlags: flags,
+		AddressFamily:   syscall.AF_INET,
+	}
+}
+
+func (c *Controller) serviceName(vip net.IP) string {
+	return fmt.Sprintf("%s/%s", c.cfg.Protocol, net.JoinHostPort(vip.String(), strconv.Itoa(int(c.cfg.Port))))
+}
+
+func tcpCheck(ctx context.Context, rs RealServer, timeout time.Duration) error {
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "tcp", rs.String())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func protocolNumber(proto string) (uint16, error) {
+	switch proto {
+	case "tcp":
+		return syscall.IPPROTO_TCP, nil
+	case "udp":
+		return syscall.IPPROTO_UDP, nil
+	default:
+		return 0, fmt.Errorf("unsupported IPVS protocol: %q", proto)
+	}
+}
+
+func forwardingFlags(method string) (uint32, error) {
+	switch method {
+	case "", "nat":
+		return ipvs.ConnectionFlagMasq, nil
+	case "dr":
+		return ipvs.ConnectionFlagDirectRoute, nil
+	case "tunnel":
+		return ipvs.ConnectionFlagTunnel, nil
+	default:
+		return 0, fmt.Errorf("unsupported IPVS forwarding method: %q", method)
+	}
+}
+
+// ParseRealServers parses a comma-separated list of ip:port[:weight] entries
+func ParseRealServers(s string) ([]RealServer, error) {
+	var servers []RealServer
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("invalid real server %q: expected ip:port[:weight]", entry)
+		}
+
+		ip := net.ParseIP(parts[0]).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid real server address: %s", parts[0])
+		}
+
+		port, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid real server port: %s", parts[1])
+		}
+
+		weight := 1
+		if len(parts) == 3 {
+			weight, err = strconv.Atoi(parts[2])
+			if err != nil || weight < 0 {
+				return nil, fmt.Errorf("invalid real server weight: %s", parts[2])
+			}
+		}
+
+		servers = append(servers, RealServer{Address: ip, Port: uint16(port), Weight: weight})
+	}
+
+	return servers, nil
+}
@@ -0,0 +1,182 @@
+package ipvs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/ipvs"
+)
+
+type fakeHandle struct {
+	services     map[string]bool
+	destinations map[string]int
+}
+
+func newFakeHandle() *fakeHandle {
+	return &fakeHandle{
+		services:     make(map[string]bool),
+		destinations: make(map[string]int),
+	}
+}
+
+func (h *fakeHandle) NewService(s *ipvs.Service) error {
+	h.services[s.Address.String()] = true
+	return nil
+}
+
+func (h *fakeHandle) DelService(s *ipvs.Service) error {
+	delete(h.services, s.Address.String())
+	for key := range h.destinations {
+		if strings.HasPrefix(key, s.Address.String()+"->") {
+			delete(h.destinations, key)
+		}
+	}
+	return nil
+}
+
+func (h *fakeHandle) IsServicePresent(s *ipvs.Service) bool {
+	return h.services[s.Address.String()]
+}
+
+func (h *fakeHandle) NewDestination(s *ipvs.Service, d *ipvs.Destination) error {
+	h.destinations[s.Address.String()+"->"+d.Address.String()] = d.Weight
+	return nil
+}
+
+func (h *fakeHandle) UpdateDestination(s *ipvs.Service, d *ipvs.Destination) error {
+	return h.NewDestination(s, d)
+}
+
+func (h *fakeHandle) Close() {}
+
+func testConfig() *Config {
+	return &Config{
+		Port:      80,
+		Protocol:  "tcp",
+		Scheduler: "wrr",
+		RealServers: []RealServer{
+			{Address: net.ParseIP("10.0.1.1").To4(), Port: 8080, Weight: 3},
+			{Address: net.ParseIP("10.0.1.2").To4(), Port: 8080, Weight: 1},
+		},
+	}
+}
+
+func TestControllerSetActive(t *testing.T) {
+	h := newFakeHandle()
+	vips := []net.IP{net.ParseIP("192.168.1.100").To4()}
+
+	c, err := newController(testConfig(), vips, h)
+	if err != nil {
+		t.Fatalf("Failed to create controller: %v", err)
+	}
+
+	if err := c.SetActive(true); err != nil {
+		t.Fatalf("Failed to activate: %v", err)
+	}
+
+	if !h.services["192.168.1.100"] {
+		t.Error("Service should be programmed while active")
+	}
+	if w := h.destinations["192.168.1.100->10.0.1.1"]; w != 3 {
+		t.Errorf("Expected weight 3 for first real server, got %d", w)
+	}
+
+	if err := c.SetActive(false); err != nil {
+		t.Fatalf("Failed to deactivate: %v", err)
+	}
+
+	if h.services["192.168.1.100"] {
+		t.Error("Service should be removed when inactive")
+	}
+}
+
+func TestControllerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := testConfig()
+	cfg.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	c, err := newController(cfg, []net.IP{net.ParseIP("192.168.1.100").To4()}, newFakeHandle())
+	if err != nil {
+		t.Fatalf("Failed to create controller: %v", err)
+	}
+	if err := c.SetActive(true); err != nil {
+		t.Fatalf("Failed to activate: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "Added IPVS service") {
+		t.Errorf("Config.Logger did not receive the controller's records: %q", buf.String())
+	}
+}
+
+func TestControllerHealthCheck(t *testing.T) {
+	h := newFakeHandle()
+	vips := []net.IP{net.ParseIP("192.168.1.100").To4()}
+
+	c, err := newController(testConfig(), vips, h)
+	if err != nil {
+		t.Fatalf("Failed to create controller: %v", err)
+	}
+
+	c.check = func(_ context.Context, rs RealServer, _ time.Duration) error {
+		if rs.Address.Equal(net.ParseIP("10.0.1.2")) {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	if err := c.SetActive(true); err != nil {
+		t.Fatalf("Failed to activate: %v", err)
+	}
+
+	c.checkRealServers(context.Background())
+
+	if w := h.destinations["192.168.1.100->10.0.1.2"]; w != 0 {
+		t.Errorf("Failed real server should have weight 0, got %d", w)
+	}
+	if w := h.destinations["192.168.1.100->10.0.1.1"]; w != 3 {
+		t.Errorf("Healthy real server should keep weight 3, got %d", w)
+	}
+}
+
+func TestNewControllerValidation(t *testing.T) {
+	cfg := testConfig()
+	cfg.Protocol = "sctp"
+	if _, err := newController(cfg, nil, newFakeHandle()); err == nil {
+		t.Error("Expected error for unsupported protocol")
+	}
+
+	cfg = testConfig()
+	cfg.RealServers = nil
+	if _, err := newController(cfg, nil, newFakeHandle()); err == nil {
+		t.Error("Expected error when no real servers are given")
+	}
+}
+
+func TestParseRealServers(t *testing.T) {
+	servers, err := ParseRealServers("10.0.1.1:80, 10.0.1.2:8080:5")
+	if err != nil {
+		t.Fatalf("Failed to parse real servers: %v", err)
+	}
+
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 real servers, got %d", len(servers))
+	}
+	if servers[0].Weight != 1 {
+		t.Errorf("Expected default weight 1, got %d", servers[0].Weight)
+	}
+	if servers[1].Port != 8080 || servers[1].Weight != 5 {
+		t.Errorf("Unexpected second real server: %+v", servers[1])
+	}
+
+	for _, bad := range []string{"10.0.1.1", "10.0.1.1:0", "host:80", "10.0.1.1:80:x"} {
+		if _, err := ParseRealServers(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package logfile provides an io.Writer that appends to a file and rotates it
+// by size and age, keeping a bounded number of old files.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is appended to the file name of rotated files. It sorts
+// lexically in time order.
+const backupTimeFormat = "20060102-150405.000"
+
+// Config controls rotation and retention
+type Config struct {
+	Path string
+
+	// MaxSize rotates the file before a write would grow it past this many
+	// bytes (0 disables size-based rotation)
+	MaxSize int64
+
+	// RotateEvery rotates the file once it has been written to for this long
+	// (0 disables time-based rotation)
+	RotateEvery time.Duration
+
+	// MaxBackups is the number of rotated files to keep (0 keeps all)
+	MaxBackups int
+
+	// MaxAge removes rotated files older than this (0 keeps them regardless
+	// of age)
+	MaxAge time.Duration
+}
+
+// Writer is a rotating log file. It is safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	cfg    Config
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	now func() time.Time
+}
+
+// Open opens or creates cfg.Path for appending
+func Open(cfg Config) (*Writer, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("log file path is empty")
+	}
+
+	w := &Writer{cfg: cfg, now: time.Now}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.cfg.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(w.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	w.f = f
+	w.size = info.Size()
+	w.opened = w.now()
+	return nil
+}
+
+// Write appends p, rotating first if p would exceed MaxSize or the file is
+// older than RotateEvery. A single write larger than MaxSize still goes to
+// one file.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.needsRotation(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *Writer) needsRotation(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.cfg.MaxSize > 0 && w.size+n > w.cfg.MaxSize {
+		return true
+	}
+	return w.cfg.RotateEvery > 0 && w.now().Sub(w.opened) >= w.cfg.RotateEvery
+}
+
+// Rotate moves the current file aside and starts a new one
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	w.f = nil
+
+	backup := w.cfg.Path + "." + w.now().Format(backupTimeFormat)
+	if err := os.Rename(w.cfg.Path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	return w.prune()
+}
+
+// prune removes rotated files beyond MaxBackups or older than MaxAge
+func (w *Writer) prune() error {
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+
+	cutoff := w.now().Add(-w.cfg.MaxAge)
+	for i, name := range backups {
+		remove := w.cfg.MaxBackups > 0 && i >= w.cfg.MaxBackups
+		if !remove && w.cfg.MaxAge > 0 {
+			if info, err := os.Stat(name); err == nil && info.ModTime().Before(cutoff) {
+				remove = true
+			}
+		}
+		if remove {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove old log file: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// backups returns the rotated files, newest first
+func (w *Writer) backups() ([]string, error) {
+	matches, err := filepath.Glob(w.cfg.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := w.cfg.Path + "."
+	var out []string
+	for _, m := range matches {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(m, prefix)); err == nil {
+			out = append(out, m)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(out)))
+	return out, nil
+}
+
+// Close closes the current file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,170 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func openTest(t *testing.T, cfg Config) (*Writer, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	cfg.Path = filepath.Join(t.TempDir(), "vrrp.log")
+
+	w, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	w.now = clock.now
+	w.opened = clock.now()
+	t.Cleanup(func() { _ = w.Close() })
+	return w, clock
+}
+
+func write(t *testing.T, w *Writer, s string) {
+	t.Helper()
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return string(data)
+}
+
+func TestRotateBySize(t *testing.T) {
+	w, clock := openTest(t, Config{MaxSize: 10})
+
+	write(t, w, "12345\n")
+	clock.advance(time.Second)
+	write(t, w, "67890\n")
+
+	if got := readFile(t, w.cfg.Path); got != "67890\n" {
+		t.Errorf("current file = %q, want %q", got, "67890\n")
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("got %d backups, want 1", len(backups))
+	}
+	if got := readFile(t, backups[0]); got != "12345\n" {
+		t.Errorf("backup = %q, want %q", got, "12345\n")
+	}
+}
+
+func TestOversizedWriteIsNotSplit(t *testing.T) {
+	w, _ := openTest(t, Config{MaxSize: 4})
+
+	write(t, w, "0123456789\n")
+
+	if got := readFile(t, w.cfg.Path); got != "0123456789\n" {
+		t.Errorf("current file = %q", got)
+	}
+	if backups, _ := w.backups(); len(backups) != 0 {
+		t.Errorf("got %d backups, want none", len(backups))
+	}
+}
+
+func TestRotateByTime(t *testing.T) {
+	w, clock := openTest(t, Config{RotateEvery: time.Hour})
+
+	write(t, w, "a\n")
+	clock.advance(30 * time.Minute)
+	write(t, w, "b\n")
+	if backups, _ := w.backups(); len(backups) != 0 {
+		t.Fatalf("rotated after 30m")
+	}
+
+	clock.advance(30 * time.Minute)
+	write(t, w, "c\n")
+
+	if got := readFile(t, w.cfg.Path); got != "c\n" {
+		t.Errorf("current file = %q, want %q", got, "c\n")
+	}
+	backups, _ := w.backups()
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".20240102-040405.000") {
+		t.Errorf("backups = %v", backups)
+	}
+}
+
+func TestMaxBackups(t *testing.T) {
+	w, clock := openTest(t, Config{MaxSize: 2, MaxBackups: 2})
+
+	for _, s := range []string{"1\n", "2\n", "3\n", "4\n"} {
+		write(t, w, s)
+		clock.advance(time.Second)
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want 2", len(backups))
+	}
+	if got := readFile(t, backups[0]); got != "3\n" {
+		t.Errorf("newest backup = %q, want %q", got, "3\n")
+	}
+	if got := readFile(t, backups[1]); got != "2\n" {
+		t.Errorf("oldest backup = %q, want %q", got, "2\n")
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	w, _ := openTest(t, Config{MaxAge: 24 * time.Hour})
+
+	dir := filepath.Dir(w.cfg.Path)
+	old := w.cfg.Path + ".20230101-000000.000"
+	unrelated := filepath.Join(dir, "vrrp.log.keep")
+	for _, name := range []string{old, unrelated} {
+		if err := os.WriteFile(name, []byte("x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		stale := w.now().Add(-48 * time.Hour)
+		if err := os.Chtimes(name, stale, stale); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(t, w, "a\n")
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expired backup was not removed")
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("unrelated file was removed: %v", err)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	w, _ := openTest(t, Config{})
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Error("expected error writing to closed writer")
+	}
+}
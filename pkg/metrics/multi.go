@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"net"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Multi passes every event to each of its sinks, e.g. to be scraped by
+// Prometheus and sent to StatsD at once
+type Multi []vrrp.Metrics
+
+func (m Multi) StateChanged(iface string, vrid uint8, old, new vrrp.State) {
+	for _, s := range m {
+		s.StateChanged(iface, vrid, old, new)
+	}
+}
+
+func (m Multi) PriorityChanged(iface string, vrid uint8, priority uint8) {
+	for _, s := range m {
+		s.PriorityChanged(iface, vrid, priority)
+	}
+}
+
+func (m Multi) AdvertSent(iface string, vrid uint8, priority uint8) {
+	for _, s := range m {
+		s.AdvertSent(iface, vrid, priority)
+	}
+}
+
+func (m Multi) AdvertReceived(iface string, vrid uint8, priority uint8) {
+	for _, s := range m {
+		s.AdvertReceived(iface, vrid, priority)
+	}
+}
+
+func (m Multi) PacketDropped(iface string, vrid uint8, reason vrrp.DropReason) {
+	for _, s := range m {
+		s.PacketDropped(iface, vrid, reason)
+	}
+}
+
+func (m Multi) AdvertMismatch(iface string, vrid uint8, field vrrp.Mismatch) {
+	for _, s := range m {
+		s.AdvertMismatch(iface, vrid, field)
+	}
+}
+
+func (m Multi) PeerAdvert(iface string, vrid uint8, peer net.IP, version, priority uint8) {
+	for _, s := range m {
+		s.PeerAdvert(iface, vrid, peer, version, priority)
+	}
+}
+
+func (m Multi) AdvertJitter(iface string, vrid uint8, peer net.IP, jitter time.Duration) {
+	for _, s := range m {
+		s.AdvertJitter(iface, vrid, peer, jitter)
+	}
+}
+
+func (m Multi) FailoverLatency(iface string, vrid uint8, latency time.Duration) {
+	for _, s := range m {
+		s.FailoverLatency(iface, vrid, latency)
+	}
+}
+
+func (m Multi) SplitBrain(iface string, vrid uint8, kind vrrp.SplitBrainKind) {
+	for _, s := range m {
+		s.SplitBrain(iface, vrid, kind)
+	}
+}
+
+func (m Multi) VIPChanged(iface string, vrid uint8, op vrrp.VIPOp, ip net.IP, err error) {
+	for _, s := range m {
+		s.VIPChanged(iface, vrid, op, ip, err)
+	}
+}
@@ -0,0 +1,391 @@
+// Package metrics implements vrrp.Metrics for telemetry systems. Prometheus
+// keeps the events as counters and gauges and renders them in the Prometheus
+// text exposition format, without depending on the Prometheus client library.
+// StatsD pushes them to a StatsD or DogStatsD server instead, for sites that
+// do not scrape.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Prometheus collects the metrics of any number of virtual routers. It is
+// safe for concurrent use.
+type Prometheus struct {
+	mu      sync.Mutex
+	routers map[routerKey]*routerMetrics
+}
+
+type routerKey struct {
+	iface string
+	vrid  uint8
+}
+
+// sortedKeys returns the routers of m by interface, then VRID
+func sortedKeys[V any](m map[routerKey]V) []routerKey {
+	keys := make([]routerKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].iface != keys[j].iface {
+			return keys[i].iface < keys[j].iface
+		}
+		return keys[i].vrid < keys[j].vrid
+	})
+	return keys
+}
+
+type routerMetrics struct {
+	state           vrrp.State
+	priority        uint8
+	transitions     map[vrrp.State]uint64
+	advertsSent     uint64
+	advertsReceived uint64
+	priZeroSent     uint64
+	priZeroReceived uint64
+	drops           map[vrrp.DropReason]uint64
+	mismatches      map[vrrp.Mismatch]uint64
+	splitBrains     map[vrrp.SplitBrainKind]uint64
+	jitter          map[string]*vrrp.Histogram
+	peers           map[string]*peerMetrics
+	failover        vrrp.Histogram
+	vipOps          map[vipOutcome]uint64
+}
+
+// peerMetrics describe a router heard advertising, like vrrp.KnownPeer
+type peerMetrics struct {
+	adverts  uint64
+	priority uint8
+	version  uint8
+	lastSeen time.Time
+}
+
+type vipOutcome struct {
+	op     vrrp.VIPOp
+	result string
+}
+
+// NewPrometheus creates a collector without any routers
+func NewPrometheus() *Prometheus {
+	return &Prometheus{routers: make(map[routerKey]*routerMetrics)}
+}
+
+// router returns the metrics of a router, creating them on its first event.
+// p.mu must be held.
+func (p *Prometheus) router(iface string, vrid uint8) *routerMetrics {
+	key := routerKey{iface, vrid}
+	r := p.routers[key]
+	if r == nil {
+		r = &routerMetrics{
+			transitions: make(map[vrrp.State]uint64),
+			drops:       make(map[vrrp.DropReason]uint64),
+			mismatches:  make(map[vrrp.Mismatch]uint64),
+			splitBrains: make(map[vrrp.SplitBrainKind]uint64),
+			jitter:      make(map[string]*vrrp.Histogram),
+			peers:       make(map[string]*peerMetrics),
+			vipOps:      make(map[vipOutcome]uint64),
+		}
+		p.routers[key] = r
+	}
+	return r
+}
+
+func (p *Prometheus) StateChanged(iface string, vrid uint8, _, new vrrp.State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.router(iface, vrid)
+	r.state = new
+	r.transitions[new]++
+}
+
+func (p *Prometheus) PriorityChanged(iface string, vrid uint8, priority uint8) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.router(iface, vrid).priority = priority
+}
+
+func (p *Prometheus) AdvertSent(iface string, vrid uint8, priority uint8) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.router(iface, vrid)
+	r.advertsSent++
+	if priority == 0 {
+		r.priZeroSent++
+	}
+}
+
+func (p *Prometheus) AdvertReceived(iface string, vrid uint8, priority uint8) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.router(iface, vrid)
+	r.advertsReceived++
+	if priority == 0 {
+		r.priZeroReceived++
+	}
+}
+
+func (p *Prometheus) PacketDropped(iface string, vrid uint8, reason vrrp.DropReason) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.router(iface, vrid).drops[reason]++
+}
+
+func (p *Prometheus) AdvertMismatch(iface string, vrid uint8, field vrrp.Mismatch) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.router(iface, vrid).mismatches[field]++
+}
+
+// PeerAdvert keeps up to vrrp.MaxPeers peers per router; advertisements from
+// further sources are not counted
+func (p *Prometheus) PeerAdvert(iface string, vrid uint8, peer net.IP, version, priority uint8) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.router(iface, vrid)
+	m := r.peers[peer.String()]
+	if m == nil {
+		if len(r.peers) >= vrrp.MaxPeers {
+			return
+		}
+		m = &peerMetrics{}
+		r.peers[peer.String()] = m
+	}
+	m.adverts++
+	m.priority = priority
+	m.version = version
+	m.lastSeen = time.Now()
+}
+
+func (p *Prometheus) AdvertJitter(iface string, vrid uint8, peer net.IP, jitter time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.router(iface, vrid)
+	h := r.jitter[peer.String()]
+	if h == nil {
+		h = &vrrp.Histogram{}
+		r.jitter[peer.String()] = h
+	}
+	h.Observe(jitter)
+}
+
+func (p *Prometheus) FailoverLatency(iface string, vrid uint8, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.router(iface, vrid).failover.Observe(latency)
+}
+
+func (p *Prometheus) SplitBrain(iface string, vrid uint8, kind vrrp.SplitBrainKind) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.router(iface, vrid).splitBrains[kind]++
+}
+
+func (p *Prometheus) VIPChanged(iface string, vrid uint8, op vrrp.VIPOp, _ net.IP, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.router(iface, vrid).vipOps[vipOutcome{op, result}]++
+}
+
+// Forget drops the metrics of a router that was removed, so its series
+// disappear instead of going stale
+func (p *Prometheus) Forget(iface string, vrid uint8) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.routers, routerKey{iface, vrid})
+}
+
+// metric is one metric family being rendered
+type metric struct {
+	name, typ, help string
+	samples         []string
+}
+
+func (m *metric) add(labels string, value uint64) {
+	m.samples = append(m.samples, fmt.Sprintf("%s{%s} %d\n", m.name, labels, value))
+}
+
+// addHistogram adds the bucket, sum and count samples of h, in seconds and
+// with cumulative buckets as Prometheus expects
+func (m *metric) addHistogram(labels string, h vrrp.Histogram) {
+	var cumulative uint64
+	for i, bound := range vrrp.LatencyBuckets {
+		if h.Counts != nil {
+			cumulative += h.Counts[i]
+		}
+		m.samples = append(m.samples,
+			fmt.Sprintf("%s_bucket{%s,le=\"%g\"} %d\n", m.name, labels, bound.Seconds(), cumulative))
+	}
+	m.samples = append(m.samples,
+		fmt.Sprintf("%s_bucket{%s,le=\"+Inf\"} %d\n", m.name, labels, h.Count),
+		fmt.Sprintf("%s_sum{%s} %g\n", m.name, labels, h.Sum.Seconds()),
+		fmt.Sprintf("%s_count{%s} %d\n", m.name, labels, h.Count))
+}
+
+// WriteTo renders every metric in the Prometheus text exposition format,
+// routers sorted by interface and VRID
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	state := &metric{name: "vrrp_state", typ: "gauge",
+		help: "Current state of the virtual router (0 INIT, 1 BACKUP, 2 MASTER)"}
+	priority := &metric{name: "vrrp_priority", typ: "gauge", help: "Current priority of the virtual router"}
+	transitions := &metric{name: "vrrp_transitions_total", typ: "counter",
+		help: "State transitions, by the state entered"}
+	sent := &metric{name: "vrrp_adverts_sent_total", typ: "counter", help: "Advertisements sent"}
+	received := &metric{name: "vrrp_adverts_received_total", typ: "counter",
+		help: "Valid advertisements received for the virtual router"}
+	priZeroSent := &metric{name: "vrrp_priority_zero_sent_total", typ: "counter",
+		help: "Priority 0 advertisements sent when giving up mastership"}
+	priZeroReceived := &metric{name: "vrrp_priority_zero_received_total", typ: "counter",
+		help: "Priority 0 advertisements received from a master giving up"}
+	drops := &metric{name: "vrrp_packets_dropped_total", typ: "counter", help: "Packets discarded, by reason"}
+	mismatches := &metric{name: "vrrp_advert_mismatches_total", typ: "counter",
+		help: "Advertisements disagreeing with the local configuration, by field"}
+	splitBrains := &metric{name: "vrrp_split_brain_total", typ: "counter",
+		help: "Other routers found acting as MASTER at the same time, by how they were noticed"}
+	jitter := &metric{name: "vrrp_advert_jitter_seconds", typ: "histogram",
+		help: "Deviation of the time between a peer's advertisements from its advertised interval"}
+	failover := &metric{name: "vrrp_failover_latency_seconds", typ: "histogram",
+		help: "Time from the master down timer firing to the virtual IPs being programmed"}
+	peerCount := &metric{name: "vrrp_peers", typ: "gauge", help: "Routers heard advertising for the VRID"}
+	peerAdverts := &metric{name: "vrrp_peer_adverts_total", typ: "counter",
+		help: "Valid advertisements received, by source"}
+	peerPriority := &metric{name: "vrrp_peer_priority", typ: "gauge", help: "Priority last advertised, by source"}
+	peerVersion := &metric{name: "vrrp_peer_version", typ: "gauge",
+		help: "VRRP version last advertised, by source"}
+	peerLastSeen := &metric{name: "vrrp_peer_last_seen_timestamp_seconds", typ: "gauge",
+		help: "Unix time of the last advertisement, by source"}
+	vipOps := &metric{name: "vrrp_vip_operations_total", typ: "counter",
+		help: "Virtual IP additions and removals, by outcome"}
+
+	p.mu.Lock()
+	keys := sortedKeys(p.routers)
+
+	for _, key := range keys {
+		r := p.routers[key]
+		labels := fmt.Sprintf(`iface=%q,vrid="%d"`, key.iface, key.vrid)
+
+		state.add(labels, uint64(r.state))
+		priority.add(labels, uint64(r.priority))
+		for _, s := range []vrrp.State{vrrp.Init, vrrp.Backup, vrrp.Master} {
+			transitions.add(fmt.Sprintf(`%s,state=%q`, labels, s), r.transitions[s])
+		}
+		sent.add(labels, r.advertsSent)
+		received.add(labels, r.advertsReceived)
+		priZeroSent.add(labels, r.priZeroSent)
+		priZeroReceived.add(labels, r.priZeroReceived)
+
+		reasons := make([]string, 0, len(r.drops))
+		for reason := range r.drops {
+			reasons = append(reasons, string(reason))
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			drops.add(fmt.Sprintf(`%s,reason=%q`, labels, reason), r.drops[vrrp.DropReason(reason)])
+		}
+
+		for _, field := range []vrrp.Mismatch{vrrp.MismatchAdvInterval, vrrp.MismatchAddressList} {
+			mismatches.add(fmt.Sprintf(`%s,field=%q`, labels, field), r.mismatches[field])
+		}
+
+		for _, kind := range []vrrp.SplitBrainKind{vrrp.SplitBrainAdverts, vrrp.SplitBrainARP} {
+			splitBrains.add(fmt.Sprintf(`%s,kind=%q`, labels, kind), r.splitBrains[kind])
+		}
+
+		peers := make([]string, 0, len(r.jitter))
+		for peer := range r.jitter {
+			peers = append(peers, peer)
+		}
+		sort.Strings(peers)
+		for _, peer := range peers {
+			jitter.addHistogram(fmt.Sprintf(`%s,peer=%q`, labels, peer), *r.jitter[peer])
+		}
+
+		peerCount.add(labels, uint64(len(r.peers)))
+		peers = peers[:0]
+		for peer := range r.peers {
+			peers = append(peers, peer)
+		}
+		sort.Strings(peers)
+		for _, peer := range peers {
+			m, peerLabels := r.peers[peer], fmt.Sprintf(`%s,peer=%q`, labels, peer)
+			peerAdverts.add(peerLabels, m.adverts)
+			peerPriority.add(peerLabels, uint64(m.priority))
+			peerVersion.add(peerLabels, uint64(m.version))
+			peerLastSeen.add(peerLabels, uint64(m.lastSeen.Unix()))
+		}
+		failover.addHistogram(labels, r.failover)
+
+		for _, op := range []vrrp.VIPOp{vrrp.VIPAdd, vrrp.VIPDelete} {
+			for _, result := range []string{"ok", "error"} {
+				if n, ok := r.vipOps[vipOutcome{op, result}]; ok {
+					vipOps.add(fmt.Sprintf(`%s,op=%q,result=%q`, labels, op, result), n)
+				}
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range []*metric{state, priority, transitions, sent, received, priZeroSent, priZeroReceived,
+		drops, mismatches, splitBrains, jitter, failover, peerCount, peerAdverts, peerPriority, peerVersion,
+		peerLastSeen, vipOps} {
+		if len(m.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, sample := range m.samples {
+			b.WriteString(sample)
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// WriteFile writes the metrics to path atomically, through a temporary file
+// in the same directory renamed over it, so that a reader such as the
+// node_exporter textfile collector never sees half of them. The temporary
+// file does not end in .prom, which the collector would read.
+func (p *Prometheus) WriteFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := p.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func (p *Prometheus) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = p.WriteTo(w)
+	})
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var _ vrrp.Metrics = (*Prometheus)(nil)
+
+func TestPrometheusWriteTo(t *testing.T) {
+	p := NewPrometheus()
+	vip := net.ParseIP("192.168.1.100")
+
+	p.PriorityChanged("eth1", 20, 100)
+	p.PriorityChanged("eth0", 10, 150)
+	p.StateChanged("eth0", 10, vrrp.Init, vrrp.Backup)
+	p.StateChanged("eth0", 10, vrrp.Backup, vrrp.Master)
+	p.AdvertSent("eth0", 10, 150)
+	p.AdvertSent("eth0", 10, 150)
+	p.AdvertSent("eth0", 10, 0)
+	p.AdvertReceived("eth1", 20, 200)
+	p.AdvertReceived("eth1", 20, 0)
+	p.AdvertMismatch("eth1", 20, vrrp.MismatchAdvInterval)
+	p.SplitBrain("eth0", 10, vrrp.SplitBrainARP)
+	p.AdvertJitter("eth1", 20, net.ParseIP("10.0.0.2"), 3*time.Millisecond)
+	p.PeerAdvert("eth1", 20, net.ParseIP("10.0.0.2"), vrrp.VRRPv2, 200)
+	p.PeerAdvert("eth1", 20, net.ParseIP("10.0.0.2"), vrrp.VRRPv2, 0)
+	p.PeerAdvert("eth1", 20, net.ParseIP("10.0.0.3"), vrrp.VRRPv3, 90)
+	p.FailoverLatency("eth0", 10, 40*time.Millisecond)
+	p.PacketDropped("eth0", 10, vrrp.DropTTL)
+	p.VIPChanged("eth0", 10, vrrp.VIPAdd, vip, nil)
+	p.VIPChanged("eth0", 10, vrrp.VIPAdd, vip, errors.New("file exists"))
+
+	var b strings.Builder
+	if _, err := p.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE vrrp_state gauge\n",
+		`vrrp_state{iface="eth0",vrid="10"} 2` + "\n",
+		`vrrp_state{iface="eth1",vrid="20"} 0` + "\n",
+		`vrrp_priority{iface="eth0",vrid="10"} 150` + "\n",
+		`vrrp_transitions_total{iface="eth0",vrid="10",state="MASTER"} 1` + "\n",
+		`vrrp_adverts_sent_total{iface="eth0",vrid="10"} 3` + "\n",
+		`vrrp_adverts_received_total{iface="eth1",vrid="20"} 2` + "\n",
+		`vrrp_priority_zero_sent_total{iface="eth0",vrid="10"} 1` + "\n",
+		`vrrp_priority_zero_received_total{iface="eth1",vrid="20"} 1` + "\n",
+		`vrrp_advert_mismatches_total{iface="eth1",vrid="20",field="advert_interval"} 1` + "\n",
+		`vrrp_advert_mismatches_total{iface="eth1",vrid="20",field="address_list"} 0` + "\n",
+		`vrrp_split_brain_total{iface="eth0",vrid="10",kind="arp"} 1` + "\n",
+		"# TYPE vrrp_advert_jitter_seconds histogram\n",
+		`vrrp_advert_jitter_seconds_bucket{iface="eth1",vrid="20",peer="10.0.0.2",le="0.001"} 0` + "\n",
+		`vrrp_advert_jitter_seconds_bucket{iface="eth1",vrid="20",peer="10.0.0.2",le="0.005"} 1` + "\n",
+		`vrrp_advert_jitter_seconds_count{iface="eth1",vrid="20",peer="10.0.0.2"} 1` + "\n",
+		`vrrp_failover_latency_seconds_bucket{iface="eth0",vrid="10",le="0.025"} 0` + "\n",
+		`vrrp_failover_latency_seconds_bucket{iface="eth0",vrid="10",le="+Inf"} 1` + "\n",
+		`vrrp_failover_latency_seconds_sum{iface="eth0",vrid="10"} 0.04` + "\n",
+		`vrrp_packets_dropped_total{iface="eth0",vrid="10",reason="ttl"} 1` + "\n",
+		`vrrp_peers{iface="eth0",vrid="10"} 0` + "\n",
+		`vrrp_peers{iface="eth1",vrid="20"} 2` + "\n",
+		`vrrp_peer_adverts_total{iface="eth1",vrid="20",peer="10.0.0.2"} 2` + "\n",
+		`vrrp_peer_priority{iface="eth1",vrid="20",peer="10.0.0.2"} 0` + "\n",
+		`vrrp_peer_version{iface="eth1",vrid="20",peer="10.0.0.3"} 3` + "\n",
+		`vrrp_peer_last_seen_timestamp_seconds{iface="eth1",vrid="20",peer="10.0.0.3"} `,
+		`vrrp_vip_operations_total{iface="eth0",vrid="10",op="add",result="ok"} 1` + "\n",
+		`vrrp_vip_operations_total{iface="eth0",vrid="10",op="add",result="error"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	if strings.Index(out, `iface="eth0"`) > strings.Index(out, `iface="eth1"`) {
+		t.Error("routers are not sorted by interface")
+	}
+}
+
+func TestPrometheusForget(t *testing.T) {
+	p := NewPrometheus()
+	p.PriorityChanged("eth0", 10, 100)
+	p.Forget("eth0", 10)
+
+	var b strings.Builder
+	if _, err := p.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("forgotten router still rendered:\n%s", b.String())
+	}
+}
+
+func TestPrometheusWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vrrp.prom")
+	p := NewPrometheus()
+	p.PriorityChanged("eth0", 10, 100)
+	if err := p.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	p.PriorityChanged("eth0", 10, 50)
+	if err := p.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile again: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `vrrp_priority{iface="eth0",vrid="10"} 50`) {
+		t.Errorf("file holds:\n%s", data)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Errorf("file mode = %v, %v; want 0644 for node_exporter to read", fi.Mode(), err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only vrrp.prom", len(entries))
+	}
+
+	if err := p.WriteFile(filepath.Join(dir, "missing", "vrrp.prom")); err == nil {
+		t.Error("WriteFile to a missing directory succeeded")
+	}
+}
@@ -0,0 +1,342 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Defaults of StatsDConfig
+const (
+	DefaultStatsDPrefix   = "vrrp"
+	DefaultStatsDInterval = 10 * time.Second
+)
+
+// maxDatagram is the most a flush puts in one UDP packet, so that it fits an
+// Ethernet frame without fragmenting
+const maxDatagram = 1432
+
+// StatsDConfig describes where and how StatsD sends the metrics
+type StatsDConfig struct {
+	// Address is the StatsD or DogStatsD server, host:port over UDP
+	Address string
+	// Prefix starts every metric name, DefaultStatsDPrefix if empty
+	Prefix string
+	// Tagged sends the interface, VRID, state and the like as DogStatsD
+	// tags. Otherwise they are part of the metric names, as Graphite wants,
+	// e.g. vrrp.eth0.10.transitions.master.
+	Tagged bool
+	// Tags are added to every metric when Tagged, e.g. env:prod
+	Tags []string
+	// Interval is how often Run sends the metrics, DefaultStatsDInterval if
+	// zero
+	Interval time.Duration
+
+	// Logger receives the sink's log records. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// StatsD sends the metrics of any number of virtual routers to a StatsD
+// server. Events are only counted as they happen; Run sends the state and
+// priority gauges and the counts since the last send every interval. It is
+// safe for concurrent use.
+type StatsD struct {
+	cfg    StatsDConfig
+	conn   net.Conn
+	logger *slog.Logger
+	// tags are cfg.Tags, ready to append to a line
+	tags string
+
+	mu      sync.Mutex
+	routers map[routerKey]*statsdRouter
+	// failing is set after a send failed, to log once until one succeeds
+	failing bool
+}
+
+// statsdRouter is what is sent for a router at the next flush; the counts
+// restart from zero after each
+type statsdRouter struct {
+	state           vrrp.State
+	priority        uint8
+	transitions     map[vrrp.State]uint64
+	advertsSent     uint64
+	advertsReceived uint64
+	drops           map[vrrp.DropReason]uint64
+	splitBrains     map[vrrp.SplitBrainKind]uint64
+	failover        []time.Duration
+}
+
+// NewStatsD resolves the server's address. Nothing is sent until Flush or
+// Run.
+func NewStatsD(cfg *StatsDConfig) (*StatsD, error) {
+	s := &StatsD{
+		cfg:     *cfg,
+		logger:  cfg.Logger,
+		routers: make(map[routerKey]*statsdRouter),
+	}
+	if s.cfg.Prefix == "" {
+		s.cfg.Prefix = DefaultStatsDPrefix
+	}
+	if s.cfg.Interval == 0 {
+		s.cfg.Interval = DefaultStatsDInterval
+	}
+	if s.cfg.Interval < 0 {
+		return nil, errors.New("statsd interval must be positive")
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	for _, tag := range s.cfg.Tags {
+		if tag == "" || strings.ContainsAny(tag, ",|#\n") {
+			return nil, fmt.Errorf("invalid statsd tag %q", tag)
+		}
+		s.tags += "," + tag
+	}
+
+	if _, _, err := net.SplitHostPort(s.cfg.Address); err != nil {
+		return nil, fmt.Errorf("invalid statsd address %q: want host:port", s.cfg.Address)
+	}
+	conn, err := net.Dial("udp", s.cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve statsd address: %w", err)
+	}
+	s.conn = conn
+	return s, nil
+}
+
+// router returns what is pending for a router, creating it on its first
+// event. s.mu must be held.
+func (s *StatsD) router(iface string, vrid uint8) *statsdRouter {
+	key := routerKey{iface, vrid}
+	r := s.routers[key]
+	if r == nil {
+		r = &statsdRouter{
+			transitions: make(map[vrrp.State]uint64),
+			drops:       make(map[vrrp.DropReason]uint64),
+			splitBrains: make(map[vrrp.SplitBrainKind]uint64),
+		}
+		s.routers[key] = r
+	}
+	return r
+}
+
+func (s *StatsD) StateChanged(iface string, vrid uint8, _, new vrrp.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.router(iface, vrid)
+	r.state = new
+	r.transitions[new]++
+}
+
+func (s *StatsD) PriorityChanged(iface string, vrid uint8, priority uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.router(iface, vrid).priority = priority
+}
+
+func (s *StatsD) AdvertSent(iface string, vrid uint8, _ uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.router(iface, vrid).advertsSent++
+}
+
+func (s *StatsD) AdvertReceived(iface string, vrid uint8, _ uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.router(iface, vrid).advertsReceived++
+}
+
+func (s *StatsD) PacketDropped(iface string, vrid uint8, reason vrrp.DropReason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.router(iface, vrid).drops[reason]++
+}
+
+func (s *StatsD) SplitBrain(iface string, vrid uint8, kind vrrp.SplitBrainKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.router(iface, vrid).splitBrains[kind]++
+}
+
+// The mismatches, peers, jitter and virtual IP operations are left to
+// Prometheus: their label sets grow with the network
+
+func (s *StatsD) AdvertMismatch(string, uint8, vrrp.Mismatch)         {}
+func (s *StatsD) PeerAdvert(string, uint8, net.IP, uint8, uint8)      {}
+func (s *StatsD) AdvertJitter(string, uint8, net.IP, time.Duration)   {}
+func (s *StatsD) VIPChanged(string, uint8, vrrp.VIPOp, net.IP, error) {}
+
+// FailoverLatency is sent as a timing, which the server aggregates
+func (s *StatsD) FailoverLatency(iface string, vrid uint8, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.router(iface, vrid)
+	r.failover = append(r.failover, latency)
+}
+
+// Forget stops sending the gauges of a router that was removed
+func (s *StatsD) Forget(iface string, vrid uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.routers, routerKey{iface, vrid})
+}
+
+// Run sends the metrics every interval until ctx is canceled
+func (s *StatsD) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.send()
+	}
+}
+
+// Close closes the socket; Flush first to send what is pending
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// send flushes and logs the first of a run of failures
+func (s *StatsD) send() {
+	err := s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil && !s.failing:
+		s.logger.Warn("Failed to send metrics to StatsD", "addr", s.cfg.Address, "err", err)
+	case err == nil && s.failing:
+		s.logger.Info("Sending metrics to StatsD again", "addr", s.cfg.Address)
+	}
+	s.failing = err != nil
+}
+
+// Flush sends the gauges of every router and the counts since the last
+// flush now, e.g. the last transitions of a daemon that stops. Counts that
+// fail to send are not kept: StatsD is lossy anyway.
+func (s *StatsD) Flush() error {
+	var lines []string
+	s.mu.Lock()
+	keys := sortedKeys(s.routers)
+	for _, key := range keys {
+		lines = s.appendLines(lines, key, s.routers[key])
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > maxDatagram {
+			if _, err := s.conn.Write(packet); err != nil {
+				errs = append(errs, err)
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if _, err := s.conn.Write(packet); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// appendLines renders r and restarts its counts. s.mu must be held.
+func (s *StatsD) appendLines(lines []string, key routerKey, r *statsdRouter) []string {
+	lines = append(lines,
+		s.line(key, "state", "", "", strconv.Itoa(int(r.state))+"|g"),
+		s.line(key, "priority", "", "", strconv.Itoa(int(r.priority))+"|g"))
+
+	for _, state := range []vrrp.State{vrrp.Init, vrrp.Backup, vrrp.Master} {
+		if n := r.transitions[state]; n > 0 {
+			lines = append(lines, s.line(key, "transitions", "state", strings.ToLower(state.String()), count(n)))
+		}
+	}
+	if r.advertsSent > 0 {
+		lines = append(lines, s.line(key, "adverts_sent", "", "", count(r.advertsSent)))
+	}
+	if r.advertsReceived > 0 {
+		lines = append(lines, s.line(key, "adverts_received", "", "", count(r.advertsReceived)))
+	}
+	for _, reason := range vrrp.DropReasons {
+		if n := r.drops[reason]; n > 0 {
+			lines = append(lines, s.line(key, "packets_dropped", "reason", string(reason), count(n)))
+		}
+	}
+	for _, kind := range []vrrp.SplitBrainKind{vrrp.SplitBrainAdverts, vrrp.SplitBrainARP} {
+		if n := r.splitBrains[kind]; n > 0 {
+			lines = append(lines, s.line(key, "split_brain", "kind", string(kind), count(n)))
+		}
+	}
+	for _, latency := range r.failover {
+		ms := strconv.FormatFloat(float64(latency)/float64(time.Millisecond), 'f', -1, 64)
+		lines = append(lines, s.line(key, "failover_latency", "", "", ms+"|ms"))
+	}
+
+	clear(r.transitions)
+	clear(r.drops)
+	clear(r.splitBrains)
+	r.advertsSent, r.advertsReceived, r.failover = 0, 0, nil
+	return lines
+}
+
+// line renders one metric of a router, with an optional tag, ending with
+// value, which holds the value and type, e.g. "1|c"
+func (s *StatsD) line(key routerKey, name, tag, tagValue, value string) string {
+	vrid := strconv.Itoa(int(key.vrid))
+	if !s.cfg.Tagged {
+		name = s.cfg.Prefix + "." + nameComponent(key.iface) + "." + vrid + "." + name
+		if tag != "" {
+			name += "." + nameComponent(tagValue)
+		}
+		return name + ":" + value
+	}
+
+	line := s.cfg.Prefix + "." + name + ":" + value + "|#iface:" + tagComponent(key.iface) + ",vrid:" + vrid
+	if tag != "" {
+		line += "," + tag + ":" + tagComponent(tagValue)
+	}
+	return line + s.tags
+}
+
+func count(n uint64) string {
+	return strconv.FormatUint(n, 10) + "|c"
+}
+
+// nameComponent replaces the characters that would split or end a Graphite
+// name, such as the dot of a VLAN interface, with "_"
+func nameComponent(s string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			return c
+		}
+		return '_'
+	}, s)
+}
+
+// tagComponent replaces the characters that would end a DogStatsD tag with
+// "_"
+func tagComponent(s string) string {
+	return strings.Map(func(c rune) rune {
+		if strings.ContainsRune(",|#: \n", c) {
+			return '_'
+		}
+		return c
+	}, s)
+}
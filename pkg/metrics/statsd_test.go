@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var _ vrrp.Metrics = (*StatsD)(nil)
+var _ vrrp.Metrics = Multi(nil)
+
+// newTestStatsD returns a sink sending to a socket the test reads from
+func newTestStatsD(t *testing.T, tagged bool, tags ...string) (*StatsD, *net.UDPConn) {
+	t.Helper()
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	s, err := NewStatsD(&StatsDConfig{
+		Address: server.LocalAddr().String(),
+		Tagged:  tagged,
+		Tags:    tags,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewStatsD: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s, server
+}
+
+// receive reads the lines of the datagrams sent by a flush
+func receive(t *testing.T, server *net.UDPConn) (lines []string, packets int) {
+	t.Helper()
+	buf := make([]byte, 65536)
+	for {
+		_ = server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := server.Read(buf)
+		if err != nil {
+			return lines, packets
+		}
+		if n > maxDatagram {
+			t.Errorf("datagram of %d bytes, want at most %d", n, maxDatagram)
+		}
+		packets++
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	s, server := newTestStatsD(t, false)
+
+	s.PriorityChanged("eth0.100", 10, 150)
+	s.StateChanged("eth0.100", 10, vrrp.Init, vrrp.Backup)
+	s.StateChanged("eth0.100", 10, vrrp.Backup, vrrp.Master)
+	s.AdvertSent("eth0.100", 10, 150)
+	s.AdvertSent("eth0.100", 10, 150)
+	s.AdvertReceived("eth0.100", 10, 200)
+	s.PacketDropped("eth0.100", 10, vrrp.DropTTL)
+	s.FailoverLatency("eth0.100", 10, 1500*time.Microsecond)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	got, _ := receive(t, server)
+	want := []string{
+		"vrrp.eth0_100.10.state:2|g",
+		"vrrp.eth0_100.10.priority:150|g",
+		"vrrp.eth0_100.10.transitions.backup:1|c",
+		"vrrp.eth0_100.10.transitions.master:1|c",
+		"vrrp.eth0_100.10.adverts_sent:2|c",
+		"vrrp.eth0_100.10.adverts_received:1|c",
+		"vrrp.eth0_100.10.packets_dropped.ttl:1|c",
+		"vrrp.eth0_100.10.failover_latency:1.5|ms",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("first flush sent\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The counts restart; the gauges are sent again
+	s.AdvertSent("eth0.100", 10, 150)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	got, _ = receive(t, server)
+	want = []string{
+		"vrrp.eth0_100.10.state:2|g",
+		"vrrp.eth0_100.10.priority:150|g",
+		"vrrp.eth0_100.10.adverts_sent:1|c",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("second flush sent\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	s.Forget("eth0.100", 10)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got, _ := receive(t, server); len(got) != 0 {
+		t.Errorf("flush after Forget sent %q", got)
+	}
+}
+
+func TestStatsDTagged(t *testing.T) {
+	s, server := newTestStatsD(t, true, "env:prod")
+
+	s.StateChanged("eth0.100", 10, vrrp.Init, vrrp.Backup)
+	s.SplitBrain("eth0.100", 10, vrrp.SplitBrainARP)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	got, _ := receive(t, server)
+	want := []string{
+		"vrrp.state:1|g|#iface:eth0.100,vrid:10,env:prod",
+		"vrrp.priority:0|g|#iface:eth0.100,vrid:10,env:prod",
+		"vrrp.transitions:1|c|#iface:eth0.100,vrid:10,state:backup,env:prod",
+		"vrrp.split_brain:1|c|#iface:eth0.100,vrid:10,kind:arp,env:prod",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("flush sent\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStatsDSplitsDatagrams(t *testing.T) {
+	s, server := newTestStatsD(t, true)
+	for i := range 100 {
+		s.StateChanged(fmt.Sprintf("eth%d", i), 10, vrrp.Init, vrrp.Backup)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	lines, packets := receive(t, server)
+	if len(lines) != 300 || packets < 2 {
+		t.Errorf("%d lines in %d datagrams, want 300 in several", len(lines), packets)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	a, b := NewPrometheus(), NewPrometheus()
+	m := Multi{a, b}
+	m.StateChanged("eth0", 10, vrrp.Init, vrrp.Master)
+	m.AdvertSent("eth0", 10, 100)
+	for _, p := range []*Prometheus{a, b} {
+		var out strings.Builder
+		if _, err := p.WriteTo(&out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), `vrrp_adverts_sent_total{iface="eth0",vrid="10"} 1`) {
+			t.Errorf("a sink missed the events:\n%s", out.String())
+		}
+	}
+}
+
+func TestNewStatsDRejects(t *testing.T) {
+	for name, cfg := range map[string]*StatsDConfig{
+		"an address without port": {Address: "127.0.0.1"},
+		"a tag with a comma":      {Address: "127.0.0.1:8125", Tagged: true, Tags: []string{"env:prod,team:net"}},
+		"an empty tag":            {Address: "127.0.0.1:8125", Tagged: true, Tags: []string{""}},
+		"a negative interval":     {Address: "127.0.0.1:8125", Interval: -time.Second},
+	} {
+		if _, err := NewStatsD(cfg); err == nil {
+			t.Errorf("NewStatsD with %s succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package metrics
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Defaults of ZabbixConfig
+const (
+	DefaultZabbixPort     = "10051"
+	DefaultZabbixPrefix   = "vrrp"
+	DefaultZabbixInterval = time.Minute
+)
+
+// zabbixTimeout bounds one exchange with the Zabbix server
+const zabbixTimeout = 10 * time.Second
+
+// maxZabbixResponse bounds the response read from the server
+const maxZabbixResponse = 64 << 10
+
+// ZabbixConfig describes where and as what host Zabbix receives the items
+type ZabbixConfig struct {
+	// Server is the Zabbix server or proxy, host[:port], DefaultZabbixPort
+	// if no port is given
+	Server string
+	// Host is the host name the items belong to in Zabbix, the hostname if
+	// empty
+	Host string
+	// Prefix starts every item key, DefaultZabbixPrefix if empty
+	Prefix string
+	// Interval is how often Run sends every item besides at transitions,
+	// DefaultZabbixInterval if zero
+	Interval time.Duration
+
+	// Logger receives the sender's log records. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// Zabbix sends the state and counters of any number of virtual routers to a
+// Zabbix server as trapper items, with the protocol of zabbix_sender. Run
+// sends every item at each transition and every interval. It is safe for
+// concurrent use.
+type Zabbix struct {
+	cfg    ZabbixConfig
+	logger *slog.Logger
+	dialer net.Dialer
+
+	mu      sync.Mutex
+	routers map[routerKey]*zabbixRouter
+	wake    chan struct{}
+	// failing is set after a send failed, to log once until one succeeds
+	failing bool
+}
+
+// zabbixRouter holds the values of a router's items; the counters are
+// totals since the router started
+type zabbixRouter struct {
+	state           vrrp.State
+	priority        uint8
+	transitions     map[vrrp.State]uint64
+	advertsSent     uint64
+	advertsReceived uint64
+	packetsDropped  uint64
+	splitBrains     uint64
+}
+
+// NewZabbix checks the server address and finds the host name
+func NewZabbix(cfg *ZabbixConfig) (*Zabbix, error) {
+	z := &Zabbix{
+		cfg:     *cfg,
+		logger:  cfg.Logger,
+		dialer:  net.Dialer{Timeout: zabbixTimeout},
+		routers: make(map[routerKey]*zabbixRouter),
+		wake:    make(chan struct{}, 1),
+	}
+	if z.cfg.Prefix == "" {
+		z.cfg.Prefix = DefaultZabbixPrefix
+	}
+	if z.cfg.Interval == 0 {
+		z.cfg.Interval = DefaultZabbixInterval
+	}
+	if z.cfg.Interval < 0 {
+		return nil, errors.New("zabbix interval must be positive")
+	}
+	if z.logger == nil {
+		z.logger = slog.Default()
+	}
+	if z.cfg.Host == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the hostname: %w", err)
+		}
+		z.cfg.Host = host
+	}
+
+	if z.cfg.Server == "" {
+		return nil, errors.New("zabbix server is required")
+	}
+	if _, _, err := net.SplitHostPort(z.cfg.Server); err != nil {
+		z.cfg.Server = net.JoinHostPort(strings.Trim(z.cfg.Server, "[]"), DefaultZabbixPort)
+	}
+	if _, port, err := net.SplitHostPort(z.cfg.Server); err != nil || port == "" {
+		return nil, fmt.Errorf("invalid zabbix server %q: want host[:port]", cfg.Server)
+	}
+	return z, nil
+}
+
+// router returns the items of a router, creating them on its first event.
+// z.mu must be held.
+func (z *Zabbix) router(iface string, vrid uint8) *zabbixRouter {
+	key := routerKey{iface, vrid}
+	r := z.routers[key]
+	if r == nil {
+		r = &zabbixRouter{transitions: make(map[vrrp.State]uint64)}
+		z.routers[key] = r
+	}
+	return r
+}
+
+// StateChanged wakes Run, so that Zabbix learns of the transition without
+// waiting for the interval
+func (z *Zabbix) StateChanged(iface string, vrid uint8, _, new vrrp.State) {
+	z.mu.Lock()
+	r := z.router(iface, vrid)
+	r.state = new
+	r.transitions[new]++
+	z.mu.Unlock()
+
+	select {
+	case z.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (z *Zabbix) PriorityChanged(iface string, vrid uint8, priority uint8) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.router(iface, vrid).priority = priority
+}
+
+func (z *Zabbix) AdvertSent(iface string, vrid uint8, _ uint8) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.router(iface, vrid).advertsSent++
+}
+
+func (z *Zabbix) AdvertReceived(iface string, vrid uint8, _ uint8) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.router(iface, vrid).advertsReceived++
+}
+
+// PacketDropped counts the drops of every reason in one item, except the
+// router's own advertisements, so that a trigger can fire on any
+func (z *Zabbix) PacketDropped(iface string, vrid uint8, reason vrrp.DropReason) {
+	if reason == vrrp.DropOwn {
+		return
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.router(iface, vrid).packetsDropped++
+}
+
+func (z *Zabbix) SplitBrain(iface string, vrid uint8, _ vrrp.SplitBrainKind) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.router(iface, vrid).splitBrains++
+}
+
+// The mismatches, peers, jitter, failover latency and virtual IP operations
+// are left to Prometheus: each would be another item to create in Zabbix
+
+func (z *Zabbix) AdvertMismatch(string, uint8, vrrp.Mismatch)         {}
+func (z *Zabbix) PeerAdvert(string, uint8, net.IP, uint8, uint8)      {}
+func (z *Zabbix) AdvertJitter(string, uint8, net.IP, time.Duration)   {}
+func (z *Zabbix) FailoverLatency(string, uint8, time.Duration)        {}
+func (z *Zabbix) VIPChanged(string, uint8, vrrp.VIPOp, net.IP, error) {}
+
+// Forget stops sending the items of a router that was removed
+func (z *Zabbix) Forget(iface string, vrid uint8) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	delete(z.routers, routerKey{iface, vrid})
+}
+
+// Run sends every item at each transition and every interval until ctx is
+// canceled
+func (z *Zabbix) Run(ctx context.Context) {
+	ticker := time.NewTicker(z.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-z.wake:
+		case <-ticker.C:
+		}
+		err := z.Flush(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		z.mu.Lock()
+		switch {
+		case err != nil && !z.failing:
+			z.logger.Warn("Failed to send items to Zabbix", "server", z.cfg.Server, "err", err)
+		case err == nil && z.failing:
+			z.logger.Info("Sending items to Zabbix again", "server", z.cfg.Server)
+		}
+		z.failing = err != nil
+		z.mu.Unlock()
+	}
+}
+
+// zabbixItem is one value in a sender data request
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+	NS    int    `json:"ns"`
+}
+
+// items renders the items of every router, in a stable order
+func (z *Zabbix) items(now time.Time) []zabbixItem {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	keys := sortedKeys(z.routers)
+
+	var items []zabbixItem
+	add := func(key string, value uint64) {
+		items = append(items, zabbixItem{
+			Host:  z.cfg.Host,
+			Key:   key,
+			Value: strconv.FormatUint(value, 10),
+			Clock: now.Unix(),
+			NS:    now.Nanosecond(),
+		})
+	}
+	for _, key := range keys {
+		r := z.routers[key]
+		params := zabbixParam(key.iface) + "," + strconv.Itoa(int(key.vrid))
+		add(z.cfg.Prefix+".state["+params+"]", uint64(r.state))
+		add(z.cfg.Prefix+".priority["+params+"]", uint64(r.priority))
+		for _, s := range []vrrp.State{vrrp.Init, vrrp.Backup, vrrp.Master} {
+			add(z.cfg.Prefix+".transitions["+params+","+strings.ToLower(s.String())+"]", r.transitions[s])
+		}
+		add(z.cfg.Prefix+".adverts_sent["+params+"]", r.advertsSent)
+		add(z.cfg.Prefix+".adverts_received["+params+"]", r.advertsReceived)
+		add(z.cfg.Prefix+".packets_dropped["+params+"]", r.packetsDropped)
+		add(z.cfg.Prefix+".split_brain["+params+"]", r.splitBrains)
+	}
+	return items
+}
+
+// zabbixParam quotes an item key parameter that holds characters that
+// would end it
+func zabbixParam(s string) string {
+	if strings.ContainsAny(s, `,]"[ `) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Flush sends every item now, e.g. the last transitions of a daemon that
+// stops. Items that Zabbix does not know, because no trapper item with that
+// key exists for the host, make it fail.
+func (z *Zabbix) Flush(ctx context.Context) error {
+	now := time.Now()
+	items := z.items(now)
+	if len(items) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(struct {
+		Request string       `json:"request"`
+		Data    []zabbixItem `json:"data"`
+		Clock   int64        `json:"clock"`
+		NS      int          `json:"ns"`
+	}{"sender data", items, now.Unix(), now.Nanosecond()})
+	if err != nil {
+		return err
+	}
+
+	conn, err := z.dialer.DialContext(ctx, "tcp", z.cfg.Server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(zabbixTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(zabbixPacket(data)); err != nil {
+		return err
+	}
+	resp, err := readZabbixPacket(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read the response: %w", err)
+	}
+
+	var out struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if out.Response != "success" {
+		return fmt.Errorf("server answered %q: %s", out.Response, out.Info)
+	}
+	var processed, failed, total int
+	if _, err := fmt.Sscanf(out.Info, "processed: %d; failed: %d; total: %d", &processed, &failed, &total); err == nil &&
+		failed > 0 {
+		return fmt.Errorf("%d of %d items rejected; create trapper items for host %q with the keys in the "+
+			"documentation", failed, total, z.cfg.Host)
+	}
+	return nil
+}
+
+// zabbixPacket frames data with the header of the Zabbix protocol: "ZBXD",
+// the flags, then the length of the data and 4 reserved bytes, little endian
+func zabbixPacket(data []byte) []byte {
+	packet := make([]byte, 13, 13+len(data))
+	copy(packet, "ZBXD\x01")
+	binary.LittleEndian.PutUint32(packet[5:], uint32(len(data)))
+	return append(packet, data...)
+}
+
+// readZabbixPacket reads a framed, uncompressed message
+func readZabbixPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "ZBXD" {
+		return nil, errors.New("not a Zabbix response")
+	}
+	if header[4] != 0x01 {
+		return nil, fmt.Errorf("unsupported flags %#x: only plain responses are", header[4])
+	}
+	n := binary.LittleEndian.Uint32(header[5:])
+	if n > maxZabbixResponse {
+		return nil, fmt.Errorf("response of %d bytes is too long", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
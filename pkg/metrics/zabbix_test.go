@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var _ vrrp.Metrics = (*Zabbix)(nil)
+
+// fakeTrapper is a Zabbix server that accepts the items with known keys
+type fakeTrapper struct {
+	ln net.Listener
+	// known are the keys of the trapper items configured; all if nil
+	known map[string]bool
+
+	mu       sync.Mutex
+	requests []map[string]string
+	received chan struct{}
+}
+
+func newFakeTrapper(t *testing.T) *fakeTrapper {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeTrapper{ln: ln, received: make(chan struct{}, 16)}
+	t.Cleanup(func() { _ = ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeTrapper) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.handle(conn)
+	}
+}
+
+func (f *fakeTrapper) handle(conn net.Conn) {
+	defer conn.Close()
+	data, err := readZabbixPacket(conn)
+	if err != nil {
+		return
+	}
+	var req struct {
+		Request string       `json:"request"`
+		Data    []zabbixItem `json:"data"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.Request != "sender data" {
+		return
+	}
+
+	items := make(map[string]string)
+	failed := 0
+	for _, item := range req.Data {
+		if item.Host != "lb1" || (f.known != nil && !f.known[item.Key]) {
+			failed++
+			continue
+		}
+		items[item.Key] = item.Value
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, items)
+	f.mu.Unlock()
+
+	resp, _ := json.Marshal(map[string]string{
+		"response": "success",
+		"info": fmt.Sprintf("processed: %d; failed: %d; total: %d; seconds spent: 0.000040",
+			len(req.Data)-failed, failed, len(req.Data)),
+	})
+	_, _ = conn.Write(zabbixPacket(resp))
+	f.received <- struct{}{}
+}
+
+func (f *fakeTrapper) last() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		return nil
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+func newTestZabbix(t *testing.T, server string) *Zabbix {
+	t.Helper()
+	z, err := NewZabbix(&ZabbixConfig{
+		Server: server,
+		Host:   "lb1",
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewZabbix: %v", err)
+	}
+	return z
+}
+
+func TestZabbixFlush(t *testing.T) {
+	f := newFakeTrapper(t)
+	z := newTestZabbix(t, f.ln.Addr().String())
+
+	// Nothing to send before the first event
+	if err := z.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	z.PriorityChanged("eth0.100", 10, 150)
+	z.StateChanged("eth0.100", 10, vrrp.Init, vrrp.Backup)
+	z.StateChanged("eth0.100", 10, vrrp.Backup, vrrp.Master)
+	z.AdvertSent("eth0.100", 10, 150)
+	z.AdvertSent("eth0.100", 10, 150)
+	z.PacketDropped("eth0.100", 10, vrrp.DropTTL)
+	z.PacketDropped("eth0.100", 10, vrrp.DropOwn)
+	z.StateChanged("bond 0", 1, vrrp.Init, vrrp.Backup)
+	if err := z.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	got := f.last()
+	for key, want := range map[string]string{
+		"vrrp.state[eth0.100,10]":              "2",
+		"vrrp.priority[eth0.100,10]":           "150",
+		"vrrp.transitions[eth0.100,10,backup]": "1",
+		"vrrp.transitions[eth0.100,10,master]": "1",
+		"vrrp.transitions[eth0.100,10,init]":   "0",
+		"vrrp.adverts_sent[eth0.100,10]":       "2",
+		"vrrp.adverts_received[eth0.100,10]":   "0",
+		"vrrp.packets_dropped[eth0.100,10]":    "1",
+		"vrrp.split_brain[eth0.100,10]":        "0",
+		`vrrp.state["bond 0",1]`:               "1",
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %q, want %q", key, got[key], want)
+		}
+	}
+
+	// The counters are totals
+	z.AdvertSent("eth0.100", 10, 150)
+	if err := z.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := f.last()["vrrp.adverts_sent[eth0.100,10]"]; got != "3" {
+		t.Errorf("adverts_sent after another = %q, want 3", got)
+	}
+}
+
+func TestZabbixUnknownItems(t *testing.T) {
+	f := newFakeTrapper(t)
+	f.known = map[string]bool{"vrrp.state[eth0,10]": true}
+	z := newTestZabbix(t, f.ln.Addr().String())
+
+	z.StateChanged("eth0", 10, vrrp.Init, vrrp.Backup)
+	err := z.Flush(context.Background())
+	if err == nil {
+		t.Fatal("Flush succeeded with items the server does not know")
+	}
+	if got := f.last()["vrrp.state[eth0,10]"]; got != "1" {
+		t.Errorf("known item = %q, want it processed", got)
+	}
+}
+
+func TestZabbixRunSendsTransitions(t *testing.T) {
+	f := newFakeTrapper(t)
+	z := newTestZabbix(t, f.ln.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go z.Run(ctx)
+
+	// Long before the interval
+	z.StateChanged("eth0", 10, vrrp.Init, vrrp.Master)
+	select {
+	case <-f.received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transition not sent")
+	}
+	if got := f.last()["vrrp.state[eth0,10]"]; got != "2" {
+		t.Errorf("state = %q, want 2", got)
+	}
+}
+
+func TestNewZabbix(t *testing.T) {
+	z, err := NewZabbix(&ZabbixConfig{Server: "zabbix.example.com", Host: "lb1"})
+	if err != nil {
+		t.Fatalf("NewZabbix: %v", err)
+	}
+	if z.cfg.Server != "zabbix.example.com:10051" {
+		t.Errorf("server = %q, want the default port added", z.cfg.Server)
+	}
+	if z, err := NewZabbix(&ZabbixConfig{Server: "[2001:db8::1]", Host: "lb1"}); err != nil ||
+		z.cfg.Server != "[2001:db8::1]:10051" {
+		t.Errorf("IPv6 server without port = %v, %v", z, err)
+	}
+
+	for name, cfg := range map[string]*ZabbixConfig{
+		"no server":           {Host: "lb1"},
+		"an empty port":       {Server: "zabbix.example.com:", Host: "lb1"},
+		"a negative interval": {Server: "zabbix.example.com", Host: "lb1", Interval: -time.Second},
+	} {
+		if _, err := NewZabbix(cfg); err == nil {
+			t.Errorf("NewZabbix with %s succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Package nomad publishes which host is MASTER of each virtual router as a
+// Nomad variable, so proxies scheduled by Nomad can find the active node with
+// a template such as {{ with nomadVar "vrrp/eth0/10" }}{{ .node }}{{ end }}.
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of Config
+const (
+	DefaultAddress       = "http://127.0.0.1:4646"
+	DefaultPrefix        = "vrrp"
+	DefaultRetryInterval = 5 * time.Second
+)
+
+// requestTimeout bounds one call to the Nomad API
+const requestTimeout = 10 * time.Second
+
+// Config describes where the variables are written
+type Config struct {
+	// Address is the Nomad HTTP API, DefaultAddress if empty
+	Address string
+	// Token is sent as X-Nomad-Token; its policy must allow writing the
+	// variables under Prefix
+	Token string
+	// Namespace holds the variables, the token's default if empty
+	Namespace string
+	// Prefix is the path of the variables, PREFIX/IFACE/VRID, DefaultPrefix
+	// if empty
+	Prefix string
+	// Node names this host in the variables, the hostname if empty
+	Node string
+	// CACert is a PEM file of the CAs the API's certificate must chain to,
+	// for an https Address; the system's are used if empty
+	CACert string
+	// RetryInterval is how long a failed write waits to be tried again,
+	// DefaultRetryInterval if zero
+	RetryInterval time.Duration
+
+	// Logger receives the publisher's log records. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// router identifies a virtual router
+type router struct {
+	iface string
+	vrid  uint8
+}
+
+// mastership is what the variable of a router should say
+type mastership struct {
+	master bool
+	since  time.Time
+}
+
+// Publisher keeps a variable per virtual router naming this host while it
+// is MASTER. Becoming MASTER writes the variable, whoever it named before;
+// leaving MASTER deletes it unless another host has written it since.
+// Calls never wait on Nomad: Run writes in the background and retries
+// until Nomad accepts.
+type Publisher struct {
+	cfg    Config
+	base   *url.URL
+	client *http.Client
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending map[router]mastership
+	wake    chan struct{}
+
+	// syncMu keeps Run and Flush from writing at the same time
+	syncMu sync.Mutex
+}
+
+// NewPublisher validates the configuration and loads CACert
+func NewPublisher(cfg *Config) (*Publisher, error) {
+	p := &Publisher{
+		cfg:     *cfg,
+		logger:  cfg.Logger,
+		pending: make(map[router]mastership),
+		wake:    make(chan struct{}, 1),
+	}
+	if p.cfg.Address == "" {
+		p.cfg.Address = DefaultAddress
+	}
+	if p.cfg.Prefix == "" {
+		p.cfg.Prefix = DefaultPrefix
+	}
+	if p.cfg.RetryInterval == 0 {
+		p.cfg.RetryInterval = DefaultRetryInterval
+	}
+	if p.logger == nil {
+		p.logger = slog.Default()
+	}
+	if p.cfg.Node == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the hostname: %w", err)
+		}
+		p.cfg.Node = host
+	}
+
+	base, err := url.Parse(p.cfg.Address)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid Nomad address %q: want http(s)://host:port", p.cfg.Address)
+	}
+	p.base = base
+	if strings.Trim(p.cfg.Prefix, "/") == "" || escapePath(p.cfg.Prefix) != p.cfg.Prefix {
+		return nil, fmt.Errorf("invalid variable prefix %q: want letters, digits, -, _, ~ and /", p.cfg.Prefix)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if p.cfg.CACert != "" {
+		pem, err := os.ReadFile(p.cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Nomad CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in Nomad CA %s", p.cfg.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+	p.client = &http.Client{Transport: transport, Timeout: requestTimeout}
+	return p, nil
+}
+
+// SetState records whether this host is MASTER of a virtual router. The
+// variable is written by Run; a change that has not been written yet is
+// replaced.
+func (p *Publisher) SetState(iface string, vrid uint8, master bool) {
+	p.mu.Lock()
+	p.pending[router{iface, vrid}] = mastership{master: master, since: time.Now()}
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run writes the changes recorded by SetState until ctx is canceled
+func (p *Publisher) Run(ctx context.Context) {
+	retry := time.NewTimer(p.cfg.RetryInterval)
+	retry.Stop()
+	defer retry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		case <-retry.C:
+		}
+		if err := p.Flush(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Warn("Failed to publish to Nomad, retrying", "err", err, "retry", p.cfg.RetryInterval)
+			retry.Reset(p.cfg.RetryInterval)
+		}
+	}
+}
+
+// Flush writes the changes recorded by SetState now, e.g. the releases of
+// a daemon that stops. A change that fails stays pending.
+func (p *Publisher) Flush(ctx context.Context) error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.mu.Lock()
+	batch := p.pending
+	p.pending = make(map[router]mastership)
+	p.mu.Unlock()
+
+	var errs []error
+	for r, m := range batch {
+		if err := p.publish(ctx, r, m); err != nil {
+			errs = append(errs, err)
+			p.mu.Lock()
+			// Unless a newer state came in meanwhile
+			if _, ok := p.pending[r]; !ok {
+				p.pending[r] = m
+			}
+			p.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// variable is a Nomad variable as the API reads and writes it
+type variable struct {
+	Namespace   string            `json:",omitempty"`
+	Path        string            `json:",omitempty"`
+	ModifyIndex uint64            `json:",omitempty"`
+	Items       map[string]string `json:",omitempty"`
+}
+
+func (p *Publisher) publish(ctx context.Context, r router, m mastership) error {
+	path := p.path(r)
+	if m.master {
+		v := variable{Namespace: p.cfg.Namespace, Path: path, Items: map[string]string{
+			"node":  p.cfg.Node,
+			"since": m.since.UTC().Format(time.RFC3339),
+		}}
+		if _, err := p.do(ctx, http.MethodPut, path, nil, &v, nil); err != nil {
+			return err
+		}
+		p.logger.Info("Published MASTER to Nomad", "path", path, "node", p.cfg.Node)
+		return nil
+	}
+
+	var v variable
+	found, err := p.do(ctx, http.MethodGet, path, nil, nil, &v)
+	if err != nil || !found || v.Items["node"] != p.cfg.Node {
+		return err
+	}
+	// Only delete the version read, so as not to remove what another host
+	// became MASTER and wrote meanwhile
+	cas := url.Values{"cas": {strconv.FormatUint(v.ModifyIndex, 10)}}
+	deleted, err := p.do(ctx, http.MethodDelete, path, cas, nil, nil)
+	if deleted {
+		p.logger.Info("Withdrew MASTER from Nomad", "path", path, "node", p.cfg.Node)
+	}
+	return err
+}
+
+// path is the variable path of r. Interface names may hold characters Nomad
+// does not allow in paths, such as the dot of a VLAN.
+func (p *Publisher) path(r router) string {
+	return p.cfg.Prefix + "/" + escapePath(r.iface) + "/" + strconv.Itoa(int(r.vrid))
+}
+
+// escapePath replaces the characters a variable path cannot hold with "_"
+func escapePath(s string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("-_~/", c):
+			return c
+		}
+		return '_'
+	}, s)
+}
+
+// do calls the variables API for path, sending in if not nil and decoding
+// the response into out if not nil. It reports false for a
+// variable that does not exist, or a check-and-set that lost to another
+// write, neither of which is an error.
+func (p *Publisher) do(ctx context.Context, method, path string, query url.Values, in, out *variable) (bool, error) {
+	u := *p.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/var/" + path
+	if query == nil {
+		query = url.Values{}
+	}
+	if p.cfg.Namespace != "" {
+		query.Set("namespace", p.cfg.Namespace)
+	}
+	u.RawQuery = query.Encode()
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return false, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return false, err
+	}
+	if p.cfg.Token != "" {
+		req.Header.Set("X-Nomad-Token", p.cfg.Token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict:
+		return false, nil
+	case resp.StatusCode/100 != 2:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("%s %s: %w", method, path, err)
+		}
+	}
+	return true, nil
+}
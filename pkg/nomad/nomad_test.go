@@ -0,0 +1,217 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNomad serves the variables API from memory
+type fakeNomad struct {
+	mu    sync.Mutex
+	vars  map[string]variable
+	index uint64
+	// fail makes the next requests fail with 500
+	fail int
+	// tokens and namespaces seen
+	tokens, namespaces []string
+}
+
+func newFakeNomad(t *testing.T) (*fakeNomad, *httptest.Server) {
+	t.Helper()
+	f := &fakeNomad{vars: make(map[string]variable)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeNomad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tokens = append(f.tokens, r.Header.Get("X-Nomad-Token"))
+	f.namespaces = append(f.namespaces, r.URL.Query().Get("namespace"))
+	if f.fail > 0 {
+		f.fail--
+		http.Error(w, "rpc error: no leader", http.StatusInternalServerError)
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/v1/var/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	v, exists := f.vars[path]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(v)
+	case http.MethodPut:
+		var in variable
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Path != path {
+			http.Error(w, "bad variable", http.StatusBadRequest)
+			return
+		}
+		f.index++
+		in.ModifyIndex = f.index
+		f.vars[path] = in
+		_ = json.NewEncoder(w).Encode(in)
+	case http.MethodDelete:
+		if cas := r.URL.Query().Get("cas"); cas != "" && (!exists || cas != strconv.FormatUint(v.ModifyIndex, 10)) {
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(v)
+			return
+		}
+		delete(f.vars, path)
+	}
+}
+
+func (f *fakeNomad) get(path string) (variable, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.vars[path]
+	return v, ok
+}
+
+func (f *fakeNomad) put(path string, v variable) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	v.ModifyIndex = f.index
+	f.vars[path] = v
+}
+
+func newTestPublisher(t *testing.T, address, node string) *Publisher {
+	t.Helper()
+	p, err := NewPublisher(&Config{
+		Address:   address,
+		Token:     "secret",
+		Namespace: "edge",
+		Node:      node,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewPublisher: %v", err)
+	}
+	return p
+}
+
+func TestPublisher(t *testing.T) {
+	f, srv := newFakeNomad(t)
+	p := newTestPublisher(t, srv.URL, "lb1")
+	ctx := context.Background()
+
+	// Becoming BACKUP with no variable does nothing
+	p.SetState("eth0.100", 10, false)
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if _, ok := f.get("vrrp/eth0_100/10"); ok {
+		t.Error("variable written by a BACKUP")
+	}
+
+	p.SetState("eth0.100", 10, true)
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	v, ok := f.get("vrrp/eth0_100/10")
+	if !ok || v.Items["node"] != "lb1" || v.Items["since"] == "" || v.Namespace != "edge" {
+		t.Fatalf("variable after MASTER = %+v, %v", v, ok)
+	}
+	if f.tokens[0] != "secret" || f.namespaces[0] != "edge" {
+		t.Errorf("token %q, namespace %q; want secret and edge", f.tokens[0], f.namespaces[0])
+	}
+
+	// Leaving MASTER withdraws it
+	p.SetState("eth0.100", 10, false)
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if v, ok := f.get("vrrp/eth0_100/10"); ok {
+		t.Errorf("variable after BACKUP = %+v, want deleted", v)
+	}
+
+	// But not once another host wrote it
+	other := variable{Path: "vrrp/eth0_100/10", Items: map[string]string{"node": "lb2"}}
+	p.SetState("eth0.100", 10, true)
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	f.put("vrrp/eth0_100/10", other)
+	p.SetState("eth0.100", 10, false)
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if v, ok := f.get("vrrp/eth0_100/10"); !ok || v.Items["node"] != "lb2" {
+		t.Errorf("variable of another MASTER = %+v, %v; want it kept", v, ok)
+	}
+}
+
+func TestPublisherRetries(t *testing.T) {
+	f, srv := newFakeNomad(t)
+	p := newTestPublisher(t, srv.URL, "lb1")
+	f.fail = 1
+
+	p.SetState("eth0", 10, true)
+	if err := p.Flush(context.Background()); err == nil || !strings.Contains(err.Error(), "no leader") {
+		t.Fatalf("Flush = %v, want the server's error", err)
+	}
+	if _, ok := f.get("vrrp/eth0/10"); ok {
+		t.Fatal("variable written although the server failed")
+	}
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatalf("second Flush: %v", err)
+	}
+	if v, ok := f.get("vrrp/eth0/10"); !ok || v.Items["node"] != "lb1" {
+		t.Errorf("variable after the retry = %+v, %v", v, ok)
+	}
+}
+
+func TestPublisherRun(t *testing.T) {
+	f, srv := newFakeNomad(t)
+	p := newTestPublisher(t, srv.URL, "lb1")
+	p.cfg.RetryInterval = 10 * time.Millisecond
+	f.fail = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	p.SetState("eth0", 10, true)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := f.get("vrrp/eth0/10"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("variable not written by Run")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNewPublisherRejects(t *testing.T) {
+	for name, cfg := range map[string]*Config{
+		"an address without scheme": {Address: "127.0.0.1:4646", Node: "lb1"},
+		"a unix address":            {Address: "unix:///run/nomad.sock", Node: "lb1"},
+		"a prefix with dots":        {Prefix: "vrrp.lb", Node: "lb1"},
+		"an empty prefix":           {Prefix: "/", Node: "lb1"},
+		"a missing CA":              {CACert: "/nonexistent/ca.pem", Node: "lb1"},
+	} {
+		if _, err := NewPublisher(cfg); err == nil {
+			t.Errorf("NewPublisher with %s succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package pcap reads and writes VRRP traffic in the classic libpcap file
+// format understood by tcpdump and Wireshark. Files are written with raw IPv4
+// framing; Ethernet, Linux cooked (SLL and SLL2) and raw IP captures can be
+// read, and frames that do not carry IPv4 are skipped.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Link types of the capture files this package handles
+const (
+	LinkTypeEthernet = 1
+	LinkTypeRaw      = 101
+	LinkTypeLinuxSLL = 113
+	LinkTypeIPv4     = 228
+	LinkTypeSLL2     = 276
+)
+
+const (
+	magicMicros = 0xa1b2c3d4
+	magicNanos  = 0xa1b23c4d
+
+	fileHeaderLen   = 24
+	recordHeaderLen = 16
+
+	// snapLen is the largest frame written or read
+	snapLen = 65535
+
+	etherTypeIPv4 = 0x0800
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+)
+
+// Packet is one IPv4 packet read from a capture
+type Packet struct {
+	Time time.Time
+	// Data is the IPv4 packet with the link-layer framing removed
+	Data []byte
+}
+
+// IPv4 parses the packet's IP header and returns it with the payload it
+// frames
+func (p *Packet) IPv4() (*ipv4.Header, []byte, error) {
+	h, err := ipv4.ParseHeader(p.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.Len < ipv4.HeaderLen || h.TotalLen < h.Len || h.TotalLen > len(p.Data) {
+		return nil, nil, fmt.Errorf("truncated IPv4 packet: total length %d, %d bytes captured", h.TotalLen, len(p.Data))
+	}
+	return h, p.Data[h.Len:h.TotalLen], nil
+}
+
+// Reader reads the packets of a capture file
+type Reader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+	hdr      [recordHeaderLen]byte
+}
+
+// NewReader reads the file header from r
+func NewReader(r io.Reader) (*Reader, error) {
+	var hdr [fileHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+
+	rd := &Reader{r: r}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[0:4]) {
+		case magicMicros:
+			rd.order = order
+		case magicNanos:
+			rd.order, rd.nanos = order, true
+		}
+	}
+	if rd.order == nil {
+		return nil, errors.New("not a pcap file (pcapng is not supported)")
+	}
+
+	rd.linkType = rd.order.Uint32(hdr[20:24]) & 0x0FFFFFFF
+	switch rd.linkType {
+	case LinkTypeEthernet, LinkTypeRaw, LinkTypeLinuxSLL, LinkTypeIPv4, LinkTypeSLL2:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", rd.linkType)
+	}
+	return rd, nil
+}
+
+// Next returns the next IPv4 packet, skipping frames of other protocols. It
+// returns io.EOF at the end of the file.
+func (rd *Reader) Next() (*Packet, error) {
+	for {
+		if _, err := io.ReadFull(rd.r, rd.hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated pcap record header")
+			}
+			return nil, err
+		}
+
+		secs := int64(rd.order.Uint32(rd.hdr[0:4]))
+		frac := int64(rd.order.Uint32(rd.hdr[4:8]))
+		capLen := rd.order.Uint32(rd.hdr[8:12])
+		if capLen > snapLen {
+			return nil, fmt.Errorf("pcap record of %d bytes exceeds the snapshot length", capLen)
+		}
+
+		frame := make([]byte, capLen)
+		if _, err := io.ReadFull(rd.r, frame); err != nil {
+			return nil, fmt.Errorf("truncated pcap record: %w", err)
+		}
+
+		if !rd.nanos {
+			frac *= int64(time.Microsecond)
+		}
+		if data := rd.ipv4(frame); data != nil {
+			return &Packet{Time: time.Unix(secs, frac), Data: data}, nil
+		}
+	}
+}
+
+// ipv4 strips the link-layer framing, returning nil if frame does not carry
+// IPv4
+func (rd *Reader) ipv4(frame []byte) []byte {
+	var etherType uint16
+	switch rd.linkType {
+	case LinkTypeRaw, LinkTypeIPv4:
+		if len(frame) == 0 || frame[0]>>4 != ipv4.Version {
+			return nil
+		}
+		return frame
+	case LinkTypeEthernet:
+		if len(frame) < 14 {
+			return nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[12:14]), frame[14:]
+		for (etherType == etherTypeVLAN || etherType == etherTypeQinQ) && len(frame) >= 4 {
+			etherType, frame = binary.BigEndian.Uint16(frame[2:4]), frame[4:]
+		}
+	case LinkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[14:16]), frame[16:]
+	case LinkTypeSLL2:
+		if len(frame) < 20 {
+			return nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[0:2]), frame[20:]
+	}
+	if etherType != etherTypeIPv4 {
+		return nil
+	}
+	return frame
+}
+
+// Writer writes packets to a capture file with raw IPv4 framing. It is safe
+// for concurrent use.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter writes the file header to w
+func NewWriter(w io.Writer) (*Writer, error) {
+	var hdr [fileHeaderLen]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], magicNanos)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2) // version 2.4
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], LinkTypeRaw)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, fmt.Errorf("failed to write pcap header: %w", err)
+	}
+	return &Writer{w: w}, nil
+}
+
+// WritePacket writes an IPv4 packet captured at t
+func (wr *Writer) WritePacket(t time.Time, data []byte) error {
+	if len(data) > snapLen {
+		return fmt.Errorf("packet of %d bytes exceeds the snapshot length", len(data))
+	}
+
+	rec := make([]byte, recordHeaderLen, recordHeaderLen+len(data))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(t.Nanosecond()))
+	binary.LittleEndian.PutUint32(rec[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(rec[12:16], uint32(len(data)))
+	rec = append(rec, data...)
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	_, err := wr.w.Write(rec)
+	return err
+}
+
+// WriteIPv4 writes payload framed by header. The header's lengths and
+// checksum are recomputed, since the kernel fills them in on send and
+// rewrites them on receive.
+func (wr *Writer) WriteIPv4(t time.Time, header *ipv4.Header, payload []byte) error {
+	h := *header
+	h.Version = ipv4.Version
+	h.Len = ipv4.HeaderLen + len(h.Options)
+	h.TotalLen = h.Len + len(payload)
+	h.Checksum = 0
+	b, err := h.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode IPv4 header: %w", err)
+	}
+	// Marshal writes the length and fragment offset in host order on some
+	// platforms; a capture file wants network order
+	binary.BigEndian.PutUint16(b[2:4], uint16(h.TotalLen))
+	binary.BigEndian.PutUint16(b[6:8], uint16(h.Flags)<<13|uint16(h.FragOff))
+	binary.BigEndian.PutUint16(b[10:12], checksum(b))
+	return wr.WritePacket(t, append(b, payload...))
+}
+
+// checksum is the Internet checksum of an IP header
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,149 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+
+	at := time.Date(2025, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	header := &ipv4.Header{
+		TOS:      0xc0,
+		TTL:      255,
+		Protocol: 112,
+		Src:      net.ParseIP("10.0.0.1").To4(),
+		Dst:      net.ParseIP("224.0.0.18").To4(),
+	}
+	payload := []byte{0x21, 10, 100, 1, 0, 1, 0xaa, 0xbb, 192, 0, 2, 1}
+	if err := w.WriteIPv4(at, header, payload); err != nil {
+		t.Fatalf("WriteIPv4: %v", err)
+	}
+	if err := w.WritePacket(at.Add(time.Second), []byte{0x60, 0, 0, 0}); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	pkt, err := r.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if !pkt.Time.Equal(at) {
+		t.Errorf("Time = %v, want %v", pkt.Time, at)
+	}
+	h, got, err := pkt.IPv4()
+	if err != nil {
+		t.Fatalf("IPv4: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("payload = %x, want %x", got, payload)
+	}
+	if h.TTL != 255 || h.Protocol != 112 || !h.Src.Equal(header.Src) || !h.Dst.Equal(header.Dst) ||
+		h.TotalLen != ipv4.HeaderLen+len(payload) {
+		t.Errorf("header = %+v", h)
+	}
+	if c := checksum(pkt.Data[:ipv4.HeaderLen]); c != 0 {
+		t.Errorf("header checksum does not verify: %#x", c)
+	}
+
+	// The IPv6 packet is skipped
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next = %v, want io.EOF", err)
+	}
+}
+
+// capture builds a big-endian, microsecond capture file of frames
+func capture(linkType uint32, frames ...[]byte) []byte {
+	var b bytes.Buffer
+	hdr := make([]byte, fileHeaderLen)
+	binary.BigEndian.PutUint32(hdr[0:4], magicMicros)
+	binary.BigEndian.PutUint32(hdr[16:20], snapLen)
+	binary.BigEndian.PutUint32(hdr[20:24], linkType)
+	b.Write(hdr)
+	for i, f := range frames {
+		rec := make([]byte, recordHeaderLen)
+		binary.BigEndian.PutUint32(rec[0:4], 1000)
+		binary.BigEndian.PutUint32(rec[4:8], uint32(i*500000))
+		binary.BigEndian.PutUint32(rec[8:12], uint32(len(f)))
+		binary.BigEndian.PutUint32(rec[12:16], uint32(len(f)))
+		b.Write(rec)
+		b.Write(f)
+	}
+	return b.Bytes()
+}
+
+func TestReadLinkTypes(t *testing.T) {
+	ip := []byte{0x45, 0, 0, 20, 0, 0, 0, 0, 255, 112, 0, 0, 10, 0, 0, 1, 224, 0, 0, 18}
+	ether := append(make([]byte, 12), 0x08, 0x00)
+	vlan := append(make([]byte, 12), 0x81, 0x00, 0, 10, 0x08, 0x00)
+	arp := append(make([]byte, 12), 0x08, 0x06)
+	sll := append(make([]byte, 14), 0x08, 0x00)
+	sll2 := append([]byte{0x08, 0x00}, make([]byte, 18)...)
+
+	tests := []struct {
+		name     string
+		linkType uint32
+		frames   [][]byte
+	}{
+		{"ethernet", LinkTypeEthernet, [][]byte{append(arp, 1, 2, 3), append(ether, ip...)}},
+		{"vlan", LinkTypeEthernet, [][]byte{append(vlan, ip...)}},
+		{"raw", LinkTypeRaw, [][]byte{ip}},
+		{"sll", LinkTypeLinuxSLL, [][]byte{append(sll, ip...)}},
+		{"sll2", LinkTypeSLL2, [][]byte{append(sll2, ip...)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(capture(tt.linkType, tt.frames...)))
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			pkt, err := r.Next()
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			if !bytes.Equal(pkt.Data, ip) {
+				t.Errorf("Data = %x, want %x", pkt.Data, ip)
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("Next = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("\x0a\x0d\x0d\x0a pcapng"))); err == nil {
+		t.Error("NewReader accepted a pcapng file")
+	}
+	if _, err := NewReader(bytes.NewReader(capture(105))); err == nil {
+		t.Error("NewReader accepted an 802.11 capture")
+	}
+
+	data := capture(LinkTypeRaw, []byte{0x45, 0, 0, 20})
+	r, err := NewReader(bytes.NewReader(data[:len(data)-2]))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next = %v, want a truncation error", err)
+	}
+
+	pkt := &Packet{Data: []byte{0x45, 0, 0, 40, 0, 0, 0, 0, 255, 112, 0, 0, 10, 0, 0, 1, 224, 0, 0, 18}}
+	if _, _, err := pkt.IPv4(); err == nil {
+		t.Error("IPv4 accepted a packet shorter than its total length")
+	}
+}
@@ -0,0 +1,304 @@
+// Package replay plays VRRP traffic from a pcap file through the
+// advertisement parser and validation, and optionally through a local state
+// machine, to see offline how a router would have handled it. Packets are
+// delivered at their recorded timing, or a multiple of it.
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/pcap"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Options tune a replay
+type Options struct {
+	// Speed plays the capture this many times faster than it was recorded
+	// (default 1). The local router's timers are scaled alike.
+	Speed float64
+
+	// Router, if set, runs a local router that receives the captured
+	// advertisements for its VRID
+	Router *Router
+
+	// Logger receives the local state machine's logs (default: discarded)
+	Logger *slog.Logger
+}
+
+// Router configures the local router of a replay
+type Router struct {
+	VRID     uint8
+	Priority uint8
+	// VirtualIPs are those the local router would hold; one at least is
+	// required
+	VirtualIPs []string
+	// AdvInterval is in seconds (default 1)
+	AdvInterval int
+	NoPreempt   bool
+	// SourceIP is the local router's address. Captured advertisements from
+	// it are its own and are not delivered.
+	SourceIP string
+}
+
+// Event is a captured message or a state change of the local router
+type Event struct {
+	// Time is the capture time; for a transition, the capture time it
+	// corresponds to
+	Time time.Time
+	// At is Time relative to the first packet
+	At time.Duration
+
+	// Header and Payload are the captured message
+	Header  *ipv4.Header
+	Payload []byte
+	// Packet is the decoded message, nil if it could not be decoded
+	Packet *vrrp.Packet
+	// Drop is why a router would discard the message, empty if it would
+	// accept it. Err is set for DropDecode.
+	Drop vrrp.DropReason
+	Err  error
+
+	// Transition is set instead for a state change of the local router
+	Transition *vrrp.Transition
+}
+
+type replay struct {
+	speed  float64
+	fn     func(Event)
+	router *Router
+	ownIP  net.IP
+
+	mu        sync.Mutex
+	start     time.Time
+	first     time.Time
+	recording bool
+}
+
+// Run reads the capture from r and calls fn for every VRRP message in it and
+// every transition of the local router, in order. After the last packet, a
+// local router keeps running for a master down interval, so a takeover from
+// a master that fell silent shows. Run returns when the capture has been
+// played or ctx is canceled.
+func Run(ctx context.Context, r io.Reader, opts Options, fn func(Event)) error {
+	rd, err := pcap.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	rp := &replay{speed: speed, fn: fn, router: opts.Router}
+	var sm *vrrp.StateMachine
+	var interval time.Duration
+	if rt := opts.Router; rt != nil {
+		if sm, interval, err = rp.newStateMachine(rt, logger); err != nil {
+			return err
+		}
+	}
+
+	for {
+		pkt, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rp.stop(sm)
+			return err
+		}
+		header, payload, err := pkt.IPv4()
+		if err != nil || header.Protocol != vrrp.VRRPProtocol {
+			continue
+		}
+
+		if rp.start.IsZero() {
+			rp.begin(pkt.Time, sm)
+			if sm != nil {
+				if err := sm.Start(ctx); err != nil {
+					return err
+				}
+			}
+		}
+		if err := rp.wait(ctx, pkt.Time.Sub(rp.first)); err != nil {
+			rp.stop(sm)
+			return err
+		}
+		rp.deliver(pkt.Time, header, payload, sm)
+	}
+
+	if sm != nil && !rp.start.IsZero() {
+		// The master down interval is under 4 advertisement intervals
+		tail := rp.now() + 4*interval
+		if err := rp.wait(ctx, tail); err != nil {
+			rp.stop(sm)
+			return err
+		}
+	}
+	rp.stop(sm)
+	return nil
+}
+
+func (rp *replay) newStateMachine(rt *Router, logger *slog.Logger) (*vrrp.StateMachine, time.Duration, error) {
+	if rt.VRID == 0 {
+		return nil, 0, fmt.Errorf("the local router needs a VRID")
+	}
+	var vips []net.IP
+	for _, s := range rt.VirtualIPs {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return nil, 0, fmt.Errorf("invalid virtual IP %q", s)
+		}
+		vips = append(vips, ip)
+	}
+	if len(vips) == 0 {
+		return nil, 0, fmt.Errorf("the local router needs virtual IPs")
+	}
+	if rt.SourceIP != "" {
+		if rp.ownIP = net.ParseIP(rt.SourceIP).To4(); rp.ownIP == nil {
+			return nil, 0, fmt.Errorf("invalid source IP %q", rt.SourceIP)
+		}
+	}
+	interval := time.Duration(max(rt.AdvInterval, 1)) * time.Second
+
+	sm := vrrp.NewStateMachine(rt.VRID, rt.Priority, vips, &net.Interface{Name: "replay"})
+	sm.SetLogger(logger)
+	sm.SetAddressManager(vrrp.NopAddresses{})
+	if rp.ownIP != nil {
+		sm.SetSourceIP(rp.ownIP)
+	}
+	sm.SetAdvertisementInterval(rp.scale(interval))
+	sm.SetPreempt(!rt.NoPreempt)
+	sm.SetTransitionCallback(rp.transition)
+	return sm, interval, nil
+}
+
+// begin starts the clock at the first packet and drains the local router's
+// advertisements, which go nowhere
+func (rp *replay) begin(first time.Time, sm *vrrp.StateMachine) {
+	rp.mu.Lock()
+	rp.start, rp.first, rp.recording = time.Now(), first, true
+	rp.mu.Unlock()
+	if sm == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-sm.GetSendChannel():
+			case <-sm.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the local router without reporting its shutdown
+func (rp *replay) stop(sm *vrrp.StateMachine) {
+	rp.mu.Lock()
+	rp.recording = false
+	rp.mu.Unlock()
+	if sm != nil && !rp.start.IsZero() {
+		sm.Stop()
+	}
+}
+
+// scale converts capture time to real time
+func (rp *replay) scale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / rp.speed)
+}
+
+// now is the capture time that has elapsed since the first packet
+func (rp *replay) now() time.Duration {
+	return time.Duration(float64(time.Since(rp.start)) * rp.speed)
+}
+
+// wait sleeps until capture time at
+func (rp *replay) wait(ctx context.Context, at time.Duration) error {
+	d := time.Until(rp.start.Add(rp.scale(at)))
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliver validates a message as a router would and passes it to the local
+// router if it would accept it
+func (rp *replay) deliver(at time.Time, header *ipv4.Header, payload []byte, sm *vrrp.StateMachine) {
+	e := Event{Time: at, At: at.Sub(rp.first), Header: header, Payload: payload}
+	e.Packet, e.Drop, e.Err = rp.validate(header, payload)
+
+	rp.mu.Lock()
+	rp.fn(e)
+	rp.mu.Unlock()
+
+	if sm != nil && e.Drop == "" {
+		sm.ProcessPacket(e.Packet)
+	}
+}
+
+// validate decodes a message and checks it in the order
+// VirtualRouter.handleAdvert does
+func (rp *replay) validate(header *ipv4.Header, payload []byte) (*vrrp.Packet, vrrp.DropReason, error) {
+	if len(payload) > 0 {
+		if version := payload[0] >> 4; version != vrrp.VRRPv2 && version != vrrp.VRRPv3 {
+			return nil, vrrp.DropVersion, nil
+		}
+	}
+	pkt := &vrrp.Packet{}
+	if err := pkt.Unmarshal(payload); err != nil {
+		return nil, vrrp.DropDecode, err
+	}
+	if pkt.Type != vrrp.TypeAdvertisement {
+		return pkt, vrrp.DropType, nil
+	}
+	if valid, ok := pkt.VerifyChecksum(payload); ok && !valid {
+		return pkt, vrrp.DropChecksum, nil
+	}
+	if header.TTL != 255 {
+		return pkt, vrrp.DropTTL, nil
+	}
+	if rp.router == nil {
+		return pkt, "", nil
+	}
+	switch {
+	case rp.ownIP != nil && header.Src.Equal(rp.ownIP):
+		return pkt, vrrp.DropOwn, nil
+	case pkt.VRID != rp.router.VRID:
+		return pkt, vrrp.DropVRIDMismatch, nil
+	}
+	return pkt, "", nil
+}
+
+// transition reports a state change of the local router at the capture time
+// it corresponds to
+func (rp *replay) transition(t vrrp.Transition) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if !rp.recording {
+		return
+	}
+	at := rp.now()
+	t.Time = rp.first.Add(at)
+	rp.fn(Event{Time: t.Time, At: at, Transition: &t})
+}
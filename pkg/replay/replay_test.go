@@ -0,0 +1,143 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/pcap"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+type captured struct {
+	offset   time.Duration
+	src      string
+	ttl      int
+	vrid     uint8
+	priority uint8
+}
+
+// capture writes the adverts to a pcap file, 192.0.2.1 being the virtual IP
+func capture(t *testing.T, adverts ...captured) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := pcap.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, a := range adverts {
+		data, err := vrrp.NewPacket(vrrp.VRRPv2, a.vrid, a.priority, []net.IP{net.ParseIP("192.0.2.1").To4()}).Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		header := &ipv4.Header{
+			TTL:      a.ttl,
+			Protocol: vrrp.VRRPProtocol,
+			Src:      net.ParseIP(a.src).To4(),
+			Dst:      net.ParseIP(vrrp.VRRPMulticastIPv4).To4(),
+		}
+		if err := w.WriteIPv4(start.Add(a.offset), header, data); err != nil {
+			t.Fatalf("WriteIPv4: %v", err)
+		}
+	}
+	return &buf
+}
+
+func TestRunDecodeOnly(t *testing.T) {
+	buf := capture(t,
+		captured{0, "10.0.0.2", 255, 10, 200},
+		captured{time.Second, "10.0.0.2", 64, 10, 200},
+		captured{2 * time.Second, "10.0.0.3", 255, 20, 100},
+	)
+
+	var events []Event
+	err := Run(context.Background(), buf, Options{Speed: 1000}, func(e Event) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	if e := events[0]; e.At != 0 || e.Drop != "" || e.Packet == nil || e.Packet.Priority != 200 ||
+		!e.Header.Src.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("events[0] = %+v, want the accepted advert from 10.0.0.2", e)
+	}
+	if e := events[1]; e.At != time.Second || e.Drop != vrrp.DropTTL {
+		t.Errorf("events[1] = %+v, want a TTL drop 1s in", e)
+	}
+	// Without a local router any VRID is accepted
+	if e := events[2]; e.Drop != "" || e.Packet.VRID != 20 {
+		t.Errorf("events[2] = %+v, want the accepted advert for VRID 20", e)
+	}
+}
+
+func TestRunLocalRouter(t *testing.T) {
+	// The master advertises for 3s and falls silent
+	buf := capture(t,
+		captured{0, "10.0.0.2", 255, 10, 200},
+		captured{time.Second, "10.0.0.2", 255, 10, 200},
+		captured{1500 * time.Millisecond, "10.0.0.3", 255, 20, 200},
+		captured{2 * time.Second, "10.0.0.1", 255, 10, 100},
+		captured{3 * time.Second, "10.0.0.2", 255, 10, 200},
+	)
+
+	var events []Event
+	opts := Options{
+		Speed:  10,
+		Router: &Router{VRID: 10, Priority: 100, VirtualIPs: []string{"192.0.2.1"}, SourceIP: "10.0.0.1"},
+	}
+	err := Run(context.Background(), buf, opts, func(e Event) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var drops []vrrp.DropReason
+	var transitions []vrrp.Transition
+	for _, e := range events {
+		if e.Transition != nil {
+			transitions = append(transitions, *e.Transition)
+		} else {
+			drops = append(drops, e.Drop)
+		}
+	}
+	want := []vrrp.DropReason{"", "", vrrp.DropVRIDMismatch, vrrp.DropOwn, ""}
+	if len(drops) != len(want) {
+		t.Fatalf("drops = %q, want %q", drops, want)
+	}
+	for i := range want {
+		if drops[i] != want[i] {
+			t.Errorf("drops = %q, want %q", drops, want)
+			break
+		}
+	}
+
+	if len(transitions) != 2 {
+		t.Fatalf("transitions = %+v, want INIT -> BACKUP -> MASTER", transitions)
+	}
+	takeover := transitions[1]
+	if takeover.New != vrrp.Master || takeover.Cause != vrrp.CauseMasterDown {
+		t.Errorf("takeover = %+v, want MASTER on %s", takeover, vrrp.CauseMasterDown)
+	}
+	// 3s after the last advert, plus the skew time, in capture time
+	at := takeover.Time.Sub(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	if at < 6*time.Second || at > 7500*time.Millisecond {
+		t.Errorf("took over %s into the capture, want 6.6s", at)
+	}
+}
+
+func TestRunInvalidCapture(t *testing.T) {
+	err := Run(context.Background(), bytes.NewBufferString("not a capture file"), Options{}, func(Event) {})
+	if err == nil {
+		t.Error("Run accepted a file that is not a capture")
+	}
+}
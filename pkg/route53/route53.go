@@ -0,0 +1,291 @@
+// Package route53 points a Route 53 record at this host when it becomes
+// MASTER, for failover to a site that ARP cannot reach, such as a DR site in
+// another L3 domain. It talks to the Route 53 API directly, signing requests
+// itself, rather than through the AWS SDK.
+package route53
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of Record and of the retries
+const (
+	DefaultTTL = 60
+
+	// DefaultEndpoint is the Route 53 API, served from us-east-1
+	DefaultEndpoint = "https://route53.amazonaws.com"
+
+	minRetry = time.Second
+	maxRetry = time.Minute
+)
+
+// requestTimeout bounds one call to the Route 53 API
+const requestTimeout = 30 * time.Second
+
+// Record is the record set pointed at this host, as in the configuration
+// file. The credentials are taken from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN unless set here.
+type Record struct {
+	// HostedZoneID is the zone holding the record, e.g. Z0123456789ABC
+	HostedZoneID string `json:"hosted_zone_id"`
+	// Name is the record's domain name, e.g. app.example.com
+	Name string `json:"name"`
+	// Type is A (the default) or AAAA
+	Type string `json:"type,omitempty"`
+	// Value is this host's address as the record should hold it, which for
+	// NAT or another site is not necessarily a local one
+	Value string `json:"value"`
+	// TTL in seconds, DefaultTTL if zero
+	TTL int `json:"ttl,omitempty"`
+
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+}
+
+// Validate checks that the record is complete and its value is an address
+// of its type
+func (r *Record) Validate() error {
+	if r.HostedZoneID == "" || r.Name == "" || r.Value == "" {
+		return errors.New("route53: hosted_zone_id, name and value are required")
+	}
+	addr, err := netip.ParseAddr(r.Value)
+	if err != nil {
+		return fmt.Errorf("route53: value: %w", err)
+	}
+	switch r.Type {
+	case "", "A":
+		if !addr.Is4() {
+			return fmt.Errorf("route53: value %s is not an IPv4 address for an A record", addr)
+		}
+	case "AAAA":
+		if !addr.Is6() || addr.Is4In6() {
+			return fmt.Errorf("route53: value %s is not an IPv6 address for an AAAA record", addr)
+		}
+	default:
+		return fmt.Errorf("route53: type %q must be A or AAAA", r.Type)
+	}
+	if r.TTL < 0 {
+		return errors.New("route53: ttl must not be negative")
+	}
+	if (r.AccessKeyID == "") != (r.SecretAccessKey == "") {
+		return errors.New("route53: access_key_id and secret_access_key go together")
+	}
+	return nil
+}
+
+// String describes the record as "NAME TYPE VALUE"
+func (r *Record) String() string {
+	typ := r.Type
+	if typ == "" {
+		typ = "A"
+	}
+	return r.Name + " " + typ + " " + r.Value
+}
+
+// Config configures an Updater
+type Config struct {
+	Record Record
+	// Endpoint is the Route 53 API, DefaultEndpoint if empty
+	Endpoint string
+
+	// Logger receives the updater's log records. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// Updater upserts its record each time the instance becomes MASTER, retrying
+// with backoff until Route 53 accepts the change or the instance leaves
+// MASTER. Leaving MASTER changes nothing: the next MASTER points the record
+// at itself.
+type Updater struct {
+	record   Record
+	creds    Credentials
+	endpoint string
+	client   *http.Client
+	logger   *slog.Logger
+	// retry is the first wait after a failure, minRetry outside tests
+	retry time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewUpdater validates the record and finds the credentials
+func NewUpdater(cfg *Config) (*Updater, error) {
+	if err := cfg.Record.Validate(); err != nil {
+		return nil, err
+	}
+	u := &Updater{
+		record:   cfg.Record,
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		client:   &http.Client{Timeout: requestTimeout},
+		logger:   cfg.Logger,
+		retry:    minRetry,
+		creds: Credentials{
+			AccessKeyID:     cfg.Record.AccessKeyID,
+			SecretAccessKey: cfg.Record.SecretAccessKey,
+		},
+	}
+	if u.record.Type == "" {
+		u.record.Type = "A"
+	}
+	if u.record.TTL == 0 {
+		u.record.TTL = DefaultTTL
+	}
+	u.record.HostedZoneID = strings.TrimPrefix(u.record.HostedZoneID, "/hostedzone/")
+	if u.endpoint == "" {
+		u.endpoint = DefaultEndpoint
+	}
+	if u.logger == nil {
+		u.logger = slog.Default()
+	}
+	if u.creds.AccessKeyID == "" {
+		u.creds = Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if u.creds.AccessKeyID == "" || u.creds.SecretAccessKey == "" {
+			return nil, errors.New("route53: no credentials: set access_key_id and secret_access_key " +
+				"or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+	}
+	return u, nil
+}
+
+// SetMaster starts pointing the record at this host when master is true, and
+// gives up an update still being retried when it is false. It does not
+// wait for Route 53.
+func (u *Updater) SetMaster(master bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.cancel != nil {
+		u.cancel()
+		u.cancel = nil
+	}
+	if !master || u.closed {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+	u.wg.Add(1)
+	go u.update(ctx)
+}
+
+// Close gives up an update still being retried and waits for it to return
+func (u *Updater) Close() {
+	u.mu.Lock()
+	u.closed = true
+	if u.cancel != nil {
+		u.cancel()
+		u.cancel = nil
+	}
+	u.mu.Unlock()
+	u.wg.Wait()
+}
+
+// update upserts the record until it succeeds or ctx is canceled, waiting
+// twice as long after each failure, from u.retry up to maxRetry
+func (u *Updater) update(ctx context.Context) {
+	defer u.wg.Done()
+
+	wait := u.retry
+	for {
+		id, err := u.upsert(ctx)
+		if err == nil {
+			u.logger.Info("Pointed Route 53 record at this host", "record", u.record.String(), "change", id)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		u.logger.Warn("Failed to update Route 53 record, retrying", "record", u.record.String(),
+			"err", err, "retry", wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, maxRetry)
+	}
+}
+
+// changeRequest is the body of ChangeResourceRecordSets
+type changeRequest struct {
+	XMLName xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Comment string   `xml:"ChangeBatch>Comment"`
+	Changes []change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type change struct {
+	Action string   `xml:"Action"`
+	Name   string   `xml:"ResourceRecordSet>Name"`
+	Type   string   `xml:"ResourceRecordSet>Type"`
+	TTL    int      `xml:"ResourceRecordSet>TTL"`
+	Values []string `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+// changeResponse is the answer to ChangeResourceRecordSets, or an error
+type changeResponse struct {
+	ID     string `xml:"ChangeInfo>Id"`
+	Code   string `xml:"Error>Code"`
+	Errmsg string `xml:"Error>Message"`
+}
+
+// upsert points the record at this host and returns the ID of the change
+func (u *Updater) upsert(ctx context.Context) (string, error) {
+	body, err := xml.Marshal(changeRequest{
+		Comment: "vrrp-simple: " + u.record.Value + " became MASTER",
+		Changes: []change{{
+			Action: "UPSERT",
+			Name:   u.record.Name,
+			Type:   u.record.Type,
+			TTL:    u.record.TTL,
+			Values: []string{u.record.Value},
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	body = append([]byte(xml.Header), body...)
+
+	url := u.endpoint + "/2013-04-01/hostedzone/" + u.record.HostedZoneID + "/rrset/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	sign(req, body, &u.creds, "us-east-1", "route53", time.Now())
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	var out changeResponse
+	_ = xml.Unmarshal(data, &out)
+	if resp.StatusCode/100 != 2 {
+		if out.Code != "" {
+			return "", fmt.Errorf("%s: %s: %s", resp.Status, out.Code, out.Errmsg)
+		}
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	return out.ID, nil
+}
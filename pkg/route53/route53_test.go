@@ -0,0 +1,177 @@
+package route53
+
+import (
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSign checks the get-vanilla case of the AWS Signature Version 4 test
+// suite
+func TestSign(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	creds := &Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	sign(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+}
+
+func TestRecordValidate(t *testing.T) {
+	valid := Record{HostedZoneID: "Z0123456789ABC", Name: "app.example.com", Value: "198.51.100.10"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate(%+v) = %v", valid, err)
+	}
+	v6 := Record{HostedZoneID: "Z0123456789ABC", Name: "app.example.com", Type: "AAAA", Value: "2001:db8::10"}
+	if err := v6.Validate(); err != nil {
+		t.Errorf("Validate(%+v) = %v", v6, err)
+	}
+
+	for name, edit := range map[string]func(r *Record){
+		"no zone":          func(r *Record) { r.HostedZoneID = "" },
+		"no value":         func(r *Record) { r.Value = "" },
+		"a name as value":  func(r *Record) { r.Value = "lb1.example.com" },
+		"an IPv6 A record": func(r *Record) { r.Value = "2001:db8::10" },
+		"an IPv4 AAAA":     func(r *Record) { r.Type = "AAAA" },
+		"a CNAME":          func(r *Record) { r.Type = "CNAME" },
+		"a negative TTL":   func(r *Record) { r.TTL = -1 },
+		"half a key":       func(r *Record) { r.AccessKeyID = "AKIDEXAMPLE" },
+	} {
+		r := valid
+		edit(&r)
+		if err := r.Validate(); err == nil {
+			t.Errorf("Validate with %s succeeded", name)
+		}
+	}
+}
+
+// fakeRoute53 accepts changes after failing the first fail requests
+type fakeRoute53 struct {
+	mu      sync.Mutex
+	fail    int
+	changes []changeRequest
+	paths   []string
+	auth    []string
+	done    chan struct{}
+}
+
+func (f *fakeRoute53) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paths = append(f.paths, r.URL.Path)
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	if f.fail > 0 {
+		f.fail--
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, `<ErrorResponse><Error><Code>PriorRequestNotComplete</Code>`+
+			`<Message>The request was rejected because Route 53 was still processing a prior request.</Message>`+
+			`</Error></ErrorResponse>`)
+		return
+	}
+	var req changeRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.changes = append(f.changes, req)
+	_, _ = io.WriteString(w, `<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id>`+
+		`<Status>PENDING</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`)
+	close(f.done)
+}
+
+func TestUpdater(t *testing.T) {
+	f := &fakeRoute53{fail: 2, done: make(chan struct{})}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	u, err := NewUpdater(&Config{
+		Record: Record{HostedZoneID: "/hostedzone/Z0123456789ABC", Name: "app.example.com", Value: "198.51.100.10",
+			AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"},
+		Endpoint: srv.URL,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewUpdater: %v", err)
+	}
+	u.retry = time.Millisecond
+	defer u.Close()
+
+	u.SetMaster(true)
+	select {
+	case <-f.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("record not updated after the failures")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.paths) != 3 || f.paths[2] != "/2013-04-01/hostedzone/Z0123456789ABC/rrset/" {
+		t.Errorf("requests to %q, want 3 to the zone's rrset", f.paths)
+	}
+	if !strings.HasPrefix(f.auth[2], "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+		!strings.Contains(f.auth[2], "/us-east-1/route53/aws4_request") {
+		t.Errorf("Authorization = %q", f.auth[2])
+	}
+	c := f.changes[0].Changes
+	if len(c) != 1 || c[0].Action != "UPSERT" || c[0].Name != "app.example.com" || c[0].Type != "A" ||
+		c[0].TTL != DefaultTTL || len(c[0].Values) != 1 || c[0].Values[0] != "198.51.100.10" {
+		t.Errorf("change = %+v", c)
+	}
+}
+
+func TestUpdaterGivesUp(t *testing.T) {
+	f := &fakeRoute53{fail: 1 << 30, done: make(chan struct{})}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	u, err := NewUpdater(&Config{
+		Record:   Record{HostedZoneID: "Z0123456789ABC", Name: "app.example.com", Value: "198.51.100.10"},
+		Endpoint: srv.URL,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("NewUpdater: %v", err)
+	}
+	u.retry = time.Millisecond
+
+	u.SetMaster(true)
+	time.Sleep(20 * time.Millisecond)
+	// Leaving MASTER ends the retries; Close would hang otherwise
+	u.SetMaster(false)
+	u.Close()
+	// Closing the server waits for the handler of a request canceled by
+	// Close, which may still be counting it
+	srv.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.paths) == 0 {
+		t.Error("no request made before leaving MASTER")
+	}
+}
+
+func TestNewUpdaterNeedsCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	_, err := NewUpdater(&Config{
+		Record: Record{HostedZoneID: "Z0123456789ABC", Name: "app.example.com", Value: "198.51.100.10"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "no credentials") {
+		t.Errorf("NewUpdater without credentials = %v", err)
+	}
+}
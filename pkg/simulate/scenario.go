@@ -0,0 +1,156 @@
+// Package simulate runs several VRRP state machines in one process, connected
+// by an in-memory transport instead of raw sockets, and records the election
+// timeline of a scripted scenario
+package simulate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+)
+
+// Actions a scenario event can perform on a router
+const (
+	ActionFail        = "fail"         // crash: stop the router and release its VIPs
+	ActionRecover     = "recover"      // restart a failed router
+	ActionIsolate     = "isolate"      // cut the router off the network, it keeps running
+	ActionRejoin      = "rejoin"       // reconnect an isolated router
+	ActionSetPriority = "set-priority" // change the priority, like vrrp set
+	ActionFailover    = "failover"     // step down for hold seconds, like vrrp failover
+)
+
+// Scenario describes the routers of one virtual router group and what happens
+// to them. Times are in seconds from the start of the simulation.
+type Scenario struct {
+	VRID           uint8     `json:"vrid"`
+	VirtualIPs     []string  `json:"virtual_ips"`
+	AdvertInterval int       `json:"advert_interval,omitempty"`
+	Duration       float64   `json:"duration"`
+	Routers        []Router  `json:"routers"`
+	Events         []Trigger `json:"events,omitempty"`
+}
+
+// Router is one simulated VRRP router
+type Router struct {
+	Name     string `json:"name"`
+	Priority uint8  `json:"priority"`
+	Preempt  *bool  `json:"preempt,omitempty"`
+
+	// SourceIP breaks ties between routers of equal priority
+	SourceIP string `json:"source_ip,omitempty"`
+}
+
+// Trigger is a scripted event
+type Trigger struct {
+	At       float64 `json:"at"`
+	Router   string  `json:"router"`
+	Action   string  `json:"action"`
+	Priority uint8   `json:"priority,omitempty"`
+	Hold     float64 `json:"hold,omitempty"`
+}
+
+// Load reads and validates a scenario file
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Parse decodes and validates a scenario, rejecting unknown fields. Events
+// are sorted by time.
+func Parse(data []byte) (*Scenario, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var s Scenario
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+
+	if s.AdvertInterval == 0 {
+		s.AdvertInterval = 1
+	}
+	for i := range s.Routers {
+		r := &s.Routers[i]
+		if r.Priority == 0 {
+			r.Priority = 100
+		}
+		if r.SourceIP == "" {
+			r.SourceIP = fmt.Sprintf("192.0.2.%d", i+1)
+		}
+	}
+	sort.SliceStable(s.Events, func(i, j int) bool { return s.Events[i].At < s.Events[j].At })
+
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	return &s, nil
+}
+
+func (s *Scenario) validate() error {
+	if s.VRID == 0 {
+		return fmt.Errorf("vrid must be between 1 and 255")
+	}
+	if len(s.VirtualIPs) == 0 {
+		return fmt.Errorf("at least one virtual IP is required")
+	}
+	if s.AdvertInterval < 1 || s.AdvertInterval > 255 {
+		return fmt.Errorf("advert_interval %d must be between 1 and 255 seconds", s.AdvertInterval)
+	}
+	if s.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if len(s.Routers) == 0 {
+		return fmt.Errorf("no routers defined")
+	}
+
+	names := make(map[string]bool)
+	for i, r := range s.Routers {
+		if r.Name == "" {
+			return fmt.Errorf("routers[%d]: name is required", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("routers[%d]: duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = true
+
+		if ip := net.ParseIP(r.SourceIP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("routers[%d]: invalid source_ip %q", i, r.SourceIP)
+		}
+	}
+
+	for i, ev := range s.Events {
+		if !names[ev.Router] {
+			return fmt.Errorf("events[%d]: unknown router %q", i, ev.Router)
+		}
+		if ev.At < 0 || ev.At > s.Duration {
+			return fmt.Errorf("events[%d]: at %gs is outside the %gs duration", i, ev.At, s.Duration)
+		}
+
+		switch ev.Action {
+		case ActionFail, ActionRecover, ActionIsolate, ActionRejoin:
+		case ActionSetPriority:
+			if ev.Priority == 0 {
+				return fmt.Errorf("events[%d]: set-priority needs a priority between 1 and 255", i)
+			}
+		case ActionFailover:
+			if ev.Hold < 0 {
+				return fmt.Errorf("events[%d]: hold must not be negative", i)
+			}
+		default:
+			return fmt.Errorf("events[%d]: unknown action %q", i, ev.Action)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,313 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Options tune a simulation run
+type Options struct {
+	// Speed runs the scenario this many times faster than real time by
+	// scaling the advertisement interval, hold times and event times
+	// (default 1)
+	Speed float64
+
+	// Logger receives the state machines' logs (default: discarded)
+	Logger *slog.Logger
+}
+
+// Entry is one line of the election timeline. At is scenario time, already
+// scaled back from the speed-up.
+type Entry struct {
+	At     time.Duration
+	Router string
+
+	// Old and New are set for state transitions
+	Old, New vrrp.State
+
+	// Action is set for scripted events, with Detail describing it
+	Action string
+	Detail string
+}
+
+// IsTransition reports whether the entry is a state change rather than a
+// scripted event
+func (e *Entry) IsTransition() bool {
+	return e.Action == ""
+}
+
+// Result is the outcome of a simulation
+type Result struct {
+	Timeline []Entry
+
+	// Final maps each router to its state at the end of the scenario
+	Final map[string]vrrp.State
+}
+
+// Masters returns the routers that were MASTER at the end, in scenario order
+func (r *Result) Masters(s *Scenario) []string {
+	var out []string
+	for _, rt := range s.Routers {
+		if r.Final[rt.Name] == vrrp.Master {
+			out = append(out, rt.Name)
+		}
+	}
+	return out
+}
+
+// nopAddresses stands in for netlink so simulated masters do not touch the
+// host's interfaces
+type nopAddresses struct{}
+
+func (nopAddresses) AddIP(net.IP) error { return nil }
+func (nopAddresses) DelIP(net.IP) error { return nil }
+
+type node struct {
+	index    int
+	cfg      Router
+	sm       *vrrp.StateMachine
+	cancel   context.CancelFunc
+	up       bool
+	isolated bool
+}
+
+type simulation struct {
+	scenario *Scenario
+	speed    float64
+	logger   *slog.Logger
+	vips     []net.IP
+
+	mu        sync.Mutex
+	start     time.Time
+	nodes     []*node
+	timeline  []Entry
+	recording bool
+	wg        sync.WaitGroup
+}
+
+// Run plays the scenario and returns the timeline. It takes the scenario's
+// duration divided by the speed.
+func Run(s *Scenario, opts Options) (*Result, error) {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	sim := &simulation{scenario: s, speed: speed, logger: logger}
+	for _, addr := range s.VirtualIPs {
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid virtual IP %q", addr)
+		}
+		sim.vips = append(sim.vips, ip.To4())
+	}
+
+	sim.mu.Lock()
+	sim.start = time.Now()
+	sim.recording = true
+	for i, r := range s.Routers {
+		n := &node{index: i, cfg: r}
+		sim.nodes = append(sim.nodes, n)
+		sim.startNode(n)
+	}
+	sim.mu.Unlock()
+
+	for _, ev := range s.Events {
+		time.Sleep(time.Until(sim.realTime(ev.At)))
+		sim.apply(ev)
+	}
+	time.Sleep(time.Until(sim.realTime(s.Duration)))
+
+	sim.mu.Lock()
+	sim.recording = false
+	res := &Result{Timeline: sim.timeline, Final: make(map[string]vrrp.State)}
+	var running []*node
+	for _, n := range sim.nodes {
+		res.Final[n.cfg.Name] = vrrp.Init
+		if n.up {
+			res.Final[n.cfg.Name] = n.sm.GetState()
+			n.up = false
+			running = append(running, n)
+		}
+	}
+	sim.mu.Unlock()
+
+	// Stop waits for the transition to INIT, whose callback takes sim.mu
+	for _, n := range running {
+		n.sm.Stop()
+		n.cancel()
+	}
+	sim.wg.Wait()
+	return res, nil
+}
+
+// realTime converts scenario seconds to wall-clock time
+func (sim *simulation) realTime(secs float64) time.Time {
+	return sim.start.Add(sim.scale(secs))
+}
+
+// scale converts scenario seconds to a real duration
+func (sim *simulation) scale(secs float64) time.Duration {
+	return time.Duration(secs / sim.speed * float64(time.Second))
+}
+
+// now returns the current scenario time
+func (sim *simulation) now() time.Duration {
+	return time.Duration(float64(time.Since(sim.start)) * sim.speed)
+}
+
+// startNode boots a router. sim.mu must be held.
+func (sim *simulation) startNode(n *node) {
+	s := sim.scenario
+	iface := &net.Interface{Name: "sim-" + n.cfg.Name}
+
+	sm := vrrp.NewStateMachine(s.VRID, n.cfg.Priority, sim.vips, iface)
+	sm.SetLogger(sim.logger.With("router", n.cfg.Name))
+	sm.SetAddressManager(nopAddresses{})
+	sm.SetSourceIP(net.ParseIP(n.cfg.SourceIP))
+	sm.SetAdvertisementInterval(sim.scale(float64(s.AdvertInterval)))
+	sm.SetPreempt(n.cfg.Preempt == nil || *n.cfg.Preempt)
+	sm.SetStateChangeCallback(func(old, new vrrp.State) {
+		sim.record(Entry{Router: n.cfg.Name, Old: old, New: new})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.sm = sm
+	n.cancel = cancel
+	n.up = true
+
+	sim.wg.Add(1)
+	go sim.forward(ctx, n, sm)
+
+	_ = sm.Start(ctx)
+}
+
+// forward is the in-memory transport: it delivers every advertisement sm
+// sends to the other running, connected routers
+func (sim *simulation) forward(ctx context.Context, n *node, sm *vrrp.StateMachine) {
+	defer sim.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pkt := <-sm.GetSendChannel():
+			sim.mu.Lock()
+			if !n.isolated {
+				for _, peer := range sim.nodes {
+					if peer != n && peer.up && !peer.isolated {
+						peer.sm.ProcessPacket(pkt)
+					}
+				}
+			}
+			sim.mu.Unlock()
+		}
+	}
+}
+
+func (sim *simulation) record(e Entry) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.recordLocked(e)
+}
+
+func (sim *simulation) recordLocked(e Entry) {
+	if !sim.recording {
+		return
+	}
+	e.At = sim.now()
+	sim.timeline = append(sim.timeline, e)
+}
+
+func (sim *simulation) apply(ev Trigger) {
+	sim.mu.Lock()
+	var n *node
+	for _, candidate := range sim.nodes {
+		if candidate.cfg.Name == ev.Router {
+			n = candidate
+		}
+	}
+
+	entry := Entry{Router: ev.Router, Action: ev.Action}
+	var sm *vrrp.StateMachine
+
+	switch ev.Action {
+	case ActionFail:
+		if !n.up {
+			entry.Detail = "already failed"
+			break
+		}
+		sim.recordLocked(entry)
+		sim.mu.Unlock()
+		// Stop records the transition to INIT through the callback
+		n.sm.Stop()
+		sim.mu.Lock()
+		n.up = false
+		n.cancel()
+		sim.mu.Unlock()
+		return
+
+	case ActionRecover:
+		if n.up {
+			entry.Detail = "already running"
+			break
+		}
+		sim.recordLocked(entry)
+		sim.startNode(n)
+		sim.mu.Unlock()
+		return
+
+	case ActionIsolate:
+		n.isolated = true
+
+	case ActionRejoin:
+		n.isolated = false
+
+	case ActionSetPriority:
+		entry.Detail = fmt.Sprintf("%d -> %d", n.cfg.Priority, ev.Priority)
+		n.cfg.Priority = ev.Priority
+		if n.up {
+			sm = n.sm
+		}
+
+	case ActionFailover:
+		if n.up {
+			sm = n.sm
+		} else {
+			entry.Detail = "router is down"
+		}
+	}
+
+	sim.recordLocked(entry)
+	sim.mu.Unlock()
+
+	// State machine calls run on its goroutine, which may need sim.mu to
+	// record a transition
+	if sm == nil {
+		return
+	}
+	switch ev.Action {
+	case ActionSetPriority:
+		sm.SetPriority(ev.Priority)
+	case ActionFailover:
+		hold := ev.Hold
+		if hold == 0 {
+			hold = 60
+		}
+		if err := sm.StepDown(sim.scale(hold)); err != nil {
+			sim.record(Entry{Router: ev.Router, Action: ev.Action, Detail: "ignored: " + err.Error()})
+		}
+	}
+}
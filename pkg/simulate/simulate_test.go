@@ -0,0 +1,128 @@
+package simulate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// testSpeed keeps each scenario well under a second of real time
+const testSpeed = 20
+
+func mustParse(t *testing.T, data string) *Scenario {
+	t.Helper()
+	s, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return s
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "unknown field",
+			data: `{"vrid": 1, "virtual_ips": ["10.0.0.100"], "duration": 5, "routers": [{"name": "a"}], "bogus": 1}`,
+			want: "unknown field",
+		},
+		{
+			name: "no routers",
+			data: `{"vrid": 1, "virtual_ips": ["10.0.0.100"], "duration": 5}`,
+			want: "no routers",
+		},
+		{
+			name: "unknown router",
+			data: `{"vrid": 1, "virtual_ips": ["10.0.0.100"], "duration": 5, "routers": [{"name": "a"}],
+				"events": [{"at": 1, "router": "b", "action": "fail"}]}`,
+			want: `unknown router "b"`,
+		},
+		{
+			name: "unknown action",
+			data: `{"vrid": 1, "virtual_ips": ["10.0.0.100"], "duration": 5, "routers": [{"name": "a"}],
+				"events": [{"at": 1, "router": "a", "action": "explode"}]}`,
+			want: `unknown action "explode"`,
+		},
+		{
+			name: "event after end",
+			data: `{"vrid": 1, "virtual_ips": ["10.0.0.100"], "duration": 5, "routers": [{"name": "a"}],
+				"events": [{"at": 6, "router": "a", "action": "fail"}]}`,
+			want: "outside",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFailAndRecover(t *testing.T) {
+	s := mustParse(t, `{
+		"vrid": 10,
+		"virtual_ips": ["10.0.0.100"],
+		"duration": 12,
+		"routers": [
+			{"name": "a", "priority": 150},
+			{"name": "b", "priority": 100}
+		],
+		"events": [
+			{"at": 4, "router": "a", "action": "fail"},
+			{"at": 8, "router": "a", "action": "recover"}
+		]
+	}`)
+
+	res, err := Run(s, Options{Speed: testSpeed})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if got := res.Masters(s); len(got) != 1 || got[0] != "a" {
+		t.Errorf("final masters = %v, want [a] after preempting again", got)
+	}
+
+	var bMaster bool
+	for _, e := range res.Timeline {
+		if e.IsTransition() && e.Router == "b" && e.New == vrrp.Master {
+			bMaster = true
+			if e.At < 4e9 {
+				t.Errorf("b became MASTER at %s, before a failed", e.At)
+			}
+		}
+	}
+	if !bMaster {
+		t.Errorf("b never took over while a was down: %+v", res.Timeline)
+	}
+}
+
+func TestRunIsolationSplitBrain(t *testing.T) {
+	s := mustParse(t, `{
+		"vrid": 10,
+		"virtual_ips": ["10.0.0.100"],
+		"duration": 8,
+		"routers": [
+			{"name": "a", "priority": 150},
+			{"name": "b", "priority": 100}
+		],
+		"events": [
+			{"at": 3, "router": "b", "action": "isolate"}
+		]
+	}`)
+
+	res, err := Run(s, Options{Speed: testSpeed})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if got := res.Masters(s); len(got) != 2 {
+		t.Errorf("final masters = %v, want both routers MASTER while partitioned", got)
+	}
+}
@@ -0,0 +1,84 @@
+package vrrp
+
+import (
+	"fmt"
+	"net"
+	"slices"
+)
+
+// AddressCheck selects what a router does with advertisements whose virtual
+// IPs differ from its own, as they do on every router of a VRID but the one
+// reconfigured first during a rolling change of the VIPs. Either way they are
+// counted in Counters.AddressListErrors and each peer is logged once per
+// list.
+type AddressCheck string
+
+const (
+	// AddressWarn accepts them (the default)
+	AddressWarn AddressCheck = "warn"
+	// AddressDrop drops them as DropAddressList, including those with no
+	// addresses at all
+	AddressDrop AddressCheck = "drop"
+	// AddressAdopt accepts them and reports the master's list as
+	// Status.Master.VirtualIPs. The router still programs its own.
+	AddressAdopt AddressCheck = "adopt"
+)
+
+// AddressChecks lists the valid values of Config.AddressCheck
+var AddressChecks = []AddressCheck{AddressWarn, AddressDrop, AddressAdopt}
+
+func validateAddressCheck(c AddressCheck) error {
+	if c == "" || slices.Contains(AddressChecks, c) {
+		return nil
+	}
+	return fmt.Errorf("%w: unknown address check %q", ErrInvalidConfig, c)
+}
+
+// SetAddressCheck replaces Config.AddressCheck, taking effect with the next
+// advertisement received
+func (vr *VirtualRouter) SetAddressCheck(c AddressCheck) error {
+	if err := validateAddressCheck(c); err != nil {
+		return err
+	}
+	if c == "" {
+		c = AddressWarn
+	}
+	vr.addressCheck.Store(c)
+	return nil
+}
+
+// AddressCheck returns what the router does with advertisements of other
+// virtual IPs
+func (vr *VirtualRouter) AddressCheck() AddressCheck {
+	c, _ := vr.addressCheck.Load().(AddressCheck)
+	return c
+}
+
+// checkAddresses compares the virtual IPs of an advertisement for the router
+// with its own configuration (RFC 3768 section 7.1), counting and logging a
+// mismatch. It returns the advertised list to report if the check adopts
+// it, nil if not, and whether the advertisement is to be dropped. One with
+// no addresses at all is a mismatch too, but its priority still counts in
+// the election unless the check drops it.
+func (vr *VirtualRouter) checkAddresses(pkt *Packet, src net.IP, want *advertExpect) (adopted []net.IP, drop bool) {
+	key := peerKey(src)
+	if sameIPSet(pkt.IPAddresses, want.ips) {
+		vr.addressWarned.agreed(key)
+		return nil, false
+	}
+
+	vr.addressListErrors.Add(1)
+	vr.metrics.AdvertMismatch(vr.iface, vr.vrid, MismatchAddressList)
+	check := vr.AddressCheck()
+	if ips := formatIPs(pkt.IPAddresses); vr.addressWarned.first(key, ips) {
+		vr.logger.Warn("Peer advertises different virtual IPs", "src", src, "virtual_ips", ips,
+			"local_virtual_ips", formatIPs(want.ips), "action", string(check))
+	}
+	switch check {
+	case AddressDrop:
+		return nil, true
+	case AddressAdopt:
+		return pkt.IPAddresses, false
+	}
+	return nil, false
+}
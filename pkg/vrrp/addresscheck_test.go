@@ -0,0 +1,110 @@
+package vrrp
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestAddressWarnings(t *testing.T) {
+	var w peerWarnings[string]
+	a := netip.MustParseAddr("10.0.0.2")
+
+	for i, tc := range []struct {
+		ips  string
+		want bool
+	}{
+		{"[192.168.1.101]", true},
+		{"[192.168.1.101]", false},
+		// A new list is logged again
+		{"[192.168.1.102]", true},
+	} {
+		if got := w.first(a, tc.ips); got != tc.want {
+			t.Errorf("first %d with %s = %v, want %v", i, tc.ips, got, tc.want)
+		}
+	}
+	w.agreed(a)
+	if !w.first(a, "[192.168.1.102]") {
+		t.Error("mismatch after agreeing is not logged")
+	}
+
+	// Past MaxPeers sources nothing more is logged
+	for i := range MaxPeers {
+		w.first(netip.AddrFrom4([4]byte{10, 0, 1, byte(i)}), "[]")
+	}
+	if w.first(netip.MustParseAddr("10.0.2.1"), "[]") || len(w.logged) != MaxPeers {
+		t.Errorf("table holds %d sources and logs another, want %d and not", len(w.logged), MaxPeers)
+	}
+}
+
+func TestAddressCheck(t *testing.T) {
+	vr := newTestRouter(t)
+	vr.stateMachine.transition(Backup)
+	var logs bytes.Buffer
+	vr.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	marshal := func(vips ...string) []byte {
+		var ips []net.IP
+		for _, v := range vips {
+			ips = append(ips, net.ParseIP(v).To4())
+		}
+		data, err := NewPacket(VRRPv2, 10, 150, ips).Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return data
+	}
+
+	// Counted and accepted by default, and logged once
+	for range 3 {
+		vr.handleAdvert(peer, marshal("192.168.1.100", "192.168.1.101"), ownIP)
+	}
+	s := vr.GetStats()
+	if s.AdvertsReceived != 3 || s.AddressListErrors != 3 || s.Drops[DropAddressList] != 0 {
+		t.Errorf("received %d, %d address list errors, %d dropped; want 3, 3 and 0",
+			s.AdvertsReceived, s.AddressListErrors, s.Drops[DropAddressList])
+	}
+	if n := strings.Count(logs.String(), "different virtual IPs"); n != 1 {
+		t.Errorf("logged the mismatch %d times, want once:\n%s", n, logs.String())
+	}
+	if vips := vr.Status().Master.VirtualIPs; vips != nil {
+		t.Errorf("master VIPs = %v without adopting, want none", vips)
+	}
+
+	// Adopting reports the master's list until it agrees again
+	if err := vr.SetAddressCheck(AddressAdopt); err != nil {
+		t.Fatalf("SetAddressCheck: %v", err)
+	}
+	vr.handleAdvert(peer, marshal("192.168.1.100", "192.168.1.101"), ownIP)
+	if vips := vr.Status().Master.VirtualIPs; len(vips) != 2 || !vips[1].Equal(net.ParseIP("192.168.1.101")) {
+		t.Errorf("adopted master VIPs = %v, want 192.168.1.100 and 192.168.1.101", vips)
+	}
+	vr.handleAdvert(peer, marshal("192.168.1.100"), ownIP)
+	if vips := vr.Status().Master.VirtualIPs; vips != nil {
+		t.Errorf("master VIPs = %v after agreeing, want none", vips)
+	}
+
+	if err := vr.SetAddressCheck(AddressDrop); err != nil {
+		t.Fatalf("SetAddressCheck: %v", err)
+	}
+	vr.ResetStats()
+	vr.handleAdvert(peer, marshal("192.168.1.101"), ownIP)
+	vr.handleAdvert(peer, marshal(), ownIP)
+	vr.handleAdvert(peer, marshal("192.168.1.100"), ownIP)
+	s = vr.GetStats()
+	if s.AdvertsReceived != 1 || s.AddressListErrors != 2 || s.Drops[DropAddressList] != 2 {
+		t.Errorf("received %d, %d address list errors, %d dropped; want 1, 2 and 2",
+			s.AdvertsReceived, s.AddressListErrors, s.Drops[DropAddressList])
+	}
+
+	if err := vr.SetAddressCheck("adopted"); err == nil {
+		t.Error(`SetAddressCheck("adopted") succeeded`)
+	}
+}
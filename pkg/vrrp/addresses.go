@@ -0,0 +1,101 @@
+package vrrp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// AddressBackend selects how a router programs its virtual IPs
+type AddressBackend string
+
+const (
+	// AddressNetlink adds and removes addresses over netlink (the default)
+	AddressNetlink AddressBackend = "netlink"
+	// AddressExec runs `ip addr add` and `ip addr del`, for systems where
+	// the address must go through the distribution's own tooling
+	AddressExec AddressBackend = "exec"
+	// AddressNoop runs the election without touching the interface, e.g.
+	// when the state change callback moves the addresses some other way.
+	// Nothing is announced and DetectVIPConflicts is ignored.
+	AddressNoop AddressBackend = "noop"
+)
+
+// AddressBackends lists the valid values of Config.AddressBackend
+var AddressBackends = []AddressBackend{AddressNetlink, AddressExec, AddressNoop}
+
+func validateAddressBackend(b AddressBackend) error {
+	if b == "" {
+		return nil
+	}
+	for _, valid := range AddressBackends {
+		if b == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown address backend %q", ErrInvalidConfig, b)
+}
+
+// execTimeout bounds one ip(8) invocation
+const execTimeout = 5 * time.Second
+
+// ExecAddresses programs virtual IPs by running ip(8). Like IPManager, adding
+// an address that is present or removing one that is absent succeeds.
+type ExecAddresses struct {
+	iface string
+
+	// run executes a command and returns its combined output
+	run func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// NewExecAddresses creates an exec backend for the named interface
+func NewExecAddresses(iface string) *ExecAddresses {
+	return &ExecAddresses{iface: iface, run: runCommand}
+}
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// AddIP runs `ip addr add <ip>/32 dev <iface>`
+func (e *ExecAddresses) AddIP(ip net.IP) error {
+	return e.ip("add", ip, "File exists")
+}
+
+// DelIP runs `ip addr del <ip>/32 dev <iface>`
+func (e *ExecAddresses) DelIP(ip net.IP) error {
+	return e.ip("del", ip, "Cannot assign requested address")
+}
+
+// ip runs one address command. Output containing benign means the address
+// was already in the wanted state.
+func (e *ExecAddresses) ip(op string, ip net.IP, benign string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	prefix := "/32"
+	if ip.To4() == nil {
+		prefix = "/128"
+	}
+
+	out, err := e.run(ctx, "ip", "addr", op, ip.String()+prefix, "dev", e.iface)
+	if err == nil || strings.Contains(string(out), benign) {
+		return nil
+	}
+
+	msg := strings.TrimSpace(string(out))
+	if strings.Contains(msg, "Operation not permitted") {
+		err = fmt.Errorf("%w: %w", ErrPermission, err)
+	}
+	return fmt.Errorf("ip addr %s %s dev %s: %w: %s", op, ip, e.iface, err, msg)
+}
+
+// NopAddresses accepts every address change without making it. Tests use it
+// to drive transitions without root.
+type NopAddresses struct{}
+
+func (NopAddresses) AddIP(net.IP) error { return nil }
+func (NopAddresses) DelIP(net.IP) error { return nil }
@@ -0,0 +1,70 @@
+package vrrp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeIP records ip(8) invocations and answers with the given output
+type fakeIP struct {
+	commands []string
+	out      string
+	err      error
+}
+
+func (f *fakeIP) run(_ context.Context, name string, args ...string) ([]byte, error) {
+	f.commands = append(f.commands, name+" "+strings.Join(args, " "))
+	return []byte(f.out), f.err
+}
+
+func TestExecAddresses(t *testing.T) {
+	fake := &fakeIP{}
+	e := &ExecAddresses{iface: "eth0", run: fake.run}
+
+	ip := net.ParseIP("192.168.1.100")
+	if err := e.AddIP(ip); err != nil {
+		t.Fatalf("AddIP: %v", err)
+	}
+	if err := e.DelIP(ip); err != nil {
+		t.Fatalf("DelIP: %v", err)
+	}
+
+	want := []string{
+		"ip addr add 192.168.1.100/32 dev eth0",
+		"ip addr del 192.168.1.100/32 dev eth0",
+	}
+	if strings.Join(fake.commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", fake.commands, want)
+	}
+}
+
+func TestExecAddressesErrors(t *testing.T) {
+	ip := net.ParseIP("192.168.1.100")
+	exitErr := errors.New("exit status 2")
+
+	// Already in the wanted state
+	e := &ExecAddresses{iface: "eth0", run: (&fakeIP{out: "RTNETLINK answers: File exists\n", err: exitErr}).run}
+	if err := e.AddIP(ip); err != nil {
+		t.Errorf("AddIP of a present address = %v, want nil", err)
+	}
+	e.run = (&fakeIP{out: "RTNETLINK answers: Cannot assign requested address\n", err: exitErr}).run
+	if err := e.DelIP(ip); err != nil {
+		t.Errorf("DelIP of an absent address = %v, want nil", err)
+	}
+
+	e.run = (&fakeIP{out: "RTNETLINK answers: Operation not permitted\n", err: exitErr}).run
+	err := e.AddIP(ip)
+	if !errors.Is(err, ErrPermission) || !strings.Contains(err.Error(), "Operation not permitted") {
+		t.Errorf("AddIP without privileges = %v, want ErrPermission with ip's message", err)
+	}
+}
+
+func TestAddressBackendValidation(t *testing.T) {
+	cfg := NewConfig("eth0", 10, WithVIPs("192.168.1.100"), WithAddressBackend("ifconfig"))
+	if _, err := NewVirtualRouter(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewVirtualRouter with an unknown backend = %v, want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,81 @@
+package vrrp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ParseAllowedPeers parses Config.AllowedPeers, addresses or CIDR prefixes,
+// into prefixes. It returns nil for no restriction.
+func ParseAllowedPeers(peers []string) ([]netip.Prefix, error) {
+	if len(peers) == 0 {
+		return nil, nil
+	}
+	prefixes := make([]netip.Prefix, 0, len(peers))
+	for _, s := range peers {
+		var prefix netip.Prefix
+		var err error
+		if strings.Contains(s, "/") {
+			prefix, err = netip.ParsePrefix(s)
+			prefix = prefix.Masked()
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(s)
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid allowed peer %q: %w", ErrInvalidConfig, s, err)
+		}
+		if !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("%w: only IPv4 peers are supported: %s", ErrInvalidConfig, s)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// SetAllowedPeers replaces Config.AllowedPeers, taking effect with the next
+// advertisement received. Empty accepts advertisements from any source.
+func (vr *VirtualRouter) SetAllowedPeers(peers []string) error {
+	prefixes, err := ParseAllowedPeers(peers)
+	if err != nil {
+		return err
+	}
+	vr.setAllowedPeers(prefixes)
+	return nil
+}
+
+func (vr *VirtualRouter) setAllowedPeers(prefixes []netip.Prefix) {
+	if prefixes == nil {
+		vr.allowedPeers.Store(nil)
+		return
+	}
+	vr.allowedPeers.Store(&prefixes)
+}
+
+// AllowedPeers returns the prefixes advertisements are accepted from, nil
+// if any source is
+func (vr *VirtualRouter) AllowedPeers() []netip.Prefix {
+	if p := vr.allowedPeers.Load(); p != nil {
+		return slices.Clone(*p)
+	}
+	return nil
+}
+
+// peerAllowed reports whether an advertisement from src is accepted
+func (vr *VirtualRouter) peerAllowed(src net.IP) bool {
+	p := vr.allowedPeers.Load()
+	if p == nil {
+		return true
+	}
+	addr := peerKey(src)
+	for _, prefix := range *p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
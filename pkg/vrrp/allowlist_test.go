@@ -0,0 +1,62 @@
+package vrrp
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestParseAllowedPeers(t *testing.T) {
+	prefixes, err := ParseAllowedPeers([]string{"10.0.0.2", "192.168.1.9/29"})
+	if err != nil {
+		t.Fatalf("ParseAllowedPeers: %v", err)
+	}
+	if got := formatPrefixes(prefixes); got != "[10.0.0.2/32, 192.168.1.8/29]" {
+		t.Errorf("ParseAllowedPeers = %s", got)
+	}
+	if prefixes, err := ParseAllowedPeers(nil); prefixes != nil || err != nil {
+		t.Errorf("ParseAllowedPeers(nil) = %v, %v, want no restriction", prefixes, err)
+	}
+	for _, bad := range []string{"bogus", "10.0.0.0/33", "fe80::1", "2001:db8::/32"} {
+		if _, err := ParseAllowedPeers([]string{bad}); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseAllowedPeers(%q) = %v, want ErrInvalidConfig", bad, err)
+		}
+	}
+}
+
+func TestAllowedPeers(t *testing.T) {
+	vr := newTestRouter(t)
+	if err := vr.SetAllowedPeers([]string{"10.0.0.2", "192.168.1.8/29"}); err != nil {
+		t.Fatalf("SetAllowedPeers: %v", err)
+	}
+
+	ownIP := net.ParseIP("10.0.0.1")
+	for _, src := range []string{"10.0.0.2", "192.168.1.14", "10.0.0.3", "192.168.1.16"} {
+		vr.handleAdvert(&ipv4.Header{Src: net.ParseIP(src), TTL: 255}, marshalAdvert(t, 10, 255), ownIP)
+	}
+	// Other VRIDs are not for the allowlist to judge
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.3"), TTL: 255}, marshalAdvert(t, 20, 255), ownIP)
+
+	s := vr.GetStats()
+	if s.AdvertsReceived != 2 || s.Drops[DropPeer] != 2 || s.VRIDMismatches != 1 {
+		t.Errorf("received %d, dropped %d from other peers and %d for other VRIDs; want 2, 2 and 1",
+			s.AdvertsReceived, s.Drops[DropPeer], s.VRIDMismatches)
+	}
+	if s.LastProtocolError != DropVRIDMismatch {
+		t.Errorf("LastProtocolError = %q", s.LastProtocolError)
+	}
+	if got := vr.stateMachine.QueueLengths().Recv; got != 2 {
+		t.Errorf("state machine received %d packets, want the 2 from allowed peers", got)
+	}
+
+	// Without an allowlist any source is accepted
+	if err := vr.SetAllowedPeers(nil); err != nil {
+		t.Fatalf("SetAllowedPeers(nil): %v", err)
+	}
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.3"), TTL: 255}, marshalAdvert(t, 10, 255), ownIP)
+	if got := vr.GetStats().AdvertsReceived; got != 3 {
+		t.Errorf("AdvertsReceived = %d after clearing the allowlist, want 3", got)
+	}
+}
@@ -0,0 +1,128 @@
+package vrrp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// arpLen is the size of an Ethernet/IPv4 ARP message
+const arpLen = 28
+
+const (
+	arpRequest = 1
+	arpReply   = 2
+)
+
+// arpConn sends and receives ARP messages on one interface through a packet
+// socket. It needs CAP_NET_RAW.
+type arpConn struct {
+	fd    int
+	iface *net.Interface
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// openARP opens an ARP socket on iface. Reads time out after readTimeout so
+// the caller notices cancellation.
+func openARP(iface *net.Interface) (*arpConn, error) {
+	if len(iface.HardwareAddr) != 6 {
+		return nil, fmt.Errorf("interface %s has no Ethernet address", iface.Name)
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		if errors.Is(err, unix.EPERM) {
+			err = fmt.Errorf("%w: %w", ErrPermission, err)
+		}
+		return nil, fmt.Errorf("failed to create ARP socket: %w", err)
+	}
+
+	sa := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}
+	if err := unix.Bind(fd, sa); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind ARP socket to %s: %w", iface.Name, err)
+	}
+
+	tv := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to set ARP read timeout: %w", err)
+	}
+
+	return &arpConn{fd: fd, iface: iface}, nil
+}
+
+// probe broadcasts an ARP probe (RFC 5227) for ip: a request with a zero
+// sender address, which any host owning ip answers without updating caches
+func (c *arpConn) probe(ip net.IP) error {
+	msg := arpMessage(arpRequest, c.iface.HardwareAddr, net.IPv4zero, nil, ip)
+	if err := c.broadcast(msg); err != nil {
+		return fmt.Errorf("failed to send ARP probe for %s: %w", ip, err)
+	}
+	return nil
+}
+
+// arpMessage builds an Ethernet/IPv4 ARP message. A nil target hardware
+// address is sent as zeros.
+func arpMessage(op uint16, senderMAC net.HardwareAddr, senderIP net.IP, targetMAC net.HardwareAddr,
+	targetIP net.IP) []byte {
+	msg := make([]byte, arpLen)
+	binary.BigEndian.PutUint16(msg[0:2], 1) // Ethernet
+	binary.BigEndian.PutUint16(msg[2:4], unix.ETH_P_IP)
+	msg[4], msg[5] = 6, 4
+	binary.BigEndian.PutUint16(msg[6:8], op)
+	copy(msg[8:14], senderMAC)
+	copy(msg[14:18], senderIP.To4())
+	copy(msg[18:24], targetMAC)
+	copy(msg[24:28], targetIP.To4())
+	return msg
+}
+
+// broadcast sends msg to the Ethernet broadcast address
+func (c *arpConn) broadcast(msg []byte) error {
+	to := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  c.iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	return unix.Sendto(c.fd, msg, 0, to)
+}
+
+// read returns the sender of the next ARP request or reply. It returns
+// errARPTimeout if none arrived within readTimeout.
+func (c *arpConn) read() (net.HardwareAddr, net.IP, error) {
+	buf := make([]byte, 64)
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		switch {
+		case errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EINTR):
+			return nil, nil, errARPTimeout
+		case err != nil:
+			return nil, nil, fmt.Errorf("failed to read ARP message: %w", err)
+		}
+
+		// Only Ethernet/IPv4 requests and replies
+		if n < arpLen || binary.BigEndian.Uint16(buf[2:4]) != unix.ETH_P_IP || buf[4] != 6 || buf[5] != 4 {
+			continue
+		}
+		if op := binary.BigEndian.Uint16(buf[6:8]); op != arpRequest && op != arpReply {
+			continue
+		}
+		mac := net.HardwareAddr(append([]byte(nil), buf[8:14]...))
+		ip := net.IP(append([]byte(nil), buf[14:18]...))
+		return mac, ip, nil
+	}
+}
+
+func (c *arpConn) close() error {
+	return unix.Close(c.fd)
+}
+
+var errARPTimeout = errors.New("ARP read timed out")
@@ -0,0 +1,231 @@
+package vrrp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An authenticated advertisement is followed by a trailer that routers
+// without the extension do not expect, so it only works between instances
+// of this package:
+//
+//	0       4        8           16                       48
+//	| magic | key ID | timestamp | HMAC-SHA256            |
+//
+// The key ID and timestamp (Unix nanoseconds) are big-endian. The HMAC is
+// computed with the key over the IPv4 source address, the VRRP message and
+// the first 16 bytes of the trailer, so neither the message nor the sender
+// can be changed without the key.
+const (
+	authTrailerLen = 48
+	authSignedLen  = 16
+)
+
+// authMagic starts the trailer, to tell it apart from the end of a message
+var authMagic = [4]byte{'V', 'S', 'A', '1'}
+
+// MinAuthKeyLen is the shortest key Config.AuthKeys accepts
+const MinAuthKeyLen = 16
+
+// AuthKey is a key shared by the routers of a VRID for authenticated
+// advertisements, identified by its ID so keys can be rolled over
+type AuthKey struct {
+	ID  uint32
+	Key []byte
+}
+
+// AuthTrailer is the authentication trailer of a received advertisement,
+// decoded but not verified
+type AuthTrailer struct {
+	KeyID     uint32
+	Timestamp time.Time
+	MAC       []byte
+}
+
+// ParseAuthKeys parses Config.AuthKeys, "ID:KEY" each. It returns nil for
+// no authentication.
+func ParseAuthKeys(keys []string) ([]AuthKey, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	parsed := make([]AuthKey, 0, len(keys))
+	for _, s := range keys {
+		idStr, key, ok := strings.Cut(s, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: authentication key must be ID:KEY", ErrInvalidConfig)
+		}
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid authentication key ID %q", ErrInvalidConfig, idStr)
+		}
+		if len(key) < MinAuthKeyLen {
+			return nil, fmt.Errorf("%w: authentication key %d is shorter than %d bytes",
+				ErrInvalidConfig, id, MinAuthKeyLen)
+		}
+		if slices.ContainsFunc(parsed, func(k AuthKey) bool { return k.ID == uint32(id) }) {
+			return nil, fmt.Errorf("%w: duplicate authentication key ID %d", ErrInvalidConfig, id)
+		}
+		parsed = append(parsed, AuthKey{ID: uint32(id), Key: []byte(key)})
+	}
+	return parsed, nil
+}
+
+// splitAuthTrailer separates the authentication trailer from a message, if
+// it ends with one. A trailer is only recognized after a message of a valid
+// length, so the addresses of a message never pass for one.
+func splitAuthTrailer(data []byte) (msg, trailer []byte) {
+	n := len(data) - authTrailerLen
+	if n < 8 || !bytes.Equal(data[n:n+len(authMagic)], authMagic[:]) || !validMessageLen(data[0]>>4, int(data[3]), n) {
+		return data, nil
+	}
+	return data[:n], data[n:]
+}
+
+// validMessageLen reports whether n is a possible length of a message of
+// version with count addresses
+func validMessageLen(version uint8, count, n int) bool {
+	switch version {
+	case VRRPv2:
+		return n == 8+count*net.IPv4len || n == 8+count*net.IPv4len+authDataLen
+	case VRRPv3:
+		return n == 8+count*net.IPv4len || n == 8+count*net.IPv6len
+	}
+	return false
+}
+
+func decodeAuthTrailer(trailer []byte) *AuthTrailer {
+	return &AuthTrailer{
+		KeyID:     binary.BigEndian.Uint32(trailer[4:8]),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(trailer[8:16]))),
+		MAC:       slices.Clone(trailer[authSignedLen:]),
+	}
+}
+
+// advertAuth signs and verifies advertisements with the keys of
+// Config.AuthKeys. The first key signs; any of them verifies, so a new key
+// can be added everywhere before routers start signing with it.
+type advertAuth struct {
+	keys []AuthKey
+
+	// mu guards the HMAC states, one per key, which the send loop and the
+	// receive path share
+	mu   sync.Mutex
+	macs []hash.Hash
+}
+
+// newAdvertAuth returns nil without keys
+func newAdvertAuth(keys []AuthKey) *advertAuth {
+	if len(keys) == 0 {
+		return nil
+	}
+	a := &advertAuth{keys: keys, macs: make([]hash.Hash, len(keys))}
+	for i, k := range keys {
+		a.macs[i] = hmac.New(sha256.New, k.Key)
+	}
+	return a
+}
+
+// keyIDs returns the IDs of the keys, for logging: never the keys
+func (a *advertAuth) keyIDs() []uint32 {
+	if a == nil {
+		return nil
+	}
+	ids := make([]uint32, len(a.keys))
+	for i, k := range a.keys {
+		ids[i] = k.ID
+	}
+	return ids
+}
+
+// sign fills in trailer, authTrailerLen bytes following msg, for an
+// advertisement sent from src at now
+func (a *advertAuth) sign(trailer []byte, src net.IP, msg []byte, now time.Time) {
+	copy(trailer, authMagic[:])
+	binary.BigEndian.PutUint32(trailer[4:8], a.keys[0].ID)
+	binary.BigEndian.PutUint64(trailer[8:16], uint64(now.UnixNano()))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	mac := a.macs[0]
+	writeAuthInput(mac, src, msg, trailer[:authSignedLen])
+	mac.Sum(trailer[authSignedLen:authSignedLen])
+}
+
+// verify reports whether trailer, following msg from src, was signed with
+// one of the keys
+func (a *advertAuth) verify(src net.IP, msg, trailer []byte) bool {
+	id := binary.BigEndian.Uint32(trailer[4:8])
+	i := slices.IndexFunc(a.keys, func(k AuthKey) bool { return k.ID == id })
+	if i < 0 {
+		return false
+	}
+
+	var sum [sha256.Size]byte
+	a.mu.Lock()
+	mac := a.macs[i]
+	writeAuthInput(mac, src, msg, trailer[:authSignedLen])
+	mac.Sum(sum[:0])
+	a.mu.Unlock()
+	return hmac.Equal(sum[:], trailer[authSignedLen:])
+}
+
+func writeAuthInput(mac hash.Hash, src net.IP, msg, signed []byte) {
+	mac.Reset()
+	if v4 := src.To4(); v4 != nil {
+		mac.Write(v4)
+	} else {
+		mac.Write(src)
+	}
+	mac.Write(msg)
+	mac.Write(signed)
+}
+
+// SetAuthKeys replaces Config.AuthKeys, taking effect with the next
+// advertisement sent and received. Empty turns authentication off.
+func (vr *VirtualRouter) SetAuthKeys(keys []string) error {
+	parsed, err := ParseAuthKeys(keys)
+	if err != nil {
+		return err
+	}
+	vr.auth.Store(newAdvertAuth(parsed))
+	return nil
+}
+
+// AuthKeyIDs returns the IDs of the keys advertisements are authenticated
+// with, the first signing, or nil if they are not
+func (vr *VirtualRouter) AuthKeyIDs() []uint32 {
+	return vr.auth.Load().keyIDs()
+}
+
+// authentic reports whether an advertisement for the router is accepted:
+// any is without Config.AuthKeys, else only one with a valid trailer
+func (vr *VirtualRouter) authentic(src net.IP, payload []byte, pkt *Packet) bool {
+	a := vr.auth.Load()
+	if a == nil {
+		return true
+	}
+	if pkt.Auth == nil {
+		return false
+	}
+	n := len(payload) - authTrailerLen
+	return a.verify(src, payload[:n], payload[n:])
+}
+
+// replayed checks an authentic advertisement against the replay guard,
+// which it passes if advertisements are not authenticated
+func (vr *VirtualRouter) replayed(src net.IP, pkt *Packet) replayReason {
+	if pkt.Auth == nil || vr.auth.Load() == nil {
+		return replayFresh
+	}
+	return vr.replay.check(peerKey(src), pkt.Auth.Timestamp, time.Now())
+}
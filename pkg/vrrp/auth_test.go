@@ -0,0 +1,248 @@
+package vrrp
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	testKey1 = "1:0123456789abcdef"
+	testKey2 = "2:fedcba9876543210"
+)
+
+func TestParseAuthKeys(t *testing.T) {
+	keys, err := ParseAuthKeys([]string{testKey2, testKey1})
+	if err != nil {
+		t.Fatalf("ParseAuthKeys: %v", err)
+	}
+	if got := formatKeyIDs(newAdvertAuth(keys).keyIDs()); got != "[2, 1]" {
+		t.Errorf("key IDs = %s, want [2, 1]", got)
+	}
+	if keys, err := ParseAuthKeys(nil); keys != nil || err != nil {
+		t.Errorf("ParseAuthKeys(nil) = %v, %v, want no authentication", keys, err)
+	}
+	for _, bad := range [][]string{
+		{"0123456789abcdef"},
+		{"x:0123456789abcdef"},
+		{"1:short"},
+		{testKey1, "1:another key of ours"},
+	} {
+		if _, err := ParseAuthKeys(bad); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseAuthKeys(%q) = %v, want ErrInvalidConfig", bad, err)
+		}
+	}
+}
+
+// signAdvert appends a trailer signed with keys to msg, sent from src at
+func signAdvert(t *testing.T, msg []byte, src net.IP, at time.Time, keys ...string) []byte {
+	t.Helper()
+
+	parsed, err := ParseAuthKeys(keys)
+	if err != nil {
+		t.Fatalf("ParseAuthKeys: %v", err)
+	}
+	data := append(slices.Clip(msg), make([]byte, authTrailerLen)...)
+	newAdvertAuth(parsed).sign(data[len(msg):], src, msg, at)
+	return data
+}
+
+func TestUnmarshalAuthTrailer(t *testing.T) {
+	src := net.ParseIP("10.0.0.2")
+	at := time.Unix(1700000000, 0)
+	for _, pkt := range []*Packet{
+		NewPacket(VRRPv2, 10, 100, []net.IP{net.ParseIP("192.168.1.100").To4()}),
+		NewPacket(VRRPv3, 10, 100, []net.IP{net.ParseIP("192.168.1.100").To4()}),
+		NewPacket(VRRPv3, 10, 100, []net.IP{net.ParseIP("2001:db8::1")}),
+	} {
+		msg, err := pkt.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		data := signAdvert(t, msg, src, at, testKey1)
+
+		var got Packet
+		if err := got.Unmarshal(data); err != nil {
+			t.Fatalf("Unmarshal v%d: %v", pkt.Version, err)
+		}
+		if got.Auth == nil || got.Auth.KeyID != 1 || !got.Auth.Timestamp.Equal(at) {
+			t.Errorf("v%d Auth = %+v", pkt.Version, got.Auth)
+		}
+		if len(got.IPAddresses) != 1 || !got.IPAddresses[0].Equal(pkt.IPAddresses[0]) {
+			t.Errorf("v%d IPAddresses = %v", pkt.Version, got.IPAddresses)
+		}
+		if valid, ok := got.VerifyChecksum(data); ok && !valid {
+			t.Errorf("v%d checksum does not verify with a trailer", pkt.Version)
+		}
+	}
+
+	// Without a trailer Auth stays nil
+	var got Packet
+	if err := got.Unmarshal(marshalAdvert(t, 10, 100)); err != nil || got.Auth != nil {
+		t.Errorf("Unmarshal = %v, Auth %+v, want no trailer", err, got.Auth)
+	}
+}
+
+func TestAdvertAuthVerify(t *testing.T) {
+	src := net.ParseIP("10.0.0.2")
+	at := time.Unix(1700000000, 0)
+	msg := marshalAdvert(t, 10, 100)
+	keys, _ := ParseAuthKeys([]string{testKey1, testKey2})
+	a := newAdvertAuth(keys)
+
+	verify := func(data []byte, from net.IP) bool {
+		n := len(data) - authTrailerLen
+		return a.verify(from, data[:n], data[n:])
+	}
+
+	if !verify(signAdvert(t, msg, src, at, testKey1), src) {
+		t.Error("advertisement signed with the first key does not verify")
+	}
+	// Any configured key verifies, for rolling keys over
+	if !verify(signAdvert(t, msg, src, at, testKey2), src) {
+		t.Error("advertisement signed with the second key does not verify")
+	}
+	if verify(signAdvert(t, msg, src, at, "3:0123456789abcdef"), src) {
+		t.Error("advertisement signed with an unknown key ID verifies")
+	}
+	if verify(signAdvert(t, msg, src, at, "1:not the right key"), src) {
+		t.Error("advertisement signed with the wrong key verifies")
+	}
+	if verify(signAdvert(t, msg, src, at, testKey1), net.ParseIP("10.0.0.3")) {
+		t.Error("advertisement verifies from another source")
+	}
+
+	tampered := signAdvert(t, msg, src, at, testKey1)
+	tampered[2] = 255 // priority
+	if verify(tampered, src) {
+		t.Error("advertisement verifies after its priority was changed")
+	}
+	tampered = signAdvert(t, msg, src, at, testKey1)
+	tampered[len(msg)+15]++ // timestamp
+	if verify(tampered, src) {
+		t.Error("advertisement verifies after its timestamp was changed")
+	}
+}
+
+func TestAuthenticatedAdverts(t *testing.T) {
+	vr := newTestRouter(t)
+	if err := vr.SetAuthKeys([]string{testKey1}); err != nil {
+		t.Fatalf("SetAuthKeys: %v", err)
+	}
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := net.ParseIP("10.0.0.2")
+	msg := marshalAdvert(t, 10, 255)
+	at := time.Now()
+	for _, data := range [][]byte{
+		signAdvert(t, msg, peer, at, testKey1),
+		msg,
+		signAdvert(t, msg, peer, at, "1:not the right key"),
+		signAdvert(t, msg, net.ParseIP("10.0.0.3"), at, testKey1),
+	} {
+		vr.handleAdvert(&ipv4.Header{Src: peer, TTL: 255}, data, ownIP)
+	}
+
+	s := vr.GetStats()
+	if s.AdvertsReceived != 1 || s.Drops[DropAuth] != 3 {
+		t.Errorf("received %d and dropped %d as unauthenticated, want 1 and 3",
+			s.AdvertsReceived, s.Drops[DropAuth])
+	}
+	if got := vr.AuthKeyIDs(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("AuthKeyIDs = %v, want [1]", got)
+	}
+
+	// Without keys the trailer is ignored
+	if err := vr.SetAuthKeys(nil); err != nil {
+		t.Fatalf("SetAuthKeys(nil): %v", err)
+	}
+	vr.handleAdvert(&ipv4.Header{Src: peer, TTL: 255}, signAdvert(t, msg, peer, at, "1:not the right key"), ownIP)
+	if got := vr.GetStats().AdvertsReceived; got != 2 {
+		t.Errorf("AdvertsReceived = %d after turning authentication off, want 2", got)
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	g := newReplayGuard(0)
+	if g.window != DefaultAuthReplayWindow {
+		t.Errorf("window = %v, want the default", g.window)
+	}
+	now := time.Unix(1700000000, 0)
+	a, b := netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.3")
+
+	for i, tc := range []struct {
+		src   netip.Addr
+		stamp time.Time
+		want  replayReason
+	}{
+		{a, now.Add(-time.Second), replayFresh},
+		{a, now, replayFresh},
+		{a, now, replayRepeated},
+		{a, now.Add(-time.Second), replayRepeated},
+		// Timestamps are tracked per source
+		{b, now.Add(-time.Second), replayFresh},
+		{b, now.Add(-time.Minute), replayStale},
+		{b, now.Add(time.Minute), replayStale},
+		{a, now.Add(time.Millisecond), replayFresh},
+	} {
+		if got := g.check(tc.src, tc.stamp, now); got != tc.want {
+			t.Errorf("check %d from %s at %v = %q, want %q", i, tc.src, tc.stamp.Sub(now), got, tc.want)
+		}
+	}
+
+	// Without a window only the order counts
+	g = newReplayGuard(-1)
+	if got := g.check(a, now.Add(-time.Hour), now); got != replayFresh {
+		t.Errorf("check an hour old without a window = %q, want fresh", got)
+	}
+	if got := g.check(a, now.Add(-2*time.Hour), now); got != replayRepeated {
+		t.Errorf("check an older one without a window = %q, want repeated", got)
+	}
+}
+
+func TestReplayGuardForgets(t *testing.T) {
+	g := newReplayGuard(time.Second)
+	now := time.Unix(1700000000, 0)
+	for i := range MaxPeers {
+		g.check(netip.AddrFrom4([4]byte{10, 0, 1, byte(i)}), now, now)
+	}
+	// A full table makes room by forgetting the sources the window covers
+	later := now.Add(2 * time.Second)
+	src := netip.MustParseAddr("10.0.0.2")
+	g.check(src, later, later)
+	if _, ok := g.last[src]; !ok || len(g.last) != 1 {
+		t.Errorf("table holds %d sources, want only the new one", len(g.last))
+	}
+}
+
+func TestReplayedAdverts(t *testing.T) {
+	vr := newTestRouter(t)
+	if err := vr.SetAuthKeys([]string{testKey1}); err != nil {
+		t.Fatalf("SetAuthKeys: %v", err)
+	}
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := net.ParseIP("10.0.0.2")
+	msg := marshalAdvert(t, 10, 255)
+	now := time.Now()
+	first := signAdvert(t, msg, peer, now.Add(-time.Second), testKey1)
+	for _, data := range [][]byte{
+		first,
+		signAdvert(t, msg, peer, now, testKey1),
+		first,
+		signAdvert(t, msg, peer, now.Add(-time.Hour), testKey1),
+	} {
+		vr.handleAdvert(&ipv4.Header{Src: peer, TTL: 255}, data, ownIP)
+	}
+
+	s := vr.GetStats()
+	if s.AdvertsReceived != 2 || s.Drops[DropReplay] != 2 || s.Drops[DropAuth] != 0 {
+		t.Errorf("received %d, dropped %d as replays and %d as unauthenticated; want 2, 2 and 0",
+			s.AdvertsReceived, s.Drops[DropReplay], s.Drops[DropAuth])
+	}
+}
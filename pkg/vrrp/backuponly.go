@@ -0,0 +1,43 @@
+package vrrp
+
+// SetBackupOnly changes Config.BackupOnly. Turned on, a MASTER advertises
+// priority 0 and steps down at once; turned off, the router takes part in
+// the election again with the next advertisement or master down timer.
+func (vr *VirtualRouter) SetBackupOnly(backupOnly bool) {
+	vr.mu.Lock()
+	vr.backupOnly = backupOnly
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if sm != nil {
+		sm.SetBackupOnly(backupOnly)
+	}
+
+	vr.logger.Info("Backup-only changed", "backup_only", backupOnly)
+}
+
+// BackupOnly reports whether the router is kept from becoming MASTER
+func (vr *VirtualRouter) BackupOnly() bool {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	return vr.backupOnly
+}
+
+// SetMasterLostCallback registers fn to be called when a backup-only router
+// finds no master where it would have taken over: the master went silent,
+// left, or none was heard since the router started. It is called once until
+// a master is heard again. It must be called before Start. fn runs on the
+// state machine's goroutine and must return quickly.
+func (vr *VirtualRouter) SetMasterLostCallback(fn func()) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.onMasterLostCb = fn
+}
+
+// onMasterLost is the state machine's callback for a backup-only router
+// without a master
+func (vr *VirtualRouter) onMasterLost() {
+	if vr.onMasterLostCb != nil {
+		vr.onMasterLostCb()
+	}
+}
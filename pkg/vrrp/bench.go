@@ -0,0 +1,181 @@
+package vrrp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BenchResult is how fast an operation of a Bench function ran
+type BenchResult struct {
+	Ops     int
+	Elapsed time.Duration
+}
+
+// PerOp is the mean time of one operation
+func (r BenchResult) PerOp() time.Duration {
+	if r.Ops == 0 {
+		return 0
+	}
+	return r.Elapsed / time.Duration(r.Ops)
+}
+
+// PerSecond is the number of operations per second
+func (r BenchResult) PerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// benchSink keeps the compiler from optimizing the benchmarked work away
+var benchSink any
+
+// benchLoop runs op in growing batches until d has passed, reading the
+// clock once per batch
+func benchLoop(d time.Duration, op func()) BenchResult {
+	var r BenchResult
+	start := time.Now()
+	for batch := 1; r.Elapsed < d; batch = min(batch*2, 1<<20) {
+		for range batch {
+			op()
+		}
+		r.Ops += batch
+		r.Elapsed = time.Since(start)
+	}
+	return r
+}
+
+// selfBenchPacket is the advertisement the daemon sends for vips addresses
+func selfBenchPacket(vips int) (*Packet, []byte, error) {
+	if vips < 1 || vips > MaxVirtualIPs {
+		return nil, nil, fmt.Errorf("%w: %d virtual IPs, want 1-%d", ErrInvalidConfig, vips, MaxVirtualIPs)
+	}
+	ips := make([]net.IP, vips)
+	for i := range ips {
+		ips[i] = net.IPv4(10, 0, byte(i>>8), byte(i)).To4()
+	}
+	pkt := NewPacket(VRRPv2, 1, 100, ips)
+	data, err := pkt.Marshal()
+	return pkt, data, err
+}
+
+// BenchMarshal measures marshaling an advertisement of vips addresses,
+// checksum included, for d
+func BenchMarshal(d time.Duration, vips int) (BenchResult, error) {
+	pkt, _, err := selfBenchPacket(vips)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	return benchLoop(d, func() {
+		benchSink, _ = pkt.Marshal()
+	}), nil
+}
+
+// BenchUnmarshal measures decoding an advertisement of vips addresses and
+// verifying its checksum, as the receive path does, for d
+func BenchUnmarshal(d time.Duration, vips int) (BenchResult, error) {
+	_, data, err := selfBenchPacket(vips)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	var pkt Packet
+	return benchLoop(d, func() {
+		_ = pkt.Unmarshal(data)
+		benchSink, _ = pkt.VerifyChecksum(data)
+	}), nil
+}
+
+// BenchChecksum measures the checksum alone of an advertisement of vips
+// addresses for d
+func BenchChecksum(d time.Duration, vips int) (BenchResult, error) {
+	pkt, data, err := selfBenchPacket(vips)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	return benchLoop(d, func() {
+		benchSink = pkt.calculateChecksum(data)
+	}), nil
+}
+
+// TimerBenchResult is how late the timers of BenchTimers expired
+type TimerBenchResult struct {
+	Timers int
+	Period time.Duration
+	// Expirations is the number received, Missed the number of ticks
+	// dropped because the receiver was a period or more late
+	Expirations int
+	Missed      int
+	// P50, P99 and Max are percentiles of the time from when each
+	// expiration was due to when its receiver got it
+	P50, P99, Max time.Duration
+}
+
+// BenchTimers runs timers periodic timers on the scheduler a Manager's state
+// machines share, all started at once like the advertisement timers of a
+// daemon starting, each received by a goroutine of its own, until d has
+// passed or ctx is canceled
+func BenchTimers(ctx context.Context, timers int, period, d time.Duration) (TimerBenchResult, error) {
+	r := TimerBenchResult{Timers: timers, Period: period}
+	if timers < 1 || period <= 0 {
+		return r, fmt.Errorf("%w: %d timers every %s", ErrInvalidConfig, timers, period)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	s := newScheduler()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		lateness []time.Duration
+	)
+	for range timers {
+		start := time.Now()
+		t := s.every(period)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer t.Stop()
+			due := start.Add(period)
+			var late []time.Duration
+			missed := 0
+			for {
+				select {
+				case <-ctx.Done():
+					mu.Lock()
+					lateness = append(lateness, late...)
+					r.Missed += missed
+					mu.Unlock()
+					return
+				case <-t.Chan():
+				}
+				now := time.Now()
+				// The scheduler skips the ticks its receiver is too slow for
+				for now.Sub(due) >= period {
+					due = due.Add(period)
+					missed++
+				}
+				late = append(late, max(now.Sub(due), 0))
+				due = due.Add(period)
+			}
+		}()
+	}
+	wg.Wait()
+	s.mu.Lock()
+	if s.wake != nil {
+		s.wake.Stop()
+	}
+	s.mu.Unlock()
+
+	r.Expirations = len(lateness)
+	if len(lateness) > 0 {
+		slices.Sort(lateness)
+		r.P50 = lateness[len(lateness)/2]
+		r.P99 = lateness[len(lateness)*99/100]
+		r.Max = lateness[len(lateness)-1]
+	}
+	return r, nil
+}
@@ -0,0 +1,43 @@
+package vrrp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBenchPackets(t *testing.T) {
+	for name, bench := range map[string]func(time.Duration, int) (BenchResult, error){
+		"marshal":   BenchMarshal,
+		"unmarshal": BenchUnmarshal,
+		"checksum":  BenchChecksum,
+	} {
+		r, err := bench(10*time.Millisecond, 4)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if r.Ops == 0 || r.Elapsed < 10*time.Millisecond || r.PerOp() <= 0 || r.PerSecond() <= 0 {
+			t.Errorf("%s: %+v", name, r)
+		}
+		if _, err := bench(time.Millisecond, 0); err == nil {
+			t.Errorf("%s without virtual IPs succeeded", name)
+		}
+	}
+}
+
+func TestBenchTimers(t *testing.T) {
+	r, err := BenchTimers(context.Background(), 20, 10*time.Millisecond, 105*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// About 10 expirations each; allow for a slow machine
+	if r.Expirations < 20*5 || r.Expirations > 20*11 {
+		t.Errorf("%d expirations of 20 timers every 10ms in 105ms", r.Expirations)
+	}
+	if r.P50 > r.P99 || r.P99 > r.Max {
+		t.Errorf("percentiles out of order: %+v", r)
+	}
+	if _, err := BenchTimers(context.Background(), 0, time.Second, time.Second); err == nil {
+		t.Error("no timers succeeded")
+	}
+}
@@ -0,0 +1,15 @@
+package vrrp
+
+import (
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// PacketCapture receives copies of the VRRP messages a router sends and
+// receives (Config.Capture). It is called from the router's send and receive
+// goroutines with messages before any validation; header and payload are only
+// valid during the call. pcap.Writer.WriteIPv4 writes them to a pcap file.
+type PacketCapture interface {
+	CapturePacket(t time.Time, header *ipv4.Header, payload []byte)
+}
@@ -0,0 +1,235 @@
+package vrrp
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Chaos configures faults injected into the messages a router receives, to
+// test how elections hold up on a lossy network (Config.Chaos). The zero
+// value injects none.
+type Chaos struct {
+	// Drop is the probability that a message is lost
+	Drop float64
+	// Delay is added to every message, and up to Jitter more at random.
+	// Jitter alone reorders messages that arrive close together.
+	Delay  time.Duration
+	Jitter time.Duration
+	// Duplicate is the probability that a message is delivered twice
+	Duplicate float64
+	// Reorder is the probability that a message is held back and delivered
+	// after the next one
+	Reorder float64
+	// Corrupt is the probability that one byte of a message is changed,
+	// which the checksum should catch
+	Corrupt float64
+	// Seed makes the faults reproducible; 0 picks one at random
+	Seed uint64
+}
+
+// chaosKeys are the keys of the ParseChaos syntax, in the order String
+// writes them
+var chaosKeys = []string{"drop", "delay", "jitter", "duplicate", "reorder", "corrupt", "seed"}
+
+// ParseChaos parses a comma-separated list of faults, e.g.
+// "drop=0.1,delay=20ms,jitter=10ms,duplicate=0.05,reorder=0.05,corrupt=0.01,seed=42".
+// Probabilities are between 0 and 1. An empty string is no faults.
+func ParseChaos(s string) (Chaos, error) {
+	var c Chaos
+	if strings.TrimSpace(s) == "" {
+		return c, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return Chaos{}, fmt.Errorf("%w: chaos %q: want key=value", ErrInvalidConfig, field)
+		}
+		var err error
+		switch key {
+		case "drop":
+			c.Drop, err = strconv.ParseFloat(value, 64)
+		case "delay":
+			c.Delay, err = time.ParseDuration(value)
+		case "jitter":
+			c.Jitter, err = time.ParseDuration(value)
+		case "duplicate":
+			c.Duplicate, err = strconv.ParseFloat(value, 64)
+		case "reorder":
+			c.Reorder, err = strconv.ParseFloat(value, 64)
+		case "corrupt":
+			c.Corrupt, err = strconv.ParseFloat(value, 64)
+		case "seed":
+			c.Seed, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return Chaos{}, fmt.Errorf("%w: unknown chaos %q, want one of %s",
+				ErrInvalidConfig, key, strings.Join(chaosKeys, ", "))
+		}
+		if err != nil {
+			return Chaos{}, fmt.Errorf("%w: chaos %s: %w", ErrInvalidConfig, key, err)
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return Chaos{}, err
+	}
+	return c, nil
+}
+
+// Validate checks that the probabilities are between 0 and 1 and the delays
+// are not negative
+func (c Chaos) Validate() error {
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"drop", c.Drop}, {"duplicate", c.Duplicate}, {"reorder", c.Reorder}, {"corrupt", c.Corrupt}} {
+		if p.value < 0 || p.value > 1 {
+			return fmt.Errorf("%w: chaos %s %v must be between 0 and 1", ErrInvalidConfig, p.name, p.value)
+		}
+	}
+	if c.Delay < 0 || c.Jitter < 0 {
+		return fmt.Errorf("%w: chaos delay and jitter must not be negative", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Enabled reports whether any fault is configured
+func (c Chaos) Enabled() bool {
+	return c.Drop > 0 || c.Delay > 0 || c.Jitter > 0 || c.Duplicate > 0 || c.Reorder > 0 || c.Corrupt > 0
+}
+
+// String returns the faults in the syntax of ParseChaos
+func (c Chaos) String() string {
+	var fields []string
+	add := func(key string, set bool, value string) {
+		if set {
+			fields = append(fields, key+"="+value)
+		}
+	}
+	add("drop", c.Drop > 0, strconv.FormatFloat(c.Drop, 'g', -1, 64))
+	add("delay", c.Delay > 0, c.Delay.String())
+	add("jitter", c.Jitter > 0, c.Jitter.String())
+	add("duplicate", c.Duplicate > 0, strconv.FormatFloat(c.Duplicate, 'g', -1, 64))
+	add("reorder", c.Reorder > 0, strconv.FormatFloat(c.Reorder, 'g', -1, 64))
+	add("corrupt", c.Corrupt > 0, strconv.FormatFloat(c.Corrupt, 'g', -1, 64))
+	add("seed", c.Seed != 0, strconv.FormatUint(c.Seed, 10))
+	return strings.Join(fields, ",")
+}
+
+// message is a received message held by a FaultInjector
+type message struct {
+	header  *ipv4.Header
+	payload []byte
+}
+
+// FaultInjector applies Chaos to a stream of received messages before
+// passing them on. Messages are delivered one at a time, in the order their
+// delays end; without Delay or Jitter they are delivered before Inject
+// returns.
+type FaultInjector struct {
+	chaos   Chaos
+	deliver func(header *ipv4.Header, payload []byte)
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	held    *message
+	stopped bool
+
+	// deliverMu serializes deliveries, and Stop waits on it for the one in
+	// progress
+	deliverMu sync.Mutex
+}
+
+// NewFaultInjector returns an injector that passes the messages surviving c
+// to deliver
+func NewFaultInjector(c Chaos, deliver func(header *ipv4.Header, payload []byte)) *FaultInjector {
+	seed := c.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &FaultInjector{
+		chaos:   c,
+		deliver: deliver,
+		rnd:     rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// Inject applies the faults to a message. header and payload are copied, so
+// the caller may reuse them.
+func (f *FaultInjector) Inject(header *ipv4.Header, payload []byte) {
+	h := *header
+	msg := &message{header: &h, payload: slices.Clone(payload)}
+
+	f.mu.Lock()
+	if f.stopped || f.chance(f.chaos.Drop) {
+		f.mu.Unlock()
+		return
+	}
+	if len(msg.payload) > 0 && f.chance(f.chaos.Corrupt) {
+		// XOR with a nonzero value, so the byte always changes
+		msg.payload[f.rnd.IntN(len(msg.payload))] ^= byte(1 + f.rnd.IntN(255))
+	}
+	if f.held == nil && f.chance(f.chaos.Reorder) {
+		f.held = msg
+		f.mu.Unlock()
+		return
+	}
+
+	batch := []*message{msg}
+	if f.chance(f.chaos.Duplicate) {
+		batch = append(batch, msg)
+	}
+	if f.held != nil {
+		batch = append(batch, f.held)
+		f.held = nil
+	}
+	delay := f.chaos.Delay
+	if f.chaos.Jitter > 0 {
+		delay += time.Duration(f.rnd.Int64N(int64(f.chaos.Jitter) + 1))
+	}
+	f.mu.Unlock()
+
+	if delay == 0 {
+		f.flush(batch)
+		return
+	}
+	time.AfterFunc(delay, func() { f.flush(batch) })
+}
+
+// chance returns true with probability p. f.mu must be held.
+func (f *FaultInjector) chance(p float64) bool {
+	return p > 0 && f.rnd.Float64() < p
+}
+
+func (f *FaultInjector) flush(batch []*message) {
+	f.deliverMu.Lock()
+	defer f.deliverMu.Unlock()
+
+	f.mu.Lock()
+	stopped := f.stopped
+	f.mu.Unlock()
+	if stopped {
+		return
+	}
+	for _, msg := range batch {
+		f.deliver(msg.header, msg.payload)
+	}
+}
+
+// Stop discards the messages still delayed or held back. Once it returns,
+// deliver is not called again.
+func (f *FaultInjector) Stop() {
+	f.mu.Lock()
+	f.stopped = true
+	f.held = nil
+	f.mu.Unlock()
+
+	// Wait for a delivery in progress
+	f.deliverMu.Lock()
+	defer f.deliverMu.Unlock()
+}
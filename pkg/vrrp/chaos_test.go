@@ -0,0 +1,140 @@
+package vrrp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestParseChaos(t *testing.T) {
+	s := "drop=0.1,delay=20ms,jitter=10ms,duplicate=0.05,reorder=0.05,corrupt=0.01,seed=42"
+	c, err := ParseChaos(s)
+	if err != nil {
+		t.Fatalf("ParseChaos: %v", err)
+	}
+	want := Chaos{
+		Drop: 0.1, Delay: 20 * time.Millisecond, Jitter: 10 * time.Millisecond,
+		Duplicate: 0.05, Reorder: 0.05, Corrupt: 0.01, Seed: 42,
+	}
+	if c != want {
+		t.Errorf("ParseChaos = %+v, want %+v", c, want)
+	}
+	if got := c.String(); got != s {
+		t.Errorf("String = %q, want %q", got, s)
+	}
+
+	if c, err := ParseChaos(""); err != nil || c.Enabled() {
+		t.Errorf("ParseChaos(\"\") = %+v, %v, want no faults", c, err)
+	}
+
+	for _, bad := range []string{"drop", "drop=x", "drop=1.5", "delay=-1s", "lose=0.1"} {
+		if _, err := ParseChaos(bad); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseChaos(%q) = %v, want ErrInvalidConfig", bad, err)
+		}
+	}
+}
+
+// recorder collects what a FaultInjector delivers
+type recorder struct {
+	mu   sync.Mutex
+	msgs [][]byte
+}
+
+func (r *recorder) deliver(_ *ipv4.Header, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, payload)
+}
+
+func (r *recorder) got() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.msgs
+}
+
+func inject(f *FaultInjector, payloads ...byte) {
+	header := &ipv4.Header{TTL: 255, Src: net.ParseIP("10.0.0.1")}
+	for _, p := range payloads {
+		f.Inject(header, []byte{p})
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	tests := []struct {
+		name  string
+		chaos Chaos
+		want  []byte
+	}{
+		{"none", Chaos{}, []byte{1, 2, 3}},
+		{"drop", Chaos{Drop: 1}, nil},
+		{"duplicate", Chaos{Duplicate: 1}, []byte{1, 1, 2, 2, 3, 3}},
+		// Each message is held back behind the next; the last stays held
+		{"reorder", Chaos{Reorder: 1}, []byte{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r recorder
+			inject(NewFaultInjector(tt.chaos, r.deliver), 1, 2, 3)
+			if got := bytes.Join(r.got(), nil); !bytes.Equal(got, tt.want) {
+				t.Errorf("delivered %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFaultInjectorCorrupt(t *testing.T) {
+	var r recorder
+	f := NewFaultInjector(Chaos{Corrupt: 1}, r.deliver)
+	payload := []byte{1, 2, 3, 4}
+	f.Inject(&ipv4.Header{}, payload)
+
+	got := r.got()
+	if len(got) != 1 || bytes.Equal(got[0], []byte{1, 2, 3, 4}) {
+		t.Errorf("delivered %v, want one corrupted message", got)
+	}
+	if !bytes.Equal(payload, []byte{1, 2, 3, 4}) {
+		t.Errorf("the caller's payload was changed to %v", payload)
+	}
+}
+
+func TestFaultInjectorDelay(t *testing.T) {
+	var r recorder
+	f := NewFaultInjector(Chaos{Delay: 50 * time.Millisecond}, r.deliver)
+	inject(f, 1)
+	if got := r.got(); len(got) != 0 {
+		t.Fatalf("delivered %v before the delay", got)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(r.got()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := r.got(); len(got) != 1 {
+		t.Fatalf("delivered %v after the delay, want one message", got)
+	}
+
+	// Stop discards what is still delayed
+	inject(f, 2)
+	f.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if got := r.got(); len(got) != 1 {
+		t.Errorf("delivered %v after Stop", got)
+	}
+}
+
+func TestVirtualRouterChaosValidation(t *testing.T) {
+	_, err := NewVirtualRouter(&Config{
+		VRID:       1,
+		Interface:  "lo",
+		VirtualIPs: []string{"192.0.2.1"},
+		Chaos:      Chaos{Drop: 2},
+	})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewVirtualRouter = %v, want ErrInvalidConfig", err)
+	}
+}
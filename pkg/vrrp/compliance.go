@@ -0,0 +1,128 @@
+package vrrp
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/net/ipv4"
+)
+
+// NoteLevel is how far an advertisement departs from its RFC
+type NoteLevel string
+
+const (
+	// NoteError is a violation that makes compliant routers discard the
+	// advertisement
+	NoteError NoteLevel = "error"
+	// NoteWarning is a departure routers tolerate but that breaks some
+	// implementations or hints at a misconfiguration
+	NoteWarning NoteLevel = "warning"
+	// NoteInfo is worth knowing but compliant
+	NoteInfo NoteLevel = "info"
+)
+
+// Note is a remark of CheckCompliance
+type Note struct {
+	Level NoteLevel
+	Text  string
+}
+
+// CheckCompliance compares an advertisement with RFC 3768 (VRRPv2) or RFC
+// 5798 (VRRPv3), for comparing captures of other implementations. data is
+// the message p was unmarshaled from; header is the IPv4 header it came
+// with, nil if unknown, in which case the checks of the IP fields and of
+// VRRPv3 checksums are left out.
+func CheckCompliance(header *ipv4.Header, data []byte, p *Packet) []Note {
+	var notes []Note
+	add := func(level NoteLevel, format string, args ...any) {
+		notes = append(notes, Note{Level: level, Text: fmt.Sprintf(format, args...)})
+	}
+
+	if header != nil {
+		if header.TTL != 255 {
+			add(NoteError, "IP TTL is %d: it must be 255, so that only routers on the link are heard", header.TTL)
+		}
+		if header.Protocol != VRRPProtocol {
+			add(NoteError, "IP protocol is %d, not %d", header.Protocol, VRRPProtocol)
+		}
+		if !header.Dst.Equal(multicastGroup) {
+			add(NoteWarning, "sent to %s rather than the group %s: only unicast peers receive it",
+				header.Dst, VRRPMulticastIPv4)
+		}
+	}
+
+	if p.Type != TypeAdvertisement {
+		add(NoteError, "type is %d: advertisements (1) are the only type", p.Type)
+	}
+	if p.VRID == 0 {
+		add(NoteError, "VRID 0 is not valid: VRIDs are 1-255")
+	}
+	if p.AdvInterval == 0 {
+		add(NoteError, "advertisement interval is 0")
+	}
+
+	switch p.Priority {
+	case 0:
+		add(NoteInfo, "priority 0: the master is giving up, backups take over after the skew time")
+	case 255:
+		add(NoteInfo, "priority 255: sent by the owner of the virtual addresses")
+	}
+
+	msg := data
+	if p.Auth != nil {
+		msg, _ = splitAuthTrailer(data)
+	}
+	switch p.Version {
+	case VRRPv2:
+		switch p.AuthType {
+		case 0:
+			if len(p.AuthData) != 0 && !bytes.Equal(p.AuthData, make([]byte, authDataLen)) {
+				add(NoteWarning, "authentication data is not zero without authentication")
+			}
+		case 1, 2:
+			add(NoteWarning, "authentication type %d was removed by RFC 3768: routers without it discard "+
+				"the advertisement", p.AuthType)
+		default:
+			add(NoteError, "unknown authentication type %d", p.AuthType)
+		}
+		if p.AuthData == nil {
+			add(NoteWarning, "the 8 bytes of authentication data are missing, as some stacks send it")
+		}
+		if valid, _ := p.VerifyChecksum(data); !valid {
+			add(NoteError, "bad checksum %#04x, want %#04x", p.Checksum, p.calculateChecksum(msg))
+		}
+	case VRRPv3:
+		if reserved := msg[4] >> 4; reserved != 0 {
+			add(NoteWarning, "reserved bits before the interval are %#x, not 0", reserved)
+		}
+		if len(p.IPAddresses) > 0 && p.IPAddresses[0].To4() == nil && header != nil {
+			add(NoteError, "IPv6 addresses in an IPv4 packet")
+		}
+		switch {
+		case header == nil:
+			add(NoteInfo, "checksum not verified: the VRRPv3 checksum covers the IP pseudo-header")
+		case !p.VerifyChecksumIPv4(data, header.Src, header.Dst):
+			add(NoteError, "bad checksum %#04x, want %#04x", p.Checksum, pseudoChecksum(header.Src, header.Dst, msg))
+		}
+	}
+
+	if len(p.IPAddresses) == 0 {
+		add(NoteWarning, "no virtual addresses: at least one is required")
+	}
+	seen := make(map[string]bool)
+	for _, ip := range p.IPAddresses {
+		if seen[ip.String()] {
+			add(NoteWarning, "virtual address %s is listed twice", ip)
+		}
+		seen[ip.String()] = true
+		if !ip.IsGlobalUnicast() && !ip.IsLinkLocalUnicast() {
+			add(NoteWarning, "virtual address %s is not a unicast address", ip)
+		}
+	}
+
+	if p.Auth != nil {
+		add(NoteInfo, "ends with a vrrp-simple authentication trailer, key %d, signed %s", p.Auth.KeyID,
+			p.Auth.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z"))
+	}
+	return notes
+}
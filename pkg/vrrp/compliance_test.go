@@ -0,0 +1,138 @@
+package vrrp
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+// notesOf maps the text of each note to its level, for failure messages
+func notesOf(notes []Note) map[string]NoteLevel {
+	out := make(map[string]NoteLevel)
+	for _, n := range notes {
+		out[n.Text] = n.Level
+	}
+	return out
+}
+
+func hasNote(notes []Note, level NoteLevel, text string) bool {
+	for _, n := range notes {
+		if n.Level == level && strings.Contains(n.Text, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifyChecksumIPv4(t *testing.T) {
+	// A VRRPv3 advertisement from 10.0.0.1 for VRID 20, priority 255, 1s,
+	// 10.0.0.200, with its checksum computed independently
+	data, _ := hex.DecodeString("3114ff010064da2d0a0000c8")
+	src := net.ParseIP("10.0.0.1")
+	pkt := &Packet{}
+	if err := pkt.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !pkt.VerifyChecksumIPv4(data, src, multicastGroup) {
+		t.Error("valid VRRPv3 checksum rejected")
+	}
+	if pkt.VerifyChecksumIPv4(data, net.ParseIP("10.0.0.2"), multicastGroup) {
+		t.Error("checksum accepted from another source")
+	}
+
+	// marshalV3 computes the same checksum
+	v2 := NewPacket(VRRPv2, 20, 255, []net.IP{net.ParseIP("10.0.0.200")})
+	if got, err := marshalV3(v2, src); err != nil || hex.EncodeToString(got) != hex.EncodeToString(data) {
+		t.Errorf("marshalV3 = %x, %v; want %x", got, err, data)
+	}
+
+	// VRRPv2 checksums do not depend on the addresses
+	v2data, _ := v2.Marshal()
+	if err := pkt.Unmarshal(v2data); err != nil {
+		t.Fatal(err)
+	}
+	if !pkt.VerifyChecksumIPv4(v2data, src, multicastGroup) {
+		t.Error("valid VRRPv2 checksum rejected")
+	}
+}
+
+func TestCheckCompliance(t *testing.T) {
+	header := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TTL:      255,
+		Protocol: VRRPProtocol,
+		Src:      net.ParseIP("10.0.0.1").To4(),
+		Dst:      multicastGroup,
+	}
+	check := func(h *ipv4.Header, pkt *Packet, data []byte) []Note {
+		t.Helper()
+		if data == nil {
+			var err error
+			if data, err = pkt.Marshal(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var decoded Packet
+		if err := decoded.Unmarshal(data); err != nil {
+			t.Fatal(err)
+		}
+		return CheckCompliance(h, data, &decoded)
+	}
+
+	good := NewPacket(VRRPv2, 10, 100, []net.IP{net.ParseIP("10.0.0.100")})
+	good.AuthData = make([]byte, authDataLen)
+	if notes := check(header, good, nil); len(notes) != 0 {
+		t.Errorf("compliant VRRPv2 advertisement has notes %v", notesOf(notes))
+	}
+
+	bad := *header
+	bad.TTL = 64
+	bad.Dst = net.ParseIP("10.0.0.2").To4()
+	notes := check(&bad, good, nil)
+	if !hasNote(notes, NoteError, "TTL is 64") || !hasNote(notes, NoteWarning, "rather than the group") {
+		t.Errorf("IP header notes = %v", notesOf(notes))
+	}
+
+	odd := NewPacket(VRRPv2, 10, 0, []net.IP{net.ParseIP("10.0.0.100"), net.ParseIP("10.0.0.100")})
+	odd.AuthType = 1
+	data, _ := odd.Marshal()
+	data[6] ^= 0xFF
+	notes = check(nil, odd, data)
+	for _, want := range []struct {
+		level NoteLevel
+		text  string
+	}{
+		{NoteWarning, "authentication type 1"},
+		{NoteError, "bad checksum"},
+		{NoteWarning, "listed twice"},
+		{NoteInfo, "priority 0"},
+	} {
+		if !hasNote(notes, want.level, want.text) {
+			t.Errorf("no %s note %q in %v", want.level, want.text, notesOf(notes))
+		}
+	}
+
+	short, _ := good.Marshal()
+	if notes := check(nil, good, short[:len(short)-authDataLen]); !hasNote(notes, NoteWarning,
+		"authentication data are missing") {
+		t.Errorf("VRRPv2 without authentication data notes = %v", notesOf(notes))
+	}
+
+	v3 := NewPacket(VRRPv3, 20, 100, []net.IP{net.ParseIP("10.0.0.200")})
+	v3data, _ := marshalV3(NewPacket(VRRPv2, 20, 100, v3.IPAddresses), header.Src)
+	if notes := check(header, v3, v3data); len(notes) != 0 {
+		t.Errorf("compliant VRRPv3 advertisement has notes %v", notesOf(notes))
+	}
+	if notes := check(nil, v3, v3data); !hasNote(notes, NoteInfo, "checksum not verified") {
+		t.Errorf("VRRPv3 without header notes = %v", notesOf(notes))
+	}
+	v3data[4] |= 0x10
+	if notes := check(header, v3, v3data); !hasNote(notes, NoteWarning, "reserved bits") ||
+		!hasNote(notes, NoteError, "bad checksum") {
+		t.Errorf("VRRPv3 with reserved bits notes = %v", notesOf(notes))
+	}
+}
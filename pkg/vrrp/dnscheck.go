@@ -0,0 +1,161 @@
+package vrrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsCheck asks one resolver for the A or AAAA records of a name. It fails
+// if the query errors or times out, the resolver answers with an error code
+// or no records, or, with expect set, an answer is not in expect.
+type dnsCheck struct {
+	server string
+	query  dnsmessage.Name
+	qtype  dnsmessage.Type
+	expect []netip.Addr
+}
+
+// newDNSCheck builds a DNS check from the keys of a "dns:" tracker spec:
+// query and server (host or host:port, port 53 by default) are required,
+// type is A (the default) or AAAA, and expect, which may repeat, lists the
+// only addresses accepted in answers
+func newDNSCheck(params [][2]string) (*dnsCheck, error) {
+	c := &dnsCheck{qtype: dnsmessage.TypeA}
+	var query string
+	for _, p := range params {
+		switch key, value := p[0], p[1]; key {
+		case "query":
+			query = value
+		case "server":
+			c.server = value
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				c.server = net.JoinHostPort(value, "53")
+			}
+		case "type":
+			switch strings.ToUpper(value) {
+			case "A":
+				c.qtype = dnsmessage.TypeA
+			case "AAAA":
+				c.qtype = dnsmessage.TypeAAAA
+			default:
+				return nil, fmt.Errorf("type %q must be A or AAAA", value)
+			}
+		case "expect":
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("expect: %w", err)
+			}
+			c.expect = append(c.expect, addr)
+		default:
+			return nil, fmt.Errorf("unknown key %q, want query, server, type, expect, "+
+				"name, interval, timeout, fall, rise or weight", key)
+		}
+	}
+	if query == "" || c.server == "" {
+		return nil, errors.New("query and server are required")
+	}
+	var err error
+	if c.query, err = dnsmessage.NewName(strings.TrimSuffix(query, ".") + "."); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	for _, addr := range c.expect {
+		if addr.Is4() != (c.qtype == dnsmessage.TypeA) {
+			return nil, fmt.Errorf("expect %s does not match type %s", addr, c.typeName())
+		}
+	}
+	return c, nil
+}
+
+func (c *dnsCheck) typeName() string {
+	return strings.TrimPrefix(c.qtype.String(), "Type")
+}
+
+// String is "NAME@SERVER", or "NAME/AAAA@SERVER"
+func (c *dnsCheck) String() string {
+	name := strings.TrimSuffix(c.query.String(), ".")
+	if c.qtype != dnsmessage.TypeA {
+		name += "/" + c.typeName()
+	}
+	return name + "@" + c.server
+}
+
+func (c *dnsCheck) check(ctx context.Context) error {
+	answers, err := c.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if len(answers) == 0 {
+		return fmt.Errorf("%s: no records", c)
+	}
+	if len(c.expect) > 0 {
+		for _, addr := range answers {
+			if !slices.Contains(c.expect, addr) {
+				return fmt.Errorf("%s: unexpected answer %s", c, addr)
+			}
+		}
+	}
+	return nil
+}
+
+// resolve sends the query over UDP and returns the addresses answered
+func (c *dnsCheck) resolve(ctx context.Context) ([]netip.Addr, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", c.server)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	id := uint16(rand.Uint32())
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: c.query, Type: c.qtype, Class: dnsmessage.ClassINET}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c, err)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("%s: %w", c, err)
+	}
+
+	buf := make([]byte, 1232)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c, err)
+		}
+		var resp dnsmessage.Message
+		// Anything but the answer to this query is ignored
+		if err := resp.Unpack(buf[:n]); err != nil || !resp.Response || resp.ID != id {
+			continue
+		}
+		if resp.RCode != dnsmessage.RCodeSuccess {
+			return nil, fmt.Errorf("%s: %s", c, strings.TrimPrefix(resp.RCode.String(), "RCode"))
+		}
+		if resp.Truncated {
+			return nil, fmt.Errorf("%s: answer truncated", c)
+		}
+		var addrs []netip.Addr
+		for _, rr := range resp.Answers {
+			switch body := rr.Body.(type) {
+			case *dnsmessage.AResource:
+				addrs = append(addrs, netip.AddrFrom4(body.A))
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, netip.AddrFrom16(body.AAAA))
+			}
+		}
+		return addrs, nil
+	}
+}
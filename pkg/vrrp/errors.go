@@ -0,0 +1,46 @@
+package vrrp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Errors returned by the package, for callers to branch on with errors.Is.
+// Returned errors wrap them with the details.
+var (
+	// ErrInvalidConfig is a Config or setting outside the allowed range
+	ErrInvalidConfig = errors.New("invalid configuration")
+
+	// ErrAlreadyRunning is returned by Start on a running router
+	ErrAlreadyRunning = errors.New("virtual router is already running")
+	// ErrNotRunning is returned by operations that need a running router
+	ErrNotRunning = errors.New("virtual router is not running")
+	// ErrNotMaster is returned by a failover of a router that is not MASTER
+	ErrNotMaster = errors.New("not MASTER")
+
+	// ErrRouterExists is returned by Manager.Add for an interface/VRID pair
+	// that was already added
+	ErrRouterExists = errors.New("virtual router already exists")
+	// ErrRouterNotFound is returned by Manager.Remove for an unknown router
+	ErrRouterNotFound = errors.New("no such virtual router")
+
+	// ErrInterfaceNotFound and ErrNoIPv4Address are returned when the
+	// interface cannot be used for VRRP
+	ErrInterfaceNotFound = errors.New("interface not found")
+	ErrNoIPv4Address     = errors.New("no IPv4 address on interface")
+
+	// ErrPermission is returned when the process lacks the privileges for
+	// the raw socket or for programming addresses. It matches
+	// os.ErrPermission too.
+	ErrPermission = fmt.Errorf("%w: VRRP needs CAP_NET_RAW and CAP_NET_ADMIN", os.ErrPermission)
+)
+
+// wrapPermission marks err with ErrPermission if a syscall behind it was
+// refused for lack of privileges
+func wrapPermission(err error) error {
+	if errors.Is(err, os.ErrPermission) && !errors.Is(err, ErrPermission) {
+		return fmt.Errorf("%w: %w", ErrPermission, err)
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package vrrp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// vridOffset is the position of the VRID in an advertisement, after the
+// version and type byte
+const vridOffset = 1
+
+// vridFilter assembles a socket filter that accepts the messages for vrids
+// and drops the rest. The socket hands the filter the IP header, whose
+// length varies with its options, so the VRID is loaded relative to it.
+// Messages too short to hold a VRID are dropped as well.
+func vridFilter(vrids []uint8) ([]bpf.RawInstruction, error) {
+	vrids = slices.Compact(slices.Sorted(slices.Values(vrids)))
+
+	prog := []bpf.Instruction{
+		// X = IP header length
+		bpf.LoadMemShift{Off: 0},
+		bpf.LoadIndirect{Off: vridOffset, Size: 1},
+	}
+	for i, vrid := range vrids {
+		// Jump past the remaining comparisons and the drop to the accept
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(vrid), SkipTrue: uint8(len(vrids) - i)})
+	}
+	prog = append(prog,
+		bpf.RetConstant{Val: 0},
+		bpf.RetConstant{Val: 0xffff},
+	)
+	return bpf.Assemble(prog)
+}
+
+// SetVRIDFilter attaches a socket filter that drops, in the kernel, every
+// message but the advertisements for vrids, so the process is not woken for
+// the other groups on the link. Dropped messages are not counted anywhere,
+// as VRID mismatches or otherwise. Without vrids the filter is removed.
+func (n *Network) SetVRIDFilter(vrids []uint8) error {
+	if len(vrids) == 0 {
+		var err error
+		cerr := n.raw.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DETACH_FILTER, 0)
+		})
+		// ENOENT: there was no filter
+		if err = errors.Join(cerr, err); err != nil && !errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("failed to detach socket filter: %w", err)
+		}
+		return nil
+	}
+
+	prog, err := vridFilter(vrids)
+	if err != nil {
+		return fmt.Errorf("failed to assemble socket filter: %w", err)
+	}
+	if err := n.conn.SetBPF(prog); err != nil {
+		return fmt.Errorf("failed to attach socket filter: %w", err)
+	}
+	return nil
+}
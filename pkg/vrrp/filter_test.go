@@ -0,0 +1,150 @@
+package vrrp
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/net/ipv4"
+)
+
+// rawMessage is an advertisement for vrid as the socket filter sees it,
+// behind an IP header with optLen bytes of options
+func rawMessage(t *testing.T, vrid uint8, optLen int) []byte {
+	t.Helper()
+	payload, err := NewPacket(VRRPv2, vrid, 100, []net.IP{net.ParseIP("192.0.2.1")}).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	header := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen + optLen,
+		TotalLen: ipv4.HeaderLen + optLen + len(payload),
+		TTL:      255,
+		Protocol: VRRPProtocol,
+		Src:      net.ParseIP("192.0.2.2"),
+		Dst:      multicastGroup,
+		Options:  make([]byte, optLen),
+	}
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("header Marshal: %v", err)
+	}
+	return append(b, payload...)
+}
+
+func TestVRIDFilter(t *testing.T) {
+	prog, err := vridFilter([]uint8{20, 10, 20, 255})
+	if err != nil {
+		t.Fatalf("vridFilter: %v", err)
+	}
+	insts, ok := bpf.Disassemble(prog)
+	if !ok {
+		t.Fatal("the filter does not disassemble")
+	}
+	vm, err := bpf.NewVM(insts)
+	if err != nil {
+		t.Fatalf("NewVM: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		msg  []byte
+		pass bool
+	}{
+		{"first VRID", rawMessage(t, 10, 0), true},
+		{"middle VRID", rawMessage(t, 20, 0), true},
+		{"last VRID", rawMessage(t, 255, 0), true},
+		{"other VRID", rawMessage(t, 11, 0), false},
+		{"VRID after IP options", rawMessage(t, 10, 4), true},
+		{"other VRID after IP options", rawMessage(t, 11, 4), false},
+		{"truncated", rawMessage(t, 10, 0)[:ipv4.HeaderLen+1], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := vm.Run(tt.msg)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if pass := n > 0; pass != tt.pass {
+				t.Errorf("passed %d bytes, want pass %v", n, tt.pass)
+			}
+		})
+	}
+}
+
+func TestSetVRIDFilter(t *testing.T) {
+	n := loopbackNetwork(t)
+	if err := n.SetVRIDFilter([]uint8{10}); err != nil {
+		t.Fatalf("SetVRIDFilter: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	received := make(chan uint8, 10)
+	go func() {
+		_ = n.ReceiveRaw(ctx, func(_ *ipv4.Header, payload []byte) {
+			received <- payload[1]
+		})
+	}()
+
+	s := newSender(n)
+	for _, vrid := range []uint8{20, 10} {
+		if _, _, err := s.send(NewPacket(VRRPv2, vrid, 100, nil)); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	select {
+	case vrid := <-received:
+		if vrid != 10 {
+			t.Errorf("received VRID %d through the filter", vrid)
+		}
+	case <-ctx.Done():
+		t.Fatal("the filter dropped VRID 10")
+	}
+
+	if err := n.SetVRIDFilter(nil); err != nil {
+		t.Fatalf("SetVRIDFilter(nil): %v", err)
+	}
+	if err := n.SetVRIDFilter(nil); err != nil {
+		t.Errorf("removing a filter twice: %v", err)
+	}
+	if _, _, err := s.send(NewPacket(VRRPv2, 20, 100, nil)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case vrid := <-received:
+		if vrid != 20 {
+			t.Errorf("received VRID %d, want 20 without the filter", vrid)
+		}
+	case <-ctx.Done():
+		t.Fatal("VRID 20 was dropped without the filter")
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	l := &link{network: loopbackNetwork(t), logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	filtered := &VirtualRouter{vrid: 10, kernelFilter: true}
+	unfiltered := &VirtualRouter{vrid: 20}
+
+	l.add(filtered)
+	if !l.filtered {
+		t.Error("no filter with only filtering routers on the link")
+	}
+	l.add(unfiltered)
+	if l.filtered {
+		t.Error("a filter with a router on the link that asked for none")
+	}
+	l.remove(unfiltered)
+	if !l.filtered {
+		t.Error("no filter after the router that asked for none was removed")
+	}
+	l.remove(filtered)
+	if l.filtered {
+		t.Error("a filter left on the link without routers")
+	}
+}
@@ -0,0 +1,141 @@
+package vrrp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// GARPMode selects the forms of gratuitous ARP a router broadcasts for each
+// virtual IP it adds on becoming MASTER, so hosts and switches move the
+// address to its MAC without waiting for their ARP caches to expire. Some
+// switches and operating systems only honor one of the two forms.
+type GARPMode string
+
+const (
+	// GARPBoth sends a request and then a reply (the default)
+	GARPBoth GARPMode = "both"
+	// GARPRequest sends an ARP announcement (RFC 5227): a request with the
+	// virtual IP as both sender and target address
+	GARPRequest GARPMode = "request"
+	// GARPReply sends an unsolicited reply with the virtual IP as both
+	// sender and target address, as arping -A does
+	GARPReply GARPMode = "reply"
+	// GARPOff sends none, for address backends or hooks that announce the
+	// addresses themselves
+	GARPOff GARPMode = "off"
+)
+
+// GARPModes lists the valid values of Config.GARP
+var GARPModes = []GARPMode{GARPBoth, GARPRequest, GARPReply, GARPOff}
+
+func validateGARPMode(m GARPMode) error {
+	if m == "" || slices.Contains(GARPModes, m) {
+		return nil
+	}
+	return fmt.Errorf("%w: unknown gratuitous ARP mode %q", ErrInvalidConfig, m)
+}
+
+// ops returns the ARP operations sent in the mode, in order
+func (m GARPMode) ops() []uint16 {
+	switch m {
+	case GARPBoth:
+		return []uint16{arpRequest, arpReply}
+	case GARPRequest:
+		return []uint16{arpRequest}
+	case GARPReply:
+		return []uint16{arpReply}
+	}
+	return nil
+}
+
+// gratuitousARP builds the gratuitous ARP message for ip with operation op:
+// a request leaves the target hardware address zero, a reply repeats the
+// sender's
+func gratuitousARP(op uint16, mac net.HardwareAddr, ip net.IP) []byte {
+	var target net.HardwareAddr
+	if op == arpReply {
+		target = mac
+	}
+	return arpMessage(op, mac, ip, target, ip)
+}
+
+// openARPSender opens a packet socket on iface that only sends ARP
+// messages: with protocol 0 the kernel delivers it nothing to read
+func openARPSender(iface *net.Interface) (*arpConn, error) {
+	if len(iface.HardwareAddr) != 6 {
+		return nil, fmt.Errorf("interface %s has no Ethernet address", iface.Name)
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.EPERM) {
+			err = fmt.Errorf("%w: %w", ErrPermission, err)
+		}
+		return nil, fmt.Errorf("failed to create ARP socket: %w", err)
+	}
+	return &arpConn{fd: fd, iface: iface}, nil
+}
+
+// sendGARP broadcasts a gratuitous ARP for ip on iface for each of ops
+func sendGARP(iface *net.Interface, ip net.IP, ops []uint16) error {
+	conn, err := openARPSender(iface)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	for _, op := range ops {
+		if err := conn.broadcast(gratuitousARP(op, iface.HardwareAddr, ip)); err != nil {
+			return fmt.Errorf("failed to send gratuitous ARP for %s: %w", ip, err)
+		}
+	}
+	return nil
+}
+
+// announcingAddresses broadcasts gratuitous ARP for each address its
+// AddressManager adds, in the router's GARPMode at the time
+type announcingAddresses struct {
+	AddressManager
+	iface  *net.Interface
+	mode   *atomic.Value
+	logger *slog.Logger
+	// send broadcasts the messages, sendGARP outside tests
+	send func(iface *net.Interface, ip net.IP, ops []uint16) error
+}
+
+func (a announcingAddresses) AddIP(ip net.IP) error {
+	if err := a.AddressManager.AddIP(ip); err != nil {
+		return err
+	}
+	mode, _ := a.mode.Load().(GARPMode)
+	if ops := mode.ops(); len(ops) > 0 {
+		// The address is in place either way; caches catch up once their
+		// entries expire
+		if err := a.send(a.iface, ip, ops); err != nil {
+			a.logger.Warn("Cannot announce virtual IP", "ip", ip, "mode", string(mode), "err", err)
+		}
+	}
+	return nil
+}
+
+// SetGARP replaces Config.GARP, taking effect with the next virtual IP added
+func (vr *VirtualRouter) SetGARP(m GARPMode) error {
+	if err := validateGARPMode(m); err != nil {
+		return err
+	}
+	if m == "" {
+		m = GARPBoth
+	}
+	vr.garp.Store(m)
+	return nil
+}
+
+// GARP returns the forms of gratuitous ARP the router sends
+func (vr *VirtualRouter) GARP() GARPMode {
+	m, _ := vr.garp.Load().(GARPMode)
+	return m
+}
@@ -0,0 +1,101 @@
+package vrrp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGratuitousARP(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	ip := net.ParseIP("192.168.1.100")
+
+	header := []byte{0, 1, 0x08, 0, 6, 4}
+	sender := []byte{0x02, 0, 0, 0, 0, 0x01, 192, 168, 1, 100}
+	for _, tc := range []struct {
+		op     uint16
+		target []byte
+	}{
+		{arpRequest, []byte{0, 0, 0, 0, 0, 0, 192, 168, 1, 100}},
+		{arpReply, []byte{0x02, 0, 0, 0, 0, 0x01, 192, 168, 1, 100}},
+	} {
+		want := append(append(append(append([]byte{}, header...), 0, byte(tc.op)), sender...), tc.target...)
+		if got := gratuitousARP(tc.op, mac, ip); !bytes.Equal(got, want) {
+			t.Errorf("gratuitousARP(%d) = % x, want % x", tc.op, got, want)
+		}
+	}
+}
+
+func TestAnnouncingAddresses(t *testing.T) {
+	ip := net.ParseIP("192.168.1.100")
+	for _, tc := range []struct {
+		mode GARPMode
+		want string
+	}{
+		{GARPBoth, "192.168.1.100 [1 2]"},
+		{GARPRequest, "192.168.1.100 [1]"},
+		{GARPReply, "192.168.1.100 [2]"},
+		{GARPOff, ""},
+	} {
+		var sent []string
+		var mode atomic.Value
+		mode.Store(tc.mode)
+		a := announcingAddresses{
+			AddressManager: &recordingAddresses{},
+			mode:           &mode,
+			logger:         slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+			send: func(_ *net.Interface, ip net.IP, ops []uint16) error {
+				sent = append(sent, fmt.Sprint(ip, " ", ops))
+				return nil
+			},
+		}
+		if err := a.AddIP(ip); err != nil {
+			t.Fatalf("AddIP in mode %s: %v", tc.mode, err)
+		}
+		if err := a.DelIP(ip); err != nil {
+			t.Fatalf("DelIP in mode %s: %v", tc.mode, err)
+		}
+		if got := strings.Join(sent, ", "); got != tc.want {
+			t.Errorf("mode %s sent %q, want %q", tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestAnnouncingAddressesErrors(t *testing.T) {
+	ip := net.ParseIP("192.168.1.100")
+	var mode atomic.Value
+	mode.Store(GARPBoth)
+	var logs bytes.Buffer
+	sent := 0
+	a := announcingAddresses{
+		AddressManager: &ExecAddresses{iface: "eth0", run: (&fakeIP{err: errors.New("exit status 2")}).run},
+		mode:           &mode,
+		logger:         slog.New(slog.NewTextHandler(&logs, nil)),
+		send: func(*net.Interface, net.IP, []uint16) error {
+			sent++
+			return errors.New("network is down")
+		},
+	}
+
+	// Nothing is announced for an address that was not added
+	if err := a.AddIP(ip); err == nil {
+		t.Error("AddIP succeeded although the backend failed")
+	}
+	if sent != 0 {
+		t.Errorf("announced %d times after a failed add, want 0", sent)
+	}
+
+	// A failed announcement is logged but does not fail the add
+	a.AddressManager = &recordingAddresses{}
+	if err := a.AddIP(ip); err != nil {
+		t.Errorf("AddIP = %v, want the announcement failure only logged", err)
+	}
+	if !strings.Contains(logs.String(), "Cannot announce virtual IP") {
+		t.Errorf("failed announcement not logged: %s", logs.String())
+	}
+}
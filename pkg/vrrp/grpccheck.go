@@ -0,0 +1,149 @@
+//go:build !small
+// +build !small
+
+package vrrp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcCheck asks a service for its health with the standard gRPC health
+// checking protocol (grpc.health.v1). It fails unless the service answers
+// SERVING. Each check opens a new connection, so a server that stopped
+// accepting connections fails even while an old one still works.
+type grpcCheck struct {
+	target  string
+	service string
+
+	// TLS is on with tls set or any of the files or serverName; the files
+	// are read again on each check to pick up renewed certificates
+	tls              bool
+	ca, cert, key    string
+	serverName       string
+	skipVerification bool
+}
+
+// newGRPCCheck builds a gRPC health check from the keys of a "grpc:" tracker
+// spec: target (host:port) is required, service names the service to ask
+// about (the server as a whole by default), and tls, ca, cert, key,
+// server_name and insecure_skip_verify set up TLS
+func newGRPCCheck(params [][2]string) (check, error) {
+	c := &grpcCheck{}
+	var err error
+	for _, p := range params {
+		switch key, value := p[0], p[1]; key {
+		case "target":
+			if _, _, err = net.SplitHostPort(value); err != nil {
+				return nil, fmt.Errorf("target: %w", err)
+			}
+			c.target = value
+		case "service":
+			c.service = value
+		case "tls":
+			if c.tls, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("tls: %w", err)
+			}
+		case "insecure_skip_verify":
+			if c.skipVerification, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("insecure_skip_verify: %w", err)
+			}
+		case "ca":
+			c.ca = value
+		case "cert":
+			c.cert = value
+		case "key":
+			c.key = value
+		case "server_name":
+			c.serverName = value
+		default:
+			return nil, fmt.Errorf("unknown key %q, want target, service, tls, ca, cert, key, server_name, "+
+				"insecure_skip_verify, name, interval, timeout, fall, rise or weight", key)
+		}
+	}
+	if c.target == "" {
+		return nil, errors.New("target is required")
+	}
+	if (c.cert == "") != (c.key == "") {
+		return nil, errors.New("cert and key go together")
+	}
+	c.tls = c.tls || c.ca != "" || c.cert != "" || c.serverName != "" || c.skipVerification
+	if _, err = c.tlsConfig(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// tlsConfig loads the TLS files, or returns nil without TLS
+func (c *grpcCheck) tlsConfig() (*tls.Config, error) {
+	if !c.tls {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.serverName,
+		InsecureSkipVerify: c.skipVerification,
+	}
+	if c.ca != "" {
+		pem, err := os.ReadFile(c.ca)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA %s", c.ca)
+		}
+	}
+	if c.cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.cert, c.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// String is "TARGET", or "SERVICE@TARGET" for a named service
+func (c *grpcCheck) String() string {
+	if c.service == "" {
+		return c.target
+	}
+	return c.service + "@" + c.target
+}
+
+func (c *grpcCheck) check(ctx context.Context) error {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient("passthrough:///"+c.target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: c.service})
+	if err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	if s := resp.GetStatus(); s != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%s: %s", c, s)
+	}
+	return nil
+}
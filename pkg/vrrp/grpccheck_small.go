@@ -0,0 +1,12 @@
+//go:build small
+// +build small
+
+package vrrp
+
+import "errors"
+
+// newGRPCCheck fails in a binary built with -tags small, which leaves out
+// the gRPC library
+func newGRPCCheck([][2]string) (check, error) {
+	return nil, errors.New("not built into this binary (built with -tags small)")
+}
@@ -0,0 +1,168 @@
+//go:build !small
+// +build !small
+
+package vrrp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthServer serves the gRPC health service, the server SERVING and the
+// service "db" NOT_SERVING, and returns its address
+func healthServer(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	hs := health.NewServer()
+	hs.SetServingStatus("db", healthpb.HealthCheckResponse_NOT_SERVING)
+	s := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(s, hs)
+	go func() { _ = s.Serve(ln) }()
+	t.Cleanup(s.Stop)
+	return ln.Addr().String()
+}
+
+// selfSignedCert writes a certificate for 127.0.0.1 that signs itself and
+// its key to dir, and returns their paths
+func selfSignedCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "health"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func runGRPCCheck(t *testing.T, params [][2]string) error {
+	t.Helper()
+	c, err := newGRPCCheck(params)
+	if err != nil {
+		t.Fatalf("newGRPCCheck(%v): %v", params, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return c.check(ctx)
+}
+
+func TestGRPCCheck(t *testing.T) {
+	addr := healthServer(t)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	for _, tc := range []struct {
+		params [][2]string
+		want   string // substring of the error, "" for none
+	}{
+		{[][2]string{{"target", addr}}, ""},
+		{[][2]string{{"target", addr}, {"service", "db"}}, "NOT_SERVING"},
+		{[][2]string{{"target", addr}, {"service", "cache"}}, "NotFound"},
+		{[][2]string{{"target", closed.Addr().String()}}, "Unavailable"},
+	} {
+		err := runGRPCCheck(t, tc.params)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("check %v = %v, want success", tc.params, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("check %v = %v, want an error with %q", tc.params, err, tc.want)
+		}
+	}
+}
+
+func TestGRPCCheckTLS(t *testing.T) {
+	certFile, keyFile := selfSignedCert(t, t.TempDir())
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := healthServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+
+	if err := runGRPCCheck(t, [][2]string{{"target", addr}, {"ca", certFile}}); err != nil {
+		t.Errorf("check with the CA = %v, want success", err)
+	}
+	if err := runGRPCCheck(t, [][2]string{{"target", addr}, {"insecure_skip_verify", "true"}}); err != nil {
+		t.Errorf("check without verification = %v, want success", err)
+	}
+	if err := runGRPCCheck(t, [][2]string{{"target", addr}, {"tls", "true"}}); err == nil {
+		t.Error("check trusting the system CAs succeeded with a self-signed certificate")
+	}
+	if err := runGRPCCheck(t, [][2]string{{"target", addr}}); err == nil {
+		t.Error("check without TLS succeeded against a TLS server")
+	}
+}
+
+func TestParseGRPCTracker(t *testing.T) {
+	tr, err := ParseTracker("grpc:target=127.0.0.1:50051,service=db,weight=20")
+	if err != nil {
+		t.Fatalf("ParseTracker: %v", err)
+	}
+	if tr.Name != "grpc:db@127.0.0.1:50051" || tr.Weight != 20 {
+		t.Errorf("ParseTracker = %+v", tr)
+	}
+
+	certFile, _ := selfSignedCert(t, t.TempDir())
+	for _, spec := range []string{
+		"grpc:service=db",
+		"grpc:target=localhost",
+		"grpc:target=127.0.0.1:50051,tls=maybe",
+		"grpc:target=127.0.0.1:50051,cert=" + certFile,
+		"grpc:target=127.0.0.1:50051,ca=/nonexistent/ca.pem",
+		"grpc:target=127.0.0.1:50051,query=example.com",
+	} {
+		if _, err := ParseTracker(spec); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseTracker(%q) = %v, want ErrInvalidConfig", spec, err)
+		}
+	}
+}
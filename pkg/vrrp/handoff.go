@@ -0,0 +1,94 @@
+package vrrp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// handoff is a Handoff waiting for a peer to take over
+type handoff struct {
+	// peer is the router to hand over to, nil for any
+	peer net.IP
+	once sync.Once
+	done chan struct{}
+	// master is the router that took over, set before done is closed
+	master net.IP
+}
+
+// observe is called with the source of each advertisement of a master
+// accepted while the handoff is in progress
+func (h *handoff) observe(src net.IP) {
+	if h.peer != nil && !h.peer.Equal(src) {
+		return
+	}
+	h.once.Do(func() {
+		h.master = src
+		close(h.done)
+	})
+}
+
+// Handoff makes a MASTER hand over to the router at to, or to any router if
+// to is nil, for planned maintenance. It advertises priority 0 and then
+// priority 1, taking over again only if no backup does, until an
+// advertisement of to as MASTER is received. It then restores the
+// priority, not preempting for hold, and returns the router that took
+// over. If ctx ends first the priority is restored without a hold and the
+// error says so. It fails if the router is not running, not MASTER or
+// already handing off.
+func (vr *VirtualRouter) Handoff(ctx context.Context, to net.IP, hold time.Duration) (net.IP, error) {
+	h := &handoff{peer: to, done: make(chan struct{})}
+
+	vr.mu.Lock()
+	sm, running, stopping := vr.stateMachine, vr.running, vr.ctx
+	switch {
+	case !running:
+		vr.mu.Unlock()
+		return nil, ErrNotRunning
+	case sm.GetState() != Master:
+		vr.mu.Unlock()
+		return nil, fmt.Errorf("VRID %d: cannot hand off: %w", vr.vrid, ErrNotMaster)
+	case !vr.handoff.CompareAndSwap(nil, h):
+		vr.mu.Unlock()
+		return nil, fmt.Errorf("VRID %d: a handoff is already in progress", vr.vrid)
+	}
+	priority, _ := vr.reprioritize()
+	vr.mu.Unlock()
+
+	target := "any router"
+	if to != nil {
+		target = to.String()
+	}
+	vr.logger.Info("Handing off", "to", target, "hold", hold)
+	vr.applyPriority(sm, priority)
+	if err := sm.StepDown(0); err != nil {
+		// A backup took over between the checks and the step-down
+		vr.logger.Debug("Handoff found the router no longer MASTER")
+	}
+
+	var err error
+	select {
+	case <-h.done:
+		// Held before the priority is restored so that it never preempts
+		sm.Hold(hold)
+	case <-ctx.Done():
+		err = fmt.Errorf("VRID %d: %s did not take over: %w", vr.vrid, target, ctx.Err())
+	case <-stopping.Done():
+		err = fmt.Errorf("VRID %d: handoff to %s: %w", vr.vrid, target, ErrNotRunning)
+	}
+
+	vr.mu.Lock()
+	vr.handoff.Store(nil)
+	priority, _ = vr.reprioritize()
+	vr.mu.Unlock()
+	vr.applyPriority(sm, priority)
+
+	if err != nil {
+		vr.logger.Warn("Handoff failed", "err", err)
+		return nil, err
+	}
+	vr.logger.Info("Handed off", "master", h.master, "hold", hold)
+	return h.master, nil
+}
@@ -0,0 +1,140 @@
+package vrrp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// startHandoffRouter starts an address owner on a memTransport and waits
+// for its first advertisement as MASTER
+func startHandoffRouter(t *testing.T) (*VirtualRouter, *memTransport) {
+	t.Helper()
+	mt := &memTransport{
+		iface: &net.Interface{Index: 7, Name: "mem0"},
+		ip:    net.IPv4(10, 0, 0, 1).To4(),
+		in:    make(chan []byte),
+		out:   make(chan []byte, 64),
+	}
+	vr, err := New("", 10, WithVIPs("192.168.1.100"), WithPriority(255), WithTransport(mt),
+		WithAddressBackend(AddressNoop), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := vr.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { _ = vr.Stop(context.Background()) })
+	waitSent(t, mt, 255)
+	return vr, mt
+}
+
+// waitSent waits for an advertisement of priority written to mt
+func waitSent(t *testing.T, mt *memTransport, priority uint8) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-mt.out:
+			var pkt Packet
+			if err := pkt.Unmarshal(msg[ipv4.HeaderLen:]); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if pkt.Priority == priority {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no advertisement of priority %d written", priority)
+		}
+	}
+}
+
+// sendFrom feeds mt an advertisement of priority from src
+func sendFrom(t *testing.T, mt *memTransport, src net.IP, priority uint8) {
+	t.Helper()
+	data := marshalAdvert(t, 10, priority)
+	header := ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TTL: 255, Protocol: VRRPProtocol,
+		Src: src, Dst: multicastGroup, TotalLen: ipv4.HeaderLen + len(data)}
+	h, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	mt.in <- append(h, data...)
+}
+
+func TestHandoff(t *testing.T) {
+	vr, mt := startHandoffRouter(t)
+	target := net.IPv4(10, 0, 0, 2).To4()
+
+	type result struct {
+		master net.IP
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		master, err := vr.Handoff(context.Background(), target, time.Minute)
+		done <- result{master, err}
+	}()
+
+	// The master advertises priority 0 so that a backup takes over at once
+	waitSent(t, mt, 0)
+	if got := vr.Status().Priority; got != 1 {
+		t.Errorf("Status().Priority during the handoff = %d, want 1", got)
+	}
+	if _, err := vr.Handoff(context.Background(), nil, 0); err == nil {
+		t.Error("a second Handoff succeeded")
+	}
+
+	// Another router taking over does not end the handoff
+	sendFrom(t, mt, net.IPv4(10, 0, 0, 3), 100)
+	select {
+	case r := <-done:
+		t.Fatalf("Handoff returned %v, %v before the target took over", r.master, r.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sendFrom(t, mt, target, 100)
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handoff did not return after the target took over")
+	}
+	if r.err != nil || !r.master.Equal(target) {
+		t.Fatalf("Handoff = %v, %v, want %s", r.master, r.err, target)
+	}
+	if got := vr.Status().Priority; got != 255 {
+		t.Errorf("Status().Priority after the handoff = %d, want 255 restored", got)
+	}
+
+	// Held, the restored priority does not preempt the new master
+	sendFrom(t, mt, target, 100)
+	time.Sleep(50 * time.Millisecond)
+	if got := vr.GetState(); got != Backup {
+		t.Errorf("state after the handoff = %s, want BACKUP", got)
+	}
+}
+
+func TestHandoffTimeout(t *testing.T) {
+	vr, _ := startHandoffRouter(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := vr.Handoff(ctx, net.IPv4(10, 0, 0, 2), time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Handoff = %v, want the context's deadline", err)
+	}
+	if got := vr.Status().Priority; got != 255 {
+		t.Errorf("Status().Priority = %d, want 255 restored", got)
+	}
+
+	if _, err := vr.Handoff(context.Background(), nil, 0); !errors.Is(err, ErrNotMaster) {
+		t.Errorf("Handoff as BACKUP = %v, want ErrNotMaster", err)
+	}
+}
@@ -0,0 +1,77 @@
+package vrrp
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of every Histogram
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Histogram counts durations in the buckets of LatencyBuckets. The zero value
+// is empty; it is not safe for concurrent use.
+type Histogram struct {
+	// Counts[i] counts the observations up to LatencyBuckets[i] and above
+	// the bucket before; the extra last element counts those above every
+	// bucket. It is nil until the first observation.
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Observe adds d to the histogram
+func (h *Histogram) Observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+	h.Counts[sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })]++
+	h.Count++
+	h.Sum += d
+}
+
+// Quantile estimates the q-quantile (0 < q <= 1) as the upper bound of the
+// bucket it falls in. Observations above every bucket are reported as the
+// largest bucket bound. It returns 0 for an empty histogram.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.Counts[:len(LatencyBuckets)] {
+		seen += n
+		if seen >= rank {
+			return LatencyBuckets[i]
+		}
+	}
+	return LatencyBuckets[len(LatencyBuckets)-1]
+}
+
+// Mean returns the average observation, or 0 for an empty histogram
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = slices.Clone(h.Counts)
+	return h
+}
@@ -0,0 +1,32 @@
+package vrrp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	if h.Quantile(0.99) != 0 || h.Mean() != 0 {
+		t.Errorf("empty histogram: p99 %v, mean %v; want 0", h.Quantile(0.99), h.Mean())
+	}
+
+	for range 98 {
+		h.Observe(3 * time.Millisecond)
+	}
+	h.Observe(200 * time.Millisecond)
+	h.Observe(time.Minute)
+
+	if h.Count != 100 || h.Counts[1] != 98 || h.Counts[6] != 1 || h.Counts[len(LatencyBuckets)] != 1 {
+		t.Errorf("Count %d, Counts %v; want 98 in the 5ms bucket, one at 250ms and one above", h.Count, h.Counts)
+	}
+	if got := h.Quantile(0.5); got != 5*time.Millisecond {
+		t.Errorf("Quantile(0.5) = %v, want 5ms", got)
+	}
+	if got := h.Quantile(0.99); got != 250*time.Millisecond {
+		t.Errorf("Quantile(0.99) = %v, want 250ms", got)
+	}
+	if got := h.Quantile(1); got != 5*time.Second {
+		t.Errorf("Quantile(1) = %v, want the largest bucket", got)
+	}
+}
@@ -0,0 +1,75 @@
+package vrrp
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// IntervalCheck selects what a router does with advertisements whose
+// interval differs from its own. VRRPv2 requires every router of a VRID to
+// use the same interval (RFC 3768 section 7.1); VRRPv3 backups adopt the
+// master's instead (RFC 5798 section 6.4.3), so VRRPv3 advertisements are
+// only ever counted.
+type IntervalCheck string
+
+const (
+	// IntervalCount counts them in Stats.AdvIntervalErrors and accepts
+	// them (the default)
+	IntervalCount IntervalCheck = "count"
+	// IntervalEnforce also drops the VRRPv2 ones as DropInterval, as RFC
+	// 3768 says
+	IntervalEnforce IntervalCheck = "enforce"
+)
+
+// IntervalChecks lists the valid values of Config.IntervalCheck
+var IntervalChecks = []IntervalCheck{IntervalCount, IntervalEnforce}
+
+func validateIntervalCheck(c IntervalCheck) error {
+	if c == "" || slices.Contains(IntervalChecks, c) {
+		return nil
+	}
+	return fmt.Errorf("%w: unknown interval check %q", ErrInvalidConfig, c)
+}
+
+// SetIntervalCheck replaces Config.IntervalCheck, taking effect with the
+// next advertisement received
+func (vr *VirtualRouter) SetIntervalCheck(c IntervalCheck) error {
+	if err := validateIntervalCheck(c); err != nil {
+		return err
+	}
+	if c == "" {
+		c = IntervalCount
+	}
+	vr.intervalCheck.Store(c)
+	return nil
+}
+
+// IntervalCheck returns what the router does with advertisements of another
+// interval
+func (vr *VirtualRouter) IntervalCheck() IntervalCheck {
+	c, _ := vr.intervalCheck.Load().(IntervalCheck)
+	return c
+}
+
+// dropInterval checks the interval of an advertisement for the router
+// against its own, counting and logging a mismatch. It reports whether the
+// advertisement is to be dropped.
+func (vr *VirtualRouter) dropInterval(pkt *Packet, src net.IP, want time.Duration) bool {
+	key := peerKey(src)
+	interval := pkt.Interval()
+	if interval == want {
+		vr.intervalWarned.agreed(key)
+		return false
+	}
+
+	vr.advIntervalErrors.Add(1)
+	vr.metrics.AdvertMismatch(vr.iface, vr.vrid, MismatchAdvInterval)
+	enforce := vr.IntervalCheck() == IntervalEnforce && pkt.Version == VRRPv2
+	if vr.intervalWarned.first(key, interval) {
+		vr.logger.Warn("Peer advertises a different interval", "src", src, "interval", interval,
+			"local_interval", want, "dropped", enforce)
+	}
+	return enforce
+}
@@ -0,0 +1,161 @@
+package vrrp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestIntervalWarnings(t *testing.T) {
+	var w peerWarnings[time.Duration]
+	a := netip.MustParseAddr("10.0.0.2")
+
+	for i, tc := range []struct {
+		interval time.Duration
+		want     bool
+	}{
+		{3 * time.Second, true},
+		{3 * time.Second, false},
+		// A new interval is logged again
+		{5 * time.Second, true},
+		{5 * time.Second, false},
+	} {
+		if got := w.first(a, tc.interval); got != tc.want {
+			t.Errorf("first %d with %v = %v, want %v", i, tc.interval, got, tc.want)
+		}
+	}
+	w.agreed(a)
+	if !w.first(a, 5*time.Second) {
+		t.Error("mismatch after agreeing is not logged")
+	}
+
+	// Past MaxPeers sources nothing more is logged
+	for i := range MaxPeers {
+		w.first(netip.AddrFrom4([4]byte{10, 0, 1, byte(i)}), time.Second)
+	}
+	if w.first(netip.MustParseAddr("10.0.2.1"), time.Second) || len(w.logged) != MaxPeers {
+		t.Errorf("table holds %d sources and logs another, want %d and not", len(w.logged), MaxPeers)
+	}
+}
+
+func TestIntervalCheck(t *testing.T) {
+	vr := newTestRouter(t)
+	var logs bytes.Buffer
+	vr.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	marshal := func(version uint8, interval uint16) []byte {
+		p := NewPacket(version, 10, 100, []net.IP{net.ParseIP("192.168.1.100").To4()})
+		p.AdvInterval = interval
+		data, err := p.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return data
+	}
+
+	// Counted and accepted by default, and logged once
+	for range 3 {
+		vr.handleAdvert(peer, marshal(VRRPv2, 3), ownIP)
+	}
+	s := vr.GetStats()
+	if s.AdvertsReceived != 3 || s.AdvIntervalErrors != 3 || s.Drops[DropInterval] != 0 {
+		t.Errorf("received %d, %d interval errors, %d dropped; want 3, 3 and 0",
+			s.AdvertsReceived, s.AdvIntervalErrors, s.Drops[DropInterval])
+	}
+	if n := strings.Count(logs.String(), "different interval"); n != 1 {
+		t.Errorf("logged the mismatch %d times, want once:\n%s", n, logs.String())
+	}
+
+	// Enforcing drops VRRPv2 advertisements only; VRRPv3 backups adopt the
+	// master's interval
+	if err := vr.SetIntervalCheck(IntervalEnforce); err != nil {
+		t.Fatalf("SetIntervalCheck: %v", err)
+	}
+	vr.ResetStats()
+	vr.handleAdvert(peer, marshal(VRRPv2, 3), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.3"), TTL: 255}, marshal(VRRPv3, 300), ownIP)
+	vr.handleAdvert(peer, marshal(VRRPv2, 1), ownIP)
+	s = vr.GetStats()
+	if s.AdvertsReceived != 2 || s.AdvIntervalErrors != 2 || s.Drops[DropInterval] != 1 {
+		t.Errorf("received %d, %d interval errors, %d dropped; want 2, 2 and 1",
+			s.AdvertsReceived, s.AdvIntervalErrors, s.Drops[DropInterval])
+	}
+
+	if err := vr.SetIntervalCheck("strict"); err == nil {
+		t.Error(`SetIntervalCheck("strict") succeeded`)
+	}
+}
+
+// TestPriorityZeroInterval checks that the priority 0 advertisements of a
+// step-down and of a shutdown carry the router's interval, so that backups
+// of a longer interval than 1s neither drop nor count them
+func TestPriorityZeroInterval(t *testing.T) {
+	// priorityZero starts a MASTER advertising every 3s, has it leave with
+	// leave and returns the priority 0 advertisement it sent
+	priorityZero := func(leave func(*VirtualRouter)) []byte {
+		t.Helper()
+		mt := &memTransport{
+			iface: &net.Interface{Index: 7, Name: "mem0"},
+			ip:    net.IPv4(10, 0, 0, 1).To4(),
+			in:    make(chan []byte),
+			out:   make(chan []byte, 64),
+		}
+		vr, err := New("", 10, WithVIPs("192.168.1.100"), WithPriority(255), WithAdvertInterval(3),
+			WithTransport(mt), WithAddressBackend(AddressNoop),
+			WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		if err := vr.Start(context.Background()); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		t.Cleanup(func() { _ = vr.Stop(context.Background()) })
+		waitSent(t, mt, 255)
+
+		leave(vr)
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case msg := <-mt.out:
+				if msg[ipv4.HeaderLen+2] == 0 {
+					return msg[ipv4.HeaderLen:]
+				}
+			case <-timeout:
+				t.Fatal("no priority 0 advertisement sent")
+			}
+		}
+	}
+	stepDown := priorityZero(func(vr *VirtualRouter) { vr.SetBackupOnly(true) })
+	shutdown := priorityZero(func(vr *VirtualRouter) {
+		if err := vr.Stop(context.Background()); err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+	})
+
+	for _, check := range []IntervalCheck{IntervalCount, IntervalEnforce} {
+		vr := newTestRouter(t)
+		if err := vr.SetAdvertInterval(3); err != nil {
+			t.Fatalf("SetAdvertInterval: %v", err)
+		}
+		if err := vr.SetIntervalCheck(check); err != nil {
+			t.Fatalf("SetIntervalCheck: %v", err)
+		}
+		peer := &ipv4.Header{Src: net.ParseIP("10.0.0.1"), TTL: 255}
+		vr.handleAdvert(peer, stepDown, net.ParseIP("10.0.0.2"))
+		vr.handleAdvert(peer, shutdown, net.ParseIP("10.0.0.2"))
+		if s := vr.GetStats(); s.AdvertsReceived != 2 || s.AdvIntervalErrors != 0 || s.Drops[DropInterval] != 0 {
+			t.Errorf("%s: received %d, %d interval errors, %d dropped; want 2, 0 and 0",
+				check, s.AdvertsReceived, s.AdvIntervalErrors, s.Drops[DropInterval])
+		}
+	}
+}
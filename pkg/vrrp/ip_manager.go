@@ -2,6 +2,7 @@ package vrrp
 
 import (
 	"fmt"
+	"log/slog"
 	"net"
 
 	"github.com/vishvananda/netlink"
@@ -9,20 +10,44 @@ import (
 
 // IPManager handles adding and removing virtual IP addresses
 type IPManager struct {
-	iface *net.Interface
+	iface  *net.Interface
+	handle *netlink.Handle
 }
 
 // NewIPManager creates a new IP manager for the given interface
 func NewIPManager(iface *net.Interface) *IPManager {
+	// The zero handle opens a netlink socket per request, like the package
+	// level functions
+	return newIPManager(iface, &netlink.Handle{})
+}
+
+// newIPManager creates an IP manager using a shared netlink handle
+func newIPManager(iface *net.Interface, handle *netlink.Handle) *IPManager {
 	return &IPManager{
-		iface: iface,
+		iface:  iface,
+		handle: handle,
 	}
 }
 
+// dryRunAddresses logs the address changes of a dry run instead of making them
+type dryRunAddresses struct {
+	logger *slog.Logger
+}
+
+func (d dryRunAddresses) AddIP(ip net.IP) error {
+	d.logger.Info("Dry run: would add virtual IP", "ip", ip)
+	return nil
+}
+
+func (d dryRunAddresses) DelIP(ip net.IP) error {
+	d.logger.Info("Dry run: would remove virtual IP", "ip", ip)
+	return nil
+}
+
 // AddIP adds a virtual IP address to the interface
 func (m *IPManager) AddIP(ip net.IP) error {
 	// Get the netlink handle
-	link, err := netlink.LinkByIndex(m.iface.Index)
+	link, err := m.handle.LinkByIndex(m.iface.Index)
 	if err != nil {
 		return fmt.Errorf("failed to get link by index %d: %w", m.iface.Index, err)
 	}
@@ -44,7 +69,7 @@ func (m *IPManager) AddIP(ip net.IP) error {
 	}
 
 	// Check if the address already exists
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	addrs, err := m.handle.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return fmt.Errorf("failed to list addresses: %w", err)
 	}
@@ -57,8 +82,8 @@ func (m *IPManager) AddIP(ip net.IP) error {
 	}
 
 	// Add the address
-	if err := netlink.AddrAdd(link, addr); err != nil {
-		return fmt.Errorf("failed to add IP %s to interface %s: %w", ip, m.iface.Name, err)
+	if err := m.handle.AddrAdd(link, addr); err != nil {
+		return wrapPermission(fmt.Errorf("failed to add IP %s to interface %s: %w", ip, m.iface.Name, err))
 	}
 
 	return nil
@@ -67,13 +92,13 @@ func (m *IPManager) AddIP(ip net.IP) error {
 // DelIP removes a virtual IP address from the interface
 func (m *IPManager) DelIP(ip net.IP) error {
 	// Get the netlink handle
-	link, err := netlink.LinkByIndex(m.iface.Index)
+	link, err := m.handle.LinkByIndex(m.iface.Index)
 	if err != nil {
 		return fmt.Errorf("failed to get link by index %d: %w", m.iface.Index, err)
 	}
 
 	// Get all addresses on the interface
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	addrs, err := m.handle.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return fmt.Errorf("failed to list addresses: %w", err)
 	}
@@ -81,8 +106,8 @@ func (m *IPManager) DelIP(ip net.IP) error {
 	// Find and delete the matching address
 	for _, addr := range addrs {
 		if addr.IP.Equal(ip) {
-			if err := netlink.AddrDel(link, &addr); err != nil {
-				return fmt.Errorf("failed to delete IP %s from interface %s: %w", ip, m.iface.Name, err)
+			if err := m.handle.AddrDel(link, &addr); err != nil {
+				return wrapPermission(fmt.Errorf("failed to delete IP %s from interface %s: %w", ip, m.iface.Name, err))
 			}
 			return nil
 		}
@@ -94,12 +119,12 @@ func (m *IPManager) DelIP(ip net.IP) error {
 
 // ListIPs returns all IP addresses on the interface
 func (m *IPManager) ListIPs() ([]net.IP, error) {
-	link, err := netlink.LinkByIndex(m.iface.Index)
+	link, err := m.handle.LinkByIndex(m.iface.Index)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get link by index %d: %w", m.iface.Index, err)
 	}
 
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	addrs, err := m.handle.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list addresses: %w", err)
 	}
@@ -0,0 +1,80 @@
+package vrrp
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+// latencyWatch is the timing seen by a router: advertisement jitter per peer
+// and failover latency. vr.statsMu guards it.
+type latencyWatch struct {
+	// arrivals is when the last advertisement from each peer arrived
+	arrivals map[netip.Addr]time.Time
+	jitter   map[netip.Addr]*Histogram
+	failover Histogram
+}
+
+// observeArrival records an advertisement from src arriving at now and
+// returns its jitter: how far the time since the peer's previous one is from
+// the interval it advertises. ok is false for the first advertisement, after
+// a gap longer than a master down interval and for priority 0. vr.statsMu
+// must be held.
+func (w *latencyWatch) observeArrival(pkt *Packet, src net.IP, now time.Time) (jitter time.Duration, ok bool) {
+	key := peerKey(src)
+	prev, seen := w.arrivals[key]
+	if pkt.Priority == 0 {
+		// The peer is giving up; its next advertisement starts over
+		delete(w.arrivals, key)
+		return 0, false
+	}
+	w.arrivals[key] = now
+	if !seen {
+		return 0, false
+	}
+
+	interval := pkt.Interval()
+	gap := now.Sub(prev)
+	if interval == 0 || gap > 3*interval {
+		return 0, false
+	}
+
+	jitter = gap - interval
+	if jitter < 0 {
+		jitter = -jitter
+	}
+	h := w.jitter[key]
+	if h == nil {
+		h = &Histogram{}
+		w.jitter[key] = h
+	}
+	h.Observe(jitter)
+	return jitter, true
+}
+
+// onFailover records the time from detecting the master down to the virtual
+// IPs being programmed. It is called by the state machine during the
+// transition to MASTER.
+func (vr *VirtualRouter) onFailover(latency time.Duration) {
+	vr.statsMu.Lock()
+	vr.latency.failover.Observe(latency)
+	vr.statsMu.Unlock()
+
+	vr.metrics.FailoverLatency(vr.iface, vr.vrid, latency)
+	vr.logger.Info("Took over as MASTER", "failover_latency", latency)
+}
+
+// latencyStats copies the histograms into s, emptying them if reset.
+// vr.statsMu must be held.
+func (vr *VirtualRouter) latencyStats(s *Stats, reset bool) {
+	w := &vr.latency
+	s.FailoverLatency = w.failover.clone()
+	s.Jitter = make(map[string]Histogram, len(w.jitter))
+	for peer, h := range w.jitter {
+		s.Jitter[peer.String()] = h.clone()
+	}
+	if reset {
+		w.failover = Histogram{}
+		w.jitter = make(map[netip.Addr]*Histogram)
+	}
+}
@@ -0,0 +1,436 @@
+package vrrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+)
+
+// Manager runs many virtual routers, across interfaces and VRIDs, in one
+// process. Routers on the same interface share one VRRP socket, and all of
+// them share a netlink handle for programming addresses and one scheduler for
+// their timers.
+type Manager struct {
+	mu      sync.Mutex
+	logger  *slog.Logger
+	routers []*VirtualRouter
+	links   map[string]*link
+	handle  *netlink.Handle
+	groups  map[string]*SyncGroup
+
+	// timers runs the advertisement and master down timers of every router
+	timers *scheduler
+
+	// inherited are sockets handed over by a previous process, used instead
+	// of opening new ones
+	inherited map[string]*os.File
+}
+
+// link is the VRRP socket shared by the running routers on one interface. Its
+// receive loop hands every message to each of them, and each router filters
+// by VRID as it would on a socket of its own.
+type link struct {
+	network *Network
+	handle  *netlink.Handle
+	refs    int
+	cancel  context.CancelFunc
+	done    chan struct{}
+	logger  *slog.Logger
+	// membership is the Config.Membership of the router that opened it
+	membership Membership
+
+	mu      sync.RWMutex
+	routers []*VirtualRouter
+	// filtered is whether a VRID filter is attached to the socket
+	filtered bool
+}
+
+// NewManager creates a manager without routers. logger receives the manager's
+// own records, such as socket errors; if nil, slog.Default() is used. Routers
+// log to their Config.Logger.
+func NewManager(logger *slog.Logger) *Manager {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Manager{
+		logger:    logger,
+		links:     make(map[string]*link),
+		groups:    make(map[string]*SyncGroup),
+		timers:    newScheduler(),
+		inherited: make(map[string]*os.File),
+	}
+}
+
+// Add creates a router from cfg without starting it. Each interface/VRID pair
+// can only be added once.
+func (m *Manager) Add(cfg *Config) (*VirtualRouter, error) {
+	vr, err := NewVirtualRouter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.find(vr.iface, cfg.VRID) != nil {
+		if vr.syncMember != nil {
+			cfg.SyncGroup.leave(vr.syncMember)
+		}
+		return nil, fmt.Errorf("VRID %d on %s: %w", cfg.VRID, vr.iface, ErrRouterExists)
+	}
+
+	vr.manager = m
+	m.routers = append(m.routers, vr)
+	return vr, nil
+}
+
+// Remove stops the router for iface and vrid if it is running and forgets it
+func (m *Manager) Remove(iface string, vrid uint8) error {
+	m.mu.Lock()
+	vr := m.find(iface, vrid)
+	for i, candidate := range m.routers {
+		if candidate == vr {
+			m.routers = append(m.routers[:i], m.routers[i+1:]...)
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	if vr == nil {
+		return fmt.Errorf("VRID %d on %s: %w", vrid, iface, ErrRouterNotFound)
+	}
+
+	var err error
+	if vr.IsRunning() {
+		err = vr.Stop(context.Background())
+	}
+	if vr.syncMember != nil {
+		vr.syncMember.group.leave(vr.syncMember)
+	}
+	return err
+}
+
+// SyncGroup returns the sync group with the given name, creating it on first
+// use, for Config.SyncGroup of the routers added to the manager
+func (m *Manager) SyncGroup(name string) *SyncGroup {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g := m.groups[name]
+	if g == nil {
+		g = NewSyncGroup(name)
+		m.groups[name] = g
+	}
+	return g
+}
+
+// Get returns the router for iface and vrid, or nil
+func (m *Manager) Get(iface string, vrid uint8) *VirtualRouter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.find(iface, vrid)
+}
+
+// Routers returns the routers in the order they were added
+func (m *Manager) Routers() []*VirtualRouter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*VirtualRouter(nil), m.routers...)
+}
+
+func (m *Manager) find(iface string, vrid uint8) *VirtualRouter {
+	for _, vr := range m.routers {
+		if vr.iface == iface && vr.vrid == vrid {
+			return vr
+		}
+	}
+	return nil
+}
+
+// Start starts every router that is not running, until ctx is canceled or
+// Stop is called. If one fails, the routers started by this call are stopped
+// again.
+func (m *Manager) Start(ctx context.Context) error {
+	var started []*VirtualRouter
+	for _, vr := range m.Routers() {
+		if vr.IsRunning() {
+			continue
+		}
+		if err := vr.Start(ctx); err != nil {
+			for _, s := range started {
+				_ = s.Stop(context.Background())
+			}
+			return fmt.Errorf("VRID %d on %s: %w", vr.vrid, vr.iface, err)
+		}
+		started = append(started, vr)
+	}
+	return nil
+}
+
+// Stop stops every running router, a MASTER handing over with a priority 0
+// advertisement, and closes the shared sockets. The routers stop one after
+// another; those not stopped by the time ctx ends finish in the background.
+func (m *Manager) Stop(ctx context.Context) error {
+	var errs []error
+	for _, vr := range m.Routers() {
+		if !vr.IsRunning() {
+			continue
+		}
+		if err := vr.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("VRID %d on %s: %w", vr.vrid, vr.iface, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status returns a snapshot of every router, in the order they were added
+func (m *Manager) Status() []Status {
+	routers := m.Routers()
+	out := make([]Status, 0, len(routers))
+	for _, vr := range routers {
+		out = append(out, vr.Status())
+	}
+	return out
+}
+
+// Stats returns the statistics of every router, in the order they were added
+func (m *Manager) Stats() []Stats {
+	routers := m.Routers()
+	out := make([]Stats, 0, len(routers))
+	for _, vr := range routers {
+		out = append(out, vr.GetStats())
+	}
+	return out
+}
+
+// Inherit makes the routers on iface use f, a VRRP socket from Files of a
+// previous process, instead of opening a new one. It must be called before
+// they start; the manager takes ownership of f.
+func (m *Manager) Inherit(iface string, f *os.File) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old := m.inherited[iface]; old != nil {
+		_ = old.Close()
+	}
+	m.inherited[iface] = f
+}
+
+// Files returns duplicates of the open VRRP sockets by interface, to hand
+// them to the process that takes over with Inherit. The caller closes them.
+func (m *Manager) Files() (map[string]*os.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make(map[string]*os.File, len(m.links))
+	for iface, l := range m.links {
+		f, err := l.network.File()
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, err
+		}
+		files[iface] = f
+	}
+	return files, nil
+}
+
+// Detach detaches every running router (see VirtualRouter.Detach) once the
+// process that inherited the sockets has taken over
+func (m *Manager) Detach(ctx context.Context) error {
+	var errs []error
+	for _, vr := range m.Routers() {
+		if !vr.IsRunning() {
+			continue
+		}
+		if err := vr.Detach(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("VRID %d on %s: %w", vr.vrid, vr.iface, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// openLink returns the shared socket for iface, opening it for the first
+// router on the interface, whose membership settings it keeps, on ifi if
+// that router was given the interface (Config.NetInterface)
+func (m *Manager) openLink(iface string, ifi *net.Interface, membership Membership) (*link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l := m.links[iface]; l != nil {
+		l.refs++
+		return l, nil
+	}
+
+	if m.handle == nil {
+		handle, err := netlink.NewHandle(unix.NETLINK_ROUTE)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open netlink handle: %w", err)
+		}
+		m.handle = handle
+	}
+
+	logger := m.logger.With("iface", iface)
+	var network *Network
+	var err error
+	f := m.inherited[iface]
+	if f != nil {
+		delete(m.inherited, iface)
+		network, err = newNetworkFromFile(iface, f, logger)
+		_ = f.Close()
+	} else if ifi != nil {
+		network, err = newNetworkOn(ifi, logger)
+	} else {
+		network, err = newNetwork(iface, logger)
+	}
+	if err != nil {
+		if len(m.links) == 0 {
+			m.closeHandle()
+		}
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &link{
+		network: network,
+		handle:  m.handle,
+		refs:    1,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		logger:  logger,
+
+		membership: membership,
+		// The process the socket was inherited from may have attached a
+		// filter, which the first router removes if it asks for none
+		filtered: f != nil,
+	}
+	m.links[iface] = l
+
+	go l.receive(ctx)
+	return l, nil
+}
+
+// closeLink releases a reference to l, closing its socket after the last
+// router on the interface has stopped
+func (m *Manager) closeLink(l *link, leave bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l.refs--
+	if l.refs > 0 {
+		return nil
+	}
+
+	l.cancel()
+	<-l.done
+	var err error
+	if leave {
+		err = l.network.Close()
+	} else {
+		err = l.network.release()
+	}
+	delete(m.links, l.network.GetInterface().Name)
+
+	if len(m.links) == 0 {
+		m.closeHandle()
+	}
+	return err
+}
+
+func (m *Manager) closeHandle() {
+	if m.handle != nil {
+		m.handle.Close()
+		m.handle = nil
+	}
+}
+
+func (l *link) receive(ctx context.Context) {
+	defer close(l.done)
+
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		l.network.watchMembership(ctx, l.membership)
+	}()
+	defer func() { <-watched }()
+
+	ownIP := l.network.GetSourceIP()
+	err := l.network.ReceiveRaw(ctx, func(header *ipv4.Header, payload []byte) {
+		l.dispatch(header, payload, ownIP)
+	})
+
+	if err != nil && err != context.Canceled {
+		l.logger.Error("Receive loop failed", "err", err)
+	}
+}
+
+// dispatch hands a received message to every router on the interface. It is
+// decoded and validated once for all of them, except those injecting faults,
+// which each need the message to themselves.
+func (l *link) dispatch(header *ipv4.Header, payload []byte, ownIP net.IP) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var in *inspection
+	for _, vr := range l.routers {
+		if vr.faults != nil {
+			vr.faults.Inject(header, payload)
+			continue
+		}
+		if in == nil {
+			i := inspectAdvert(header, payload, ownIP)
+			in = &i
+		}
+		vr.acceptAdvert(header, payload, *in)
+	}
+}
+
+// add starts delivering advertisements to vr
+func (l *link) add(vr *VirtualRouter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routers = append(l.routers, vr)
+	l.updateFilter()
+}
+
+// remove stops delivering advertisements to vr
+func (l *link) remove(vr *VirtualRouter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, candidate := range l.routers {
+		if candidate == vr {
+			l.routers = append(l.routers[:i], l.routers[i+1:]...)
+			l.updateFilter()
+			return
+		}
+	}
+}
+
+// updateFilter attaches a socket filter for the VRIDs on the link if every
+// router on it asks for one (Config.KernelFilter), and removes it otherwise.
+// l.mu must be held.
+func (l *link) updateFilter() {
+	vrids := make([]uint8, 0, len(l.routers))
+	for _, vr := range l.routers {
+		if !vr.kernelFilter {
+			vrids = nil
+			break
+		}
+		vrids = append(vrids, vr.vrid)
+	}
+	if len(vrids) == 0 && !l.filtered {
+		return
+	}
+	if err := l.network.SetVRIDFilter(vrids); err != nil {
+		l.logger.Warn("Filtering advertisements in userspace", "err", err)
+		return
+	}
+	l.filtered = len(vrids) > 0
+}
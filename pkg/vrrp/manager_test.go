@@ -0,0 +1,107 @@
+package vrrp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestManagerAddRemove(t *testing.T) {
+	m := NewManager(nil)
+
+	for _, cfg := range []*Config{
+		{VRID: 10, Priority: 100, Interface: "eth0", VirtualIPs: []string{"192.168.1.100"}},
+		{VRID: 20, Priority: 100, Interface: "eth0", VirtualIPs: []string{"192.168.1.101"}},
+		{VRID: 10, Priority: 100, Interface: "eth1", VirtualIPs: []string{"192.168.2.100"}},
+	} {
+		if _, err := m.Add(cfg); err != nil {
+			t.Fatalf("Add(%s/%d): %v", cfg.Interface, cfg.VRID, err)
+		}
+	}
+
+	_, err := m.Add(&Config{VRID: 20, Interface: "eth0", VirtualIPs: []string{"192.168.1.102"}})
+	if !errors.Is(err, ErrRouterExists) {
+		t.Errorf("Add of a duplicate = %v, want ErrRouterExists", err)
+	}
+
+	if err := m.Remove("eth0", 20); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := m.Remove("eth0", 20); !errors.Is(err, ErrRouterNotFound) {
+		t.Errorf("Remove of an unknown router = %v, want ErrRouterNotFound", err)
+	}
+
+	var got []string
+	for _, st := range m.Status() {
+		got = append(got, fmt.Sprintf("%s/%d", st.Interface, st.VRID))
+	}
+	if strings.Join(got, ",") != "eth0/10,eth1/10" {
+		t.Errorf("Status() routers = %v, want eth0/10,eth1/10 in order added", got)
+	}
+
+	if m.Get("eth1", 10) == nil || m.Get("eth1", 20) != nil {
+		t.Error("Get did not find exactly the added router")
+	}
+}
+
+func TestLinkDispatch(t *testing.T) {
+	a := newTestRouter(t)
+	b := newTestRouter(t)
+	b.vrid = 20
+
+	l := &link{}
+	l.add(a)
+	l.add(b)
+
+	header := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	l.dispatch(header, marshalAdvert(t, 10, 100), net.ParseIP("10.0.0.1"))
+
+	if got := a.Counters().AdvertsReceived; got != 1 {
+		t.Errorf("router for VRID 10 received %d adverts, want 1", got)
+	}
+	if got := b.Counters().VRIDMismatches; got != 1 {
+		t.Errorf("router for VRID 20 counted %d VRID mismatches, want 1", got)
+	}
+
+	l.remove(a)
+	if len(l.routers) != 1 || l.routers[0] != b {
+		t.Errorf("remove left %d routers, want only VRID 20", len(l.routers))
+	}
+}
+
+// BenchmarkLinkDispatch is the receive path of a host running a router for
+// every VRID on one interface: each advertisement reaches all of them
+func BenchmarkLinkDispatch(b *testing.B) {
+	l := &link{}
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for vrid := 1; vrid <= 255; vrid++ {
+		vr, err := NewVirtualRouter(&Config{
+			VRID: uint8(vrid), Priority: 100, Interface: "test0", VirtualIPs: []string{"192.168.1.100"}, Logger: discard,
+			MaxAdvertRate: -1,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		vr.stateMachine = NewStateMachine(vr.vrid, vr.priority, vr.ips, &net.Interface{Index: 1, Name: "test0"})
+		l.add(vr)
+	}
+	data, err := NewPacket(VRRPv2, 10, 150, []net.IP{net.ParseIP("192.168.1.100").To4()}).Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	header := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	ownIP := net.ParseIP("10.0.0.1")
+	sm := l.routers[9].stateMachine
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.dispatch(header, data, ownIP)
+		<-sm.recvCh
+	}
+}
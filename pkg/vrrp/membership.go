@@ -0,0 +1,169 @@
+package vrrp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMembershipCheck is how often the VRRP socket's membership of the
+// multicast group is checked by default
+const DefaultMembershipCheck = 10 * time.Second
+
+// igmpPath lists the multicast groups joined on each interface
+var igmpPath = "/proc/net/igmp"
+
+// Membership says how a VRRP socket keeps its membership of the VRRP
+// multicast group. The socket joins with MCAST_JOIN_GROUP, so the kernel
+// reports the membership in whatever IGMP version the link runs, and repeats
+// each unsolicited report as many times as its robustness variable
+// (net.ipv4.igmp_qrv) says.
+type Membership struct {
+	// Check is how often the kernel's group table is checked for the
+	// membership, which is joined again if it is missing: the kernel drops
+	// memberships when an interface is taken down or replaced, which
+	// otherwise looks like a dead master. 0 means DefaultMembershipCheck and
+	// a negative interval disables the check.
+	Check time.Duration
+	// Refresh, if positive, is how often the membership is announced again,
+	// by leaving and joining the group, for switches that age out IGMP
+	// snooping entries on links without a querier
+	Refresh time.Duration
+}
+
+// hasMembership reports whether the kernel lists the VRRP group as joined on
+// the socket's interface. It errors if the interface is gone.
+func (n *Network) hasMembership() (bool, error) {
+	f, err := os.Open(igmpPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read multicast memberships: %w", err)
+	}
+	defer f.Close()
+	return igmpJoined(f, n.iface.Index, multicastGroup)
+}
+
+// igmpJoined reports whether r, in the format of /proc/net/igmp, lists group
+// as joined on the interface with index. It errors if the interface is not
+// listed at all, as happens once it is gone.
+func igmpJoined(r io.Reader, index int, group net.IP) (bool, error) {
+	// Groups are printed as the hex of the address read in host byte order
+	want := fmt.Sprintf("%08X", binary.NativeEndian.Uint32(group.To4()))
+	listed, current := false, false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") {
+			// An interface: "Idx Device : Count Querier"
+			idx, err := strconv.Atoi(fields[0])
+			current = err == nil && idx == index
+			listed = listed || current
+			continue
+		}
+		if current && fields[0] == want {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read multicast memberships: %w", err)
+	}
+	if !listed {
+		return false, fmt.Errorf("interface index %d has no multicast memberships", index)
+	}
+	return false, nil
+}
+
+// joinGroup joins the VRRP group on the socket's interface again, which the
+// kernel takes as a no-op if the socket is still a member
+func (n *Network) joinGroup() error {
+	group := &net.IPAddr{IP: multicastGroup}
+	if err := n.conn.JoinGroup(n.iface, group); err != nil && !errors.Is(err, unix.EADDRINUSE) {
+		return fmt.Errorf("failed to join multicast group: %w", err)
+	}
+	return nil
+}
+
+// reannounce leaves the VRRP group and joins it again, which makes the
+// kernel send a fresh membership report
+func (n *Network) reannounce() error {
+	group := &net.IPAddr{IP: multicastGroup}
+	if err := n.conn.LeaveGroup(n.iface, group); err != nil {
+		return fmt.Errorf("failed to leave multicast group: %w", err)
+	}
+	return n.joinGroup()
+}
+
+// watchMembership checks and refreshes the socket's membership of the VRRP
+// group as m says until ctx is canceled
+func (n *Network) watchMembership(ctx context.Context, m Membership) {
+	check := m.Check
+	if check == 0 {
+		check = DefaultMembershipCheck
+	}
+	var checkC, refreshC <-chan time.Time
+	if check > 0 {
+		t := time.NewTicker(check)
+		defer t.Stop()
+		checkC = t.C
+	}
+	if m.Refresh > 0 {
+		t := time.NewTicker(m.Refresh)
+		defer t.Stop()
+		refreshC = t.C
+	}
+	if checkC == nil && refreshC == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-checkC:
+			joined, err := n.hasMembership()
+			if err != nil {
+				n.logger.Error("Cannot check multicast membership", "err", err)
+				continue
+			}
+			if joined {
+				continue
+			}
+			n.membershipLost.Add(1)
+			if err := n.joinGroup(); err != nil {
+				n.logger.Error("Multicast membership lost; joining again failed", "err", err)
+				continue
+			}
+			n.logger.Warn("Multicast membership lost; joined again", "group", VRRPMulticastIPv4)
+		case <-refreshC:
+			if err := n.reannounce(); err != nil {
+				n.logger.Error("Failed to announce multicast membership", "err", err)
+			}
+		}
+	}
+}
+
+// membershipLoop watches the membership of a socket of the router's own
+// until the router stops
+func (vr *VirtualRouter) membershipLoop() {
+	defer vr.wg.Done()
+	vr.network.watchMembership(vr.ctx, vr.membership)
+}
+
+// MembershipLost returns how many times the socket was found out of the VRRP
+// multicast group and joined it again
+func (n *Network) MembershipLost() uint64 {
+	return n.membershipLost.Load()
+}
@@ -0,0 +1,85 @@
+package vrrp
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// igmpTable is /proc/net/igmp of a little-endian host with eth0 in the VRRP
+// group and lo not
+const igmpTable = `Idx	Device    : Count Querier	Group    Users Timer	Reporter
+1	lo        :     1      V3
+				010000E0     1 0:00000000		0
+2	eth0      :     2      V3
+				120000E0     1 0:00000000		0
+				010000E0     1 0:00000000		0
+`
+
+func TestIGMPJoined(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("the table is of a little-endian host")
+	}
+	for _, tc := range []struct {
+		index   int
+		want    bool
+		wantErr bool
+	}{
+		{2, true, false},
+		{1, false, false},
+		// An interface that is gone is not listed
+		{3, false, true},
+	} {
+		got, err := igmpJoined(strings.NewReader(igmpTable), tc.index, multicastGroup)
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("igmpJoined on index %d = %v, %v; want %v and error %v", tc.index, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestWatchMembership(t *testing.T) {
+	n := loopbackNetwork(t)
+	if joined, err := n.hasMembership(); err != nil || !joined {
+		t.Fatalf("hasMembership after opening = %v, %v; want joined", joined, err)
+	}
+
+	// The kernel dropping the membership looks like leaving the group
+	if err := n.conn.LeaveGroup(n.iface, &net.IPAddr{IP: multicastGroup}); err != nil {
+		t.Fatalf("LeaveGroup: %v", err)
+	}
+	if joined, _ := n.hasMembership(); joined {
+		t.Fatal("hasMembership after leaving = true")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.watchMembership(ctx, Membership{Check: 10 * time.Millisecond})
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for n.MembershipLost() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if lost := n.MembershipLost(); lost != 1 {
+		t.Errorf("MembershipLost = %d, want 1", lost)
+	}
+	if joined, err := n.hasMembership(); err != nil || !joined {
+		t.Errorf("hasMembership after watching = %v, %v; want joined again", joined, err)
+	}
+
+	// Announcing again leaves the socket a member
+	if err := n.reannounce(); err != nil {
+		t.Fatalf("reannounce: %v", err)
+	}
+	if joined, err := n.hasMembership(); err != nil || !joined {
+		t.Errorf("hasMembership after announcing = %v, %v; want joined", joined, err)
+	}
+}
@@ -0,0 +1,163 @@
+package vrrp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DropReason says why a packet was discarded
+type DropReason string
+
+const (
+	// DropDecode is a message too short or malformed to decode
+	DropDecode DropReason = "decode"
+	// DropVersion is a message of a VRRP version other than 2 or 3
+	DropVersion DropReason = "version"
+	// DropType is a message of a type other than advertisement
+	DropType DropReason = "type"
+	// DropChecksum is an advertisement with a bad checksum
+	DropChecksum DropReason = "checksum"
+	// DropTTL is an advertisement with an IP TTL other than 255
+	DropTTL DropReason = "ttl"
+	// DropVRIDMismatch is an advertisement for another virtual router on the link
+	DropVRIDMismatch DropReason = "vrid_mismatch"
+	// DropThrottled is an advertisement for the router from a source that
+	// exceeded Config.MaxAdvertRate
+	DropThrottled DropReason = "throttled"
+	// DropNotOnLink is an advertisement for the router from a source outside
+	// the interface's subnets, with Config.OnLinkCheck set to enforce
+	DropNotOnLink DropReason = "not_on_link"
+	// DropPeer is an advertisement for the router from a source outside
+	// Config.AllowedPeers
+	DropPeer DropReason = "peer_not_allowed"
+	// DropAuth is an advertisement for the router without a valid
+	// authentication trailer, with Config.AuthKeys set
+	DropAuth DropReason = "auth"
+	// DropReplay is an authenticated advertisement with a timestamp not
+	// newer than the last from its source or outside
+	// Config.AuthReplayWindow
+	DropReplay DropReason = "replay"
+	// DropForeignVersion is a VRRPv3 advertisement from a source that also
+	// advertises VRRPv2, or any with Config.VersionPolicy set to strict
+	DropForeignVersion DropReason = "foreign_version"
+	// DropInterval is a VRRPv2 advertisement with an interval other than
+	// the router's, with Config.IntervalCheck set to enforce
+	DropInterval DropReason = "advert_interval"
+	// DropAddressList is an advertisement with virtual IPs other than the
+	// router's, with Config.AddressCheck set to drop
+	DropAddressList DropReason = "address_list"
+	// DropQueueFull is a packet dropped because the state machine fell behind
+	DropQueueFull DropReason = "queue_full"
+	// DropOwn is one of the router's own advertisements, looped back by the
+	// socket. These are expected and not counted in Counters.PacketsDropped.
+	DropOwn DropReason = "own"
+)
+
+// DropReasons lists every DropReason, in the order a message is checked
+var DropReasons = []DropReason{
+	DropVersion, DropDecode, DropType, DropChecksum, DropTTL, DropOwn, DropVRIDMismatch, DropThrottled, DropNotOnLink,
+	DropPeer, DropAuth, DropReplay, DropForeignVersion, DropInterval, DropAddressList, DropQueueFull,
+}
+
+// Mismatch is a field of an accepted advertisement that differs from the
+// router's own configuration
+type Mismatch string
+
+const (
+	// MismatchAdvInterval is a different advertisement interval
+	MismatchAdvInterval Mismatch = "advert_interval"
+	// MismatchAddressList is a different set of virtual IPs
+	MismatchAddressList Mismatch = "address_list"
+)
+
+// VIPOp is an operation on a virtual IP
+type VIPOp string
+
+const (
+	VIPAdd    VIPOp = "add"
+	VIPDelete VIPOp = "delete"
+)
+
+// Metrics receives a virtual router's events as they happen, to bridge them
+// into a telemetry system. The methods are called from the router's own
+// goroutines, so they must be safe for concurrent use and must not block.
+type Metrics interface {
+	// StateChanged is called after every state transition
+	StateChanged(iface string, vrid uint8, old, new State)
+	// PriorityChanged is called when the router starts and whenever its
+	// priority is set
+	PriorityChanged(iface string, vrid uint8, priority uint8)
+	// AdvertSent is called for every advertisement put on the wire
+	AdvertSent(iface string, vrid uint8, priority uint8)
+	// AdvertReceived is called for every valid advertisement for this router
+	AdvertReceived(iface string, vrid uint8, priority uint8)
+	// PacketDropped is called for every packet discarded
+	PacketDropped(iface string, vrid uint8, reason DropReason)
+	// AdvertMismatch is called for every field of a received advertisement
+	// that differs from the router's configuration; the advertisement is
+	// still processed
+	AdvertMismatch(iface string, vrid uint8, field Mismatch)
+	// PeerAdvert is called for every valid advertisement for this router,
+	// with its source, version and priority, as it is added to the peer
+	// table
+	PeerAdvert(iface string, vrid uint8, peer net.IP, version, priority uint8)
+	// AdvertJitter is called for every advertisement from a peer after its
+	// first, with how far the time since the previous one is from the
+	// advertised interval
+	AdvertJitter(iface string, vrid uint8, peer net.IP, jitter time.Duration)
+	// FailoverLatency is called when the router takes over as MASTER after
+	// the master down timer fired, with the time until its virtual IPs were
+	// programmed
+	FailoverLatency(iface string, vrid uint8, latency time.Duration)
+	// SplitBrain is called when another router is found acting as MASTER
+	// while this one is
+	SplitBrain(iface string, vrid uint8, kind SplitBrainKind)
+	// VIPChanged is called after adding or removing a virtual IP; err is
+	// nil if it succeeded
+	VIPChanged(iface string, vrid uint8, op VIPOp, ip net.IP, err error)
+}
+
+// NopMetrics discards every event. It is used when Config.Metrics is nil.
+type NopMetrics struct{}
+
+func (NopMetrics) StateChanged(string, uint8, State, State)          {}
+func (NopMetrics) PriorityChanged(string, uint8, uint8)              {}
+func (NopMetrics) AdvertSent(string, uint8, uint8)                   {}
+func (NopMetrics) AdvertReceived(string, uint8, uint8)               {}
+func (NopMetrics) PacketDropped(string, uint8, DropReason)           {}
+func (NopMetrics) AdvertMismatch(string, uint8, Mismatch)            {}
+func (NopMetrics) PeerAdvert(string, uint8, net.IP, uint8, uint8)    {}
+func (NopMetrics) AdvertJitter(string, uint8, net.IP, time.Duration) {}
+func (NopMetrics) FailoverLatency(string, uint8, time.Duration)      {}
+func (NopMetrics) SplitBrain(string, uint8, SplitBrainKind)          {}
+func (NopMetrics) VIPChanged(string, uint8, VIPOp, net.IP, error)    {}
+
+// meteredAddresses reports every address change made through an
+// AddressManager and counts the failures
+type meteredAddresses struct {
+	AddressManager
+	metrics  Metrics
+	failures *atomic.Uint64
+	iface    string
+	vrid     uint8
+}
+
+func (m meteredAddresses) AddIP(ip net.IP) error {
+	err := m.AddressManager.AddIP(ip)
+	m.report(VIPAdd, ip, err)
+	return err
+}
+
+func (m meteredAddresses) DelIP(ip net.IP) error {
+	err := m.AddressManager.DelIP(ip)
+	m.report(VIPDelete, ip, err)
+	return err
+}
+
+func (m meteredAddresses) report(op VIPOp, ip net.IP, err error) {
+	if err != nil {
+		m.failures.Add(1)
+	}
+	m.metrics.VIPChanged(m.iface, m.vrid, op, ip, err)
+}
@@ -2,53 +2,90 @@ package vrrp
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"os"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
 )
 
 const (
 	VRRPMulticastIPv4 = "224.0.0.18"
 	VRRPProtocol      = 112
+
+	readTimeout = time.Second
 )
 
+// multicastGroup is VRRPMulticastIPv4, parsed once for the send path
+var multicastGroup = net.ParseIP(VRRPMulticastIPv4).To4()
+
+// multicastAddr is the destination of the advertisements a sender writes
+var multicastAddr = &unix.SockaddrInet4{Addr: [4]byte(multicastGroup)}
+
+// Transport carries a router's VRRP messages in place of the socket Start
+// opens on its interface (Config.Transport): a *Network the caller opened
+// itself, e.g. in another network namespace, or an in-memory link for
+// tests. *Network is one.
+type Transport interface {
+	// GetInterface returns the interface the messages go out on
+	GetInterface() *net.Interface
+	// GetSourceIP returns the IPv4 address advertisements are sent from,
+	// by which the router also recognizes its own looped back
+	GetSourceIP() net.IP
+	// WriteMessage sends msg, an IPv4 header followed by a VRRP message,
+	// to the VRRP multicast group. msg is reused once it returns.
+	WriteMessage(msg []byte) error
+	// ReceiveRaw reads the VRRP messages arriving on the interface until
+	// ctx is canceled, passing each to handler with its IP header. It is
+	// called once per Start and must return when ctx is canceled.
+	ReceiveRaw(ctx context.Context, handler func(header *ipv4.Header, payload []byte)) error
+}
+
 type Network struct {
 	iface    *net.Interface
+	pc       net.PacketConn
 	conn     *ipv4.RawConn
+	raw      syscall.RawConn
 	sourceIP net.IP
+	logger   *slog.Logger
+
+	decodeErrors   atomic.Uint64
+	membershipLost atomic.Uint64
 }
 
+// NewNetwork opens the VRRP raw socket on the interface, logging to slog.Default()
 func NewNetwork(ifaceName string) (*Network, error) {
-	iface, err := net.InterfaceByName(ifaceName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get interface %s: %w", ifaceName, err)
-	}
+	return newNetwork(ifaceName, slog.Default())
+}
 
-	addrs, err := iface.Addrs()
+func newNetwork(ifaceName string, logger *slog.Logger) (*Network, error) {
+	iface, sourceIP, err := lookupInterface(ifaceName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get interface addresses: %w", err)
-	}
-
-	var sourceIP net.IP
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok {
-			if ipv4 := ipnet.IP.To4(); ipv4 != nil {
-				sourceIP = ipv4
-				break
-			}
-		}
+		return nil, err
 	}
+	return openSocket(iface, sourceIP, logger)
+}
 
-	if sourceIP == nil {
-		return nil, fmt.Errorf("no IPv4 address found on interface %s", ifaceName)
+// newNetworkOn opens the VRRP socket on an interface the caller has
+// resolved (Config.NetInterface)
+func newNetworkOn(iface *net.Interface, logger *slog.Logger) (*Network, error) {
+	sourceIP, err := interfaceSourceIP(iface)
+	if err != nil {
+		return nil, err
 	}
+	return openSocket(iface, sourceIP, logger)
+}
 
+func openSocket(iface *net.Interface, sourceIP net.IP, logger *slog.Logger) (*Network, error) {
 	conn, err := net.ListenPacket("ip4:112", "0.0.0.0")
 	if err != nil {
-		return nil, fmt.Errorf("failed to listen for VRRP packets: %w", err)
+		return nil, wrapPermission(fmt.Errorf("failed to listen for VRRP packets: %w", err))
 	}
 
 	rawConn, err := ipv4.NewRawConn(conn)
@@ -59,10 +96,10 @@ func NewNetwork(ifaceName string) (*Network, error) {
 
 	if p, ok := conn.(*net.IPConn); ok {
 		if err := p.SetReadBuffer(256 * 1024); err != nil {
-			log.Printf("Failed to set read buffer: %v", err)
+			logger.Warn("Failed to set read buffer", "err", err)
 		}
 		if err := p.SetWriteBuffer(256 * 1024); err != nil {
-			log.Printf("Failed to set write buffer: %v", err)
+			logger.Warn("Failed to set write buffer", "err", err)
 		}
 	}
 
@@ -71,13 +108,108 @@ func NewNetwork(ifaceName string) (*Network, error) {
 		return nil, fmt.Errorf("failed to join multicast group: %w", err)
 	}
 
+	if err := rawConn.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to enable interface control messages: %w", err)
+	}
+
+	raw, err := syscallConn(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
 	return &Network{
 		iface:    iface,
+		pc:       conn,
 		conn:     rawConn,
+		raw:      raw,
 		sourceIP: sourceIP,
+		logger:   logger,
 	}, nil
 }
 
+// newNetworkFromFile wraps a VRRP socket inherited from another process,
+// which has already joined the multicast group. f is not closed.
+func newNetworkFromFile(ifaceName string, f *os.File, logger *slog.Logger) (*Network, error) {
+	iface, sourceIP, err := lookupInterface(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use inherited socket for %s: %w", ifaceName, err)
+	}
+
+	rawConn, err := ipv4.NewRawConn(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to create raw connection: %w", err)
+	}
+
+	raw, err := syscallConn(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &Network{
+		iface:    iface,
+		pc:       conn,
+		conn:     rawConn,
+		raw:      raw,
+		sourceIP: sourceIP,
+		logger:   logger,
+	}, nil
+}
+
+// syscallConn returns the file descriptor of conn, which senders write to
+// directly
+func syscallConn(conn net.PacketConn) (syscall.RawConn, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("socket %T has no file descriptor", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("failed to access socket: %w", err)
+	}
+	return raw, nil
+}
+
+// lookupInterface returns the named interface and its first IPv4 address,
+// the source of the advertisements
+func lookupInterface(name string) (*net.Interface, net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %w", ErrInterfaceNotFound, name, err)
+	}
+	sourceIP, err := interfaceSourceIP(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	return iface, sourceIP, nil
+}
+
+// interfaceSourceIP returns the first IPv4 address of iface
+func interfaceSourceIP(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface addresses: %w", err)
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if ipv4 := ipnet.IP.To4(); ipv4 != nil {
+				return ipv4, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w %s", ErrNoIPv4Address, iface.Name)
+}
+
 func joinMulticast(conn net.PacketConn, iface *net.Interface) error {
 	group := net.ParseIP(VRRPMulticastIPv4)
 	if group == nil {
@@ -100,43 +232,211 @@ func joinMulticast(conn net.PacketConn, iface *net.Interface) error {
 	return nil
 }
 
+// Close leaves the VRRP multicast group and closes the socket. It does both
+// even if leaving fails, returning every error.
 func (n *Network) Close() error {
-	if n.conn != nil {
-		return n.conn.Close()
+	if n.conn == nil {
+		return nil
+	}
+
+	var errs []error
+	group := &net.IPAddr{IP: net.ParseIP(VRRPMulticastIPv4)}
+	if err := n.conn.LeaveGroup(n.iface, group); err != nil {
+		errs = append(errs, fmt.Errorf("failed to leave multicast group: %w", err))
+	}
+	if err := n.release(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// release closes this process's socket without leaving the multicast group,
+// which a process the socket was handed to still relies on
+func (n *Network) release() error {
+	if n.conn == nil {
+		return nil
+	}
+	if err := n.conn.Close(); err != nil {
+		return fmt.Errorf("failed to close socket: %w", err)
 	}
 	return nil
 }
 
+// File returns a duplicate of the socket, to hand it to another process
+func (n *Network) File() (*os.File, error) {
+	fc, ok := n.pc.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("socket of %s cannot be passed on", n.iface.Name)
+	}
+	return fc.File()
+}
+
 func (n *Network) SendPacket(pkt *Packet) error {
-	data, err := pkt.Marshal()
+	_, _, err := n.send(pkt)
+	return err
+}
+
+// send multicasts pkt and returns the message sent with its IP header
+func (n *Network) send(pkt *Packet) (*ipv4.Header, []byte, error) {
+	return newSender(n).send(pkt)
+}
+
+// sender writes the advertisements of one router. The message is marshaled
+// with its IP header once and written again for as long as the state machine
+// hands over the same Packet, which it keeps until the priority, interval or
+// virtual IPs change, so a MASTER's steady advertisements allocate nothing.
+// An authenticated message is built with room for its trailer, which is
+// signed again before each write with a timestamp later than the last, even
+// if the clock steps back.
+// A sender is not safe for concurrent use.
+type sender struct {
+	t Transport
+	// sourceIP is the Transport's; raw is the socket of a Network, which
+	// the sender writes to directly
+	sourceIP net.IP
+	raw      syscall.RawConn
+	pkt      *Packet
+	header   ipv4.Header
+	// msg is the IP header followed by the VRRP message. The socket has
+	// IP_HDRINCL set; the kernel fills in the ID and checksum.
+	msg []byte
+
+	// auth, if set, holds the router's keys; signed is the one msg was
+	// built for and stamp the timestamp it was last signed with
+	auth   *atomic.Pointer[advertAuth]
+	signed *advertAuth
+	stamp  int64
+
+	// v3 makes the sender write the VRRPv2 Packets it is handed translated
+	// to VRRPv3, for VersionTranslate
+	v3 bool
+
+	// writeFn is write, bound once so that writing allocates no closure;
+	// err is its result
+	writeFn func(fd uintptr) bool
+	err     error
+}
+
+func newSender(t Transport) *sender {
+	s := &sender{t: t, sourceIP: t.GetSourceIP()}
+	if n, ok := t.(*Network); ok {
+		s.raw = n.raw
+	}
+	s.writeFn = s.write
+	return s
+}
+
+// send multicasts pkt and returns the message sent with its IP header, valid
+// until the next call
+func (s *sender) send(pkt *Packet) (*ipv4.Header, []byte, error) {
+	var a *advertAuth
+	if s.auth != nil {
+		a = s.auth.Load()
+	}
+	if pkt != s.pkt || a != s.signed {
+		if err := s.build(pkt, a); err != nil {
+			return nil, nil, err
+		}
+	}
+	if a != nil {
+		n := len(s.msg) - authTrailerLen
+		s.stamp = max(time.Now().UnixNano(), s.stamp+1)
+		a.sign(s.msg[n:], s.sourceIP, s.msg[ipv4.HeaderLen:n], time.Unix(0, s.stamp))
+	}
+
+	if err := s.writeMessage(); err != nil {
+		return nil, nil, fmt.Errorf("failed to send packet: %w", err)
+	}
+	return &s.header, s.msg[ipv4.HeaderLen:], nil
+}
+
+// writeMessage writes the message straight to the socket of a Network, or
+// else hands it to the Transport
+func (s *sender) writeMessage() error {
+	if s.raw == nil {
+		return s.t.WriteMessage(s.msg)
+	}
+	s.err = nil
+	if err := s.raw.Write(s.writeFn); err != nil {
+		return err
+	}
+	return s.err
+}
+
+// build marshals pkt into a new message, with room for a trailer if a is
+// set, leaving the previous one to whoever still holds it
+func (s *sender) build(pkt *Packet, a *advertAuth) error {
+	var data []byte
+	var err error
+	if s.v3 {
+		data, err = marshalV3(pkt, s.sourceIP)
+	} else {
+		data, err = pkt.Marshal()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to marshal packet: %w", err)
 	}
-
-	dst := net.ParseIP(VRRPMulticastIPv4)
-	if dst == nil {
-		return fmt.Errorf("invalid destination IP")
+	if a != nil {
+		data = append(data, make([]byte, authTrailerLen)...)
 	}
 
-	header := &ipv4.Header{
+	header := ipv4.Header{
 		Version:  ipv4.Version,
 		Len:      ipv4.HeaderLen,
 		TOS:      0xc0,
 		TotalLen: ipv4.HeaderLen + len(data),
 		TTL:      255,
 		Protocol: VRRPProtocol,
-		Dst:      dst,
-		Src:      n.sourceIP,
+		Dst:      multicastGroup,
+		Src:      s.sourceIP,
 	}
-
-	if err := n.conn.WriteTo(header, data, nil); err != nil {
-		return fmt.Errorf("failed to send packet: %w", err)
+	h, err := header.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal IP header: %w", err)
 	}
 
+	s.pkt, s.signed, s.header, s.msg = pkt, a, header, append(h, data...)
 	return nil
 }
 
-func (n *Network) ReceivePackets(ctx context.Context, handler func(*Packet)) error {
+func (s *sender) write(fd uintptr) bool {
+	s.err = unix.Sendto(int(fd), s.msg, 0, multicastAddr)
+	return s.err != unix.EAGAIN
+}
+
+// WriteMessage sends msg, an IPv4 header followed by a VRRP message, to the
+// VRRP multicast group
+func (n *Network) WriteMessage(msg []byte) error {
+	var sendErr error
+	err := n.raw.Write(func(fd uintptr) bool {
+		sendErr = unix.Sendto(int(fd), msg, 0, multicastAddr)
+		return sendErr != unix.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// ReceivePackets reads VRRP packets until ctx is canceled, passing each decoded
+// packet and the source address from its IP header to handler
+func (n *Network) ReceivePackets(ctx context.Context, handler func(pkt *Packet, src net.IP)) error {
+	return n.ReceiveRaw(ctx, func(header *ipv4.Header, payload []byte) {
+		pkt := &Packet{}
+		if err := pkt.Unmarshal(payload); err != nil {
+			n.decodeErrors.Add(1)
+			n.logger.Warn("Failed to unmarshal VRRP packet", "src", header.Src, "err", err)
+			return
+		}
+
+		handler(pkt, header.Src)
+	})
+}
+
+// ReceiveRaw reads VRRP packets arriving on the interface until ctx is canceled,
+// passing the IP header and the undecoded VRRP message to handler. The payload
+// buffer is reused for the next packet.
+func (n *Network) ReceiveRaw(ctx context.Context, handler func(header *ipv4.Header, payload []byte)) error {
 	buf := make([]byte, 1500)
 
 	for {
@@ -146,7 +446,12 @@ func (n *Network) ReceivePackets(ctx context.Context, handler func(*Packet)) err
 		default:
 		}
 
-		header, payload, _, err := n.conn.ReadFrom(buf)
+		// Wake up periodically so cancellation is noticed even when no packets arrive
+		if err := n.conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			return fmt.Errorf("failed to set read deadline: %w", err)
+		}
+
+		header, payload, cm, err := n.conn.ReadFrom(buf)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
@@ -161,13 +466,12 @@ func (n *Network) ReceivePackets(ctx context.Context, handler func(*Packet)) err
 			continue
 		}
 
-		pkt := &Packet{}
-		if err := pkt.Unmarshal(payload); err != nil {
-			log.Printf("Failed to unmarshal VRRP packet: %v", err)
+		// The raw socket sees VRRP traffic from every interface
+		if cm != nil && cm.IfIndex != 0 && cm.IfIndex != n.iface.Index {
 			continue
 		}
 
-		handler(pkt)
+		handler(header, payload)
 	}
 }
 
@@ -178,3 +482,8 @@ func (n *Network) GetInterface() *net.Interface {
 func (n *Network) GetSourceIP() net.IP {
 	return n.sourceIP
 }
+
+// DecodeErrors returns how many received packets could not be unmarshaled
+func (n *Network) DecodeErrors() uint64 {
+	return n.decodeErrors.Load()
+}
@@ -0,0 +1,149 @@
+package vrrp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// loopbackNetwork opens the VRRP socket on lo, skipping the test without the
+// privileges for it
+func loopbackNetwork(tb testing.TB) *Network {
+	tb.Helper()
+	n, err := newNetwork("lo", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		tb.Skipf("cannot open a VRRP socket on lo: %v", err)
+	}
+	tb.Cleanup(func() { _ = n.Close() })
+	return n
+}
+
+func TestSender(t *testing.T) {
+	n := loopbackNetwork(t)
+	s := newSender(n)
+	pkt := NewPacket(VRRPv2, 10, 100, nil)
+	pkt.IPAddresses = append(pkt.IPAddresses, n.GetSourceIP())
+	pkt.CountIPAddrs = 1
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	received := make(chan []byte, 1)
+	go func() {
+		_ = n.ReceiveRaw(ctx, func(header *ipv4.Header, payload []byte) {
+			if header.TTL == 255 && header.Dst.Equal(multicastGroup) {
+				select {
+				case received <- bytes.Clone(payload):
+				default:
+				}
+			}
+		})
+	}()
+
+	header, data, err := s.send(pkt)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	want, _ := pkt.Marshal()
+	if !bytes.Equal(data, want) {
+		t.Errorf("sent %x, want %x", data, want)
+	}
+	if !header.Src.Equal(n.GetSourceIP()) || header.TotalLen != ipv4.HeaderLen+len(want) {
+		t.Errorf("sent with header %v", header)
+	}
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, want) {
+			t.Errorf("received %x, want %x", got, want)
+		}
+	case <-ctx.Done():
+		t.Fatal("the advertisement did not loop back")
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { _, _, _ = s.send(pkt) }); allocs != 0 {
+		t.Errorf("sending the same advertisement allocates %v times, want 0", allocs)
+	}
+
+	// A different advertisement is marshaled again
+	changed := *pkt
+	changed.Priority = 50
+	if _, data, err := s.send(&changed); err != nil || data[2] != 50 {
+		t.Errorf("send after a priority change = %x, %v, want priority 50", data, err)
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+	n := loopbackNetwork(b)
+	s := newSender(n)
+	pkt := NewPacket(VRRPv2, 10, 100, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := s.send(pkt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSenderAuth(t *testing.T) {
+	n := loopbackNetwork(t)
+	s := newSender(n)
+	var auth atomic.Pointer[advertAuth]
+	s.auth = &auth
+	pkt := NewPacket(VRRPv2, 10, 100, nil)
+	want, _ := pkt.Marshal()
+
+	keys, _ := ParseAuthKeys([]string{testKey1})
+	auth.Store(newAdvertAuth(keys))
+	_, data, err := s.send(pkt)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if len(data) != len(want)+authTrailerLen || !bytes.Equal(data[:len(want)], want) {
+		t.Fatalf("sent %x, want %x and a trailer", data, want)
+	}
+	if !auth.Load().verify(n.GetSourceIP(), data[:len(want)], data[len(want):]) {
+		t.Error("the advertisement sent does not verify")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { _, _, _ = s.send(pkt) }); allocs != 0 {
+		t.Errorf("signing the same advertisement allocates %v times, want 0", allocs)
+	}
+
+	// Each advertisement is signed with a later timestamp
+	first := decodeAuthTrailer(data[len(want):]).Timestamp
+	_, data, _ = s.send(pkt)
+	if next := decodeAuthTrailer(data[len(want):]).Timestamp; !next.After(first) {
+		t.Errorf("signed at %v after %v, want later", next, first)
+	}
+
+	// Turning authentication off drops the trailer
+	auth.Store(nil)
+	if _, data, err := s.send(pkt); err != nil || !bytes.Equal(data, want) {
+		t.Errorf("send without keys = %x, %v, want %x", data, err, want)
+	}
+}
+
+func TestSenderV3(t *testing.T) {
+	n := loopbackNetwork(t)
+	s := newSender(n)
+	s.v3 = true
+	pkt := NewPacket(VRRPv2, 10, 100, []net.IP{n.GetSourceIP()})
+
+	_, data, err := s.send(pkt)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	want, _ := marshalV3(pkt, n.GetSourceIP())
+	if !bytes.Equal(data, want) {
+		t.Errorf("sent %x, want %x", data, want)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { _, _, _ = s.send(pkt) }); allocs != 0 {
+		t.Errorf("translating the same advertisement allocates %v times, want 0", allocs)
+	}
+}
@@ -0,0 +1,72 @@
+package vrrp
+
+import (
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Advert is an advertisement a router sent or received, passed to the
+// callbacks of SetAdvertReceivedCallback and SetAdvertSentCallback. Header,
+// Payload and Packet are only valid during the call and must not be
+// modified; copy what is kept.
+type Advert struct {
+	Time time.Time
+	// Header is the IP header, Payload the VRRP message it carried and
+	// Packet the message decoded
+	Header  *ipv4.Header
+	Payload []byte
+	Packet  *Packet
+	// Dropped is why a received advertisement was discarded, empty if the
+	// router accepted it. It is empty for those sent.
+	Dropped DropReason
+	// Translated marks the VRRPv3 copy of an advertisement sent for
+	// VersionTranslate
+	Translated bool
+}
+
+// SetAdvertReceivedCallback registers fn to be called with every
+// advertisement for the router's VRID it receives and decodes, whether it is
+// accepted or dropped, before the state machine sees it; its own looped back
+// are left out. It must be called before Start. fn runs on the receive loop,
+// shared on a Manager by the routers of the interface, and must return
+// quickly.
+func (vr *VirtualRouter) SetAdvertReceivedCallback(fn func(Advert)) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.onAdvertRecvCb = fn
+}
+
+// SetAdvertSentCallback registers fn to be called with every advertisement
+// the router sends, the priority 0 one as it stops included, once written to
+// the socket. It must be called before Start. fn runs on the send loop,
+// holding up the next advertisement, and must return quickly.
+func (vr *VirtualRouter) SetAdvertSentCallback(fn func(Advert)) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.onAdvertSentCb = fn
+}
+
+// observeReceived passes a received advertisement to the callback
+func (vr *VirtualRouter) observeReceived(header *ipv4.Header, payload []byte, pkt *Packet, dropped DropReason) {
+	if vr.onAdvertRecvCb == nil {
+		return
+	}
+	vr.onAdvertRecvCb(Advert{Time: time.Now(), Header: header, Payload: payload, Packet: pkt, Dropped: dropped})
+}
+
+// observeSent passes an advertisement sent to the callback. pkt is nil for a
+// translated copy, which is decoded from data.
+func (vr *VirtualRouter) observeSent(header *ipv4.Header, data []byte, pkt *Packet) {
+	if vr.onAdvertSentCb == nil {
+		return
+	}
+	a := Advert{Time: time.Now(), Header: header, Payload: data, Packet: pkt}
+	if pkt == nil {
+		a.Packet, a.Translated = &Packet{}, true
+		if err := a.Packet.Unmarshal(data); err != nil {
+			return
+		}
+	}
+	vr.onAdvertSentCb(a)
+}
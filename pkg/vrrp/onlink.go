@@ -0,0 +1,139 @@
+package vrrp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// OnLinkCheck selects what a router does with advertisements whose source is
+// outside the subnets of its interface. A router on the link sends with TTL
+// 255, which no router forwards, so such a source can only be spoofed or
+// misconfigured.
+type OnLinkCheck string
+
+const (
+	// OnLinkOff does not check the source (the default)
+	OnLinkOff OnLinkCheck = "off"
+	// OnLinkCount counts advertisements from off-link sources in
+	// Stats.OffLinkAdverts but still accepts them, to find out whether
+	// enforcing would drop a legitimate peer
+	OnLinkCount OnLinkCheck = "count"
+	// OnLinkEnforce counts them and drops them as DropNotOnLink
+	OnLinkEnforce OnLinkCheck = "enforce"
+)
+
+// OnLinkChecks lists the valid values of Config.OnLinkCheck
+var OnLinkChecks = []OnLinkCheck{OnLinkOff, OnLinkCount, OnLinkEnforce}
+
+func validateOnLinkCheck(c OnLinkCheck) error {
+	if c == "" || slices.Contains(OnLinkChecks, c) {
+		return nil
+	}
+	return fmt.Errorf("%w: unknown on-link check %q", ErrInvalidConfig, c)
+}
+
+// onLinkRefresh is how often, at most, a source outside the cached subnets
+// makes the router read the interface's addresses again, in case they
+// changed
+const onLinkRefresh = time.Second
+
+// onLinkSubnets caches the subnets of an interface for the on-link check
+type onLinkSubnets struct {
+	iface string
+	// lookup reads the interface's subnets; interfaceSubnets outside tests
+	lookup func(iface string) ([]netip.Prefix, error)
+
+	mu        sync.Mutex
+	subnets   []netip.Prefix
+	refreshed time.Time
+}
+
+// contains reports whether addr is in one of the interface's subnets. A miss
+// rereads them, so an address added to the interface is picked up. If they
+// cannot be read, every source is taken to be on the link.
+func (o *onLinkSubnets) contains(addr netip.Addr) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if subnetsContain(o.subnets, addr) {
+		return true
+	}
+	if now := time.Now(); now.Sub(o.refreshed) >= onLinkRefresh {
+		o.refreshed = now
+		subnets, err := o.lookup(o.iface)
+		if err != nil {
+			o.subnets = nil
+			return true
+		}
+		o.subnets = subnets
+	}
+	return o.subnets == nil || subnetsContain(o.subnets, addr)
+}
+
+func subnetsContain(subnets []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range subnets {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceSubnets returns the IPv4 subnets configured on an interface
+func interfaceSubnets(name string) ([]netip.Prefix, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var subnets []netip.Prefix
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		addr, _ := netip.AddrFromSlice(ipnet.IP.To4())
+		ones, _ := ipnet.Mask.Size()
+		subnets = append(subnets, netip.PrefixFrom(addr, ones).Masked())
+	}
+	return subnets, nil
+}
+
+// SetOnLinkCheck replaces Config.OnLinkCheck, taking effect with the next
+// advertisement received
+func (vr *VirtualRouter) SetOnLinkCheck(c OnLinkCheck) error {
+	if err := validateOnLinkCheck(c); err != nil {
+		return err
+	}
+	if c == "" {
+		c = OnLinkOff
+	}
+	vr.onLinkCheck.Store(c)
+	return nil
+}
+
+// OnLinkCheck returns what the router does with advertisements from off-link
+// sources
+func (vr *VirtualRouter) OnLinkCheck() OnLinkCheck {
+	c, _ := vr.onLinkCheck.Load().(OnLinkCheck)
+	return c
+}
+
+// dropOffLink checks the source of an advertisement for the router against
+// the interface's subnets. It reports whether the advertisement is to be
+// dropped.
+func (vr *VirtualRouter) dropOffLink(src net.IP) bool {
+	check := vr.OnLinkCheck()
+	if check == OnLinkOff || vr.onLink.contains(peerKey(src)) {
+		return false
+	}
+	vr.offLinkAdverts.Add(1)
+	return check == OnLinkEnforce
+}
@@ -0,0 +1,82 @@
+package vrrp
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestOnLinkCheck(t *testing.T) {
+	vr := newTestRouter(t)
+	lookups := 0
+	vr.onLink.lookup = func(string) ([]netip.Prefix, error) {
+		lookups++
+		return []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}, nil
+	}
+
+	ownIP := net.ParseIP("10.0.0.1")
+	onLink := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	offLink := &ipv4.Header{Src: net.ParseIP("172.16.0.2"), TTL: 255}
+
+	// Off by default
+	vr.handleAdvert(offLink, marshalAdvert(t, 10, 100), ownIP)
+	if s := vr.GetStats(); s.AdvertsReceived != 1 || s.OffLinkAdverts != 0 || lookups != 0 {
+		t.Errorf("without the check: received %d, off link %d, %d lookups; want 1, 0, 0",
+			s.AdvertsReceived, s.OffLinkAdverts, lookups)
+	}
+
+	if err := vr.SetOnLinkCheck(OnLinkCount); err != nil {
+		t.Fatalf("SetOnLinkCheck: %v", err)
+	}
+	vr.handleAdvert(onLink, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(offLink, marshalAdvert(t, 10, 100), ownIP)
+	if s := vr.GetStats(); s.AdvertsReceived != 3 || s.OffLinkAdverts != 1 || s.Drops[DropNotOnLink] != 0 {
+		t.Errorf("counting: received %d, off link %d, dropped %d; want 3, 1, 0",
+			s.AdvertsReceived, s.OffLinkAdverts, s.Drops[DropNotOnLink])
+	}
+
+	if err := vr.SetOnLinkCheck(OnLinkEnforce); err != nil {
+		t.Fatalf("SetOnLinkCheck: %v", err)
+	}
+	vr.handleAdvert(onLink, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(offLink, marshalAdvert(t, 10, 100), ownIP)
+	if s := vr.GetStats(); s.AdvertsReceived != 4 || s.OffLinkAdverts != 2 || s.Drops[DropNotOnLink] != 1 {
+		t.Errorf("enforcing: received %d, off link %d, dropped %d; want 4, 2, 1",
+			s.AdvertsReceived, s.OffLinkAdverts, s.Drops[DropNotOnLink])
+	}
+
+	// Misses reread the subnets at most once per onLinkRefresh
+	if lookups != 1 {
+		t.Errorf("subnets read %d times, want once", lookups)
+	}
+
+	if err := vr.SetOnLinkCheck("strict"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("SetOnLinkCheck(strict) = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestOnLinkSubnetsUnreadable(t *testing.T) {
+	o := &onLinkSubnets{iface: "test0", lookup: func(string) ([]netip.Prefix, error) {
+		return nil, errors.New("no such interface")
+	}}
+	if !o.contains(netip.MustParseAddr("172.16.0.2")) {
+		t.Error("a source was taken to be off the link without knowing the subnets")
+	}
+}
+
+func TestInterfaceSubnets(t *testing.T) {
+	subnets, err := interfaceSubnets("lo")
+	if err != nil {
+		t.Skipf("no lo: %v", err)
+	}
+	want := netip.MustParsePrefix("127.0.0.0/8")
+	for _, p := range subnets {
+		if p == want {
+			return
+		}
+	}
+	t.Errorf("subnets of lo = %v, want %v among them", subnets, want)
+}
@@ -0,0 +1,208 @@
+package vrrp
+
+import (
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Option sets one Config field for New
+type Option func(*Config)
+
+// New creates a virtual router for vrid on iface, with the defaults of Config
+// for everything the options leave unset:
+//
+//	vr, err := vrrp.New("eth0", 10,
+//		vrrp.WithPriority(150),
+//		vrrp.WithVIPs("192.168.1.100"),
+//		vrrp.WithPreempt(true),
+//	)
+func New(iface string, vrid uint8, opts ...Option) (*VirtualRouter, error) {
+	return NewVirtualRouter(NewConfig(iface, vrid, opts...))
+}
+
+// NewConfig builds the Config New would use, for Manager.Add
+func NewConfig(iface string, vrid uint8, opts ...Option) *Config {
+	cfg := &Config{
+		Interface: iface,
+		VRID:      vrid,
+		Priority:  100,
+		Preempt:   true,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithPriority sets the priority, 1-255 (default 100)
+func WithPriority(priority uint8) Option {
+	return func(c *Config) { c.Priority = priority }
+}
+
+// WithVIPs adds virtual IPs; at least one is required
+func WithVIPs(addrs ...string) Option {
+	return func(c *Config) { c.VirtualIPs = append(c.VirtualIPs, addrs...) }
+}
+
+// WithExcludedIPs adds addresses held with the virtual IPs but not advertised
+func WithExcludedIPs(addrs ...string) Option {
+	return func(c *Config) { c.ExcludedIPs = append(c.ExcludedIPs, addrs...) }
+}
+
+// WithAdvertInterval sets the advertisement interval in seconds (default 1)
+func WithAdvertInterval(secs int) Option {
+	return func(c *Config) { c.AdvInterval = secs }
+}
+
+// WithPreempt sets whether a higher-priority backup preempts the master
+// (default true)
+func WithPreempt(preempt bool) Option {
+	return func(c *Config) { c.Preempt = preempt }
+}
+
+// WithPreemptWindows sets Config.PreemptWindows
+func WithPreemptWindows(windows ...string) Option {
+	return func(c *Config) { c.PreemptWindows = windows }
+}
+
+// WithVersion sets the VRRP version advertised, which must be VRRPv2; see
+// WithVersionPolicy for VRRPv3
+func WithVersion(version uint8) Option {
+	return func(c *Config) { c.Version = version }
+}
+
+// WithLogger sets Config.Logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) { c.Logger = logger }
+}
+
+// WithMetrics sets Config.Metrics
+func WithMetrics(m Metrics) Option {
+	return func(c *Config) { c.Metrics = m }
+}
+
+// WithObserve sets Config.Observe
+func WithObserve(observe bool) Option {
+	return func(c *Config) { c.Observe = observe }
+}
+
+// WithBackupOnly sets Config.BackupOnly
+func WithBackupOnly(backupOnly bool) Option {
+	return func(c *Config) { c.BackupOnly = backupOnly }
+}
+
+// WithDryRun sets Config.DryRun
+func WithDryRun(dryRun bool) Option {
+	return func(c *Config) { c.DryRun = dryRun }
+}
+
+// WithAddressBackend sets Config.AddressBackend
+func WithAddressBackend(b AddressBackend) Option {
+	return func(c *Config) { c.AddressBackend = b }
+}
+
+// WithVIPHooks sets Config.Hooks
+func WithVIPHooks(hooks VIPHooks) Option {
+	return func(c *Config) { c.Hooks = hooks }
+}
+
+// WithDetectVIPConflicts sets Config.DetectVIPConflicts
+func WithDetectVIPConflicts(detect bool) Option {
+	return func(c *Config) { c.DetectVIPConflicts = detect }
+}
+
+// WithSyncGroup sets Config.SyncGroup
+func WithSyncGroup(g *SyncGroup) Option {
+	return func(c *Config) { c.SyncGroup = g }
+}
+
+// WithResumeMaster sets Config.ResumeMaster
+func WithResumeMaster(resume bool) Option {
+	return func(c *Config) { c.ResumeMaster = resume }
+}
+
+// WithQueueLength sets Config.QueueLength
+func WithQueueLength(n int) Option {
+	return func(c *Config) { c.QueueLength = n }
+}
+
+// WithMaxAdvertRate sets Config.MaxAdvertRate
+func WithMaxAdvertRate(rate float64) Option {
+	return func(c *Config) { c.MaxAdvertRate = rate }
+}
+
+// WithAuthKeys sets Config.AuthKeys, "ID:KEY" each
+func WithAuthKeys(keys ...string) Option {
+	return func(c *Config) { c.AuthKeys = keys }
+}
+
+// WithAuthReplayWindow sets Config.AuthReplayWindow
+func WithAuthReplayWindow(window time.Duration) Option {
+	return func(c *Config) { c.AuthReplayWindow = window }
+}
+
+// WithOnLinkCheck sets Config.OnLinkCheck
+func WithOnLinkCheck(check OnLinkCheck) Option {
+	return func(c *Config) { c.OnLinkCheck = check }
+}
+
+// WithIntervalCheck sets Config.IntervalCheck
+func WithIntervalCheck(check IntervalCheck) Option {
+	return func(c *Config) { c.IntervalCheck = check }
+}
+
+// WithVersionPolicy sets Config.VersionPolicy
+func WithVersionPolicy(policy VersionPolicy) Option {
+	return func(c *Config) { c.VersionPolicy = policy }
+}
+
+// WithAddressCheck sets Config.AddressCheck
+func WithAddressCheck(check AddressCheck) Option {
+	return func(c *Config) { c.AddressCheck = check }
+}
+
+// WithGARP sets Config.GARP
+func WithGARP(mode GARPMode) Option {
+	return func(c *Config) { c.GARP = mode }
+}
+
+// WithTrackers adds trackers, in the syntax of ParseTracker
+func WithTrackers(specs ...string) Option {
+	return func(c *Config) { c.Trackers = append(c.Trackers, specs...) }
+}
+
+// WithAllowedPeers sets Config.AllowedPeers
+func WithAllowedPeers(peers ...string) Option {
+	return func(c *Config) { c.AllowedPeers = peers }
+}
+
+// WithKernelFilter sets Config.KernelFilter
+func WithKernelFilter(filter bool) Option {
+	return func(c *Config) { c.KernelFilter = filter }
+}
+
+// WithChaos sets Config.Chaos
+func WithChaos(chaos Chaos) Option {
+	return func(c *Config) { c.Chaos = chaos }
+}
+
+// WithNetInterface sets Config.NetInterface; New's iface may then be empty
+func WithNetInterface(iface *net.Interface) Option {
+	return func(c *Config) { c.NetInterface = iface }
+}
+
+// WithTransport sets Config.Transport; New's iface may then be empty
+func WithTransport(t Transport) Option {
+	return func(c *Config) { c.Transport = t }
+}
+
+// WithPriorityFunc sets Config.PriorityFunc
+func WithPriorityFunc(fn PriorityFunc) Option {
+	return func(c *Config) { c.PriorityFunc = fn }
+}
+
+// WithPriorityInterval sets Config.PriorityInterval
+func WithPriorityInterval(d time.Duration) Option {
+	return func(c *Config) { c.PriorityInterval = d }
+}
@@ -0,0 +1,38 @@
+package vrrp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	vr, err := New("eth0", 10,
+		WithPriority(150),
+		WithVIPs("192.168.1.100"),
+		WithVIPs("192.168.1.101"),
+		WithAdvertInterval(3),
+		WithPreempt(false),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if vr.GetVRID() != 10 || vr.GetPriority() != 150 || vr.GetAdvertInterval() != 3 || vr.GetPreempt() {
+		t.Errorf("New set vrid %d, priority %d, interval %d, preempt %v; want 10, 150, 3, false",
+			vr.GetVRID(), vr.GetPriority(), vr.GetAdvertInterval(), vr.GetPreempt())
+	}
+	if got := len(vr.GetVirtualIPs()); got != 2 {
+		t.Errorf("New set %d virtual IPs, want 2", got)
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	cfg := NewConfig("eth0", 10)
+	if cfg.Priority != 100 || !cfg.Preempt {
+		t.Errorf("NewConfig defaults: priority %d, preempt %v; want 100, true", cfg.Priority, cfg.Preempt)
+	}
+
+	if _, err := New("eth0", 10); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("New without VIPs = %v, want ErrInvalidConfig", err)
+	}
+}
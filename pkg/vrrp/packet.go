@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"slices"
+	"time"
 )
 
 const (
@@ -15,6 +17,18 @@ const (
 	TypeAdvertisement = 1
 )
 
+// MaxPacketSize is the largest valid VRRP message: a VRRPv3 advertisement
+// for 255 IPv6 addresses. Unmarshal rejects anything longer.
+const MaxPacketSize = 8 + 255*net.IPv6len
+
+// MaxVirtualIPs is the most addresses an advertisement carries, its count
+// field being a byte
+const MaxVirtualIPs = 255
+
+// authDataLen is the length of the authentication data ending a VRRPv2
+// advertisement
+const authDataLen = 8
+
 const (
 	StateInit   = 1
 	StateBackup = 2
@@ -28,10 +42,16 @@ type Packet struct {
 	Priority     uint8
 	CountIPAddrs uint8
 	AuthType     uint8
-	AdvInterval  uint8
-	Checksum     uint16
-	IPAddresses  []net.IP
-	AuthData     []byte
+	// AdvInterval is in seconds (8 bits) for VRRPv2 and in centiseconds
+	// (12 bits) for VRRPv3
+	AdvInterval uint16
+	Checksum    uint16
+	IPAddresses []net.IP
+	AuthData    []byte
+	// Auth is the authentication trailer of a received advertisement, nil
+	// if it has none (see Config.AuthKeys). Marshal leaves it out: the
+	// router signs each advertisement as it sends it.
+	Auth *AuthTrailer
 }
 
 func NewPacket(version, vrid, priority uint8, ips []net.IP) *Packet {
@@ -50,6 +70,12 @@ func (p *Packet) Marshal() ([]byte, error) {
 	if p.Version != VRRPv2 && p.Version != VRRPv3 {
 		return nil, fmt.Errorf("unsupported VRRP version: %d", p.Version)
 	}
+	if p.Version == VRRPv2 && p.AdvInterval > 0xFF {
+		return nil, fmt.Errorf("advertisement interval %d does not fit VRRPv2", p.AdvInterval)
+	}
+	if p.AdvInterval > 0xFFF {
+		return nil, fmt.Errorf("advertisement interval %d does not fit VRRPv3", p.AdvInterval)
+	}
 
 	size := 8
 	for _, ip := range p.IPAddresses {
@@ -73,10 +99,10 @@ func (p *Packet) Marshal() ([]byte, error) {
 
 	if p.Version == VRRPv2 {
 		buf[4] = p.AuthType
-		buf[5] = p.AdvInterval
+		buf[5] = uint8(p.AdvInterval)
 	} else {
-		buf[4] = (p.AdvInterval >> 4) & 0xF0
-		buf[5] = p.AdvInterval & 0xFF
+		buf[4] = uint8(p.AdvInterval >> 8)
+		buf[5] = uint8(p.AdvInterval)
 	}
 
 	offset := 8
@@ -100,65 +126,158 @@ func (p *Packet) Marshal() ([]byte, error) {
 	return buf, nil
 }
 
+// Interval returns the advertisement interval p carries
+func (p *Packet) Interval() time.Duration {
+	if p.Version == VRRPv2 {
+		return time.Duration(p.AdvInterval) * time.Second
+	}
+	return time.Duration(p.AdvInterval) * 10 * time.Millisecond
+}
+
+// Unmarshal decodes an advertisement received from the network. It checks
+// the length of data against the address count before decoding anything, so
+// a malformed message yields an error rather than a panic, and p shares no
+// memory with data.
+//
+// A VRRPv2 message carries IPv4 addresses followed by 8 bytes of
+// authentication data, which some senders omit. A VRRPv3 message carries
+// addresses of one family only, IPv4 or IPv6, told apart by its length.
+// Either may be followed by an authentication trailer.
+//
+// Some stacks advertise no addresses at all. Such a message, of 8 bytes
+// (16 with VRRPv2 authentication data), decodes with an empty IPAddresses;
+// the router accepts it for the election and counts it as an address list
+// mismatch.
 func (p *Packet) Unmarshal(data []byte) error {
 	if len(data) < 8 {
 		return fmt.Errorf("packet too short: %d bytes", len(data))
 	}
+	data, trailer := splitAuthTrailer(data)
+	if len(data) > MaxPacketSize {
+		return fmt.Errorf("packet too long: %d bytes", len(data))
+	}
 
-	p.Version = (data[0] >> 4) & 0x0F
-	p.Type = data[0] & 0x0F
-	p.VRID = data[1]
-	p.Priority = data[2]
-	p.CountIPAddrs = data[3]
+	version := data[0] >> 4
+	count := int(data[3])
+	addrLen := net.IPv4len
+	hasAuth := false
+	switch version {
+	case VRRPv2:
+		switch len(data) {
+		case 8 + count*net.IPv4len:
+		case 8 + count*net.IPv4len + authDataLen:
+			hasAuth = true
+		default:
+			return fmt.Errorf("packet length %d does not match %d IPv4 addresses", len(data), count)
+		}
+	case VRRPv3:
+		switch len(data) {
+		case 8 + count*net.IPv4len:
+		case 8 + count*net.IPv6len:
+			addrLen = net.IPv6len
+		default:
+			return fmt.Errorf("packet length %d does not match %d IPv4 or IPv6 addresses", len(data), count)
+		}
+	default:
+		return fmt.Errorf("unsupported VRRP version: %d", version)
+	}
 
-	if p.Version == VRRPv2 {
+	*p = Packet{
+		Version:      version,
+		Type:         data[0] & 0x0F,
+		VRID:         data[1],
+		Priority:     data[2],
+		CountIPAddrs: data[3],
+		Checksum:     binary.BigEndian.Uint16(data[6:8]),
+		IPAddresses:  make([]net.IP, count),
+	}
+	if version == VRRPv2 {
 		p.AuthType = data[4]
-		p.AdvInterval = data[5]
+		p.AdvInterval = uint16(data[5])
 	} else {
-		p.AdvInterval = uint8(((uint16(data[4]) & 0x0F) << 8) | uint16(data[5]))
+		p.AdvInterval = binary.BigEndian.Uint16(data[4:6]) & 0x0FFF
 	}
 
-	p.Checksum = binary.BigEndian.Uint16(data[6:8])
+	// The addresses and authentication data share one copy of the message
+	// body, each capped so appending to one cannot overwrite the next
+	body := slices.Clone(data[8:])
+	offset := 0
+	for i := range p.IPAddresses {
+		p.IPAddresses[i] = body[offset : offset+addrLen : offset+addrLen]
+		offset += addrLen
+	}
+	if hasAuth {
+		p.AuthData = body[offset : offset+authDataLen : offset+authDataLen]
+	}
+	if trailer != nil {
+		p.Auth = decodeAuthTrailer(trailer)
+	}
 
-	offset := 8
-	p.IPAddresses = make([]net.IP, p.CountIPAddrs)
+	return nil
+}
 
-	for i := 0; i < int(p.CountIPAddrs); i++ {
-		if p.Version == VRRPv2 || (p.Version == VRRPv3 && len(data[offset:]) >= 4) {
-			if offset+4 > len(data) {
-				return fmt.Errorf("insufficient data for IPv4 address")
-			}
-			p.IPAddresses[i] = net.IP(data[offset : offset+4])
-			offset += 4
-		} else {
-			if offset+16 > len(data) {
-				return fmt.Errorf("insufficient data for IPv6 address")
-			}
-			p.IPAddresses[i] = net.IP(data[offset : offset+16])
-			offset += 16
-		}
+// VerifyChecksum reports whether data, the message p was unmarshaled from, carries
+// a correct checksum. Only VRRPv2 checksums can be verified: VRRPv3 includes an IP
+// pseudo-header, so ok is false for other versions. The checksum does not
+// cover an authentication trailer.
+func (p *Packet) VerifyChecksum(data []byte) (valid, ok bool) {
+	if p.Version != VRRPv2 || len(data) < 8 {
+		return false, false
 	}
+	if p.Auth != nil {
+		data, _ = splitAuthTrailer(data)
+	}
+	return p.calculateChecksum(data) == p.Checksum, true
+}
 
-	if p.Version == VRRPv2 && offset+8 <= len(data) {
-		p.AuthData = data[offset : offset+8]
+// VerifyChecksumIPv4 is VerifyChecksum for a message that came in an IPv4
+// packet from src to dst: knowing the pseudo-header, it verifies VRRPv3
+// checksums too
+func (p *Packet) VerifyChecksumIPv4(data []byte, src, dst net.IP) bool {
+	if p.Version != VRRPv3 {
+		valid, _ := p.VerifyChecksum(data)
+		return valid
 	}
+	if p.Auth != nil {
+		data, _ = splitAuthTrailer(data)
+	}
+	return pseudoChecksum(src, dst, data) == p.Checksum
+}
 
-	return nil
+// pseudoChecksum is the VRRPv3 checksum of data sent from src to dst over
+// IPv4, which RFC 5798 section 5.2.8 computes over the pseudo-header too,
+// with data's checksum field taken as zero
+func pseudoChecksum(src, dst net.IP, data []byte) uint16 {
+	var sum uint32
+	pseudo := []byte{0, VRRPProtocol, byte(len(data) >> 8), byte(len(data))}
+	body := slices.Concat(data[:6], []byte{0, 0}, data[8:])
+	for _, b := range [][]byte{src.To4(), dst.To4(), pseudo, body} {
+		for i := 0; i < len(b); i += 2 {
+			sum += uint32(b[i]) << 8
+			if i+1 < len(b) {
+				sum += uint32(b[i+1])
+			}
+		}
+	}
+	for sum>>16 > 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
 }
 
+// calculateChecksum is the Internet checksum of data with its checksum field
+// taken as zero
 func (p *Packet) calculateChecksum(data []byte) uint16 {
-	temp := make([]byte, len(data))
-	copy(temp, data)
-	temp[6] = 0
-	temp[7] = 0
-
 	var sum uint32
-	for i := 0; i < len(temp)-1; i += 2 {
-		sum += uint32(temp[i])<<8 + uint32(temp[i+1])
+	for i := 0; i < len(data)-1; i += 2 {
+		if i == 6 {
+			continue
+		}
+		sum += uint32(data[i])<<8 + uint32(data[i+1])
 	}
 
-	if len(temp)%2 != 0 {
-		sum += uint32(temp[len(temp)-1]) << 8
+	if len(data)%2 != 0 {
+		sum += uint32(data[len(data)-1]) << 8
 	}
 
 	for (sum >> 16) > 0 {
@@ -1,8 +1,11 @@
 package vrrp
 
 import (
+	"bytes"
 	"net"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestNewPacket(t *testing.T) {
@@ -119,6 +122,45 @@ func TestPacketUnmarshalShortData(t *testing.T) {
 	}
 }
 
+func TestPacketUnmarshalNoAddresses(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		authData bool
+	}{
+		{"v2", []byte{0x21, 1, 100, 0, 0, 1, 0, 0}, false},
+		{"v2 with auth data", append([]byte{0x21, 1, 100, 0, 0, 1, 0, 0}, make([]byte, authDataLen)...), true},
+		{"v3", []byte{0x31, 1, 100, 0, 0, 100, 0, 0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := &Packet{}
+			if err := pkt.Unmarshal(tt.data); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if pkt.CountIPAddrs != 0 || len(pkt.IPAddresses) != 0 || (pkt.AuthData != nil) != tt.authData {
+				t.Errorf("decoded %d addresses %v, auth data %v", pkt.CountIPAddrs, pkt.IPAddresses, pkt.AuthData)
+			}
+			if pkt.Priority != 100 || pkt.Interval() != time.Second {
+				t.Errorf("priority %d, interval %v; want 100 and 1s", pkt.Priority, pkt.Interval())
+			}
+		})
+	}
+
+	// And such a message survives a round trip
+	data, err := NewPacket(VRRPv2, 1, 100, nil).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	pkt := &Packet{}
+	if err := pkt.Unmarshal(data); err != nil || len(pkt.IPAddresses) != 0 {
+		t.Errorf("Unmarshal = %v with addresses %v, want none", err, pkt.IPAddresses)
+	}
+	if valid, ok := pkt.VerifyChecksum(data); !ok || !valid {
+		t.Errorf("VerifyChecksum = %v, %v; want a valid checksum", valid, ok)
+	}
+}
+
 func TestPacketChecksum(t *testing.T) {
 	ips := []net.IP{
 		net.ParseIP("10.0.0.1").To4(),
@@ -146,3 +188,200 @@ func TestPacketChecksum(t *testing.T) {
 		t.Errorf("Checksum mismatch: expected %d, got %d", checksum, decoded.Checksum)
 	}
 }
+
+func TestPacketVerifyChecksum(t *testing.T) {
+	pkt := NewPacket(VRRPv2, 1, 200, []net.IP{net.ParseIP("10.0.0.1").To4()})
+
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal packet: %v", err)
+	}
+
+	decoded := &Packet{}
+	if err := decoded.Unmarshal(data); err != nil {
+		t.Fatalf("Failed to unmarshal packet: %v", err)
+	}
+	if valid, ok := decoded.VerifyChecksum(data); !ok || !valid {
+		t.Errorf("Expected valid checksum, got valid=%v ok=%v", valid, ok)
+	}
+
+	data[2]++ // corrupt the priority
+	if valid, _ := decoded.VerifyChecksum(data); valid {
+		t.Error("Expected checksum mismatch after corrupting the packet")
+	}
+}
+
+func TestPacketUnmarshalV3Interval(t *testing.T) {
+	pkt := NewPacket(VRRPv3, 1, 100, []net.IP{net.ParseIP("2001:db8::1")})
+	pkt.AdvInterval = 0xABC
+
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal packet: %v", err)
+	}
+	if data[4]&0xF0 != 0 {
+		t.Errorf("Reserved bits set: %#x", data[4])
+	}
+
+	decoded := &Packet{}
+	if err := decoded.Unmarshal(data); err != nil {
+		t.Fatalf("Failed to unmarshal packet: %v", err)
+	}
+	if decoded.AdvInterval != 0xABC {
+		t.Errorf("AdvInterval = %#x, want 0xabc", decoded.AdvInterval)
+	}
+	if len(decoded.IPAddresses) != 1 || !decoded.IPAddresses[0].Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("IPAddresses = %v, want [2001:db8::1]", decoded.IPAddresses)
+	}
+
+	pkt.AdvInterval = 0x1000
+	if _, err := pkt.Marshal(); err == nil {
+		t.Error("Expected error for an interval over 12 bits, got nil")
+	}
+}
+
+func TestPacketUnmarshalMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		// Two addresses announced, one present
+		{"v2 count over payload", []byte{0x21, 1, 100, 2, 0, 1, 0, 0, 10, 0, 0, 1}},
+		{"v2 count 255 in a short message", append([]byte{0x21, 1, 100, 255, 0, 1, 0, 0}, make([]byte, 16)...)},
+		// One address and 5 bytes of authentication data
+		{"v2 truncated auth data", append([]byte{0x21, 1, 100, 1, 0, 1, 0, 0, 10, 0, 0, 1}, make([]byte, 5)...)},
+		{"v2 trailing bytes", append([]byte{0x21, 1, 100, 1, 0, 1, 0, 0, 10, 0, 0, 1}, make([]byte, 12)...)},
+		// Neither two IPv4 nor two IPv6 addresses
+		{"v3 mixed lengths", append([]byte{0x31, 1, 100, 2, 0, 100, 0, 0}, make([]byte, 20)...)},
+		{"v3 count over payload", append([]byte{0x31, 1, 100, 3, 0, 100, 0, 0}, make([]byte, 16)...)},
+		{"unknown version", []byte{0x41, 1, 100, 0, 0, 1, 0, 0}},
+		{"too long", append([]byte{0x31, 1, 100, 0, 0, 100, 0, 0}, make([]byte, MaxPacketSize)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := &Packet{}
+			if err := pkt.Unmarshal(tt.data); err == nil {
+				t.Errorf("Expected error, got %+v", pkt)
+			}
+		})
+	}
+}
+
+func TestPacketUnmarshalCopies(t *testing.T) {
+	pkt := NewPacket(VRRPv2, 1, 100, []net.IP{net.ParseIP("10.0.0.1").To4()})
+	pkt.AuthData = []byte("password")
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal packet: %v", err)
+	}
+
+	decoded := &Packet{}
+	if err := decoded.Unmarshal(data); err != nil {
+		t.Fatalf("Failed to unmarshal packet: %v", err)
+	}
+	// The receive buffer is reused for the next message
+	clear(data)
+	if !decoded.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) || string(decoded.AuthData) != "password" {
+		t.Errorf("decoded packet changed with its buffer: %v %q", decoded.IPAddresses, decoded.AuthData)
+	}
+}
+
+// FuzzUnmarshal checks that no message makes Unmarshal panic, and that a
+// message it accepts survives a round trip through Marshal
+func FuzzUnmarshal(f *testing.F) {
+	seeds := []*Packet{
+		NewPacket(VRRPv2, 1, 100, []net.IP{net.ParseIP("10.0.0.1").To4()}),
+		NewPacket(VRRPv2, 255, 0, nil),
+		NewPacket(VRRPv3, 10, 200, []net.IP{net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4()}),
+		NewPacket(VRRPv3, 10, 200, []net.IP{net.ParseIP("2001:db8::1")}),
+	}
+	for _, pkt := range seeds {
+		data, err := pkt.Marshal()
+		if err != nil {
+			f.Fatalf("Failed to marshal seed: %v", err)
+		}
+		f.Add(data)
+		// VRRPv2 without authentication data
+		if pkt.Version == VRRPv2 {
+			f.Add(data[:len(data)-authDataLen])
+		}
+	}
+	f.Add([]byte{0x21, 1, 100, 255, 0, 1, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt := &Packet{}
+		if err := pkt.Unmarshal(data); err != nil {
+			return
+		}
+		if int(pkt.CountIPAddrs) != len(pkt.IPAddresses) {
+			t.Fatalf("CountIPAddrs %d with %d addresses", pkt.CountIPAddrs, len(pkt.IPAddresses))
+		}
+
+		out, err := pkt.Marshal()
+		if err != nil {
+			t.Fatalf("Failed to marshal an unmarshaled packet: %v", err)
+		}
+		again := &Packet{}
+		if err := again.Unmarshal(out); err != nil {
+			t.Fatalf("Failed to unmarshal a marshaled packet: %v", err)
+		}
+		if again.Version != pkt.Version || again.Type != pkt.Type || again.VRID != pkt.VRID ||
+			again.Priority != pkt.Priority || again.AuthType != pkt.AuthType ||
+			again.AdvInterval != pkt.AdvInterval || !slices.EqualFunc(again.IPAddresses, pkt.IPAddresses, net.IP.Equal) {
+			t.Fatalf("round trip changed the packet: %+v became %+v", pkt, again)
+		}
+		if pkt.AuthData != nil && !bytes.Equal(again.AuthData, pkt.AuthData) {
+			t.Fatalf("round trip changed the authentication data: %q became %q", pkt.AuthData, again.AuthData)
+		}
+	})
+}
+
+// benchPacket is a typical advertisement: VRRPv2 with two addresses and
+// authentication data
+func benchPacket() *Packet {
+	return NewPacket(VRRPv2, 10, 100, []net.IP{
+		net.ParseIP("192.168.1.100").To4(),
+		net.ParseIP("192.168.1.101").To4(),
+	})
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	pkt := benchPacket()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := pkt.Marshal(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data, err := benchPacket().Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var pkt Packet
+		if err := pkt.Unmarshal(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifyChecksum(b *testing.B) {
+	pkt := benchPacket()
+	data, err := pkt.Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := pkt.Unmarshal(data); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if valid, _ := pkt.VerifyChecksum(data); !valid {
+			b.Fatal("checksum does not verify")
+		}
+	}
+}
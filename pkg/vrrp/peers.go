@@ -0,0 +1,121 @@
+package vrrp
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MaxPeers bounds the peer table of a router. Once it is full, a new router
+// replaces the one heard from least recently, so forged sources cannot grow
+// it without limit.
+const MaxPeers = 32
+
+// KnownPeer is an entry of the peer table: a router heard advertising for the
+// VRID since the router was created
+type KnownPeer struct {
+	SourceIP net.IP
+	// Priority, Version and AdvInterval are those of the last advertisement
+	Priority    uint8
+	Version     uint8
+	AdvInterval time.Duration
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Adverts     uint64
+}
+
+// peerTable holds the routers heard for the VRID by source address.
+// vr.statsMu guards it.
+type peerTable map[netip.Addr]*KnownPeer
+
+// peerKey is src as a map key. Unlike src.String() it does not allocate,
+// which matters on the receive path.
+func peerKey(src net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(src)
+	return addr.Unmap()
+}
+
+// observe records an advertisement from src. It reports whether src is new
+// to the table.
+func (t peerTable) observe(pkt *Packet, src net.IP, now time.Time) bool {
+	key := peerKey(src)
+	p, ok := t[key]
+	if !ok {
+		if len(t) >= MaxPeers {
+			t.evictOldest()
+		}
+		p = &KnownPeer{SourceIP: slices.Clone(src), FirstSeen: now}
+		t[key] = p
+	}
+
+	p.Priority = pkt.Priority
+	p.Version = pkt.Version
+	p.AdvInterval = pkt.Interval()
+	p.LastSeen = now
+	p.Adverts++
+	return !ok
+}
+
+func (t peerTable) evictOldest() {
+	var oldest *KnownPeer
+	var key netip.Addr
+	for k, p := range t {
+		if oldest == nil || p.LastSeen.Before(oldest.LastSeen) {
+			oldest, key = p, k
+		}
+	}
+	delete(t, key)
+}
+
+// snapshot returns copies of the entries ordered by source address
+func (t peerTable) snapshot() []KnownPeer {
+	peers := make([]KnownPeer, 0, len(t))
+	for _, p := range t {
+		c := *p
+		c.SourceIP = slices.Clone(p.SourceIP)
+		peers = append(peers, c)
+	}
+	slices.SortFunc(peers, func(a, b KnownPeer) int {
+		return bytes.Compare(a.SourceIP.To16(), b.SourceIP.To16())
+	})
+	return peers
+}
+
+// peerWarnings remembers the value each peer was last warned about, so a
+// peer is logged once rather than with every advertisement, and again if the
+// value changes. Past MaxPeers sources nothing more is logged, so forged
+// sources cannot flood the log.
+type peerWarnings[V comparable] struct {
+	mu     sync.Mutex
+	logged map[netip.Addr]V
+}
+
+// first reports whether src advertising v, which the router objects to, is
+// to be logged
+func (w *peerWarnings[V]) first(src netip.Addr, v V) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	last, ok := w.logged[src]
+	if ok && last == v {
+		return false
+	}
+	if !ok && len(w.logged) >= MaxPeers {
+		return false
+	}
+	if w.logged == nil {
+		w.logged = make(map[netip.Addr]V)
+	}
+	w.logged[src] = v
+	return true
+}
+
+// agreed forgets src once it advertises what the router expects, so a later
+// mismatch is logged again
+func (w *peerWarnings[V]) agreed(src netip.Addr) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.logged, src)
+}
@@ -0,0 +1,195 @@
+package vrrp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PreemptWindow is a weekly period during which a BACKUP may preempt a
+// lower-priority master, parsed by ParsePreemptWindow
+type PreemptWindow struct {
+	// Days are the weekdays the window opens on, indexed by time.Weekday
+	Days [7]bool
+	// Start and End are the times of day, on the host's clock, the window
+	// opens and closes. An End not after Start closes the next day.
+	Start, End time.Duration
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParsePreemptWindow parses "[DAYS ]HH:MM-HH:MM", DAYS being a
+// comma-separated list of weekdays and ranges of them, e.g. "Mon-Fri
+// 02:00-04:00" or "Sat,Sun 22:00-06:00". Without DAYS the window opens
+// every day.
+func ParsePreemptWindow(s string) (PreemptWindow, error) {
+	var w PreemptWindow
+	fail := func(format string, args ...any) (PreemptWindow, error) {
+		return PreemptWindow{}, fmt.Errorf("%w: invalid preemption window %q: %s", ErrInvalidConfig, s,
+			fmt.Sprintf(format, args...))
+	}
+
+	fields := strings.Fields(s)
+	var days, times string
+	switch len(fields) {
+	case 1:
+		days, times = "sun-sat", fields[0]
+	case 2:
+		days, times = fields[0], fields[1]
+	default:
+		return fail("want [DAYS ]HH:MM-HH:MM")
+	}
+
+	for _, part := range strings.Split(days, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := parseWeekday(from)
+		last := first
+		if isRange {
+			var okTo bool
+			last, okTo = parseWeekday(to)
+			ok = ok && okTo
+		}
+		if !ok {
+			return fail("unknown day %q", part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.Days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	from, to, ok := strings.Cut(times, "-")
+	if !ok {
+		return fail("want HH:MM-HH:MM")
+	}
+	var err error
+	if w.Start, err = parseTimeOfDay(from); err != nil {
+		return fail("%v", err)
+	}
+	if w.End, err = parseTimeOfDay(to); err != nil {
+		return fail("%v", err)
+	}
+	if w.Start == 24*time.Hour {
+		return fail("the window cannot open at 24:00")
+	}
+	if w.Start == w.End {
+		return fail("the window is empty")
+	}
+	return w, nil
+}
+
+// ParsePreemptWindows parses Config.PreemptWindows. It returns nil for no
+// restriction.
+func ParsePreemptWindows(specs []string) ([]PreemptWindow, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	windows := make([]PreemptWindow, len(specs))
+	for i, s := range specs {
+		w, err := ParsePreemptWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		windows[i] = w
+	}
+	return windows, nil
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	for i, name := range weekdayNames {
+		if strings.EqualFold(s, name) {
+			return time.Weekday(i), true
+		}
+	}
+	return 0, false
+}
+
+// parseTimeOfDay parses HH:MM, 24:00 included, as the time since midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * time.Hour, nil
+		}
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether the window is open at t, on t's clock
+func (w PreemptWindow) Contains(t time.Time) bool {
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	if w.Start < w.End {
+		return w.Days[day] && now >= w.Start && now < w.End
+	}
+	// Opened today, or opened yesterday and closing today
+	return w.Days[day] && now >= w.Start || w.Days[(day+6)%7] && now < w.End
+}
+
+// String formats the window as ParsePreemptWindow parses it
+func (w PreemptWindow) String() string {
+	var days []string
+	for d, on := range w.Days {
+		if on {
+			name := weekdayNames[d]
+			days = append(days, strings.ToUpper(name[:1])+name[1:])
+		}
+	}
+	hhmm := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	times := hhmm(w.Start) + "-" + hhmm(w.End)
+	if len(days) == 7 {
+		return times
+	}
+	return strings.Join(days, ",") + " " + times
+}
+
+// preemptOpen reports whether any of windows is open at t; none means
+// preemption is never restricted
+func preemptOpen(windows []PreemptWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPreemptWindows replaces Config.PreemptWindows, taking effect with the
+// next advertisement received. Empty lets the router preempt at any time.
+func (vr *VirtualRouter) SetPreemptWindows(specs []string) error {
+	windows, err := ParsePreemptWindows(specs)
+	if err != nil {
+		return err
+	}
+	vr.setPreemptWindows(windows)
+	return nil
+}
+
+func (vr *VirtualRouter) setPreemptWindows(windows []PreemptWindow) {
+	vr.mu.Lock()
+	vr.preemptWindows = windows
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if sm != nil {
+		sm.SetPreemptWindows(windows)
+	}
+}
+
+// PreemptWindows returns the windows preemption is restricted to, nil if
+// it is not
+func (vr *VirtualRouter) PreemptWindows() []PreemptWindow {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	return slices.Clone(vr.preemptWindows)
+}
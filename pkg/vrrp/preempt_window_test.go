@@ -0,0 +1,95 @@
+package vrrp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParsePreemptWindow(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want string
+	}{
+		{"02:00-04:00", "02:00-04:00"},
+		{"Mon-Fri 02:00-04:00", "Mon,Tue,Wed,Thu,Fri 02:00-04:00"},
+		{"sat,SUN 22:00-06:00", "Sun,Sat 22:00-06:00"},
+		{"Fri-Mon 20:00-24:00", "Sun,Mon,Fri,Sat 20:00-24:00"},
+		{"Wed 9:30-10:00", "Wed 09:30-10:00"},
+	} {
+		w, err := ParsePreemptWindow(tc.spec)
+		if err != nil {
+			t.Errorf("ParsePreemptWindow(%q): %v", tc.spec, err)
+			continue
+		}
+		if got := w.String(); got != tc.want {
+			t.Errorf("ParsePreemptWindow(%q) = %s, want %s", tc.spec, got, tc.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"", "Mon", "Mon-Fri 02:00", "Mon 02:00-02:00", "Mon 24:00-02:00", "Mon 02:00-25:00",
+		"Funday 02:00-04:00", "Mon-Fri 02:00-04:00 UTC",
+	} {
+		if _, err := ParsePreemptWindow(spec); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParsePreemptWindow(%q) = %v, want ErrInvalidConfig", spec, err)
+		}
+	}
+}
+
+func TestPreemptWindowContains(t *testing.T) {
+	weeknights, err := ParsePreemptWindow("Mon-Fri 22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-03-02 is a Monday
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(2, 21, 59), false},
+		{at(2, 22, 0), true},
+		{at(3, 1, 59), true}, // Tuesday, in Monday's window
+		{at(3, 2, 0), false},
+		{at(2, 1, 0), false}, // Monday, Sunday's window is not open
+		{at(7, 1, 0), true},  // Saturday, in Friday's window
+		{at(7, 22, 0), false},
+	} {
+		if got := weeknights.Contains(tc.t); got != tc.want {
+			t.Errorf("Contains(%s) = %t, want %t", tc.t.Format("Mon 15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestPreemptWindows(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetAddressManager(NopAddresses{})
+	sm.handleEvent(EventStartup)
+
+	// Windows an hour either side of now, on every day
+	now := time.Now()
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	window := func(from, to time.Duration) PreemptWindow {
+		w := PreemptWindow{Start: (clock + from + 24*time.Hour) % (24 * time.Hour),
+			End: (clock + to + 24*time.Hour) % (24 * time.Hour)}
+		for d := range w.Days {
+			w.Days[d] = true
+		}
+		return w
+	}
+
+	sm.SetPreemptWindows([]PreemptWindow{window(time.Hour, 2*time.Hour)})
+	sm.handlePacket(&Packet{VRID: 10, Priority: 50})
+	if sm.preempting {
+		t.Error("a lower-priority master is preempted outside the windows")
+	}
+
+	sm.SetPreemptWindows([]PreemptWindow{window(time.Hour, 2*time.Hour), window(-time.Hour, time.Hour)})
+	sm.handlePacket(&Packet{VRID: 10, Priority: 50})
+	if !sm.preempting {
+		t.Error("a lower-priority master is not preempted within a window")
+	}
+}
@@ -0,0 +1,79 @@
+package vrrp
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultAuthReplayWindow is how far the timestamp of an authenticated
+// advertisement may be from the receiver's clock unless
+// Config.AuthReplayWindow says otherwise
+const DefaultAuthReplayWindow = 30 * time.Second
+
+// replayGuard rejects authenticated advertisements played back from a
+// capture. Each router timestamps its advertisements in strictly increasing
+// order, so one that is not newer than the last accepted from its source is
+// a replay; a timestamp outside the window of the receiver's clock is too,
+// which covers a receiver that has not heard the source since it started.
+// Only verified advertisements reach it, so the table holds key holders.
+type replayGuard struct {
+	// window is the largest accepted difference between a timestamp and
+	// now, zero for none
+	window time.Duration
+
+	mu   sync.Mutex
+	last map[netip.Addr]int64
+}
+
+// newReplayGuard returns a guard with the given window; a negative one
+// only checks that timestamps increase
+func newReplayGuard(window time.Duration) *replayGuard {
+	if window == 0 {
+		window = DefaultAuthReplayWindow
+	}
+	return &replayGuard{window: max(window, 0), last: make(map[netip.Addr]int64)}
+}
+
+// replayReason says why the guard rejected an advertisement
+type replayReason string
+
+const (
+	replayFresh    replayReason = ""
+	replayRepeated replayReason = "not newer than the last from the source"
+	replayStale    replayReason = "outside the replay window"
+)
+
+// check records the timestamp of a verified advertisement from src
+// received at now, or reports why it is a replay
+func (g *replayGuard) check(src netip.Addr, stamp time.Time, now time.Time) replayReason {
+	if g.window > 0 {
+		if d := now.Sub(stamp); d > g.window || d < -g.window {
+			return replayStale
+		}
+	}
+	ns := stamp.UnixNano()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if last, ok := g.last[src]; ok {
+		if ns <= last {
+			return replayRepeated
+		}
+		g.last[src] = ns
+		return replayFresh
+	}
+	if len(g.last) >= MaxPeers && g.window > 0 {
+		// A source not heard for the window has nothing left to replay
+		// that the window does not reject
+		for addr, last := range g.last {
+			if now.Sub(time.Unix(0, last)) > g.window {
+				delete(g.last, addr)
+			}
+		}
+	}
+	if len(g.last) < MaxPeers {
+		g.last[src] = ns
+	}
+	return replayFresh
+}
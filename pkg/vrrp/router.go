@@ -2,27 +2,281 @@ package vrrp
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net"
+	"net/netip"
+	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/ipv4"
 )
 
 type VirtualRouter struct {
-	mu       sync.RWMutex
-	vrid     uint8
-	priority uint8
-	ips      []net.IP
-	iface    string
+	mu          sync.RWMutex
+	vrid        uint8
+	priority    uint8
+	ips         []net.IP
+	excluded    []net.IP
+	iface       string
+	advInterval int
+	preempt     bool
+	dryRun      bool
+	observe     bool
+	backupOnly  bool
+	addresses   AddressBackend
+	arpCheck    bool
+	logger      *slog.Logger
+	metrics     Metrics
+	capture     PacketCapture
+	hooks       VIPHooks
+	chaos       Chaos
+	queueLength int
+	// kernelFilter asks for the advertisements of other VRIDs to be
+	// dropped by the kernel (Config.KernelFilter)
+	kernelFilter bool
+
+	// faults injects chaos into the messages received while running, if
+	// any is configured
+	faults *FaultInjector
 
+	// netIface is Config.NetInterface and injected Config.Transport
+	netIface *net.Interface
+	injected Transport
+
+	// transport carries the messages while running: network, the socket
+	// of the router's own or its link's, or else the injected one
+	transport    Transport
 	network      *Network
 	stateMachine *StateMachine
+	// sender writes the advertisements to transport, from the send loop
+	// and then teardown
+	sender *sender
+	// translator writes them again as VRRPv3, for VersionTranslate
+	translator *sender
+
+	// manager is set for routers created by Manager.Add; link is their
+	// shared socket while running
+	manager *Manager
+	link    *link
+
+	syncMember *syncMember
 
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	running bool
+	// stopped is closed once teardown has finished; stopErr holds the
+	// teardown steps that failed
+	stopped chan struct{}
+	stopErr error
+
+	// resumeMaster is Config.ResumeMaster until the first Start; detaching
+	// makes teardown leave the virtual IPs and the multicast group in place
+	resumeMaster bool
+	detaching    bool
+
+	running   bool
+	startedAt time.Time
+
+	// statsMu guards fields updated from the receive loop and state callbacks,
+	// which must not contend with mu held across Start/Stop
+	statsMu         sync.Mutex
+	lastTransition  time.Time
+	peer            PeerInfo
+	master          PeerInfo
+	peers           peerTable
+	lastSent        time.Time
+	countersSince   time.Time
+	splitBrain      splitBrainWatch
+	latency         latencyWatch
+	stateChanged    chan struct{}
+	watchers        map[chan StateChange]struct{}
+	advertsSent     atomic.Uint64
+	advertsReceived atomic.Uint64
+
+	// expect is what received advertisements are checked against. The
+	// receive loop reads it without mu, which teardown holds while waiting
+	// for the loop to exit.
+	expect atomic.Pointer[advertExpect]
+	// allowedPeers are the sources advertisements are accepted from, nil
+	// for any (Config.AllowedPeers). The receive loop reads it without mu.
+	allowedPeers atomic.Pointer[[]netip.Prefix]
+	// onLinkCheck is the OnLinkCheck (Config.OnLinkCheck), read by the
+	// receive loop; onLink holds the subnets it checks against
+	onLinkCheck atomic.Value
+	onLink      onLinkSubnets
+	// intervalCheck is the IntervalCheck (Config.IntervalCheck), read by
+	// the receive loop; intervalWarned holds the peers logged for it
+	intervalCheck  atomic.Value
+	intervalWarned peerWarnings[time.Duration]
+	// versionPolicy is the VersionPolicy (Config.VersionPolicy), read by
+	// both loops; versions tracks the versions each source advertises
+	versionPolicy atomic.Value
+	versions      versionWatch
+	// addressCheck is the AddressCheck (Config.AddressCheck), read by the
+	// receive loop; addressWarned holds the peers logged for it
+	addressCheck  atomic.Value
+	addressWarned peerWarnings[string]
+	// garp is the GARPMode (Config.GARP), read as virtual IPs are added
+	garp atomic.Value
+	// membership is Config.Membership
+	membership Membership
+	// throttle limits the advertisements processed per source, nil for no
+	// limit (Config.MaxAdvertRate)
+	throttle *throttle
+	// tracking runs Config.Trackers while the router runs
+	tracking *tracking
+	// priorityFn and priorityInterval are Config.PriorityFunc and
+	// Config.PriorityInterval; advertised is the priority last computed
+	priorityFn       PriorityFunc
+	priorityInterval time.Duration
+	advertised       uint8
+	// preemptWindows are Config.PreemptWindows parsed
+	preemptWindows []PreemptWindow
+	// handoff is the Handoff in progress, nil if none. recordPeer reads it
+	// without mu.
+	handoff atomic.Pointer[handoff]
+	// auth signs and verifies advertisements, nil when they are not
+	// authenticated (Config.AuthKeys). Both loops read it without mu.
+	auth atomic.Pointer[advertAuth]
+	// replay rejects authenticated advertisements played back from a
+	// capture (Config.AuthReplayWindow)
+	replay *replayGuard
+
+	// lastProtoError is the DropReason of the last message discarded by
+	// validation
+	lastProtoError atomic.Value
+
+	becomeMaster      atomic.Uint64
+	checksumErrors    atomic.Uint64
+	ttlErrors         atomic.Uint64
+	vridMismatches    atomic.Uint64
+	decodeErrors      atomic.Uint64
+	versionErrors     atomic.Uint64
+	invalidTypeRecv   atomic.Uint64
+	advIntervalErrors atomic.Uint64
+	addressListErrors atomic.Uint64
+	priorityZeroRecv  atomic.Uint64
+	priorityZeroSent  atomic.Uint64
+	transitions       atomic.Uint64
+	vipErrors         atomic.Uint64
+	splitBrains       atomic.Uint64
+	ownAdverts        atomic.Uint64
+	peerRejected      atomic.Uint64
+	offLinkAdverts    atomic.Uint64
+	notOnLink         atomic.Uint64
+	throttled         atomic.Uint64
+	authFailures      atomic.Uint64
+	replays           atomic.Uint64
+	intervalDrops     atomic.Uint64
+	foreignVersion    atomic.Uint64
+	versionDrops      atomic.Uint64
+	addressDrops      atomic.Uint64
+
+	onStateChangeCb func(old, new State)
+	onSplitBrainCb  func(SplitBrain)
+	onMasterLostCb  func()
+	onTransitionCb  func(Transition)
+	onAdvertRecvCb  func(Advert)
+	onAdvertSentCb  func(Advert)
+}
+
+// PeerInfo describes the last advertisement heard from another router
+type PeerInfo struct {
+	SourceIP net.IP
+	Priority uint8
+	// AdvInterval is the interval the router advertises
+	AdvInterval time.Duration
+	LastSeen    time.Time
+	// VirtualIPs are the virtual IPs the router advertises, when they
+	// differ from this router's and Config.AddressCheck is adopt; nil
+	// otherwise
+	VirtualIPs []net.IP
+}
+
+func (p PeerInfo) clone() PeerInfo {
+	p.SourceIP = slices.Clone(p.SourceIP)
+	if p.VirtualIPs != nil {
+		p.VirtualIPs = slices.Clone(p.VirtualIPs)
+		for i, ip := range p.VirtualIPs {
+			p.VirtualIPs[i] = slices.Clone(ip)
+		}
+	}
+	return p
+}
+
+// Status is a point-in-time snapshot of a virtual router. It shares no memory
+// with the router.
+type Status struct {
+	VRID           uint8
+	Interface      string
+	State          State
+	Priority       uint8
+	VirtualIPs     []net.IP
+	ExcludedIPs    []net.IP
+	Running        bool
+	StartedAt      time.Time
+	LastTransition time.Time
+	// MasterIP is Master.SourceIP, nil if the master is unknown
+	MasterIP net.IP
+	// Master is the router acting as MASTER: this one while MASTER, with
+	// LastSeen its last advertisement sent, or as BACKUP the last router
+	// heard advertising a non-zero priority. It is zero while unknown: in
+	// INIT, after the master advertised priority 0, or once it has been
+	// silent for a master down interval.
+	Master PeerInfo
+	Peer   PeerInfo
+	// Peers is the peer table: every router heard for the VRID, ordered by
+	// address. A router that should not be in the group shows up here.
+	Peers           []KnownPeer
+	AdvertsSent     uint64
+	AdvertsReceived uint64
+	PacketsDropped  uint64
+	SyncGroup       string
+	// Observe is Config.Observe: the router follows the election without
+	// taking part
+	Observe bool
+	// BackupOnly is the router's backup-only setting (Config.BackupOnly)
+	BackupOnly bool
+	// Trackers are the states of Config.Trackers. Priority is the one
+	// advertised, lowered by the failing trackers' weights or as
+	// Config.PriorityFunc computes it.
+	Trackers []TrackerStatus
+	// ConfiguredPriority is Config.Priority or the last SetPriority, before
+	// the trackers lower it
+	ConfiguredPriority uint8
+}
+
+// Counters are the protocol counters of a virtual router since it was created
+// or since the last ResetCounters. The comments name the matching objects of
+// the VRRPv3 MIB (RFC 6527).
+type Counters struct {
+	AdvertsSent     uint64
+	AdvertsReceived uint64 // vrrpv3StatisticsRcvdAdvertisements
+	BecomeMaster    uint64 // vrrpv3StatisticsMasterTransitions
+	ChecksumErrors  uint64 // vrrpv3RouterChecksumErrors
+	TTLErrors       uint64 // vrrpv3StatisticsIpTtlErrors
+	VRIDMismatches  uint64 // vrrpv3RouterVrIdErrors
+	// VersionErrors counts messages of a version other than 2 or 3
+	VersionErrors uint64 // vrrpv3RouterVersionErrors
+	// InvalidTypeReceived counts messages that are not advertisements
+	InvalidTypeReceived uint64 // vrrpv3StatisticsRcvdInvalidTypePackets
+	// PacketLengthErrors counts messages whose length does not match their
+	// address count
+	PacketLengthErrors uint64 // vrrpv3StatisticsPacketLengthErrors
+	// AdvIntervalErrors and AddressListErrors count accepted advertisements
+	// whose interval or virtual IPs differ from the router's own
+	AdvIntervalErrors    uint64 // vrrpv3StatisticsAdvIntervalErrors
+	AddressListErrors    uint64 // vrrpv3StatisticsAddressListErrors
+	PriorityZeroReceived uint64 // vrrpv3StatisticsRcvdPriZeroPackets
+	PriorityZeroSent     uint64 // vrrpv3StatisticsSentPriZeroPackets
+	PacketsDropped       uint64
 }
 
 type Config struct {
@@ -33,100 +287,782 @@ type Config struct {
 	AdvInterval int
 	Preempt     bool
 	Version     uint8
+
+	// PreemptWindows restrict preemption to weekly periods in the syntax of
+	// ParsePreemptWindow, e.g. "Mon-Fri 02:00-04:00" on the host's clock:
+	// outside them a BACKUP leaves a lower-priority master alone, so that a
+	// recovered router fails back when moving the traffic is harmless.
+	// Empty lets it preempt at any time.
+	PreemptWindows []string
+
+	// ExcludedIPs are IPv4 addresses programmed with VirtualIPs while
+	// MASTER but left out of advertisements, like keepalived's
+	// virtual_ipaddress_excluded: for more addresses than an advertisement
+	// carries (255), or addresses the routers of the VRID need not agree
+	// on. They must not repeat VirtualIPs.
+	ExcludedIPs []string
+
+	// Logger receives the router's log records, with vrid and iface attributes
+	// added. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	// DryRun runs the state machine and receives advertisements but only logs
+	// the address changes and advertisements it would make. Without
+	// CAP_NET_RAW it runs without receiving, as if alone on the link.
+	DryRun bool
+
+	// Observe runs the router listen-only: it joins the multicast group
+	// and follows the election for the VRID, reporting the master and the
+	// peers in Status, Stats and Metrics, but stays BACKUP whatever its
+	// priority, so it never advertises or programs the virtual IPs. It
+	// cannot be in a SyncGroup.
+	Observe bool
+
+	// BackupOnly keeps the router from ever becoming MASTER, whatever its
+	// priority, while it is otherwise configured like its peers: when the
+	// master goes silent or leaves it stays BACKUP, logs an error and
+	// calls the SetMasterLostCallback callback. It cannot be in a
+	// SyncGroup.
+	BackupOnly bool
+
+	// AddressBackend selects how the virtual IPs are programmed: netlink
+	// (the default), exec or noop. DryRun and Observe override it.
+	AddressBackend AddressBackend
+
+	// Metrics receives the router's transitions, advertisements, drops and
+	// address changes. If nil, they are only counted in Counters.
+	Metrics Metrics
+
+	// Capture receives a copy of the advertisements the router sends and of
+	// the messages it receives for its VRID, e.g. to write them to a pcap
+	// file
+	Capture PacketCapture
+
+	// Hooks run around every change to the virtual IPs on the interface, e.g.
+	// to quiesce a service before the addresses go away
+	Hooks VIPHooks
+
+	// Chaos injects faults into the messages the router receives, to test
+	// elections on a lossy network. It is for testing only.
+	Chaos Chaos
+
+	// QueueLength is the capacity of the state machine's send and receive
+	// queues; 0 means defaultQueueLength. Shorter queues save a little
+	// memory, but a burst of messages that overflows them is dropped and
+	// counted as DropQueueFull.
+	QueueLength int
+
+	// KernelFilter drops the advertisements for other VRIDs in the kernel,
+	// with a socket filter, instead of waking the process for each one. On a
+	// link shared through a Manager the filter is attached only while every
+	// router on it asks for one. The dropped messages are not counted as
+	// VRID mismatches.
+	KernelFilter bool
+
+	// MaxAdvertRate is the advertisements per second the router processes
+	// from one source, allowing bursts of twice as many; the rest are
+	// dropped as DropThrottled. 0 means DefaultMaxAdvertRate and a
+	// negative rate disables the limit.
+	MaxAdvertRate float64
+
+	// OnLinkCheck makes the router check that advertisements come from an
+	// address in one of the interface's subnets, as a router on the link
+	// does, and count or drop those that do not. Empty is OnLinkOff.
+	OnLinkCheck OnLinkCheck
+
+	// IntervalCheck is what the router does with advertisements whose
+	// interval differs from AdvertInterval: count them (the default) or
+	// also drop the VRRPv2 ones as DropInterval. Either way each peer is
+	// logged once.
+	IntervalCheck IntervalCheck
+
+	// VersionPolicy is what the router does with VRRPv3 advertisements for
+	// its VRID: accept them unless their source also advertises VRRPv2
+	// (the default), drop them all, or accept them and also send its own
+	// advertisements as VRRPv3. Empty is VersionPrefer.
+	VersionPolicy VersionPolicy
+
+	// AddressCheck is what the router does with advertisements whose
+	// virtual IPs differ from VirtualIPs: log and count them (the
+	// default), also drop them as DropAddressList, or report the master's
+	// list in Status. Empty is AddressWarn.
+	AddressCheck AddressCheck
+
+	// GARP is the forms of gratuitous ARP broadcast for each virtual IP
+	// added on becoming MASTER: a request and a reply (the default), one
+	// of them, or none. None is sent by a dry run or the noop backend.
+	// Empty is GARPBoth.
+	GARP GARPMode
+
+	// AllowedPeers are the addresses and CIDR prefixes of the routers
+	// advertisements are accepted from, e.g. "192.168.1.2" or
+	// "192.168.1.0/29". Advertisements for the VRID from any other source
+	// are dropped and counted as DropPeer, so a rogue host on the link
+	// cannot take the virtual IPs by advertising priority 255. Empty
+	// accepts any source.
+	AllowedPeers []string
+
+	// Membership is how the router's socket keeps its membership of the
+	// VRRP multicast group. Routers run by a Manager share one socket per
+	// interface, which keeps the setting of the first router to open it.
+	Membership Membership
+
+	// AuthKeys authenticates advertisements with HMAC-SHA256, "ID:KEY"
+	// each with a key of at least MinAuthKeyLen bytes. The first key signs
+	// the advertisements sent, which carry a trailer other VRRP
+	// implementations do not understand; advertisements received without
+	// a valid trailer from one of the keys are dropped as DropAuth. Empty,
+	// the default, sends standard advertisements and accepts any.
+	AuthKeys []string
+
+	// Trackers tie the priority to health checks, in the syntax of
+	// ParseTracker, e.g. "dns:query=example.com,server=127.0.0.1": while
+	// one fails the router advertises its Weight less than Priority. A
+	// failing tracker is shown in Status.Trackers.
+	Trackers []string
+
+	// PriorityFunc computes the priority advertised in place of taking the
+	// weights of the failing Trackers off Priority, e.g. to fold in signals
+	// of the caller's own. It is evaluated at Start, when the priority is
+	// set, when a tracker fails or recovers and every PriorityInterval while
+	// the router runs, with the router's lock held: it must return quickly
+	// and not call the router's methods.
+	PriorityFunc PriorityFunc
+
+	// PriorityInterval is how often PriorityFunc is evaluated while the
+	// router runs; 0 means DefaultPriorityInterval
+	PriorityInterval time.Duration
+
+	// AuthReplayWindow is how far the timestamp of an authenticated
+	// advertisement may be from the router's clock, so the routers' clocks
+	// must agree to within it. Timestamps from a source must also increase;
+	// advertisements failing either check are replays, dropped as
+	// DropReplay. 0 means DefaultAuthReplayWindow and a negative window
+	// only checks that timestamps increase.
+	AuthReplayWindow time.Duration
+
+	// DetectVIPConflicts makes a MASTER watch ARP traffic, probing its
+	// virtual IPs periodically, and report a split brain when another host
+	// answers for one of them. It needs CAP_NET_RAW and is off in a dry run
+	// and with the noop backend, where another system holds the addresses.
+	// Another router advertising for the VRID is reported regardless.
+	DetectVIPConflicts bool
+
+	// ResumeMaster makes the first Start enter MASTER at once instead of
+	// electing, for a process taking over from one that was MASTER and
+	// detached (see Detach). The virtual IPs are expected to be on the
+	// interface already; adding them again is harmless.
+	ResumeMaster bool
+
+	// SyncGroup makes the router fail over together with the group's other
+	// members. A stopped member holds the whole group in BACKUP until it is
+	// started again or removed from its Manager.
+	SyncGroup *SyncGroup
+
+	// NetInterface is the interface to run on, for a caller that has
+	// resolved it already, e.g. by index. Interface may then be left empty;
+	// otherwise it must be its name. Without it the interface is looked up
+	// by name at each Start.
+	NetInterface *net.Interface
+
+	// Transport carries the router's messages in place of the VRRP socket
+	// Start opens: a *Network the caller opened, e.g. in another network
+	// namespace, or a Transport of its own, e.g. an in-memory link for
+	// tests. Its interface takes the place of NetInterface. The caller
+	// owns it: the router never closes it, a Manager does not share it with
+	// other routers, and KernelFilter and Membership, which apply to the
+	// router's own socket, are ignored.
+	Transport Transport
 }
 
 func NewVirtualRouter(cfg *Config) (*VirtualRouter, error) {
 	if cfg.VRID == 0 {
-		return nil, fmt.Errorf("invalid VRID: must be between 1 and 255")
+		return nil, fmt.Errorf("%w: VRID must be between 1 and 255", ErrInvalidConfig)
 	}
 
 	// Priority is uint8, so it can't exceed 255
 
-	if cfg.Interface == "" {
-		return nil, fmt.Errorf("interface name is required")
+	ifaceName, err := configInterface(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(cfg.VirtualIPs) == 0 {
-		return nil, fmt.Errorf("at least one virtual IP is required")
+	ips, err := parseVirtualIPs(cfg.VirtualIPs)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := parseExcludedIPs(cfg.ExcludedIPs, ips)
+	if err != nil {
+		return nil, err
 	}
 
-	ips := make([]net.IP, 0, len(cfg.VirtualIPs))
-	for _, ipStr := range cfg.VirtualIPs {
+	advInterval := cfg.AdvInterval
+	if advInterval == 0 {
+		advInterval = 1
+	}
+	if err := validateAdvInterval(advInterval); err != nil {
+		return nil, err
+	}
+	if err := validateAddressBackend(cfg.AddressBackend); err != nil {
+		return nil, err
+	}
+	if err := cfg.Chaos.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.PriorityInterval < 0 {
+		return nil, fmt.Errorf("%w: priority interval %s must not be negative", ErrInvalidConfig,
+			cfg.PriorityInterval)
+	}
+	if cfg.QueueLength < 0 {
+		return nil, fmt.Errorf("%w: queue length %d must not be negative", ErrInvalidConfig, cfg.QueueLength)
+	}
+	if cfg.Observe && cfg.SyncGroup != nil {
+		return nil, fmt.Errorf("%w: an observer cannot be in a sync group", ErrInvalidConfig)
+	}
+	if cfg.BackupOnly && cfg.SyncGroup != nil {
+		return nil, fmt.Errorf("%w: a backup-only router cannot be in a sync group", ErrInvalidConfig)
+	}
+	preemptWindows, err := ParsePreemptWindows(cfg.PreemptWindows)
+	if err != nil {
+		return nil, err
+	}
+	allowedPeers, err := ParseAllowedPeers(cfg.AllowedPeers)
+	if err != nil {
+		return nil, err
+	}
+	authKeys, err := ParseAuthKeys(cfg.AuthKeys)
+	if err != nil {
+		return nil, err
+	}
+	trackers, err := ParseTrackers(cfg.Trackers)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateOnLinkCheck(cfg.OnLinkCheck); err != nil {
+		return nil, err
+	}
+	if err := validateIntervalCheck(cfg.IntervalCheck); err != nil {
+		return nil, err
+	}
+	if err := validateVersionPolicy(cfg.VersionPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateVersion(cfg.Version); err != nil {
+		return nil, err
+	}
+	if err := validateAddressCheck(cfg.AddressCheck); err != nil {
+		return nil, err
+	}
+	if err := validateGARPMode(cfg.GARP); err != nil {
+		return nil, err
+	}
+	if math.IsNaN(cfg.MaxAdvertRate) {
+		return nil, fmt.Errorf("%w: advertisement rate must be a number", ErrInvalidConfig)
+	}
+	maxAdvertRate := cfg.MaxAdvertRate
+	if maxAdvertRate == 0 {
+		maxAdvertRate = DefaultMaxAdvertRate
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = NopMetrics{}
+	}
+
+	vr := &VirtualRouter{
+		logger:       logger.With("vrid", cfg.VRID, "iface", ifaceName),
+		metrics:      metrics,
+		capture:      cfg.Capture,
+		hooks:        cfg.Hooks,
+		chaos:        cfg.Chaos,
+		queueLength:  cfg.QueueLength,
+		kernelFilter: cfg.KernelFilter,
+		vrid:         cfg.VRID,
+		priority:     cfg.Priority,
+		ips:          ips,
+		excluded:     excluded,
+		iface:        ifaceName,
+		netIface:     cfg.NetInterface,
+		injected:     cfg.Transport,
+		advInterval:  advInterval,
+		preempt:      cfg.Preempt,
+		dryRun:       cfg.DryRun,
+		observe:      cfg.Observe,
+		backupOnly:   cfg.BackupOnly,
+		addresses:    cfg.AddressBackend,
+		arpCheck:     cfg.DetectVIPConflicts,
+		membership:   cfg.Membership,
+
+		resumeMaster: cfg.ResumeMaster,
+
+		countersSince: time.Now(),
+		stateChanged:  make(chan struct{}),
+		watchers:      make(map[chan StateChange]struct{}),
+	}
+	vr.expectAdverts()
+	vr.preemptWindows = preemptWindows
+	vr.setAllowedPeers(allowedPeers)
+	vr.auth.Store(newAdvertAuth(authKeys))
+	vr.tracking = newTracking(trackers)
+	vr.priorityFn, vr.priorityInterval = cfg.PriorityFunc, cfg.PriorityInterval
+	if vr.priorityInterval == 0 {
+		vr.priorityInterval = DefaultPriorityInterval
+	}
+	vr.reprioritize()
+	vr.replay = newReplayGuard(cfg.AuthReplayWindow)
+	vr.onLink = onLinkSubnets{iface: ifaceName, lookup: interfaceSubnets}
+	vr.throttle = newThrottle(maxAdvertRate)
+	_ = vr.SetOnLinkCheck(cfg.OnLinkCheck)
+	_ = vr.SetIntervalCheck(cfg.IntervalCheck)
+	_ = vr.SetVersionPolicy(cfg.VersionPolicy)
+	_ = vr.SetAddressCheck(cfg.AddressCheck)
+	_ = vr.SetGARP(cfg.GARP)
+	vr.splitBrain.reset(false)
+	vr.latency = latencyWatch{arrivals: make(map[netip.Addr]time.Time), jitter: make(map[netip.Addr]*Histogram)}
+	if cfg.SyncGroup != nil {
+		vr.syncMember = cfg.SyncGroup.join()
+	}
+	return vr, nil
+}
+
+// configInterface returns the name of the interface cfg runs on: Interface,
+// which the interface of Transport or NetInterface must match if set
+func configInterface(cfg *Config) (string, error) {
+	var given *net.Interface
+	switch {
+	case cfg.Transport != nil:
+		if given = cfg.Transport.GetInterface(); given == nil {
+			return "", fmt.Errorf("%w: transport has no interface", ErrInvalidConfig)
+		}
+	case cfg.NetInterface != nil:
+		given = cfg.NetInterface
+	}
+
+	switch {
+	case given == nil && cfg.Interface == "":
+		return "", fmt.Errorf("%w: interface name is required", ErrInvalidConfig)
+	case given == nil:
+		return cfg.Interface, nil
+	case cfg.Interface != "" && cfg.Interface != given.Name:
+		return "", fmt.Errorf("%w: interface %s does not match the given interface %s",
+			ErrInvalidConfig, cfg.Interface, given.Name)
+	}
+	return given.Name, nil
+}
+
+func parseVirtualIPs(addrs []string) ([]net.IP, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: at least one virtual IP is required", ErrInvalidConfig)
+	}
+	if len(addrs) > MaxVirtualIPs {
+		return nil, fmt.Errorf("%w: %d virtual IPs do not fit an advertisement, which carries %d; "+
+			"list the rest as excluded IPs", ErrInvalidConfig, len(addrs), MaxVirtualIPs)
+	}
+	return parseIPv4s(addrs)
+}
+
+// parseExcludedIPs parses Config.ExcludedIPs, which must not repeat the
+// virtual IPs ips
+func parseExcludedIPs(addrs []string, ips []net.IP) ([]net.IP, error) {
+	excluded, err := parseIPv4s(addrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range excluded {
+		if containsIP(ips, ip) {
+			return nil, fmt.Errorf("%w: excluded IP %s is also a virtual IP", ErrInvalidConfig, ip)
+		}
+	}
+	return excluded, nil
+}
+
+func parseIPv4s(addrs []string) ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(addrs))
+	for _, ipStr := range addrs {
 		ip := net.ParseIP(ipStr)
 		if ip == nil {
-			return nil, fmt.Errorf("invalid IP address: %s", ipStr)
+			return nil, fmt.Errorf("%w: invalid IP address: %s", ErrInvalidConfig, ipStr)
 		}
 		if ip.To4() == nil {
-			return nil, fmt.Errorf("only IPv4 addresses are supported: %s", ipStr)
+			return nil, fmt.Errorf("%w: only IPv4 addresses are supported: %s", ErrInvalidConfig, ipStr)
 		}
 		ips = append(ips, ip.To4())
 	}
 
-	return &VirtualRouter{
-		vrid:     cfg.VRID,
-		priority: cfg.Priority,
-		ips:      ips,
-		iface:    cfg.Interface,
-	}, nil
+	return ips, nil
+}
+
+func validateAdvInterval(secs int) error {
+	// VRRPv2 carries the interval in an 8-bit seconds field
+	if secs < 1 || secs > 255 {
+		return fmt.Errorf("%w: advertisement interval %d must be between 1 and 255 seconds",
+			ErrInvalidConfig, secs)
+	}
+	return nil
 }
 
-func (vr *VirtualRouter) Start() error {
+// Start runs the router until ctx is canceled or Stop is called. Either way it
+// tears down in the same order: the state machine releases the virtual IPs,
+// the send and receive loops exit, a MASTER advertises priority 0 and the
+// socket is closed.
+func (vr *VirtualRouter) Start(ctx context.Context) error {
 	vr.mu.Lock()
 	defer vr.mu.Unlock()
 
 	if vr.running {
-		return fmt.Errorf("virtual router is already running")
+		return ErrAlreadyRunning
 	}
 
-	network, err := NewNetwork(vr.iface)
+	iface, err := vr.openNetwork()
 	if err != nil {
-		return fmt.Errorf("failed to initialize network: %w", err)
+		return err
+	}
+
+	var arp *arpConn
+	if vr.arpCheck && !vr.dryRun && !vr.observe && vr.addresses != AddressNoop {
+		if arp, err = openARP(iface); err != nil {
+			_ = vr.closeNetwork()
+			return err
+		}
 	}
-	vr.network = network
 
-	vr.stateMachine = NewStateMachine(vr.vrid, vr.priority, vr.ips, vr.network.GetInterface())
+	vr.tracking.reset()
+	vr.reprioritize()
+	vr.stateMachine = NewStateMachine(vr.vrid, vr.advertised, vr.ips, iface)
+	vr.stateMachine.SetExcludedIPs(vr.excluded)
+	vr.stateMachine.SetLogger(vr.logger)
+	if vr.queueLength > 0 {
+		vr.stateMachine.SetQueueLength(vr.queueLength)
+	}
+	if vr.manager != nil {
+		vr.stateMachine.scheduler = vr.manager.timers
+	}
+	switch {
+	case vr.observe:
+		vr.stateMachine.SetAddressManager(NopAddresses{})
+		vr.stateMachine.SetObserve(true)
+	case vr.dryRun:
+		vr.stateMachine.SetAddressManager(dryRunAddresses{logger: vr.logger})
+	case vr.addresses == AddressExec:
+		vr.stateMachine.SetAddressManager(NewExecAddresses(iface.Name))
+	case vr.addresses == AddressNoop:
+		vr.stateMachine.SetAddressManager(NopAddresses{})
+	case vr.link != nil:
+		vr.stateMachine.SetAddressManager(newIPManager(iface, vr.link.handle))
+	}
+	if !vr.dryRun && !vr.observe && vr.addresses != AddressNoop {
+		vr.stateMachine.SetAddressManager(announcingAddresses{
+			AddressManager: vr.stateMachine.ipManager,
+			iface:          iface,
+			mode:           &vr.garp,
+			logger:         vr.logger,
+			send:           sendGARP,
+		})
+	}
+	vr.stateMachine.SetAddressManager(meteredAddresses{
+		AddressManager: vr.stateMachine.ipManager,
+		metrics:        vr.metrics,
+		failures:       &vr.vipErrors,
+		iface:          vr.iface,
+		vrid:           vr.vrid,
+	})
+	vr.stateMachine.SetMetrics(vr.metrics)
+	vr.stateMachine.SetVIPHooks(vr.hooks)
+	vr.stateMachine.SetAdvertisementInterval(time.Duration(vr.advInterval) * time.Second)
+	vr.stateMachine.SetPreempt(vr.preempt)
+	vr.stateMachine.SetPreemptWindows(vr.preemptWindows)
+	vr.stateMachine.SetBackupOnly(vr.backupOnly)
+	vr.stateMachine.SetMasterLostCallback(vr.onMasterLost)
 	vr.stateMachine.SetStateChangeCallback(vr.onStateChange)
+	vr.stateMachine.SetFailoverCallback(vr.onFailover)
+	vr.stateMachine.SetTransitionCallback(vr.onTransition)
+	vr.stateMachine.SetResumeMaster(vr.resumeMaster)
+	vr.resumeMaster = false
+	if vr.syncMember != nil {
+		vr.syncMember.attach(vr.stateMachine)
+	}
+
+	vr.ctx, vr.cancel = context.WithCancel(ctx)
+
+	vr.faults = nil
+	if vr.chaos.Enabled() && vr.transport != nil {
+		vr.logger.Warn("Injecting faults into received advertisements", "chaos", vr.chaos.String())
+		ownIP := vr.transport.GetSourceIP()
+		vr.faults = NewFaultInjector(vr.chaos, func(header *ipv4.Header, payload []byte) {
+			vr.handleAdvert(header, payload, ownIP)
+		})
+	}
 
-	vr.ctx, vr.cancel = context.WithCancel(context.Background())
+	vr.sender, vr.translator = nil, nil
+	if vr.transport != nil {
+		vr.sender = newSender(vr.transport)
+		vr.sender.auth = &vr.auth
+		vr.translator = newSender(vr.transport)
+		vr.translator.auth = &vr.auth
+		vr.translator.v3 = true
+	}
 
-	vr.wg.Add(2)
+	vr.wg.Add(1)
 	go vr.sendLoop()
-	go vr.recvLoop()
+	switch {
+	case vr.link != nil:
+		vr.link.add(vr)
+	case vr.network != nil:
+		if vr.kernelFilter {
+			if err := vr.network.SetVRIDFilter([]uint8{vr.vrid}); err != nil {
+				vr.logger.Warn("Filtering advertisements in userspace", "err", err)
+			}
+		}
+		vr.wg.Add(1)
+		go vr.recvLoop()
+		vr.wg.Add(1)
+		go vr.membershipLoop()
+	case vr.transport != nil:
+		vr.wg.Add(1)
+		go vr.recvLoop()
+	}
+	if arp != nil {
+		vr.wg.Add(1)
+		go vr.arpLoop(arp)
+	}
 
-	if err := vr.stateMachine.Start(vr.ctx); err != nil {
+	// The state machine is stopped by teardown rather than by ctx, so it is
+	// still known whether it was MASTER when the router was canceled
+	if err := vr.stateMachine.Start(context.WithoutCancel(vr.ctx)); err != nil {
 		vr.cancel()
-		_ = vr.network.Close()
+		vr.wg.Wait()
+		if vr.link != nil {
+			vr.link.remove(vr)
+		}
+		_ = vr.closeNetwork()
 		return fmt.Errorf("failed to start state machine: %w", err)
 	}
 
 	vr.running = true
-	log.Printf("Virtual Router started - VRID: %d, Priority: %d, Interface: %s",
-		vr.vrid, vr.priority, vr.iface)
+	vr.startedAt = time.Now()
+	vr.stopped = make(chan struct{})
+	vr.startTrackers()
+	vr.logger.Info("Virtual router started", "priority", vr.priority, "dry_run", vr.dryRun, "observe", vr.observe,
+		"backup_only", vr.backupOnly)
+	vr.metrics.PriorityChanged(vr.iface, vr.vrid, vr.priority)
+
+	go vr.teardown()
 
 	return nil
 }
 
-func (vr *VirtualRouter) Stop() error {
+// openNetwork opens the VRRP socket, or joins the manager's socket for the
+// interface, and returns the interface to run on. A router given a Transport
+// runs on that instead. A dry run without the privileges for a raw socket
+// carries on without one.
+func (vr *VirtualRouter) openNetwork() (*net.Interface, error) {
+	if vr.injected != nil {
+		vr.transport, vr.network = vr.injected, nil
+		return vr.injected.GetInterface(), nil
+	}
+
+	var network *Network
+	var err error
+	switch {
+	case vr.manager != nil:
+		vr.link, err = vr.manager.openLink(vr.iface, vr.netIface, vr.membership)
+		if err == nil {
+			network = vr.link.network
+		}
+	case vr.netIface != nil:
+		network, err = newNetworkOn(vr.netIface, vr.logger)
+	default:
+		network, err = newNetwork(vr.iface, vr.logger)
+	}
+	if err == nil {
+		vr.transport, vr.network = network, network
+		return network.GetInterface(), nil
+	}
+
+	if !vr.dryRun || !errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("failed to initialize network: %w", err)
+	}
+
+	vr.logger.Warn("Dry run without CAP_NET_RAW, not receiving advertisements", "err", err)
+	vr.transport, vr.network = nil, nil
+	if vr.netIface != nil {
+		return vr.netIface, nil
+	}
+	iface, err := net.InterfaceByName(vr.iface)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInterfaceNotFound, vr.iface, err)
+	}
+	return iface, nil
+}
+
+// closeNetwork leaves the multicast group and closes the socket, or releases
+// the manager's socket for the interface. A detaching router leaves the group
+// to the process it handed the socket to. A Transport given by the caller is
+// left open.
+func (vr *VirtualRouter) closeNetwork() error {
+	leave := !vr.detaching
+	if vr.link != nil {
+		err := vr.manager.closeLink(vr.link, leave)
+		vr.link = nil
+		vr.transport, vr.network = nil, nil
+		return err
+	}
+	switch {
+	case vr.network == nil:
+		return nil
+	case leave:
+		return vr.network.Close()
+	default:
+		return vr.network.release()
+	}
+}
+
+// Stop cancels the router and waits until it has torn down or ctx ends.
+// Teardown attempts every step even if an earlier one fails: releasing the
+// virtual IPs, advertising priority 0 if MASTER, leaving the multicast group
+// and closing the socket. Stop returns the failed steps joined.
+//
+// If ctx ends first, Stop returns ctx's error while teardown carries on in the
+// background; Done is closed once it has finished.
+func (vr *VirtualRouter) Stop(ctx context.Context) error {
+	vr.mu.RLock()
+	running, cancel, stopped := vr.running, vr.cancel, vr.stopped
+	vr.mu.RUnlock()
+
+	if !running {
+		return ErrNotRunning
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("VRID %d on %s: stop: %w", vr.vrid, vr.iface, ctx.Err())
+	}
+
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	return vr.stopErr
+}
+
+// Detach stops the router like Stop, but as a hand-over to another process
+// running the same router with ResumeMaster: a MASTER leaves its virtual IPs
+// on the interface and sends no priority 0 advertisement, and the socket is
+// closed without leaving the multicast group.
+func (vr *VirtualRouter) Detach(ctx context.Context) error {
+	vr.mu.Lock()
+	if vr.running {
+		vr.detaching = true
+	}
+	vr.mu.Unlock()
+
+	return vr.Stop(ctx)
+}
+
+// Done returns a channel closed once the router has stopped, or nil if it
+// has not been started
+func (vr *VirtualRouter) Done() <-chan struct{} {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	return vr.stopped
+}
+
+// loopExitTimeout bounds the wait for the send and receive loops in teardown.
+// The receive loop notices cancellation within readTimeout.
+const loopExitTimeout = 5 * time.Second
+
+// teardown waits for the router's context to end and stops everything Start
+// started, recording the steps that failed in vr.stopErr
+func (vr *VirtualRouter) teardown() {
+	<-vr.ctx.Done()
+	vr.stopTrackers()
+
 	vr.mu.Lock()
 	defer vr.mu.Unlock()
 
-	if !vr.running {
-		return fmt.Errorf("virtual router is not running")
+	var errs []error
+	fail := func(step string, err error) {
+		vr.logger.Warn("Failed to "+step, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", step, err))
 	}
 
-	vr.stateMachine.Stop()
-	vr.cancel()
+	wasMaster := vr.stateMachine.GetState() == Master
 
-	vr.wg.Wait()
+	if vr.link != nil {
+		vr.link.remove(vr)
+	}
+	if vr.faults != nil {
+		vr.faults.Stop()
+	}
+	if vr.detaching {
+		vr.stateMachine.Detach()
+	} else {
+		vr.stateMachine.Stop()
+	}
+	if err := vr.stateMachine.shutdownErr; err != nil {
+		errs = append(errs, fmt.Errorf("release virtual IPs: %w", err))
+	}
 
-	if err := vr.network.Close(); err != nil {
-		log.Printf("Failed to close network: %v", err)
+	// A loop stuck in a send must not keep the socket open: closing it below
+	// unblocks the loop
+	loopsDone := make(chan struct{})
+	go func() {
+		vr.wg.Wait()
+		close(loopsDone)
+	}()
+	select {
+	case <-loopsDone:
+	case <-time.After(loopExitTimeout):
+		fail("stop send and receive loops", fmt.Errorf("still running after %s", loopExitTimeout))
 	}
 
-	vr.running = false
-	log.Printf("Virtual Router stopped - VRID: %d", vr.vrid)
+	// RFC 3768 6.4.3: a master shutting down advertises priority 0 so a
+	// backup takes over after the skew time instead of the master down
+	// interval. It goes out after the send loop has exited so no regular
+	// advertisement can follow it.
+	switch {
+	case !wasMaster, vr.detaching:
+	case vr.dryRun:
+		vr.logger.Info("Dry run: would send priority 0 advertisement")
+	default:
+		pkt := NewPacket(VRRPv2, vr.vrid, 0, vr.ips)
+		// With the interval, so that backups enforcing it do not drop it
+		pkt.AdvInterval = uint16(vr.advInterval)
+		header, data, err := vr.sender.send(pkt)
+		if err != nil {
+			fail("send priority 0 advertisement", err)
+			break
+		}
+		vr.priorityZeroSent.Add(1)
+		vr.metrics.AdvertSent(vr.iface, vr.vrid, 0)
+		if vr.capture != nil {
+			vr.capture.CapturePacket(time.Now(), header, data)
+		}
+		vr.observeSent(header, data, pkt)
+		if err := vr.translate(pkt); err != nil {
+			fail("send priority 0 advertisement as VRRPv3", err)
+		}
+	}
 
-	return nil
+	if err := vr.closeNetwork(); err != nil {
+		fail("close network", err)
+	}
+	<-loopsDone
+
+	if vr.detaching {
+		vr.logger.Info("Virtual router detached", "was_master", wasMaster)
+	} else {
+		vr.logger.Info("Virtual router stopped")
+	}
+	vr.stopErr = errors.Join(errs...)
+	vr.running = false
+	vr.detaching = false
+	close(vr.stopped)
 }
 
 func (vr *VirtualRouter) sendLoop() {
@@ -138,36 +1074,361 @@ func (vr *VirtualRouter) sendLoop() {
 			return
 
 		case pkt := <-vr.stateMachine.GetSendChannel():
-			if err := vr.network.SendPacket(pkt); err != nil {
-				log.Printf("Failed to send packet: %v", err)
+			if vr.dryRun {
+				vr.logger.Debug("Dry run: would send advertisement", "priority", pkt.Priority)
+				continue
+			}
+			header, data, err := vr.sender.send(pkt)
+			if err != nil {
+				vr.logger.Error("Failed to send packet", "err", err)
+				continue
+			}
+			if vr.capture != nil {
+				vr.capture.CapturePacket(time.Now(), header, data)
+			}
+			vr.observeSent(header, data, pkt)
+			if err := vr.translate(pkt); err != nil {
+				vr.logger.Error("Failed to send packet as VRRPv3", "err", err)
 			}
+			vr.advertsSent.Add(1)
+			vr.statsMu.Lock()
+			vr.lastSent = time.Now()
+			vr.statsMu.Unlock()
+			if pkt.Priority == 0 {
+				vr.priorityZeroSent.Add(1)
+			}
+			vr.metrics.AdvertSent(vr.iface, vr.vrid, pkt.Priority)
 		}
 	}
 }
 
+// translate sends pkt again as VRRPv3 if the version policy is
+// VersionTranslate. The copy is captured and observed but not counted as
+// another advertisement sent.
+func (vr *VirtualRouter) translate(pkt *Packet) error {
+	if vr.VersionPolicy() != VersionTranslate {
+		return nil
+	}
+	header, data, err := vr.translator.send(pkt)
+	if err != nil {
+		return err
+	}
+	if vr.capture != nil {
+		vr.capture.CapturePacket(time.Now(), header, data)
+	}
+	vr.observeSent(header, data, nil)
+	return nil
+}
+
 func (vr *VirtualRouter) recvLoop() {
 	defer vr.wg.Done()
 
-	err := vr.network.ReceivePackets(vr.ctx, func(pkt *Packet) {
-		vr.stateMachine.ProcessPacket(pkt)
+	ownIP := vr.transport.GetSourceIP()
+	err := vr.transport.ReceiveRaw(vr.ctx, func(header *ipv4.Header, payload []byte) {
+		vr.receive(header, payload, ownIP)
 	})
 
 	if err != nil && err != context.Canceled {
-		log.Printf("Receive loop error: %v", err)
+		vr.logger.Error("Receive loop failed", "err", err)
+	}
+}
+
+// receive passes a received message to handleAdvert, through the fault
+// injector if Config.Chaos is set
+func (vr *VirtualRouter) receive(header *ipv4.Header, payload []byte, ownIP net.IP) {
+	if vr.faults != nil {
+		vr.faults.Inject(header, payload)
+		return
+	}
+	vr.handleAdvert(header, payload, ownIP)
+}
+
+// inspection is the outcome of the checks on a received message that do not
+// depend on the router receiving it: every router on an interface comes to
+// the same, so a shared socket runs them once per message
+type inspection struct {
+	pkt  *Packet
+	drop DropReason
+	err  error
+}
+
+// inspectAdvert decodes a received message and runs the checks of RFC 3768
+// section 7.1 that do not depend on the router, in order: version, decoding,
+// type, checksum and TTL. It also recognizes our own advertisements, which the
+// multicast socket loops back.
+func inspectAdvert(header *ipv4.Header, payload []byte, ownIP net.IP) inspection {
+	// The version decides how the rest of the message is decoded
+	if len(payload) > 0 {
+		if version := payload[0] >> 4; version != VRRPv2 && version != VRRPv3 {
+			return inspection{drop: DropVersion}
+		}
+	}
+
+	pkt := &Packet{}
+	if err := pkt.Unmarshal(payload); err != nil {
+		return inspection{drop: DropDecode, err: err}
+	}
+	in := inspection{pkt: pkt}
+	switch {
+	case pkt.Type != TypeAdvertisement:
+		in.drop = DropType
+	case !checksumOK(pkt, payload):
+		in.drop = DropChecksum
+	case header.TTL != 255:
+		in.drop = DropTTL
+	case header.Src.Equal(ownIP):
+		in.drop = DropOwn
+	}
+	return in
+}
+
+// checksumOK reports whether the checksum of payload is correct or cannot be
+// verified
+func checksumOK(pkt *Packet, payload []byte) bool {
+	valid, ok := pkt.VerifyChecksum(payload)
+	return valid || !ok
+}
+
+// handleAdvert validates a received message, counts it and passes it on to
+// the state machine. As required by RFC 3768 section 7.1, messages with a TTL
+// other than 255 or a bad checksum are discarded.
+func (vr *VirtualRouter) handleAdvert(header *ipv4.Header, payload []byte, ownIP net.IP) {
+	vr.acceptAdvert(header, payload, inspectAdvert(header, payload, ownIP))
+}
+
+// acceptAdvert counts a message inspected by inspectAdvert and, if it is an
+// advertisement for this router, passes it on to the state machine
+func (vr *VirtualRouter) acceptAdvert(header *ipv4.Header, payload []byte, in inspection) {
+	// Every router on the interface sees every message; each captures those
+	// for its VRID. Our own are captured as they are sent.
+	if vr.capture != nil && len(payload) > 1 && payload[1] == vr.vrid && in.drop != DropOwn {
+		vr.capture.CapturePacket(time.Now(), header, payload)
+	}
+
+	pkt := in.pkt
+	if pkt != nil && pkt.VRID == vr.vrid && in.drop != "" && in.drop != DropOwn {
+		vr.observeReceived(header, payload, pkt, in.drop)
+	}
+	switch in.drop {
+	case "":
+	case DropVersion:
+		vr.drop(&vr.versionErrors, DropVersion)
+		vr.logger.Debug("Discarding message of unknown VRRP version", "src", header.Src, "version", payload[0]>>4)
+		return
+	case DropDecode:
+		vr.drop(&vr.decodeErrors, DropDecode)
+		vr.logger.Warn("Failed to unmarshal VRRP packet", "src", header.Src, "err", in.err)
+		return
+	case DropType:
+		vr.drop(&vr.invalidTypeRecv, DropType)
+		vr.logger.Debug("Discarding message that is not an advertisement", "src", header.Src, "type", pkt.Type)
+		return
+	case DropChecksum:
+		vr.drop(&vr.checksumErrors, DropChecksum)
+		vr.logger.Debug("Discarding advertisement with bad checksum", "src", header.Src)
+		return
+	case DropTTL:
+		vr.drop(&vr.ttlErrors, DropTTL)
+		vr.logger.Debug("Discarding advertisement with TTL other than 255", "src", header.Src, "ttl", header.TTL)
+		return
+	case DropOwn:
+		vr.ownAdverts.Add(1)
+		vr.metrics.PacketDropped(vr.iface, vr.vrid, DropOwn)
+		return
+	}
+
+	if pkt.VRID != vr.vrid {
+		vr.drop(&vr.vridMismatches, DropVRIDMismatch)
+		return
+	}
+	adopted, dropped := vr.admitAdvert(header, payload, pkt)
+	vr.observeReceived(header, payload, pkt, dropped)
+	if dropped != "" {
+		return
+	}
+
+	vr.advertsReceived.Add(1)
+	if pkt.Priority == 0 {
+		vr.priorityZeroRecv.Add(1)
+	}
+	vr.watchAdvert(pkt, header.Src)
+	vr.metrics.AdvertReceived(vr.iface, vr.vrid, pkt.Priority)
+	vr.recordPeer(pkt, header.Src, adopted)
+	// Building the arguments allocates, even when they are not logged
+	if vr.logger.Enabled(context.Background(), slog.LevelDebug) {
+		vr.logger.Debug("Advertisement received", "src", header.Src, "priority", pkt.Priority)
+	}
+
+	vr.stateMachine.ProcessPacket(pkt)
+}
+
+// admitAdvert runs the checks of the router's own policies on an
+// advertisement for its VRID and returns the virtual IPs checkAddresses
+// adopted from it, or the reason it was dropped
+func (vr *VirtualRouter) admitAdvert(header *ipv4.Header, payload []byte, pkt *Packet) ([]net.IP, DropReason) {
+	ok, event, dropped := vr.throttle.allow(peerKey(header.Src), time.Now())
+	switch event {
+	case throttleStarted:
+		vr.logger.Warn("Throttling advertisements from a source sending too many", "src", header.Src)
+	case throttleEnded:
+		vr.logger.Info("No longer throttling advertisements", "src", header.Src, "dropped", dropped)
+	}
+	if !ok {
+		vr.drop(&vr.throttled, DropThrottled)
+		return nil, DropThrottled
+	}
+	if vr.dropOffLink(header.Src) {
+		vr.drop(&vr.notOnLink, DropNotOnLink)
+		vr.logger.Debug("Discarding advertisement from a source off the link", "src", header.Src)
+		return nil, DropNotOnLink
+	}
+	if !vr.peerAllowed(header.Src) {
+		vr.drop(&vr.peerRejected, DropPeer)
+		vr.logger.Debug("Discarding advertisement from a peer not allowed", "src", header.Src,
+			"priority", pkt.Priority)
+		return nil, DropPeer
+	}
+	if !vr.authentic(header.Src, payload, pkt) {
+		vr.drop(&vr.authFailures, DropAuth)
+		vr.logger.Debug("Discarding advertisement that fails authentication", "src", header.Src,
+			"authenticated", pkt.Auth != nil)
+		return nil, DropAuth
+	}
+	if reason := vr.replayed(header.Src, pkt); reason != replayFresh {
+		vr.drop(&vr.replays, DropReplay)
+		vr.logger.Debug("Discarding replayed advertisement", "src", header.Src, "reason", string(reason),
+			"timestamp", pkt.Auth.Timestamp)
+		return nil, DropReplay
+	}
+	want := vr.expect.Load()
+	if vr.dropForeignVersion(pkt, header.Src, time.Duration(want.interval)*time.Second) {
+		vr.drop(&vr.versionDrops, DropForeignVersion)
+		return nil, DropForeignVersion
+	}
+	if vr.dropInterval(pkt, header.Src, time.Duration(want.interval)*time.Second) {
+		vr.drop(&vr.intervalDrops, DropInterval)
+		return nil, DropInterval
+	}
+	adopted, drop := vr.checkAddresses(pkt, header.Src, want)
+	if drop {
+		vr.drop(&vr.addressDrops, DropAddressList)
+		return nil, DropAddressList
+	}
+	return adopted, ""
+
+}
+
+// drop counts a message discarded by validation
+func (vr *VirtualRouter) drop(counter *atomic.Uint64, reason DropReason) {
+	counter.Add(1)
+	// Repeated drops for one reason, such as the advertisements of other
+	// VRIDs on a shared interface, leave it untouched
+	if last, _ := vr.lastProtoError.Load().(DropReason); last != reason {
+		vr.lastProtoError.Store(reason)
+	}
+	vr.metrics.PacketDropped(vr.iface, vr.vrid, reason)
+}
+
+// advertExpect is the router's configuration as advertised by its peers
+type advertExpect struct {
+	interval int
+	ips      []net.IP
+}
+
+// expectAdverts updates what advertisements are checked against. vr.mu must
+// be held or the router not yet shared.
+func (vr *VirtualRouter) expectAdverts() {
+	vr.expect.Store(&advertExpect{interval: vr.advInterval, ips: vr.ips})
+}
+
+// sameIPSet reports whether a and b hold the same addresses in any order
+func sameIPSet(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, ip := range a {
+		if !slices.ContainsFunc(b, ip.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordPeer records an accepted advertisement from src, with the virtual
+// IPs checkAddresses adopted from it, if any
+func (vr *VirtualRouter) recordPeer(pkt *Packet, src net.IP, adopted []net.IP) {
+	now := time.Now()
+	vr.statsMu.Lock()
+	vr.peer = PeerInfo{
+		SourceIP:    src,
+		Priority:    pkt.Priority,
+		AdvInterval: pkt.Interval(),
+		LastSeen:    now,
+		VirtualIPs:  adopted,
+	}
+	switch {
+	case pkt.Priority > 0:
+		vr.master = vr.peer
+		if h := vr.handoff.Load(); h != nil {
+			h.observe(src)
+		}
+	case src.Equal(vr.master.SourceIP):
+		// The master is leaving
+		vr.master = PeerInfo{}
+	}
+	if vr.peers == nil {
+		vr.peers = make(peerTable)
+	}
+	isNew := vr.peers.observe(pkt, src, now)
+	jitter, ok := vr.latency.observeArrival(pkt, src, now)
+	vr.statsMu.Unlock()
+
+	if isNew {
+		vr.logger.Info("New router heard", "src", src, "priority", pkt.Priority, "version", pkt.Version)
+	}
+	vr.metrics.PeerAdvert(vr.iface, vr.vrid, src, pkt.Version, pkt.Priority)
+	if ok {
+		vr.metrics.AdvertJitter(vr.iface, vr.vrid, src, jitter)
 	}
 }
 
 func (vr *VirtualRouter) onStateChange(old, new State) {
-	log.Printf("VRID %d: State changed from %s to %s", vr.vrid, old, new)
+	now := time.Now()
+	vr.statsMu.Lock()
+	vr.lastTransition = now
+	vr.splitBrain.reset(new == Master)
+	vr.notifyWatchers(StateChange{Old: old, New: new, Time: now})
+	vr.statsMu.Unlock()
 
+	vr.transitions.Add(1)
 	if new == Master {
-		log.Printf("VRID %d: Now MASTER for IPs: %v", vr.vrid, vr.ips)
+		vr.becomeMaster.Add(1)
 	}
+	vr.metrics.StateChanged(vr.iface, vr.vrid, old, new)
+
+	if vr.onStateChangeCb != nil {
+		vr.onStateChangeCb(old, new)
+	}
+}
+
+// SetStateChangeCallback registers fn to be called after every state transition.
+// It must be called before Start. fn runs in the middle of the transition and
+// must not call the router's methods, which would deadlock.
+func (vr *VirtualRouter) SetStateChangeCallback(fn func(old, new State)) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.onStateChangeCb = fn
 }
 
+// GetState returns the current state, Init before the router first starts.
+// While Stop tears the router down it blocks until teardown has finished.
 func (vr *VirtualRouter) GetState() State {
-	if vr.stateMachine != nil {
-		return vr.stateMachine.GetState()
+	vr.mu.RLock()
+	sm := vr.stateMachine
+	vr.mu.RUnlock()
+
+	if sm != nil {
+		return sm.GetState()
 	}
 	return Init
 }
@@ -177,11 +1438,177 @@ func (vr *VirtualRouter) GetVRID() uint8 {
 }
 
 func (vr *VirtualRouter) GetPriority() uint8 {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
 	return vr.priority
 }
 
+// GetVirtualIPs returns a copy of the virtual IPs, which the caller may modify
 func (vr *VirtualRouter) GetVirtualIPs() []net.IP {
-	return vr.ips
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	return cloneIPs(vr.ips)
+}
+
+// GetExcludedIPs returns a copy of the excluded IPs, which the caller may
+// modify
+func (vr *VirtualRouter) GetExcludedIPs() []net.IP {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	return cloneIPs(vr.excluded)
+}
+
+func cloneIPs(ips []net.IP) []net.IP {
+	out := make([]net.IP, len(ips))
+	for i, ip := range ips {
+		out[i] = slices.Clone(ip)
+	}
+	return out
+}
+
+// SetPriority changes the router priority, taking effect in the next advertisement
+func (vr *VirtualRouter) SetPriority(priority uint8) error {
+	if priority == 0 {
+		return fmt.Errorf("%w: priority must be between 1 and 255", ErrInvalidConfig)
+	}
+
+	vr.mu.Lock()
+	vr.priority = priority
+	effective, _ := vr.reprioritize()
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	vr.applyPriority(sm, effective)
+	if effective != priority {
+		vr.logger.Info("Priority changed", "priority", priority, "advertised", effective)
+	} else {
+		vr.logger.Info("Priority changed", "priority", priority)
+	}
+	return nil
+}
+
+// Failover makes a MASTER hand over to a backup and not preempt it for hold.
+// It fails if the router is not running or not MASTER.
+func (vr *VirtualRouter) Failover(hold time.Duration) error {
+	vr.mu.RLock()
+	sm := vr.stateMachine
+	running := vr.running
+	vr.mu.RUnlock()
+
+	if !running {
+		return ErrNotRunning
+	}
+
+	if err := sm.StepDown(hold); err != nil {
+		return fmt.Errorf("VRID %d: cannot fail over: %w", vr.vrid, err)
+	}
+	return nil
+}
+
+// SetAdvertInterval changes the advertisement interval in seconds
+func (vr *VirtualRouter) SetAdvertInterval(secs int) error {
+	if err := validateAdvInterval(secs); err != nil {
+		return err
+	}
+
+	vr.mu.Lock()
+	vr.advInterval = secs
+	vr.expectAdverts()
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if sm != nil {
+		sm.SetAdvertisementInterval(time.Duration(secs) * time.Second)
+	}
+
+	vr.logger.Info("Advertisement interval changed", "advert_interval", secs)
+	return nil
+}
+
+// SetPreempt enables or disables preemption of a lower-priority master
+func (vr *VirtualRouter) SetPreempt(preempt bool) {
+	vr.mu.Lock()
+	vr.preempt = preempt
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if sm != nil {
+		sm.SetPreempt(preempt)
+	}
+
+	vr.logger.Info("Preemption changed", "preempt", preempt)
+}
+
+// SetVirtualIPs replaces the virtual IP list, reprogramming addresses if MASTER
+func (vr *VirtualRouter) SetVirtualIPs(addrs []string) error {
+	ips, err := parseVirtualIPs(addrs)
+	if err != nil {
+		return err
+	}
+
+	vr.mu.Lock()
+	vr.ips = ips
+	vr.expectAdverts()
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if sm != nil {
+		sm.SetVirtualIPs(ips)
+	}
+
+	vr.logger.Info("Virtual IPs changed", "virtual_ips", ips)
+	return nil
+}
+
+// SetExcludedIPs replaces the excluded IP list, reprogramming addresses if
+// MASTER. Unlike Config.ExcludedIPs the list may repeat virtual IPs, which
+// are then held once, so an address can move between the lists in two
+// steps without being released.
+func (vr *VirtualRouter) SetExcludedIPs(addrs []string) error {
+	excluded, err := parseIPv4s(addrs)
+	if err != nil {
+		return err
+	}
+
+	vr.mu.Lock()
+	vr.excluded = excluded
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if sm != nil {
+		sm.SetExcludedIPs(excluded)
+	}
+
+	vr.logger.Info("Excluded IPs changed", "excluded_ips", excluded)
+	return nil
+}
+
+// setAddresses replaces the virtual and excluded IPs at once, so a MASTER
+// keeps an address moving from one list to the other
+func (vr *VirtualRouter) setAddresses(ips, excluded []net.IP) {
+	vr.mu.Lock()
+	vr.ips, vr.excluded = ips, excluded
+	vr.expectAdverts()
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if sm != nil {
+		sm.exec(func() { sm.setAddresses(ips, excluded) })
+	}
+
+	vr.logger.Info("Virtual IPs changed", "virtual_ips", ips, "excluded_ips", excluded)
+}
+
+func (vr *VirtualRouter) GetAdvertInterval() int {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	return vr.advInterval
+}
+
+func (vr *VirtualRouter) GetPreempt() bool {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	return vr.preempt
 }
 
 func (vr *VirtualRouter) IsRunning() bool {
@@ -189,3 +1616,105 @@ func (vr *VirtualRouter) IsRunning() bool {
 	defer vr.mu.RUnlock()
 	return vr.running
 }
+
+// QueueLengths returns the state machine's channel depths, or zeros if the
+// router has not been started
+func (vr *VirtualRouter) QueueLengths() QueueLengths {
+	vr.mu.RLock()
+	sm := vr.stateMachine
+	vr.mu.RUnlock()
+
+	if sm == nil {
+		return QueueLengths{}
+	}
+	return sm.QueueLengths()
+}
+
+// Status returns a snapshot of the router's current state and counters
+func (vr *VirtualRouter) Status() Status {
+	state := vr.GetState()
+
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	vr.statsMu.Lock()
+	defer vr.statsMu.Unlock()
+
+	st := Status{
+		VRID:            vr.vrid,
+		Interface:       vr.iface,
+		State:           state,
+		Priority:        vr.advertised,
+		VirtualIPs:      cloneIPs(vr.ips),
+		ExcludedIPs:     cloneIPs(vr.excluded),
+		Running:         vr.running,
+		StartedAt:       vr.startedAt,
+		LastTransition:  vr.lastTransition,
+		Peer:            vr.peer.clone(),
+		Peers:           vr.peers.snapshot(),
+		AdvertsSent:     vr.advertsSent.Load(),
+		AdvertsReceived: vr.advertsReceived.Load(),
+		Trackers:        vr.tracking.snapshot(),
+		Observe:         vr.observe,
+		BackupOnly:      vr.backupOnly,
+
+		ConfiguredPriority: vr.priority,
+	}
+
+	st.PacketsDropped = vr.packetsDropped()
+	if vr.syncMember != nil {
+		st.SyncGroup = vr.syncMember.group.name
+	}
+
+	// As BACKUP the only router still advertising is the master
+	switch st.State {
+	case Master:
+		if vr.transport != nil {
+			st.Master = PeerInfo{
+				SourceIP:    slices.Clone(vr.transport.GetSourceIP()),
+				Priority:    st.Priority,
+				AdvInterval: time.Duration(vr.advInterval) * time.Second,
+				LastSeen:    vr.lastSent,
+			}
+		}
+	case Backup:
+		if vr.master.SourceIP != nil && time.Since(vr.master.LastSeen) <= vr.masterSilence(vr.master) {
+			st.Master = vr.master.clone()
+		}
+	}
+	st.MasterIP = st.Master.SourceIP
+
+	return st
+}
+
+// masterSilence is how long master may go without advertising before it is
+// no longer taken to be MASTER: the master down interval, with at most a
+// second of skew time
+func (vr *VirtualRouter) masterSilence(master PeerInfo) time.Duration {
+	interval := master.AdvInterval
+	if interval == 0 {
+		interval = time.Duration(vr.advInterval) * time.Second
+	}
+	return 3*interval + time.Second
+}
+
+// packetsDropped counts packets lost to full channels or that could not be
+// decoded as advertisements
+func (vr *VirtualRouter) packetsDropped() uint64 {
+	n := vr.decodeErrors.Load() + vr.versionErrors.Load() + vr.invalidTypeRecv.Load()
+	if vr.stateMachine != nil {
+		n += vr.stateMachine.DroppedPackets()
+	}
+	return n
+}
+
+// Counters returns the current protocol counters
+func (vr *VirtualRouter) Counters() Counters {
+	return vr.stats(false).Counters
+}
+
+// ResetCounters zeroes the protocol counters, returning their values from
+// just before the reset so no increment is lost in between. It resets
+// everything ResetStats does.
+func (vr *VirtualRouter) ResetCounters() Counters {
+	return vr.stats(true).Counters
+}
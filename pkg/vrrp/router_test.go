@@ -0,0 +1,1030 @@
+package vrrp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func newTestRouter(t *testing.T) *VirtualRouter {
+	t.Helper()
+
+	vr, err := NewVirtualRouter(&Config{
+		VRID:       10,
+		Priority:   100,
+		Interface:  "test0",
+		VirtualIPs: []string{"192.168.1.100"},
+	})
+	if err != nil {
+		t.Fatalf("NewVirtualRouter: %v", err)
+	}
+
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	vr.stateMachine = NewStateMachine(vr.vrid, vr.priority, vr.ips, iface)
+	return vr
+}
+
+func marshalAdvert(t *testing.T, vrid, priority uint8) []byte {
+	t.Helper()
+
+	data, err := NewPacket(VRRPv2, vrid, priority, []net.IP{net.ParseIP("192.168.1.100").To4()}).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return data
+}
+
+func TestHandleAdvertCounters(t *testing.T) {
+	vr := newTestRouter(t)
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+
+	badChecksum := marshalAdvert(t, 10, 100)
+	badChecksum[6] ^= 0xff
+
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 0), ownIP)
+	vr.handleAdvert(peer, marshalAdvert(t, 20, 100), ownIP)
+	vr.handleAdvert(peer, badChecksum, ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: peer.Src, TTL: 1}, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: ownIP, TTL: 255}, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(peer, []byte{0x21}, ownIP)
+
+	want := Counters{
+		AdvertsReceived:      2,
+		ChecksumErrors:       1,
+		TTLErrors:            1,
+		VRIDMismatches:       1,
+		PriorityZeroReceived: 1,
+		PacketLengthErrors:   1,
+		PacketsDropped:       1,
+	}
+	if got := vr.Counters(); got != want {
+		t.Errorf("Counters() = %+v, want %+v", got, want)
+	}
+
+	// Our own looped-back advert is counted, but not as PacketsDropped
+	if got := vr.GetStats().Drops[DropOwn]; got != 1 {
+		t.Errorf("Drops[DropOwn] = %d, want 1", got)
+	}
+
+	// Only the two valid adverts for our VRID reach the state machine
+	if got := vr.stateMachine.QueueLengths().Recv; got != 2 {
+		t.Errorf("state machine received %d packets, want 2", got)
+	}
+}
+
+func TestAdvertReceivedCallback(t *testing.T) {
+	vr := newTestRouter(t)
+	var got []Advert
+	vr.SetAdvertReceivedCallback(func(a Advert) { got = append(got, a) })
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(peer, marshalAdvert(t, 20, 100), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: peer.Src, TTL: 1}, marshalAdvert(t, 10, 150), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: ownIP, TTL: 255}, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(peer, []byte{0x21}, ownIP)
+	vr.allowedPeers.Store(&[]netip.Prefix{netip.MustParsePrefix("10.0.0.3/32")})
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 200), ownIP)
+
+	// Only the decoded advertisements for VRID 10 from others, accepted or not
+	want := []struct {
+		priority uint8
+		dropped  DropReason
+	}{{100, ""}, {150, DropTTL}, {200, DropPeer}}
+	if len(got) != len(want) {
+		t.Fatalf("callback called %d times, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		a := got[i]
+		if a.Packet.Priority != w.priority || a.Dropped != w.dropped || !a.Header.Src.Equal(peer.Src) {
+			t.Errorf("advert %d = priority %d dropped %q from %s, want priority %d dropped %q from %s",
+				i, a.Packet.Priority, a.Dropped, a.Header.Src, w.priority, w.dropped, peer.Src)
+		}
+		if len(a.Payload) == 0 || a.Time.IsZero() {
+			t.Errorf("advert %d has no payload or time: %+v", i, a)
+		}
+	}
+}
+
+func TestAdvertSentCallback(t *testing.T) {
+	mt := &memTransport{
+		iface: &net.Interface{Index: 7, Name: "mem0"},
+		ip:    net.IPv4(10, 0, 0, 1).To4(),
+		in:    make(chan []byte),
+		out:   make(chan []byte, 64),
+	}
+	vr, err := New("", 10, WithVIPs("192.168.1.100"), WithPriority(255), WithTransport(mt),
+		WithAddressBackend(AddressNoop), WithVersionPolicy(VersionTranslate),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	sent := make(chan Advert, 64)
+	vr.SetAdvertSentCallback(func(a Advert) {
+		pkt := *a.Packet
+		a.Packet = &pkt
+		sent <- a
+	})
+	if err := vr.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	var first []Advert
+	for len(first) < 2 {
+		select {
+		case a := <-sent:
+			first = append(first, a)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no advertisement observed")
+		}
+	}
+	if err := vr.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	// Each advertisement is followed by its VRRPv3 copy
+	if a := first[0]; a.Translated || a.Packet.Version != VRRPv2 || a.Packet.Priority != 255 {
+		t.Errorf("first advert = version %d priority %d translated %v, want a VRRPv2 one of priority 255",
+			a.Packet.Version, a.Packet.Priority, a.Translated)
+	}
+	if a := first[1]; !a.Translated || a.Packet.Version != VRRPv3 || a.Packet.VRID != 10 {
+		t.Errorf("second advert = version %d VRID %d translated %v, want the VRRPv3 copy",
+			a.Packet.Version, a.Packet.VRID, a.Translated)
+	}
+	var last Advert
+	for len(sent) > 0 {
+		if a := <-sent; !a.Translated {
+			last = a
+		}
+	}
+	if last.Packet == nil || last.Packet.Priority != 0 {
+		t.Errorf("last advert sent is not the priority 0 one: %+v", last)
+	}
+}
+
+// recordingMetrics keeps the events reported to it
+type recordingMetrics struct {
+	NopMetrics
+
+	mu       sync.Mutex
+	received []uint8
+	drops    []DropReason
+}
+
+func (m *recordingMetrics) AdvertReceived(_ string, _ uint8, priority uint8) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received = append(m.received, priority)
+}
+
+func (m *recordingMetrics) PacketDropped(_ string, _ uint8, reason DropReason) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drops = append(m.drops, reason)
+}
+
+func TestHandleAdvertMetrics(t *testing.T) {
+	vr := newTestRouter(t)
+	m := &recordingMetrics{}
+	vr.metrics = m
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 120), ownIP)
+	vr.handleAdvert(peer, marshalAdvert(t, 20, 100), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: peer.Src, TTL: 1}, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(peer, []byte{0x21}, ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: ownIP, TTL: 255}, marshalAdvert(t, 10, 100), ownIP)
+
+	if want := []uint8{120}; !reflect.DeepEqual(m.received, want) {
+		t.Errorf("AdvertReceived priorities = %v, want %v", m.received, want)
+	}
+	if want := []DropReason{DropVRIDMismatch, DropTTL, DropDecode, DropOwn}; !reflect.DeepEqual(m.drops, want) {
+		t.Errorf("PacketDropped reasons = %v, want %v", m.drops, want)
+	}
+}
+
+func TestHandleAdvertProtocolErrors(t *testing.T) {
+	vr := newTestRouter(t)
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+
+	marshal := func(modify func(p *Packet)) []byte {
+		p := NewPacket(VRRPv2, 10, 100, []net.IP{net.ParseIP("192.168.1.100").To4()})
+		modify(p)
+		data, err := p.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return data
+	}
+
+	badVersion := marshalAdvert(t, 10, 100)
+	badVersion[0] = 0x11
+	vr.handleAdvert(peer, badVersion, ownIP)
+	vr.handleAdvert(peer, marshal(func(p *Packet) { p.Type = 2 }), ownIP)
+	vr.handleAdvert(peer, marshal(func(p *Packet) { p.AdvInterval = 3 }), ownIP)
+	vr.handleAdvert(peer, marshal(func(p *Packet) {
+		p.IPAddresses = []net.IP{net.ParseIP("192.168.1.200").To4()}
+	}), ownIP)
+
+	s := vr.GetStats()
+	want := Counters{
+		VersionErrors:       1,
+		InvalidTypeReceived: 1,
+		AdvIntervalErrors:   1,
+		AddressListErrors:   1,
+		AdvertsReceived:     2,
+		PacketsDropped:      2,
+	}
+	if s.Counters != want {
+		t.Errorf("Counters = %+v, want %+v", s.Counters, want)
+	}
+	if s.LastProtocolError != DropType {
+		t.Errorf("LastProtocolError = %q, want %q", s.LastProtocolError, DropType)
+	}
+
+	// Mismatched advertisements are still processed
+	if got := vr.stateMachine.QueueLengths().Recv; got != 2 {
+		t.Errorf("state machine received %d packets, want 2", got)
+	}
+
+	// The expectations follow the configuration
+	if err := vr.SetAdvertInterval(3); err != nil {
+		t.Fatalf("SetAdvertInterval: %v", err)
+	}
+	vr.handleAdvert(peer, marshal(func(p *Packet) { p.AdvInterval = 3 }), ownIP)
+	if got := vr.GetStats().AdvIntervalErrors; got != 1 {
+		t.Errorf("AdvIntervalErrors after matching the interval = %d, want 1", got)
+	}
+
+	since := s.CountersSince
+	if since.IsZero() {
+		t.Error("CountersSince is zero, want the router's creation")
+	}
+	vr.ResetStats()
+	if s := vr.GetStats(); !s.CountersSince.After(since) || s.LastProtocolError != DropType {
+		t.Errorf("after reset CountersSince = %v (was %v), LastProtocolError = %q; want later and kept",
+			s.CountersSince, since, s.LastProtocolError)
+	}
+}
+
+func TestZeroAddressAdverts(t *testing.T) {
+	vr := newTestRouter(t)
+
+	ownIP := net.ParseIP("10.0.0.1")
+	// From two routers, as the VRRPv3 copy of a router advertising both
+	// versions is dropped
+	for i, version := range []uint8{VRRPv2, VRRPv3} {
+		peer := &ipv4.Header{Src: net.IPv4(10, 0, 0, byte(2+i)), TTL: 255}
+		pkt := NewPacket(version, 10, 200, nil)
+		if version == VRRPv3 {
+			pkt.AdvInterval = 100
+		}
+		data, err := pkt.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		vr.handleAdvert(peer, data, ownIP)
+	}
+
+	// Both take part in the election and count as address list mismatches
+	s := vr.GetStats()
+	if s.AdvertsReceived != 2 || s.AddressListErrors != 2 || s.PacketsDropped != 0 {
+		t.Errorf("received %d, %d address list errors, %d dropped; want 2, 2 and 0",
+			s.AdvertsReceived, s.AddressListErrors, s.PacketsDropped)
+	}
+	if got := vr.stateMachine.QueueLengths().Recv; got != 2 {
+		t.Errorf("state machine received %d packets, want 2", got)
+	}
+
+	// A tie with one is won by the router advertising its VIPs
+	vr.stateMachine.sourceIP = ownIP
+	if got := vr.stateMachine.compareSourceIP(NewPacket(VRRPv2, 10, 100, nil)); got != 1 {
+		t.Errorf("compareSourceIP with no addresses = %d, want 1", got)
+	}
+}
+
+func TestSplitBrain(t *testing.T) {
+	vr := newTestRouter(t)
+
+	var reported []SplitBrain
+	vr.SetSplitBrainCallback(func(sb SplitBrain) { reported = append(reported, sb) })
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	advertise := func(n int) {
+		for range n {
+			vr.handleAdvert(peer, marshalAdvert(t, 10, 90), ownIP)
+		}
+	}
+
+	// A BACKUP hears the master's advertisements all the time
+	vr.onStateChange(Init, Backup)
+	advertise(splitBrainAdverts)
+	if len(reported) != 0 {
+		t.Fatalf("BACKUP reported %v, want nothing", reported)
+	}
+
+	vr.onStateChange(Backup, Master)
+	advertise(splitBrainAdverts - 1)
+	if len(reported) != 0 {
+		t.Fatalf("reported %v below the threshold, want nothing", reported)
+	}
+	advertise(2)
+	if len(reported) != 1 {
+		t.Fatalf("reported %d split brains, want 1 per peer", len(reported))
+	}
+	if sb := reported[0]; sb.Kind != SplitBrainAdverts || !sb.Peer.Equal(peer.Src) || sb.PeerPriority != 90 {
+		t.Errorf("reported %+v, want adverts from %s at priority 90", sb, peer.Src)
+	}
+
+	own := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	other := net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	vip := net.ParseIP("192.168.1.100")
+	vr.watchARP(own, vip, own)
+	vr.watchARP(other, net.ParseIP("192.168.1.1"), own)
+	vr.watchARP(other, vip, own)
+	vr.watchARP(other, vip, own)
+	if len(reported) != 2 {
+		t.Fatalf("reported %d split brains, want one more for the VIP answered by %s", len(reported), other)
+	}
+	if sb := reported[1]; sb.Kind != SplitBrainARP || !sb.Peer.Equal(vip) || sb.PeerMAC.String() != other.String() {
+		t.Errorf("reported %+v, want %s answered by %s", sb, vip, other)
+	}
+
+	if got := vr.GetStats().SplitBrains; got != 2 {
+		t.Errorf("SplitBrains = %d, want 2", got)
+	}
+
+	// Each period as MASTER starts over
+	vr.onStateChange(Master, Backup)
+	vr.onStateChange(Backup, Master)
+	advertise(splitBrainAdverts)
+	if len(reported) != 3 {
+		t.Errorf("reported %d split brains after becoming MASTER again, want 3", len(reported))
+	}
+}
+
+func TestAdvertJitter(t *testing.T) {
+	vr := newTestRouter(t)
+	peer := net.ParseIP("10.0.0.2")
+	advert := NewPacket(VRRPv2, 10, 100, nil)
+
+	start := time.Now()
+	vr.statsMu.Lock()
+	for i, arrival := range []time.Duration{0, 1010 * time.Millisecond, 2000 * time.Millisecond, time.Minute} {
+		if _, ok := vr.latency.observeArrival(advert, peer, start.Add(arrival)); ok != (i == 1 || i == 2) {
+			t.Errorf("advertisement %d: ok = %v", i, ok)
+		}
+	}
+	vr.statsMu.Unlock()
+
+	// 10ms late, then 10ms early; the minute-long gap is not jitter
+	h := vr.GetStats().Jitter[peer.String()]
+	if h.Count != 2 || h.Sum != 20*time.Millisecond {
+		t.Errorf("jitter of %s: %d observations summing to %v, want 2 and 20ms", peer, h.Count, h.Sum)
+	}
+
+	vr.onFailover(30 * time.Millisecond)
+	s := vr.ResetStats()
+	if s.FailoverLatency.Count != 1 || s.FailoverLatency.Sum != 30*time.Millisecond {
+		t.Errorf("FailoverLatency = %+v, want one 30ms takeover", s.FailoverLatency)
+	}
+	if s := vr.GetStats(); s.FailoverLatency.Count != 0 || len(s.Jitter) != 0 {
+		t.Errorf("after reset: FailoverLatency %+v, Jitter %v; want empty", s.FailoverLatency, s.Jitter)
+	}
+}
+
+func TestResetCounters(t *testing.T) {
+	vr := newTestRouter(t)
+
+	vr.onStateChange(Backup, Master)
+	vr.advertsSent.Add(3)
+
+	got := vr.ResetCounters()
+	if got.BecomeMaster != 1 || got.AdvertsSent != 3 {
+		t.Errorf("ResetCounters() = %+v, want BecomeMaster 1 and AdvertsSent 3", got)
+	}
+
+	if got := vr.Counters(); got != (Counters{}) {
+		t.Errorf("Counters() after reset = %+v, want zero", got)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	vr := newTestRouter(t)
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+
+	vr.onStateChange(Init, Backup)
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 120), ownIP)
+	vr.handleAdvert(peer, marshalAdvert(t, 20, 100), ownIP)
+	vr.handleAdvert(peer, []byte{0x21}, ownIP)
+
+	failing := meteredAddresses{
+		AddressManager: &recordingAddresses{},
+		metrics:        NopMetrics{},
+		failures:       &vr.vipErrors,
+	}
+	_ = failing.AddIP(net.ParseIP("192.168.1.100"))
+	_ = failing.DelIP(net.ParseIP("192.168.1.101"))
+
+	s := vr.GetStats()
+	if s.Interface != "test0" || s.VRID != 10 {
+		t.Errorf("GetStats() identifies %s VRID %d, want test0 VRID 10", s.Interface, s.VRID)
+	}
+	if s.StateSince.IsZero() || s.StateUptime < 0 {
+		t.Errorf("StateSince = %v, StateUptime = %v, want the Backup transition", s.StateSince, s.StateUptime)
+	}
+	if s.Transitions != 1 || s.AdvertsReceived != 1 || s.VIPErrors != 1 {
+		t.Errorf("Transitions %d, AdvertsReceived %d, VIPErrors %d, want 1 each",
+			s.Transitions, s.AdvertsReceived, s.VIPErrors)
+	}
+	wantDrops := map[DropReason]uint64{
+		DropDecode: 1, DropVersion: 0, DropType: 0, DropChecksum: 0, DropTTL: 0, DropVRIDMismatch: 1, DropQueueFull: 0,
+		DropOwn: 0, DropPeer: 0, DropNotOnLink: 0, DropThrottled: 0, DropAuth: 0, DropReplay: 0, DropInterval: 0,
+		DropForeignVersion: 0, DropAddressList: 0,
+	}
+	if !reflect.DeepEqual(s.Drops, wantDrops) {
+		t.Errorf("Drops = %v, want %v", s.Drops, wantDrops)
+	}
+	if !s.LastAdvert.SourceIP.Equal(peer.Src) || s.LastAdvert.Priority != 120 {
+		t.Errorf("LastAdvert = %+v, want %s priority 120", s.LastAdvert, peer.Src)
+	}
+	if s.Counters != vr.Counters() {
+		t.Errorf("Stats.Counters = %+v, want Counters() %+v", s.Counters, vr.Counters())
+	}
+
+	vr.ResetStats()
+	s = vr.GetStats()
+	if s.Transitions != 0 || s.VIPErrors != 0 || s.Counters != (Counters{}) {
+		t.Errorf("GetStats() after reset = %+v, want zero counters", s)
+	}
+	if s.StateSince.IsZero() || s.LastAdvert.SourceIP == nil {
+		t.Error("ResetStats cleared the state or last advert, want them kept")
+	}
+}
+
+func TestGettersReturnCopies(t *testing.T) {
+	vr := newTestRouter(t)
+	vr.peer = PeerInfo{SourceIP: net.ParseIP("10.0.0.2").To4(), Priority: 120}
+
+	vr.GetVirtualIPs()[0][3] = 1
+	vr.Status().VirtualIPs[0][3] = 1
+	vr.Status().Peer.SourceIP[3] = 1
+	vr.GetStats().LastAdvert.SourceIP[3] = 1
+
+	if got := vr.GetVirtualIPs()[0]; !got.Equal(net.ParseIP("192.168.1.100")) {
+		t.Errorf("virtual IP = %s after modifying a returned copy, want 192.168.1.100", got)
+	}
+	if got := vr.Status().Peer.SourceIP; !got.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("peer = %s after modifying a returned copy, want 10.0.0.2", got)
+	}
+}
+
+// TestConcurrentGetters runs under -race: the getters must be safe while the
+// router starts and stops
+func TestConcurrentGetters(t *testing.T) {
+	if _, err := net.InterfaceByName("lo"); err != nil {
+		t.Skip("no loopback interface")
+	}
+
+	vr, err := New("lo", 10, WithVIPs("127.0.0.10"), WithPriority(255), WithDryRun(true),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				vr.GetState()
+				vr.GetPriority()
+				vr.GetVirtualIPs()
+				vr.Status()
+				vr.GetStats()
+				vr.QueueLengths()
+			}
+		}()
+	}
+
+	// Each Stop waits up to a second for the receive loop's read deadline
+	for range 2 {
+		if err := vr.Start(context.Background()); err != nil {
+			cancel()
+			wg.Wait()
+			t.Fatalf("Start: %v", err)
+		}
+		if err := vr.SetPriority(200); err != nil {
+			t.Errorf("SetPriority: %v", err)
+		}
+		if err := vr.Stop(context.Background()); err != nil {
+			t.Errorf("Stop: %v", err)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestStopContext(t *testing.T) {
+	if _, err := net.InterfaceByName("lo"); err != nil {
+		t.Skip("no loopback interface")
+	}
+
+	vr, err := New("lo", 10, WithVIPs("127.0.0.10"), WithPriority(255), WithDryRun(true),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := vr.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := vr.Stop(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Stop with a canceled context = %v, want context.Canceled", err)
+	}
+
+	// Teardown carries on without the caller
+	select {
+	case <-vr.Done():
+	case <-time.After(loopExitTimeout + time.Second):
+		t.Fatal("router did not finish tearing down")
+	}
+	if vr.IsRunning() {
+		t.Error("router still running after Done was closed")
+	}
+}
+
+func TestDryRunSendLoop(t *testing.T) {
+	vr := newTestRouter(t)
+	vr.dryRun = true
+	vr.ctx, vr.cancel = context.WithCancel(context.Background())
+
+	// network is nil: a dry run must never reach it
+	vr.wg.Add(1)
+	go vr.sendLoop()
+	vr.stateMachine.sendCh <- NewPacket(VRRPv2, vr.vrid, vr.priority, vr.ips)
+
+	deadline := time.Now().Add(time.Second)
+	for vr.stateMachine.QueueLengths().Send != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	vr.cancel()
+	vr.wg.Wait()
+
+	if got := vr.Counters().AdvertsSent; got != 0 {
+		t.Errorf("AdvertsSent = %d, want 0 in a dry run", got)
+	}
+}
+
+// memTransport is a Transport whose messages are read from in and written to
+// out
+type memTransport struct {
+	iface *net.Interface
+	ip    net.IP
+	in    chan []byte
+	out   chan []byte
+}
+
+func (m *memTransport) GetInterface() *net.Interface { return m.iface }
+func (m *memTransport) GetSourceIP() net.IP          { return m.ip }
+
+func (m *memTransport) WriteMessage(msg []byte) error {
+	m.out <- bytes.Clone(msg)
+	return nil
+}
+
+func (m *memTransport) ReceiveRaw(ctx context.Context, handler func(*ipv4.Header, []byte)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-m.in:
+			header, err := ipv4.ParseHeader(msg)
+			if err != nil {
+				return err
+			}
+			handler(header, msg[header.Len:])
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	mt := &memTransport{
+		iface: &net.Interface{Index: 7, Name: "mem0"},
+		ip:    net.IPv4(10, 0, 0, 1).To4(),
+		in:    make(chan []byte),
+		out:   make(chan []byte, 16),
+	}
+	vr, err := New("", 10, WithVIPs("192.168.1.100"), WithPriority(255), WithTransport(mt),
+		WithAddressBackend(AddressNoop), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if got := vr.Status().Interface; got != "mem0" {
+		t.Errorf("Status().Interface = %q, want the transport's mem0", got)
+	}
+	if err := vr.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	next := func() (*ipv4.Header, *Packet) {
+		t.Helper()
+		select {
+		case msg := <-mt.out:
+			header, err := ipv4.ParseHeader(msg)
+			if err != nil {
+				t.Fatalf("ParseHeader: %v", err)
+			}
+			var pkt Packet
+			if err := pkt.Unmarshal(msg[header.Len:]); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			return header, &pkt
+		case <-time.After(2 * time.Second):
+			t.Fatal("no advertisement written to the transport")
+			return nil, nil
+		}
+	}
+	header, pkt := next()
+	if !header.Src.Equal(mt.ip) || pkt.VRID != 10 || pkt.Priority != 255 {
+		t.Errorf("sent from %s VRID %d priority %d, want from %s VRID 10 priority 255",
+			header.Src, pkt.VRID, pkt.Priority, mt.ip)
+	}
+
+	// An advertisement from a peer arrives through the transport
+	peer := ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TTL: 255, Protocol: VRRPProtocol,
+		Src: net.IPv4(10, 0, 0, 2), Dst: multicastGroup}
+	data := marshalAdvert(t, 10, 100)
+	peer.TotalLen = ipv4.HeaderLen + len(data)
+	h, err := peer.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	mt.in <- append(h, data...)
+	deadline := time.Now().Add(2 * time.Second)
+	for vr.Counters().AdvertsReceived == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := vr.Counters().AdvertsReceived; got != 1 {
+		t.Errorf("AdvertsReceived = %d, want 1", got)
+	}
+
+	if err := vr.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	for {
+		if _, pkt := next(); pkt.Priority == 0 {
+			break
+		}
+	}
+}
+
+func TestConfigInterface(t *testing.T) {
+	eth0 := &net.Interface{Index: 2, Name: "eth0"}
+	for _, tc := range []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Interface: "eth0"}, "eth0"},
+		{Config{NetInterface: eth0}, "eth0"},
+		{Config{Interface: "eth0", NetInterface: eth0}, "eth0"},
+		{Config{Interface: "eth1", NetInterface: eth0}, ""},
+		{Config{Transport: &memTransport{iface: eth0}}, "eth0"},
+		{Config{Transport: &memTransport{}}, ""},
+		{Config{}, ""},
+	} {
+		got, err := configInterface(&tc.cfg)
+		if got != tc.want || (err == nil) != (tc.want != "") {
+			t.Errorf("configInterface(%+v) = %q, %v, want %q", tc.cfg, got, err, tc.want)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("configInterface(%+v) = %v, want ErrInvalidConfig", tc.cfg, err)
+		}
+	}
+}
+
+func TestWaitForState(t *testing.T) {
+	vr := newTestRouter(t)
+	vr.running = true
+	vr.stopped = make(chan struct{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- vr.WaitForState(ctx, Master) }()
+
+	vr.stateMachine.transition(Backup)
+	vr.onStateChange(Init, Backup)
+	vr.stateMachine.transition(Master)
+	vr.onStateChange(Backup, Master)
+
+	if err := <-errCh; err != nil {
+		t.Errorf("WaitForState(MASTER) = %v, want nil", err)
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	if err := vr.WaitForState(short, Backup); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForState(BACKUP) = %v, want a deadline error", err)
+	}
+
+	vr.running = false
+	if err := vr.WaitForState(ctx, Backup); err == nil {
+		t.Error("WaitForState on a stopped router succeeded")
+	}
+}
+
+func TestWatchState(t *testing.T) {
+	vr := newTestRouter(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := vr.WatchState(ctx)
+
+	vr.onStateChange(Init, Backup)
+	vr.onStateChange(Backup, Master)
+
+	for _, want := range []State{Backup, Master} {
+		if got := <-changes; got.New != want {
+			t.Errorf("WatchState delivered %v, want %v", got.New, want)
+		}
+	}
+
+	cancel()
+	for range changes {
+		t.Error("WatchState delivered a transition after cancellation")
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	for _, cfg := range []*Config{
+		{VRID: 0, Interface: "eth0", VirtualIPs: []string{"192.168.1.100"}},
+		{VRID: 10, VirtualIPs: []string{"192.168.1.100"}},
+		{VRID: 10, Interface: "eth0", VirtualIPs: []string{"2001:db8::1"}},
+		{VRID: 10, Interface: "eth0", VirtualIPs: []string{"192.168.1.100"}, AdvInterval: 256},
+	} {
+		if _, err := NewVirtualRouter(cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("NewVirtualRouter(%+v) = %v, want ErrInvalidConfig", cfg, err)
+		}
+	}
+
+	vr := newTestRouter(t)
+	if err := vr.SetPriority(0); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("SetPriority(0) = %v, want ErrInvalidConfig", err)
+	}
+	if err := vr.Stop(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Stop() = %v, want ErrNotRunning", err)
+	}
+	if err := vr.Failover(time.Second); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Failover() = %v, want ErrNotRunning", err)
+	}
+
+	err := wrapPermission(fmt.Errorf("socket: %w", syscall.EPERM))
+	if !errors.Is(err, ErrPermission) || !errors.Is(err, os.ErrPermission) {
+		t.Errorf("wrapPermission(EPERM) = %v, want ErrPermission and os.ErrPermission", err)
+	}
+	if err := wrapPermission(syscall.ENODEV); errors.Is(err, ErrPermission) {
+		t.Errorf("wrapPermission(ENODEV) = %v, want it unchanged", err)
+	}
+}
+
+func TestTransitionPeer(t *testing.T) {
+	vr := newTestRouter(t)
+	vr.stateMachine.SetTransitionCallback(vr.onTransition)
+
+	var got []Transition
+	vr.SetTransitionCallback(func(tr Transition) { got = append(got, tr) })
+
+	vr.stateMachine.transition(Master)
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 150), net.ParseIP("10.0.0.1"))
+	vr.stateMachine.handlePacket(<-vr.stateMachine.recvCh)
+
+	if len(got) != 2 {
+		t.Fatalf("got %d transitions, want 2", len(got))
+	}
+	if got[0].Peer.SourceIP != nil {
+		t.Errorf("first transition peer = %+v, want none heard yet", got[0].Peer)
+	}
+	tr := got[1]
+	if tr.Cause != CausePreemptedBy || !tr.Peer.SourceIP.Equal(peer.Src) || tr.Peer.Priority != 150 {
+		t.Errorf("transition = %+v, want preempted by %s at priority 150", tr, peer.Src)
+	}
+}
+
+func TestStatusMaster(t *testing.T) {
+	vr := newTestRouter(t)
+	vr.stateMachine.transition(Backup)
+
+	ownIP := net.ParseIP("10.0.0.1")
+	peer := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+
+	if st := vr.Status(); st.MasterIP != nil || st.Master.SourceIP != nil {
+		t.Errorf("master before any advert = %+v, want unknown", st.Master)
+	}
+
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 150), ownIP)
+	st := vr.Status()
+	if !st.MasterIP.Equal(peer.Src) || st.Master.Priority != 150 || st.Master.AdvInterval != time.Second ||
+		st.Master.LastSeen.IsZero() {
+		t.Errorf("master = %+v (MasterIP %s), want %s at priority 150 every 1s", st.Master, st.MasterIP, peer.Src)
+	}
+
+	// The master leaving with priority 0 is no longer the master
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 0), ownIP)
+	if st := vr.Status(); st.Master.SourceIP != nil {
+		t.Errorf("master after priority 0 = %+v, want unknown", st.Master)
+	}
+
+	// Nor is one silent for longer than the master down interval
+	vr.handleAdvert(peer, marshalAdvert(t, 10, 150), ownIP)
+	vr.master.LastSeen = time.Now().Add(-5 * time.Second)
+	if st := vr.Status(); st.Master.SourceIP != nil {
+		t.Errorf("master silent for 5s = %+v, want unknown", st.Master)
+	}
+}
+
+func TestPeerTable(t *testing.T) {
+	vr := newTestRouter(t)
+	ownIP := net.ParseIP("10.0.0.1")
+
+	v3, err := NewPacket(VRRPv3, 10, 90, []net.IP{net.ParseIP("192.168.1.100").To4()}).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.3"), TTL: 255}, v3, ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}, marshalAdvert(t, 10, 150), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}, marshalAdvert(t, 10, 0), ownIP)
+	// Neither our own adverts nor those for another VRID are peers
+	vr.handleAdvert(&ipv4.Header{Src: ownIP, TTL: 255}, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.4"), TTL: 255}, marshalAdvert(t, 20, 100), ownIP)
+
+	peers := vr.Status().Peers
+	if len(peers) != 2 {
+		t.Fatalf("peers = %+v, want 10.0.0.2 and 10.0.0.3", peers)
+	}
+	p := peers[0]
+	if !p.SourceIP.Equal(net.ParseIP("10.0.0.2")) || p.Priority != 0 || p.Version != VRRPv2 ||
+		p.AdvInterval != time.Second || p.Adverts != 2 || p.FirstSeen.After(p.LastSeen) {
+		t.Errorf("peers[0] = %+v, want 10.0.0.2, VRRPv2 every 1s, priority 0 after 2 adverts", p)
+	}
+	p = peers[1]
+	if !p.SourceIP.Equal(net.ParseIP("10.0.0.3")) || p.Priority != 90 || p.Version != VRRPv3 ||
+		p.AdvInterval != 10*time.Millisecond || p.Adverts != 1 {
+		t.Errorf("peers[1] = %+v, want 10.0.0.3, VRRPv3 every 10ms at priority 90 after 1 advert", p)
+	}
+
+	// A full table makes room by dropping the peer heard from least recently
+	for i := range MaxPeers {
+		src := net.IPv4(10, 1, byte(i/250), byte(i%250+1))
+		vr.handleAdvert(&ipv4.Header{Src: src, TTL: 255}, marshalAdvert(t, 10, 100), ownIP)
+		for len(vr.stateMachine.recvCh) > 0 {
+			<-vr.stateMachine.recvCh
+		}
+	}
+	peers = vr.Status().Peers
+	if len(peers) != MaxPeers {
+		t.Fatalf("peer table holds %d routers, want %d", len(peers), MaxPeers)
+	}
+	for _, p := range peers {
+		if p.SourceIP.Equal(net.ParseIP("10.0.0.3")) || p.SourceIP.Equal(net.ParseIP("10.0.0.2")) {
+			t.Errorf("peer table still holds %s after %d newer routers were heard", p.SourceIP, MaxPeers)
+		}
+	}
+}
+
+// captured records the messages passed to a PacketCapture
+type captured struct {
+	sources []string
+}
+
+func (c *captured) CapturePacket(_ time.Time, header *ipv4.Header, _ []byte) {
+	c.sources = append(c.sources, header.Src.String())
+}
+
+func TestCaptureReceived(t *testing.T) {
+	vr := newTestRouter(t)
+	c := &captured{}
+	vr.capture = c
+	ownIP := net.ParseIP("10.0.0.1")
+
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}, marshalAdvert(t, 10, 150), ownIP)
+	// Captured although discarded: the capture is for troubleshooting
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.3"), TTL: 64}, marshalAdvert(t, 10, 150), ownIP)
+	// Not ours to capture: another VRID, our own advert looped back and a
+	// message too short to tell
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.4"), TTL: 255}, marshalAdvert(t, 20, 150), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: ownIP, TTL: 255}, marshalAdvert(t, 10, 100), ownIP)
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.5"), TTL: 255}, []byte{0x21}, ownIP)
+
+	if got := strings.Join(c.sources, ","); got != "10.0.0.2,10.0.0.3" {
+		t.Errorf("captured from %s, want 10.0.0.2,10.0.0.3", got)
+	}
+}
+
+// BenchmarkHandleAdvert is the receive path of an advertisement the router
+// accepts, up to the state machine
+func BenchmarkHandleAdvert(b *testing.B) {
+	vr, err := NewVirtualRouter(&Config{
+		VRID: 10, Priority: 100, Interface: "test0", VirtualIPs: []string{"192.168.1.100"},
+		// The same source every time; measure the accepted advertisement
+		MaxAdvertRate: -1,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	vr.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	vr.stateMachine = NewStateMachine(vr.vrid, vr.priority, vr.ips, &net.Interface{Index: 1, Name: "test0"})
+	vr.stateMachine.SetLogger(vr.logger)
+
+	data, err := NewPacket(VRRPv2, 10, 150, []net.IP{net.ParseIP("192.168.1.100").To4()}).Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	header := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	ownIP := net.ParseIP("10.0.0.1")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		vr.handleAdvert(header, data, ownIP)
+		<-vr.stateMachine.recvCh
+	}
+}
+
+func TestObserve(t *testing.T) {
+	mt := &memTransport{
+		iface: &net.Interface{Index: 7, Name: "mem0"},
+		ip:    net.IPv4(10, 0, 0, 1).To4(),
+		in:    make(chan []byte),
+		out:   make(chan []byte, 16),
+	}
+	// Even the address owner only follows the election
+	vr, err := New("", 10, WithVIPs("192.168.1.100"), WithPriority(255), WithTransport(mt), WithObserve(true),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := vr.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := vr.WaitForState(context.Background(), Backup); err != nil {
+		t.Fatalf("WaitForState: %v", err)
+	}
+
+	sendFrom(t, mt, net.IPv4(10, 0, 0, 2), 100)
+	deadline := time.Now().Add(2 * time.Second)
+	for vr.Status().MasterIP == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	st := vr.Status()
+	if !st.Observe || !st.MasterIP.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Errorf("Status() Observe %t, MasterIP %s; want true, 10.0.0.2", st.Observe, st.MasterIP)
+	}
+
+	// The master leaving does not make the observer take over
+	sendFrom(t, mt, net.IPv4(10, 0, 0, 2), 0)
+	time.Sleep(100 * time.Millisecond)
+	if got := vr.GetState(); got != Backup {
+		t.Errorf("state after the master left = %s, want BACKUP", got)
+	}
+	if err := vr.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got := vr.Counters().AdvertsSent; got != 0 || len(mt.out) != 0 {
+		t.Errorf("the observer sent %d advertisements", got)
+	}
+
+	_, err = New("eth0", 10, WithVIPs("192.168.1.100"), WithObserve(true), WithSyncGroup(NewSyncGroup("g")))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("New with an observer in a sync group = %v, want ErrInvalidConfig", err)
+	}
+}
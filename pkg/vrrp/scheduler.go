@@ -0,0 +1,183 @@
+package vrrp
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// timer is an expiration the state machine waits on: a runtime timer of its
+// own, or one kept by a scheduler shared with other state machines
+type timer interface {
+	Chan() <-chan time.Time
+	// Reset restarts the timer to expire after d. A value from before the
+	// reset is not received.
+	Reset(d time.Duration)
+	Stop()
+}
+
+// ownTimer is a one-shot timer on the runtime's timers
+type ownTimer struct{ t *time.Timer }
+
+func (o ownTimer) Chan() <-chan time.Time { return o.t.C }
+func (o ownTimer) Reset(d time.Duration)  { o.t.Reset(d) }
+func (o ownTimer) Stop()                  { o.t.Stop() }
+
+// ownTicker is a periodic timer on the runtime's timers
+type ownTicker struct{ t *time.Ticker }
+
+func (o ownTicker) Chan() <-chan time.Time { return o.t.C }
+func (o ownTicker) Reset(d time.Duration)  { o.t.Reset(d) }
+func (o ownTicker) Stop()                  { o.t.Stop() }
+
+// scheduler keeps the advertisement and master down timers of all the state
+// machines of a Manager. The timers are held in a heap ordered by expiration
+// and only the earliest is armed on the runtime, so hundreds of instances
+// cost one runtime timer and at most one goroutine, which runs while timers
+// expire. Unlike a timing wheel with a fixed tick, expirations are exact,
+// which keeps backups whose skew times differ by a few milliseconds in
+// priority order.
+type scheduler struct {
+	mu     sync.Mutex
+	timers timerHeap
+	wake   *time.Timer
+	// armed is when wake is set to fire, zero if it is not
+	armed time.Time
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{}
+}
+
+// scheduledTimer is a timer kept by a scheduler. Its channel holds one
+// value; like a time.Ticker, expirations the receiver is too slow for are
+// dropped.
+type scheduledTimer struct {
+	s      *scheduler
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+	// index is the position in the heap, -1 while stopped
+	index int
+}
+
+// after returns a timer that expires once, after d
+func (s *scheduler) after(d time.Duration) *scheduledTimer {
+	t := &scheduledTimer{s: s, c: make(chan time.Time, 1), index: -1}
+	t.Reset(d)
+	return t
+}
+
+// every returns a timer that expires every period
+func (s *scheduler) every(period time.Duration) *scheduledTimer {
+	t := &scheduledTimer{s: s, c: make(chan time.Time, 1), period: period, index: -1}
+	t.Reset(period)
+	return t
+}
+
+func (t *scheduledTimer) Chan() <-chan time.Time { return t.c }
+
+func (t *scheduledTimer) Reset(d time.Duration) {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-t.c:
+	default:
+	}
+	if t.period > 0 {
+		t.period = d
+	}
+	t.when = time.Now().Add(d)
+	if t.index >= 0 {
+		heap.Fix(&s.timers, t.index)
+	} else {
+		heap.Push(&s.timers, t)
+	}
+	s.arm()
+}
+
+func (t *scheduledTimer) Stop() {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.index >= 0 {
+		heap.Remove(&s.timers, t.index)
+	}
+}
+
+// arm sets the runtime timer for the earliest expiration, if it is not
+// already set for it. s.mu must be held.
+func (s *scheduler) arm() {
+	if len(s.timers) == 0 {
+		return
+	}
+	next := s.timers[0].when
+	if !s.armed.IsZero() && !s.armed.After(next) {
+		return
+	}
+	s.armed = next
+	d := time.Until(next)
+	if s.wake == nil {
+		s.wake = time.AfterFunc(d, s.expire)
+		return
+	}
+	s.wake.Reset(d)
+}
+
+// expire delivers every expiration that is due and arms the runtime timer
+// for the next
+func (s *scheduler) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.armed = time.Time{}
+	now := time.Now()
+	for len(s.timers) > 0 && !s.timers[0].when.After(now) {
+		t := s.timers[0]
+		select {
+		case t.c <- now:
+		default:
+		}
+		if t.period == 0 {
+			heap.Pop(&s.timers)
+			continue
+		}
+		// Stay on the period's grid, skipping the ticks that were missed
+		t.when = t.when.Add(t.period)
+		if !t.when.After(now) {
+			t.when = now.Add(t.period - now.Sub(t.when)%t.period)
+		}
+		heap.Fix(&s.timers, 0)
+	}
+	s.arm()
+}
+
+// timerHeap orders scheduled timers by expiration, for container/heap
+type timerHeap []*scheduledTimer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*scheduledTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,130 @@
+package vrrp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// pending counts the scheduled timers
+func (s *scheduler) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.timers)
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	s := newScheduler()
+	// Skew times of priorities 101 and 100 at a 1s interval, 4ms apart
+	slow := s.after(40 * time.Millisecond)
+	fast := s.after(36 * time.Millisecond)
+
+	select {
+	case <-fast.Chan():
+	case <-slow.Chan():
+		t.Fatal("the later timer fired first")
+	case <-time.After(time.Second):
+		t.Fatal("no timer fired")
+	}
+	select {
+	case <-slow.Chan():
+	case <-time.After(time.Second):
+		t.Fatal("the later timer did not fire")
+	}
+	if n := s.pending(); n != 0 {
+		t.Errorf("%d one-shot timers left after firing", n)
+	}
+}
+
+func TestSchedulerStopAndReset(t *testing.T) {
+	s := newScheduler()
+	stopped := s.after(20 * time.Millisecond)
+	stopped.Stop()
+
+	reset := s.after(20 * time.Millisecond)
+	reset.Reset(200 * time.Millisecond)
+
+	select {
+	case <-stopped.Chan():
+		t.Error("a stopped timer fired")
+	case <-reset.Chan():
+		t.Error("a reset timer fired at its old expiration")
+	case <-time.After(100 * time.Millisecond):
+	}
+	select {
+	case <-reset.Chan():
+	case <-time.After(time.Second):
+		t.Error("a reset timer did not fire")
+	}
+}
+
+func TestSchedulerPeriodic(t *testing.T) {
+	s := newScheduler()
+	ticker := s.every(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	var last time.Time
+	for i := 0; i < 5; i++ {
+		select {
+		case fired := <-ticker.Chan():
+			if !fired.After(last) {
+				t.Fatalf("tick %d at %v, not after %v", i, fired, last)
+			}
+			last = fired
+		case <-time.After(time.Second):
+			t.Fatalf("tick %d did not come", i)
+		}
+	}
+	if n := s.pending(); n != 1 {
+		t.Errorf("%d timers scheduled, want the ticker", n)
+	}
+}
+
+func TestStateMachineScheduler(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	s := newScheduler()
+
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetAddressManager(NopAddresses{})
+	sm.scheduler = s
+	sm.advertisementInterval = 20 * time.Millisecond
+	sm.masterDownInterval = sm.calculateMasterDownInterval()
+
+	sm.transition(Backup)
+	if _, ok := sm.masterDownTimer.(*scheduledTimer); !ok {
+		t.Fatalf("master down timer is %T, want the scheduler's", sm.masterDownTimer)
+	}
+	select {
+	case <-sm.masterDownTimerChan():
+	case <-time.After(time.Second):
+		t.Fatal("master down timer did not fire")
+	}
+
+	sm.transition(Master)
+	if s.pending() != 1 {
+		t.Fatalf("%d timers scheduled as MASTER, want the advertisement timer", s.pending())
+	}
+	select {
+	case <-sm.advertTimerChan():
+	case <-time.After(time.Second):
+		t.Fatal("advertisement timer did not fire")
+	}
+
+	sm.transition(Init)
+	if s.pending() != 0 {
+		t.Errorf("%d timers left scheduled in INIT", s.pending())
+	}
+}
+
+func BenchmarkSchedulerReset(b *testing.B) {
+	s := newScheduler()
+	timers := make([]*scheduledTimer, 255)
+	for i := range timers {
+		timers[i] = s.after(time.Hour + time.Duration(i)*time.Millisecond)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timers[i%len(timers)].Reset(time.Hour)
+	}
+}
@@ -0,0 +1,168 @@
+package vrrp
+
+import (
+	"net"
+	"slices"
+	"time"
+)
+
+// SplitBrainKind says how another MASTER was noticed
+type SplitBrainKind string
+
+const (
+	// SplitBrainAdverts is another router advertising for the VRID while
+	// this one is MASTER, without either giving way
+	SplitBrainAdverts SplitBrainKind = "adverts"
+	// SplitBrainARP is a virtual IP answered for by another hardware
+	// address while this router is MASTER (Config.DetectVIPConflicts)
+	SplitBrainARP SplitBrainKind = "arp"
+)
+
+// splitBrainAdverts is how many advertisements from another router a MASTER
+// accepts before reporting a split brain. The other router should give way
+// after the first one it hears from this MASTER.
+const splitBrainAdverts = 3
+
+// arpProbeInterval is how often a MASTER checking for address conflicts
+// probes its virtual IPs
+const arpProbeInterval = 10 * time.Second
+
+// SplitBrain reports another router acting as MASTER for this router's
+// virtual IPs while it is MASTER itself, e.g. because the two cannot hear
+// each other's advertisements or preemption is misconfigured. Each peer is
+// reported once per period as MASTER.
+type SplitBrain struct {
+	Kind SplitBrainKind
+	Time time.Time
+
+	// Peer is the source of the other router's advertisements, or for
+	// SplitBrainARP the virtual IP it answered for
+	Peer net.IP
+	// PeerPriority is the priority the other router advertises, for
+	// SplitBrainAdverts
+	PeerPriority uint8
+	// PeerMAC is the hardware address that answered, for SplitBrainARP
+	PeerMAC net.HardwareAddr
+}
+
+// splitBrainWatch is what has been seen of other masters during the current
+// period as MASTER. vr.statsMu guards it.
+type splitBrainWatch struct {
+	master   bool
+	adverts  map[string]int
+	reported map[string]bool
+}
+
+// reset starts over on a state change
+func (w *splitBrainWatch) reset(master bool) {
+	*w = splitBrainWatch{master: master, adverts: make(map[string]int), reported: make(map[string]bool)}
+}
+
+// SetSplitBrainCallback registers fn to be called when a split brain is
+// detected. It must be called before Start. fn runs on the receive loop and
+// must return quickly.
+func (vr *VirtualRouter) SetSplitBrainCallback(fn func(SplitBrain)) {
+	vr.onSplitBrainCb = fn
+}
+
+// watchAdvert counts an advertisement for this router from src. Any
+// advertisement a MASTER hears is from a router that believes it is MASTER
+// too; normally one of them gives way at once.
+func (vr *VirtualRouter) watchAdvert(pkt *Packet, src net.IP) {
+	if pkt.Priority == 0 {
+		return
+	}
+
+	vr.statsMu.Lock()
+	w := &vr.splitBrain
+	if !w.master {
+		vr.statsMu.Unlock()
+		return
+	}
+	key := src.String()
+	w.adverts[key]++
+	report := w.adverts[key] >= splitBrainAdverts && !w.reported[key]
+	w.reported[key] = w.reported[key] || report
+	vr.statsMu.Unlock()
+
+	if report {
+		vr.reportSplitBrain(SplitBrain{
+			Kind:         SplitBrainAdverts,
+			Time:         time.Now(),
+			Peer:         slices.Clone(src),
+			PeerPriority: pkt.Priority,
+		})
+	}
+}
+
+// watchARP checks the sender of an ARP message against the virtual IPs
+func (vr *VirtualRouter) watchARP(mac net.HardwareAddr, ip net.IP, own net.HardwareAddr) {
+	if slices.Equal(mac, own) || !slices.ContainsFunc(vr.expect.Load().ips, ip.Equal) {
+		return
+	}
+
+	vr.statsMu.Lock()
+	w := &vr.splitBrain
+	key := "arp " + ip.String()
+	report := w.master && !w.reported[key]
+	if report {
+		w.reported[key] = true
+	}
+	vr.statsMu.Unlock()
+
+	if report {
+		vr.reportSplitBrain(SplitBrain{Kind: SplitBrainARP, Time: time.Now(), Peer: ip, PeerMAC: mac})
+	}
+}
+
+func (vr *VirtualRouter) reportSplitBrain(sb SplitBrain) {
+	vr.splitBrains.Add(1)
+	vr.metrics.SplitBrain(vr.iface, vr.vrid, sb.Kind)
+
+	attrs := []any{"kind", sb.Kind, "peer", sb.Peer}
+	if sb.Kind == SplitBrainARP {
+		attrs = append(attrs, "peer_mac", sb.PeerMAC)
+	} else {
+		attrs = append(attrs, "peer_priority", sb.PeerPriority)
+	}
+	vr.logger.Error("Split brain: another router is acting as MASTER", attrs...)
+
+	if vr.onSplitBrainCb != nil {
+		vr.onSplitBrainCb(sb)
+	}
+}
+
+// arpLoop watches ARP traffic for virtual IPs answered by other hosts and,
+// while MASTER, probes the virtual IPs every arpProbeInterval so an owner
+// that stays silent otherwise answers
+func (vr *VirtualRouter) arpLoop(conn *arpConn) {
+	defer vr.wg.Done()
+	defer func() { _ = conn.close() }()
+
+	own := conn.iface.HardwareAddr
+	lastProbe := time.Time{}
+	for vr.ctx.Err() == nil {
+		vr.statsMu.Lock()
+		master := vr.splitBrain.master
+		vr.statsMu.Unlock()
+
+		if master && time.Since(lastProbe) >= arpProbeInterval {
+			lastProbe = time.Now()
+			for _, ip := range vr.expect.Load().ips {
+				if err := conn.probe(ip); err != nil {
+					vr.logger.Warn("Failed to probe virtual IP", "ip", ip, "err", err)
+				}
+			}
+		}
+
+		mac, ip, err := conn.read()
+		switch {
+		case err == errARPTimeout:
+		case err != nil:
+			vr.logger.Error("ARP conflict detection failed", "err", err)
+			return
+		default:
+			vr.watchARP(mac, ip, own)
+		}
+	}
+}
@@ -3,10 +3,13 @@ package vrrp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,29 +34,144 @@ func (s State) String() string {
 	}
 }
 
+// MasterReason says why a router became MASTER, after newMasterReason in the
+// VRRPv3 MIB (RFC 6527)
+type MasterReason string
+
+const (
+	// MasterReasonNone is reported while the router is not MASTER
+	MasterReasonNone MasterReason = ""
+	// MasterReasonPriority is the address owner (priority 255) taking over at startup
+	MasterReasonPriority MasterReason = "priority"
+	// MasterReasonPreempted is a backup preempting a lower-priority master
+	MasterReasonPreempted MasterReason = "preempted"
+	// MasterReasonNoResponse is the master going silent or advertising priority 0
+	MasterReasonNoResponse MasterReason = "master_no_response"
+	// MasterReasonSyncGroup is following another member of the sync group
+	MasterReasonSyncGroup MasterReason = "sync_group"
+	// MasterReasonResumed is taking over from a process that was MASTER
+	MasterReasonResumed MasterReason = "resumed"
+)
+
 type StateMachine struct {
 	mu                    sync.RWMutex
 	state                 State
 	vrid                  uint8
 	priority              uint8
+	preempt               bool
 	advertisementInterval time.Duration
 	masterDownInterval    time.Duration
 	virtualIPs            []net.IP
 	iface                 *net.Interface
-	ipManager             *IPManager
+	ipManager             AddressManager
 	sourceIP              net.IP
-
-	masterDownTimer *time.Timer
-	advertTimer     *time.Ticker
+	// excludedIPs are programmed with virtualIPs but not advertised
+	excludedIPs []net.IP
+
+	// advert is the advertisement a MASTER sends every interval, built on
+	// first use after the priority, interval or virtual IPs change. It is
+	// shared with whoever receives it from sendCh, who must not change it.
+	advert *Packet
+
+	masterDownTimer timer
+	advertTimer     timer
+	// scheduler keeps the timers when the router runs in a Manager; without
+	// one they are the runtime's
+	scheduler *scheduler
+
+	// observe keeps the router in BACKUP, following the election only.
+	// backupOnly does too, calling onMasterLost when the master down timer
+	// fires without a master; masterLost is set from then until one is
+	// heard again.
+	observe      bool
+	backupOnly   bool
+	masterLost   bool
+	onMasterLost func()
+
+	// holdUntil suppresses preemption after a manual step-down
+	holdUntil time.Time
+	// preemptWindows restrict preemption to their times, if any;
+	// outsideWindow is set while a BACKUP leaves a lower-priority master
+	// alone because none is open
+	preemptWindows []PreemptWindow
+	outsideWindow  bool
+
+	// preempting is set while a BACKUP lets the master down timer run out on
+	// a lower-priority master; masterReason is guarded by mu
+	preempting   bool
+	masterReason MasterReason
+
+	// priorityZero is set while a BACKUP waits out the skew time after the
+	// master advertised priority 0
+	priorityZero bool
+
+	// cause and causeDetail say why the next transition happens; transition
+	// reports them to onTransition and clears them
+	cause        TransitionCause
+	causeDetail  string
+	onTransition func(Transition)
+
+	// masterDownAt is when the master down timer fired without a live
+	// master being heard since, for the failover latency
+	masterDownAt time.Time
+	onFailover   func(latency time.Duration)
 
 	sendCh  chan *Packet
 	recvCh  chan *Packet
 	eventCh chan Event
-	stopCh  chan struct{}
+	cmdCh   chan func()
+	started atomic.Bool
+
+	// cancel stops the run loop, which closes done once it has left every
+	// state and released the virtual IPs
+	cancel context.CancelFunc
+	done   chan struct{}
 
 	onStateChange func(old, new State)
+	logger        *slog.Logger
+	metrics       Metrics
+	hooks         VIPHooks
+
+	syncMember *syncMember
+
+	droppedPackets atomic.Uint64
+
+	// resumeMaster enters MASTER at startup without an election; detaching
+	// leaves the virtual IPs in place when the state machine stops
+	resumeMaster bool
+	detaching    atomic.Bool
+
+	// shutdownErr holds the virtual IPs that failed to be released when the
+	// state machine stopped; read it after Done is closed
+	shutdownErr error
+}
+
+// VIPHooks are run around every change to the virtual IPs on the interface:
+// entering and leaving MASTER, and SetVirtualIPs while MASTER. They receive
+// the addresses being changed and, after the change, the errors of those that
+// failed. A nil hook is skipped.
+//
+// The hooks run on the state machine's goroutine in the middle of the
+// transition, which waits for them: a slow hook delays the advertisements. They
+// must not call back into the router or its state machine.
+type VIPHooks struct {
+	BeforeAcquireVIPs func(ips []net.IP)
+	AfterAcquireVIPs  func(ips []net.IP, err error)
+	BeforeReleaseVIPs func(ips []net.IP)
+	AfterReleaseVIPs  func(ips []net.IP, err error)
 }
 
+// AddressManager adds and removes virtual IPs on the interface. IPManager is
+// the netlink implementation; simulations substitute their own.
+type AddressManager interface {
+	AddIP(ip net.IP) error
+	DelIP(ip net.IP) error
+}
+
+// defaultQueueLength is the capacity of a state machine's send and receive
+// queues
+const defaultQueueLength = 10
+
 type Event int
 
 const (
@@ -62,6 +180,8 @@ const (
 	EventMasterDown
 	EventAdvertReceived
 	EventPriorityZeroReceived
+	EventSyncGroupMaster
+	EventSyncGroupBackup
 )
 
 func NewStateMachine(vrid, priority uint8, ips []net.IP, iface *net.Interface) *StateMachine {
@@ -81,15 +201,19 @@ func NewStateMachine(vrid, priority uint8, ips []net.IP, iface *net.Interface) *
 		state:                 Init,
 		vrid:                  vrid,
 		priority:              priority,
+		preempt:               true,
 		advertisementInterval: time.Second,
 		virtualIPs:            ips,
 		iface:                 iface,
 		ipManager:             NewIPManager(iface),
 		sourceIP:              sourceIP,
-		sendCh:                make(chan *Packet, 10),
-		recvCh:                make(chan *Packet, 10),
+		sendCh:                make(chan *Packet, defaultQueueLength),
+		recvCh:                make(chan *Packet, defaultQueueLength),
 		eventCh:               make(chan Event, 10),
-		stopCh:                make(chan struct{}),
+		cmdCh:                 make(chan func()),
+		done:                  make(chan struct{}),
+		logger:                slog.Default().With("vrid", vrid, "iface", iface.Name),
+		metrics:               NopMetrics{},
 	}
 
 	sm.masterDownInterval = sm.calculateMasterDownInterval()
@@ -103,6 +227,70 @@ func (sm *StateMachine) SetStateChangeCallback(fn func(old, new State)) {
 	sm.onStateChange = fn
 }
 
+// SetTransitionCallback registers fn to be called after every state change
+// with its cause. Like the state change callback it runs under the state
+// machine's lock. It must be called before Start.
+func (sm *StateMachine) SetTransitionCallback(fn func(Transition)) {
+	sm.onTransition = fn
+}
+
+// SetAddressManager replaces the netlink IP manager. It must be called before Start.
+func (sm *StateMachine) SetAddressManager(m AddressManager) {
+	sm.ipManager = m
+}
+
+// SetSourceIP overrides the address used for tie-breaking, normally the first
+// IPv4 address of the interface. It must be called before Start.
+func (sm *StateMachine) SetSourceIP(ip net.IP) {
+	sm.sourceIP = ip.To4()
+}
+
+// SetLogger replaces the logger. It must be called before Start.
+func (sm *StateMachine) SetLogger(logger *slog.Logger) {
+	sm.logger = logger
+}
+
+// SetVIPHooks sets the hooks run around virtual IP changes. It must be called
+// before Start.
+func (sm *StateMachine) SetVIPHooks(hooks VIPHooks) {
+	sm.hooks = hooks
+}
+
+// SetFailoverCallback registers fn to be called with the time from the
+// master down timer firing to the virtual IPs being programmed, each time the
+// router takes over from a master that went silent or gave up. It runs in the
+// middle of the transition and must be called before Start.
+func (sm *StateMachine) SetFailoverCallback(fn func(latency time.Duration)) {
+	sm.onFailover = fn
+}
+
+// SetMasterLostCallback registers fn to be called when a backup-only router
+// finds no master where it would have taken over, once until a master is
+// heard again. It runs on the state machine's goroutine and must be called
+// before Start.
+func (sm *StateMachine) SetMasterLostCallback(fn func()) {
+	sm.onMasterLost = fn
+}
+
+// SetQueueLength sets the capacity of the send and receive queues. It must
+// be called before Start.
+func (sm *StateMachine) SetQueueLength(n int) {
+	sm.sendCh = make(chan *Packet, n)
+	sm.recvCh = make(chan *Packet, n)
+}
+
+// SetMetrics reports packets dropped because a channel was full to m. It must
+// be called before Start.
+func (sm *StateMachine) SetMetrics(m Metrics) {
+	sm.metrics = m
+}
+
+// SetResumeMaster makes the state machine start as MASTER, for a process
+// taking over from one that was MASTER. It must be called before Start.
+func (sm *StateMachine) SetResumeMaster(resume bool) {
+	sm.resumeMaster = resume
+}
+
 func (sm *StateMachine) GetState() State {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
@@ -110,11 +298,24 @@ func (sm *StateMachine) GetState() State {
 }
 
 func (sm *StateMachine) calculateMasterDownInterval() time.Duration {
-	skewTime := time.Duration((256-int(sm.priority))*int(sm.advertisementInterval.Milliseconds()/256)) * time.Millisecond
-	return 3*sm.advertisementInterval + skewTime
+	return 3*sm.advertisementInterval + sm.skewTime()
+}
+
+// skewTime is (256 - priority) / 256 of the advertisement interval. It is
+// computed at full precision so sub-second intervals still order backups by
+// priority.
+func (sm *StateMachine) skewTime() time.Duration {
+	return time.Duration(256-int(sm.priority)) * sm.advertisementInterval / 256
 }
 
+// Start runs the state machine until ctx is canceled or Stop is called. Either
+// way it transitions to INIT, releasing the virtual IPs, before it exits.
 func (sm *StateMachine) Start(ctx context.Context) error {
+	if sm.started.Swap(true) {
+		return fmt.Errorf("state machine already started")
+	}
+
+	ctx, sm.cancel = context.WithCancel(ctx)
 	sm.eventCh <- EventStartup
 
 	go sm.run(ctx)
@@ -122,26 +323,61 @@ func (sm *StateMachine) Start(ctx context.Context) error {
 	return nil
 }
 
+// Stop stops the state machine and waits until it has released the virtual
+// IPs. It does nothing if the state machine was never started.
 func (sm *StateMachine) Stop() {
-	close(sm.stopCh)
+	if !sm.started.Load() {
+		return
+	}
+	sm.cancel()
+	<-sm.done
+}
+
+// Detach stops the state machine like Stop but leaves the virtual IPs on the
+// interface, for another process that carries on as MASTER
+func (sm *StateMachine) Detach() {
+	sm.detaching.Store(true)
+	sm.Stop()
+}
+
+// Done returns a channel closed once the state machine has stopped
+func (sm *StateMachine) Done() <-chan struct{} {
+	return sm.done
 }
 
 func (sm *StateMachine) ProcessPacket(pkt *Packet) {
 	select {
 	case sm.recvCh <- pkt:
 	default:
-		log.Printf("Receive channel full, dropping packet")
+		sm.countDrop()
+		sm.logger.Warn("Receive channel full, dropping packet")
 	}
 }
 
+// post queues an event from another goroutine without blocking it
+func (sm *StateMachine) post(event Event) {
+	go func() {
+		select {
+		case sm.eventCh <- event:
+		case <-sm.done:
+		}
+	}()
+}
+
 func (sm *StateMachine) run(ctx context.Context) {
+	defer close(sm.done)
+	if sm.syncMember != nil {
+		defer sm.syncMember.detach(sm)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
-			sm.transition(Init)
-			return
-
-		case <-sm.stopCh:
+			detail := ""
+			if sm.detaching.Load() {
+				detail = "handed over to the next process"
+			}
+			sm.because(CauseShutdown, detail)
 			sm.transition(Init)
 			return
 
@@ -151,8 +387,14 @@ func (sm *StateMachine) run(ctx context.Context) {
 		case pkt := <-sm.recvCh:
 			sm.handlePacket(pkt)
 
-		case <-sm.masterDownTimerChan():
+		case fn := <-sm.cmdCh:
+			fn()
+
+		case fired := <-sm.masterDownTimerChan():
 			if sm.state == Backup {
+				if sm.masterDownAt.IsZero() {
+					sm.masterDownAt = fired
+				}
 				sm.eventCh <- EventMasterDown
 			}
 
@@ -164,16 +406,210 @@ func (sm *StateMachine) run(ctx context.Context) {
 	}
 }
 
+// exec runs fn on the state machine goroutine so it can safely touch state owned
+// by the event loop. Before Start, fn runs directly on the caller's goroutine.
+func (sm *StateMachine) exec(fn func()) {
+	if !sm.started.Load() {
+		fn()
+		return
+	}
+
+	done := make(chan struct{})
+	select {
+	case sm.cmdCh <- func() { fn(); close(done) }:
+		<-done
+	case <-sm.done:
+	}
+}
+
+// SetPriority changes the advertised priority. A master advertises the new
+// priority immediately so backups can react without waiting for the next tick.
+func (sm *StateMachine) SetPriority(priority uint8) {
+	sm.exec(func() {
+		sm.priority = priority
+		sm.advert = nil
+		sm.masterDownInterval = sm.calculateMasterDownInterval()
+
+		if sm.GetState() == Master {
+			sm.sendAdvertisement()
+		}
+	})
+}
+
+// SetAdvertisementInterval changes the advertisement interval, restarting the
+// running timer so the new interval applies immediately
+func (sm *StateMachine) SetAdvertisementInterval(interval time.Duration) {
+	sm.exec(func() {
+		sm.advertisementInterval = interval
+		sm.advert = nil
+		sm.masterDownInterval = sm.calculateMasterDownInterval()
+
+		switch sm.GetState() {
+		case Master:
+			sm.startAdvertTimer()
+		case Backup:
+			sm.resetMasterDownTimer()
+		}
+	})
+}
+
+// StepDown makes a master relinquish mastership: it advertises priority 0 so a
+// backup takes over at once, then stays BACKUP without preempting for hold.
+// If no backup takes over, the master down timer still fires and the router
+// becomes MASTER again.
+func (sm *StateMachine) StepDown(hold time.Duration) error {
+	err := ErrNotMaster
+	sm.exec(func() {
+		if sm.GetState() != Master {
+			return
+		}
+		err = nil
+
+		// Queued before the transition so it goes out ahead of anything else
+		sm.sendPriorityZero()
+
+		sm.holdUntil = time.Now().Add(hold)
+		sm.logger.Info("Stepping down", "hold", hold)
+		sm.because(CauseOperator, fmt.Sprintf("step down, hold %s", hold))
+		sm.transition(Backup)
+	})
+	return err
+}
+
+// Hold keeps a backup from preempting the master for d
+func (sm *StateMachine) Hold(d time.Duration) {
+	sm.exec(func() {
+		sm.holdUntil = time.Now().Add(d)
+	})
+}
+
+// SetPreempt controls whether a backup takes over from a lower-priority master
+func (sm *StateMachine) SetPreempt(preempt bool) {
+	sm.exec(func() {
+		sm.preempt = preempt
+	})
+}
+
+// SetObserve makes the state machine stay in BACKUP, never claiming
+// mastership, so that it only follows the election
+func (sm *StateMachine) SetObserve(observe bool) {
+	sm.exec(func() {
+		sm.observe = observe
+	})
+}
+
+// SetBackupOnly keeps the state machine from becoming MASTER, a MASTER
+// stepping down at once, so that the router only stands by
+func (sm *StateMachine) SetBackupOnly(backupOnly bool) {
+	sm.exec(func() {
+		sm.backupOnly, sm.masterLost = backupOnly, false
+		if backupOnly && sm.GetState() == Master {
+			sm.sendPriorityZero()
+			sm.logger.Info("Stepping down to be backup-only")
+			sm.because(CauseOperator, "backup only")
+			sm.transition(Backup)
+		}
+	})
+}
+
+// SetPreemptWindows restricts preemption to the times of windows; none lifts
+// the restriction
+func (sm *StateMachine) SetPreemptWindows(windows []PreemptWindow) {
+	sm.exec(func() {
+		sm.preemptWindows = windows
+	})
+}
+
+// SetVirtualIPs replaces the virtual IP list. A master programs added addresses
+// and releases removed ones without leaving the MASTER state.
+func (sm *StateMachine) SetVirtualIPs(ips []net.IP) {
+	sm.exec(func() { sm.setAddresses(ips, sm.excludedIPs) })
+}
+
+// SetExcludedIPs replaces the addresses programmed with the virtual IPs but
+// left out of advertisements, reprogramming them as SetVirtualIPs does
+func (sm *StateMachine) SetExcludedIPs(ips []net.IP) {
+	sm.exec(func() { sm.setAddresses(sm.virtualIPs, ips) })
+}
+
+// setAddresses replaces both lists at once, so an address moving from one
+// to the other is never released. It must run on the state machine's
+// goroutine.
+func (sm *StateMachine) setAddresses(virtual, excluded []net.IP) {
+	sm.reprogram(virtual, excluded)
+	sm.excludedIPs = excluded
+	if sameIPs(virtual, sm.virtualIPs) {
+		return
+	}
+	sm.virtualIPs = virtual
+	sm.advert = nil
+
+	if sm.GetState() == Master {
+		sm.sendAdvertisement()
+	}
+}
+
+// programmedIPs returns the addresses a master holds: the virtual IPs, then
+// the excluded ones not among them
+func (sm *StateMachine) programmedIPs() []net.IP {
+	return mergeIPs(sm.virtualIPs, sm.excludedIPs)
+}
+
+func mergeIPs(virtual, excluded []net.IP) []net.IP {
+	ips := slices.Clip(virtual)
+	for _, ip := range excluded {
+		if !containsIP(ips, ip) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// reprogram makes a master's addresses those of the given lists, adding and
+// releasing only what changed. It does nothing in the other states.
+func (sm *StateMachine) reprogram(virtual, excluded []net.IP) {
+	if sm.GetState() != Master {
+		return
+	}
+	old, ips := sm.programmedIPs(), mergeIPs(virtual, excluded)
+	var added, removed []net.IP
+	for _, ip := range ips {
+		if !containsIP(old, ip) {
+			added = append(added, ip)
+		}
+	}
+	for _, ip := range old {
+		if !containsIP(ips, ip) {
+			removed = append(removed, ip)
+		}
+	}
+	if len(added) > 0 {
+		sm.acquireVirtualIPs(added)
+	}
+	if len(removed) > 0 {
+		_ = sm.releaseVirtualIPs(removed)
+	}
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (sm *StateMachine) masterDownTimerChan() <-chan time.Time {
 	if sm.masterDownTimer != nil {
-		return sm.masterDownTimer.C
+		return sm.masterDownTimer.Chan()
 	}
 	return nil
 }
 
 func (sm *StateMachine) advertTimerChan() <-chan time.Time {
 	if sm.advertTimer != nil {
-		return sm.advertTimer.C
+		return sm.advertTimer.Chan()
 	}
 	return nil
 }
@@ -181,23 +617,76 @@ func (sm *StateMachine) advertTimerChan() <-chan time.Time {
 func (sm *StateMachine) handleEvent(event Event) {
 	switch event {
 	case EventStartup:
-		if sm.priority == 255 {
-			sm.transition(Master)
+		if (sm.resumeMaster || sm.priority == 255) && sm.claimMaster() {
+			reason := MasterReasonPriority
+			sm.because(CauseStartup, "address owner")
+			if sm.resumeMaster {
+				sm.logger.Info("Resuming as MASTER")
+				reason = MasterReasonResumed
+				sm.because(CauseResumed, "")
+			}
+			sm.becomeMaster(reason)
 		} else {
+			sm.because(CauseStartup, "")
 			sm.transition(Backup)
 		}
 
 	case EventShutdown:
+		sm.because(CauseShutdown, "")
 		sm.transition(Init)
 
 	case EventMasterDown:
-		if sm.state == Backup {
-			sm.transition(Master)
+		if sm.state != Backup {
+			break
+		}
+		if sm.claimMaster() {
+			reason := MasterReasonNoResponse
+			switch {
+			case sm.preempting:
+				reason = MasterReasonPreempted
+				sm.because(CausePreempt, "")
+			case sm.priorityZero:
+				sm.because(CausePriorityZero, "")
+			default:
+				sm.because(CauseMasterDown, "")
+			}
+			sm.becomeMaster(reason)
+		} else {
+			if sm.backupOnly && !sm.masterLost {
+				sm.masterLost = true
+				sm.logger.Error("No master, and this router is backup-only: not taking over")
+				if sm.onMasterLost != nil {
+					sm.onMasterLost()
+				}
+			}
+			// Check again after another interval without a better master
+			sm.resetMasterDownTimer()
 		}
 
-	case EventPriorityZeroReceived:
+	case EventSyncGroupMaster:
+		if sm.state == Backup && sm.syncMember.takeReady() {
+			sm.logger.Info("Taking over with sync group", "sync_group", sm.syncMember.group.name)
+			sm.because(CauseSyncGroup, sm.syncMember.group.name)
+			sm.becomeMaster(MasterReasonSyncGroup)
+		}
+
+	case EventSyncGroupBackup:
 		if sm.state == Master {
+			sm.logger.Info("Stepping down with sync group", "sync_group", sm.syncMember.group.name)
+			sm.sendPriorityZero()
+			sm.because(CauseSyncGroup, sm.syncMember.group.name)
+			sm.transition(Backup)
+		}
+
+	case EventPriorityZeroReceived:
+		switch sm.state {
+		case Master:
 			sm.sendAdvertisement()
+		case Backup:
+			// The master is leaving: take over after the skew time only
+			sm.preempting = false
+			sm.priorityZero = true
+			sm.setMasterDownTimer(sm.skewTime())
 		}
 	}
 }
@@ -214,13 +703,35 @@ func (sm *StateMachine) handlePacket(pkt *Packet) {
 
 	switch sm.state {
 	case Backup:
-		if pkt.Priority >= sm.priority {
+		// Without preemption, while holding after a step-down or outside
+		// the preemption windows, any live master keeps us in BACKUP, as
+		// it does a router that may not become MASTER
+		sm.masterLost = false
+		now := time.Now()
+		lower := sm.preempt && pkt.Priority < sm.priority && !now.Before(sm.holdUntil) &&
+			!sm.observe && !sm.backupOnly
+		outside := lower && !preemptOpen(sm.preemptWindows, now)
+		if outside && !sm.outsideWindow {
+			sm.logger.Info("Not preempting the lower-priority master outside the preemption windows",
+				"priority", pkt.Priority)
+		}
+		sm.outsideWindow = outside
+		if !lower || outside {
+			sm.preempting = false
+			sm.priorityZero = false
+			sm.masterDownAt = time.Time{}
 			sm.resetMasterDownTimer()
+			if sm.syncMember != nil {
+				sm.syncMember.unready()
+			}
+		} else {
+			sm.preempting = true
 		}
 
 	case Master:
 		if pkt.Priority > sm.priority ||
 			(pkt.Priority == sm.priority && sm.compareSourceIP(pkt) < 0) {
+			sm.because(CausePreemptedBy, fmt.Sprintf("priority %d", pkt.Priority))
 			sm.transition(Backup)
 		}
 	}
@@ -235,12 +746,26 @@ func (sm *StateMachine) transition(newState State) {
 		return
 	}
 
-	log.Printf("VRID %d: State transition %s -> %s", sm.vrid, oldState, newState)
+	cause, detail := sm.cause, sm.causeDetail
+	sm.cause, sm.causeDetail = "", ""
+
+	attrs := []any{"old_state", oldState.String(), "state", newState.String()}
+	if cause != "" {
+		attrs = append(attrs, "cause", cause)
+	}
+	sm.logger.Info("State changed", attrs...)
 
 	switch oldState {
 	case Master:
+		sm.masterReason = MasterReasonNone
 		sm.stopAdvertTimer()
-		sm.releaseVirtualIPs()
+		if newState == Init && sm.detaching.Load() {
+			sm.logger.Info("Leaving virtual IPs to the next process", "virtual_ips", sm.programmedIPs())
+			break
+		}
+		if err := sm.releaseVirtualIPs(sm.programmedIPs()); err != nil && newState == Init {
+			sm.shutdownErr = err
+		}
 
 	case Backup:
 		sm.stopMasterDownTimer()
@@ -250,29 +775,92 @@ func (sm *StateMachine) transition(newState State) {
 
 	switch newState {
 	case Master:
-		sm.acquireVirtualIPs()
+		sm.acquireVirtualIPs(sm.programmedIPs())
+		if !sm.masterDownAt.IsZero() && sm.onFailover != nil {
+			sm.onFailover(time.Since(sm.masterDownAt))
+		}
+		sm.masterDownAt = time.Time{}
 		sm.sendAdvertisement()
 		sm.startAdvertTimer()
 
 	case Backup:
+		sm.preempting = false
+		sm.priorityZero = false
+		sm.masterDownAt = time.Time{}
 		sm.startMasterDownTimer()
 
 	case Init:
 		sm.stopAdvertTimer()
 		sm.stopMasterDownTimer()
-		sm.releaseVirtualIPs()
 	}
 
 	if sm.onStateChange != nil {
 		sm.onStateChange(oldState, newState)
 	}
+	if sm.onTransition != nil {
+		sm.onTransition(Transition{
+			Time:     time.Now(),
+			Old:      oldState,
+			New:      newState,
+			Cause:    cause,
+			Detail:   detail,
+			Priority: sm.priority,
+		})
+	}
+
+	sm.mu.Unlock()
+
+	if oldState == Master && sm.syncMember != nil {
+		sm.syncMember.leftMaster()
+	}
+}
+
+// because records why the next transition happens. It is called on the state
+// machine goroutine just before transition.
+func (sm *StateMachine) because(cause TransitionCause, detail string) {
+	sm.cause, sm.causeDetail = cause, detail
+}
 
+// becomeMaster enters MASTER, recording why for MasterReason
+func (sm *StateMachine) becomeMaster(reason MasterReason) {
+	sm.mu.Lock()
+	sm.masterReason = reason
 	sm.mu.Unlock()
+	sm.transition(Master)
+}
+
+// MasterReason returns why the router became MASTER, or MasterReasonNone if
+// it is not MASTER
+func (sm *StateMachine) MasterReason() MasterReason {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.masterReason
+}
+
+// claimMaster reports whether the router may become MASTER, which is always
+// the case outside a sync group unless it observes or is backup-only
+func (sm *StateMachine) claimMaster() bool {
+	if sm.observe || sm.backupOnly {
+		return false
+	}
+	return sm.syncMember == nil || sm.syncMember.claim()
 }
 
 func (sm *StateMachine) startMasterDownTimer() {
-	sm.stopMasterDownTimer()
-	sm.masterDownTimer = time.NewTimer(sm.masterDownInterval)
+	sm.setMasterDownTimer(sm.masterDownInterval)
+}
+
+// setMasterDownTimer (re)starts the master down timer to fire after d,
+// reusing the running one
+func (sm *StateMachine) setMasterDownTimer(d time.Duration) {
+	switch {
+	case sm.masterDownTimer != nil:
+		sm.masterDownTimer.Reset(d)
+	case sm.scheduler != nil:
+		sm.masterDownTimer = sm.scheduler.after(d)
+	default:
+		sm.masterDownTimer = ownTimer{time.NewTimer(d)}
+	}
 }
 
 func (sm *StateMachine) stopMasterDownTimer() {
@@ -283,13 +871,18 @@ func (sm *StateMachine) stopMasterDownTimer() {
 }
 
 func (sm *StateMachine) resetMasterDownTimer() {
-	sm.stopMasterDownTimer()
-	sm.masterDownTimer = time.NewTimer(sm.masterDownInterval)
+	sm.setMasterDownTimer(sm.masterDownInterval)
 }
 
 func (sm *StateMachine) startAdvertTimer() {
-	sm.stopAdvertTimer()
-	sm.advertTimer = time.NewTicker(sm.advertisementInterval)
+	switch {
+	case sm.advertTimer != nil:
+		sm.advertTimer.Reset(sm.advertisementInterval)
+	case sm.scheduler != nil:
+		sm.advertTimer = sm.scheduler.every(sm.advertisementInterval)
+	default:
+		sm.advertTimer = ownTicker{time.NewTicker(sm.advertisementInterval)}
+	}
 }
 
 func (sm *StateMachine) stopAdvertTimer() {
@@ -300,33 +893,85 @@ func (sm *StateMachine) stopAdvertTimer() {
 }
 
 func (sm *StateMachine) sendAdvertisement() {
-	pkt := NewPacket(VRRPv2, sm.vrid, sm.priority, sm.virtualIPs)
+	if sm.advert == nil {
+		sm.advert = sm.newAdvert(sm.priority)
+	}
+
+	select {
+	case sm.sendCh <- sm.advert:
+	default:
+		sm.countDrop()
+		sm.logger.Warn("Send channel full, dropping advertisement")
+	}
+}
+
+// newAdvert builds an advertisement of priority carrying the router's
+// interval, which backups enforcing the interval check compare with theirs
+func (sm *StateMachine) newAdvert(priority uint8) *Packet {
+	pkt := NewPacket(VRRPv2, sm.vrid, priority, sm.virtualIPs)
+	if secs := sm.advertisementInterval / time.Second; secs > 1 {
+		pkt.AdvInterval = uint16(secs)
+	}
+	return pkt
+}
 
+// sendPriorityZero queues a priority 0 advertisement so a backup takes over
+// after the skew time
+func (sm *StateMachine) sendPriorityZero() {
 	select {
-	case sm.sendCh <- pkt:
+	case sm.sendCh <- sm.newAdvert(0):
 	default:
-		log.Printf("Send channel full, dropping advertisement")
+		sm.countDrop()
+		sm.logger.Warn("Send channel full, dropping advertisement")
 	}
 }
 
-func (sm *StateMachine) acquireVirtualIPs() {
-	for _, ip := range sm.virtualIPs {
+// acquireVirtualIPs adds ips to the interface between the hooks. A failed
+// address is logged and the others are still added.
+func (sm *StateMachine) acquireVirtualIPs(ips []net.IP) {
+	ips = append([]net.IP(nil), ips...)
+	if sm.hooks.BeforeAcquireVIPs != nil {
+		sm.hooks.BeforeAcquireVIPs(ips)
+	}
+
+	var errs []error
+	for _, ip := range ips {
 		if err := sm.addIP(ip); err != nil {
-			log.Printf("Failed to add virtual IP %s: %v", ip, err)
+			sm.logger.Error("Failed to add virtual IP", "ip", ip, "err", err)
+			errs = append(errs, err)
 		} else {
-			log.Printf("Added virtual IP %s to interface %s", ip, sm.iface.Name)
+			sm.logger.Info("Added virtual IP", "ip", ip)
 		}
 	}
+
+	if sm.hooks.AfterAcquireVIPs != nil {
+		sm.hooks.AfterAcquireVIPs(ips, errors.Join(errs...))
+	}
 }
 
-func (sm *StateMachine) releaseVirtualIPs() {
-	for _, ip := range sm.virtualIPs {
+// releaseVirtualIPs removes ips from the interface between the hooks and
+// returns the failures
+func (sm *StateMachine) releaseVirtualIPs(ips []net.IP) error {
+	ips = append([]net.IP(nil), ips...)
+	if sm.hooks.BeforeReleaseVIPs != nil {
+		sm.hooks.BeforeReleaseVIPs(ips)
+	}
+
+	var errs []error
+	for _, ip := range ips {
 		if err := sm.delIP(ip); err != nil {
-			log.Printf("Failed to remove virtual IP %s: %v", ip, err)
+			sm.logger.Error("Failed to remove virtual IP", "ip", ip, "err", err)
+			errs = append(errs, err)
 		} else {
-			log.Printf("Removed virtual IP %s from interface %s", ip, sm.iface.Name)
+			sm.logger.Info("Removed virtual IP", "ip", ip)
 		}
 	}
+
+	err := errors.Join(errs...)
+	if sm.hooks.AfterReleaseVIPs != nil {
+		sm.hooks.AfterReleaseVIPs(ips, err)
+	}
+	return err
 }
 
 func (sm *StateMachine) addIP(ip net.IP) error {
@@ -358,13 +1003,46 @@ func (sm *StateMachine) compareSourceIP(pkt *Packet) int {
 	// In a real implementation, this would come from the IP header
 	// For now, compare with the first virtual IP as a proxy
 	if len(pkt.IPAddresses) == 0 {
-		return 1 // No IP in packet, we win
+		// An advertisement without addresses loses ties, so a router
+		// advertising its VIPs stays MASTER
+		return 1
 	}
 
 	// Compare byte by byte
 	return bytes.Compare(sm.sourceIP, pkt.IPAddresses[0])
 }
 
+// DroppedPackets returns how many packets were dropped because a channel was full
+func (sm *StateMachine) DroppedPackets() uint64 {
+	return sm.droppedPackets.Load()
+}
+
+func (sm *StateMachine) countDrop() {
+	sm.droppedPackets.Add(1)
+	sm.metrics.PacketDropped(sm.iface.Name, sm.vrid, DropQueueFull)
+}
+
+func (sm *StateMachine) resetDroppedPackets() uint64 {
+	return sm.droppedPackets.Swap(0)
+}
+
+// QueueLengths counts the items waiting in the state machine's channels
+type QueueLengths struct {
+	Send  int
+	Recv  int
+	Event int
+}
+
+// QueueLengths returns the current channel depths. Channels that stay full
+// point at a stalled event loop or send loop.
+func (sm *StateMachine) QueueLengths() QueueLengths {
+	return QueueLengths{
+		Send:  len(sm.sendCh),
+		Recv:  len(sm.recvCh),
+		Event: len(sm.eventCh),
+	}
+}
+
 func (sm *StateMachine) GetSendChannel() <-chan *Packet {
 	return sm.sendCh
 }
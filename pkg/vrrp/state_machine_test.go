@@ -2,7 +2,14 @@ package vrrp
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -136,7 +143,7 @@ func TestMasterDownInterval(t *testing.T) {
 
 		// Master down interval should be 3 * advert_interval + skew_time
 		// Skew time = (256 - priority) * advert_interval / 256
-		expectedSkew := time.Duration((256-int(priority))*int(sm.advertisementInterval.Milliseconds()/256)) * time.Millisecond
+		expectedSkew := time.Duration(256-int(priority)) * sm.advertisementInterval / 256
 		expected := 3*sm.advertisementInterval + expectedSkew
 
 		if interval != expected {
@@ -231,3 +238,560 @@ func TestByteComparison(t *testing.T) {
 		t.Error("Same IPs should compare equal")
 	}
 }
+
+func TestSetPriority(t *testing.T) {
+	iface := &net.Interface{
+		Index: 1,
+		Name:  "test0",
+	}
+
+	vips := []net.IP{net.ParseIP("192.168.1.100")}
+	sm := NewStateMachine(1, 100, vips, iface)
+	before := sm.masterDownInterval
+
+	// Not started: applied directly
+	sm.SetPriority(200)
+	if sm.priority != 200 {
+		t.Errorf("Expected priority 200, got %d", sm.priority)
+	}
+	if sm.masterDownInterval >= before {
+		t.Errorf("Higher priority should shorten master down interval: before=%v after=%v",
+			before, sm.masterDownInterval)
+	}
+
+	// Started: applied on the event loop
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := sm.Start(ctx); err != nil {
+		t.Fatalf("Failed to start state machine: %v", err)
+	}
+
+	sm.SetPriority(150)
+
+	var got uint8
+	sm.exec(func() { got = sm.priority })
+	if got != 150 {
+		t.Errorf("Expected priority 150 after running SetPriority, got %d", got)
+	}
+
+	sm.Stop()
+}
+
+func TestAdvertisementReused(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(1, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+
+	sent := func() *Packet {
+		t.Helper()
+		sm.sendAdvertisement()
+		select {
+		case pkt := <-sm.sendCh:
+			return pkt
+		default:
+			t.Fatal("no advertisement sent")
+			return nil
+		}
+	}
+
+	first := sent()
+	if again := sent(); again != first {
+		t.Error("an unchanged advertisement was built again")
+	}
+
+	sm.SetPriority(150)
+	if pkt := sent(); pkt == first || pkt.Priority != 150 {
+		t.Errorf("advertisement after SetPriority has priority %d, want a new one with 150", pkt.Priority)
+	}
+	if first.Priority != 100 {
+		t.Errorf("the advertisement already sent was changed to priority %d", first.Priority)
+	}
+
+	sm.SetVirtualIPs([]net.IP{net.ParseIP("192.168.1.101")})
+	if pkt := sent(); !pkt.IPAddresses[0].Equal(net.ParseIP("192.168.1.101")) {
+		t.Errorf("advertisement after SetVirtualIPs carries %v", pkt.IPAddresses)
+	}
+
+	sm.SetAdvertisementInterval(3 * time.Second)
+	if pkt := sent(); pkt.AdvInterval != 3 {
+		t.Errorf("advertisement after SetAdvertisementInterval has interval %d, want 3", pkt.AdvInterval)
+	}
+}
+
+func TestStepDown(t *testing.T) {
+	iface := &net.Interface{
+		Index: 1,
+		Name:  "test0",
+	}
+
+	vips := []net.IP{net.ParseIP("192.168.1.100")}
+	sm := NewStateMachine(10, 100, vips, iface)
+
+	if err := sm.StepDown(time.Minute); err == nil {
+		t.Error("StepDown should fail when not Master")
+	}
+
+	sm.transition(Master)
+	<-sm.sendCh // advertisement sent on becoming Master
+
+	if err := sm.StepDown(time.Minute); err != nil {
+		t.Fatalf("StepDown failed: %v", err)
+	}
+	if sm.GetState() != Backup {
+		t.Errorf("State should be Backup after stepping down, got %v", sm.GetState())
+	}
+
+	select {
+	case pkt := <-sm.sendCh:
+		if pkt.Priority != 0 {
+			t.Errorf("Step-down advertisement should carry priority 0, got %d", pkt.Priority)
+		}
+	default:
+		t.Error("No priority 0 advertisement sent")
+	}
+
+	// A lower-priority master must not be preempted during the hold time
+	sm.stopMasterDownTimer()
+	sm.handlePacket(&Packet{VRID: 10, Priority: 50})
+	if sm.masterDownTimer == nil {
+		t.Error("Master down timer should be reset by a lower-priority master while holding")
+	}
+}
+
+func TestPriorityZeroInBackup(t *testing.T) {
+	iface := &net.Interface{
+		Index: 1,
+		Name:  "test0",
+	}
+
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.transition(Backup)
+
+	sm.handleEvent(EventPriorityZeroReceived)
+
+	select {
+	case <-sm.masterDownTimerChan():
+	case <-time.After(sm.masterDownInterval - time.Second):
+		t.Error("Master down timer should fire after the skew time when the master resigns")
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	iface := &net.Interface{
+		Index: 1,
+		Name:  "test0",
+	}
+
+	vips := []net.IP{net.ParseIP("192.168.1.100")}
+	sm := NewStateMachine(7, 100, vips, iface)
+
+	var buf bytes.Buffer
+	sm.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)).With("vrid", 7))
+
+	sm.transition(Backup)
+
+	out := buf.String()
+	for _, want := range []string{`msg="State changed"`, "vrid=7", "old_state=INIT", "state=BACKUP"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestQueueLengths(t *testing.T) {
+	iface := &net.Interface{
+		Index: 1,
+		Name:  "test0",
+	}
+
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.ProcessPacket(&Packet{VRID: 10, Priority: 50})
+	sm.ProcessPacket(&Packet{VRID: 10, Priority: 50})
+	sm.sendAdvertisement()
+
+	got := sm.QueueLengths()
+	want := QueueLengths{Send: 1, Recv: 2}
+	if got != want {
+		t.Errorf("QueueLengths() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSetQueueLength(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetQueueLength(2)
+
+	for range 3 {
+		sm.ProcessPacket(&Packet{VRID: 10, Priority: 50})
+	}
+	if got := sm.QueueLengths().Recv; got != 2 {
+		t.Errorf("%d packets queued, want 2", got)
+	}
+	if got := sm.DroppedPackets(); got != 1 {
+		t.Errorf("DroppedPackets() = %d, want 1", got)
+	}
+
+	_, err := NewVirtualRouter(&Config{
+		VRID:        1,
+		Interface:   "lo",
+		VirtualIPs:  []string{"192.0.2.1"},
+		QueueLength: -1,
+	})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewVirtualRouter with a negative queue length = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestStartContextCancel(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetAddressManager(NopAddresses{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := sm.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := sm.Start(ctx); err == nil {
+		t.Error("Second Start should fail")
+	}
+
+	cancel()
+	select {
+	case <-sm.Done():
+	case <-time.After(time.Second):
+		t.Fatal("State machine did not stop after its context was canceled")
+	}
+	if sm.GetState() != Init {
+		t.Errorf("State should be Init after cancellation, got %v", sm.GetState())
+	}
+
+	// Stop after cancellation returns at once
+	sm.Stop()
+}
+
+func TestStopWaitsForInit(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(10, 255, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetAddressManager(NopAddresses{})
+
+	var released atomic.Bool
+	sm.SetStateChangeCallback(func(_, new State) {
+		if new == Init {
+			released.Store(true)
+		}
+	})
+
+	if err := sm.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	for sm.GetState() != Master {
+		time.Sleep(time.Millisecond)
+	}
+
+	sm.Stop()
+	if !released.Load() {
+		t.Error("Stop returned before the state machine left MASTER")
+	}
+}
+
+// recordingAddresses logs address changes and hook calls in order
+type recordingAddresses struct {
+	calls []string
+}
+
+func (r *recordingAddresses) AddIP(ip net.IP) error {
+	r.calls = append(r.calls, "add "+ip.String())
+	return nil
+}
+
+func (r *recordingAddresses) DelIP(ip net.IP) error {
+	r.calls = append(r.calls, "del "+ip.String())
+	if ip.Equal(net.ParseIP("192.168.1.101")) {
+		return errors.New("no such address")
+	}
+	return nil
+}
+
+func (r *recordingAddresses) hooks() VIPHooks {
+	return VIPHooks{
+		BeforeAcquireVIPs: func(ips []net.IP) { r.calls = append(r.calls, fmt.Sprintf("before acquire %v", ips)) },
+		AfterAcquireVIPs: func(ips []net.IP, err error) {
+			r.calls = append(r.calls, fmt.Sprintf("after acquire %v: %v", ips, err))
+		},
+		BeforeReleaseVIPs: func(ips []net.IP) { r.calls = append(r.calls, fmt.Sprintf("before release %v", ips)) },
+		AfterReleaseVIPs: func(ips []net.IP, err error) {
+			r.calls = append(r.calls, fmt.Sprintf("after release %v: %v", ips, err))
+		},
+	}
+}
+
+func TestVIPHooks(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	ips := []net.IP{net.ParseIP("192.168.1.100").To4(), net.ParseIP("192.168.1.101").To4()}
+	sm := NewStateMachine(10, 100, ips, iface)
+
+	rec := &recordingAddresses{}
+	sm.SetAddressManager(rec)
+	sm.SetVIPHooks(rec.hooks())
+
+	sm.transition(Master)
+	sm.SetVirtualIPs(ips[:1])
+	sm.SetVirtualIPs(ips)
+	sm.transition(Init)
+
+	want := []string{
+		"before acquire [192.168.1.100 192.168.1.101]",
+		"add 192.168.1.100",
+		"add 192.168.1.101",
+		"after acquire [192.168.1.100 192.168.1.101]: <nil>",
+		"before release [192.168.1.101]",
+		"del 192.168.1.101",
+		"after release [192.168.1.101]: no such address",
+		"before acquire [192.168.1.101]",
+		"add 192.168.1.101",
+		"after acquire [192.168.1.101]: <nil>",
+		"before release [192.168.1.100 192.168.1.101]",
+		"del 192.168.1.100",
+		"del 192.168.1.101",
+		"after release [192.168.1.100 192.168.1.101]: no such address",
+	}
+	if strings.Join(rec.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(rec.calls, "\n"), strings.Join(want, "\n"))
+	}
+	// Leaving MASTER for INIT is the shutdown, whose failures Stop reports
+	if sm.shutdownErr == nil {
+		t.Error("shutdownErr = nil, want the failed release of 192.168.1.101")
+	}
+}
+
+func TestExcludedIPs(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	vip, first, second := net.ParseIP("192.168.1.100").To4(), net.ParseIP("192.168.1.200").To4(),
+		net.ParseIP("192.168.1.201").To4()
+	sm := NewStateMachine(10, 100, []net.IP{vip}, iface)
+	rec := &recordingAddresses{}
+	sm.SetAddressManager(rec)
+	sm.SetExcludedIPs([]net.IP{first})
+
+	sm.transition(Master)
+	pkt := <-sm.sendCh
+	if len(pkt.IPAddresses) != 1 || !pkt.IPAddresses[0].Equal(vip) {
+		t.Errorf("advertisement carries %v, want only the virtual IP", pkt.IPAddresses)
+	}
+
+	// Moving an address from one list to the other keeps it
+	sm.exec(func() { sm.setAddresses([]net.IP{vip, first}, nil) })
+	if pkt := <-sm.sendCh; len(pkt.IPAddresses) != 2 {
+		t.Errorf("advertisement after the move carries %v, want both addresses", pkt.IPAddresses)
+	}
+	sm.SetExcludedIPs([]net.IP{second})
+	sm.transition(Init)
+
+	want := []string{
+		"add 192.168.1.100",
+		"add 192.168.1.200",
+		"add 192.168.1.201",
+		"del 192.168.1.100",
+		"del 192.168.1.200",
+		"del 192.168.1.201",
+	}
+	if strings.Join(rec.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(rec.calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestResumeMasterAndDetach(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100").To4()}, iface)
+	rec := &recordingAddresses{}
+	sm.SetAddressManager(rec)
+	sm.SetResumeMaster(true)
+
+	if err := sm.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for sm.GetState() != Master && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := sm.GetState(); got != Master {
+		t.Fatalf("state = %v, want MASTER at once with priority 100 when resuming", got)
+	}
+
+	sm.Detach()
+	if got := sm.GetState(); got != Init {
+		t.Errorf("state after Detach = %v, want INIT", got)
+	}
+	if want := []string{"add 192.168.1.100"}; !reflect.DeepEqual(rec.calls, want) {
+		t.Errorf("address calls = %v, want %v: Detach must leave the VIPs", rec.calls, want)
+	}
+}
+
+func TestMasterReason(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetAddressManager(NopAddresses{})
+
+	sm.handleEvent(EventStartup)
+	if got := sm.MasterReason(); got != MasterReasonNone {
+		t.Errorf("MasterReason as BACKUP = %q, want none", got)
+	}
+
+	// A lower-priority master does not reset the timer, so it is preempted
+	sm.handlePacket(&Packet{VRID: 10, Priority: 50})
+	sm.handleEvent(EventMasterDown)
+	if got := sm.MasterReason(); sm.GetState() != Master || got != MasterReasonPreempted {
+		t.Errorf("after preempting: state %v, MasterReason %q; want MASTER, %q", sm.GetState(), got, MasterReasonPreempted)
+	}
+
+	sm.transition(Backup)
+	if got := sm.MasterReason(); got != MasterReasonNone {
+		t.Errorf("MasterReason after leaving MASTER = %q, want none", got)
+	}
+
+	// A better master that goes silent
+	sm.handlePacket(&Packet{VRID: 10, Priority: 150})
+	sm.handleEvent(EventMasterDown)
+	if got := sm.MasterReason(); got != MasterReasonNoResponse {
+		t.Errorf("after the master went silent: MasterReason %q, want %q", got, MasterReasonNoResponse)
+	}
+
+	owner := NewStateMachine(10, 255, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	owner.SetAddressManager(NopAddresses{})
+	owner.handleEvent(EventStartup)
+	if got := owner.MasterReason(); got != MasterReasonPriority {
+		t.Errorf("address owner MasterReason = %q, want %q", got, MasterReasonPriority)
+	}
+}
+
+func TestFailoverLatency(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetAddressManager(NopAddresses{})
+
+	var latencies []time.Duration
+	sm.SetFailoverCallback(func(d time.Duration) { latencies = append(latencies, d) })
+
+	sm.handleEvent(EventStartup)
+
+	// A live master heard after the timer fired cancels the measurement
+	sm.masterDownAt = time.Now()
+	sm.handlePacket(&Packet{VRID: 10, Priority: 150})
+	if !sm.masterDownAt.IsZero() {
+		t.Error("advertisement from a live master did not clear the master down time")
+	}
+
+	sm.masterDownAt = time.Now().Add(-50 * time.Millisecond)
+	sm.handleEvent(EventMasterDown)
+	if len(latencies) != 1 || latencies[0] < 50*time.Millisecond {
+		t.Fatalf("failover latencies = %v, want one of at least 50ms", latencies)
+	}
+
+	// Becoming MASTER without a master down, as the address owner does, is not a failover
+	owner := NewStateMachine(10, 255, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	owner.SetAddressManager(NopAddresses{})
+	owner.SetFailoverCallback(func(d time.Duration) { latencies = append(latencies, d) })
+	owner.handleEvent(EventStartup)
+	if len(latencies) != 1 {
+		t.Errorf("startup as owner reported failover latency %v", latencies[1:])
+	}
+}
+
+func TestTransitionCause(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(10, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetAddressManager(NopAddresses{})
+
+	var got []Transition
+	sm.SetTransitionCallback(func(tr Transition) { got = append(got, tr) })
+
+	sm.handleEvent(EventStartup)
+	sm.handleEvent(EventPriorityZeroReceived)
+	sm.handleEvent(EventMasterDown)
+	sm.handlePacket(&Packet{VRID: 10, Priority: 150})
+	sm.handlePacket(&Packet{VRID: 10, Priority: 50})
+	sm.handleEvent(EventMasterDown)
+	if err := sm.StepDown(time.Minute); err != nil {
+		t.Fatalf("StepDown failed: %v", err)
+	}
+	sm.handleEvent(EventMasterDown)
+	sm.handleEvent(EventShutdown)
+
+	want := []struct {
+		old, new State
+		cause    TransitionCause
+		detail   string
+	}{
+		{Init, Backup, CauseStartup, ""},
+		{Backup, Master, CausePriorityZero, ""},
+		{Master, Backup, CausePreemptedBy, "priority 150"},
+		{Backup, Master, CausePreempt, ""},
+		{Master, Backup, CauseOperator, "step down, hold 1m0s"},
+		{Backup, Master, CauseMasterDown, ""},
+		{Master, Init, CauseShutdown, ""},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d transitions, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Old != w.old || g.New != w.new || g.Cause != w.cause || g.Detail != w.detail {
+			t.Errorf("transition %d = %v -> %v (%s, %q), want %v -> %v (%s, %q)",
+				i, g.Old, g.New, g.Cause, g.Detail, w.old, w.new, w.cause, w.detail)
+		}
+		if g.Priority != 100 || g.Time.IsZero() {
+			t.Errorf("transition %d: priority %d, time %v; want 100 and the time", i, g.Priority, g.Time)
+		}
+	}
+}
+
+func TestBackupOnly(t *testing.T) {
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	// Even the address owner stands by
+	sm := NewStateMachine(10, 255, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	sm.SetAddressManager(NopAddresses{})
+	sm.SetBackupOnly(true)
+	lost := 0
+	sm.SetMasterLostCallback(func() { lost++ })
+
+	sm.handleEvent(EventStartup)
+	if sm.GetState() != Backup {
+		t.Fatalf("state after startup = %v, want BACKUP", sm.GetState())
+	}
+
+	// A lower-priority master is not preempted, and no master is reported
+	// once until one is heard again
+	sm.handlePacket(&Packet{VRID: 10, Priority: 50})
+	if sm.preempting {
+		t.Error("a backup-only router preempts a lower-priority master")
+	}
+	sm.handleEvent(EventMasterDown)
+	sm.handleEvent(EventMasterDown)
+	if sm.GetState() != Backup || lost != 1 {
+		t.Errorf("after the master went silent: state %v, %d alarms; want BACKUP, 1", sm.GetState(), lost)
+	}
+	sm.handlePacket(&Packet{VRID: 10, Priority: 50})
+	sm.handleEvent(EventMasterDown)
+	if lost != 2 {
+		t.Errorf("%d alarms after the master came back and went silent again, want 2", lost)
+	}
+
+	// Turning it off lets the router take over; on again, it steps down
+	sm.SetBackupOnly(false)
+	sm.handleEvent(EventMasterDown)
+	if sm.GetState() != Master {
+		t.Fatalf("state without backup-only = %v, want MASTER", sm.GetState())
+	}
+	<-sm.sendCh // advertisement sent on becoming Master
+	sm.SetBackupOnly(true)
+	if sm.GetState() != Backup {
+		t.Errorf("state after turning backup-only on = %v, want BACKUP", sm.GetState())
+	}
+	select {
+	case pkt := <-sm.sendCh:
+		if pkt.Priority != 0 {
+			t.Errorf("step-down advertisement priority = %d, want 0", pkt.Priority)
+		}
+	default:
+		t.Error("no priority 0 advertisement sent on turning backup-only on")
+	}
+}
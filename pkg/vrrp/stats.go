@@ -0,0 +1,167 @@
+package vrrp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is the complete statistics of a virtual router: its Counters plus
+// what a poller such as the CLI or an SNMP agent needs besides them. Stats
+// and Counters are read from the same counters, so they always agree.
+type Stats struct {
+	Interface string
+	VRID      uint8
+	State     State
+
+	// StateSince is when the current state was entered, zero before the
+	// first transition; StateUptime is the time since then
+	StateSince  time.Time
+	StateUptime time.Duration
+
+	// MasterReason is why the router became MASTER, empty unless it is
+	// MASTER (vrrpv3StatisticsNewMasterReason)
+	MasterReason MasterReason
+
+	Counters
+
+	// CountersSince is when the counters started from zero: when the router
+	// was created or last reset (vrrpv3StatisticsRowDiscontinuityTime)
+	CountersSince time.Time
+	// LastProtocolError is why the last invalid message was discarded, empty
+	// if none was (vrrpv3StatisticsProtoErrReason)
+	LastProtocolError DropReason
+
+	// Transitions counts state transitions of any kind
+	Transitions uint64
+	// Drops counts every message the router ignored by DropReason: the
+	// validation errors, Counters.PacketsDropped and its own advertisements.
+	// Every reason in DropReasons is present, even if zero.
+	Drops map[DropReason]uint64
+	// LastAdvert is the last advertisement heard from another router
+	LastAdvert PeerInfo
+	// VIPErrors counts virtual IP additions and removals that failed
+	VIPErrors uint64
+	// SplitBrains counts the split brains detected
+	SplitBrains uint64
+	// OffLinkAdverts counts the advertisements for the VRID from sources
+	// outside the interface's subnets, while Config.OnLinkCheck is count or
+	// enforce. When enforcing they are dropped, and also counted in Drops.
+	OffLinkAdverts uint64
+	// ForeignVersionAdverts counts the VRRPv3 advertisements for the VRID,
+	// accepted or dropped according to Config.VersionPolicy. Those dropped
+	// are also counted in Drops.
+	ForeignVersionAdverts uint64
+	// MembershipLost counts the times the router's socket was found out of
+	// the VRRP multicast group and joined it again (see Config.Membership).
+	// It belongs to the socket, which routers run by a Manager share, and
+	// ResetStats does not zero it.
+	MembershipLost uint64
+
+	// Jitter is the advertisement jitter of each peer by source address:
+	// how far apart its advertisements arrive from its advertised interval
+	Jitter map[string]Histogram
+	// FailoverLatency is the time from the master down timer firing to the
+	// virtual IPs being programmed, for each takeover
+	FailoverLatency Histogram
+}
+
+// GetStats returns a snapshot of the router's statistics
+func (vr *VirtualRouter) GetStats() Stats {
+	return vr.stats(false)
+}
+
+// ResetStats zeroes the counters in Stats, returning their values from just
+// before the reset, and empties the histograms. The state, its uptime,
+// LastAdvert and LastProtocolError are not counters and are kept.
+func (vr *VirtualRouter) ResetStats() Stats {
+	return vr.stats(true)
+}
+
+func (vr *VirtualRouter) stats(reset bool) Stats {
+	read := func(c *atomic.Uint64) uint64 {
+		if reset {
+			return c.Swap(0)
+		}
+		return c.Load()
+	}
+
+	state := vr.GetState()
+
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+
+	s := Stats{
+		Interface: vr.iface,
+		VRID:      vr.vrid,
+		State:     state,
+		Counters: Counters{
+			AdvertsSent:          read(&vr.advertsSent),
+			AdvertsReceived:      read(&vr.advertsReceived),
+			BecomeMaster:         read(&vr.becomeMaster),
+			ChecksumErrors:       read(&vr.checksumErrors),
+			TTLErrors:            read(&vr.ttlErrors),
+			VRIDMismatches:       read(&vr.vridMismatches),
+			VersionErrors:        read(&vr.versionErrors),
+			InvalidTypeReceived:  read(&vr.invalidTypeRecv),
+			AdvIntervalErrors:    read(&vr.advIntervalErrors),
+			AddressListErrors:    read(&vr.addressListErrors),
+			PriorityZeroReceived: read(&vr.priorityZeroRecv),
+			PriorityZeroSent:     read(&vr.priorityZeroSent),
+		},
+		Transitions: read(&vr.transitions),
+		VIPErrors:   read(&vr.vipErrors),
+		SplitBrains: read(&vr.splitBrains),
+	}
+	s.OffLinkAdverts = read(&vr.offLinkAdverts)
+	s.ForeignVersionAdverts = read(&vr.foreignVersion)
+	if vr.network != nil {
+		s.MembershipLost = vr.network.MembershipLost()
+	}
+
+	decode := read(&vr.decodeErrors)
+	var queueFull uint64
+	if vr.stateMachine != nil {
+		if reset {
+			queueFull = vr.stateMachine.resetDroppedPackets()
+		} else {
+			queueFull = vr.stateMachine.DroppedPackets()
+		}
+		s.MasterReason = vr.stateMachine.MasterReason()
+	}
+	s.PacketLengthErrors = decode
+	s.PacketsDropped = decode + s.VersionErrors + s.InvalidTypeReceived + queueFull
+	s.Drops = map[DropReason]uint64{
+		DropDecode:         decode,
+		DropVersion:        s.VersionErrors,
+		DropType:           s.InvalidTypeReceived,
+		DropChecksum:       s.ChecksumErrors,
+		DropTTL:            s.TTLErrors,
+		DropVRIDMismatch:   s.VRIDMismatches,
+		DropThrottled:      read(&vr.throttled),
+		DropNotOnLink:      read(&vr.notOnLink),
+		DropPeer:           read(&vr.peerRejected),
+		DropAuth:           read(&vr.authFailures),
+		DropReplay:         read(&vr.replays),
+		DropForeignVersion: read(&vr.versionDrops),
+		DropInterval:       read(&vr.intervalDrops),
+		DropAddressList:    read(&vr.addressDrops),
+		DropQueueFull:      queueFull,
+		DropOwn:            read(&vr.ownAdverts),
+	}
+
+	vr.statsMu.Lock()
+	s.StateSince = vr.lastTransition
+	s.LastAdvert = vr.peer.clone()
+	s.LastProtocolError, _ = vr.lastProtoError.Load().(DropReason)
+	s.CountersSince = vr.countersSince
+	vr.latencyStats(&s, reset)
+	if reset {
+		vr.countersSince = time.Now()
+	}
+	vr.statsMu.Unlock()
+	if !s.StateSince.IsZero() {
+		s.StateUptime = time.Since(s.StateSince)
+	}
+
+	return s
+}
@@ -0,0 +1,150 @@
+package vrrp
+
+import (
+	"sync"
+)
+
+// SyncGroup makes a set of virtual routers fail over together, such as the
+// inside and outside interfaces of a firewall. When any member leaves MASTER,
+// because a peer preempted it or it was stopped, the other members step down
+// too. A member only becomes MASTER once every member would win its own
+// election; until then it waits in BACKUP.
+type SyncGroup struct {
+	name string
+
+	mu      sync.Mutex
+	members []*syncMember
+}
+
+// syncMember is a router's place in a group. sm is the state machine of the
+// running router and nil while it is stopped, which blocks the group from
+// claiming mastership.
+type syncMember struct {
+	group *SyncGroup
+	sm    *StateMachine
+
+	// ready is set while a BACKUP member's master down timer has fired, i.e.
+	// it would be MASTER were it not for the group
+	ready bool
+}
+
+// NewSyncGroup creates an empty sync group. Routers join it through
+// Config.SyncGroup.
+func NewSyncGroup(name string) *SyncGroup {
+	return &SyncGroup{name: name}
+}
+
+// Name returns the group's name
+func (g *SyncGroup) Name() string {
+	return g.name
+}
+
+// join adds a member that blocks the group until it is started
+func (g *SyncGroup) join() *syncMember {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	m := &syncMember{group: g}
+	g.members = append(g.members, m)
+	return m
+}
+
+// leave removes a member for good, so it no longer holds back the others
+func (g *SyncGroup) leave(m *syncMember) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, candidate := range g.members {
+		if candidate == m {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// attach binds the member to the state machine of a starting router
+func (m *syncMember) attach(sm *StateMachine) {
+	m.group.mu.Lock()
+	defer m.group.mu.Unlock()
+
+	m.sm = sm
+	m.ready = false
+	sm.syncMember = m
+}
+
+// detach marks the member stopped once its router's state machine has exited
+func (m *syncMember) detach(sm *StateMachine) {
+	m.group.mu.Lock()
+	defer m.group.mu.Unlock()
+
+	if m.sm == sm {
+		m.sm = nil
+		m.ready = false
+	}
+}
+
+// claim is called on m's state machine goroutine when it would become MASTER.
+// It reports whether it may: only when every member is MASTER or ready. The
+// other ready members are told to take over as well.
+func (m *syncMember) claim() bool {
+	g := m.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	m.ready = true
+	for _, other := range g.members {
+		if other == m {
+			continue
+		}
+		if other.sm == nil || (!other.ready && other.sm.GetState() != Master) {
+			m.sm.logger.Debug("Waiting for sync group", "sync_group", g.name)
+			return false
+		}
+	}
+
+	for _, other := range g.members {
+		if other != m && other.ready {
+			other.sm.post(EventSyncGroupMaster)
+		}
+	}
+	m.ready = false
+	return true
+}
+
+// unready is called when m hears a better master and stays BACKUP. Members
+// that are still MASTER step down so the group is not split.
+func (m *syncMember) unready() {
+	m.group.mu.Lock()
+	defer m.group.mu.Unlock()
+
+	m.ready = false
+	m.stepDownOthers()
+}
+
+// leftMaster is called after m left MASTER: the other members step down too
+func (m *syncMember) leftMaster() {
+	m.group.mu.Lock()
+	defer m.group.mu.Unlock()
+
+	m.stepDownOthers()
+}
+
+// stepDownOthers tells the other members that are MASTER to step down. The
+// group's mu must be held.
+func (m *syncMember) stepDownOthers() {
+	for _, other := range m.group.members {
+		if other != m && other.sm != nil && other.sm.GetState() == Master {
+			other.sm.post(EventSyncGroupBackup)
+		}
+	}
+}
+
+// takeReady clears m's ready flag and reports whether it was set
+func (m *syncMember) takeReady() bool {
+	m.group.mu.Lock()
+	defer m.group.mu.Unlock()
+
+	ready := m.ready
+	m.ready = false
+	return ready
+}
@@ -0,0 +1,104 @@
+package vrrp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newSyncGroupMember(t *testing.T, g *SyncGroup, vrid uint8) *StateMachine {
+	t.Helper()
+
+	iface := &net.Interface{Index: 1, Name: "test0"}
+	sm := NewStateMachine(vrid, 100, []net.IP{net.ParseIP("192.168.1.100")}, iface)
+	g.join().attach(sm)
+	sm.transition(Backup)
+	return sm
+}
+
+func nextEvent(t *testing.T, sm *StateMachine) Event {
+	t.Helper()
+
+	select {
+	case ev := <-sm.eventCh:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("VRID %d: no event posted", sm.vrid)
+		return 0
+	}
+}
+
+func TestSyncGroupClaimsTogether(t *testing.T) {
+	g := NewSyncGroup("edge")
+	inside := newSyncGroupMember(t, g, 10)
+	outside := newSyncGroupMember(t, g, 20)
+
+	// The first member to win its election waits for the other
+	inside.handleEvent(EventMasterDown)
+	if inside.GetState() != Backup {
+		t.Fatalf("inside = %v, want BACKUP until outside can be MASTER too", inside.GetState())
+	}
+
+	outside.handleEvent(EventMasterDown)
+	if outside.GetState() != Master {
+		t.Fatalf("outside = %v, want MASTER once the whole group is ready", outside.GetState())
+	}
+
+	if ev := nextEvent(t, inside); ev != EventSyncGroupMaster {
+		t.Fatalf("inside got event %d, want EventSyncGroupMaster", ev)
+	}
+	inside.handleEvent(EventSyncGroupMaster)
+	if inside.GetState() != Master {
+		t.Errorf("inside = %v, want MASTER with the group", inside.GetState())
+	}
+}
+
+func TestSyncGroupStepsDownTogether(t *testing.T) {
+	g := NewSyncGroup("edge")
+	inside := newSyncGroupMember(t, g, 10)
+	outside := newSyncGroupMember(t, g, 20)
+	inside.transition(Master)
+	outside.transition(Master)
+	<-inside.sendCh
+
+	// A better master on the outside link preempts that member
+	outside.handlePacket(&Packet{VRID: 20, Priority: 200})
+	if outside.GetState() != Backup {
+		t.Fatalf("outside = %v, want BACKUP after being preempted", outside.GetState())
+	}
+
+	if ev := nextEvent(t, inside); ev != EventSyncGroupBackup {
+		t.Fatalf("inside got event %d, want EventSyncGroupBackup", ev)
+	}
+	inside.handleEvent(EventSyncGroupBackup)
+	if inside.GetState() != Backup {
+		t.Errorf("inside = %v, want BACKUP with the group", inside.GetState())
+	}
+
+	select {
+	case pkt := <-inside.sendCh:
+		if pkt.Priority != 0 {
+			t.Errorf("step-down advertisement has priority %d, want 0", pkt.Priority)
+		}
+	default:
+		t.Error("inside sent no priority 0 advertisement")
+	}
+}
+
+func TestSyncGroupStoppedMemberBlocks(t *testing.T) {
+	g := NewSyncGroup("edge")
+	inside := newSyncGroupMember(t, g, 10)
+	outside := newSyncGroupMember(t, g, 20)
+
+	outside.syncMember.detach(outside)
+	inside.handleEvent(EventMasterDown)
+	if inside.GetState() != Backup {
+		t.Errorf("inside = %v, want BACKUP while outside is stopped", inside.GetState())
+	}
+
+	g.leave(outside.syncMember)
+	inside.handleEvent(EventMasterDown)
+	if inside.GetState() != Master {
+		t.Errorf("inside = %v, want MASTER once outside left the group", inside.GetState())
+	}
+}
@@ -0,0 +1,124 @@
+package vrrp
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultMaxAdvertRate is the advertisements per second a router processes
+// from one source unless Config.MaxAdvertRate says otherwise. A router
+// advertises once per interval, plus once for each change of priority or
+// state, so this only holds back a peer gone wrong.
+const DefaultMaxAdvertRate = 10
+
+// tokenBucket allows up to burst advertisements at once, refilled at the
+// throttle's rate
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// dropped counts the advertisements held back since the bucket last
+	// ran dry, zero while it is not throttling
+	dropped uint64
+}
+
+func (b *tokenBucket) take(now time.Time, rate, burst float64) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// throttle limits the advertisements a router processes from each source
+// with a token bucket per source, so a peer blasting advertisements cannot
+// keep the receive loop and the log busy. Sources beyond MaxPeers share one
+// bucket, which bounds the work a flood from forged sources causes too.
+type throttle struct {
+	rate  float64
+	burst float64
+
+	mu       sync.Mutex
+	sources  map[netip.Addr]*tokenBucket
+	overflow tokenBucket
+}
+
+// newThrottle returns a throttle allowing rate advertisements per second
+// from each source, with bursts of twice as many; nil if rate is not
+// positive, which allows any rate
+func newThrottle(rate float64) *throttle {
+	if rate <= 0 {
+		return nil
+	}
+	return &throttle{
+		rate:    rate,
+		burst:   max(1, 2*rate),
+		sources: make(map[netip.Addr]*tokenBucket),
+	}
+}
+
+// throttleEvent is a change in whether a source is throttled
+type throttleEvent int
+
+const (
+	throttleNone throttleEvent = iota
+	// throttleStarted is the first advertisement from a source held back
+	throttleStarted
+	// throttleEnded is the first advertisement from a throttled source let
+	// through again
+	throttleEnded
+)
+
+// allow reports whether an advertisement from src arriving at now is to be
+// processed. When src starts or stops being throttled it returns the event,
+// and when it stops, how many advertisements were held back.
+func (t *throttle) allow(src netip.Addr, now time.Time) (bool, throttleEvent, uint64) {
+	if t == nil {
+		return true, throttleNone, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.bucket(src, now)
+	if !b.take(now, t.rate, t.burst) {
+		b.dropped++
+		if b.dropped == 1 {
+			return false, throttleStarted, 0
+		}
+		return false, throttleNone, 0
+	}
+	if dropped := b.dropped; dropped > 0 {
+		b.dropped = 0
+		return true, throttleEnded, dropped
+	}
+	return true, throttleNone, 0
+}
+
+// bucket returns the bucket of src, adding one if the table has room. A full
+// table first forgets the sources whose buckets have refilled, which are
+// as good as new. t.mu must be held.
+func (t *throttle) bucket(src netip.Addr, now time.Time) *tokenBucket {
+	if b, ok := t.sources[src]; ok {
+		return b
+	}
+	if len(t.sources) >= MaxPeers {
+		refill := time.Duration(t.burst / t.rate * float64(time.Second))
+		for addr, b := range t.sources {
+			if b.dropped == 0 && now.Sub(b.last) >= refill {
+				delete(t.sources, addr)
+			}
+		}
+	}
+	if len(t.sources) >= MaxPeers {
+		return &t.overflow
+	}
+	b := &tokenBucket{}
+	t.sources[src] = b
+	return b
+}
@@ -0,0 +1,93 @@
+package vrrp
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(10)
+	src := netip.MustParseAddr("10.0.0.2")
+	now := time.Now()
+
+	// A burst of twice the rate goes through, the next is held back
+	for i := 0; i < 20; i++ {
+		if ok, _, _ := th.allow(src, now); !ok {
+			t.Fatalf("advertisement %d of the burst held back", i)
+		}
+	}
+	if ok, event, _ := th.allow(src, now); ok || event != throttleStarted {
+		t.Fatalf("after the burst = %v, %v; want held back, throttling started", ok, event)
+	}
+	if ok, event, _ := th.allow(src, now); ok || event != throttleNone {
+		t.Errorf("second held back = %v, %v; want no new event", ok, event)
+	}
+
+	// Other sources have buckets of their own
+	if ok, _, _ := th.allow(netip.MustParseAddr("10.0.0.3"), now); !ok {
+		t.Error("another source held back")
+	}
+
+	// The bucket refills at the rate
+	ok, event, dropped := th.allow(src, now.Add(100*time.Millisecond))
+	if !ok || event != throttleEnded || dropped != 2 {
+		t.Errorf("after a refill = %v, %v, %d; want let through, throttling ended, 2 dropped", ok, event, dropped)
+	}
+
+	if ok, _, _ := newThrottle(-1).allow(src, now); !ok {
+		t.Error("held back without a limit")
+	}
+}
+
+func TestThrottleForgedSources(t *testing.T) {
+	th := newThrottle(1)
+	now := time.Now()
+	src := func(i int) netip.Addr { return netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}) }
+
+	passed := 0
+	for i := 0; i < 1000; i++ {
+		if ok, _, _ := th.allow(src(i), now); ok {
+			passed++
+		}
+	}
+	// One from each of MaxPeers sources, then the shared bucket's burst of 2
+	if want := MaxPeers + 2; passed != want {
+		t.Errorf("%d advertisements from forged sources let through, want %d", passed, want)
+	}
+	if len(th.sources) != MaxPeers {
+		t.Errorf("%d sources tracked, want %d", len(th.sources), MaxPeers)
+	}
+
+	// Sources idle long enough to refill make room for new ones
+	later := now.Add(time.Minute)
+	if ok, _, _ := th.allow(src(5000), later); !ok {
+		t.Error("a new source after the flood held back")
+	}
+	if _, ok := th.sources[src(5000)]; !ok {
+		t.Error("a new source after the flood did not get a bucket of its own")
+	}
+}
+
+func TestHandleAdvertThrottled(t *testing.T) {
+	vr := newTestRouter(t)
+	// A burst of 2 and a refill slower than the test
+	vr.throttle = newThrottle(1)
+	ownIP := net.ParseIP("10.0.0.1")
+	flood := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	for i := 0; i < 100; i++ {
+		vr.handleAdvert(flood, marshalAdvert(t, 10, 255), ownIP)
+	}
+	vr.handleAdvert(&ipv4.Header{Src: net.ParseIP("10.0.0.3"), TTL: 255}, marshalAdvert(t, 10, 100), ownIP)
+
+	s := vr.GetStats()
+	if s.AdvertsReceived != 3 || s.Drops[DropThrottled] != 98 {
+		t.Errorf("received %d and throttled %d, want 3 and 98", s.AdvertsReceived, s.Drops[DropThrottled])
+	}
+	if vr.stateMachine.QueueLengths().Recv != 3 {
+		t.Errorf("state machine received %d packets, want 3", vr.stateMachine.QueueLengths().Recv)
+	}
+}
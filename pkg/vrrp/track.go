@@ -0,0 +1,485 @@
+package vrrp
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the settings every tracker has
+const (
+	DefaultTrackInterval = 2 * time.Second
+	DefaultTrackFall     = 3
+	DefaultTrackRise     = 2
+)
+
+// DefaultPriorityInterval is how often a Config.PriorityFunc is evaluated
+// unless Config.PriorityInterval says otherwise
+const DefaultPriorityInterval = time.Second
+
+// PriorityInput is what a PriorityFunc computes the advertised priority from
+type PriorityInput struct {
+	// Priority is the configured priority, Config.Priority or the last
+	// SetPriority
+	Priority uint8
+	// Penalty is what the failing trackers, less the disabled ones, take
+	// off Priority without a PriorityFunc: the sum of their weights, 255
+	// for a weight of 0
+	Penalty int
+	// Trackers are the states of Config.Trackers
+	Trackers []TrackerStatus
+}
+
+// PriorityFunc computes the priority a router advertises (Config.PriorityFunc).
+// A result of 0 is advertised as 1, since priority 0 means the master is
+// leaving.
+type PriorityFunc func(PriorityInput) uint8
+
+// TrackerTypes lists the checks a tracker can run, the TYPE of its spec
+var TrackerTypes = []string{"dns", "grpc"}
+
+// check is the health check a tracker runs. check returns nil while
+// healthy; String names what is checked, without spaces.
+type check interface {
+	check(ctx context.Context) error
+	String() string
+}
+
+// Tracker ties a router's priority to a health check: while the check fails
+// the router advertises a lower priority, so a healthy backup with
+// preemption takes over as MASTER.
+type Tracker struct {
+	// Name identifies the tracker in logs and Status; unique per router
+	Name string
+	// Interval is how often the check runs, and Timeout how long one may
+	// take, at most Interval
+	Interval time.Duration
+	Timeout  time.Duration
+	// Fall is how many checks in a row must fail for the tracker to fail,
+	// and Rise how many must pass for it to recover
+	Fall int
+	Rise int
+	// Weight is subtracted from the priority while the tracker fails; 0
+	// drops it to 1, the lowest a router can advertise
+	Weight uint8
+
+	check check
+	spec  string
+}
+
+// String returns the spec the tracker was parsed from
+func (t *Tracker) String() string {
+	return t.spec
+}
+
+// ParseTracker parses a tracker spec, "TYPE:key=value,...", e.g.
+// "dns:query=example.com,server=127.0.0.1:53,expect=192.0.2.10,fall=3". Besides
+// the keys of its check every tracker takes name, interval, timeout, fall,
+// rise and weight.
+func ParseTracker(spec string) (*Tracker, error) {
+	typ, rest, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok {
+		return nil, fmt.Errorf("%w: tracker %q: want TYPE:key=value,...", ErrInvalidConfig, spec)
+	}
+	t := &Tracker{Interval: DefaultTrackInterval, Fall: DefaultTrackFall, Rise: DefaultTrackRise, spec: spec}
+
+	// The check's own keys, in order; a key may repeat
+	var params [][2]string
+	for _, field := range strings.Split(rest, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: tracker %q: %q is not key=value", ErrInvalidConfig, spec, field)
+		}
+		var err error
+		switch key {
+		case "name":
+			t.Name = value
+		case "interval":
+			t.Interval, err = time.ParseDuration(value)
+		case "timeout":
+			t.Timeout, err = time.ParseDuration(value)
+		case "fall":
+			t.Fall, err = strconv.Atoi(value)
+		case "rise":
+			t.Rise, err = strconv.Atoi(value)
+		case "weight":
+			var w uint64
+			w, err = strconv.ParseUint(value, 10, 8)
+			t.Weight = uint8(w)
+		default:
+			params = append(params, [2]string{key, value})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: tracker %q: %s: %w", ErrInvalidConfig, spec, key, err)
+		}
+	}
+
+	var err error
+	switch typ {
+	case "dns":
+		t.check, err = newDNSCheck(params)
+	case "grpc":
+		t.check, err = newGRPCCheck(params)
+	default:
+		return nil, fmt.Errorf("%w: tracker %q: unknown type %q, want one of %s",
+			ErrInvalidConfig, spec, typ, strings.Join(TrackerTypes, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: tracker %q: %w", ErrInvalidConfig, spec, err)
+	}
+
+	if t.Name == "" {
+		t.Name = typ + ":" + t.check.String()
+	}
+	if t.Timeout == 0 {
+		t.Timeout = t.Interval
+	}
+	switch {
+	case t.Interval <= 0:
+		return nil, fmt.Errorf("%w: tracker %q: interval must be positive", ErrInvalidConfig, spec)
+	case t.Timeout <= 0 || t.Timeout > t.Interval:
+		return nil, fmt.Errorf("%w: tracker %q: timeout must be positive and at most the interval",
+			ErrInvalidConfig, spec)
+	case t.Fall < 1 || t.Rise < 1:
+		return nil, fmt.Errorf("%w: tracker %q: fall and rise must be at least 1", ErrInvalidConfig, spec)
+	}
+	return t, nil
+}
+
+// ParseTrackers parses Config.Trackers, whose names must be unique
+func ParseTrackers(specs []string) ([]*Tracker, error) {
+	trackers := make([]*Tracker, 0, len(specs))
+	for _, spec := range specs {
+		t, err := ParseTracker(spec)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(trackers, func(o *Tracker) bool { return o.Name == t.Name }) {
+			return nil, fmt.Errorf("%w: duplicate tracker name %q", ErrInvalidConfig, t.Name)
+		}
+		trackers = append(trackers, t)
+	}
+	return trackers, nil
+}
+
+// TrackerStatus is the state of one tracker
+type TrackerStatus struct {
+	Name    string
+	Healthy bool
+	// Err is the error of the last failed check, kept until one passes
+	Err string
+	// Since is when the tracker last failed or recovered, or started
+	Since time.Time
+	// Disabled is set by SetTrackerEnabled: the check still runs, but its
+	// failures do not lower the priority
+	Disabled bool
+}
+
+// tracking runs the trackers of a router. The status is guarded by the
+// router's mu.
+type tracking struct {
+	trackers []*Tracker
+	status   map[string]*TrackerStatus
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newTracking(trackers []*Tracker) *tracking {
+	tr := &tracking{trackers: trackers, status: make(map[string]*TrackerStatus)}
+	for _, t := range trackers {
+		tr.status[t.Name] = &TrackerStatus{Name: t.Name, Healthy: true}
+	}
+	return tr
+}
+
+// penalty is how much lower than configured the priority is while the
+// failing trackers fail
+func (tr *tracking) penalty() int {
+	p := 0
+	for _, t := range tr.trackers {
+		if st := tr.status[t.Name]; st.Healthy || st.Disabled {
+			continue
+		}
+		if t.Weight == 0 {
+			p += 255
+		} else {
+			p += int(t.Weight)
+		}
+	}
+	return p
+}
+
+func (tr *tracking) snapshot() []TrackerStatus {
+	if len(tr.trackers) == 0 {
+		return nil
+	}
+	out := make([]TrackerStatus, len(tr.trackers))
+	for i, t := range tr.trackers {
+		out[i] = *tr.status[t.Name]
+	}
+	return out
+}
+
+// reset makes every tracker healthy again, as of now, keeping the disabled
+// ones disabled
+func (tr *tracking) reset() {
+	now := time.Now()
+	for _, t := range tr.trackers {
+		st := tr.status[t.Name]
+		*st = TrackerStatus{Name: t.Name, Healthy: true, Since: now, Disabled: st.Disabled}
+	}
+}
+
+// startTrackers runs the trackers, and the evaluation of the PriorityFunc,
+// until the router stops or they are replaced. It must be called with vr.mu
+// held, while the router runs.
+func (vr *VirtualRouter) startTrackers() {
+	tr := vr.tracking
+	var ctx context.Context
+	ctx, tr.cancel = context.WithCancel(vr.ctx)
+	for _, t := range tr.trackers {
+		tr.wg.Add(1)
+		go vr.trackLoop(ctx, tr, t)
+	}
+	if vr.priorityFn != nil {
+		tr.wg.Add(1)
+		go vr.priorityLoop(ctx, tr)
+	}
+}
+
+// priorityLoop evaluates the PriorityFunc every PriorityInterval, for the
+// signals of the caller's own the router does not see change
+func (vr *VirtualRouter) priorityLoop(ctx context.Context, tr *tracking) {
+	defer tr.wg.Done()
+
+	ticker := time.NewTicker(vr.priorityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		vr.mu.Lock()
+		if vr.tracking != tr {
+			vr.mu.Unlock()
+			return
+		}
+		old := vr.advertised
+		priority, changed := vr.reprioritize()
+		sm := vr.stateMachine
+		vr.mu.Unlock()
+
+		if changed {
+			vr.logger.Info("Priority re-evaluated", "priority", priority, "old_priority", old)
+			vr.applyPriority(sm, priority)
+		}
+	}
+}
+
+// stopTrackers waits for the trackers to return once the router is
+// canceled. It must be called without vr.mu held, which they take.
+func (vr *VirtualRouter) stopTrackers() {
+	vr.mu.RLock()
+	tr, cancel := vr.tracking, vr.tracking.cancel
+	vr.mu.RUnlock()
+	if cancel != nil {
+		cancel()
+		tr.wg.Wait()
+	}
+}
+
+// trackLoop runs t's check every interval, reporting when it fails Fall
+// times in a row and when it passes Rise times in a row after that
+func (vr *VirtualRouter) trackLoop(ctx context.Context, tr *tracking, t *Tracker) {
+	defer tr.wg.Done()
+
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	healthy, fails, passes := true, 0, 0
+	for {
+		cctx, cancel := context.WithTimeout(ctx, t.Timeout)
+		err := t.check.check(cctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fails, passes = fails+1, 0
+		} else {
+			fails, passes = 0, passes+1
+		}
+		switch {
+		case healthy && fails >= t.Fall:
+			healthy = false
+			vr.tracked(tr, t, err)
+		case !healthy && passes >= t.Rise:
+			healthy = true
+			vr.tracked(tr, t, nil)
+		case !healthy && err != nil:
+			vr.trackErr(tr, t, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tracked records that t failed with err, or recovered if err is nil, and
+// applies the priority that results
+func (vr *VirtualRouter) tracked(tr *tracking, t *Tracker, err error) {
+	vr.mu.Lock()
+	if vr.tracking != tr {
+		// Replaced while checking
+		vr.mu.Unlock()
+		return
+	}
+	st := tr.status[t.Name]
+	st.Healthy, st.Since, st.Err = err == nil, time.Now(), ""
+	if err != nil {
+		st.Err = err.Error()
+	}
+	priority, changed := vr.reprioritize()
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if err != nil {
+		vr.logger.Warn("Tracker failed", "tracker", t.Name, "err", err, "priority", priority)
+	} else {
+		vr.logger.Info("Tracker recovered", "tracker", t.Name, "priority", priority)
+	}
+	if changed {
+		vr.applyPriority(sm, priority)
+	}
+}
+
+// trackErr keeps the latest error of a failed tracker for Status
+func (vr *VirtualRouter) trackErr(tr *tracking, t *Tracker, err error) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	if vr.tracking == tr {
+		tr.status[t.Name].Err = err.Error()
+	}
+}
+
+// effectivePriority is the configured priority less the penalty of the
+// failing trackers, or what the PriorityFunc makes of them, at least 1, and
+// 1 during a Handoff. It must be called with vr.mu held.
+func (vr *VirtualRouter) effectivePriority() uint8 {
+	if vr.handoff.Load() != nil {
+		return 1
+	}
+	penalty := 0
+	if vr.tracking != nil {
+		penalty = vr.tracking.penalty()
+	}
+	if vr.priorityFn != nil {
+		return max(vr.priorityFn(PriorityInput{
+			Priority: vr.priority,
+			Penalty:  penalty,
+			Trackers: vr.tracking.snapshot(),
+		}), 1)
+	}
+	return uint8(max(int(vr.priority)-penalty, 1))
+}
+
+// reprioritize records the effective priority as the one advertised and
+// reports whether it changed. It must be called with vr.mu held.
+func (vr *VirtualRouter) reprioritize() (uint8, bool) {
+	priority := vr.effectivePriority()
+	changed := priority != vr.advertised
+	vr.advertised = priority
+	return priority, changed
+}
+
+// applyPriority makes sm, if started, advertise priority
+func (vr *VirtualRouter) applyPriority(sm *StateMachine, priority uint8) {
+	if sm != nil {
+		sm.SetPriority(priority)
+	}
+	vr.metrics.PriorityChanged(vr.iface, vr.vrid, priority)
+}
+
+// SetTrackers replaces Config.Trackers. The new trackers start healthy and
+// the priority is restored until one of them fails.
+func (vr *VirtualRouter) SetTrackers(specs []string) error {
+	trackers, err := ParseTrackers(specs)
+	if err != nil {
+		return err
+	}
+
+	vr.mu.Lock()
+	old, oldCancel := vr.tracking, vr.tracking.cancel
+	vr.tracking = newTracking(trackers)
+	vr.tracking.reset()
+	if vr.running && vr.ctx.Err() == nil {
+		vr.startTrackers()
+	}
+	priority, changed := vr.reprioritize()
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if oldCancel != nil {
+		oldCancel()
+		old.wg.Wait()
+	}
+	if changed {
+		vr.applyPriority(sm, priority)
+	}
+	vr.logger.Info("Trackers changed", "trackers", trackerNames(trackers))
+	return nil
+}
+
+// SetTrackerEnabled turns the tracker named name off or back on, e.g. while
+// the service it checks is maintained. A disabled tracker keeps checking and
+// reporting its state, but no longer lowers the priority. SetTrackers, and
+// so a reload changing the trackers, enables them all again.
+func (vr *VirtualRouter) SetTrackerEnabled(name string, enabled bool) error {
+	vr.mu.Lock()
+	st, ok := vr.tracking.status[name]
+	if !ok {
+		vr.mu.Unlock()
+		return fmt.Errorf("%w: no tracker %q", ErrInvalidConfig, name)
+	}
+	st.Disabled = !enabled
+	priority, changed := vr.reprioritize()
+	sm := vr.stateMachine
+	vr.mu.Unlock()
+
+	if changed {
+		vr.applyPriority(sm, priority)
+	}
+	if enabled {
+		vr.logger.Info("Tracker enabled", "tracker", name, "priority", priority)
+	} else {
+		vr.logger.Info("Tracker disabled", "tracker", name, "priority", priority)
+	}
+	return nil
+}
+
+// TrackerSpecs returns the specs of the router's trackers
+func (vr *VirtualRouter) TrackerSpecs() []string {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	specs := make([]string, len(vr.tracking.trackers))
+	for i, t := range vr.tracking.trackers {
+		specs[i] = t.spec
+	}
+	return specs
+}
+
+func trackerNames(trackers []*Tracker) []string {
+	names := make([]string, len(trackers))
+	for i, t := range trackers {
+		names[i] = t.Name
+	}
+	return names
+}
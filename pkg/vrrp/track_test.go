@@ -0,0 +1,324 @@
+package vrrp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseTracker(t *testing.T) {
+	tr, err := ParseTracker("dns:query=example.com,server=127.0.0.1,expect=192.0.2.10,expect=192.0.2.11," +
+		"interval=5s,fall=2,weight=50")
+	if err != nil {
+		t.Fatalf("ParseTracker: %v", err)
+	}
+	if tr.Name != "dns:example.com@127.0.0.1:53" || tr.Interval != 5*time.Second || tr.Timeout != 5*time.Second ||
+		tr.Fall != 2 || tr.Rise != DefaultTrackRise || tr.Weight != 50 {
+		t.Errorf("ParseTracker = %+v", tr)
+	}
+	c := tr.check.(*dnsCheck)
+	if c.server != "127.0.0.1:53" || c.qtype != dnsmessage.TypeA || len(c.expect) != 2 {
+		t.Errorf("dns check = %+v", c)
+	}
+
+	tr, err = ParseTracker("dns:name=v6,query=example.com.,server=[::1]:5353,type=aaaa,timeout=1s")
+	if err != nil {
+		t.Fatalf("ParseTracker: %v", err)
+	}
+	if tr.Name != "v6" || tr.Timeout != time.Second || tr.check.String() != "example.com/AAAA@[::1]:5353" {
+		t.Errorf("ParseTracker = %+v, check %s", tr, tr.check)
+	}
+
+	for _, spec := range []string{
+		"",
+		"dns",
+		"ping:host=192.0.2.1",
+		"dns:query=example.com",
+		"dns:server=127.0.0.1",
+		"dns:query=example.com,server=127.0.0.1,type=MX",
+		"dns:query=example.com,server=127.0.0.1,expect=bogus",
+		"dns:query=example.com,server=127.0.0.1,expect=::1",
+		"dns:query=example.com,server=127.0.0.1,port=53",
+		"dns:query=example.com,server=127.0.0.1,fall",
+		"dns:query=example.com,server=127.0.0.1,fall=0",
+		"dns:query=example.com,server=127.0.0.1,interval=0s",
+		"dns:query=example.com,server=127.0.0.1,timeout=3s",
+		"dns:query=example.com,server=127.0.0.1,weight=256",
+	} {
+		if _, err := ParseTracker(spec); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseTracker(%q) = %v, want ErrInvalidConfig", spec, err)
+		}
+	}
+
+	if _, err := ParseTrackers([]string{
+		"dns:query=example.com,server=127.0.0.1",
+		"dns:query=example.com,server=127.0.0.1,fall=5",
+	}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("ParseTrackers with a duplicate name = %v, want ErrInvalidConfig", err)
+	}
+}
+
+// fakeResolver answers A queries for example.com from records, or with
+// rcode, and drops the rest
+func fakeResolver(t *testing.T, rcode dnsmessage.RCode, records ...string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if err := q.Unpack(buf[:n]); err != nil || q.Questions[0].Name.String() != "example.com." {
+				continue
+			}
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: q.ID, Response: true, RCode: rcode},
+				Questions: q.Questions,
+			}
+			for _, r := range records {
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA,
+						Class: dnsmessage.ClassINET, TTL: 60},
+					Body: &dnsmessage.AResource{A: netip.MustParseAddr(r).As4()},
+				})
+			}
+			out, _ := resp.Pack()
+			_, _ = conn.WriteTo(out, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSCheck(t *testing.T) {
+	ok := fakeResolver(t, dnsmessage.RCodeSuccess, "192.0.2.10")
+	for _, tc := range []struct {
+		params [][2]string
+		want   string // substring of the error, "" for none
+	}{
+		{[][2]string{{"query", "example.com"}, {"server", ok}}, ""},
+		{[][2]string{{"query", "example.com"}, {"server", ok}, {"expect", "192.0.2.10"}}, ""},
+		{[][2]string{{"query", "example.com"}, {"server", ok}, {"expect", "192.0.2.11"}},
+			"unexpected answer 192.0.2.10"},
+		{[][2]string{{"query", "example.com"}, {"server", fakeResolver(t, dnsmessage.RCodeNameError)}},
+			"NameError"},
+		{[][2]string{{"query", "example.com"}, {"server", fakeResolver(t, dnsmessage.RCodeSuccess)}},
+			"no records"},
+		{[][2]string{{"query", "example.org"}, {"server", ok}}, "timeout"},
+	} {
+		c, err := newDNSCheck(tc.params)
+		if err != nil {
+			t.Fatalf("newDNSCheck(%v): %v", tc.params, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		err = c.check(ctx)
+		cancel()
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("check %v = %v, want success", tc.params, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("check %v = %v, want an error with %q", tc.params, err, tc.want)
+		}
+	}
+}
+
+// fakeCheck fails while fail is set
+type fakeCheck struct {
+	fail atomic.Bool
+}
+
+func (c *fakeCheck) check(context.Context) error {
+	if c.fail.Load() {
+		return errors.New("down")
+	}
+	return nil
+}
+
+func (c *fakeCheck) String() string { return "fake" }
+
+func TestTrackerPriority(t *testing.T) {
+	vr := newTestRouter(t)
+	light := &Tracker{Name: "light", Weight: 30, check: &fakeCheck{}}
+	heavy := &Tracker{Name: "heavy", Weight: 80, check: &fakeCheck{}}
+	all := &Tracker{Name: "all", check: &fakeCheck{}}
+	tr := newTracking([]*Tracker{light, heavy, all})
+	vr.tracking = tr
+
+	down := errors.New("down")
+	for _, step := range []struct {
+		tracker *Tracker
+		err     error
+		want    uint8
+	}{
+		{light, down, 70},
+		{heavy, down, 1},
+		{light, nil, 20},
+		{all, down, 1},
+		{heavy, nil, 1},
+		{all, nil, 100},
+	} {
+		vr.tracked(tr, step.tracker, step.err)
+		if st := vr.Status(); st.Priority != step.want || vr.stateMachine.priority != step.want {
+			t.Errorf("after %s %v: priority %d, state machine %d; want %d",
+				step.tracker.Name, step.err, st.Priority, vr.stateMachine.priority, step.want)
+		}
+	}
+
+	// The configured priority still counts while a tracker fails
+	vr.tracked(tr, light, down)
+	vr.SetPriority(150)
+	if st := vr.Status(); st.Priority != 120 || vr.GetPriority() != 150 || st.ConfiguredPriority != 150 {
+		t.Errorf("priority %d, configured %d (status %d); want 120 and 150", st.Priority, vr.GetPriority(),
+			st.ConfiguredPriority)
+	}
+	st := vr.Status().Trackers
+	if len(st) != 3 || st[0].Healthy || st[0].Err != "down" || !st[1].Healthy {
+		t.Errorf("tracker status = %+v", st)
+	}
+
+	// A tracker that was replaced no longer counts
+	vr.tracked(newTracking(nil), heavy, down)
+	if st := vr.Status(); st.Priority != 120 {
+		t.Errorf("priority %d after a stale report, want 120", st.Priority)
+	}
+}
+
+func TestSetTrackerEnabled(t *testing.T) {
+	vr := newTestRouter(t)
+	light := &Tracker{Name: "light", Weight: 30, check: &fakeCheck{}}
+	tr := newTracking([]*Tracker{light})
+	vr.tracking = tr
+	vr.tracked(tr, light, errors.New("down"))
+
+	if err := vr.SetTrackerEnabled("light", false); err != nil {
+		t.Fatalf("SetTrackerEnabled: %v", err)
+	}
+	st := vr.Status()
+	if st.Priority != 100 || vr.stateMachine.priority != 100 || !st.Trackers[0].Disabled || st.Trackers[0].Healthy {
+		t.Errorf("disabled failing tracker: priority %d, state machine %d, status %+v; want 100, disabled and failing",
+			st.Priority, vr.stateMachine.priority, st.Trackers[0])
+	}
+	// Still disabled after a restart resets the trackers
+	tr.reset()
+	if !vr.Status().Trackers[0].Disabled {
+		t.Error("reset enabled the tracker")
+	}
+
+	vr.tracked(tr, light, errors.New("down"))
+	if err := vr.SetTrackerEnabled("light", true); err != nil {
+		t.Fatalf("SetTrackerEnabled: %v", err)
+	}
+	if st := vr.Status(); st.Priority != 70 || vr.stateMachine.priority != 70 {
+		t.Errorf("enabled failing tracker: priority %d, state machine %d; want 70", st.Priority, vr.stateMachine.priority)
+	}
+
+	if err := vr.SetTrackerEnabled("heavy", false); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("SetTrackerEnabled of an unknown tracker = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestTrackLoop(t *testing.T) {
+	vr := newTestRouter(t)
+	check := &fakeCheck{}
+	tr := newTracking([]*Tracker{{
+		Name: "fake", Interval: 10 * time.Millisecond, Timeout: 10 * time.Millisecond,
+		Fall: 3, Rise: 2, Weight: 50, check: check,
+	}})
+	vr.tracking = tr
+	vr.ctx, vr.cancel = context.WithCancel(context.Background())
+	defer vr.stopTrackers()
+	defer vr.cancel()
+
+	vr.mu.Lock()
+	vr.startTrackers()
+	vr.mu.Unlock()
+
+	waitPriority := func(want uint8) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for vr.Status().Priority != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("priority %d, want %d", vr.Status().Priority, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	check.fail.Store(true)
+	waitPriority(50)
+	check.fail.Store(false)
+	waitPriority(100)
+	if st := vr.Status().Trackers[0]; !st.Healthy || st.Err != "" {
+		t.Errorf("recovered tracker status = %+v", st)
+	}
+}
+
+func TestPriorityFunc(t *testing.T) {
+	vr := newTestRouter(t)
+	check := &fakeCheck{}
+	tr := newTracking([]*Tracker{{
+		Name: "fake", Interval: 10 * time.Millisecond, Timeout: 10 * time.Millisecond,
+		Fall: 1, Rise: 1, Weight: 50, check: check,
+	}})
+	vr.tracking = tr
+
+	// Half the penalty, and an external signal that drains the router
+	var draining atomic.Bool
+	vr.priorityFn = func(in PriorityInput) uint8 {
+		if draining.Load() {
+			return 0
+		}
+		if len(in.Trackers) != 1 {
+			t.Errorf("PriorityFunc got trackers %+v", in.Trackers)
+		}
+		return in.Priority - uint8(in.Penalty/2)
+	}
+	vr.priorityInterval = 10 * time.Millisecond
+	// Both loops apply priorities; an unstarted state machine would take
+	// them unserialized
+	vr.stateMachine = nil
+	vr.ctx, vr.cancel = context.WithCancel(context.Background())
+	defer vr.stopTrackers()
+	defer vr.cancel()
+
+	vr.mu.Lock()
+	vr.startTrackers()
+	vr.mu.Unlock()
+
+	waitPriority := func(want uint8) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for vr.Status().Priority != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("priority %d, want %d", vr.Status().Priority, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	check.fail.Store(true)
+	waitPriority(75)
+	check.fail.Store(false)
+	waitPriority(100)
+
+	// Only the cadence notices the signal; 0 is advertised as 1
+	draining.Store(true)
+	waitPriority(1)
+	draining.Store(false)
+	waitPriority(100)
+}
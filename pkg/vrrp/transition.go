@@ -0,0 +1,70 @@
+package vrrp
+
+import (
+	"time"
+)
+
+// TransitionCause says what made a router change state
+type TransitionCause string
+
+const (
+	// CauseStartup is leaving INIT when the router starts
+	CauseStartup TransitionCause = "startup"
+	// CauseResumed is entering MASTER at startup to take over from a
+	// process that was MASTER (Config.ResumeMaster)
+	CauseResumed TransitionCause = "resumed"
+	// CauseMasterDown is the master down timer firing: the master went
+	// silent
+	CauseMasterDown TransitionCause = "master_down_timer"
+	// CausePriorityZero is taking over from a master that left advertising
+	// priority 0
+	CausePriorityZero TransitionCause = "priority_zero_received"
+	// CausePreempt is a backup preempting a lower-priority master
+	CausePreempt TransitionCause = "preempt"
+	// CausePreemptedBy is a master giving way to a router advertising a
+	// higher priority, or the same priority from a higher address
+	CausePreemptedBy TransitionCause = "preempted_by"
+	// CauseSyncGroup is following another member of the sync group
+	CauseSyncGroup TransitionCause = "sync_group"
+	// CauseOperator is an administrative request, e.g. StepDown
+	CauseOperator TransitionCause = "operator"
+	// CauseShutdown is the router stopping
+	CauseShutdown TransitionCause = "shutdown"
+)
+
+// Transition describes one state change of a router and why it happened
+type Transition struct {
+	Time  time.Time
+	Old   State
+	New   State
+	Cause TransitionCause
+	// Detail adds to the cause, e.g. the sync group or the step-down hold
+	Detail string
+	// Priority is the router's own priority at the time
+	Priority uint8
+	// Peer is the last advertisement heard from another router: the master
+	// that went silent, left or preempted this one. It is zero if none was
+	// heard.
+	Peer PeerInfo
+}
+
+// SetTransitionCallback registers fn to be called after every state
+// transition with its cause. It must be called before Start. Like the state
+// change callback, fn runs in the middle of the transition and must not call
+// the router's methods.
+func (vr *VirtualRouter) SetTransitionCallback(fn func(Transition)) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.onTransitionCb = fn
+}
+
+// onTransition adds the peer to a transition reported by the state machine
+func (vr *VirtualRouter) onTransition(t Transition) {
+	if vr.onTransitionCb == nil {
+		return
+	}
+	vr.statsMu.Lock()
+	t.Peer = vr.peer.clone()
+	vr.statsMu.Unlock()
+	vr.onTransitionCb(t)
+}
@@ -0,0 +1,341 @@
+package vrrp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ConfigField names a setting UpdateConfig changes, as it is logged and
+// reported
+type ConfigField string
+
+const (
+	FieldPriority       ConfigField = "priority"
+	FieldAdvertInterval ConfigField = "advert interval"
+	FieldPreempt        ConfigField = "preempt"
+	FieldPreemptWindows ConfigField = "preempt windows"
+	FieldVirtualIPs     ConfigField = "virtual IPs"
+	FieldExcludedIPs    ConfigField = "excluded IPs"
+	FieldOnLinkCheck    ConfigField = "on-link check"
+	FieldIntervalCheck  ConfigField = "interval check"
+	FieldVersionPolicy  ConfigField = "version policy"
+	FieldAddressCheck   ConfigField = "address check"
+	FieldGARP           ConfigField = "gratuitous ARP"
+	FieldAllowedPeers   ConfigField = "allowed peers"
+	FieldAuthKeys       ConfigField = "auth keys"
+	FieldTrackers       ConfigField = "trackers"
+	FieldBackupOnly     ConfigField = "backup only"
+)
+
+// ConfigChange is one setting changed by UpdateConfig
+type ConfigChange struct {
+	Field ConfigField
+	Old   string
+	New   string
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s %s -> %s", c.Field, c.Old, c.New)
+}
+
+// UpdateConfig applies the differences between cfg and the router's settings
+// while it runs: a new priority, preemption setting or preemption windows
+// take effect with the next advertisement, a new interval restarts the
+// timers, new virtual or excluded IPs are reprogrammed if MASTER, a new
+// gratuitous ARP mode applies to the next virtual IP added, a new on-link,
+// interval or address check, version policy, allowed peers or
+// authentication keys apply to the next advertisement received (and the
+// policy and keys to the next sent), new trackers replace the old ones,
+// restoring the priority until one fails, and turning backup-only on makes
+// a MASTER step down. It returns the changes made, in that order; a change
+// of keys shows their IDs only.
+//
+// cfg is validated as a whole before anything is applied. The interface,
+// VRID, sync group, address backend, VIP conflict detection, dry-run and
+// observe settings identify the router and cannot be changed; Logger, Metrics, Hooks,
+// QueueLength, MaxAdvertRate, AuthReplayWindow, ResumeMaster, PriorityFunc and
+// PriorityInterval are ignored, and Version is only validated. NetInterface and Transport only name the
+// interface.
+func (vr *VirtualRouter) UpdateConfig(cfg *Config) ([]ConfigChange, error) {
+	iface, err := configInterface(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if iface != vr.iface || cfg.VRID != vr.vrid {
+		return nil, fmt.Errorf("%w: cannot change VRID %d on %s to VRID %d on %s",
+			ErrInvalidConfig, vr.vrid, vr.iface, cfg.VRID, iface)
+	}
+	if cfg.DryRun != vr.dryRun {
+		return nil, fmt.Errorf("%w: dry run cannot be changed while the router exists", ErrInvalidConfig)
+	}
+	if cfg.Observe != vr.observe {
+		return nil, fmt.Errorf("%w: observe cannot be changed while the router exists", ErrInvalidConfig)
+	}
+	if cfg.AddressBackend != vr.addresses {
+		return nil, fmt.Errorf("%w: address backend cannot be changed while the router exists", ErrInvalidConfig)
+	}
+	if cfg.DetectVIPConflicts != vr.arpCheck {
+		return nil, fmt.Errorf("%w: VIP conflict detection cannot be changed while the router exists",
+			ErrInvalidConfig)
+	}
+	var group *SyncGroup
+	if vr.syncMember != nil {
+		group = vr.syncMember.group
+	}
+	if cfg.SyncGroup != group {
+		return nil, fmt.Errorf("%w: sync group cannot be changed while the router exists", ErrInvalidConfig)
+	}
+	if cfg.BackupOnly && group != nil {
+		return nil, fmt.Errorf("%w: a backup-only router cannot be in a sync group", ErrInvalidConfig)
+	}
+
+	if cfg.Priority == 0 {
+		return nil, fmt.Errorf("%w: priority must be between 1 and 255", ErrInvalidConfig)
+	}
+	advInterval := cfg.AdvInterval
+	if advInterval == 0 {
+		advInterval = 1
+	}
+	if err := validateAdvInterval(advInterval); err != nil {
+		return nil, err
+	}
+	ips, err := parseVirtualIPs(cfg.VirtualIPs)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := parseExcludedIPs(cfg.ExcludedIPs, ips)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateOnLinkCheck(cfg.OnLinkCheck); err != nil {
+		return nil, err
+	}
+	onLinkCheck := cfg.OnLinkCheck
+	if onLinkCheck == "" {
+		onLinkCheck = OnLinkOff
+	}
+	if err := validateIntervalCheck(cfg.IntervalCheck); err != nil {
+		return nil, err
+	}
+	intervalCheck := cfg.IntervalCheck
+	if intervalCheck == "" {
+		intervalCheck = IntervalCount
+	}
+	if err := validateVersionPolicy(cfg.VersionPolicy); err != nil {
+		return nil, err
+	}
+	versionPolicy := cfg.VersionPolicy
+	if versionPolicy == "" {
+		versionPolicy = VersionPrefer
+	}
+	if err := validateVersion(cfg.Version); err != nil {
+		return nil, err
+	}
+	if err := validateAddressCheck(cfg.AddressCheck); err != nil {
+		return nil, err
+	}
+	addressCheck := cfg.AddressCheck
+	if addressCheck == "" {
+		addressCheck = AddressWarn
+	}
+	if err := validateGARPMode(cfg.GARP); err != nil {
+		return nil, err
+	}
+	garp := cfg.GARP
+	if garp == "" {
+		garp = GARPBoth
+	}
+	preemptWindows, err := ParsePreemptWindows(cfg.PreemptWindows)
+	if err != nil {
+		return nil, err
+	}
+	allowedPeers, err := ParseAllowedPeers(cfg.AllowedPeers)
+	if err != nil {
+		return nil, err
+	}
+	authKeys, err := ParseAuthKeys(cfg.AuthKeys)
+	if err != nil {
+		return nil, err
+	}
+	trackers, err := ParseTrackers(cfg.Trackers)
+	if err != nil {
+		return nil, err
+	}
+
+	vr.mu.RLock()
+	oldPriority, oldInterval, oldPreempt, oldIPs := vr.priority, vr.advInterval, vr.preempt, vr.ips
+	oldExcluded, oldWindows := vr.excluded, vr.preemptWindows
+	vr.mu.RUnlock()
+
+	var changes []ConfigChange
+
+	if cfg.Priority != oldPriority {
+		if err := vr.SetPriority(cfg.Priority); err != nil {
+			return changes, err
+		}
+		changes = append(changes, ConfigChange{FieldPriority, fmt.Sprint(oldPriority), fmt.Sprint(cfg.Priority)})
+	}
+
+	if advInterval != oldInterval {
+		if err := vr.SetAdvertInterval(advInterval); err != nil {
+			return changes, err
+		}
+		changes = append(changes, ConfigChange{FieldAdvertInterval,
+			fmt.Sprintf("%ds", oldInterval), fmt.Sprintf("%ds", advInterval)})
+	}
+
+	if cfg.Preempt != oldPreempt {
+		vr.SetPreempt(cfg.Preempt)
+		changes = append(changes, ConfigChange{FieldPreempt, fmt.Sprint(oldPreempt), fmt.Sprint(cfg.Preempt)})
+	}
+
+	if !slices.Equal(preemptWindows, oldWindows) {
+		vr.setPreemptWindows(preemptWindows)
+		changes = append(changes, ConfigChange{FieldPreemptWindows,
+			formatPreemptWindows(oldWindows), formatPreemptWindows(preemptWindows)})
+	}
+
+	if !sameIPs(ips, oldIPs) || !sameIPs(excluded, oldExcluded) {
+		vr.setAddresses(ips, excluded)
+	}
+	if !sameIPs(ips, oldIPs) {
+		changes = append(changes, ConfigChange{FieldVirtualIPs, formatIPs(oldIPs), formatIPs(ips)})
+	}
+	if !sameIPs(excluded, oldExcluded) {
+		changes = append(changes, ConfigChange{FieldExcludedIPs, formatIPs(oldExcluded), formatIPs(excluded)})
+	}
+
+	if oldCheck := vr.OnLinkCheck(); onLinkCheck != oldCheck {
+		_ = vr.SetOnLinkCheck(onLinkCheck)
+		changes = append(changes, ConfigChange{FieldOnLinkCheck, string(oldCheck), string(onLinkCheck)})
+	}
+
+	if oldCheck := vr.IntervalCheck(); intervalCheck != oldCheck {
+		_ = vr.SetIntervalCheck(intervalCheck)
+		changes = append(changes, ConfigChange{FieldIntervalCheck, string(oldCheck), string(intervalCheck)})
+	}
+
+	if oldPolicy := vr.VersionPolicy(); versionPolicy != oldPolicy {
+		_ = vr.SetVersionPolicy(versionPolicy)
+		changes = append(changes, ConfigChange{FieldVersionPolicy, string(oldPolicy), string(versionPolicy)})
+	}
+
+	if oldCheck := vr.AddressCheck(); addressCheck != oldCheck {
+		_ = vr.SetAddressCheck(addressCheck)
+		changes = append(changes, ConfigChange{FieldAddressCheck, string(oldCheck), string(addressCheck)})
+	}
+
+	if oldMode := vr.GARP(); garp != oldMode {
+		_ = vr.SetGARP(garp)
+		changes = append(changes, ConfigChange{FieldGARP, string(oldMode), string(garp)})
+	}
+
+	if oldPeers := vr.AllowedPeers(); !slices.Equal(allowedPeers, oldPeers) {
+		vr.setAllowedPeers(allowedPeers)
+		changes = append(changes, ConfigChange{FieldAllowedPeers, formatPrefixes(oldPeers), formatPrefixes(allowedPeers)})
+	}
+
+	if old := vr.auth.Load(); !sameAuthKeys(authKeys, old) {
+		a := newAdvertAuth(authKeys)
+		vr.auth.Store(a)
+		changes = append(changes, ConfigChange{FieldAuthKeys, formatKeyIDs(old.keyIDs()), formatKeyIDs(a.keyIDs())})
+	}
+
+	vr.mu.RLock()
+	oldTrackers, oldBackupOnly := vr.tracking.trackers, vr.backupOnly
+	vr.mu.RUnlock()
+	if !sameTrackers(trackers, oldTrackers) {
+		if err := vr.SetTrackers(cfg.Trackers); err != nil {
+			return changes, err
+		}
+		changes = append(changes, ConfigChange{FieldTrackers, formatTrackers(oldTrackers), formatTrackers(trackers)})
+	}
+
+	if cfg.BackupOnly != oldBackupOnly {
+		vr.SetBackupOnly(cfg.BackupOnly)
+		changes = append(changes, ConfigChange{FieldBackupOnly, fmt.Sprint(oldBackupOnly), fmt.Sprint(cfg.BackupOnly)})
+	}
+
+	return changes, nil
+}
+
+// formatPreemptWindows formats preemption windows; none means any time
+func formatPreemptWindows(windows []PreemptWindow) string {
+	if len(windows) == 0 {
+		return "any time"
+	}
+	s := make([]string, len(windows))
+	for i, w := range windows {
+		s[i] = w.String()
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
+
+func sameTrackers(a, b []*Tracker) bool {
+	return slices.EqualFunc(a, b, func(x, y *Tracker) bool { return x.spec == y.spec })
+}
+
+// formatTrackers formats trackers by name
+func formatTrackers(trackers []*Tracker) string {
+	if len(trackers) == 0 {
+		return "none"
+	}
+	return "[" + strings.Join(trackerNames(trackers), ", ") + "]"
+}
+
+func sameIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameAuthKeys(keys []AuthKey, a *advertAuth) bool {
+	var old []AuthKey
+	if a != nil {
+		old = a.keys
+	}
+	return slices.EqualFunc(keys, old, func(x, y AuthKey) bool {
+		return x.ID == y.ID && string(x.Key) == string(y.Key)
+	})
+}
+
+// formatKeyIDs formats authentication key IDs; none means off
+func formatKeyIDs(ids []uint32) string {
+	if len(ids) == 0 {
+		return "off"
+	}
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprint(id)
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
+
+// formatPrefixes formats allowed peers; none means any
+func formatPrefixes(prefixes []netip.Prefix) string {
+	if len(prefixes) == 0 {
+		return "any"
+	}
+	s := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		s[i] = p.String()
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
+
+func formatIPs(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
@@ -0,0 +1,114 @@
+package vrrp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	vr := newTestRouter(t)
+
+	cfg := &Config{
+		VRID:           10,
+		Priority:       150,
+		Interface:      "test0",
+		VirtualIPs:     []string{"192.168.1.100", "192.168.1.101"},
+		ExcludedIPs:    []string{"192.168.1.200"},
+		AdvInterval:    1,
+		Preempt:        true,
+		PreemptWindows: []string{"Mon-Fri 02:00-04:00"},
+		OnLinkCheck:    OnLinkEnforce,
+		IntervalCheck:  IntervalEnforce,
+		VersionPolicy:  VersionTranslate,
+		AddressCheck:   AddressAdopt,
+		GARP:           GARPReply,
+		AllowedPeers:   []string{"192.168.1.0/29"},
+		AuthKeys:       []string{testKey2, testKey1},
+		Trackers:       []string{"dns:name=resolver,query=example.com,server=127.0.0.1"},
+		BackupOnly:     true,
+	}
+	changes, err := vr.UpdateConfig(cfg)
+	if err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"priority 100 -> 150",
+		"preempt false -> true",
+		"preempt windows any time -> [Mon,Tue,Wed,Thu,Fri 02:00-04:00]",
+		"virtual IPs [192.168.1.100] -> [192.168.1.100, 192.168.1.101]",
+		"excluded IPs [] -> [192.168.1.200]",
+		"on-link check off -> enforce",
+		"interval check count -> enforce",
+		"version policy prefer -> translate",
+		"address check warn -> adopt",
+		"gratuitous ARP both -> reply",
+		"allowed peers any -> [192.168.1.0/29]",
+		"auth keys off -> [2, 1]",
+		"trackers none -> [resolver]",
+		"backup only false -> true",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("UpdateConfig changes = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	if vr.GetPriority() != 150 || vr.stateMachine.priority != 150 {
+		t.Errorf("priority not applied: router %d, state machine %d", vr.GetPriority(), vr.stateMachine.priority)
+	}
+	if !vr.BackupOnly() || !vr.stateMachine.backupOnly {
+		t.Error("backup-only not applied")
+	}
+
+	// Applying the same configuration again changes nothing
+	if changes, err := vr.UpdateConfig(cfg); err != nil || len(changes) != 0 {
+		t.Errorf("second UpdateConfig = %v, %v; want no changes", changes, err)
+	}
+}
+
+func TestUpdateConfigRejects(t *testing.T) {
+	vr := newTestRouter(t)
+
+	valid := func(edit func(*Config)) *Config {
+		cfg := &Config{VRID: 10, Priority: 100, Interface: "test0", VirtualIPs: []string{"192.168.1.100"}}
+		edit(cfg)
+		return cfg
+	}
+
+	for name, cfg := range map[string]*Config{
+		"another VRID":         valid(func(c *Config) { c.VRID = 11 }),
+		"dry run":              valid(func(c *Config) { c.DryRun = true }),
+		"a sync group":         valid(func(c *Config) { c.SyncGroup = NewSyncGroup("g") }),
+		"a bad VIP":            valid(func(c *Config) { c.Priority = 150; c.VirtualIPs = append(c.VirtualIPs, "bogus") }),
+		"an excluded VIP":      valid(func(c *Config) { c.Priority = 150; c.ExcludedIPs = c.VirtualIPs }),
+		"a zero priority":      valid(func(c *Config) { c.Priority = 0 }),
+		"a long interval":      valid(func(c *Config) { c.Priority = 150; c.AdvInterval = 300 }),
+		"a bad check":          valid(func(c *Config) { c.Priority = 150; c.OnLinkCheck = "strict" }),
+		"a bad interval check": valid(func(c *Config) { c.Priority = 150; c.IntervalCheck = "strict" }),
+		"a bad version policy": valid(func(c *Config) { c.Priority = 150; c.VersionPolicy = "v3" }),
+		"a version":            valid(func(c *Config) { c.Priority = 150; c.Version = VRRPv3 }),
+		"a bad address check":  valid(func(c *Config) { c.Priority = 150; c.AddressCheck = "adopted" }),
+		"a bad GARP mode":      valid(func(c *Config) { c.Priority = 150; c.GARP = "announce" }),
+		"a bad window":         valid(func(c *Config) { c.Priority = 150; c.PreemptWindows = []string{"02:00"} }),
+		"a bad peer":           valid(func(c *Config) { c.Priority = 150; c.AllowedPeers = []string{"bogus"} }),
+		"a short key":          valid(func(c *Config) { c.Priority = 150; c.AuthKeys = []string{"1:short"} }),
+		"a bad tracker":        valid(func(c *Config) { c.Priority = 150; c.Trackers = []string{"dns:query=a"} }),
+	} {
+		if _, err := vr.UpdateConfig(cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("UpdateConfig with %s = %v, want ErrInvalidConfig", name, err)
+		}
+	}
+
+	// Nothing was applied from the invalid configurations
+	if vr.GetPriority() != 100 {
+		t.Errorf("priority = %d after rejected updates, want 100", vr.GetPriority())
+	}
+}
@@ -0,0 +1,155 @@
+package vrrp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// VersionPolicy selects what a router does with advertisements of the VRRP
+// version it does not speak, VRRPv3, which appear on the link while the
+// routers of a VRID migrate from one version to the other. Either way they
+// are counted in Stats.ForeignVersionAdverts and each peer sending them is
+// logged once.
+type VersionPolicy string
+
+const (
+	// VersionPrefer accepts a VRRPv3 advertisement unless its source also
+	// advertises VRRPv2, as a VRRPv3 router in VRRPv2 compatibility mode
+	// does (RFC 5798 section 8.4); those duplicates are dropped as
+	// DropForeignVersion. The default.
+	VersionPrefer VersionPolicy = "prefer"
+	// VersionStrict drops every VRRPv3 advertisement as DropForeignVersion
+	VersionStrict VersionPolicy = "strict"
+	// VersionTranslate receives as VersionPrefer does and also sends each
+	// advertisement translated to VRRPv3, so routers that only speak
+	// VRRPv3 hear the router during a migration
+	VersionTranslate VersionPolicy = "translate"
+)
+
+// VersionPolicies lists the valid values of Config.VersionPolicy
+var VersionPolicies = []VersionPolicy{VersionPrefer, VersionStrict, VersionTranslate}
+
+func validateVersionPolicy(p VersionPolicy) error {
+	if p == "" || slices.Contains(VersionPolicies, p) {
+		return nil
+	}
+	return fmt.Errorf("%w: unknown version policy %q", ErrInvalidConfig, p)
+}
+
+// validateVersion checks Config.Version: the router advertises VRRPv2,
+// and VRRPv3 only as a translation
+func validateVersion(version uint8) error {
+	if version == 0 || version == VRRPv2 {
+		return nil
+	}
+	return fmt.Errorf("%w: version %d is not supported: routers advertise VRRPv2, "+
+		"and VRRPv3 too with the translate version policy", ErrInvalidConfig, version)
+}
+
+// nativeWindow is how many of the router's advertisement intervals a source
+// counts as advertising VRRPv2 after its last VRRPv2 advertisement: the
+// master down interval without the skew time
+const nativeWindow = 3
+
+// versionWatch tracks which sources advertise which version, for
+// VersionPrefer and for logging each source of VRRPv3 advertisements once
+type versionWatch struct {
+	mu sync.Mutex
+	// native is when each source last advertised VRRPv2
+	native map[netip.Addr]time.Time
+	// logged holds the sources of VRRPv3 advertisements already logged
+	logged peerWarnings[struct{}]
+}
+
+// sawNative records a VRRPv2 advertisement from src at now
+func (w *versionWatch) sawNative(src netip.Addr, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.native == nil {
+		w.native = make(map[netip.Addr]time.Time)
+	}
+	if _, ok := w.native[src]; !ok && len(w.native) >= MaxPeers {
+		return
+	}
+	w.native[src] = now
+}
+
+// sawForeign records a VRRPv3 advertisement from src at now. It reports
+// whether src advertised VRRPv2 within window, and whether it is the first
+// VRRPv3 advertisement from src, to be logged.
+func (w *versionWatch) sawForeign(src netip.Addr, now time.Time, window time.Duration) (native, first bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if last, ok := w.native[src]; ok && now.Sub(last) <= window {
+		native = true
+	}
+	return native, w.logged.first(src, struct{}{})
+}
+
+// SetVersionPolicy replaces Config.VersionPolicy, taking effect with the
+// next advertisement received and sent
+func (vr *VirtualRouter) SetVersionPolicy(p VersionPolicy) error {
+	if err := validateVersionPolicy(p); err != nil {
+		return err
+	}
+	if p == "" {
+		p = VersionPrefer
+	}
+	vr.versionPolicy.Store(p)
+	return nil
+}
+
+// VersionPolicy returns what the router does with VRRPv3 advertisements
+func (vr *VirtualRouter) VersionPolicy() VersionPolicy {
+	p, _ := vr.versionPolicy.Load().(VersionPolicy)
+	return p
+}
+
+// dropForeignVersion checks the version of an advertisement for the router
+// against the policy, counting and logging a VRRPv3 one. It reports whether
+// the advertisement is to be dropped.
+func (vr *VirtualRouter) dropForeignVersion(pkt *Packet, src net.IP, interval time.Duration) bool {
+	key := peerKey(src)
+	now := time.Now()
+	if pkt.Version == VRRPv2 {
+		vr.versions.sawNative(key, now)
+		return false
+	}
+
+	vr.foreignVersion.Add(1)
+	policy := vr.VersionPolicy()
+	native, first := vr.versions.sawForeign(key, now, nativeWindow*interval)
+	drop := policy == VersionStrict || native
+	if first {
+		vr.logger.Warn("Peer advertises another VRRP version", "src", src, "version", pkt.Version,
+			"policy", string(policy), "also_v2", native, "dropped", drop)
+	}
+	return drop
+}
+
+// marshalV3 marshals pkt, a VRRPv2 advertisement, translated to VRRPv3
+// from src: its interval in centiseconds, without authentication data, and
+// with the checksum over the IPv4 pseudo-header RFC 5798 section 5.2.8 adds
+func marshalV3(pkt *Packet, src net.IP) ([]byte, error) {
+	v3 := &Packet{
+		Version:      VRRPv3,
+		Type:         pkt.Type,
+		VRID:         pkt.VRID,
+		Priority:     pkt.Priority,
+		CountIPAddrs: pkt.CountIPAddrs,
+		AdvInterval:  uint16(pkt.Interval() / (10 * time.Millisecond)),
+		IPAddresses:  pkt.IPAddresses,
+	}
+	data, err := v3.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	// Marshal put a checksum without the pseudo-header in data[6:8]
+	binary.BigEndian.PutUint16(data[6:8], pseudoChecksum(src, multicastGroup, data))
+	return data, nil
+}
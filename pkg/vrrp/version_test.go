@@ -0,0 +1,130 @@
+package vrrp
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestVersionWatch(t *testing.T) {
+	var w versionWatch
+	a := netip.MustParseAddr("10.0.0.2")
+	now := time.Now()
+
+	if native, first := w.sawForeign(a, now, 3*time.Second); native || !first {
+		t.Errorf("first VRRPv3 = %v, %v, want not native and first", native, first)
+	}
+	w.sawNative(a, now)
+	if native, first := w.sawForeign(a, now.Add(time.Second), 3*time.Second); !native || first {
+		t.Errorf("VRRPv3 after VRRPv2 = %v, %v, want native and not first", native, first)
+	}
+	// A source that stopped advertising VRRPv2 is heard as VRRPv3 again
+	if native, _ := w.sawForeign(a, now.Add(4*time.Second), 3*time.Second); native {
+		t.Error("VRRPv3 long after VRRPv2 is native")
+	}
+
+	// Past MaxPeers sources nothing more is logged or tracked
+	for i := range MaxPeers {
+		src := netip.AddrFrom4([4]byte{10, 0, 1, byte(i)})
+		w.sawNative(src, now)
+		w.sawForeign(src, now, time.Second)
+	}
+	extra := netip.MustParseAddr("10.0.2.1")
+	w.sawNative(extra, now)
+	if native, first := w.sawForeign(extra, now, time.Second); native || first || len(w.native) != MaxPeers {
+		t.Errorf("source past MaxPeers = %v, %v with %d tracked, want neither and %d",
+			native, first, len(w.native), MaxPeers)
+	}
+}
+
+func TestVersionPolicy(t *testing.T) {
+	vr := newTestRouter(t)
+	var logs bytes.Buffer
+	vr.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	ownIP := net.ParseIP("10.0.0.1")
+	both := &ipv4.Header{Src: net.ParseIP("10.0.0.2"), TTL: 255}
+	v3only := &ipv4.Header{Src: net.ParseIP("10.0.0.3"), TTL: 255}
+	marshal := func(version uint8, interval uint16) []byte {
+		p := NewPacket(version, 10, 100, []net.IP{net.ParseIP("192.168.1.100").To4()})
+		p.AdvInterval = interval
+		data, err := p.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return data
+	}
+
+	// By default the VRRPv3 copies of a router advertising both are
+	// dropped, and a router advertising VRRPv3 only is heard
+	for range 2 {
+		vr.handleAdvert(both, marshal(VRRPv2, 1), ownIP)
+		vr.handleAdvert(both, marshal(VRRPv3, 100), ownIP)
+		vr.handleAdvert(v3only, marshal(VRRPv3, 100), ownIP)
+	}
+	s := vr.GetStats()
+	if s.AdvertsReceived != 4 || s.ForeignVersionAdverts != 4 || s.Drops[DropForeignVersion] != 2 {
+		t.Errorf("received %d, %d VRRPv3, %d dropped; want 4, 4 and 2",
+			s.AdvertsReceived, s.ForeignVersionAdverts, s.Drops[DropForeignVersion])
+	}
+	if n := strings.Count(logs.String(), "another VRRP version"); n != 2 {
+		t.Errorf("logged %d times, want once per source:\n%s", n, logs.String())
+	}
+
+	if err := vr.SetVersionPolicy(VersionStrict); err != nil {
+		t.Fatalf("SetVersionPolicy: %v", err)
+	}
+	vr.ResetStats()
+	vr.handleAdvert(v3only, marshal(VRRPv3, 100), ownIP)
+	vr.handleAdvert(both, marshal(VRRPv2, 1), ownIP)
+	if s := vr.GetStats(); s.AdvertsReceived != 1 || s.Drops[DropForeignVersion] != 1 {
+		t.Errorf("strict: received %d, %d dropped; want 1 and 1", s.AdvertsReceived, s.Drops[DropForeignVersion])
+	}
+
+	if err := vr.SetVersionPolicy("v3"); err == nil {
+		t.Error(`SetVersionPolicy("v3") succeeded`)
+	}
+	if _, err := NewVirtualRouter(&Config{VRID: 10, Priority: 100, Interface: "test0",
+		VirtualIPs: []string{"192.168.1.100"}, Version: VRRPv3}); err == nil {
+		t.Error("NewVirtualRouter advertising VRRPv3 succeeded")
+	}
+}
+
+func TestMarshalV3(t *testing.T) {
+	src := net.ParseIP("192.168.1.1")
+	pkt := NewPacket(VRRPv2, 10, 100, []net.IP{net.ParseIP("192.168.1.100").To4()})
+	pkt.AdvInterval = 3
+
+	data, err := marshalV3(pkt, src)
+	if err != nil {
+		t.Fatalf("marshalV3: %v", err)
+	}
+	var got Packet
+	if err := got.Unmarshal(data); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.Version != VRRPv3 || got.VRID != 10 || got.Priority != 100 || got.Interval() != 3*time.Second ||
+		len(got.IPAddresses) != 1 || !got.IPAddresses[0].Equal(pkt.IPAddresses[0]) {
+		t.Errorf("translated to %+v", got)
+	}
+
+	// The checksum over the pseudo-header and the message, checksum
+	// included, is all ones
+	msg := append([]byte{192, 168, 1, 1, 224, 0, 0, 18, 0, VRRPProtocol, 0, byte(len(data))}, data...)
+	var sum uint32
+	for i := 0; i < len(msg); i += 2 {
+		sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+	}
+	for sum>>16 > 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	if sum != 0xFFFF {
+		t.Errorf("checksum %#04x does not verify: sum %#04x", got.Checksum, sum)
+	}
+}
@@ -0,0 +1,86 @@
+package vrrp
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StateChange is a state transition delivered by WatchState
+type StateChange struct {
+	Old  State
+	New  State
+	Time time.Time
+}
+
+// watchBuffer is how many transitions a WatchState receiver may fall behind
+// before it misses some
+const watchBuffer = 16
+
+// WaitForState blocks until the router is in state want. It returns an error
+// if ctx ends first, or if the router is not running or stops without
+// reaching want; waiting for Init succeeds once a router has stopped.
+func (vr *VirtualRouter) WaitForState(ctx context.Context, want State) error {
+	for {
+		// Take the channel before reading the state, so a transition in
+		// between closes it and is not missed
+		vr.statsMu.Lock()
+		changed := vr.stateChanged
+		vr.statsMu.Unlock()
+
+		if vr.GetState() == want {
+			return nil
+		}
+
+		vr.mu.RLock()
+		running, stopped := vr.running, vr.stopped
+		vr.mu.RUnlock()
+		if !running {
+			return ErrNotRunning
+		}
+
+		select {
+		case <-changed:
+		case <-stopped:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", want, ctx.Err())
+		}
+	}
+}
+
+// WatchState returns a channel receiving every state transition until ctx is
+// canceled, when it is closed. It keeps working across Stop and Start. Like
+// the state change callback's other consumers, a receiver more than a few
+// transitions behind misses transitions rather than blocking the router.
+func (vr *VirtualRouter) WatchState(ctx context.Context) <-chan StateChange {
+	ch := make(chan StateChange, watchBuffer)
+
+	vr.statsMu.Lock()
+	vr.watchers[ch] = struct{}{}
+	vr.statsMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		vr.statsMu.Lock()
+		defer vr.statsMu.Unlock()
+		delete(vr.watchers, ch)
+		close(ch)
+	}()
+
+	return ch
+}
+
+// notifyWatchers wakes WaitForState callers and delivers the transition to
+// WatchState receivers. vr.statsMu must be held.
+func (vr *VirtualRouter) notifyWatchers(change StateChange) {
+	close(vr.stateChanged)
+	vr.stateChanged = make(chan struct{})
+
+	for ch := range vr.watchers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
@@ -0,0 +1,487 @@
+// Package vrrptest runs VRRP elections in-process for tests. A Network wires
+// state machines together through an in-memory transport in place of raw
+// sockets and netlink, with helpers to kill, revive and partition routers
+// and to assert on who ends up MASTER. Options.Chaos makes the transport
+// lossy. Tests written with it need neither root nor network namespaces.
+//
+// Timers run on the real clock; a short advertisement interval (20ms by
+// default) keeps an election to a fraction of a second.
+package vrrptest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// Options configure a Network
+type Options struct {
+	// Interval is the advertisement interval of every router (default 20ms)
+	Interval time.Duration
+
+	// Timeout bounds how long the Expect helpers wait (default 5s)
+	Timeout time.Duration
+
+	// Logger receives the state machines' logs (default: discarded)
+	Logger *slog.Logger
+
+	// Chaos injects faults into what each router receives, independently
+	// of the others (default: none). Corrupted messages that fail the
+	// checksum are dropped, as a router would. A Seed is varied per router,
+	// so they do not lose the same messages.
+	Chaos vrrp.Chaos
+}
+
+// Router configures a router added to a Network
+type Router struct {
+	Name string
+	// VRID defaults to 1
+	VRID uint8
+	// Priority defaults to 100
+	Priority uint8
+	// VirtualIPs default to 192.0.2.1
+	VirtualIPs []string
+	// NoPreempt disables preemption
+	NoPreempt bool
+	// SourceIP breaks priority ties; it defaults to 10.0.0.<n> for the
+	// n-th router added
+	SourceIP string
+}
+
+// Network is a shared segment that carries the advertisements of its
+// routers. Routers of different VRIDs may share it. It is safe for
+// concurrent use; every router is stopped when the test ends.
+type Network struct {
+	tb       testing.TB
+	interval time.Duration
+	timeout  time.Duration
+	logger   *slog.Logger
+	chaos    vrrp.Chaos
+
+	mu    sync.Mutex
+	nodes []*Node
+	// group assigns nodes to partitions; nodes talk only within theirs.
+	// Nodes without an entry are in group 0.
+	group map[*Node]int
+	wg    sync.WaitGroup
+}
+
+// NewNetwork creates an empty network. Its routers are stopped by
+// tb.Cleanup.
+func NewNetwork(tb testing.TB, opts Options) *Network {
+	tb.Helper()
+	if opts.Interval <= 0 {
+		opts.Interval = 20 * time.Millisecond
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	n := &Network{
+		tb:       tb,
+		interval: opts.Interval,
+		timeout:  opts.Timeout,
+		logger:   opts.Logger,
+		chaos:    opts.Chaos,
+		group:    make(map[*Node]int),
+	}
+	tb.Cleanup(n.close)
+	return n
+}
+
+// Node is a router on a Network
+type Node struct {
+	net      *Network
+	name     string
+	vrid     uint8
+	vips     []net.IP
+	preempt  bool
+	sourceIP net.IP
+	// faults is nil unless Options.Chaos is set
+	faults *vrrp.FaultInjector
+
+	mu          sync.Mutex
+	priority    uint8
+	sm          *vrrp.StateMachine
+	cancel      context.CancelFunc
+	up          bool
+	held        []net.IP
+	transitions []vrrp.Transition
+}
+
+// Add starts a router on the network
+func (n *Network) Add(r Router) *Node {
+	n.tb.Helper()
+	if r.VRID == 0 {
+		r.VRID = 1
+	}
+	if r.Priority == 0 {
+		r.Priority = 100
+	}
+	if len(r.VirtualIPs) == 0 {
+		r.VirtualIPs = []string{"192.0.2.1"}
+	}
+
+	n.mu.Lock()
+	if r.SourceIP == "" {
+		r.SourceIP = fmt.Sprintf("10.0.0.%d", len(n.nodes)+1)
+	}
+	n.mu.Unlock()
+
+	node := &Node{
+		net:      n,
+		name:     r.Name,
+		vrid:     r.VRID,
+		preempt:  !r.NoPreempt,
+		priority: r.Priority,
+	}
+	for _, s := range r.VirtualIPs {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			n.tb.Fatalf("router %s: invalid virtual IP %q", r.Name, s)
+		}
+		node.vips = append(node.vips, ip)
+	}
+	if node.sourceIP = net.ParseIP(r.SourceIP).To4(); node.sourceIP == nil {
+		n.tb.Fatalf("router %s: invalid source IP %q", r.Name, r.SourceIP)
+	}
+
+	n.mu.Lock()
+	if n.chaos.Enabled() {
+		chaos := n.chaos
+		if chaos.Seed != 0 {
+			chaos.Seed += uint64(len(n.nodes))
+		}
+		node.faults = vrrp.NewFaultInjector(chaos, node.receive)
+	}
+	n.nodes = append(n.nodes, node)
+	n.mu.Unlock()
+
+	node.start()
+	return node
+}
+
+func (n *Network) close() {
+	n.mu.Lock()
+	nodes := slices.Clone(n.nodes)
+	n.mu.Unlock()
+	for _, node := range nodes {
+		node.Kill()
+		if node.faults != nil {
+			node.faults.Stop()
+		}
+	}
+	n.wg.Wait()
+}
+
+// Partition splits the network: routers in different groups stop hearing
+// each other. Routers not listed form one more group together.
+func (n *Network) Partition(groups ...[]*Node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.group = make(map[*Node]int)
+	for i, g := range groups {
+		for _, node := range g {
+			n.group[node] = i + 1
+		}
+	}
+}
+
+// Heal undoes Partition
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.group = make(map[*Node]int)
+}
+
+// deliver passes pkt from one router to every other running router that can
+// hear it. Delivery does not block: a router that falls behind drops it.
+func (n *Network) deliver(from *Node, pkt *vrrp.Packet) {
+	var header *ipv4.Header
+	var data []byte
+	if n.chaos.Enabled() {
+		var err error
+		if data, err = pkt.Marshal(); err != nil {
+			n.tb.Errorf("router %s: %v", from.name, err)
+			return
+		}
+		header = &ipv4.Header{TTL: 255, Protocol: vrrp.VRRPProtocol, Src: from.sourceIP}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, to := range n.nodes {
+		if to == from || n.group[to] != n.group[from] {
+			continue
+		}
+		if to.faults != nil {
+			to.faults.Inject(header, data)
+			continue
+		}
+		to.mu.Lock()
+		if to.up {
+			to.sm.ProcessPacket(pkt)
+		}
+		to.mu.Unlock()
+	}
+}
+
+// receive hands a message that came through the fault injector to the state
+// machine, unless corruption broke it
+func (node *Node) receive(_ *ipv4.Header, payload []byte) {
+	pkt := &vrrp.Packet{}
+	if err := pkt.Unmarshal(payload); err != nil {
+		return
+	}
+	if valid, ok := pkt.VerifyChecksum(payload); ok && !valid {
+		return
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.up {
+		node.sm.ProcessPacket(pkt)
+	}
+}
+
+// start boots the node's state machine
+func (node *Node) start() {
+	n := node.net
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	sm := vrrp.NewStateMachine(node.vrid, node.priority, node.vips, &net.Interface{Name: "test-" + node.name})
+	sm.SetLogger(n.logger.With("router", node.name))
+	sm.SetAddressManager((*nodeAddresses)(node))
+	sm.SetSourceIP(node.sourceIP)
+	sm.SetAdvertisementInterval(n.interval)
+	sm.SetPreempt(node.preempt)
+	sm.SetTransitionCallback(func(t vrrp.Transition) {
+		node.mu.Lock()
+		node.transitions = append(node.transitions, t)
+		node.mu.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	node.sm, node.cancel, node.up = sm, cancel, true
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pkt := <-sm.GetSendChannel():
+				n.deliver(node, pkt)
+			}
+		}
+	}()
+
+	if err := sm.Start(ctx); err != nil {
+		n.tb.Errorf("router %s: %v", node.name, err)
+	}
+}
+
+// nodeAddresses records the virtual IPs a node holds
+type nodeAddresses Node
+
+func (a *nodeAddresses) AddIP(ip net.IP) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !slices.ContainsFunc(a.held, ip.Equal) {
+		a.held = append(a.held, ip)
+	}
+	return nil
+}
+
+func (a *nodeAddresses) DelIP(ip net.IP) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.held = slices.DeleteFunc(a.held, ip.Equal)
+	return nil
+}
+
+// Name returns the router's name
+func (node *Node) Name() string {
+	return node.name
+}
+
+func (node *Node) String() string {
+	return node.name
+}
+
+// State returns the router's state, INIT while it is killed
+func (node *Node) State() vrrp.State {
+	node.mu.Lock()
+	sm, up := node.sm, node.up
+	node.mu.Unlock()
+	if !up {
+		return vrrp.Init
+	}
+	return sm.GetState()
+}
+
+// VirtualIPs returns the virtual IPs the router currently holds
+func (node *Node) VirtualIPs() []net.IP {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return slices.Clone(node.held)
+}
+
+// Transitions returns every transition the router has made, with its cause
+func (node *Node) Transitions() []vrrp.Transition {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return slices.Clone(node.transitions)
+}
+
+// Kill stops the router: a MASTER releases its virtual IPs and falls
+// silent, so a backup takes over once the master down timer fires. Partition
+// instead cuts a router off while it keeps running.
+func (node *Node) Kill() {
+	node.mu.Lock()
+	sm, cancel, up := node.sm, node.cancel, node.up
+	node.mu.Unlock()
+	if !up {
+		return
+	}
+
+	// Stop waits for the transition to INIT, whose callback takes node.mu
+	sm.Stop()
+	cancel()
+	node.mu.Lock()
+	node.up = false
+	node.mu.Unlock()
+}
+
+// Revive starts a killed router again, with its last priority
+func (node *Node) Revive() {
+	node.mu.Lock()
+	up := node.up
+	node.mu.Unlock()
+	if !up {
+		node.start()
+	}
+}
+
+// SetPriority changes the router's priority
+func (node *Node) SetPriority(priority uint8) {
+	node.mu.Lock()
+	node.priority = priority
+	sm, up := node.sm, node.up
+	node.mu.Unlock()
+	if up {
+		sm.SetPriority(priority)
+	}
+}
+
+// StepDown makes a MASTER give way as `vrrp failover` does
+func (node *Node) StepDown(hold time.Duration) error {
+	node.mu.Lock()
+	sm, up := node.sm, node.up
+	node.mu.Unlock()
+	if !up {
+		return fmt.Errorf("router %s is killed", node.name)
+	}
+	return sm.StepDown(hold)
+}
+
+// ExpectMasters waits until exactly the given routers are MASTER, every
+// other running router is BACKUP and each MASTER holds its virtual IPs, and
+// the election has stayed that way for a master down interval. It fails the
+// test if that does not happen within the timeout.
+func (n *Network) ExpectMasters(masters ...*Node) {
+	n.tb.Helper()
+
+	settle := 4 * n.interval
+	deadline := time.Now().Add(n.timeout)
+	var since time.Time
+	var last string
+	for time.Now().Before(deadline) {
+		if last = n.check(masters); last == "" {
+			if since.IsZero() {
+				since = time.Now()
+			}
+			if time.Since(since) >= settle {
+				return
+			}
+		} else {
+			since = time.Time{}
+		}
+		time.Sleep(n.interval / 4)
+	}
+	n.tb.Fatalf("election did not settle with MASTER %v within %s: %s", masters, n.timeout, last)
+}
+
+// ExpectMaster is ExpectMasters for a single MASTER
+func (n *Network) ExpectMaster(master *Node) {
+	n.tb.Helper()
+	n.ExpectMasters(master)
+}
+
+// ExpectState waits until node is in state, failing the test after the
+// timeout
+func (n *Network) ExpectState(node *Node, state vrrp.State) {
+	n.tb.Helper()
+	deadline := time.Now().Add(n.timeout)
+	for time.Now().Before(deadline) {
+		if node.State() == state {
+			return
+		}
+		time.Sleep(n.interval / 4)
+	}
+	n.tb.Fatalf("router %s is %v, want %v after %s", node.name, node.State(), state, n.timeout)
+}
+
+// check describes how the network differs from the expected election, or
+// returns "" if it matches
+func (n *Network) check(masters []*Node) string {
+	n.mu.Lock()
+	nodes := slices.Clone(n.nodes)
+	n.mu.Unlock()
+
+	var problems []string
+	for _, node := range nodes {
+		node.mu.Lock()
+		up := node.up
+		node.mu.Unlock()
+		if !up {
+			continue
+		}
+
+		state := node.State()
+		want := vrrp.Backup
+		if slices.Contains(masters, node) {
+			want = vrrp.Master
+		}
+		if state != want {
+			problems = append(problems, fmt.Sprintf("%s is %v", node.name, state))
+			continue
+		}
+
+		held := node.VirtualIPs()
+		if want == vrrp.Master && len(held) != len(node.vips) {
+			problems = append(problems, fmt.Sprintf("%s holds %v of %v", node.name, held, node.vips))
+		}
+		if want == vrrp.Backup && len(held) > 0 {
+			problems = append(problems, fmt.Sprintf("%s is BACKUP holding %v", node.name, held))
+		}
+	}
+	sort.Strings(problems)
+	return strings.Join(problems, ", ")
+}
@@ -0,0 +1,154 @@
+package vrrptest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// The first four tests cover the scenarios of test/integration without root
+
+func TestMasterElection(t *testing.T) {
+	n := NewNetwork(t, Options{})
+	backup := n.Add(Router{Name: "backup", Priority: 100})
+	master := n.Add(Router{Name: "master", Priority: 200})
+
+	n.ExpectMaster(master)
+	if got := master.VirtualIPs(); len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("master holds %v, want 192.0.2.1", got)
+	}
+	if got := backup.VirtualIPs(); len(got) != 0 {
+		t.Errorf("backup holds %v, want none", got)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	n := NewNetwork(t, Options{})
+	master := n.Add(Router{Name: "master", Priority: 200})
+	backup := n.Add(Router{Name: "backup", Priority: 100})
+	n.ExpectMaster(master)
+
+	master.Kill()
+	n.ExpectMaster(backup)
+
+	trs := backup.Transitions()
+	if last := trs[len(trs)-1]; last.New != vrrp.Master || last.Cause != vrrp.CauseMasterDown {
+		t.Errorf("takeover = %+v, want MASTER on %s", last, vrrp.CauseMasterDown)
+	}
+
+	// The recovered master preempts the backup
+	master.Revive()
+	n.ExpectMaster(master)
+}
+
+func TestPreemption(t *testing.T) {
+	n := NewNetwork(t, Options{})
+	low := n.Add(Router{Name: "low", Priority: 100})
+	n.ExpectMaster(low)
+
+	high := n.Add(Router{Name: "high", Priority: 200})
+	n.ExpectMaster(high)
+
+	trs := low.Transitions()
+	if last := trs[len(trs)-1]; last.New != vrrp.Backup || last.Cause != vrrp.CausePreemptedBy {
+		t.Errorf("low's last transition = %+v, want BACKUP, %s", last, vrrp.CausePreemptedBy)
+	}
+}
+
+func TestMultipleVRIDs(t *testing.T) {
+	n := NewNetwork(t, Options{})
+	a40 := n.Add(Router{Name: "a40", VRID: 40, Priority: 200, VirtualIPs: []string{"10.0.0.101"}})
+	a41 := n.Add(Router{Name: "a41", VRID: 41, Priority: 100, VirtualIPs: []string{"10.0.0.102"}})
+	b40 := n.Add(Router{Name: "b40", VRID: 40, Priority: 100, VirtualIPs: []string{"10.0.0.101"}})
+	b41 := n.Add(Router{Name: "b41", VRID: 41, Priority: 200, VirtualIPs: []string{"10.0.0.102"}})
+
+	n.ExpectMasters(a40, b41)
+	for _, node := range []*Node{a41, b40} {
+		if got := node.State(); got != vrrp.Backup {
+			t.Errorf("%s is %v, want BACKUP", node, got)
+		}
+	}
+}
+
+func TestNoPreempt(t *testing.T) {
+	n := NewNetwork(t, Options{})
+	low := n.Add(Router{Name: "low", Priority: 100})
+	n.ExpectMaster(low)
+
+	n.Add(Router{Name: "high", Priority: 200, NoPreempt: true})
+	n.ExpectMaster(low)
+}
+
+func TestPartitionAndHeal(t *testing.T) {
+	n := NewNetwork(t, Options{})
+	a := n.Add(Router{Name: "a", Priority: 200})
+	b := n.Add(Router{Name: "b", Priority: 150})
+	c := n.Add(Router{Name: "c", Priority: 100})
+	n.ExpectMaster(a)
+
+	// Cut off from a, b and c elect b: a split brain
+	n.Partition([]*Node{a})
+	n.ExpectMasters(a, b)
+
+	n.Heal()
+	n.ExpectMaster(a)
+	if got := c.State(); got != vrrp.Backup {
+		t.Errorf("c is %v, want BACKUP", got)
+	}
+}
+
+func TestStepDown(t *testing.T) {
+	n := NewNetwork(t, Options{})
+	a := n.Add(Router{Name: "a", Priority: 200})
+	b := n.Add(Router{Name: "b", Priority: 100})
+	n.ExpectMaster(a)
+
+	if err := a.StepDown(time.Minute); err != nil {
+		t.Fatalf("StepDown: %v", err)
+	}
+	n.ExpectMaster(b)
+
+	trs := b.Transitions()
+	if last := trs[len(trs)-1]; last.Cause != vrrp.CausePriorityZero {
+		t.Errorf("b took over on %s, want %s", last.Cause, vrrp.CausePriorityZero)
+	}
+}
+
+func TestSetPriority(t *testing.T) {
+	n := NewNetwork(t, Options{})
+	a := n.Add(Router{Name: "a", Priority: 200})
+	b := n.Add(Router{Name: "b", Priority: 100})
+	n.ExpectMaster(a)
+
+	b.SetPriority(250)
+	n.ExpectMaster(b)
+}
+
+func TestLossyNetwork(t *testing.T) {
+	n := NewNetwork(t, Options{Chaos: vrrp.Chaos{
+		Drop:      0.1,
+		Jitter:    5 * time.Millisecond,
+		Duplicate: 0.2,
+		Reorder:   0.2,
+		Corrupt:   0.1,
+		Seed:      1,
+	}})
+	a := n.Add(Router{Name: "a", Priority: 200})
+	b := n.Add(Router{Name: "b", Priority: 150})
+	n.Add(Router{Name: "c", Priority: 100})
+
+	// Losing one advertisement in ten is not enough to trigger a takeover
+	n.ExpectMaster(a)
+	a.Kill()
+	n.ExpectMaster(b)
+}
+
+func TestTotalLoss(t *testing.T) {
+	n := NewNetwork(t, Options{Chaos: vrrp.Chaos{Drop: 1}})
+	a := n.Add(Router{Name: "a", Priority: 200})
+	b := n.Add(Router{Name: "b", Priority: 100})
+
+	n.ExpectMasters(a, b)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// capability is one Linux capability the daemon needs
+type capability struct {
+	bit  uint
+	name string
+	use  string
+}
+
+// requiredCaps are needed for the whole life of the daemon: instances started
+// on reload open raw sockets, and netlink sockets are opened per VIP change
+var requiredCaps = []capability{
+	{unix.CAP_NET_RAW, "CAP_NET_RAW", "send and receive advertisements"},
+	{unix.CAP_NET_ADMIN, "CAP_NET_ADMIN", "add virtual IPs and IPVS services"},
+}
+
+// checkCapabilities fails with an error naming every required capability
+// missing from the effective set, rather than assuming euid 0 has them all
+func checkCapabilities() error {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed to read process capabilities: %w", err)
+	}
+
+	var missing []string
+	for _, c := range requiredCaps {
+		if data[0].Effective&(1<<c.bit) == 0 {
+			missing = append(missing, fmt.Sprintf("%s (to %s)", c.name, c.use))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing %s; run as root or grant them with setcap cap_net_raw,cap_net_admin+ep",
+			os.ErrPermission, strings.Join(missing, " and "))
+	}
+	return nil
+}
+
+// dropPrivileges switches every thread of the process to the given user and
+// group (the user's primary group if empty), keeping only the required
+// capabilities and extra. They are kept as ambient capabilities too, so the
+// commands the daemon runs and the new process of an upgrade have them
+// without being root. Open sockets and locks stay usable.
+func dropPrivileges(userName, groupName string, extra ...capability) error {
+	uid, gid, err := lookupUser(userName)
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	if groupName != "" {
+		if gid, err = lookupGroup(groupName); err != nil {
+			return withExitCode(exitUsage, err)
+		}
+	}
+
+	// An upgraded process starts as the user already, with no capability to
+	// switch again
+	if os.Getuid() != uid || os.Geteuid() != uid || os.Getgid() != gid || os.Getegid() != gid {
+		if err := switchUser(uid, gid); err != nil {
+			return err
+		}
+	}
+
+	// setuid cleared the effective and ambient sets; restore the required
+	// capabilities and drop every other one from the permitted set
+	var keep uint32
+	caps := append(requiredCaps, extra...)
+	for _, c := range caps {
+		keep |= 1 << c.bit
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{{Effective: keep, Permitted: keep, Inheritable: keep}}
+	if err := allThreads("--user", syscall.SYS_CAPSET,
+		uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); err != nil {
+		return fmt.Errorf("failed to set capabilities: %w", err)
+	}
+	for _, c := range caps {
+		if err := allThreads("--user", syscall.SYS_PRCTL, unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE,
+			uintptr(c.bit)); err != nil {
+			return fmt.Errorf("failed to make %s ambient: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// switchUser sets the UID and GID of every thread, without supplementary
+// groups, keeping the permitted capabilities
+func switchUser(uid, gid int) error {
+	// Capabilities are per thread: the changes go through AllThreadsSyscall,
+	// which the runtime only supports without cgo
+	if err := allThreads("--user", syscall.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 1, 0); err != nil {
+		return fmt.Errorf("failed to keep capabilities: %w", err)
+	}
+
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("failed to clear supplementary groups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to switch to group %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to switch to user %d: %w", uid, err)
+	}
+	return nil
+}
+
+// allThreads makes a system call on every thread, for option
+func allThreads(option string, trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	switch {
+	case errno == 0:
+		return nil
+	case errors.Is(errno, syscall.ENOTSUP):
+		return withExitCode(exitUsage, fmt.Errorf("%s requires a binary built with CGO_ENABLED=0", option))
+	default:
+		return errno
+	}
+}
+
+// lookupUser resolves a user name or numeric UID to its UID and primary GID
+func lookupUser(name string) (int, int, error) {
+	var u *user.User
+	var err error
+	if _, numErr := strconv.Atoi(name); numErr == nil {
+		u, err = user.LookupId(name)
+	} else {
+		u, err = user.Lookup(name)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("unknown user %q: %w", name, err)
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid UID %q for user %s", u.Uid, name)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid GID %q for user %s", u.Gid, name)
+	}
+	return uid, gid, nil
+}
+
+// lookupGroup resolves a group name or numeric GID to its GID
+func lookupGroup(name string) (int, error) {
+	var g *user.Group
+	var err error
+	if _, numErr := strconv.Atoi(name); numErr == nil {
+		g, err = user.LookupGroupId(name)
+	} else {
+		g, err = user.LookupGroup(name)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unknown group %q: %w", name, err)
+	}
+
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GID %q for group %s", g.Gid, name)
+	}
+	return gid, nil
+}
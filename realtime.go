@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ipcLockCap is kept by --user with --mlock: the memory the runtime maps
+// later is locked too, which without it counts against RLIMIT_MEMLOCK
+var ipcLockCap = capability{unix.CAP_IPC_LOCK, "CAP_IPC_LOCK", "lock memory"}
+
+// sysNiceCap is kept by --user with a real-time policy or a negative nice
+// value, which an upgraded process sets again
+var sysNiceCap = capability{unix.CAP_SYS_NICE, "CAP_SYS_NICE", "set the scheduling policy"}
+
+// schedPolicies are the --sched-policy values
+var schedPolicies = map[string]uint32{
+	"other": 0, // SCHED_OTHER
+	"fifo":  unix.SCHED_FIFO,
+	"rr":    unix.SCHED_RR,
+}
+
+// applyScheduling sets the scheduling policy and priority, or the nice
+// value, of the process and locks its memory, as configured by
+// --sched-policy, --sched-priority, --nice and --mlock. Commands the daemon
+// runs inherit the policy and nice value.
+func applyScheduling(policy string, priority, nice int, mlock bool) error {
+	switch {
+	case policy == "other" && priority != 0:
+		return withExitCode(exitUsage, errors.New("--sched-priority requires --sched-policy fifo or rr"))
+	case policy != "other" && nice != 0:
+		return withExitCode(exitUsage, errors.New("--nice applies only to --sched-policy other"))
+	case policy != "other" && (priority < 1 || priority > 99):
+		return withExitCode(exitUsage, fmt.Errorf("--sched-priority %d must be between 1 and 99", priority))
+	case nice < -20 || nice > 19:
+		return withExitCode(exitUsage, fmt.Errorf("--nice %d must be between -20 and 19", nice))
+	}
+
+	if policy != "other" || nice != 0 {
+		attr := unix.SchedAttr{
+			Size:     unix.SizeofSchedAttr,
+			Policy:   schedPolicies[policy],
+			Nice:     int32(nice),
+			Priority: uint32(priority),
+		}
+		if err := setThreadsSchedAttr(&attr); err != nil {
+			if errors.Is(err, syscall.EPERM) {
+				err = fmt.Errorf("%w (needs CAP_SYS_NICE)", err)
+			}
+			return fmt.Errorf("failed to set scheduling policy %s: %w", policy, err)
+		}
+		slog.Info("Scheduling set", "policy", policy, "priority", priority, "nice", nice)
+	}
+
+	if mlock {
+		// The runtime reserves far more address space than it uses; lock
+		// pages as they are touched rather than faulting all of it in
+		if err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE | unix.MCL_ONFAULT); err != nil {
+			if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOMEM) {
+				err = fmt.Errorf("%w (needs CAP_IPC_LOCK or a higher RLIMIT_MEMLOCK)", err)
+			}
+			return fmt.Errorf("failed to lock memory: %w", err)
+		}
+		slog.Info("Memory locked")
+	}
+	return nil
+}
+
+// setThreadsSchedAttr applies attr to every thread of the process. Linux
+// schedules threads, not processes, and advertisements are timed by
+// goroutines that may run on any of them. Threads the runtime starts later
+// inherit the attributes of the thread that starts them, so the threads are
+// listed again until no new one shows up.
+func setThreadsSchedAttr(attr *unix.SchedAttr) error {
+	done := make(map[int]bool)
+	for {
+		entries, err := os.ReadDir("/proc/self/task")
+		if err != nil {
+			return fmt.Errorf("failed to list threads: %w", err)
+		}
+		changed := false
+		for _, e := range entries {
+			tid, err := strconv.Atoi(e.Name())
+			if err != nil || done[tid] {
+				continue
+			}
+			_, _, errno := unix.Syscall(unix.SYS_SCHED_SETATTR, uintptr(tid), uintptr(unsafe.Pointer(attr)), 0)
+			// ESRCH: the thread exited
+			if errno != 0 && errno != unix.ESRCH {
+				return errno
+			}
+			done[tid] = true
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+	}
+}
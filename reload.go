@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var reloadCmd = app.Command("reload", "Reload the configuration file of the running daemon")
+
+func reloadConfig() {
+	resp, err := control.NewClient(*socketPath).Do(&control.Request{Command: control.CommandReload})
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Println(resp.Message)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/replay"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var (
+	replayCmd  = app.Command("replay", "Play the VRRP traffic of a pcap file through the parser and state machine")
+	replayFile = replayCmd.Arg("file", "Capture file (pcap, e.g. from tcpdump -w or run --pcap)").
+			Required().ExistingFile()
+	replaySpeed = replayCmd.Flag("speed", "Play this many times faster than recorded").Default("1").Float64()
+	replayVRID  = replayCmd.Flag("vrid",
+		"Only show this Virtual Router ID; with --priority, the VRID of the local router").Short('r').Uint8()
+	replayPriority = replayCmd.Flag("priority",
+		"Run a local router at this priority against the traffic (requires --vrid and --vips)").Short('p').Uint8()
+	replayVIPs = replayCmd.Flag("vips", "Virtual IP addresses of the local router (comma-separated)").
+			Short('v').String()
+	replayInterval = replayCmd.Flag("advert-int", "Advertisement interval of the local router in seconds").
+			Default("1").Int()
+	replayPreempt  = replayCmd.Flag("preempt", "Enable preemption on the local router").Default("true").Bool()
+	replaySourceIP = replayCmd.Flag("source-ip",
+		"Address of the local router; captured advertisements from it are its own").String()
+	replayOutput = replayCmd.Flag("output", "Output format").Short('o').Default("text").Enum("text", "json")
+)
+
+// replayTransition is a state change of the local router as printed by vrrp
+// replay
+type replayTransition struct {
+	Time       time.Time `json:"time"`
+	Transition struct {
+		OldState string `json:"old_state"`
+		NewState string `json:"new_state"`
+		Cause    string `json:"cause"`
+		Detail   string `json:"detail,omitempty"`
+	} `json:"transition"`
+}
+
+func replayCapture() {
+	if *replaySpeed <= 0 {
+		app.Fatalf("--speed must be positive")
+	}
+
+	opts := replay.Options{Speed: *replaySpeed}
+	if *replayPriority != 0 {
+		if *replayVRID == 0 || *replayVIPs == "" {
+			app.Fatalf("--priority requires --vrid and --vips")
+		}
+		opts.Router = &replay.Router{
+			VRID:        *replayVRID,
+			Priority:    *replayPriority,
+			VirtualIPs:  strings.Split(*replayVIPs, ","),
+			AdvInterval: *replayInterval,
+			NoPreempt:   !*replayPreempt,
+			SourceIP:    *replaySourceIP,
+		}
+	}
+
+	f, err := os.Open(*replayFile)
+	if err != nil {
+		exitWithError(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var adverts, dropped int
+	final := vrrp.Init
+	enc := json.NewEncoder(os.Stdout)
+	err = replay.Run(ctx, f, opts, func(e replay.Event) {
+		if t := e.Transition; t != nil {
+			final = t.New
+			printReplayTransition(enc, t)
+			return
+		}
+
+		a := decodeAdvert(e.Time, "", e.Header, e.Payload)
+		a.Drop = string(e.Drop)
+		// The local router counts other VRIDs as drops, but they are hidden
+		// along with the rest
+		if *replayVRID != 0 && a.Error == "" && a.VRID != *replayVRID {
+			return
+		}
+		adverts++
+		if e.Drop != "" {
+			dropped++
+		}
+		if *replayOutput == "json" {
+			_ = enc.Encode(a)
+			return
+		}
+		printAdvert(&a)
+	})
+	if err != nil && err != context.Canceled {
+		exitWithError(err)
+	}
+
+	if *replayOutput == "text" {
+		fmt.Printf("\n%d advertisements, %d would be dropped\n", adverts, dropped)
+		if opts.Router != nil {
+			fmt.Printf("Local router ended %s\n", final)
+		}
+	}
+}
+
+func printReplayTransition(enc *json.Encoder, t *vrrp.Transition) {
+	if *replayOutput == "json" {
+		var out replayTransition
+		out.Time = t.Time
+		out.Transition.OldState = t.Old.String()
+		out.Transition.NewState = t.New.String()
+		out.Transition.Cause = string(t.Cause)
+		out.Transition.Detail = t.Detail
+		_ = enc.Encode(out)
+		return
+	}
+	line := fmt.Sprintf("%s ** local %s -> %s (%s", t.Time.Format("15:04:05.000"), t.Old, t.New, t.Cause)
+	if t.Detail != "" {
+		line += ": " + t.Detail
+	}
+	fmt.Println(line + ")")
+}
@@ -0,0 +1,805 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/config"
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+	"github.com/tokuhirom/vrrp-simple/pkg/ipvs"
+	"github.com/tokuhirom/vrrp-simple/pkg/metrics"
+	"github.com/tokuhirom/vrrp-simple/pkg/nomad"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var (
+	runCmd    = app.Command("run", "Run VRRP instance")
+	runConfig = runCmd.Flag("config", "Configuration file (instead of the instance flags below)").
+			Envar("VRRP_CONFIG").Short('c').String()
+	runInterface = runCmd.Flag("interface", "Network interface to use").
+			Envar("VRRP_INTERFACE").Short('i').HintAction(interfaceNames).String()
+	runVRID     = runCmd.Flag("vrid", "Virtual Router ID (1-255)").Envar("VRRP_VRID").Short('r').Uint8()
+	runPriority = runCmd.Flag("priority", "Router priority (1-255, 255 = master)").
+			Envar("VRRP_PRIORITY").Short('p').Default("100").Uint8()
+	runVIPs     = runCmd.Flag("vips", "Virtual IP addresses (comma-separated)").Envar("VRRP_VIPS").Short('v').String()
+	runExcluded = runCmd.Flag("excluded-ips",
+		"Addresses held with the virtual IPs while master but not advertised (comma-separated)").
+		Envar("VRRP_EXCLUDED_IPS").String()
+	runInterval = runCmd.Flag("advert-int", "Advertisement interval in seconds").
+			Envar("VRRP_ADVERT_INT").Default("1").Int()
+	runPreempt = runCmd.Flag("preempt", "Enable preemption").Envar("VRRP_PREEMPT").Default("true").Bool()
+
+	runObserve = runCmd.Flag("observe",
+		"Follow the election without taking part: never advertise or program the VIPs").
+		Envar("VRRP_OBSERVE").Bool()
+	runBackupOnly = runCmd.Flag("backup-only",
+		"Never become MASTER, whatever the priority; log an error when the master is lost instead").
+		Envar("VRRP_BACKUP_ONLY").Bool()
+	runPreemptWindows = runCmd.Flag("preempt-window",
+		"Only preempt during this weekly period, [DAYS ]HH:MM-HH:MM on the host's clock (repeatable; "+
+			"e.g. Mon-Fri 02:00-04:00; default any time)").
+		Envar("VRRP_PREEMPT_WINDOW").Strings()
+
+	runAddressBackend = runCmd.Flag("address-backend", "How virtual IPs are programmed").
+				Envar("VRRP_ADDRESS_BACKEND").Default("netlink").Enum("netlink", "exec", "noop")
+
+	runDetectVIPConflicts = runCmd.Flag("detect-vip-conflicts",
+		"While MASTER, probe the VIPs with ARP and report a split brain if another host answers").
+		Envar("VRRP_DETECT_VIP_CONFLICTS").Bool()
+	runOnLinkCheck = runCmd.Flag("on-link-check",
+		"What to do with advertisements from sources outside the interface's subnets: off, count or enforce").
+		Envar("VRRP_ON_LINK_CHECK").Default("off").Enum("off", "count", "enforce")
+	runIntervalCheck = runCmd.Flag("interval-check",
+		"What to do with advertisements of another interval: count, or enforce to drop the VRRPv2 ones").
+		Envar("VRRP_INTERVAL_CHECK").Default("count").Enum("count", "enforce")
+	runVersionPolicy = runCmd.Flag("version-policy",
+		"What to do with VRRPv3 advertisements: prefer the VRRPv2 ones of a source sending both, drop them "+
+			"all (strict), or translate to also send VRRPv3").
+		Envar("VRRP_VERSION_POLICY").Default("prefer").Enum("prefer", "strict", "translate")
+	runAddressCheck = runCmd.Flag("address-check",
+		"What to do with advertisements of other virtual IPs: warn, drop, or adopt to report the master's list").
+		Envar("VRRP_ADDRESS_CHECK").Default("warn").Enum("warn", "drop", "adopt")
+	runGARP = runCmd.Flag("garp",
+		"Gratuitous ARP to broadcast for each virtual IP on becoming master: both, request, reply or off").
+		Envar("VRRP_GARP").Default("both").Enum("both", "request", "reply", "off")
+	runAllowedPeers = runCmd.Flag("allowed-peers",
+		"Only accept advertisements from these addresses or CIDR prefixes (comma-separated; default any)").
+		Envar("VRRP_ALLOWED_PEERS").String()
+	runAuthKeys = runCmd.Flag("auth-keys",
+		"Authenticate advertisements with these ID:KEY shared keys, the first signing (comma-separated; "+
+			"only understood by this daemon; prefer the environment variable)").
+		Envar("VRRP_AUTH_KEYS").String()
+	runAuthReplayWindow = runCmd.Flag("auth-replay-window",
+		"How far the timestamp of an authenticated advertisement may be from this host's clock "+
+			"before it is dropped as a replay (0 only checks that timestamps increase)").
+		Envar("VRRP_AUTH_REPLAY_WINDOW").Default(vrrp.DefaultAuthReplayWindow.String()).Duration()
+	runTrack = runCmd.Flag("track",
+		"Lower the priority while this health check fails, TYPE:key=value,... (repeatable; "+
+			"e.g. dns:query=example.com,server=127.0.0.1,weight=50)").
+		Envar("VRRP_TRACK").Strings()
+
+	runIPVSPort = runCmd.Flag("ipvs-port",
+		"Program an IPVS virtual server on this port for each VIP while MASTER").Envar("VRRP_IPVS_PORT").Uint16()
+	runIPVSProtocol = runCmd.Flag("ipvs-protocol",
+		"IPVS virtual server protocol").Envar("VRRP_IPVS_PROTOCOL").Default("tcp").Enum("tcp", "udp")
+	runIPVSScheduler = runCmd.Flag("ipvs-scheduler",
+		"IPVS scheduler (rr, wrr, lc, ...)").Envar("VRRP_IPVS_SCHEDULER").Default("rr").String()
+	runIPVSForwarding = runCmd.Flag("ipvs-forwarding",
+		"IPVS forwarding method").Envar("VRRP_IPVS_FORWARDING").Default("nat").Enum("nat", "dr", "tunnel")
+	runIPVSRealServers = runCmd.Flag("ipvs-real-servers",
+		"IPVS real servers (comma-separated ip:port[:weight])").Envar("VRRP_IPVS_REAL_SERVERS").String()
+	runIPVSCheckInterval = runCmd.Flag("ipvs-check-interval",
+		"TCP health check interval for real servers (0 disables)").
+		Envar("VRRP_IPVS_CHECK_INTERVAL").Default("5s").Duration()
+
+	runNomadAddr = runCmd.Flag("nomad-addr",
+		"Publish this host as MASTER of each instance in a variable of the Nomad cluster with this "+
+			"HTTP API address, e.g. http://127.0.0.1:4646 (disabled if empty)").
+		Envar("VRRP_NOMAD_ADDR").String()
+	runNomadToken = runCmd.Flag("nomad-token",
+		"Nomad ACL token allowed to write the variables (prefer the environment variable)").
+		Envar("VRRP_NOMAD_TOKEN").String()
+	runNomadNamespace = runCmd.Flag("nomad-namespace", "Nomad namespace of the variables (default the token's)").
+				Envar("VRRP_NOMAD_NAMESPACE").String()
+	runNomadPrefix = runCmd.Flag("nomad-prefix", "Path of the Nomad variables, PREFIX/IFACE/VRID").
+			Envar("VRRP_NOMAD_PREFIX").Default(nomad.DefaultPrefix).String()
+	runNomadNode = runCmd.Flag("nomad-node", "Name of this host in the Nomad variables (default the hostname)").
+			Envar("VRRP_NOMAD_NODE").String()
+	runNomadCACert = runCmd.Flag("nomad-ca-cert", "Verify the Nomad API's certificate against the CAs in this PEM file").
+			Envar("VRRP_NOMAD_CA_CERT").String()
+
+	runStopTimeout = runCmd.Flag("stop-timeout",
+		"How long shutdown waits for the instances to release their VIPs and hand over").
+		Envar("VRRP_STOP_TIMEOUT").Default("10s").Duration()
+
+	runPidfile  = runCmd.Flag("pidfile", "Write the daemon PID to this file").Envar("VRRP_PIDFILE").String()
+	runAuditLog = runCmd.Flag("audit-log",
+		"Append a JSON line for every state transition, with its cause, to this file").
+		Envar("VRRP_AUDIT_LOG").String()
+	runPcap = runCmd.Flag("pcap",
+		"Write every advertisement sent and received to this pcap file, replacing it").
+		Envar("VRRP_PCAP").String()
+	runChaos = runCmd.Flag("chaos",
+		"Inject faults into received advertisements, for testing, e.g. drop=0.2,jitter=20ms "+
+			"(with --config, set chaos per instance)").
+		Envar("VRRP_CHAOS").String()
+	runLockDir = runCmd.Flag("lock-dir", "Directory for the per-instance lock files").
+			Envar("VRRP_LOCK_DIR").Default("/run/vrrp-simple").String()
+
+	runAdminUsers = runCmd.Flag("admin-users",
+		"Only let root, the daemon's user and these users (comma-separated) change the daemon "+
+			"through the control socket").
+		Envar("VRRP_ADMIN_USERS").String()
+	runAdminGroups = runCmd.Flag("admin-groups",
+		"Also let members of these groups (comma-separated) change the daemon through the control socket").
+		Envar("VRRP_ADMIN_GROUPS").String()
+	runAPITokenFile = runCmd.Flag("api-token-file",
+		"Require a bearer token from this file of NAME:TOKEN lines for gRPC and REST requests "+
+			"that change the daemon").
+		Envar("VRRP_API_TOKEN_FILE").String()
+	runAPITLSCert = runCmd.Flag("api-tls-cert",
+		"Serve the gRPC and REST admin APIs over TLS with this PEM certificate chain, "+
+			"required for a non-loopback address").
+		Envar("VRRP_API_TLS_CERT").String()
+	runAPITLSKey = runCmd.Flag("api-tls-key", "PEM private key of --api-tls-cert").
+			Envar("VRRP_API_TLS_KEY").String()
+	runAPIClientCA = runCmd.Flag("api-client-ca",
+		"Require admin API clients to present a certificate signed by a CA in this PEM file").
+		Envar("VRRP_API_CLIENT_CA").String()
+	runAPIClientNames = runCmd.Flag("api-client-names",
+		"Only accept client certificates with one of these common or DNS names (comma-separated)").
+		Envar("VRRP_API_CLIENT_NAMES").String()
+
+	runGRPCListen = runCmd.Flag("grpc-listen", "Serve the gRPC admin API on this address (disabled if empty)").
+			Envar("VRRP_GRPC_LISTEN").String()
+	runHTTPListen = runCmd.Flag("http-listen", "Serve the REST admin API on this address (disabled if empty)").
+			Envar("VRRP_HTTP_LISTEN").String()
+	runMetricsListen = runCmd.Flag("metrics-listen",
+		"Serve Prometheus metrics at /metrics on this address (disabled if empty)").
+		Envar("VRRP_METRICS_LISTEN").String()
+	runMetricsTextfileDir = runCmd.Flag("metrics-textfile-dir",
+		"Write Prometheus metrics to vrrp.prom in this node_exporter textfile collector directory "+
+			"at each transition and every --metrics-textfile-interval (disabled if empty)").
+		Envar("VRRP_METRICS_TEXTFILE_DIR").String()
+	runMetricsTextfileInterval = runCmd.Flag("metrics-textfile-interval", "How often the metrics textfile is written").
+					Envar("VRRP_METRICS_TEXTFILE_INTERVAL").Default("15s").Duration()
+	runStatsDAddr = runCmd.Flag("statsd-addr",
+		"Send metrics to the StatsD or DogStatsD server at this UDP host:port (disabled if empty)").
+		Envar("VRRP_STATSD_ADDR").String()
+	runStatsDPrefix = runCmd.Flag("statsd-prefix", "Prefix of the StatsD metric names").
+			Envar("VRRP_STATSD_PREFIX").Default(metrics.DefaultStatsDPrefix).String()
+	runStatsDFormat = runCmd.Flag("statsd-format",
+		"statsd puts the interface and VRID in the metric names, as Graphite wants; dogstatsd sends them as tags").
+		Envar("VRRP_STATSD_FORMAT").Default("statsd").Enum("statsd", "dogstatsd")
+	runStatsDTags = runCmd.Flag("statsd-tags", "Tags added to every metric with --statsd-format dogstatsd "+
+		"(comma-separated, e.g. env:prod,dc:tyo)").Envar("VRRP_STATSD_TAGS").String()
+	runStatsDInterval = runCmd.Flag("statsd-interval", "How often the metrics are sent to StatsD").
+				Envar("VRRP_STATSD_INTERVAL").Default(metrics.DefaultStatsDInterval.String()).Duration()
+	runZabbixServer = runCmd.Flag("zabbix-server",
+		"Send state and counter items to the Zabbix server or proxy at this host[:port] at each transition "+
+			"and every --zabbix-interval (disabled if empty)").
+		Envar("VRRP_ZABBIX_SERVER").String()
+	runZabbixHost = runCmd.Flag("zabbix-host", "Host name of the items in Zabbix (default the hostname)").
+			Envar("VRRP_ZABBIX_HOST").String()
+	runZabbixPrefix = runCmd.Flag("zabbix-prefix", "Prefix of the Zabbix item keys").
+			Envar("VRRP_ZABBIX_PREFIX").Default(metrics.DefaultZabbixPrefix).String()
+	runZabbixInterval = runCmd.Flag("zabbix-interval", "How often every item is sent to Zabbix").
+				Envar("VRRP_ZABBIX_INTERVAL").Default(metrics.DefaultZabbixInterval.String()).Duration()
+	runDebugListen = runCmd.Flag("debug-listen",
+		"Serve pprof and expvar on this loopback address, e.g. 127.0.0.1:6060 (disabled if empty)").
+		Envar("VRRP_DEBUG_LISTEN").String()
+
+	runDryRun = runCmd.Flag("dry-run",
+		"Run the election but only log the address, advertisement and IPVS changes it would make").
+		Envar("VRRP_DRY_RUN").Bool()
+	runLowFootprint = runCmd.Flag("low-footprint",
+		"Save memory on small devices: tune the garbage collector, shorten the queues, "+
+			"skip metrics collection unless --metrics-listen or --metrics-textfile-dir is set "+
+			"and the periodic state log").
+		Envar("VRRP_LOW_FOOTPRINT").Bool()
+	runMaxAdvertRate = runCmd.Flag("max-advert-rate",
+		"Advertisements per second each instance processes from one source; more are dropped (0 disables)").
+		Envar("VRRP_MAX_ADVERT_RATE").Default(strconv.Itoa(vrrp.DefaultMaxAdvertRate)).Float64()
+	runKernelFilter = runCmd.Flag("kernel-filter",
+		"Drop the advertisements of VRIDs this daemon does not run in the kernel, with a socket filter; "+
+			"they are then not counted as VRID mismatches").
+		Envar("VRRP_KERNEL_FILTER").Bool()
+	runMulticastCheck = runCmd.Flag("multicast-check",
+		"How often to check that each socket is still in the VRRP multicast group, joining it again "+
+			"if the kernel dropped it (0 disables)").
+		Envar("VRRP_MULTICAST_CHECK").Default(vrrp.DefaultMembershipCheck.String()).Duration()
+	runMulticastRefresh = runCmd.Flag("multicast-refresh",
+		"How often to announce the multicast membership again, for switches that age out IGMP snooping "+
+			"entries without a querier (0 disables)").
+		Envar("VRRP_MULTICAST_REFRESH").Default("0s").Duration()
+	runUser = runCmd.Flag("user",
+		"Switch to this user once started, keeping only CAP_NET_RAW and CAP_NET_ADMIN "+
+			"(and CAP_SYS_NICE or CAP_IPC_LOCK for the scheduling options) as ambient capabilities").
+		Envar("VRRP_USER").String()
+	runGroup = runCmd.Flag("group", "Switch to this group with --user instead of the user's primary group").
+			Envar("VRRP_GROUP").String()
+
+	runSchedPolicy = runCmd.Flag("sched-policy",
+		"Scheduling policy of the daemon: other, or fifo or rr to run ahead of normal processes under load").
+		Envar("VRRP_SCHED_POLICY").Default("other").Enum("other", "fifo", "rr")
+	runSchedPriority = runCmd.Flag("sched-priority", "Real-time priority 1-99 for --sched-policy fifo or rr").
+				Envar("VRRP_SCHED_PRIORITY").Int()
+	runNice = runCmd.Flag("nice", "Nice value -20 to 19 for --sched-policy other").
+		Envar("VRRP_NICE").Int()
+	runMlock = runCmd.Flag("mlock", "Lock the daemon's memory so it is never paged out").
+			Envar("VRRP_MLOCK").Bool()
+
+	runSeccomp = runCmd.Flag("seccomp",
+		"Once started, restrict the daemon and the commands it runs to the system calls they need: "+
+			"off, log (log the others) or enforce (fail the others with EPERM)").
+		Envar("VRRP_SECCOMP").Default("off").Enum("off", "log", "enforce")
+	runLandlock = runCmd.Flag("landlock",
+		"Once started, only let the daemon and the commands it runs write where the daemon writes "+
+			"(lock directory, pidfile, logs, capture and control socket) and to --landlock-write").
+		Envar("VRRP_LANDLOCK").Bool()
+	runLandlockWrite = runCmd.Flag("landlock-write",
+		"More files or directories writable with --landlock (comma-separated)").
+		Envar("VRRP_LANDLOCK_WRITE").String()
+)
+
+func runVRRP() {
+	logs := control.NewLogBuffer(control.DefaultLogHistory)
+	keepLogHistory(logs)
+
+	cfgs := instanceConfigs()
+	if *runGroup != "" && *runUser == "" {
+		app.Fatalf("--group requires --user")
+	}
+	if *runStatsDTags != "" && *runStatsDFormat != "dogstatsd" {
+		app.Fatalf("--statsd-tags requires --statsd-format dogstatsd")
+	}
+
+	if *runLowFootprint {
+		applyLowFootprint()
+	}
+	if err := applyScheduling(*runSchedPolicy, *runSchedPriority, *runNice, *runMlock); err != nil {
+		fatal("Failed to set scheduling", err)
+	}
+
+	if !*runDryRun {
+		if err := checkCapabilities(); err != nil {
+			fatal("Insufficient privileges", err)
+		}
+	}
+
+	h, err := inheritedHandover()
+	if err != nil {
+		fatal("Failed to take over from the previous process", err)
+	}
+
+	d, err := newDaemon(*runConfig, cfgs, *runDryRun, *runLowFootprint, h)
+	if err != nil {
+		fatal("Failed to create virtual router", withExitCode(exitConfig, err))
+	}
+
+	if *runPidfile != "" {
+		predecessor := 0
+		if h != nil {
+			predecessor = os.Getppid()
+		}
+		if err := writePidfile(*runPidfile, predecessor); err != nil {
+			fatal("Failed to write pidfile", err)
+		}
+		defer func() { _ = os.Remove(*runPidfile) }()
+	}
+
+	policy, err := adminPolicy()
+	if err != nil {
+		fatal("Invalid admin access settings", withExitCode(exitConfig, err))
+	}
+	d.ctrl.SetPolicy(policy)
+	d.ctrl.SetLogs(logs)
+	apiTLS, err := adminTLS()
+	if err != nil {
+		fatal("Invalid admin access settings", withExitCode(exitConfig, err))
+	}
+	if (*runGRPCListen != "" || *runHTTPListen != "") && len(policy.Tokens) == 0 && apiTLS == nil {
+		slog.Warn("The admin API lets any client that can connect change the daemon; set --api-token-file")
+	}
+
+	if *runAuditLog != "" {
+		audit, err := openAuditLog(*runAuditLog)
+		if err != nil {
+			fatal("Failed to open audit log", err)
+		}
+		d.audit = audit
+		defer func() { _ = audit.close() }()
+	}
+
+	if *runPcap != "" {
+		capture, err := openPacketCapture(*runPcap)
+		if err != nil {
+			fatal("Failed to open packet capture", err)
+		}
+		d.capture = capture
+		defer func() { _ = capture.close() }()
+	}
+
+	if *runNomadAddr != "" && !*runDryRun {
+		pub, err := nomad.NewPublisher(&nomad.Config{
+			Address:   *runNomadAddr,
+			Token:     *runNomadToken,
+			Namespace: *runNomadNamespace,
+			Prefix:    *runNomadPrefix,
+			Node:      *runNomadNode,
+			CACert:    *runNomadCACert,
+		})
+		if err != nil {
+			fatal("Invalid Nomad settings", withExitCode(exitConfig, err))
+		}
+		d.nomad = pub
+	}
+
+	if *runMetricsTextfileDir != "" {
+		textfile, err := newTextfileExport(*runMetricsTextfileDir, d.metrics, *runMetricsTextfileInterval)
+		if err != nil {
+			fatal("Invalid metrics textfile settings", withExitCode(exitConfig, err))
+		}
+		d.textfile = textfile
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if d.textfile != nil {
+		d.textfile.start()
+	}
+	if d.nomad != nil {
+		go d.nomad.Run(ctx)
+	}
+	if d.statsd != nil {
+		go d.statsd.Run(ctx)
+	}
+	if d.zabbix != nil {
+		go d.zabbix.Run(ctx)
+	}
+
+	var ipvsCtrl *ipvs.Controller
+	if *runIPVSPort != 0 {
+		if *runConfig != "" {
+			app.Fatalf("IPVS flags can only be used without --config")
+		}
+		inst := d.instances[0]
+		if *runDryRun {
+			inst.onStateChange = append(inst.onStateChange, func(_, new vrrp.State) {
+				slog.Info("Dry run: would update IPVS services", "active", new == vrrp.Master)
+			})
+		} else {
+			ipvsCtrl = newIPVSController(inst.router.GetVirtualIPs())
+			inst.onStateChange = append(inst.onStateChange, func(_, new vrrp.State) {
+				if err := ipvsCtrl.SetActive(new == vrrp.Master); err != nil {
+					slog.Error("Failed to update IPVS services", "err", err)
+				}
+			})
+			go ipvsCtrl.Run(ctx)
+		}
+	}
+
+	if err := d.start(ctx, *runLockDir); err != nil {
+		fatal("Failed to start virtual router", err)
+	}
+
+	if *runDryRun {
+		slog.Warn("Dry run: virtual IPs, advertisements, IPVS services, Nomad variables and Route 53 records " +
+			"are left untouched")
+	}
+	for _, inst := range d.instances {
+		slog.Info("VRRP started",
+			"iface", inst.cfg.Interface,
+			"vrid", inst.cfg.VRID,
+			"priority", inst.cfg.Priority,
+			"virtual_ips", inst.cfg.VirtualIPs,
+			"advert_interval", inst.cfg.AdvertInterval,
+			"preempt", inst.cfg.PreemptEnabled())
+	}
+	if ipvsCtrl != nil {
+		slog.Info("IPVS enabled", "protocol", *runIPVSProtocol, "port", *runIPVSPort,
+			"real_servers", *runIPVSRealServers, "scheduler", *runIPVSScheduler, "forwarding", *runIPVSForwarding)
+	}
+
+	servers := &adminServers{d: d, tls: apiTLS}
+	if err := servers.start(); err != nil {
+		fatal("Failed to start admin servers", err)
+	}
+	defer servers.close()
+
+	if *runUser != "" {
+		var extra []capability
+		if *runSchedPolicy != "other" || *runNice < 0 {
+			extra = append(extra, sysNiceCap)
+		}
+		if *runMlock {
+			extra = append(extra, ipcLockCap)
+		}
+		if err := dropPrivileges(*runUser, *runGroup, extra...); err != nil {
+			fatal("Failed to drop privileges", err)
+		}
+		slog.Info("Dropped privileges", "user", *runUser, "group", *runGroup)
+	}
+
+	if err := applySandbox(*runSeccomp, *runLandlock); err != nil {
+		fatal("Failed to sandbox the daemon", err)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+
+	if h != nil {
+		if err := d.resumed(h); err != nil {
+			fatal("Failed to take over from the previous process", err)
+		}
+	} else {
+		sdNotify("READY=1")
+	}
+
+	// A flash-backed log on a small device is better off without the
+	// periodic state records
+	if !*runLowFootprint {
+		go logStates(ctx, d)
+	}
+
+	for sig := range sigCh {
+		if sig == syscall.SIGHUP {
+			slog.Info("Received SIGHUP, reloading configuration")
+			sdNotify("RELOADING=1")
+			if _, err := d.reload(); err != nil {
+				slog.Error("Reload failed", "err", err)
+			}
+			sdNotify("READY=1")
+			continue
+		}
+
+		if sig == syscall.SIGUSR2 {
+			slog.Info("Received SIGUSR2, upgrading")
+			pid, err := d.upgrade(servers)
+			if err != nil {
+				slog.Error("Upgrade failed, carrying on", "err", err)
+				continue
+			}
+			// The new process owns the VIPs, sockets, locks, pidfile and
+			// control socket now: exit without the deferred cleanup
+			slog.Info("Handed over", "pid", pid)
+			os.Exit(exitOK)
+		}
+
+		slog.Info("Received signal, shutting down", "signal", sig.String())
+		break
+	}
+
+	sdNotify("STOPPING=1")
+
+	d.stop(*runStopTimeout)
+
+	if d.nomad != nil {
+		// Withdraw the instances that were MASTER
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), *runStopTimeout)
+		if err := d.nomad.Flush(flushCtx); err != nil {
+			slog.Error("Failed to withdraw from Nomad", "err", err)
+		}
+		cancelFlush()
+	}
+
+	if d.statsd != nil {
+		// Send the last transitions
+		if err := d.statsd.Flush(); err != nil {
+			slog.Error("Failed to send metrics to StatsD", "err", err)
+		}
+		_ = d.statsd.Close()
+	}
+	if d.textfile != nil {
+		d.textfile.close()
+	}
+	if d.zabbix != nil {
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), *runStopTimeout)
+		if err := d.zabbix.Flush(flushCtx); err != nil {
+			slog.Error("Failed to send items to Zabbix", "err", err)
+		}
+		cancelFlush()
+	}
+
+	if ipvsCtrl != nil {
+		if err := ipvsCtrl.Close(); err != nil {
+			slog.Error("Failed to remove IPVS services", "err", err)
+		}
+	}
+
+	slog.Info("VRRP stopped")
+}
+
+// logStates logs the state of every instance every 5 seconds until ctx is
+// canceled
+func logStates(ctx context.Context, d *daemon) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// A reload adds and removes instances meanwhile
+			d.mu.Lock()
+			instances := slices.Clone(d.instances)
+			d.mu.Unlock()
+			for _, inst := range instances {
+				slog.Info("Current state",
+					"vrid", inst.cfg.VRID,
+					"iface", inst.cfg.Interface,
+					"state", inst.router.GetState().String())
+			}
+		}
+	}
+}
+
+// instanceConfigs returns the instances to run, from --config or from the flags
+func instanceConfigs() []config.Instance {
+	if *runConfig != "" {
+		if *runInterface != "" || *runVRID != 0 || *runVIPs != "" {
+			app.Fatalf("--config cannot be combined with --interface, --vrid or --vips")
+		}
+
+		f, err := config.Load(*runConfig)
+		if err != nil {
+			fatal("Failed to load configuration", withExitCode(exitConfig, err))
+		}
+		if err := errors.Join(f.Validate()...); err != nil {
+			fatal("Invalid configuration", withExitCode(exitConfig, err), "path", *runConfig)
+		}
+		return f.Instances
+	}
+
+	if *runInterface == "" || *runVRID == 0 || *runVIPs == "" {
+		app.Fatalf("--interface, --vrid and --vips are required unless --config is given")
+	}
+
+	vips := strings.Split(*runVIPs, ",")
+	for i, vip := range vips {
+		vips[i] = strings.TrimSpace(vip)
+	}
+
+	if _, err := vrrp.ParseChaos(*runChaos); err != nil {
+		app.Fatalf("invalid --chaos: %v", err)
+	}
+
+	var excluded []string
+	if *runExcluded != "" {
+		excluded = strings.Split(*runExcluded, ",")
+		for i, ip := range excluded {
+			excluded[i] = strings.TrimSpace(ip)
+		}
+	}
+
+	var peers []string
+	if *runAllowedPeers != "" {
+		peers = strings.Split(*runAllowedPeers, ",")
+		for i, peer := range peers {
+			peers[i] = strings.TrimSpace(peer)
+		}
+		if _, err := vrrp.ParseAllowedPeers(peers); err != nil {
+			app.Fatalf("invalid --allowed-peers: %v", err)
+		}
+	}
+
+	var authKeys []string
+	if *runAuthKeys != "" {
+		authKeys = strings.Split(*runAuthKeys, ",")
+		for i, key := range authKeys {
+			authKeys[i] = strings.TrimSpace(key)
+		}
+		if _, err := vrrp.ParseAuthKeys(authKeys); err != nil {
+			app.Fatalf("invalid --auth-keys: %v", err)
+		}
+	}
+	if _, err := vrrp.ParseTrackers(*runTrack); err != nil {
+		app.Fatalf("invalid --track: %v", err)
+	}
+	if _, err := vrrp.ParsePreemptWindows(*runPreemptWindows); err != nil {
+		app.Fatalf("invalid --preempt-window: %v", err)
+	}
+
+	preempt := *runPreempt
+	return []config.Instance{{
+		Interface:      *runInterface,
+		VRID:           *runVRID,
+		Priority:       *runPriority,
+		VirtualIPs:     vips,
+		ExcludedIPs:    excluded,
+		AdvertInterval: *runInterval,
+		Preempt:        &preempt,
+		PreemptWindows: *runPreemptWindows,
+		Observe:        *runObserve,
+		BackupOnly:     *runBackupOnly,
+		AddressBackend: *runAddressBackend,
+
+		DetectVIPConflicts: *runDetectVIPConflicts,
+		OnLinkCheck:        *runOnLinkCheck,
+		IntervalCheck:      *runIntervalCheck,
+		VersionPolicy:      *runVersionPolicy,
+		AddressCheck:       *runAddressCheck,
+		GARP:               *runGARP,
+		AllowedPeers:       peers,
+		AuthKeys:           authKeys,
+		Trackers:           *runTrack,
+		Chaos:              *runChaos,
+	}}
+}
+
+func newIPVSController(vips []net.IP) *ipvs.Controller {
+	servers, err := ipvs.ParseRealServers(*runIPVSRealServers)
+	if err != nil {
+		app.Fatalf("invalid IPVS real servers: %v", err)
+	}
+
+	ctrl, err := ipvs.NewController(&ipvs.Config{
+		Port:          *runIPVSPort,
+		Protocol:      *runIPVSProtocol,
+		Scheduler:     *runIPVSScheduler,
+		Forwarding:    *runIPVSForwarding,
+		RealServers:   servers,
+		CheckInterval: *runIPVSCheckInterval,
+	}, vips)
+	if err != nil {
+		fatal("Failed to set up IPVS", err)
+	}
+
+	return ctrl
+}
+
+// adminPolicy is who may change the daemon through the control socket and
+// the admin APIs
+func adminPolicy() (control.Policy, error) {
+	var p control.Policy
+	for _, name := range strings.Split(*runAdminUsers, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		uid, _, err := lookupUser(name)
+		if err != nil {
+			return p, fmt.Errorf("--admin-users: %w", err)
+		}
+		p.UIDs = append(p.UIDs, uint32(uid))
+	}
+	for _, name := range strings.Split(*runAdminGroups, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		gid, err := lookupGroup(name)
+		if err != nil {
+			return p, fmt.Errorf("--admin-groups: %w", err)
+		}
+		p.GIDs = append(p.GIDs, uint32(gid))
+	}
+
+	if *runAPITokenFile != "" {
+		data, err := os.ReadFile(*runAPITokenFile)
+		if err != nil {
+			return p, fmt.Errorf("--api-token-file: %w", err)
+		}
+		if p.Tokens, err = control.ParseTokens(data); err != nil {
+			return p, fmt.Errorf("--api-token-file %s: %w", *runAPITokenFile, err)
+		}
+		if len(p.Tokens) == 0 {
+			return p, fmt.Errorf("--api-token-file %s holds no tokens", *runAPITokenFile)
+		}
+	}
+	return p, nil
+}
+
+// adminTLS loads the mutual TLS configuration of the admin APIs, or returns
+// nil without --api-tls-cert. An API listening on a non-loopback address is
+// refused without it: remote clients must authenticate with certificates.
+func adminTLS() (*tls.Config, error) {
+	if *runAPITLSCert == "" {
+		if *runAPITLSKey != "" || *runAPIClientCA != "" || *runAPIClientNames != "" {
+			return nil, errors.New("the admin API TLS flags require --api-tls-cert")
+		}
+		for _, api := range []struct{ what, addr string }{
+			{"gRPC", *runGRPCListen},
+			{"REST", *runHTTPListen},
+		} {
+			if api.addr == "" {
+				continue
+			}
+			if err := checkLoopback(api.what, api.addr); err != nil {
+				return nil, fmt.Errorf("%w; set --api-tls-cert, --api-tls-key and --api-client-ca to serve it", err)
+			}
+		}
+		return nil, nil
+	}
+	if *runAPITLSKey == "" || *runAPIClientCA == "" {
+		return nil, errors.New("--api-tls-cert requires --api-tls-key and --api-client-ca")
+	}
+
+	files := &control.TLSFiles{Cert: *runAPITLSCert, Key: *runAPITLSKey, ClientCA: *runAPIClientCA}
+	for _, name := range strings.Split(*runAPIClientNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			files.ClientNames = append(files.ClientNames, name)
+		}
+	}
+	return control.ServerTLSConfig(files)
+}
+
+// adminServers are the control socket and the optional API, metrics and debug
+// listeners. An upgrade closes them so the new process can bind the same
+// addresses, and starts them again if it fails.
+type adminServers struct {
+	d *daemon
+	// tls is the mutual TLS configuration of the gRPC and REST APIs, if any
+	tls    *tls.Config
+	closer []func()
+}
+
+func (s *adminServers) start() error {
+	if err := s.d.ctrl.Start(); err != nil {
+		return err
+	}
+	s.closer = append(s.closer, func() { _ = s.d.ctrl.Close() })
+
+	if *runGRPCListen != "" {
+		closeGRPC, err := startGRPCServer(*runGRPCListen, s.d.ctrl, s.tls)
+		if err != nil {
+			s.close()
+			return fmt.Errorf("gRPC admin API: %w", err)
+		}
+		s.closer = append(s.closer, closeGRPC)
+		slog.Info("gRPC admin API listening", "addr", *runGRPCListen, "tls", s.tls != nil)
+	}
+
+	if *runHTTPListen != "" {
+		httpServer := control.NewHTTPServer(s.d.ctrl)
+		if s.tls != nil {
+			httpServer.SetTLSConfig(s.tls)
+		}
+		if err := httpServer.Start(*runHTTPListen); err != nil {
+			s.close()
+			return fmt.Errorf("REST admin API: %w", err)
+		}
+		s.closer = append(s.closer, func() { _ = httpServer.Close() })
+		slog.Info("REST admin API listening", "addr", *runHTTPListen, "tls", s.tls != nil)
+	}
+
+	if *runMetricsListen != "" {
+		metricsServer, err := startMetricsServer(*runMetricsListen, s.d.metrics)
+		if err != nil {
+			s.close()
+			return fmt.Errorf("metrics endpoint: %w", err)
+		}
+		s.closer = append(s.closer, func() { _ = metricsServer.Close() })
+		slog.Info("Metrics endpoint listening", "addr", *runMetricsListen)
+	}
+
+	if *runDebugListen != "" {
+		debugServer, err := startDebugServer(*runDebugListen, s.d)
+		if err != nil {
+			s.close()
+			return fmt.Errorf("debug server: %w", err)
+		}
+		s.closer = append(s.closer, func() { _ = debugServer.Close() })
+		slog.Info("Debug server listening", "addr", *runDebugListen)
+	}
+
+	return nil
+}
+
+// close stops the servers in the reverse order they were started
+func (s *adminServers) close() {
+	for i := len(s.closer) - 1; i >= 0; i-- {
+		s.closer[i]()
+	}
+	s.closer = nil
+}
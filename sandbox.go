@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// seccompActions are the --seccomp values other than off: what happens to a
+// system call outside the allowlist
+var seccompActions = map[string]uint32{
+	"log":     unix.SECCOMP_RET_LOG,
+	"enforce": unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM),
+}
+
+// sandboxedSyscalls are the system calls the daemon and the commands it runs
+// (ip(8) for the exec backend, the new process of an upgrade) make on every
+// architecture, on top of archSyscalls. Left out are those that change the
+// system rather than the process, e.g. mount, ptrace, bpf, module loading,
+// kexec, reboot, setns and unshare. The credential and scheduling calls are
+// in because an upgraded process goes through the same startup.
+var sandboxedSyscalls = []uintptr{
+	// Files
+	unix.SYS_READ, unix.SYS_WRITE, unix.SYS_READV, unix.SYS_WRITEV, unix.SYS_PREAD64, unix.SYS_PWRITE64,
+	unix.SYS_OPENAT, unix.SYS_CLOSE, unix.SYS_CLOSE_RANGE, unix.SYS_LSEEK, unix.SYS_FSTAT, unix.SYS_STATX,
+	unix.SYS_STATFS, unix.SYS_FSTATFS, unix.SYS_GETDENTS64, unix.SYS_READLINKAT, unix.SYS_FACCESSAT,
+	unix.SYS_FACCESSAT2, unix.SYS_FCHMOD, unix.SYS_FCHMODAT, unix.SYS_FCHOWN, unix.SYS_FCHOWNAT, unix.SYS_FSYNC,
+	unix.SYS_FDATASYNC, unix.SYS_FTRUNCATE, unix.SYS_TRUNCATE, unix.SYS_FLOCK, unix.SYS_FCNTL, unix.SYS_DUP,
+	unix.SYS_DUP3, unix.SYS_PIPE2, unix.SYS_IOCTL, unix.SYS_MKDIRAT, unix.SYS_UNLINKAT,
+	unix.SYS_RENAMEAT2, unix.SYS_LINKAT, unix.SYS_SYMLINKAT, unix.SYS_UTIMENSAT, unix.SYS_UMASK, unix.SYS_CHDIR,
+	unix.SYS_FCHDIR, unix.SYS_GETCWD, unix.SYS_SENDFILE, unix.SYS_SPLICE, unix.SYS_COPY_FILE_RANGE,
+	unix.SYS_INOTIFY_INIT1, unix.SYS_INOTIFY_ADD_WATCH, unix.SYS_INOTIFY_RM_WATCH,
+	// Memory
+	unix.SYS_MUNMAP, unix.SYS_MPROTECT, unix.SYS_MADVISE, unix.SYS_MREMAP, unix.SYS_MINCORE, unix.SYS_BRK,
+	unix.SYS_MLOCK, unix.SYS_MUNLOCK, unix.SYS_MLOCKALL, unix.SYS_MEMBARRIER,
+	// Processes and threads
+	unix.SYS_CLONE, unix.SYS_CLONE3, unix.SYS_EXECVE, unix.SYS_EXECVEAT, unix.SYS_EXIT, unix.SYS_EXIT_GROUP,
+	unix.SYS_WAIT4, unix.SYS_WAITID, unix.SYS_KILL, unix.SYS_TGKILL, unix.SYS_TKILL, unix.SYS_GETPID,
+	unix.SYS_GETTID, unix.SYS_GETPPID, unix.SYS_GETUID, unix.SYS_GETEUID, unix.SYS_GETGID, unix.SYS_GETEGID,
+	unix.SYS_GETRESUID, unix.SYS_GETRESGID, unix.SYS_GETGROUPS, unix.SYS_SETPGID, unix.SYS_GETPGID,
+	unix.SYS_SETSID, unix.SYS_GETSID, unix.SYS_PRCTL, unix.SYS_CAPGET, unix.SYS_CAPSET, unix.SYS_SETUID,
+	unix.SYS_SETGID, unix.SYS_SETGROUPS, unix.SYS_SETRESUID, unix.SYS_SETRESGID, unix.SYS_SET_TID_ADDRESS,
+	unix.SYS_SET_ROBUST_LIST, unix.SYS_GET_ROBUST_LIST, unix.SYS_RSEQ, unix.SYS_SCHED_YIELD,
+	unix.SYS_SCHED_GETAFFINITY, unix.SYS_SCHED_SETAFFINITY, unix.SYS_SCHED_GETATTR, unix.SYS_SCHED_SETATTR,
+	unix.SYS_SCHED_GETPARAM, unix.SYS_SCHED_GETSCHEDULER, unix.SYS_GETPRIORITY, unix.SYS_SETPRIORITY,
+	unix.SYS_PRLIMIT64, unix.SYS_GETRUSAGE, unix.SYS_UNAME, unix.SYS_SYSINFO, unix.SYS_GETRANDOM,
+	unix.SYS_PIDFD_OPEN, unix.SYS_PIDFD_SEND_SIGNAL, unix.SYS_SECCOMP, unix.SYS_LANDLOCK_CREATE_RULESET,
+	unix.SYS_LANDLOCK_ADD_RULE, unix.SYS_LANDLOCK_RESTRICT_SELF,
+	// Signals
+	unix.SYS_RT_SIGACTION, unix.SYS_RT_SIGPROCMASK, unix.SYS_RT_SIGRETURN, unix.SYS_RT_SIGSUSPEND,
+	unix.SYS_RT_SIGTIMEDWAIT, unix.SYS_SIGALTSTACK, unix.SYS_RESTART_SYSCALL,
+	// Time
+	unix.SYS_NANOSLEEP, unix.SYS_CLOCK_NANOSLEEP, unix.SYS_CLOCK_GETTIME, unix.SYS_CLOCK_GETRES,
+	unix.SYS_GETTIMEOFDAY, unix.SYS_SETITIMER, unix.SYS_GETITIMER, unix.SYS_TIMER_CREATE, unix.SYS_TIMER_SETTIME,
+	unix.SYS_TIMER_GETTIME, unix.SYS_TIMER_DELETE, unix.SYS_TIMERFD_CREATE, unix.SYS_TIMERFD_SETTIME,
+	unix.SYS_TIMERFD_GETTIME,
+	// Events
+	unix.SYS_EPOLL_CREATE1, unix.SYS_EPOLL_CTL, unix.SYS_EPOLL_PWAIT, unix.SYS_EPOLL_PWAIT2, unix.SYS_EVENTFD2,
+	unix.SYS_PPOLL, unix.SYS_PSELECT6, unix.SYS_SIGNALFD4, unix.SYS_FUTEX,
+	// Sockets: raw, netlink, the control socket and the admin listeners
+	unix.SYS_SOCKET, unix.SYS_SOCKETPAIR, unix.SYS_BIND, unix.SYS_CONNECT, unix.SYS_LISTEN, unix.SYS_ACCEPT4,
+	unix.SYS_GETSOCKNAME, unix.SYS_GETPEERNAME, unix.SYS_GETSOCKOPT, unix.SYS_SETSOCKOPT, unix.SYS_SENDTO,
+	unix.SYS_RECVFROM, unix.SYS_SENDMSG, unix.SYS_RECVMSG, unix.SYS_SENDMMSG, unix.SYS_RECVMMSG, unix.SYS_SHUTDOWN,
+}
+
+// seccompFilter assembles a seccomp program that allows syscalls and takes
+// action on every other call, and on calls through another ABI than the one
+// the binary was built for
+func seccompFilter(arch uint32, syscalls []uintptr, action uint32) ([]bpf.RawInstruction, error) {
+	if len(syscalls) > 255 {
+		return nil, fmt.Errorf("%d system calls do not fit in one jump", len(syscalls))
+	}
+	prog := []bpf.Instruction{
+		// struct seccomp_data: int nr, __u32 arch, ...
+		bpf.LoadAbsolute{Off: 4, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: arch, SkipTrue: 1},
+		bpf.RetConstant{Val: action},
+		bpf.LoadAbsolute{Off: 0, Size: 4},
+	}
+	for i, nr := range syscalls {
+		// Jump past the remaining comparisons and the action to the allow
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(nr), SkipTrue: uint8(len(syscalls) - i)})
+	}
+	prog = append(prog,
+		bpf.RetConstant{Val: action},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ALLOW},
+	)
+	return bpf.Assemble(prog)
+}
+
+// applySeccomp restricts every thread of the process, and the commands it
+// runs, to sandboxedSyscalls, as configured by --seccomp. The filter cannot
+// be removed, and setuid binaries lose their privileges under it.
+func applySeccomp(mode string) error {
+	if auditArch == 0 {
+		return withExitCode(exitUsage, fmt.Errorf("--seccomp is not supported on %s", runtime.GOARCH))
+	}
+	raw, err := seccompFilter(auditArch, slices.Concat(sandboxedSyscalls, archSyscalls), seccompActions[mode])
+	if err != nil {
+		return fmt.Errorf("failed to assemble seccomp filter: %w", err)
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// Without CAP_SYS_ADMIN a filter needs no_new_privs, which is per
+	// thread; TSYNC installs the filter and no_new_privs on the other
+	// threads from this one
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	r, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC,
+		uintptr(unsafe.Pointer(&prog)))
+	switch {
+	case errno != 0:
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	case r != 0:
+		return fmt.Errorf("failed to install seccomp filter: thread %d cannot be synchronized", r)
+	}
+	slog.Info("Seccomp filter installed", "mode", mode, "syscalls", len(sandboxedSyscalls)+len(archSyscalls))
+	return nil
+}
+
+// landlockWrites are the filesystem rights --landlock handles, and grants
+// only beneath the paths the daemon writes to, by Landlock ABI version
+var landlockWrites = []struct {
+	abi    int
+	access uint64
+}{
+	{1, unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK | unix.LANDLOCK_ACCESS_FS_MAKE_SYM},
+	{2, unix.LANDLOCK_ACCESS_FS_REFER},
+	{3, unix.LANDLOCK_ACCESS_FS_TRUNCATE},
+}
+
+// landlockFileRights are the rights a rule for a file rather than a
+// directory can grant
+const landlockFileRights = unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+
+// sandboxWritePaths are the files and directories the daemon writes to once
+// started: the lock directory, the directories of the pidfile, control
+// socket, log file, audit log and packet capture (which are replaced or
+// rotated), the metrics textfile directory, /dev/null for the commands it
+// runs, and --landlock-write
+func sandboxWritePaths() []string {
+	paths := []string{*runLockDir, filepath.Dir(*socketPath), os.DevNull}
+	if *runMetricsTextfileDir != "" {
+		paths = append(paths, *runMetricsTextfileDir)
+	}
+	for _, file := range []string{*runPidfile, *logFile, *runAuditLog, *runPcap} {
+		if file != "" {
+			paths = append(paths, filepath.Dir(file))
+		}
+	}
+	if *runLandlockWrite != "" {
+		for _, path := range strings.Split(*runLandlockWrite, ",") {
+			paths = append(paths, strings.TrimSpace(path))
+		}
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
+
+// applyLandlock lets every thread of the process, and the commands it runs,
+// write only beneath paths. Reading is not restricted.
+func applyLandlock(paths []string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("the kernel does not support Landlock: %w", errno)
+	}
+	var access uint64
+	for _, w := range landlockWrites {
+		if int(abi) >= w.abi {
+			access |= w.access
+		}
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: access}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create Landlock ruleset: %w", errno)
+	}
+	defer func() { _ = unix.Close(int(fd)) }()
+
+	for _, path := range paths {
+		if err := landlockAllow(int(fd), path, access); err != nil {
+			return err
+		}
+	}
+
+	// Landlock applies to the calling thread only, and needs no_new_privs
+	// there first
+	if err := allThreads("--landlock", syscall.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	if err := allThreads("--landlock", unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); err != nil {
+		return fmt.Errorf("failed to enforce Landlock ruleset: %w", err)
+	}
+	slog.Info("Landlock ruleset enforced", "abi", abi, "writable", paths)
+	return nil
+}
+
+// landlockAllow adds a rule granting access beneath path to a ruleset
+func landlockAllow(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for Landlock: %w", path, err)
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("failed to stat %s for Landlock: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileRights
+	}
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to allow writing beneath %s: %w", path, errno)
+	}
+	return nil
+}
+
+// applySandbox restricts the process as configured by --landlock and
+// --seccomp
+func applySandbox(seccomp string, landlock bool) error {
+	if landlock {
+		if err := applyLandlock(sandboxWritePaths()); err != nil {
+			return err
+		}
+	}
+	if seccomp != "off" {
+		return applySeccomp(seccomp)
+	}
+	return nil
+}
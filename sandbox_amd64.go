@@ -0,0 +1,18 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// auditArch identifies the system call ABI the binary uses to seccomp
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// archSyscalls are the system calls of this architecture only, mostly the
+// older forms the commands the daemon runs still use
+var archSyscalls = []uintptr{
+	unix.SYS_OPEN, unix.SYS_CREAT, unix.SYS_STAT, unix.SYS_LSTAT, unix.SYS_NEWFSTATAT, unix.SYS_ACCESS, unix.SYS_RENAMEAT,
+	unix.SYS_READLINK, unix.SYS_GETDENTS, unix.SYS_UNLINK, unix.SYS_RENAME, unix.SYS_MKDIR, unix.SYS_RMDIR,
+	unix.SYS_LINK, unix.SYS_SYMLINK, unix.SYS_CHMOD, unix.SYS_CHOWN, unix.SYS_LCHOWN, unix.SYS_UTIMES,
+	unix.SYS_FADVISE64, unix.SYS_DUP2, unix.SYS_PIPE, unix.SYS_MMAP, unix.SYS_ARCH_PRCTL, unix.SYS_FORK,
+	unix.SYS_VFORK, unix.SYS_GETPGRP, unix.SYS_ALARM, unix.SYS_PAUSE, unix.SYS_TIME, unix.SYS_GETRLIMIT,
+	unix.SYS_SETRLIMIT, unix.SYS_POLL, unix.SYS_SELECT, unix.SYS_EPOLL_CREATE, unix.SYS_EPOLL_WAIT,
+	unix.SYS_EVENTFD, unix.SYS_SIGNALFD, unix.SYS_INOTIFY_INIT,
+}
@@ -0,0 +1,31 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// auditArch identifies the system call ABI the binary uses to seccomp
+const auditArch = unix.AUDIT_ARCH_ARM
+
+// armSetTLS is the ARM private call with which the C library of the commands
+// the daemon runs sets up thread-local storage
+const armSetTLS = 0x0f0005
+
+// archSyscalls are the system calls of this architecture only: the 64-bit
+// file and time variants, the 32-bit UID calls and the older forms the
+// commands the daemon runs still use
+var archSyscalls = []uintptr{
+	unix.SYS_MMAP2, unix.SYS_FSTAT64, unix.SYS_STAT64, unix.SYS_LSTAT64, unix.SYS_FSTATAT64, unix.SYS_FCNTL64,
+	unix.SYS__LLSEEK, unix.SYS_STATFS64, unix.SYS_FSTATFS64, unix.SYS_TRUNCATE64, unix.SYS_FTRUNCATE64,
+	unix.SYS_SENDFILE64, unix.SYS_ARM_FADVISE64_64, unix.SYS_UGETRLIMIT, unix.SYS_SETRLIMIT,
+	unix.SYS_CLOCK_GETTIME64, unix.SYS_CLOCK_GETRES_TIME64, unix.SYS_CLOCK_NANOSLEEP_TIME64, unix.SYS_FUTEX_TIME64,
+	unix.SYS_TIMER_SETTIME64, unix.SYS_TIMER_GETTIME64, unix.SYS_TIMERFD_SETTIME64, unix.SYS_TIMERFD_GETTIME64,
+	unix.SYS_PPOLL_TIME64, unix.SYS_PSELECT6_TIME64, unix.SYS_RECVMMSG_TIME64, unix.SYS_RT_SIGTIMEDWAIT_TIME64,
+	unix.SYS_UTIMENSAT_TIME64, unix.SYS_GETUID32, unix.SYS_GETEUID32, unix.SYS_GETGID32, unix.SYS_GETEGID32,
+	unix.SYS_SETUID32, unix.SYS_SETGID32, unix.SYS_SETGROUPS32, unix.SYS_GETGROUPS32, unix.SYS_SETRESUID32,
+	unix.SYS_GETRESUID32, unix.SYS_SETRESGID32, unix.SYS_GETRESGID32, unix.SYS_FCHOWN32, unix.SYS_CHOWN32,
+	unix.SYS_LCHOWN32, unix.SYS_OPEN, unix.SYS_CREAT, unix.SYS_STAT, unix.SYS_LSTAT, unix.SYS_ACCESS,
+	unix.SYS_READLINK, unix.SYS_GETDENTS, unix.SYS_UNLINK, unix.SYS_RENAME, unix.SYS_RENAMEAT, unix.SYS_MKDIR,
+	unix.SYS_RMDIR, unix.SYS_LINK, unix.SYS_SYMLINK, unix.SYS_CHMOD, unix.SYS_CHOWN, unix.SYS_LCHOWN, unix.SYS_UTIMES,
+	unix.SYS_DUP2, unix.SYS_PIPE, unix.SYS_FORK, unix.SYS_VFORK, unix.SYS_GETPGRP, unix.SYS_PAUSE,
+	unix.SYS_SIGRETURN, unix.SYS_POLL, unix.SYS__NEWSELECT, unix.SYS_EPOLL_CREATE, unix.SYS_EPOLL_WAIT,
+	unix.SYS_EVENTFD, unix.SYS_SIGNALFD, unix.SYS_INOTIFY_INIT, unix.SYS_SEND, unix.SYS_RECV, armSetTLS,
+}
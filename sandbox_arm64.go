@@ -0,0 +1,11 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// auditArch identifies the system call ABI the binary uses to seccomp
+const auditArch = unix.AUDIT_ARCH_AARCH64
+
+// archSyscalls are the system calls of this architecture only
+var archSyscalls = []uintptr{
+	unix.SYS_FSTATAT, unix.SYS_RENAMEAT, unix.SYS_MMAP, unix.SYS_FADVISE64, unix.SYS_GETRLIMIT, unix.SYS_SETRLIMIT,
+}
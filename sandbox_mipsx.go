@@ -0,0 +1,32 @@
+//go:build mips || mipsle
+
+package main
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// auditArch identifies the system call ABI the binary uses to seccomp, which
+// tells the byte orders apart
+var auditArch = map[string]uint32{"mips": unix.AUDIT_ARCH_MIPS, "mipsle": unix.AUDIT_ARCH_MIPSEL}[runtime.GOARCH]
+
+// archSyscalls are the system calls of this architecture only: the 64-bit
+// file and time variants, the MIPS thread-local storage setup and the older
+// forms the commands the daemon runs still use
+var archSyscalls = []uintptr{
+	unix.SYS_MMAP, unix.SYS_MMAP2, unix.SYS_FSTAT64, unix.SYS_STAT64, unix.SYS_LSTAT64, unix.SYS_FSTATAT64,
+	unix.SYS_FCNTL64, unix.SYS__LLSEEK, unix.SYS_STATFS64, unix.SYS_FSTATFS64, unix.SYS_TRUNCATE64,
+	unix.SYS_FTRUNCATE64, unix.SYS_SENDFILE64, unix.SYS_FADVISE64, unix.SYS_GETRLIMIT, unix.SYS_SETRLIMIT,
+	unix.SYS_CLOCK_GETTIME64, unix.SYS_CLOCK_GETRES_TIME64, unix.SYS_CLOCK_NANOSLEEP_TIME64, unix.SYS_FUTEX_TIME64,
+	unix.SYS_TIMER_SETTIME64, unix.SYS_TIMER_GETTIME64, unix.SYS_TIMERFD_SETTIME64, unix.SYS_TIMERFD_GETTIME64,
+	unix.SYS_PPOLL_TIME64, unix.SYS_PSELECT6_TIME64, unix.SYS_RECVMMSG_TIME64, unix.SYS_RT_SIGTIMEDWAIT_TIME64,
+	unix.SYS_UTIMENSAT_TIME64, unix.SYS_SET_THREAD_AREA, unix.SYS_CACHEFLUSH, unix.SYS_OPEN, unix.SYS_CREAT,
+	unix.SYS_STAT, unix.SYS_LSTAT, unix.SYS_ACCESS, unix.SYS_READLINK, unix.SYS_GETDENTS, unix.SYS_UNLINK,
+	unix.SYS_RENAME, unix.SYS_RENAMEAT, unix.SYS_MKDIR, unix.SYS_RMDIR, unix.SYS_LINK, unix.SYS_SYMLINK, unix.SYS_CHMOD,
+	unix.SYS_CHOWN, unix.SYS_LCHOWN, unix.SYS_UTIMES, unix.SYS_DUP2, unix.SYS_PIPE, unix.SYS_FORK,
+	unix.SYS_GETPGRP, unix.SYS_ALARM, unix.SYS_PAUSE, unix.SYS_TIME, unix.SYS_SIGRETURN, unix.SYS_POLL,
+	unix.SYS__NEWSELECT, unix.SYS_EPOLL_CREATE, unix.SYS_EPOLL_WAIT, unix.SYS_EVENTFD, unix.SYS_SIGNALFD,
+	unix.SYS_INOTIFY_INIT, unix.SYS_SEND, unix.SYS_RECV,
+}
@@ -0,0 +1,8 @@
+//go:build !amd64 && !arm64 && !arm && !mips && !mipsle
+
+package main
+
+// auditArch is unknown: --seccomp is not supported on this architecture
+const auditArch = 0
+
+var archSyscalls []uintptr
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/config"
+)
+
+var (
+	configCmd          = app.Command("config", "Configuration file tools")
+	configSchemaCmd    = configCmd.Command("schema", "Print the JSON Schema of the configuration file")
+	configSchemaFormat = configSchemaCmd.Flag("format",
+		"json for editors and validators, markdown for a field reference").Default("json").Enum("json", "markdown")
+)
+
+// printConfigSchema prints the schema generated from config.File
+func printConfigSchema() {
+	s := config.Schema()
+	if *configSchemaFormat == "markdown" {
+		printSchemaMarkdown(s)
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		exitWithError(err)
+	}
+}
+
+// printSchemaMarkdown prints a table of every field, by path
+func printSchemaMarkdown(s *config.JSONSchema) {
+	fmt.Println("| Field | Type | Required | Default | Description |")
+	fmt.Println("|---|---|---|---|---|")
+	var walk func(s *config.JSONSchema, path string)
+	walk = func(s *config.JSONSchema, path string) {
+		if s.Items != nil && s.Items.Type == "object" {
+			s = s.Items
+			path += "[]"
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			p := s.Properties[name]
+			field := strings.TrimPrefix(path+"."+name, ".")
+			required := ""
+			if slices.Contains(s.Required, name) {
+				required = "yes"
+			}
+			def := ""
+			if p.Default != nil {
+				def = fmt.Sprintf("`%v`", p.Default)
+			}
+			fmt.Printf("| `%s` | %s | %s | %s | %s |\n", field, schemaType(p), required, def, schemaText(p))
+			walk(p, field)
+		}
+	}
+	walk(s, "")
+}
+
+// schemaType names the type of a field, with the type of the items of an
+// array
+func schemaType(s *config.JSONSchema) string {
+	if s.Items != nil {
+		return schemaType(s.Items) + " array"
+	}
+	if s.Format != "" {
+		return s.Type + " (" + s.Format + ")"
+	}
+	return s.Type
+}
+
+// schemaText is the description of a field with its allowed values
+func schemaText(s *config.JSONSchema) string {
+	text := s.Description
+	switch {
+	case len(s.Enum) > 0:
+		text += " (" + strings.Join(s.Enum, ", ") + ")"
+	case s.Minimum != nil && s.Maximum != nil:
+		text += fmt.Sprintf(" (%d-%d)", *s.Minimum, *s.Maximum)
+	}
+	return text
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var (
+	installServiceCmd    = app.Command("install-service", "Write a hardened systemd unit running the daemon")
+	installServiceConfig = installServiceCmd.Flag("config", "Configuration file the service runs with").
+				Short('c').Required().ExistingFile()
+	installServiceUnit = installServiceCmd.Flag("unit", "Unit file to write").
+				Default("/etc/systemd/system/vrrp-simple.service").String()
+	installServicePrint = installServiceCmd.Flag("print", "Print the unit instead of writing it").Bool()
+	installServiceForce = installServiceCmd.Flag("force", "Overwrite an existing unit file").Bool()
+)
+
+var serviceTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=vrrp-simple VRRP daemon
+Documentation=https://github.com/tokuhirom/vrrp-simple
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+ExecStart={{.Binary}}{{if .Socket}} --socket {{.Socket}}{{end}} run --config {{.Config}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=2s
+# Usage, config, permission, interface and conflict errors need an operator
+RestartPreventExitStatus=2 3 4 5 6
+
+# Raw sockets for advertisements, netlink for VIPs and IPVS
+CapabilityBoundingSet=CAP_NET_ADMIN CAP_NET_RAW
+AmbientCapabilities=CAP_NET_ADMIN CAP_NET_RAW
+NoNewPrivileges=yes
+ProtectSystem=strict
+ReadWritePaths={{.SocketDir}}
+# Per-instance lock files (run --lock-dir)
+RuntimeDirectory=vrrp-simple
+ProtectHome=yes
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX AF_NETLINK
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func installService() {
+	unit, err := renderServiceUnit()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if *installServicePrint {
+		_, _ = os.Stdout.Write(unit)
+		return
+	}
+
+	if !*installServiceForce {
+		if _, err := os.Stat(*installServiceUnit); err == nil {
+			exitWithError(fmt.Errorf("%s already exists (use --force to overwrite)", *installServiceUnit))
+		} else if !errors.Is(err, os.ErrNotExist) {
+			exitWithError(err)
+		}
+	}
+
+	if err := os.WriteFile(*installServiceUnit, unit, 0o644); err != nil {
+		exitWithError(err)
+	}
+
+	name := filepath.Base(*installServiceUnit)
+	fmt.Printf("Wrote %s\n", *installServiceUnit)
+	fmt.Printf("Enable it with: systemctl daemon-reload && systemctl enable --now %s\n", name)
+}
+
+func renderServiceUnit() ([]byte, error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the vrrp binary: %w", err)
+	}
+
+	cfg, err := filepath.Abs(*installServiceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	sock, err := filepath.Abs(*socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Binary, Config, Socket, SocketDir string
+	}{
+		Binary:    binary,
+		Config:    cfg,
+		SocketDir: filepath.Dir(sock),
+	}
+	if sock != control.DefaultSocketPath {
+		data.Socket = sock
+	}
+
+	var buf bytes.Buffer
+	if err := serviceTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
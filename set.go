@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var (
+	setCmd       = app.Command("set", "Change parameters of a running instance")
+	setInterface = setCmd.Flag("interface", "Network interface").Short('i').HintAction(interfaceNames).String()
+	setVRID      = setCmd.Flag("vrid", "Virtual Router ID").Short('r').Uint8()
+	setPriority  = setCmd.Flag("priority", "Router priority (1-255)").Short('p').Uint8()
+	setInterval  = setCmd.Flag("advert-int", "Advertisement interval in seconds").Int()
+
+	setPreemptGiven bool
+	setPreempt      = setCmd.Flag("preempt", "Enable or disable (--no-preempt) preemption").
+			IsSetByUser(&setPreemptGiven).Bool()
+
+	setDisableTracker = setCmd.Flag("disable-tracker", "Stop the named tracker from lowering the priority").
+				String()
+	setEnableTracker = setCmd.Flag("enable-tracker", "Let the named tracker lower the priority again").String()
+)
+
+func setParameters() {
+	req := &control.Request{
+		Command:        control.CommandSet,
+		Interface:      *setInterface,
+		VRID:           *setVRID,
+		Priority:       *setPriority,
+		AdvertInterval: *setInterval,
+	}
+	if setPreemptGiven {
+		req.Preempt = setPreempt
+	}
+	switch {
+	case *setDisableTracker != "" && *setEnableTracker != "":
+		app.Fatalf("--disable-tracker and --enable-tracker cannot be combined")
+	case *setDisableTracker != "":
+		req.Tracker = *setDisableTracker
+	case *setEnableTracker != "":
+		req.Tracker, req.TrackerEnabled = *setEnableTracker, true
+	}
+
+	resp, err := control.NewClient(*socketPath).Do(req)
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Println(resp.Message)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/simulate"
+)
+
+var (
+	simulateCmd      = app.Command("simulate", "Run a scripted election between in-process routers")
+	simulateScenario = simulateCmd.Arg("scenario", "Scenario file").Required().ExistingFile()
+	simulateSpeed    = simulateCmd.Flag("speed", "Run this many times faster than real time").Default("10").Float64()
+	simulateOutput   = simulateCmd.Flag("output", "Output format").Short('o').Default("text").Enum("text", "json")
+	simulateExpect   = simulateCmd.Flag("expect-master",
+		"Exit non-zero unless exactly this router is MASTER at the end").String()
+)
+
+// simulateEntry is the JSON form of a timeline entry
+type simulateEntry struct {
+	At       float64 `json:"at"`
+	Router   string  `json:"router"`
+	OldState string  `json:"old_state,omitempty"`
+	NewState string  `json:"new_state,omitempty"`
+	Action   string  `json:"action,omitempty"`
+	Detail   string  `json:"detail,omitempty"`
+}
+
+func runSimulation() {
+	if *simulateSpeed <= 0 {
+		app.Fatalf("--speed must be positive")
+	}
+
+	s, err := simulate.Load(*simulateScenario)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if *simulateOutput == "text" {
+		fmt.Printf("Simulating %d routers for %gs (%gx speed)\n\n", len(s.Routers), s.Duration, *simulateSpeed)
+	}
+
+	res, err := simulate.Run(s, simulate.Options{Speed: *simulateSpeed})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	masters := res.Masters(s)
+	if *simulateOutput == "json" {
+		printSimulationJSON(s, res, masters)
+	} else {
+		printSimulationText(s, res, masters)
+	}
+
+	if *simulateExpect != "" && (len(masters) != 1 || masters[0] != *simulateExpect) {
+		exitWithError(fmt.Errorf("expected %s to be MASTER, got [%s]", *simulateExpect, strings.Join(masters, ", ")))
+	}
+}
+
+func printSimulationText(s *simulate.Scenario, res *simulate.Result, masters []string) {
+	width := 0
+	for _, r := range s.Routers {
+		width = max(width, len(r.Name))
+	}
+
+	for _, e := range res.Timeline {
+		at := fmt.Sprintf("%9.3fs", e.At.Seconds())
+		if e.IsTransition() {
+			fmt.Printf("%s  %-*s  %s -> %s\n", at, width, e.Router, e.Old, e.New)
+			continue
+		}
+		line := fmt.Sprintf("%s  %-*s  ** %s", at, width, e.Router, e.Action)
+		if e.Detail != "" {
+			line += " (" + e.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Println()
+	fmt.Println("Final states:")
+	for _, r := range s.Routers {
+		fmt.Printf("  %-*s  %s\n", width, r.Name, res.Final[r.Name])
+	}
+
+	switch len(masters) {
+	case 0:
+		fmt.Println("\nWARNING: no router is MASTER")
+	case 1:
+	default:
+		fmt.Printf("\nWARNING: split brain, several MASTERs: %s\n", strings.Join(masters, ", "))
+	}
+}
+
+func printSimulationJSON(s *simulate.Scenario, res *simulate.Result, masters []string) {
+	out := struct {
+		Timeline []simulateEntry   `json:"timeline"`
+		Final    map[string]string `json:"final"`
+		Masters  []string          `json:"masters"`
+	}{
+		Timeline: []simulateEntry{},
+		Final:    make(map[string]string),
+		Masters:  masters,
+	}
+	if out.Masters == nil {
+		out.Masters = []string{}
+	}
+
+	for _, e := range res.Timeline {
+		entry := simulateEntry{
+			At:     e.At.Round(time.Millisecond).Seconds(),
+			Router: e.Router,
+			Action: e.Action,
+			Detail: e.Detail,
+		}
+		if e.IsTransition() {
+			entry.OldState = e.Old.String()
+			entry.NewState = e.New.String()
+		}
+		out.Timeline = append(out.Timeline, entry)
+	}
+	for _, r := range s.Routers {
+		out.Final[r.Name] = res.Final[r.Name].String()
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		exitWithError(err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+var (
+	statsCmd       = app.Command("stats", "Show protocol counters and per-instance statistics")
+	statsInterface = statsCmd.Flag("interface", "Network interface").Short('i').HintAction(interfaceNames).String()
+	statsVRID      = statsCmd.Flag("vrid", "Virtual Router ID").Short('r').Uint8()
+	statsOutput    = statsCmd.Flag("output", "Output format").Short('o').Default(outputTable).Enum(outputFormats...)
+	statsReset     = statsCmd.Flag("reset", "Zero the counters after showing them").Bool()
+)
+
+func counterColumn(header string, value func(control.InstanceStats) uint64) column[control.InstanceStats] {
+	return column[control.InstanceStats]{
+		header: header,
+		value:  func(s control.InstanceStats) string { return strconv.FormatUint(value(s), 10) },
+	}
+}
+
+var statsColumns = []column[control.InstanceStats]{
+	{header: "INTERFACE", value: func(s control.InstanceStats) string { return s.Interface }},
+	{header: "VRID", value: func(s control.InstanceStats) string { return strconv.Itoa(int(s.VRID)) }},
+	{header: "STATE", value: func(s control.InstanceStats) string { return s.State }},
+	{header: "IN STATE", value: func(s control.InstanceStats) string { return s.StateUptime }},
+	counterColumn("TRANSITIONS", func(s control.InstanceStats) uint64 { return s.Transitions }),
+	counterColumn("TX", func(s control.InstanceStats) uint64 { return s.AdvertsSent }),
+	counterColumn("RX", func(s control.InstanceStats) uint64 { return s.AdvertsReceived }),
+	counterColumn("BECAME MASTER", func(s control.InstanceStats) uint64 { return s.BecomeMaster }),
+	counterColumn("PRIO0 TX", func(s control.InstanceStats) uint64 { return s.PriorityZeroSent }),
+	counterColumn("PRIO0 RX", func(s control.InstanceStats) uint64 { return s.PriorityZeroReceived }),
+	counterColumn("CKSUM ERR", func(s control.InstanceStats) uint64 { return s.ChecksumErrors }),
+	counterColumn("TTL ERR", func(s control.InstanceStats) uint64 { return s.TTLErrors }),
+	counterColumn("OTHER VRID", func(s control.InstanceStats) uint64 { return s.VRIDMismatches }),
+	counterColumn("OFF LINK", func(s control.InstanceStats) uint64 { return s.OffLinkAdverts }),
+	counterColumn("OTHER VER", func(s control.InstanceStats) uint64 { return s.ForeignVersion }),
+	counterColumn("MCAST REJOIN", func(s control.InstanceStats) uint64 { return s.MembershipLost }),
+	counterColumn("THROTTLED", func(s control.InstanceStats) uint64 { return s.Drops[vrrp.DropThrottled] }),
+	counterColumn("AUTH ERR", func(s control.InstanceStats) uint64 { return s.Drops[vrrp.DropAuth] }),
+	counterColumn("INTERVAL ERR", func(s control.InstanceStats) uint64 { return s.AdvIntervalErrors }),
+	counterColumn("ADDR ERR", func(s control.InstanceStats) uint64 { return s.AddressListErrors }),
+	counterColumn("DROPPED", func(s control.InstanceStats) uint64 { return s.PacketsDropped }),
+	{header: "DROPS BY REASON", wide: true, value: dropsByReason},
+	counterColumn("VIP ERR", func(s control.InstanceStats) uint64 { return s.VIPErrors }),
+	counterColumn("SPLIT BRAIN", func(s control.InstanceStats) uint64 { return s.SplitBrains }),
+	{header: "JITTER P99", value: worstJitter},
+	{header: "FAILOVER P99", value: func(s control.InstanceStats) string { return latencyCell(s.FailoverLatency) }},
+}
+
+// latencyCell shows a latency percentile, or "-" before the first observation
+func latencyCell(ls control.LatencyStats) string {
+	if ls.Count == 0 {
+		return "-"
+	}
+	return ls.P99
+}
+
+// dropsByReason lists the reasons packets were ignored for, e.g.
+// "checksum=2 own=41", or "-" if none were
+func dropsByReason(s control.InstanceStats) string {
+	var parts []string
+	for _, reason := range vrrp.DropReasons {
+		if n := s.Drops[reason]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", reason, n))
+		}
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, " ")
+}
+
+// worstJitter shows the highest 99th percentile jitter of any peer
+func worstJitter(s control.InstanceStats) string {
+	worst, cell := time.Duration(-1), "-"
+	for _, ls := range s.Jitter {
+		if d, err := time.ParseDuration(ls.P99); err == nil && ls.Count > 0 && d > worst {
+			worst, cell = d, ls.P99
+		}
+	}
+	return cell
+}
+
+func showStats() {
+	resp, err := control.NewClient(*socketPath).Do(&control.Request{
+		Command:   control.CommandStats,
+		Interface: *statsInterface,
+		VRID:      *statsVRID,
+		Reset:     *statsReset,
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if len(resp.Stats) == 0 && *statsOutput != outputJSON {
+		fmt.Println("No matching VRRP instances")
+		return
+	}
+
+	if resp.Stats == nil {
+		resp.Stats = []control.InstanceStats{}
+	}
+
+	if err := printRows(os.Stdout, *statsOutput, resp.Stats, statsColumns); err != nil {
+		exitWithError(err)
+	}
+}
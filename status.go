@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var (
+	statusCmd       = app.Command("status", "Show VRRP status")
+	statusInterface = statusCmd.Flag("interface", "Network interface").Short('i').HintAction(interfaceNames).String()
+	statusVRID      = statusCmd.Flag("vrid", "Virtual Router ID").Short('r').Uint8()
+	statusOutput    = statusCmd.Flag("output", "Output format").Short('o').Default(outputTable).Enum(outputFormats...)
+	statusPeers     = statusCmd.Flag("peers", "List every router heard for each VRID instead").Bool()
+)
+
+var statusColumns = []column[control.InstanceStatus]{
+	{header: "INTERFACE", value: func(is control.InstanceStatus) string { return is.Interface }},
+	{header: "VRID", value: func(is control.InstanceStatus) string { return strconv.Itoa(int(is.VRID)) }},
+	{header: "STATE", value: func(is control.InstanceStatus) string {
+		// An observer is always BACKUP; say why
+		if is.Observe && is.State == "BACKUP" {
+			return "OBSERVING"
+		}
+		return is.State
+	}},
+	{header: "PRIORITY", value: func(is control.InstanceStatus) string { return strconv.Itoa(int(is.Priority)) }},
+	{header: "VIPS", value: func(is control.InstanceStatus) string { return strings.Join(is.VirtualIPs, ",") }},
+	{header: "MASTER", value: func(is control.InstanceStatus) string { return orDash(is.MasterIP) }},
+	{header: "UPTIME", value: func(is control.InstanceStatus) string { return orDash(is.Uptime) }},
+	{header: "LAST ADVERT", value: func(is control.InstanceStatus) string {
+		if is.Master == nil {
+			return "-"
+		}
+		return formatAgo(is.Master.LastSeen)
+	}},
+	{header: "EXCLUDED", wide: true, value: func(is control.InstanceStatus) string {
+		return orDash(strings.Join(is.ExcludedIPs, ","))
+	}},
+	{header: "MASTER PRIO", wide: true, value: func(is control.InstanceStatus) string {
+		if is.Master == nil {
+			return "-"
+		}
+		return strconv.Itoa(int(is.Master.Priority))
+	}},
+	{header: "MASTER INTERVAL", wide: true, value: func(is control.InstanceStatus) string {
+		if is.Master == nil {
+			return "-"
+		}
+		return orDash(is.Master.AdvertInterval)
+	}},
+	{header: "MASTER VIPS", wide: true, value: func(is control.InstanceStatus) string {
+		if is.Master == nil || is.Master.VirtualIPs == nil {
+			return "-"
+		}
+		return strings.Join(is.Master.VirtualIPs, ",")
+	}},
+	{header: "LAST TRANSITION", wide: true, value: func(is control.InstanceStatus) string {
+		return formatTime(is.LastTransition)
+	}},
+	{header: "SYNC GROUP", wide: true, value: func(is control.InstanceStatus) string { return orDash(is.SyncGroup) }},
+	{header: "PAIR", wide: true, value: func(is control.InstanceStatus) string {
+		if is.Pair == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%s (master %s, partner %d)", is.Pair.Name, is.Pair.Master, is.Pair.PartnerVRID)
+	}},
+	{header: "PEER", wide: true, value: func(is control.InstanceStatus) string { return formatPeer(is.Peer) }},
+	{header: "TX", wide: true, value: func(is control.InstanceStatus) string {
+		return strconv.FormatUint(is.AdvertsSent, 10)
+	}},
+	{header: "RX", wide: true, value: func(is control.InstanceStatus) string {
+		return strconv.FormatUint(is.AdvertsReceived, 10)
+	}},
+	{header: "DROPPED", wide: true, value: func(is control.InstanceStatus) string {
+		return strconv.FormatUint(is.PacketsDropped, 10)
+	}},
+	{header: "PEERS", wide: true, value: func(is control.InstanceStatus) string { return strconv.Itoa(len(is.Peers)) }},
+	{header: "TRACKERS", wide: true, value: func(is control.InstanceStatus) string {
+		var failed, disabled []string
+		for _, t := range is.Trackers {
+			switch {
+			case t.Disabled:
+				disabled = append(disabled, t.Name)
+			case !t.Healthy:
+				failed = append(failed, t.Name)
+			}
+		}
+		var s string
+		switch {
+		case len(is.Trackers) == 0:
+			return "-"
+		case len(failed) == 0:
+			s = fmt.Sprintf("%d ok", len(is.Trackers)-len(disabled))
+		default:
+			s = "failed: " + strings.Join(failed, ",")
+		}
+		if len(disabled) != 0 {
+			s += " disabled: " + strings.Join(disabled, ",")
+		}
+		return s
+	}},
+}
+
+// peerRow is one entry of an instance's peer table, for --peers
+type peerRow struct {
+	Interface string `json:"interface"`
+	VRID      uint8  `json:"vrid"`
+	control.KnownPeerStatus
+}
+
+var peerColumns = []column[peerRow]{
+	{header: "INTERFACE", value: func(p peerRow) string { return p.Interface }},
+	{header: "VRID", value: func(p peerRow) string { return strconv.Itoa(int(p.VRID)) }},
+	{header: "ROUTER", value: func(p peerRow) string { return p.SourceIP }},
+	{header: "PRIORITY", value: func(p peerRow) string { return strconv.Itoa(int(p.Priority)) }},
+	{header: "VERSION", value: func(p peerRow) string { return strconv.Itoa(int(p.Version)) }},
+	{header: "INTERVAL", value: func(p peerRow) string { return orDash(p.AdvertInterval) }},
+	{header: "ADVERTS", value: func(p peerRow) string { return strconv.FormatUint(p.Adverts, 10) }},
+	{header: "LAST SEEN", value: func(p peerRow) string { return formatAgo(p.LastSeen) }},
+	{header: "FIRST SEEN", wide: true, value: func(p peerRow) string { return formatTime(p.FirstSeen) }},
+}
+
+func showStatus() {
+	resp, err := control.NewClient(*socketPath).Do(&control.Request{
+		Command:   control.CommandStatus,
+		Interface: *statusInterface,
+		VRID:      *statusVRID,
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if len(resp.Instances) == 0 && *statusOutput != outputJSON {
+		fmt.Println("No matching VRRP instances")
+		return
+	}
+
+	if resp.Instances == nil {
+		resp.Instances = []control.InstanceStatus{}
+	}
+
+	if *statusPeers {
+		rows := []peerRow{}
+		for _, is := range resp.Instances {
+			for _, p := range is.Peers {
+				rows = append(rows, peerRow{Interface: is.Interface, VRID: is.VRID, KnownPeerStatus: p})
+			}
+		}
+		if len(rows) == 0 && *statusOutput != outputJSON {
+			fmt.Println("No routers heard")
+			return
+		}
+		if err := printRows(os.Stdout, *statusOutput, rows, peerColumns); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	if err := printRows(os.Stdout, *statusOutput, resp.Instances, statusColumns); err != nil {
+		exitWithError(err)
+	}
+}
+
+func formatPeer(p *control.PeerStatus) string {
+	if p == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s (prio %d, %s)", p.SourceIP, p.Priority, formatAgo(p.LastSeen))
+}
@@ -0,0 +1,95 @@
+//go:build crossbuild
+// +build crossbuild
+
+// Package crossbuild checks that the daemon builds for the embedded targets
+// it is run on, and runs the protocol tests there when qemu-user is
+// installed. It needs only the Go toolchain, so it runs the same anywhere:
+//
+//	go test -tags crossbuild -v ./test/crossbuild
+package crossbuild
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// target is a GOOS=linux platform of OpenWrt-class routers
+type target struct {
+	name string
+	env  []string
+	// qemu is the qemu-user emulator that runs its binaries
+	qemu string
+}
+
+var targets = []target{
+	// MIPS routers mostly lack an FPU
+	{"mips", []string{"GOARCH=mips", "GOMIPS=softfloat"}, "qemu-mips"},
+	{"mipsle", []string{"GOARCH=mipsle", "GOMIPS=softfloat"}, "qemu-mipsel"},
+	{"armv7", []string{"GOARCH=arm", "GOARM=7"}, "qemu-arm"},
+	{"arm64", []string{"GOARCH=arm64"}, "qemu-aarch64"},
+}
+
+// packages are run under qemu: the packet format and the election, where
+// byte order and 32-bit alignment would show
+var packages = []string{"./pkg/vrrp", "./pkg/vrrptest", "./pkg/config"}
+
+func goTool(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	path, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("no go tool: %v", err)
+	}
+	return path
+}
+
+func run(t *testing.T, tg target, args ...string) {
+	t.Helper()
+	cmd := exec.Command(goTool(t), args...)
+	cmd.Dir = filepath.Join("..", "..")
+	cmd.Env = append(os.Environ(), "GOOS=linux", "CGO_ENABLED=0")
+	cmd.Env = append(cmd.Env, tg.env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	for _, tg := range targets {
+		t.Run(tg.name, func(t *testing.T) {
+			for _, tags := range []string{"", "small"} {
+				run(t, tg, "build", "-tags", tags, "-o", filepath.Join(t.TempDir(), "vrrp"), ".")
+			}
+			run(t, tg, "vet", "./...")
+		})
+	}
+}
+
+func TestUnderQEMU(t *testing.T) {
+	for _, tg := range targets {
+		t.Run(tg.name, func(t *testing.T) {
+			qemu, err := exec.LookPath(tg.qemu)
+			if err != nil {
+				t.Skipf("%s not installed", tg.qemu)
+			}
+			for _, pkg := range packages {
+				bin := filepath.Join(t.TempDir(), filepath.Base(pkg)+".test")
+				run(t, tg, "test", "-c", "-o", bin, pkg)
+
+				// Tests that need root or a real interface skip themselves
+				cmd := exec.Command(qemu, bin, "-test.short")
+				cmd.Dir = filepath.Join("..", "..", pkg)
+				if out, err := cmd.CombinedOutput(); err != nil {
+					t.Errorf("%s under %s: %v\n%s", pkg, tg.qemu, err, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+//go:build integration && interop
+// +build integration,interop
+
+package integration
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// keepalivedConfig is a single VRRPv2 instance without authentication, as
+// the daemon speaks it. The notify script records every state keepalived
+// enters.
+const keepalivedConfig = `global_defs {
+    router_id vrrp-interop
+    script_user root
+}
+
+vrrp_instance VI_%[2]d {
+    state BACKUP
+    interface %[1]s
+    virtual_router_id %[2]d
+    priority %[3]d
+    advert_int 1
+    notify %[5]s
+    virtual_ipaddress {
+        %[4]s/32
+    }
+}
+`
+
+// keepalivedNotify is called by keepalived as: notify.sh TYPE NAME STATE
+// PRIORITY
+const keepalivedNotify = `#!/bin/sh
+echo "$3" > %s
+`
+
+// Keepalived is a keepalived process running one VRRP instance in a
+// namespace
+type Keepalived struct {
+	Namespace Namespace
+	VRID      uint8
+	Priority  uint8
+	VIP       string
+
+	t         *testing.T
+	stateFile string
+	cmd       *exec.Cmd
+	output    *syncBuffer
+	done      chan struct{}
+}
+
+// StartKeepalived starts keepalived with its VRRP subsystem only, in the
+// foreground and logging to the console. It is stopped when the test ends,
+// and its output is logged if the test failed.
+func StartKeepalived(t *testing.T, ns Namespace, vrid, priority uint8, vip string) *Keepalived {
+	t.Helper()
+	path, err := exec.LookPath("keepalived")
+	if err != nil {
+		t.Fatalf("The interop tests need keepalived: %v", err)
+	}
+
+	dir := t.TempDir()
+	k := &Keepalived{
+		Namespace: ns,
+		VRID:      vrid,
+		Priority:  priority,
+		VIP:       vip,
+		t:         t,
+		stateFile: filepath.Join(dir, "state"),
+		output:    &syncBuffer{},
+		done:      make(chan struct{}),
+	}
+
+	notify := filepath.Join(dir, "notify.sh")
+	if err := os.WriteFile(notify, []byte(fmt.Sprintf(keepalivedNotify, k.stateFile)), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "keepalived.conf")
+	body := fmt.Sprintf(keepalivedConfig, ns.Interface, vrid, priority, vip, notify)
+	if err := os.WriteFile(conf, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each keepalived needs its own PID files, or a second one refuses to
+	// start
+	k.cmd = exec.Command("ip", "netns", "exec", ns.Name, path,
+		"--dont-fork", "--log-console", "--log-detail", "--vrrp",
+		"--use-file", conf,
+		"--pid", filepath.Join(dir, "keepalived.pid"),
+		"--vrrp_pid", filepath.Join(dir, "vrrp.pid"),
+		"--checkers_pid", filepath.Join(dir, "checkers.pid"),
+	)
+	k.cmd.Stdout = k.output
+	k.cmd.Stderr = k.output
+	if err := k.cmd.Start(); err != nil {
+		t.Fatalf("Failed to start keepalived: %v", err)
+	}
+	go func() {
+		_ = k.cmd.Wait()
+		close(k.done)
+	}()
+
+	t.Cleanup(func() {
+		k.Stop()
+		if t.Failed() {
+			t.Logf("Output of %s:\n%s", k, k.output.String())
+		}
+	})
+
+	// keepalived always enters BACKUP first
+	WaitFor(t, 10*time.Second, fmt.Sprintf("%s to start", k), func() (bool, string) {
+		if k.exited() {
+			t.Fatalf("%s exited:\n%s", k, k.output.String())
+		}
+		state := k.State()
+		return state != "", "no state yet"
+	})
+	return k
+}
+
+func (k *Keepalived) String() string {
+	return fmt.Sprintf("keepalived VRID %d in %s (priority %d)", k.VRID, k.Namespace.Name, k.Priority)
+}
+
+func (k *Keepalived) exited() bool {
+	select {
+	case <-k.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// State returns the last state keepalived notified, empty before the first
+func (k *Keepalived) State() string {
+	b, err := os.ReadFile(k.stateFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// WaitForState waits until keepalived has notified state, failing the test
+// after timeout
+func (k *Keepalived) WaitForState(state string, timeout time.Duration) {
+	k.t.Helper()
+	WaitFor(k.t, timeout, fmt.Sprintf("%s to be %s", k, state), func() (bool, string) {
+		got := k.State()
+		return got == state, "state " + got
+	})
+}
+
+// Output returns what keepalived has logged so far
+func (k *Keepalived) Output() string {
+	return k.output.String()
+}
+
+// Stop shuts keepalived down: a MASTER advertises priority 0 and releases
+// its VIP
+func (k *Keepalived) Stop() {
+	if k.exited() {
+		return
+	}
+	_ = k.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-k.done:
+	case <-time.After(10 * time.Second):
+		k.t.Errorf("%s did not exit on SIGTERM", k)
+		_ = k.cmd.Process.Kill()
+		<-k.done
+	}
+}
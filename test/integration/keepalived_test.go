@@ -0,0 +1,167 @@
+//go:build integration && interop
+// +build integration,interop
+
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The interop tests run the daemon against keepalived. Each check goes both
+// ways: a router that rejected the other's advertisements, for their
+// checksum or anything else, would not stay BACKUP to it.
+
+// stableFor outlasts the master down interval, after which a backup that
+// dropped the master's advertisements would have taken over
+const stableFor = 5 * time.Second
+
+// requireClean fails the test if the instance heard nothing from keepalived
+// or counted any of it as malformed
+func requireClean(t *testing.T, v *VRRPInstance) {
+	t.Helper()
+	st, err := v.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if st.AdvertsReceived == 0 {
+		t.Errorf("%s received no advertisements", v)
+	}
+	if st.ChecksumErrors != 0 || st.PacketLengthErrors != 0 || st.AddressListErrors != 0 ||
+		st.AdvIntervalErrors != 0 || st.VersionErrors != 0 {
+		t.Errorf("%s counted protocol errors: %+v", v, st)
+	}
+}
+
+// keepalivedComplaints are in the messages keepalived logs for a received
+// advertisement it rejects
+var keepalivedComplaints = []string{"checksum", "bogus", "ignoring received", "mismatch", "invalid ip address"}
+
+// requireKeepalivedClean fails the test if keepalived complained about the
+// daemon's advertisements
+func requireKeepalivedClean(t *testing.T, k *Keepalived) {
+	t.Helper()
+	for _, line := range strings.Split(k.Output(), "\n") {
+		l := strings.ToLower(line)
+		for _, complaint := range keepalivedComplaints {
+			if strings.Contains(l, complaint) {
+				t.Errorf("%s: %s", k, line)
+				break
+			}
+		}
+	}
+}
+
+// stays checks that the daemon keeps state and keepalived keeps kstate
+func stays(t *testing.T, v *VRRPInstance, state string, k *Keepalived, kstate string) {
+	t.Helper()
+	what := fmt.Sprintf("%s to stay %s and %s to stay %s", v, state, k, kstate)
+	Consistently(t, stableFor, what, func() (bool, string) {
+		got, err := v.State()
+		if err != nil {
+			return false, err.Error()
+		}
+		kgot := k.State()
+		return got == state && kgot == kstate, fmt.Sprintf("states %s and %s", got, kgot)
+	})
+}
+
+func TestKeepalivedElection(t *testing.T) {
+	vip := testVIP()
+
+	t.Run("daemon master", func(t *testing.T) {
+		k := StartKeepalived(t, ns1, 50, 100, vip)
+		v := StartVRRPInstance(t, ns2, 50, 200, vip)
+
+		v.WaitForState("MASTER", electionTimeout)
+		k.WaitForState("BACKUP", electionTimeout)
+		stays(t, v, "MASTER", k, "BACKUP")
+		WaitForVIP(t, ns2, vip, true, electionTimeout)
+		WaitForVIP(t, ns1, vip, false, electionTimeout)
+		requireKeepalivedClean(t, k)
+	})
+
+	t.Run("keepalived master", func(t *testing.T) {
+		k := StartKeepalived(t, ns1, 51, 200, vip)
+		v := StartVRRPInstance(t, ns2, 51, 100, vip)
+
+		k.WaitForState("MASTER", electionTimeout)
+		v.WaitForState("BACKUP", electionTimeout)
+		stays(t, v, "BACKUP", k, "MASTER")
+		WaitForVIP(t, ns1, vip, true, electionTimeout)
+		WaitForVIP(t, ns2, vip, false, electionTimeout)
+		requireClean(t, v)
+	})
+}
+
+func TestKeepalivedPreemption(t *testing.T) {
+	vip := testVIP()
+
+	t.Run("daemon preempts", func(t *testing.T) {
+		k := StartKeepalived(t, ns1, 52, 100, vip)
+		k.WaitForState("MASTER", electionTimeout)
+
+		v := StartVRRPInstance(t, ns2, 52, 200, vip)
+		v.WaitForState("MASTER", electionTimeout)
+		k.WaitForState("BACKUP", electionTimeout)
+		WaitForVIP(t, ns1, vip, false, electionTimeout)
+		WaitForVIP(t, ns2, vip, true, electionTimeout)
+		requireClean(t, v)
+		requireKeepalivedClean(t, k)
+	})
+
+	t.Run("keepalived preempts", func(t *testing.T) {
+		v := StartVRRPInstance(t, ns2, 53, 100, vip)
+		v.WaitForState("MASTER", electionTimeout)
+
+		k := StartKeepalived(t, ns1, 53, 200, vip)
+		k.WaitForState("MASTER", electionTimeout)
+		v.WaitForState("BACKUP", electionTimeout)
+		WaitForVIP(t, ns2, vip, false, electionTimeout)
+		WaitForVIP(t, ns1, vip, true, electionTimeout)
+		requireClean(t, v)
+		requireKeepalivedClean(t, k)
+	})
+}
+
+func TestKeepalivedPriorityZero(t *testing.T) {
+	vip := testVIP()
+
+	// A master that stops advertises priority 0, so the backup takes over
+	// after the skew time rather than a master down interval
+	t.Run("daemon stops", func(t *testing.T) {
+		k := StartKeepalived(t, ns1, 54, 100, vip)
+		v := StartVRRPInstance(t, ns2, 54, 200, vip)
+		v.WaitForState("MASTER", electionTimeout)
+		k.WaitForState("BACKUP", electionTimeout)
+
+		v.Stop()
+		WaitForVIP(t, ns2, vip, false, electionTimeout)
+		k.WaitForState("MASTER", 2*time.Second)
+		WaitForVIP(t, ns1, vip, true, electionTimeout)
+		requireKeepalivedClean(t, k)
+	})
+
+	t.Run("keepalived stops", func(t *testing.T) {
+		k := StartKeepalived(t, ns1, 55, 200, vip)
+		v := StartVRRPInstance(t, ns2, 55, 100, vip)
+		k.WaitForState("MASTER", electionTimeout)
+		v.WaitForState("BACKUP", electionTimeout)
+
+		k.Stop()
+		WaitForVIP(t, ns1, vip, false, electionTimeout)
+		v.WaitForState("MASTER", 2*time.Second)
+		WaitForVIP(t, ns2, vip, true, electionTimeout)
+
+		st, err := v.Stats()
+		if err != nil {
+			t.Fatalf("Stats: %v", err)
+		}
+		if st.PriorityZeroReceived == 0 {
+			t.Errorf("%s took over without receiving priority 0", v)
+		}
+		requireClean(t, v)
+	})
+}
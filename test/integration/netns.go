@@ -0,0 +1,77 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Namespace is a network namespace attached to the test bridge
+type Namespace struct {
+	Name      string
+	Interface string
+	Address   string
+}
+
+const testBridge = "vrrp-br0"
+
+// The test namespaces are created by TestMain before the tests run and
+// removed after them
+var (
+	ns1 = Namespace{Name: "vrrp-test-1", Interface: "veth1", Address: "10.0.0.1/24"}
+	ns2 = Namespace{Name: "vrrp-test-2", Interface: "veth2", Address: "10.0.0.2/24"}
+
+	testNamespaces = []Namespace{ns1, ns2}
+)
+
+// ip runs the ip command with args
+func ip(args ...string) error {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// setupNetwork connects the test namespaces through a bridge. Leftovers of
+// an interrupted run are removed first.
+func setupNetwork() error {
+	teardownNetwork()
+
+	steps := [][]string{
+		{"link", "add", testBridge, "type", "bridge"},
+		{"link", "set", testBridge, "up"},
+	}
+	for _, ns := range testNamespaces {
+		peer := ns.Interface + "-peer"
+		steps = append(steps,
+			[]string{"netns", "add", ns.Name},
+			[]string{"link", "add", ns.Interface, "type", "veth", "peer", "name", peer},
+			[]string{"link", "set", ns.Interface, "netns", ns.Name},
+			[]string{"link", "set", peer, "master", testBridge},
+			[]string{"link", "set", peer, "up"},
+			[]string{"-n", ns.Name, "link", "set", "lo", "up"},
+			[]string{"-n", ns.Name, "link", "set", ns.Interface, "up"},
+			[]string{"-n", ns.Name, "addr", "add", ns.Address, "dev", ns.Interface},
+		)
+	}
+	for _, args := range steps {
+		if err := ip(args...); err != nil {
+			teardownNetwork()
+			return err
+		}
+	}
+	return nil
+}
+
+// teardownNetwork removes the namespaces and the bridge, ignoring those that
+// do not exist. Deleting a namespace deletes its veth pair.
+func teardownNetwork() {
+	for _, ns := range testNamespaces {
+		_ = ip("netns", "delete", ns.Name)
+	}
+	_ = ip("link", "delete", testBridge)
+}
@@ -4,166 +4,267 @@
 package integration
 
 import (
-	"bufio"
 	"bytes"
-	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
 )
 
+// vrrpBin is the binary under test, built by TestMain unless VRRP_BIN is set
+var vrrpBin string
+
+// pollInterval is how often the wait helpers check their condition
+const pollInterval = 100 * time.Millisecond
+
+// VRRPInstance is a `vrrp run` process in a namespace. It is queried over its
+// own control socket.
 type VRRPInstance struct {
-	Namespace string
-	Interface string
+	Namespace Namespace
 	VRID      uint8
 	Priority  uint8
 	VIP       string
-	cmd       *exec.Cmd
-	output    *bytes.Buffer
-	t         *testing.T
+
+	t      *testing.T
+	socket string
+	cmd    *exec.Cmd
+	output *syncBuffer
+	done   chan struct{}
+}
+
+// syncBuffer collects the output of a process while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
 }
 
-func NewVRRPInstance(t *testing.T, ns string, iface string, vrid uint8, priority uint8, vip string) *VRRPInstance {
-	return &VRRPInstance{
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// StartVRRPInstance starts an instance and waits until its control socket
+// answers. args are added to the run command line. The instance is stopped
+// when the test ends, and its output is logged if the test failed.
+func StartVRRPInstance(t *testing.T, ns Namespace, vrid, priority uint8, vip string, args ...string) *VRRPInstance {
+	t.Helper()
+	dir := t.TempDir()
+	v := &VRRPInstance{
 		Namespace: ns,
-		Interface: iface,
 		VRID:      vrid,
 		Priority:  priority,
 		VIP:       vip,
 		t:         t,
-		output:    &bytes.Buffer{},
-	}
-}
-
-func (v *VRRPInstance) Start(ctx context.Context) error {
-	vrrpBin := os.Getenv("VRRP_BIN")
-	if vrrpBin == "" {
-		vrrpBin = "../../../vrrp"
-	}
-
-	args := []string{
-		"netns", "exec", v.Namespace,
-		vrrpBin, "run",
-		"--interface", v.Interface,
-		"--vrid", fmt.Sprintf("%d", v.VRID),
-		"--priority", fmt.Sprintf("%d", v.Priority),
-		"--vips", v.VIP,
+		socket:    filepath.Join(dir, "vrrp.sock"),
+		output:    &syncBuffer{},
+		done:      make(chan struct{}),
 	}
 
-	v.cmd = exec.CommandContext(ctx, "ip", args...)
+	v.cmd = exec.Command("ip", append([]string{"netns", "exec", ns.Name,
+		vrrpBin, "--socket", v.socket, "run",
+		"--interface", ns.Interface,
+		"--vrid", fmt.Sprint(vrid),
+		"--priority", fmt.Sprint(priority),
+		"--vips", vip,
+		"--lock-dir", dir,
+	}, args...)...)
 	v.cmd.Stdout = v.output
 	v.cmd.Stderr = v.output
-
 	if err := v.cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start VRRP instance: %w", err)
+		t.Fatalf("Failed to start VRRP instance: %v", err)
 	}
+	go func() {
+		_ = v.cmd.Wait()
+		close(v.done)
+	}()
 
-	// Wait for startup
-	time.Sleep(2 * time.Second)
-	return nil
-}
+	t.Cleanup(func() {
+		v.Stop()
+		if t.Failed() {
+			t.Logf("Output of %s:\n%s", v, v.output.String())
+		}
+	})
 
-func (v *VRRPInstance) Stop() error {
-	if v.cmd != nil && v.cmd.Process != nil {
-		if err := v.cmd.Process.Kill(); err != nil {
-			return err
+	WaitFor(t, 10*time.Second, fmt.Sprintf("%s to answer on its control socket", v), func() (bool, string) {
+		if v.exited() {
+			t.Fatalf("%s exited:\n%s", v, v.output.String())
 		}
-		v.cmd.Wait()
-	}
-	return nil
+		_, err := v.status()
+		return err == nil, fmt.Sprint(err)
+	})
+	return v
 }
 
-func (v *VRRPInstance) GetState() (string, error) {
-	scanner := bufio.NewScanner(bytes.NewReader(v.output.Bytes()))
-	var lastState string
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "Current state:") {
-			parts := strings.Split(line, "Current state:")
-			if len(parts) > 1 {
-				lastState = strings.TrimSpace(parts[1])
-			}
-		}
-	}
+func (v *VRRPInstance) String() string {
+	return fmt.Sprintf("VRID %d in %s (priority %d)", v.VRID, v.Namespace.Name, v.Priority)
+}
 
-	if lastState == "" {
-		return "", fmt.Errorf("state not found in output")
+func (v *VRRPInstance) exited() bool {
+	select {
+	case <-v.done:
+		return true
+	default:
+		return false
 	}
-
-	return lastState, nil
 }
 
-func (v *VRRPInstance) WaitForState(expectedState string, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
-
-	for time.Now().Before(deadline) {
-		state, err := v.GetState()
-		if err == nil && strings.EqualFold(state, expectedState) {
-			return nil
-		}
-		time.Sleep(500 * time.Millisecond)
+func (v *VRRPInstance) status() (*control.InstanceStatus, error) {
+	resp, err := control.NewClient(v.socket).Do(&control.Request{Command: control.CommandStatus, VRID: v.VRID})
+	if err != nil {
+		return nil, err
 	}
-
-	return fmt.Errorf("timeout waiting for state %s", expectedState)
+	if len(resp.Instances) != 1 {
+		return nil, fmt.Errorf("status returned %d instances", len(resp.Instances))
+	}
+	return &resp.Instances[0], nil
 }
 
-func CheckVIPPresent(namespace, iface, vip string) (bool, error) {
-	cmd := exec.Command("ip", "netns", "exec", namespace, "ip", "addr", "show", iface)
-	output, err := cmd.Output()
+// State returns the state the instance reports over its control socket
+func (v *VRRPInstance) State() (string, error) {
+	st, err := v.status()
 	if err != nil {
-		return false, err
+		return "", err
 	}
+	return st.State, nil
+}
 
-	return strings.Contains(string(output), vip), nil
+// WaitForState waits until the instance reports state, failing the test
+// after timeout
+func (v *VRRPInstance) WaitForState(state string, timeout time.Duration) {
+	v.t.Helper()
+	WaitFor(v.t, timeout, fmt.Sprintf("%s to be %s", v, state), func() (bool, string) {
+		got, err := v.State()
+		if err != nil {
+			return false, err.Error()
+		}
+		return got == state, "state " + got
+	})
 }
 
-func RunCommand(namespace string, command ...string) (string, error) {
-	args := []string{"netns", "exec", namespace}
-	args = append(args, command...)
+// Stop shuts the instance down gracefully: a MASTER releases its VIP and
+// advertises priority 0
+func (v *VRRPInstance) Stop() {
+	v.signal(syscall.SIGTERM)
+}
 
-	cmd := exec.Command("ip", args...)
-	output, err := cmd.CombinedOutput()
-	return string(output), err
+// Crash kills the instance without letting it shut down, leaving a MASTER's
+// VIP on the interface until the test ends
+func (v *VRRPInstance) Crash() {
+	v.signal(syscall.SIGKILL)
+	v.t.Cleanup(func() {
+		_ = ip("-n", v.Namespace.Name, "addr", "del", v.VIP+"/32", "dev", v.Namespace.Interface)
+	})
 }
 
-func PingVIP(namespace, vip string) error {
-	_, err := RunCommand(namespace, "ping", "-c", "1", "-W", "1", vip)
-	return err
+// signal sends sig to the process, escalating to SIGKILL if it has not
+// exited within 10 seconds
+func (v *VRRPInstance) signal(sig syscall.Signal) {
+	if v.exited() {
+		return
+	}
+	// ip netns exec replaces itself with the daemon, so this is the daemon
+	_ = v.cmd.Process.Signal(sig)
+	select {
+	case <-v.done:
+	case <-time.After(10 * time.Second):
+		v.t.Errorf("%s did not exit on %v", v, sig)
+		_ = v.cmd.Process.Kill()
+		<-v.done
+	}
 }
 
-func WaitForVIP(namespace, iface, vip string, present bool, timeout time.Duration) error {
+// WaitFor polls cond until it holds, failing the test after timeout with
+// the last detail cond returned
+func WaitFor(t *testing.T, timeout time.Duration, what string, cond func() (ok bool, detail string)) {
+	t.Helper()
 	deadline := time.Now().Add(timeout)
-
-	for time.Now().Before(deadline) {
-		hasVIP, err := CheckVIPPresent(namespace, iface, vip)
-		if err != nil {
-			return err
+	var detail string
+	for {
+		var ok bool
+		if ok, detail = cond(); ok {
+			return
 		}
-
-		if hasVIP == present {
-			return nil
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out after %s waiting for %s: %s", timeout, what, detail)
 		}
+		time.Sleep(pollInterval)
+	}
+}
 
-		time.Sleep(500 * time.Millisecond)
+// CheckVIPPresent reports whether vip is assigned to the namespace's interface
+func CheckVIPPresent(ns Namespace, vip string) (bool, error) {
+	out, err := exec.Command("ip", "-n", ns.Name, "-o", "addr", "show", "dev", ns.Interface).Output()
+	if err != nil {
+		return false, err
 	}
+	return strings.Contains(string(out), " "+vip+"/"), nil
+}
 
+// WaitForVIP waits until vip is present on, or absent from, the namespace's
+// interface
+func WaitForVIP(t *testing.T, ns Namespace, vip string, present bool, timeout time.Duration) {
+	t.Helper()
+	what := fmt.Sprintf("VIP %s to leave %s", vip, ns.Name)
 	if present {
-		return fmt.Errorf("timeout waiting for VIP %s to appear", vip)
+		what = fmt.Sprintf("VIP %s on %s", vip, ns.Name)
 	}
-	return fmt.Errorf("timeout waiting for VIP %s to disappear", vip)
+	WaitFor(t, timeout, what, func() (bool, string) {
+		has, err := CheckVIPPresent(ns, vip)
+		if err != nil {
+			return false, err.Error()
+		}
+		return has == present, fmt.Sprintf("present=%v", has)
+	})
 }
 
-func CapturePackets(namespace, iface string, duration time.Duration) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), duration)
-	defer cancel()
+// buildBinary builds the vrrp binary into dir
+func buildBinary(dir string) (string, error) {
+	bin := filepath.Join(dir, "vrrp")
+	cmd := exec.Command("go", "build", "-o", bin, "../..")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to build vrrp: %w", err)
+	}
+	return bin, nil
+}
 
-	cmd := exec.CommandContext(ctx, "ip", "netns", "exec", namespace,
-		"tcpdump", "-i", iface, "-w", "-", "proto", "112")
+// Stats returns the counters the instance reports over its control socket
+func (v *VRRPInstance) Stats() (*control.InstanceStats, error) {
+	resp, err := control.NewClient(v.socket).Do(&control.Request{Command: control.CommandStats, VRID: v.VRID})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Stats) != 1 {
+		return nil, fmt.Errorf("stats returned %d instances", len(resp.Stats))
+	}
+	return &resp.Stats[0], nil
+}
 
-	output, _ := cmd.Output()
-	return output, nil
+// Consistently polls cond for the whole of d, failing the test as soon as it
+// does not hold. It checks that something does not happen, such as a backup
+// taking over, over a period longer than the timer that would cause it.
+func Consistently(t *testing.T, d time.Duration, what string, cond func() (ok bool, detail string)) {
+	t.Helper()
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if ok, detail := cond(); !ok {
+			t.Fatalf("Expected %s for %s: %s", what, d, detail)
+		}
+		time.Sleep(pollInterval)
+	}
 }
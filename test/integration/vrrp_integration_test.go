@@ -4,257 +4,159 @@
 package integration
 
 import (
-	"context"
+	"fmt"
 	"os"
 	"testing"
 	"time"
 )
 
-func TestMain(m *testing.M) {
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		println("Integration tests must be run as root")
-		os.Exit(1)
-	}
+// electionTimeout bounds every wait: a master down interval at the default
+// 1s advertisement interval is just over 3s
+const electionTimeout = 10 * time.Second
 
-	os.Exit(m.Run())
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
 }
 
-func TestMasterElection(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	vip := os.Getenv("TEST_VIP")
-	if vip == "" {
-		vip = "10.0.0.100"
+func runTests(m *testing.M) int {
+	if os.Geteuid() != 0 {
+		fmt.Fprintln(os.Stderr, "Integration tests must be run as root")
+		return 1
 	}
 
-	// Start instance 1 with lower priority (backup)
-	instance1 := NewVRRPInstance(t, "ns1", "veth1", 10, 100, vip)
-	if err := instance1.Start(ctx); err != nil {
-		t.Fatalf("Failed to start instance 1: %v", err)
+	if vrrpBin = os.Getenv("VRRP_BIN"); vrrpBin == "" {
+		dir, err := os.MkdirTemp("", "vrrp-integration-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer func() { _ = os.RemoveAll(dir) }()
+		if vrrpBin, err = buildBinary(dir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
 	}
-	defer instance1.Stop()
 
-	// Start instance 2 with higher priority (should become master)
-	instance2 := NewVRRPInstance(t, "ns2", "veth2", 10, 200, vip)
-	if err := instance2.Start(ctx); err != nil {
-		t.Fatalf("Failed to start instance 2: %v", err)
+	if err := setupNetwork(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up the test network: %v\n", err)
+		return 1
 	}
-	defer instance2.Stop()
+	defer teardownNetwork()
 
-	// Wait for election
-	time.Sleep(5 * time.Second)
-
-	// Check that instance2 is master
-	state2, err := instance2.GetState()
-	if err != nil {
-		t.Fatalf("Failed to get state of instance 2: %v", err)
-	}
-
-	if state2 != "MASTER" {
-		t.Errorf("Instance 2 should be MASTER, got %s", state2)
-	}
+	return m.Run()
+}
 
-	// Check that instance1 is backup
-	state1, err := instance1.GetState()
-	if err != nil {
-		t.Fatalf("Failed to get state of instance 1: %v", err)
+func testVIP() string {
+	if vip := os.Getenv("TEST_VIP"); vip != "" {
+		return vip
 	}
+	return "10.0.0.100"
+}
 
-	if state1 != "BACKUP" {
-		t.Errorf("Instance 1 should be BACKUP, got %s", state1)
-	}
+func TestMasterElection(t *testing.T) {
+	vip := testVIP()
 
-	// Verify VIP is on instance2
-	hasVIP, err := CheckVIPPresent("ns2", "veth2", vip)
-	if err != nil {
-		t.Fatalf("Failed to check VIP: %v", err)
-	}
+	instance1 := StartVRRPInstance(t, ns1, 10, 100, vip)
+	instance2 := StartVRRPInstance(t, ns2, 10, 200, vip)
 
-	if !hasVIP {
-		t.Error("VIP should be present on master (ns2)")
-	}
+	instance2.WaitForState("MASTER", electionTimeout)
+	instance1.WaitForState("BACKUP", electionTimeout)
+	WaitForVIP(t, ns2, vip, true, electionTimeout)
+	WaitForVIP(t, ns1, vip, false, electionTimeout)
 }
 
 func TestFailover(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	vip := testVIP()
 
-	vip := os.Getenv("TEST_VIP")
-	if vip == "" {
-		vip = "10.0.0.100"
-	}
-
-	// Start master instance
-	master := NewVRRPInstance(t, "ns1", "veth1", 20, 200, vip)
-	if err := master.Start(ctx); err != nil {
-		t.Fatalf("Failed to start master: %v", err)
-	}
-
-	// Start backup instance
-	backup := NewVRRPInstance(t, "ns2", "veth2", 20, 100, vip)
-	if err := backup.Start(ctx); err != nil {
-		t.Fatalf("Failed to start backup: %v", err)
-	}
-	defer backup.Stop()
-
-	// Wait for initial election
-	time.Sleep(5 * time.Second)
-
-	// Verify master state
-	masterState, err := master.GetState()
-	if err != nil {
-		t.Fatalf("Failed to get master state: %v", err)
-	}
+	master := StartVRRPInstance(t, ns1, 20, 200, vip)
+	master.WaitForState("MASTER", electionTimeout)
+	backup := StartVRRPInstance(t, ns2, 20, 100, vip)
+	backup.WaitForState("BACKUP", electionTimeout)
 
-	if masterState != "MASTER" {
-		t.Errorf("Initial master should be MASTER, got %s", masterState)
-	}
-
-	// Stop master to trigger failover
-	t.Log("Stopping master to trigger failover...")
-	if err := master.Stop(); err != nil {
-		t.Fatalf("Failed to stop master: %v", err)
-	}
-
-	// Wait for failover (3 * advert_interval + skew_time)
-	time.Sleep(10 * time.Second)
+	// A crashed master neither releases its VIP nor advertises priority 0:
+	// the backup takes over when its master down timer fires
+	t.Log("Crashing master to trigger failover...")
+	master.Crash()
 
-	// Check that backup became master
-	backupState, err := backup.GetState()
-	if err != nil {
-		t.Fatalf("Failed to get backup state: %v", err)
-	}
+	backup.WaitForState("MASTER", electionTimeout)
+	WaitForVIP(t, ns2, vip, true, electionTimeout)
+}
 
-	if backupState != "MASTER" {
-		t.Errorf("Backup should have become MASTER after failover, got %s", backupState)
-	}
+func TestGracefulShutdown(t *testing.T) {
+	vip := testVIP()
 
-	// Verify VIP moved to new master
-	hasVIP, err := CheckVIPPresent("ns2", "veth2", vip)
-	if err != nil {
-		t.Fatalf("Failed to check VIP after failover: %v", err)
-	}
+	master := StartVRRPInstance(t, ns1, 25, 200, vip)
+	master.WaitForState("MASTER", electionTimeout)
+	backup := StartVRRPInstance(t, ns2, 25, 100, vip)
+	backup.WaitForState("BACKUP", electionTimeout)
 
-	if !hasVIP {
-		t.Error("VIP should be present on new master (ns2) after failover")
-	}
+	// A stopping master advertises priority 0, so the backup takes over
+	// after the skew time rather than a master down interval
+	master.Stop()
+	WaitForVIP(t, ns1, vip, false, electionTimeout)
+	backup.WaitForState("MASTER", 2*time.Second)
+	WaitForVIP(t, ns2, vip, true, electionTimeout)
 }
 
 func TestPreemption(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
-	defer cancel()
-
-	vip := os.Getenv("TEST_VIP")
-	if vip == "" {
-		vip = "10.0.0.100"
-	}
-
-	// Start low priority instance (becomes initial master)
-	lowPrio := NewVRRPInstance(t, "ns1", "veth1", 30, 100, vip)
-	if err := lowPrio.Start(ctx); err != nil {
-		t.Fatalf("Failed to start low priority instance: %v", err)
-	}
-	defer lowPrio.Stop()
+	vip := testVIP()
 
-	// Wait for it to become master
-	time.Sleep(5 * time.Second)
-
-	state, err := lowPrio.GetState()
-	if err != nil {
-		t.Fatalf("Failed to get initial state: %v", err)
-	}
-
-	if state != "MASTER" {
-		t.Errorf("Low priority instance should be initial MASTER, got %s", state)
-	}
+	lowPrio := StartVRRPInstance(t, ns1, 30, 100, vip)
+	lowPrio.WaitForState("MASTER", electionTimeout)
 
-	// Start high priority instance (should preempt)
-	highPrio := NewVRRPInstance(t, "ns2", "veth2", 30, 200, vip)
-	if err := highPrio.Start(ctx); err != nil {
-		t.Fatalf("Failed to start high priority instance: %v", err)
-	}
-	defer highPrio.Stop()
-
-	// Wait for preemption
-	time.Sleep(5 * time.Second)
-
-	// Check that high priority became master
-	highState, err := highPrio.GetState()
-	if err != nil {
-		t.Fatalf("Failed to get high priority state: %v", err)
-	}
-
-	if highState != "MASTER" {
-		t.Errorf("High priority instance should have preempted and become MASTER, got %s", highState)
-	}
-
-	// Check that low priority became backup
-	lowState, err := lowPrio.GetState()
-	if err != nil {
-		t.Fatalf("Failed to get low priority state after preemption: %v", err)
-	}
-
-	if lowState != "BACKUP" {
-		t.Errorf("Low priority instance should have become BACKUP after preemption, got %s", lowState)
-	}
+	highPrio := StartVRRPInstance(t, ns2, 30, 200, vip)
+	highPrio.WaitForState("MASTER", electionTimeout)
+	lowPrio.WaitForState("BACKUP", electionTimeout)
+	WaitForVIP(t, ns1, vip, false, electionTimeout)
+	WaitForVIP(t, ns2, vip, true, electionTimeout)
 }
 
 func TestMultipleVRIDs(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Start VRID 40 on ns1 (master)
-	vrid40_ns1 := NewVRRPInstance(t, "ns1", "veth1", 40, 200, "10.0.0.101")
-	if err := vrid40_ns1.Start(ctx); err != nil {
-		t.Fatalf("Failed to start VRID 40 on ns1: %v", err)
-	}
-	defer vrid40_ns1.Stop()
-
-	// Start VRID 41 on ns1 (backup)
-	vrid41_ns1 := NewVRRPInstance(t, "ns1", "veth1", 41, 100, "10.0.0.102")
-	if err := vrid41_ns1.Start(ctx); err != nil {
-		t.Fatalf("Failed to start VRID 41 on ns1: %v", err)
-	}
-	defer vrid41_ns1.Stop()
-
-	// Start VRID 40 on ns2 (backup)
-	vrid40_ns2 := NewVRRPInstance(t, "ns2", "veth2", 40, 100, "10.0.0.101")
-	if err := vrid40_ns2.Start(ctx); err != nil {
-		t.Fatalf("Failed to start VRID 40 on ns2: %v", err)
-	}
-	defer vrid40_ns2.Stop()
+	vrid40ns1 := StartVRRPInstance(t, ns1, 40, 200, "10.0.0.101")
+	vrid41ns1 := StartVRRPInstance(t, ns1, 41, 100, "10.0.0.102")
+	vrid40ns2 := StartVRRPInstance(t, ns2, 40, 100, "10.0.0.101")
+	vrid41ns2 := StartVRRPInstance(t, ns2, 41, 200, "10.0.0.102")
 
-	// Start VRID 41 on ns2 (master)
-	vrid41_ns2 := NewVRRPInstance(t, "ns2", "veth2", 41, 200, "10.0.0.102")
-	if err := vrid41_ns2.Start(ctx); err != nil {
-		t.Fatalf("Failed to start VRID 41 on ns2: %v", err)
-	}
-	defer vrid41_ns2.Stop()
-
-	// Wait for elections
-	time.Sleep(5 * time.Second)
+	// VRID 40: ns1 master, ns2 backup
+	vrid40ns1.WaitForState("MASTER", electionTimeout)
+	vrid40ns2.WaitForState("BACKUP", electionTimeout)
 
-	// Verify VRID 40: ns1 master, ns2 backup
-	state40_ns1, _ := vrid40_ns1.GetState()
-	state40_ns2, _ := vrid40_ns2.GetState()
-
-	if state40_ns1 != "MASTER" {
-		t.Errorf("VRID 40: ns1 should be MASTER, got %s", state40_ns1)
-	}
-	if state40_ns2 != "BACKUP" {
-		t.Errorf("VRID 40: ns2 should be BACKUP, got %s", state40_ns2)
-	}
+	// VRID 41: ns1 backup, ns2 master
+	vrid41ns2.WaitForState("MASTER", electionTimeout)
+	vrid41ns1.WaitForState("BACKUP", electionTimeout)
 
-	// Verify VRID 41: ns1 backup, ns2 master
-	state41_ns1, _ := vrid41_ns1.GetState()
-	state41_ns2, _ := vrid41_ns2.GetState()
+	WaitForVIP(t, ns1, "10.0.0.101", true, electionTimeout)
+	WaitForVIP(t, ns2, "10.0.0.102", true, electionTimeout)
+}
 
-	if state41_ns1 != "BACKUP" {
-		t.Errorf("VRID 41: ns1 should be BACKUP, got %s", state41_ns1)
-	}
-	if state41_ns2 != "MASTER" {
-		t.Errorf("VRID 41: ns2 should be MASTER, got %s", state41_ns2)
-	}
+func TestLossyNetwork(t *testing.T) {
+	vip := testVIP()
+
+	// Each instance loses, delays, duplicates, reorders and corrupts some of
+	// what it receives; one advertisement in ten lost is not enough for a
+	// master down interval to pass without one
+	chaos := "--chaos=drop=0.1,jitter=200ms,duplicate=0.1,reorder=0.1,corrupt=0.1"
+	master := StartVRRPInstance(t, ns1, 60, 200, vip, chaos)
+	master.WaitForState("MASTER", electionTimeout)
+
+	// Started together, the backup's master down timer would run out half a
+	// second after the master's first advertisement, which the faults often
+	// hold back that long
+	backup := StartVRRPInstance(t, ns2, 60, 100, vip, chaos)
+	backup.WaitForState("BACKUP", electionTimeout)
+	Consistently(t, 5*time.Second, "the election to hold", func() (bool, string) {
+		m, err := master.State()
+		if err != nil {
+			return false, err.Error()
+		}
+		b, err := backup.State()
+		if err != nil {
+			return false, err.Error()
+		}
+		return m == "MASTER" && b == "BACKUP", "states " + m + " and " + b
+	})
+
+	master.Crash()
+	backup.WaitForState("MASTER", electionTimeout)
+	WaitForVIP(t, ns2, vip, true, electionTimeout)
 }
@@ -125,7 +125,7 @@ create_vrrp_container() {
     
     # Build VRRP in container
     echo "Building VRRP in $name..."
-    lxc exec $name -- bash -c "cd /root/vrrp-simple && go build -o /usr/local/bin/vrrp ./main.go"
+    lxc exec $name -- bash -c "cd /root/vrrp-simple && go build -o /usr/local/bin/vrrp ."
     
     # Create systemd service for VRRP
     cat << EOF | lxc exec $name -- tee /etc/systemd/system/vrrp.service
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/metrics"
+)
+
+// textfileName is the file written in --metrics-textfile-dir. The
+// node_exporter textfile collector reads every *.prom file there.
+const textfileName = "vrrp.prom"
+
+// textfileExport writes the daemon's metrics for the node_exporter textfile
+// collector, for hosts where the daemon may not listen on a port of its own
+type textfileExport struct {
+	path     string
+	metrics  *metrics.Prometheus
+	interval time.Duration
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	// failing is set after a write failed, to log once until one succeeds;
+	// only run touches it
+	failing bool
+}
+
+// newTextfileExport checks that dir exists; the file is written once
+// started
+func newTextfileExport(dir string, m *metrics.Prometheus, interval time.Duration) (*textfileExport, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("textfile directory: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("textfile directory %s is not a directory", dir)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("textfile interval %s must be positive", interval)
+	}
+	return &textfileExport{
+		path:     filepath.Join(dir, textfileName),
+		metrics:  m,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// changed makes the file be written now, e.g. after a transition. It does
+// not wait, so it may be called from a state callback.
+func (t *textfileExport) changed() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// start writes the file at once, then after each change and every interval
+// until close
+func (t *textfileExport) start() {
+	go t.run()
+}
+
+func (t *textfileExport) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		t.write()
+		select {
+		case <-t.stop:
+			return
+		case <-t.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *textfileExport) write() {
+	err := t.metrics.WriteFile(t.path)
+	switch {
+	case err != nil && !t.failing:
+		slog.Error("Failed to write metrics textfile", "path", t.path, "err", err)
+	case err == nil && t.failing:
+		slog.Info("Writing metrics textfile again", "path", t.path)
+	}
+	t.failing = err != nil
+}
+
+// close stops writing and deletes the file, so that node_exporter does not
+// go on exposing the state of a daemon that is gone
+func (t *textfileExport) close() {
+	close(t.stop)
+	<-t.done
+	if err := os.Remove(t.path); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove metrics textfile", "path", t.path, "err", err)
+	}
+}
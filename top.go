@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var (
+	topCmd       = app.Command("top", "Live dashboard of the local instances")
+	topInterface = topCmd.Flag("interface", "Network interface").Short('i').HintAction(interfaceNames).String()
+	topVRID      = topCmd.Flag("vrid", "Virtual Router ID").Short('r').Uint8()
+	topInterval  = topCmd.Flag("interval", "Refresh interval").Short('n').Default("1s").Duration()
+)
+
+// ANSI sequences used by the dashboard
+const (
+	ansiAltScreen  = "\x1b[?1049h"
+	ansiMainScreen = "\x1b[?1049l"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiHome       = "\x1b[H"
+	ansiClearBelow = "\x1b[J"
+	ansiClearEOL   = "\x1b[K"
+	ansiBold       = "\x1b[1m"
+	ansiReset      = "\x1b[0m"
+)
+
+var stateColors = map[string]string{
+	"MASTER": "\x1b[32m",
+	"BACKUP": "\x1b[33m",
+	"INIT":   "\x1b[31m",
+}
+
+var topColumns = columnsNamed(statusColumns,
+	"INTERFACE", "VRID", "STATE", "PRIORITY", "MASTER", "LAST ADVERT", "MASTER PRIO", "UPTIME", "VIPS", "TRACKERS")
+
+func runTop() {
+	if *topInterval <= 0 {
+		app.Fatalf("--interval must be positive")
+	}
+
+	restore, err := enterCbreak(int(os.Stdin.Fd()))
+	if err != nil {
+		exitWithError(err)
+	}
+
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer func() {
+		fmt.Print(ansiShowCursor + ansiMainScreen)
+		restore()
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	keys := make(chan byte)
+	go readKeys(keys)
+
+	ticker := time.NewTicker(*topInterval)
+	defer ticker.Stop()
+
+	client := control.NewClient(*socketPath)
+	for {
+		drawTop(client)
+
+		select {
+		case <-sigCh:
+			return
+		case k := <-keys:
+			if k == 'q' || k == 'Q' {
+				return
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func drawTop(client *control.Client) {
+	var buf bytes.Buffer
+	buf.WriteString(ansiHome)
+	fmt.Fprintf(&buf, "%svrrp top%s - %s - %s - refresh %s - q to quit%s\n\n",
+		ansiBold, ansiReset, time.Now().Format("15:04:05"), *socketPath, *topInterval, ansiClearEOL)
+
+	resp, err := client.Do(&control.Request{
+		Command:   control.CommandStatus,
+		Interface: *topInterface,
+		VRID:      *topVRID,
+	})
+	switch {
+	case err != nil:
+		fmt.Fprintf(&buf, "Error: %v%s\n", err, ansiClearEOL)
+	case len(resp.Instances) == 0:
+		fmt.Fprintf(&buf, "No matching VRRP instances%s\n", ansiClearEOL)
+	default:
+		renderTopTable(&buf, resp.Instances)
+	}
+
+	buf.WriteString(ansiClearBelow)
+	_, _ = os.Stdout.Write(buf.Bytes())
+}
+
+// renderTopTable lays the rows out with printRows and then colors each line by
+// state, so escape sequences do not upset the column widths
+func renderTopTable(buf *bytes.Buffer, instances []control.InstanceStatus) {
+	var table bytes.Buffer
+	_ = printRows(&table, outputTable, instances, topColumns)
+
+	lines := strings.Split(strings.TrimRight(table.String(), "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			buf.WriteString(ansiBold + line + ansiReset)
+		case stateColors[instances[i-1].State] != "":
+			buf.WriteString(stateColors[instances[i-1].State] + line + ansiReset)
+		default:
+			buf.WriteString(line)
+		}
+		buf.WriteString(ansiClearEOL + "\n")
+	}
+}
+
+// enterCbreak turns off line buffering and echo on the terminal so single
+// key presses are delivered, keeping signal keys and output processing. It
+// returns a function restoring the previous mode.
+func enterCbreak(fd int) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, fmt.Errorf("vrrp top needs a terminal: %w", err)
+	}
+
+	t := *old
+	t.Lflag &^= unix.ICANON | unix.ECHO
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &t); err != nil {
+		return nil, fmt.Errorf("failed to configure terminal: %w", err)
+	}
+
+	return func() { _ = unix.IoctlSetTermios(fd, unix.TCSETS, old) }, nil
+}
+
+func readKeys(keys chan<- byte) {
+	b := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(b); err != nil || n == 0 {
+			return
+		}
+		keys <- b[0]
+	}
+}
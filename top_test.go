@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/control"
+)
+
+var ansiSequence = regexp.MustCompile("\x1b\\[[0-9;?]*[a-zA-Z]")
+
+func TestRenderTopTable(t *testing.T) {
+	var buf bytes.Buffer
+	renderTopTable(&buf, []control.InstanceStatus{{
+		Interface:   "eth0",
+		VRID:        10,
+		State:       "BACKUP",
+		Priority:    100,
+		VirtualIPs:  []string{"192.168.1.100"},
+		ExcludedIPs: []string{"10.9.9.9"},
+		Uptime:      "1m0s",
+		MasterIP:    "10.0.0.2",
+		Master:      &control.PeerStatus{SourceIP: "10.0.0.2", Priority: 150, LastSeen: time.Now()},
+		Trackers:    []control.TrackerStatus{{Name: "resolver", Healthy: true}, {Name: "web"}},
+	}})
+
+	lines := strings.Split(strings.TrimSpace(ansiSequence.ReplaceAllString(buf.String(), "")), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("rendered %d lines, want a header and a row:\n%s", len(lines), buf.String())
+	}
+	want := "INTERFACE VRID STATE PRIORITY MASTER LAST ADVERT MASTER PRIO UPTIME VIPS TRACKERS"
+	if got := strings.Join(strings.Fields(lines[0]), " "); got != want {
+		t.Errorf("header = %q, want %q", got, want)
+	}
+	// LAST ADVERT is "0s ago"
+	row := strings.Fields(lines[1])
+	wantRow := []string{"eth0", "10", "BACKUP", "100", "10.0.0.2", "0s", "ago", "150", "1m0s", "192.168.1.100", "failed:", "web"}
+	if strings.Join(row, " ") != strings.Join(wantRow, " ") {
+		t.Errorf("row = %q, want %q", row, wantRow)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/tokuhirom/vrrp-simple/pkg/vrrp"
+)
+
+// upgradeEnv carries the handover from the old daemon to the new one
+const upgradeEnv = "VRRP_UPGRADE"
+
+const (
+	// upgradeReadyTimeout bounds how long the old daemon waits for the new
+	// one to report that it has taken over
+	upgradeReadyTimeout = 30 * time.Second
+
+	// resumeTimeout bounds how long the new daemon waits for the instances
+	// that were MASTER to be MASTER again
+	resumeTimeout = 5 * time.Second
+)
+
+// handover is what a daemon passes to the binary replacing it: descriptor
+// numbers of the VRRP sockets and instance locks, and which instances were
+// MASTER. The new daemon writes a byte to Ready once it has taken over.
+type handover struct {
+	Ready     int                `json:"ready"`
+	Sockets   map[string]int     `json:"sockets"`
+	Instances []handoverInstance `json:"instances"`
+
+	// files are the descriptors as files in the new process; those not
+	// claimed by resume are closed
+	files map[int]*os.File
+}
+
+type handoverInstance struct {
+	Interface string `json:"interface"`
+	VRID      uint8  `json:"vrid"`
+	Master    bool   `json:"master"`
+	Lock      int    `json:"lock,omitempty"`
+}
+
+// upgrade starts a new daemon from the current executable with the same
+// arguments and hands the instances over to it: it inherits the sockets and
+// locks and resumes the MASTER instances without an election, so peers never
+// see an advertisement missed or a priority 0 one. It returns the new
+// daemon's PID once this one may exit; on error this daemon carries on.
+func (d *daemon) upgrade(servers *adminServers) (int, error) {
+	if *runIPVSPort != 0 {
+		return 0, errors.New("upgrade is not supported with IPVS")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	sockets, err := d.manager.Files()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		for _, f := range sockets {
+			_ = f.Close()
+		}
+	}()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create pipe: %w", err)
+	}
+	defer func() { _ = readyR.Close() }()
+
+	// ExtraFiles[i] is descriptor 3+i in the new process
+	extra := []*os.File{readyW}
+	pass := func(f *os.File) int {
+		extra = append(extra, f)
+		return 2 + len(extra)
+	}
+
+	h := handover{Ready: 3, Sockets: make(map[string]int)}
+	for iface, f := range sockets {
+		h.Sockets[iface] = pass(f)
+	}
+	d.mu.Lock()
+	for _, inst := range d.instances {
+		hi := handoverInstance{
+			Interface: inst.cfg.Interface,
+			VRID:      inst.cfg.VRID,
+			Master:    inst.router.GetState() == vrrp.Master,
+		}
+		if inst.lock != nil {
+			hi.Lock = pass(inst.lock.f)
+		}
+		h.Instances = append(h.Instances, hi)
+	}
+	d.mu.Unlock()
+	data, err := json.Marshal(&h)
+	if err != nil {
+		return 0, err
+	}
+
+	// The new daemon binds the same control socket and listen addresses. The
+	// control socket waits for the requests in flight, so d.mu is not held.
+	servers.close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), upgradeEnv+"="+string(data))
+	cmd.ExtraFiles = extra
+	err = cmd.Start()
+	_ = readyW.Close()
+	if err != nil {
+		return 0, errors.Join(fmt.Errorf("failed to start %s: %w", exe, err), servers.start())
+	}
+
+	// The pipe reads EOF if the new daemon exits without taking over
+	ready := make(chan bool, 1)
+	go func() {
+		n, _ := readyR.Read(make([]byte, 1))
+		ready <- n == 1
+	}()
+
+	select {
+	case ok := <-ready:
+		if ok {
+			break
+		}
+		_ = cmd.Wait()
+		return 0, errors.Join(fmt.Errorf("new process exited without taking over: %s", cmd.ProcessState),
+			servers.start())
+	case <-time.After(upgradeReadyTimeout):
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return 0, errors.Join(fmt.Errorf("new process did not take over within %s", upgradeReadyTimeout),
+			servers.start())
+	}
+
+	pid := cmd.Process.Pid
+	sdNotify("MAINPID=" + strconv.Itoa(pid))
+
+	ctx, cancel := context.WithTimeout(context.Background(), *runStopTimeout)
+	defer cancel()
+	if err := d.manager.Detach(ctx); err != nil {
+		slog.Warn("Failed to detach instances", "err", err)
+	}
+	return pid, nil
+}
+
+// inheritedHandover returns the handover from the daemon this one replaces,
+// or nil if it was started normally
+func inheritedHandover() (*handover, error) {
+	data, ok := os.LookupEnv(upgradeEnv)
+	if !ok {
+		return nil, nil
+	}
+	// A later upgrade passes its own
+	_ = os.Unsetenv(upgradeEnv)
+
+	var h handover
+	if err := json.Unmarshal([]byte(data), &h); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", upgradeEnv, err)
+	}
+
+	h.files = make(map[int]*os.File)
+	open := func(fd int, name string) {
+		h.files[fd] = os.NewFile(uintptr(fd), name)
+	}
+	open(h.Ready, "upgrade-ready")
+	for iface, fd := range h.Sockets {
+		open(fd, "vrrp-"+iface)
+	}
+	for _, hi := range h.Instances {
+		if hi.Lock != 0 {
+			open(hi.Lock, fmt.Sprintf("lock-%s-%d", hi.Interface, hi.VRID))
+		}
+	}
+	return &h, nil
+}
+
+// instance returns the handover of the instance for iface and vrid, or nil
+func (h *handover) instance(iface string, vrid uint8) *handoverInstance {
+	if h == nil {
+		return nil
+	}
+	for i := range h.Instances {
+		if h.Instances[i].Interface == iface && h.Instances[i].VRID == vrid {
+			return &h.Instances[i]
+		}
+	}
+	return nil
+}
+
+// take returns the inherited file for fd and marks it used
+func (h *handover) take(fd int) *os.File {
+	f := h.files[fd]
+	delete(h.files, fd)
+	return f
+}
+
+// inherit gives the inherited sockets to the manager, before any router starts
+func (h *handover) inherit(m *vrrp.Manager) {
+	for iface, fd := range h.Sockets {
+		if f := h.take(fd); f != nil {
+			m.Inherit(iface, f)
+		}
+	}
+}
+
+// resumed waits for the instances that were MASTER to be MASTER again, then
+// tells the old daemon to exit. Inherited descriptors no instance claimed are
+// closed, and MASTER instances missing from the configuration are reported:
+// their virtual IPs stay on the interface.
+func (d *daemon) resumed(h *handover) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+	defer cancel()
+
+	for _, hi := range h.Instances {
+		d.mu.Lock()
+		inst := d.find(fmt.Sprintf("%s/%d", hi.Interface, hi.VRID))
+		d.mu.Unlock()
+		switch {
+		case inst == nil && hi.Master:
+			slog.Warn("Instance was MASTER before the upgrade but is no longer configured; "+
+				"its virtual IPs are left on the interface", "iface", hi.Interface, "vrid", hi.VRID)
+		case inst != nil && hi.Master:
+			if err := inst.router.WaitForState(ctx, vrrp.Master); err != nil {
+				return fmt.Errorf("instance %s did not resume as MASTER: %w", inst.cfg.Key(), err)
+			}
+		}
+	}
+
+	ready := h.take(h.Ready)
+	for _, f := range h.files {
+		_ = f.Close()
+	}
+	d.mu.Lock()
+	d.handover = nil
+	d.mu.Unlock()
+	if ready == nil {
+		return errors.New("no readiness pipe from the previous process")
+	}
+	defer func() { _ = ready.Close() }()
+	if _, err := ready.Write([]byte{1}); err != nil {
+		return fmt.Errorf("failed to notify the previous process: %w", err)
+	}
+
+	slog.Info("Took over from the previous process", "pid", os.Getppid())
+	return nil
+}
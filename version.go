@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+var (
+	versionCmd  = app.Command("version", "Show version information")
+	versionJSON = versionCmd.Flag("json", "Print build information as JSON").Bool()
+)
+
+// Build information, set with -ldflags "-X main.Version=... -X main.Commit=...
+// -X main.BuildDate=...". Commit and BuildDate fall back to the VCS stamp Go
+// embeds when building from a checkout.
+var (
+	Version   = "0.1.0"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// features lists optional capabilities and whether this build has them
+var features = map[string]bool{
+	"ipvs": true,
+	"grpc": grpcBuilt,
+	"ipv6": false,
+	"vmac": false,
+	"bgp":  false,
+	"snmp": false,
+}
+
+type buildInfo struct {
+	Version   string          `json:"version"`
+	Commit    string          `json:"commit"`
+	Modified  bool            `json:"modified"`
+	BuildDate string          `json:"build_date"`
+	GoVersion string          `json:"go_version"`
+	Platform  string          `json:"platform"`
+	Features  map[string]bool `json:"features"`
+}
+
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Features:  features,
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+func showVersion() {
+	info := currentBuildInfo()
+
+	if *versionJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	commit := orDash(info.Commit)
+	if info.Modified {
+		commit += " (modified)"
+	}
+
+	var names []string
+	for name := range info.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var enabled, disabled []string
+	for _, name := range names {
+		if info.Features[name] {
+			enabled = append(enabled, name)
+		} else {
+			disabled = append(disabled, name)
+		}
+	}
+
+	fmt.Printf("vrrp-simple version %s\n", info.Version)
+	fmt.Println("A simple VRRP implementation in Go")
+	fmt.Println("https://github.com/tokuhirom/vrrp-simple")
+	fmt.Println()
+	fmt.Printf("  commit:    %s\n", commit)
+	fmt.Printf("  built:     %s\n", orDash(info.BuildDate))
+	fmt.Printf("  go:        %s %s\n", info.GoVersion, info.Platform)
+	fmt.Printf("  enabled:   %s\n", orDash(strings.Join(enabled, ", ")))
+	fmt.Printf("  disabled:  %s\n", orDash(strings.Join(disabled, ", ")))
+}